@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"enhanced-tcr-udp/internal/bot"
+	"enhanced-tcr-udp/internal/client"
+	"enhanced-tcr-udp/pkg/network"
+	"enhanced-tcr-udp/pkg/tcrclient"
+)
+
+// defaultBotGameTimeout bounds how long the bot subcommand waits for one game, from
+// queueing to its results.
+const defaultBotGameTimeout = 10 * time.Minute
+
+// runBot runs the "bot" subcommand: it logs in and plays games with a computer strategy
+// instead of the UI, printing a line per game, and returns the exit code.
+func runBot(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("bot", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	serverAddr := fs.String("server", client.ServerAddressTCP, "host:port of the server's TCP port")
+	username := fs.String("username", "", "account to play as")
+	password := fs.String("password", "", "password of the account")
+	strategyName := fs.String("strategy", bot.Names()[0], "strategy to play with: "+strings.Join(bot.Names(), ", "))
+	casual := fs.Bool("casual", false, "queue for casual games, which award no EXP")
+	games := fs.Int("games", 1, "how many games to play")
+	seed := fs.Int64("seed", 0, "seed of the strategy's choices; 0 picks a random one")
+	timeout := fs.Duration("timeout", defaultBotGameTimeout, "how long one game may take, from queueing to its results")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	switch {
+	case fs.NArg() > 0:
+		fmt.Fprintf(stderr, "bot: unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		return exitUsage
+	case *username == "" || *password == "":
+		fmt.Fprintln(stderr, "bot: -username and -password are required")
+		return exitUsage
+	case *games <= 0:
+		fmt.Fprintf(stderr, "bot: invalid number of games %d: must be positive\n", *games)
+		return exitUsage
+	case *timeout <= 0:
+		fmt.Fprintf(stderr, "bot: invalid timeout %v: must be positive\n", *timeout)
+		return exitUsage
+	}
+	if _, err := bot.New(*strategyName, bot.Options{}); err != nil {
+		fmt.Fprintf(stderr, "bot: %v\n", err)
+		return exitUsage
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	c, err := tcrclient.Connect(ctx, *serverAddr)
+	if err == nil {
+		_, err = c.Login(ctx, *username, *password)
+	}
+	cancel()
+	if err != nil {
+		fmt.Fprintf(stderr, "bot: %v\n", err)
+		if c != nil {
+			c.Close()
+		}
+		return exitFailure
+	}
+	defer c.Close()
+
+	queue := tcrclient.QueueOptions{QueueType: network.QueueRanked}
+	if *casual {
+		queue.QueueType = network.QueueCasual
+	}
+	for i := 1; i <= *games; i++ {
+		results, err := playBotGame(c, *strategyName, *seed, queue, *timeout)
+		var cancelled *tcrclient.MatchCancelledError
+		if errors.As(err, &cancelled) {
+			fmt.Fprintf(stdout, "Game %d was called off (%s); queueing again.\n", i, cancelled.Reason)
+			i--
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "bot: game %d: %v\n", i, err)
+			return exitFailure
+		}
+		account := c.Account()
+		fmt.Fprintf(stdout, "Game %d: %s (%+d EXP, level %d, %d EXP)\n", i, results.Outcome, results.EXPChange, account.Level, account.EXP)
+	}
+	return exitOK
+}
+
+// playBotGame queues for a game and plays it with a new strategy, returning its results.
+func playBotGame(c *tcrclient.Client, strategyName string, seed int64, queue tcrclient.QueueOptions, timeout time.Duration) (network.GameOverResults, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Updates may arrive before Queue returns the config the strategy is built from.
+	var mu sync.Mutex
+	var strategy bot.Strategy
+	c.OnStateUpdate(func(update network.GameStateUpdateUDP) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strategy == nil {
+			return
+		}
+		for _, action := range strategy.OnState(update) {
+			c.Deploy(ctx, action.Deploy, "")
+		}
+	})
+	defer c.OnStateUpdate(nil)
+
+	match, err := c.Queue(ctx, queue)
+	if err != nil {
+		return network.GameOverResults{}, err
+	}
+	opts := bot.Options{
+		Username:  c.Account().Username,
+		PlayerOne: match.IsPlayerOne,
+		Seed:      seed,
+	}
+	if match.Config != nil {
+		opts.Troops = match.Config.Troops
+		opts.Towers = match.Config.Towers
+	}
+	s, err := bot.New(strategyName, opts)
+	if err != nil {
+		return network.GameOverResults{}, err
+	}
+	mu.Lock()
+	strategy = s
+	mu.Unlock()
+
+	return c.Results(ctx)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"enhanced-tcr-udp/internal/client"
+)
+
+// Exit codes of the check subcommand.
+const (
+	exitOK      = 0
+	exitFailure = 1 // A step of the check failed
+	exitUsage   = 2 // Bad flags or arguments
+)
+
+// defaultCheckTimeout bounds each step of the check subcommand.
+const defaultCheckTimeout = 5 * time.Second
+
+// runCheck runs the "check" subcommand: it checks that the server can be reached over TCP
+// and UDP without logging in, prints a table of the steps, and returns the exit code.
+func runCheck(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	serverAddr := fs.String("server", client.ServerAddressTCP, "host:port of the server's TCP port")
+	timeout := fs.Duration("timeout", defaultCheckTimeout, "how long each step may take")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "check: unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		return exitUsage
+	}
+	if *timeout <= 0 {
+		fmt.Fprintf(stderr, "check: invalid timeout %v: must be positive\n", *timeout)
+		return exitUsage
+	}
+
+	steps := client.RunHealthCheck(*serverAddr, *timeout)
+	fmt.Fprintf(stdout, "Checking %s\n\n", *serverAddr)
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STEP\tRESULT\tLATENCY\tDETAIL")
+	for _, step := range steps {
+		result, latency, detail := "PASS", step.Latency.Round(time.Microsecond).String(), step.Detail
+		if step.Err != nil {
+			result = "FAIL"
+			if detail != "" {
+				detail += ": "
+			}
+			detail += step.Err.Error()
+		}
+		if step.Latency == 0 {
+			latency = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", step.Name, result, latency, detail)
+	}
+	w.Flush()
+
+	if !client.HealthCheckPassed(steps) {
+		fmt.Fprintln(stdout, "\nThe server cannot be fully reached from this machine.")
+		return exitFailure
+	}
+	fmt.Fprintln(stdout, "\nAll checks passed.")
+	return exitOK
+}
@@ -1,35 +1,169 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
-
-	// "os"
+	"os"
+	"strings"
+	"time"
 
 	"enhanced-tcr-udp/internal/client"
-	"enhanced-tcr-udp/internal/models"  // For PlayerAccount type hint
-	"enhanced-tcr-udp/internal/network" // For MatchFoundResponse type hint
+	"enhanced-tcr-udp/pkg/models"  // For PlayerAccount type hint
+	"enhanced-tcr-udp/pkg/network" // For MatchFoundResponse type hint
 
 	"github.com/nsf/termbox-go"
 )
 
+// udpPingTimeout is how long the pre-game connectivity check waits for each of the session's
+// pongs, and udpPingAttempts how many pings it sends before deciding the port is unreachable.
+const (
+	udpPingTimeout  = 3 * time.Second
+	udpPingAttempts = 3
+)
+
+// settingFlags collects repeated -setting key=value flags.
+type settingFlags map[string]string
+
+func (s settingFlags) String() string { return fmt.Sprint(map[string]string(s)) }
+
+func (s settingFlags) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("want key=value, got %q", value)
+	}
+	s[key] = val
+	return nil
+}
+
+// reportCrash writes a crash report for a recovered panic and the stack of the goroutine
+// that panicked, and tells the player where it is. It runs after the UI has been torn
+// down, so stderr is readable again.
+func reportCrash(value interface{}, stack []byte, logs *client.LogRing, capture *network.Capture) {
+	report := client.NewCrashReport(value, stack, logs, capture)
+	path, err := client.WriteCrashReport(os.TempDir(), report)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "The client crashed: %v\nThe crash report could not be written (%v):\n%s", value, err, report.Stack)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "The client crashed: %v\nA crash report was written to %s\nPlease attach it when reporting the problem.\n", value, path)
+}
+
+// offerConfigBrowser lets the player look through the troops and towers before queueing:
+// t opens the browser, any other key goes on to matchmaking. The screen is left showing
+// only the welcome line once the player moves on.
+func offerConfigBrowser(ui *client.TermboxUI, gameClient *client.Client, player *models.PlayerAccount, welcome string) {
+	cfg, err := gameClient.FetchGameConfig()
+	if err != nil {
+		return // Nothing to browse; queue straight away as before
+	}
+	for {
+		ui.DisplayStaticText(1, 3, "Press t to browse Troops & Towers, or any other key to find a match.", termbox.ColorWhite, termbox.ColorBlack)
+		if ev := ui.WaitForKey(); ev.Ch != 't' && ev.Ch != 'T' {
+			break
+		}
+		// Asks again in case the server's config changed; an unchanged one is not resent
+		if latest, err := gameClient.FetchGameConfig(); err == nil {
+			cfg = latest
+		}
+		ui.BrowseGameConfig(cfg, player.Level)
+		ui.ClearScreen()
+		ui.DisplayStaticText(1, 1, welcome, termbox.ColorGreen, termbox.ColorBlack)
+	}
+	ui.ClearScreen()
+	ui.DisplayStaticText(1, 1, welcome, termbox.ColorGreen, termbox.ColorBlack)
+}
+
 func main() {
+	// "check" tests the connection to the server and exits, without starting the UI
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(runCheck(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	// "bot" plays games with a computer strategy instead of the UI
+	if len(os.Args) > 1 && os.Args[1] == "bot" {
+		os.Exit(runBot(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	// Keep the latest log lines in memory for a crash report.
+	logs := client.NewLogRing(client.CrashLogLines)
+	log.SetOutput(io.MultiWriter(os.Stderr, logs))
+
+	var capture *network.Capture
+	// A panic in main or any goroutine of the client ends up here. Deferred first so that
+	// it runs last, after the UI is torn down and the capture closed.
+	client.SetCrashHandler(func(value interface{}, stack []byte) {
+		reportCrash(value, stack, logs, capture)
+		os.Exit(2)
+	})
+	defer client.RecoverCrash()
+
+	configPath := flag.String("config", client.DefaultClientConfigPath, "path to the client config file")
+	printKeys := flag.Bool("print-keys", false, "print the effective key bindings and exit")
+	capturePath := flag.String("capture", "", "append every TCP and UDP message sent or received to this file as JSON lines")
+	recordGames := flag.Bool("record-games", false, "save each game you play to its own file in -replay-dir")
+	replayDir := flag.String("replay-dir", client.DefaultReplayDir, "directory -record-games saves games in")
+	casual := flag.Bool("casual", false, "play in the casual queue: less EXP, and the game does not count towards your win/loss record")
+	duration := flag.Duration("duration", 0, "game length to ask for, e.g. 90s or 3m (0 for any); the server pairs you with players asking for the same when it can")
+	settings := settingFlags{}
+	flag.Var(settings, "setting", "change an account setting after login, as key=value (an empty value clears it); may be repeated")
+	flag.Parse()
+
+	cfg, err := client.LoadClientConfig(*configPath)
+	if err != nil {
+		log.Printf("Warning: %v. Using default client settings.", err)
+	}
+	keymap, err := client.BuildKeymap(cfg.Keys)
+	if err != nil {
+		log.Printf("Warning: invalid key bindings: %v. Using default keys.", err)
+		keymap = client.DefaultKeymap()
+	}
+	if *printKeys {
+		fmt.Print(keymap.Dump())
+		return
+	}
+
 	log.Println("Starting Enhanced TCR Client with Termbox UI...")
 
+	if *capturePath != "" {
+		if capture, err = network.OpenCapture(*capturePath); err != nil {
+			log.Fatalf("Failed to open capture file: %v", err)
+		}
+		defer capture.Close() // Deferred before the UI, so it runs after the UI is torn down
+	}
+
 	ui := client.NewTermboxUI()
-	err := ui.Init()
+	ui.SetAlertConfig(cfg.Alerts)
+	ui.SetKeymap(keymap)
+	err = ui.Init()
 	if err != nil {
 		log.Fatalf("Failed to initialize termbox: %v", err)
 		// Fallback to console if termbox fails? For now, just exit.
 		return
 	}
+	// While the UI owns the terminal, log lines only go to the ring; on stderr they would
+	// scribble over the screen. Deferred before the UI, so stderr is back once it is torn down.
+	log.SetOutput(logs)
+	defer log.SetOutput(io.MultiWriter(os.Stderr, logs))
 	defer ui.Close()
 
 	ui.ClearScreen()
 	ui.DisplayStaticText(1, 1, "Welcome to Enhanced TCR Client!", termbox.ColorCyan, termbox.ColorBlack)
 
 	gameClient := client.NewClient(ui) // Pass UI to client
-	// defer gameClient.CloseConnections() // Ensure connections are closed on exit -- We will call this manually now
+	gameClient.SetCapture(capture)
+	gameClient.SetResendConfig(cfg.Resend)
+	if *recordGames {
+		gameClient.SetGameRecorder(client.NewGameRecorder(*replayDir, func(message string) {
+			ui.AddEventMessage(client.LogError, message)
+		}))
+	}
+	if *casual {
+		gameClient.QueueType = network.QueueCasual
+	}
+	gameClient.PreferredDuration = *duration
+	// defer gameClient.CloseConnections() // Ensure connections are closed on exit -- main calls gameClient.Shutdown instead
 
 	var player *models.PlayerAccount
 	player, err = gameClient.AuthenticateWithUI() // Modified to use UI
@@ -40,12 +174,50 @@ func main() {
 		return
 	}
 
-	ui.ClearScreen()
-	ui.DisplayStaticText(1, 1, fmt.Sprintf("Welcome, %s (Level %d, EXP %d)!", player.Username, player.Level, player.EXP), termbox.ColorGreen, termbox.ColorBlack)
-	ui.DisplayStaticText(1, 3, "Login successful. Requesting matchmaking...", termbox.ColorWhite, termbox.ColorBlack)
+	// Show results of earlier games that ended while we were disconnected.
+	for _, results := range gameClient.PendingResults {
+		ui.SetGameOverDetails(results)
+		ui.SetCurrentView(client.ViewGameOver)
+		ui.Render()
+		ui.DisplayStaticText(1, 0, "Results from a game that ended while you were away:", termbox.ColorCyan, termbox.ColorBlack)
+		ui.WaitForKeyPress()
+	}
+	ui.SetCurrentView(client.ViewGame)
 
-	var matchInfo *network.MatchFoundResponse              // Use the type from network package
-	matchInfo, err = gameClient.RequestMatchmakingWithUI() // Modified to use UI for status updates
+	ui.ClearScreen()
+	welcome := fmt.Sprintf("Welcome, %s (Level %d, EXP %d)!", player.Username, player.Level, player.EXP)
+	if totals := client.ProfileTotals(player); totals != "" {
+		welcome += " " + totals + "."
+	}
+	ui.DisplayStaticText(1, 1, welcome, termbox.ColorGreen, termbox.ColorBlack)
+	if len(settings) > 0 {
+		if current, err := gameClient.UpdateSettings(settings); err != nil {
+			ui.DisplayStaticText(1, 2, fmt.Sprintf("Settings not changed: %v", err), termbox.ColorRed, termbox.ColorBlack)
+		} else {
+			ui.DisplayStaticText(1, 2, fmt.Sprintf("Settings saved: %v", current), termbox.ColorGreen, termbox.ColorBlack)
+		}
+	}
+	if gameClient.ActiveGameID == "" && gameClient.ProtocolVersion >= network.ProtocolVersionGameConfigRequest {
+		offerConfigBrowser(ui, gameClient, player, welcome)
+	}
+	var matchInfo *network.MatchFoundResponse // Use the type from network package
+	if gameClient.ActiveGameID != "" && gameClient.ProtocolVersion >= network.ProtocolVersionReconnect {
+		// Our last client left a game running; take its place rather than queueing again
+		ui.DisplayStaticText(1, 3, "Login successful. You are still in a running game.", termbox.ColorWhite, termbox.ColorBlack)
+		if matchInfo, err = gameClient.ReconnectWithUI(); err != nil && matchInfo == nil {
+			ui.DisplayStaticText(1, 4, fmt.Sprintf("Could not rejoin (%v). Requesting matchmaking...", err), termbox.ColorYellow, termbox.ColorBlack)
+		}
+	} else {
+		ui.DisplayStaticText(1, 3, "Login successful. Requesting matchmaking...", termbox.ColorWhite, termbox.ColorBlack)
+	}
+	if matchInfo == nil {
+		matchInfo, err = gameClient.RequestMatchmakingWithUI() // Modified to use UI for status updates
+	}
+	if errors.Is(err, client.ErrAlreadyInGame) && gameClient.ProtocolVersion >= network.ProtocolVersionReconnect {
+		// Our game started after we logged in, e.g. from another client; take it over
+		ui.DisplayStaticText(1, 4, "You are already in a running game. Rejoining it...", termbox.ColorYellow, termbox.ColorBlack)
+		matchInfo, err = gameClient.ReconnectWithUI()
+	}
 	if err != nil {
 		ui.DisplayStaticText(1, 5, fmt.Sprintf("Matchmaking failed: %v", err), termbox.ColorRed, termbox.ColorBlack)
 		ui.DisplayStaticText(1, 7, "Press ESC to exit.", termbox.ColorWhite, termbox.ColorBlack)
@@ -53,55 +225,66 @@ func main() {
 		return
 	}
 
+	ui.ShowVersusSplash() // Let the player register who they're fighting before the board appears
+
 	ui.ClearScreen()
-	ui.DisplayStaticText(1, 1, "Match Found!", termbox.ColorGreen, termbox.ColorBlack)
+	if matchInfo.QueueType == network.QueueCasual {
+		ui.DisplayStaticText(1, 1, "Casual Match Found! (does not count towards your record)", termbox.ColorGreen, termbox.ColorBlack)
+	} else {
+		ui.DisplayStaticText(1, 1, "Match Found!", termbox.ColorGreen, termbox.ColorBlack)
+	}
+	if handicaps := client.HandicapSummary(matchInfo.Handicaps); handicaps != "" {
+		ui.DisplayStaticText(1, 2, "Handicaps: "+handicaps, termbox.ColorYellow, termbox.ColorBlack)
+	}
 	ui.DisplayStaticText(1, 3, fmt.Sprintf("Game ID: %s", matchInfo.GameID), termbox.ColorWhite, termbox.ColorBlack)
-	ui.DisplayStaticText(1, 4, fmt.Sprintf("Opponent: %s (Level %d)", matchInfo.Opponent.Username, matchInfo.Opponent.Level), termbox.ColorWhite, termbox.ColorBlack)
+	ui.DisplayStaticText(1, 4, fmt.Sprintf("Opponent: %s (Level %d, %dW/%dL)", matchInfo.Opponent.Username, matchInfo.Opponent.Level, matchInfo.Opponent.Wins, matchInfo.Opponent.Losses), termbox.ColorWhite, termbox.ColorBlack)
 	ui.DisplayStaticText(1, 5, fmt.Sprintf("UDP Port for Game: %d", matchInfo.UDPPort), termbox.ColorWhite, termbox.ColorBlack)
 	ui.DisplayStaticText(1, 6, fmt.Sprintf("You are PlayerOne: %t", matchInfo.IsPlayerOne), termbox.ColorWhite, termbox.ColorBlack)
+	if matchInfo.GameDurationSeconds > 0 {
+		ui.DisplayStaticText(1, 7, fmt.Sprintf("Game length: %v", time.Duration(matchInfo.GameDurationSeconds)*time.Second), termbox.ColorWhite, termbox.ColorBlack)
+	}
 
-	ui.DisplayStaticText(1, 8, "Attempting to send a UDP ping to global echo server (localhost:8081)...", termbox.ColorYellow, termbox.ColorBlack)
+	ui.DisplayStaticText(1, 8, fmt.Sprintf("Checking UDP connectivity to the game session (port %d)...", matchInfo.UDPPort), termbox.ColorYellow, termbox.ColorBlack)
 	termbox.Flush() // Ensure message is displayed before potential blocking call
 
-	// Use a placeholder gameID and token for this global ping, or use actual if available
-	// For global echo, gameID/token might not be strictly checked by the echo server.
-	pingGameID := "global_ping_test"
-	if matchInfo != nil && matchInfo.GameID != "" {
-		pingGameID = matchInfo.GameID // Use actual game ID if we have one
-	}
-	pingPlayerToken := "test_client"
-	if player != nil {
-		pingPlayerToken = player.Username
-	}
-
-	udpResponse, udpErr := gameClient.SendBasicUDPMessage(pingGameID, pingPlayerToken, 8081, "Hello UDP Echo Server!")
-	if udpErr != nil {
+	// The ping goes over the game socket itself, so a pong proves the path the game will use
+	rtt, udpErr := gameClient.ProbeUDP(udpPingAttempts, udpPingTimeout)
+	var unreachable *client.UDPUnreachableError
+	switch {
+	case errors.As(udpErr, &unreachable):
+		// The game can never start; tell the server so the opponent is not left waiting in it
+		ui.DisplayStaticText(1, 9, fmt.Sprintf("%s (no answer to %d pings).", unreachable, unreachable.Attempts), termbox.ColorRed, termbox.ColorBlack)
+		if reported, err := gameClient.ReportUDPUnreachable(unreachable); err != nil {
+			ui.DisplayStaticText(1, 10, fmt.Sprintf("Could not tell the server: %v", err), termbox.ColorRed, termbox.ColorBlack)
+		} else if reported {
+			ui.DisplayStaticText(1, 10, "The server has been told and is cancelling the match for both players.", termbox.ColorYellow, termbox.ColorBlack)
+		} else {
+			ui.DisplayStaticText(1, 10, "This server cannot be told; the match will not start.", termbox.ColorYellow, termbox.ColorBlack)
+		}
+	case udpErr != nil:
 		ui.DisplayStaticText(1, 9, fmt.Sprintf("UDP Ping failed: %v", udpErr), termbox.ColorRed, termbox.ColorBlack)
-	} else {
-		ui.DisplayStaticText(1, 9, fmt.Sprintf("UDP Ping successful! Response: %s", udpResponse), termbox.ColorGreen, termbox.ColorBlack)
+	default:
+		ui.DisplayStaticText(1, 9, fmt.Sprintf("UDP Ping successful! Round trip: %v", rtt.Round(time.Millisecond)), termbox.ColorGreen, termbox.ColorBlack)
 	}
 
 	ui.DisplayStaticText(1, 11, "Client is ready for game-specific UDP gameplay. Press ESC to exit this screen.", termbox.ColorYellow, termbox.ColorBlack)
 	quitRequested := ui.RunSimpleEvacuateLoop()
 
 	log.Println("Termbox loop exited.")
+	if drops := gameClient.InboundUDPDrops(); drops.Total() > 0 {
+		log.Printf("Dropped inbound UDP datagrams: %d malformed, %d oversize, %d of unknown type, %d for another session or player.",
+			drops.ParseErrors, drops.Oversize, drops.UnknownType, drops.BadSession)
+	}
 
+	// Tell the server we quit (if we did), then stop the game goroutines and close the connections.
 	if quitRequested {
 		log.Println("Quit was requested. Sending PlayerQuitUDP message...")
-		if err := gameClient.SendPlayerQuitMessage(); err != nil {
-			log.Printf("Error sending player quit message from main: %v", err)
-		}
-		// Optionally, add a small delay here if issues persist, e.g., time.Sleep(100 * time.Millisecond)
-		// This gives the UDP packet a moment to be processed by the OS network stack before connections are closed.
 	}
-
-	// Connections are closed by defer gameClient.CloseConnections() when main exits.
+	if err := gameClient.Shutdown(quitRequested); err != nil {
+		log.Printf("Error sending player quit message from main: %v", err)
+	}
 
 	log.Println("Exiting client application.")
-
-	// Explicitly close connections after everything, including sending quit message.
-	log.Println("Closing client connections manually...")
-	gameClient.CloseConnections()
 }
 
 /*
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+
+	"enhanced-tcr-udp/internal/persistence"
+	"enhanced-tcr-udp/pkg/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Exit codes of the account subcommands.
+const (
+	exitOK      = 0
+	exitFailure = 1 // The operation failed, e.g. the account does not exist
+	exitUsage   = 2 // Bad flags or arguments
+	exitLocked  = 3 // A server is running against the data root
+)
+
+// generatedPasswordLength is the length of the passwords reset-password makes up.
+const generatedPasswordLength = 12
+
+// passwordAlphabet leaves out characters that are easily misread, such as 0/O and 1/l.
+const passwordAlphabet = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// accountCommands are the subcommands that manage player accounts directly in the data
+// root, without a running server. Each returns the process exit code.
+var accountCommands = map[string]func(args []string, stdout, stderr io.Writer) int{
+	"create-account": runCreateAccount,
+	"reset-password": runResetPassword,
+	"show-player":    runShowPlayer,
+}
+
+// prepareAccountCommand parses a subcommand's flags, adding -data-root, points the
+// persistence layer at that data root and locks it, so that no server starts writing the
+// same account files meanwhile. It refuses to go on while a server holds the lock. It
+// returns the lock for the caller to release, or nil and the exit code if the command
+// should not run.
+func prepareAccountCommand(fs *flag.FlagSet, args []string, stderr io.Writer) (*persistence.ServerLock, int) {
+	dataRoot := fs.String("data-root", persistence.DefaultDataRoot, "directory holding the server's data")
+	fs.SetOutput(stderr)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, exitOK
+		}
+		return nil, exitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "%s: unexpected arguments: %s\n", fs.Name(), strings.Join(fs.Args(), " "))
+		return nil, exitUsage
+	}
+	persistence.SetDataRoot(*dataRoot)
+
+	lock, err := persistence.AcquireServerLock()
+	if errors.Is(err, persistence.ErrServerLocked) {
+		fmt.Fprintf(stderr, "%s: a server is using %s (%v); stop it first\n", fs.Name(), persistence.DataRoot(), err)
+		return nil, exitLocked
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: locking %s: %v\n", fs.Name(), persistence.DataRoot(), err)
+		return nil, exitFailure
+	}
+	if lock.Reclaimed != 0 {
+		fmt.Fprintf(stderr, "%s: warning: reclaimed the lock on %s from process %d, which is no longer running\n", fs.Name(), persistence.DataRoot(), lock.Reclaimed)
+	}
+	return lock, exitOK
+}
+
+// checkUsername rejects usernames that cannot name an account file.
+func checkUsername(username string) error {
+	switch {
+	case username == "":
+		return errors.New("-user is required")
+	case username == "." || username == ".." || strings.ContainsAny(username, `/\`):
+		return fmt.Errorf("invalid username %q", username)
+	}
+	return nil
+}
+
+// runCreateAccount implements "create-account -user X -password Y [-level N]".
+func runCreateAccount(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("create-account", flag.ContinueOnError)
+	username := fs.String("user", "", "username of the new account")
+	password := fs.String("password", "", "password of the new account")
+	level := fs.Int("level", 1, "starting level")
+	lock, code := prepareAccountCommand(fs, args, stderr)
+	if lock == nil {
+		return code
+	}
+	defer lock.Release()
+	if err := checkUsername(*username); err != nil {
+		fmt.Fprintf(stderr, "create-account: %v\n", err)
+		return exitUsage
+	}
+	if *password == "" {
+		fmt.Fprintln(stderr, "create-account: -password is required")
+		return exitUsage
+	}
+	if *level < 1 {
+		fmt.Fprintf(stderr, "create-account: invalid level %d: must be at least 1\n", *level)
+		return exitUsage
+	}
+
+	if exists, err := persistence.PlayerExists(*username); err != nil {
+		fmt.Fprintf(stderr, "create-account: checking for account %q: %v\n", *username, err)
+		return exitFailure
+	} else if exists {
+		fmt.Fprintf(stderr, "create-account: account %q already exists\n", *username)
+		return exitFailure
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(*password), bcrypt.DefaultCost)
+	if err != nil {
+		fmt.Fprintf(stderr, "create-account: hashing password: %v\n", err)
+		return exitFailure
+	}
+	acc := &models.PlayerAccount{Username: *username, HashedPassword: string(hash), Level: *level}
+	if err := persistence.CreatePlayerAccount(acc); os.IsExist(err) {
+		fmt.Fprintf(stderr, "create-account: account %q already exists\n", *username)
+		return exitFailure
+	} else if err != nil {
+		fmt.Fprintf(stderr, "create-account: saving account %q: %v\n", *username, err)
+		return exitFailure
+	}
+	fmt.Fprintf(stdout, "Created account %s (level %d).\n", acc.Username, acc.Level)
+	return exitOK
+}
+
+// runResetPassword implements "reset-password -user X [-password Y]". Without -password a
+// new password is generated and printed.
+func runResetPassword(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("reset-password", flag.ContinueOnError)
+	username := fs.String("user", "", "username of the account")
+	password := fs.String("password", "", "new password (default: generate one and print it)")
+	lock, code := prepareAccountCommand(fs, args, stderr)
+	if lock == nil {
+		return code
+	}
+	defer lock.Release()
+	if err := checkUsername(*username); err != nil {
+		fmt.Fprintf(stderr, "reset-password: %v\n", err)
+		return exitUsage
+	}
+
+	acc, err := persistence.LoadPlayerAccount(*username)
+	if err != nil {
+		fmt.Fprintf(stderr, "reset-password: loading account %q: %v\n", *username, err)
+		return exitFailure
+	}
+	newPassword, generated := *password, false
+	if newPassword == "" {
+		if newPassword, err = generatePassword(generatedPasswordLength); err != nil {
+			fmt.Fprintf(stderr, "reset-password: generating password: %v\n", err)
+			return exitFailure
+		}
+		generated = true
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		fmt.Fprintf(stderr, "reset-password: hashing password: %v\n", err)
+		return exitFailure
+	}
+	acc.HashedPassword = string(hash)
+	if err := persistence.SavePlayerAccount(acc); err != nil {
+		fmt.Fprintf(stderr, "reset-password: saving account %q: %v\n", *username, err)
+		return exitFailure
+	}
+	if generated {
+		fmt.Fprintf(stdout, "New password for %s: %s\n", acc.Username, newPassword)
+	} else {
+		fmt.Fprintf(stdout, "Password for %s reset.\n", acc.Username)
+	}
+	return exitOK
+}
+
+// runShowPlayer implements "show-player -user X": the account as JSON, without the
+// password hash.
+func runShowPlayer(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("show-player", flag.ContinueOnError)
+	username := fs.String("user", "", "username of the account")
+	lock, code := prepareAccountCommand(fs, args, stderr)
+	if lock == nil {
+		return code
+	}
+	defer lock.Release()
+	if err := checkUsername(*username); err != nil {
+		fmt.Fprintf(stderr, "show-player: %v\n", err)
+		return exitUsage
+	}
+
+	acc, err := persistence.LoadPlayerAccount(*username)
+	if err != nil {
+		fmt.Fprintf(stderr, "show-player: loading account %q: %v\n", *username, err)
+		return exitFailure
+	}
+	data, err := json.MarshalIndent(acc, "", "  ") // PlayerAccount never serializes its hash
+	if err != nil {
+		fmt.Fprintf(stderr, "show-player: %v\n", err)
+		return exitFailure
+	}
+	fmt.Fprintln(stdout, string(data))
+	return exitOK
+}
+
+// generatePassword returns a random password of n characters from passwordAlphabet.
+func generatePassword(n int) (string, error) {
+	var b strings.Builder
+	max := big.NewInt(int64(len(passwordAlphabet)))
+	for i := 0; i < n; i++ {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(passwordAlphabet[idx.Int64()])
+	}
+	return b.String(), nil
+}
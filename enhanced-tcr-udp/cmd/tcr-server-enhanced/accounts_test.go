@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"enhanced-tcr-udp/internal/persistence"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// runAccountCommand runs an account subcommand against dataRoot and returns its exit code
+// and output.
+func runAccountCommand(dataRoot, name string, args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = accountCommands[name](append([]string{"-data-root", dataRoot}, args...), &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+// checkPassword fails the test unless the stored account of username has password's hash.
+func checkPassword(t *testing.T, username, password string) {
+	t.Helper()
+	acc, err := persistence.LoadPlayerAccount(username)
+	if err != nil {
+		t.Fatalf("loading %s: %v", username, err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(acc.HashedPassword), []byte(password)); err != nil {
+		t.Errorf("%s's stored hash does not match %q: %v", username, password, err)
+	}
+}
+
+// The account subcommands create, reset and show accounts in the data root they are given,
+// exit with a code that says why they failed, and never print a password hash.
+func TestAccountCommands(t *testing.T) {
+	dataRoot := t.TempDir()
+	t.Cleanup(func() { persistence.SetDataRoot("") })
+
+	code, stdout, stderr := runAccountCommand(dataRoot, "create-account", "-user", "alice", "-password", "first", "-level", "3")
+	if code != exitOK || stdout != "Created account alice (level 3).\n" {
+		t.Fatalf("create-account: exit %d, %q %q", code, stdout, stderr)
+	}
+	checkPassword(t, "alice", "first")
+
+	for _, tt := range []struct {
+		name     string
+		args     []string
+		wantCode int
+		wantErr  string
+	}{
+		{"create-account", []string{"-user", "alice", "-password", "again"}, exitFailure, `account "alice" already exists`},
+		{"create-account", []string{"-user", "bob"}, exitUsage, "-password is required"},
+		{"create-account", []string{"-user", "../bob", "-password", "x"}, exitUsage, "invalid username"},
+		{"create-account", []string{"-user", "bob", "-password", "x", "-level", "0"}, exitUsage, "invalid level 0"},
+		{"create-account", []string{"-user", "bob", "-password", "x", "extra"}, exitUsage, "unexpected arguments: extra"},
+		{"reset-password", []string{"-user", "nobody"}, exitFailure, `loading account "nobody"`},
+		{"show-player", []string{}, exitUsage, "-user is required"},
+		{"show-player", []string{"-user", "nobody"}, exitFailure, `loading account "nobody"`},
+	} {
+		code, _, stderr := runAccountCommand(dataRoot, tt.name, tt.args...)
+		if code != tt.wantCode || !strings.Contains(stderr, tt.wantErr) {
+			t.Errorf("%s %v: exit %d, %q; want exit %d mentioning %s", tt.name, tt.args, code, stderr, tt.wantCode, tt.wantErr)
+		}
+	}
+	checkPassword(t, "alice", "first") // The failed create left the account alone
+
+	if code, stdout, stderr := runAccountCommand(dataRoot, "reset-password", "-user", "alice", "-password", "second"); code != exitOK || stdout != "Password for alice reset.\n" {
+		t.Errorf("reset-password: exit %d, %q %q", code, stdout, stderr)
+	}
+	checkPassword(t, "alice", "second")
+
+	code, stdout, stderr = runAccountCommand(dataRoot, "reset-password", "-user", "alice")
+	generated, ok := strings.CutPrefix(strings.TrimSpace(stdout), "New password for alice: ")
+	if code != exitOK || !ok || len(generated) != generatedPasswordLength || strings.Trim(generated, passwordAlphabet) != "" {
+		t.Fatalf("reset-password with no -password: exit %d, %q %q; want a generated password", code, stdout, stderr)
+	}
+	checkPassword(t, "alice", generated)
+
+	code, stdout, stderr = runAccountCommand(dataRoot, "show-player", "-user", "alice")
+	if code != exitOK {
+		t.Fatalf("show-player: exit %d, %q", code, stderr)
+	}
+	var shown map[string]interface{}
+	if err := json.Unmarshal([]byte(stdout), &shown); err != nil {
+		t.Fatalf("show-player printed %q: %v", stdout, err)
+	}
+	if shown["username"] != "alice" || shown["level"] != float64(3) {
+		t.Errorf("show-player printed %s, want alice at level 3", stdout)
+	}
+	acc, _ := persistence.LoadPlayerAccount("alice")
+	if strings.Contains(stdout, "password") || strings.Contains(stdout, acc.HashedPassword) {
+		t.Errorf("show-player printed the password hash: %s", stdout)
+	}
+}
+
+// While a server holds the data root, the account subcommands refuse to touch it.
+func TestAccountCommandsRefuseLockedDataRoot(t *testing.T) {
+	dataRoot := t.TempDir()
+	t.Cleanup(func() { persistence.SetDataRoot("") })
+	persistence.SetDataRoot(dataRoot)
+	lock, err := persistence.AcquireServerLock()
+	if err != nil {
+		t.Fatalf("locking %s: %v", dataRoot, err)
+	}
+	defer lock.Release()
+
+	code, _, stderr := runAccountCommand(dataRoot, "create-account", "-user", "alice", "-password", "pw")
+	if code != exitLocked || !strings.Contains(stderr, "stop it first") {
+		t.Errorf("create-account on a locked data root: exit %d, %q; want exit %d", code, stderr, exitLocked)
+	}
+	if exists, err := persistence.PlayerExists("alice"); err != nil || exists {
+		t.Errorf("alice exists: %t (%v), want no account created", exists, err)
+	}
+}
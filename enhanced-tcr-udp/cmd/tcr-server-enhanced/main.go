@@ -1,43 +1,167 @@
 package main
 
 import (
+	"enhanced-tcr-udp/internal/persistence"
 	"enhanced-tcr-udp/internal/server"
+	"enhanced-tcr-udp/pkg/models"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 )
 
 func main() {
+	// The account subcommands run instead of the server; "run", or no subcommand, starts it
+	if len(os.Args) > 1 {
+		if command, ok := accountCommands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:], os.Stdout, os.Stderr))
+		}
+		if os.Args[1] == "run" {
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		}
+	}
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s [run] [flags]\n", os.Args[0])
+		fmt.Fprintf(out, "       %s create-account -user X -password Y [-level N] [-data-root DIR]\n", os.Args[0])
+		fmt.Fprintf(out, "       %s reset-password -user X [-password Y] [-data-root DIR]\n", os.Args[0])
+		fmt.Fprintf(out, "       %s show-player -user X [-data-root DIR]\n", os.Args[0])
+		fmt.Fprintln(out, "Flags for run:")
+		flag.PrintDefaults()
+	}
+
+	defaults := server.DefaultNetworkConfig()
+	tcpListen := flag.String("tcp-listen", defaults.TCPListen, "host:port for the TCP control connection")
+	udpListenHost := flag.String("udp-listen-host", defaults.UDPListenHost, "host game UDP sockets bind to (empty for all interfaces)")
+	udpPortRange := flag.String("udp-port-range", fmt.Sprintf("%d-%d", defaults.UDPPortMin, defaults.UDPPortMax), "UDP ports handed to game sessions, as min-max")
+	advertiseHost := flag.String("advertise-host", "", "host clients send game UDP to, for servers behind NAT (default: derived from the listen addresses)")
+	tcpWriteTimeout := flag.Duration("tcp-write-timeout", defaults.TCPWriteTimeout, "give up on a TCP message to a client that is not reading after this long")
+	tickInterval := flag.Duration("tick-interval", models.DefaultGameRules().TickInterval, "how often game sessions advance the simulation")
+	dayTimezone := flag.String("day-timezone", "Local", "IANA time zone whose calendar days the first-win-of-the-day bonus follows, e.g. Asia/Ho_Chi_Minh")
+	twoLanes := flag.Bool("two-lanes", false, "play games on a left and a right lane, each guarded by its own Guard Tower")
+	hpTiebreak := flag.Bool("hp-tiebreak", false, "at timeout, break a tie in towers destroyed by the share of tower HP each player has left")
+	forfeitOnCheating := flag.Bool("forfeit-on-cheating", false, "make a player forfeit once enough of their commands fail the plausibility checks")
+	sessionLogs := flag.Bool("session-logs", false, "also write each game session's log to data/session_logs/<gameID>.log")
+	captureSessions := flag.Bool("capture-sessions", false, "record each game session's UDP messages to data/session_logs/<gameID>.capture.jsonl")
+	exportAnalytics := flag.Bool("analytics", false, "append an anonymized record of each game to data/analytics/ for balance analysis")
+	sessionLogRetention := flag.Duration("session-log-retention", 7*24*time.Hour, "remove session logs older than this (0 keeps them)")
+	udpCheckPort := flag.Int("udp-check-port", server.DefaultUDPCheckPort, "UDP port, on the game UDP host, that answers client connectivity checks")
+	udpEcho := flag.String("udp-echo", server.DefaultUDPEchoAddress, "host:port of the UDP echo server for basic UDP tests (empty to disable)")
+	dataRoot := flag.String("data-root", persistence.DefaultDataRoot, "directory holding player accounts, match history and session logs")
+	flag.Parse()
+
 	log.Println("Starting Enhanced TCR Server...")
 
+	// Only one process at a time may write the data root: another server, or an account
+	// subcommand, would overwrite the account files this one writes
+	persistence.SetDataRoot(*dataRoot)
+	dataLock, err := persistence.AcquireServerLock()
+	if errors.Is(err, persistence.ErrServerLocked) {
+		log.Fatalf("Another server is using data root %s (%v). Stop it, or pass a different -data-root.", persistence.DataRoot(), err)
+	} else if err != nil {
+		log.Fatalf("Could not lock data root %s: %v", persistence.DataRoot(), err)
+	}
+	if dataLock.Reclaimed != 0 {
+		log.Printf("Warning: reclaimed the lock on %s from process %d, which is no longer running (it did not shut down cleanly).", persistence.DataRoot(), dataLock.Reclaimed)
+	}
+	if indexed, skipped, err := persistence.RebuildPlayerIndex(); err != nil {
+		log.Printf("Warning: could not index player accounts: %v. The index will be built on first use.", err)
+	} else if skipped > 0 {
+		log.Printf("Indexed %d player accounts; skipped %d unreadable account files.", indexed, skipped)
+	} else {
+		log.Printf("Indexed %d player accounts.", indexed)
+	}
+
+	netCfg := server.NetworkConfig{
+		TCPListen:     *tcpListen,
+		UDPListenHost: *udpListenHost,
+		AdvertiseHost: *advertiseHost,
+
+		TCPWriteTimeout: *tcpWriteTimeout,
+	}
+	if netCfg.UDPPortMin, netCfg.UDPPortMax, err = server.ParsePortRange(*udpPortRange); err != nil {
+		log.Fatalf("Invalid network configuration: %v", err)
+	}
+	if err := server.SetNetworkConfig(netCfg); err != nil {
+		log.Fatalf("Invalid network configuration: %v", err)
+	}
+	log.Printf("TCP control on %s; game UDP on host %q ports %d-%d; advertising UDP host %q.",
+		netCfg.TCPListen, netCfg.UDPListenHost, netCfg.UDPPortMin, netCfg.UDPPortMax, netCfg.EffectiveAdvertiseHost())
+
+	if *udpCheckPort < 0 || *udpCheckPort > 65535 {
+		log.Fatalf("Invalid UDP check port %d: must be within 0-65535", *udpCheckPort)
+	}
+	if *tickInterval <= 0 {
+		log.Fatalf("Invalid tick interval %v: must be positive", *tickInterval)
+	}
+	rules := models.DefaultGameRules()
+	rules.TickInterval = *tickInterval
+	rules.ForfeitOnCheating = *forfeitOnCheating
+	rules.TwoLanes = *twoLanes
+	rules.TimeoutHPTiebreak = *hpTiebreak
+	if rules.DayLocation, err = time.LoadLocation(*dayTimezone); err != nil {
+		log.Fatalf("Invalid day time zone %q: %v", *dayTimezone, err)
+	}
+	server.GlobalSessionManager.SetRules(rules)
+	if *sessionLogRetention < 0 {
+		log.Fatalf("Invalid session log retention %v: must not be negative", *sessionLogRetention)
+	}
+	server.GlobalSessionManager.SetSessionLogging(*sessionLogs, *sessionLogRetention)
+	server.GlobalSessionManager.SetSessionCapture(*captureSessions)
+	server.GlobalSessionManager.SetAnalyticsExport(*exportAnalytics)
+
 	// Initialize the main server
-	srv := server.NewServer("localhost:8080") // Use default or configure via env/args
+	srv := server.NewServer(netCfg.TCPListen)
+
+	// Clients check they can reach us over UDP on their own port, next to the game sessions'
+	srv.SetUDPCheckResponder(server.NewUDPCheckResponder(net.JoinHostPort(netCfg.UDPListenHost, strconv.Itoa(*udpCheckPort))))
 
-	// Start the global UDP echo server (optional, for basic UDP tests)
-	// This runs on a different port than game-specific UDP.
-	go server.StartGlobalUDPEchoServer("localhost:8008")
+	// The UDP echo server (for basic UDP tests) runs on a different port than game-specific UDP
+	if *udpEcho != "" {
+		srv.AddAuxiliary(server.NewUDPEchoServer(*udpEcho))
+	}
+
+	// Bind everything up front, so a port that is in use stops the server before it claims to run
+	if err := srv.Listen(); err != nil {
+		dataLock.Release()
+		log.Fatalf("Server failed to start: %v", err)
+	}
 
 	// Channel to listen for OS signals for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	// Goroutine to start the main TCP server
+	// Goroutine to run the main TCP server. Serve only returns before Stop if a listener failed.
+	serveErr := make(chan error, 1)
 	go func() {
-		if err := srv.Start(); err != nil {
-			log.Fatalf("Server failed to start: %v", err)
-			// If server Start exits, main might exit too, or we can handle restart logic.
-			// For now, fatal error will stop the program.
-		}
+		serveErr <- srv.Serve()
 	}()
 
-	log.Println("Server is running. Press Ctrl+C to exit.")
+	// Operator commands (e.g. "list-sessions") are read from stdin.
+	go server.NewAdminConsole(server.GlobalSessionManager).Run(os.Stdin, os.Stdout)
+
+	log.Println("Server is running. Type 'help' for admin commands. Press Ctrl+C to exit.")
 
-	// Wait for a signal
-	<-sigChan
+	// Wait for a signal, or for the server to fail
+	select {
+	case <-sigChan:
+	case err := <-serveErr:
+		srv.Stop()
+		dataLock.Release()
+		log.Fatalf("Server failed: %v", err)
+	}
 
 	// Signal received, initiate graceful shutdown
 	log.Println("Shutdown signal received, stopping server...")
 	srv.Stop()
+	if err := dataLock.Release(); err != nil {
+		log.Printf("Could not remove server lock %s: %v", persistence.ServerLockPath(), err)
+	}
 	log.Println("Server stopped gracefully.")
 }
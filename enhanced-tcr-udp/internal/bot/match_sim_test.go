@@ -0,0 +1,257 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"enhanced-tcr-udp/internal/game"
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+)
+
+const (
+	simTick           = 500 * time.Millisecond // The default rules' TickInterval
+	simAttackInterval = 2 * time.Second        // As the server's attackInterval
+	simQueenHeal      = 300                    // As the server's Queen
+)
+
+// simMatch plays a game between two strategies with the server's combat rules on a
+// simulated clock: mana regeneration, troops attacking towers and towers attacking troops
+// every simAttackInterval, the Queen's heal, and DefaultWinConditions at the end. The
+// strategies see a state update after every tick and their deploys are handled at once.
+type simMatch struct {
+	rules      models.GameRules
+	session    *models.GameSession
+	strategies map[string]Strategy // By username
+	now, end   time.Time
+	lastRegen  map[string]time.Time
+	lastAttack map[string]time.Time // Troop instance ID or tower game-specific ID -> last attack
+	troops     int                  // Troops deployed so far, for instance IDs
+}
+
+func newSimMatch(cfg models.GameConfig, p1, p2 Strategy) *simMatch {
+	rules := models.DefaultGameRules()
+	start := time.Unix(0, 0)
+	m := &simMatch{
+		rules:      rules,
+		session:    &models.GameSession{GameConfig: &cfg},
+		strategies: map[string]Strategy{"p1": p1, "p2": p2},
+		now:        start,
+		end:        start.Add(rules.GameDuration),
+		lastRegen:  map[string]time.Time{"p1": start, "p2": start},
+		lastAttack: map[string]time.Time{},
+	}
+	towerIDs := make([]string, 0, len(cfg.Towers))
+	for id := range cfg.Towers {
+		towerIDs = append(towerIDs, id)
+	}
+	sort.Strings(towerIDs)
+	players := make([]*models.PlayerInGame, 2)
+	for i, username := range []string{"p1", "p2"} {
+		player := &models.PlayerInGame{
+			Account:        models.PlayerAccount{Username: username, Level: 1},
+			CurrentMana:    rules.StartingMana,
+			DeployedTroops: map[string]*models.ActiveTroop{},
+		}
+		for _, id := range towerIDs {
+			spec := cfg.Towers[id]
+			tower := &models.TowerInstance{
+				SpecID: id, OwnerID: username, GameSpecificID: username + "_" + id,
+				CurrentHP: spec.BaseHP, MaxHP: spec.BaseHP, CurrentATK: spec.BaseATK, CurrentDEF: spec.BaseDEF,
+			}
+			player.Towers = append(player.Towers, tower)
+			m.lastAttack[tower.GameSpecificID] = start
+		}
+		players[i] = player
+	}
+	m.session.Player1, m.session.Player2 = players[0], players[1]
+	return m
+}
+
+// play runs the game to its end and returns the outcome.
+func (m *simMatch) play() game.Outcome {
+	for {
+		if reason, over := m.tick(); over {
+			return game.DefaultWinConditions{}.Evaluate(m.session, game.EndTrigger{Reason: reason, Elapsed: m.now.Sub(m.end.Add(-m.rules.GameDuration))}, m.rules)
+		}
+		for _, player := range m.players() {
+			for _, action := range m.strategies[player.Account.Username].OnState(m.state()) {
+				m.deploy(player, action.Deploy)
+			}
+		}
+	}
+}
+
+func (m *simMatch) players() []*models.PlayerInGame {
+	return []*models.PlayerInGame{m.session.Player1, m.session.Player2}
+}
+
+func (m *simMatch) opponent(username string) *models.PlayerInGame {
+	if username == "p1" {
+		return m.session.Player2
+	}
+	return m.session.Player1
+}
+
+// tick advances the clock by one tick and reports whether, and why, the game ended.
+func (m *simMatch) tick() (string, bool) {
+	m.now = m.now.Add(simTick)
+	for _, player := range m.players() {
+		username := player.Account.Username
+		for m.now.Sub(m.lastRegen[username]) >= m.rules.ManaRegenInterval {
+			player.CurrentMana = min(player.CurrentMana+1, m.rules.MaxMana)
+			m.lastRegen[username] = m.lastRegen[username].Add(m.rules.ManaRegenInterval)
+		}
+	}
+
+	for _, player := range m.players() {
+		for _, id := range sortedTroopIDs(player) {
+			troop := player.DeployedTroops[id]
+			if !m.due(id) {
+				continue
+			}
+			target := game.FindTargetTower(troop, m.session)
+			if target == nil {
+				continue
+			}
+			spec := m.session.GameConfig.Troops[troop.SpecID]
+			atk := troop.CurrentATK
+			if !troop.HasAttacked && spec.Special == models.SpecialCharge {
+				atk = game.ChargedATK(atk, spec.ChargeMultiplier)
+			}
+			troop.HasAttacked = true
+			effectiveness := game.Effectiveness(m.rules, spec.DamageType, m.session.GameConfig.Towers[target.SpecID].ArmorType)
+			damage, _ := game.CalculateDamage(atk, target.CurrentDEF, false, 0, effectiveness)
+			if game.ApplyDamageToTower(target, damage, troop.InstanceID).Destroyed && game.IsKingTower(m.session.GameConfig, target) {
+				return network.GameEndKingTowerDestroyed, true
+			}
+		}
+	}
+
+	for _, player := range m.players() {
+		for _, tower := range player.Towers {
+			if tower.CurrentHP <= 0 || !m.due(tower.GameSpecificID) {
+				continue
+			}
+			target := game.FindTroopToAttack(tower, m.session)
+			if target == nil {
+				continue
+			}
+			spec := m.session.GameConfig.Towers[tower.SpecID]
+			effectiveness := game.Effectiveness(m.rules, spec.DamageType, m.session.GameConfig.Troops[target.SpecID].ArmorType)
+			damage, _ := game.CalculateDamage(tower.CurrentATK, target.CurrentDEF, true, spec.CritChance, effectiveness)
+			if game.ApplyDamageToTroop(target, damage).Destroyed {
+				delete(m.opponent(player.Account.Username).DeployedTroops, target.InstanceID)
+			}
+		}
+	}
+
+	if !m.now.Before(m.end) {
+		return network.GameEndTimeout, true
+	}
+	return "", false
+}
+
+// due reports whether the troop or tower with the given ID attacks this tick.
+func (m *simMatch) due(id string) bool {
+	if m.now.Sub(m.lastAttack[id]) < simAttackInterval {
+		return false
+	}
+	m.lastAttack[id] = m.lastAttack[id].Add(simAttackInterval)
+	return true
+}
+
+// deploy handles a deploy as the server does, ignoring one the player cannot afford.
+func (m *simMatch) deploy(player *models.PlayerInGame, specID string) {
+	spec, ok := m.session.GameConfig.Troops[specID]
+	if !ok || player.CurrentMana < spec.ManaCost {
+		return
+	}
+	player.CurrentMana -= spec.ManaCost
+	if isQueen(spec) {
+		game.ApplyQueenHeal(player.Account.Username, m.session, simQueenHeal)
+		return
+	}
+	m.troops++
+	troop := &models.ActiveTroop{
+		InstanceID: fmt.Sprintf("%s_troop_%03d", player.Account.Username, m.troops),
+		SpecID:     spec.ID, OwnerID: player.Account.Username,
+		CurrentHP: spec.BaseHP, MaxHP: spec.BaseHP, CurrentATK: spec.BaseATK, CurrentDEF: spec.BaseDEF,
+		ShieldHP:   spec.ShieldHP,
+		DeployedAt: m.now, ReadyAt: m.now,
+	}
+	player.DeployedTroops[troop.InstanceID] = troop
+	m.lastAttack[troop.InstanceID] = m.now
+}
+
+// state is the state update both players receive.
+func (m *simMatch) state() network.GameStateUpdateUDP {
+	update := network.GameStateUpdateUDP{
+		GameTimeRemainingSeconds: int(m.end.Sub(m.now) / time.Second),
+		Player1Mana:              m.session.Player1.CurrentMana,
+		Player2Mana:              m.session.Player2.CurrentMana,
+		PlayerMana:               map[string]int{},
+		ActiveTroops:             map[string]network.TroopState{},
+	}
+	for _, player := range m.players() {
+		update.PlayerMana[player.Account.Username] = player.CurrentMana
+		for _, tower := range player.Towers {
+			update.Towers = append(update.Towers, network.TowerState{
+				ID: tower.GameSpecificID, Spec: tower.SpecID, Owner: tower.OwnerID,
+				HP: tower.CurrentHP, MaxHP: tower.MaxHP, Destroyed: tower.IsDestroyed,
+			})
+		}
+		for id, troop := range player.DeployedTroops {
+			update.ActiveTroops[id] = network.TroopState{Spec: troop.SpecID, Owner: troop.OwnerID, HP: troop.CurrentHP, MaxHP: troop.MaxHP, ATK: troop.CurrentATK}
+		}
+	}
+	return update
+}
+
+func sortedTroopIDs(player *models.PlayerInGame) []string {
+	ids := make([]string, 0, len(player.DeployedTroops))
+	for id := range player.DeployedTroops {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// ValueBased should beat CheapestSpam between players of the same level. The games are
+// seeded, strategies and CRIT rolls alike, and the strategies swap sides every game.
+func TestValueBasedBeatsCheapestSpam(t *testing.T) {
+	const games = 20
+	cfg := loadConfig(t)
+	wins, draws, losses := 0, 0, 0
+	for seed := int64(1); seed <= games; seed++ {
+		game.SeedRNG(seed)
+		valueSide, spamSide := "p1", "p2"
+		if seed%2 == 0 {
+			valueSide, spamSide = spamSide, valueSide
+		}
+		value := NewValueBased(Options{Username: valueSide, PlayerOne: valueSide == "p1", Troops: cfg.Troops, Towers: cfg.Towers, Seed: seed})
+		spam := NewCheapestSpam(Options{Username: spamSide, PlayerOne: spamSide == "p1", Troops: cfg.Troops, Seed: seed})
+		var m *simMatch
+		if valueSide == "p1" {
+			m = newSimMatch(cfg, value, spam)
+		} else {
+			m = newSimMatch(cfg, spam, value)
+		}
+		outcome := m.play()
+		switch outcome.Winner {
+		case valueSide:
+			wins++
+		case "":
+			draws++
+		default:
+			losses++
+		}
+		t.Logf("seed %d: value-based as %s: %s", seed, valueSide, outcome.Summary)
+	}
+	t.Logf("value-based against cheapest-spam: %d wins, %d draws, %d losses", wins, draws, losses)
+	if wins <= games/2 {
+		t.Errorf("value-based won %d of %d games against cheapest-spam, want most", wins, games)
+	}
+}
@@ -0,0 +1,106 @@
+package bot
+
+import (
+	"math"
+
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// CheapestSpam deploys the cheapest troop it can afford whenever it can afford one. It
+// never uses the Queen. It is the simplest opponent, and a baseline for other strategies.
+type CheapestSpam struct {
+	player
+}
+
+// NewCheapestSpam creates a CheapestSpam strategy.
+func NewCheapestSpam(opts Options) *CheapestSpam {
+	return &CheapestSpam{player: newPlayer(opts)}
+}
+
+// OnState implements Strategy.
+func (s *CheapestSpam) OnState(update network.GameStateUpdateUDP) []Action {
+	if !canAct(update) {
+		return nil
+	}
+	affordable := s.fighters(s.mana(update))
+	if len(affordable) == 0 {
+		return nil
+	}
+	cheapest := s.pickBest(affordable, func(spec models.TroopSpec) int { return -spec.ManaCost })
+	return []Action{{Deploy: cheapest.ID}}
+}
+
+// queenHealThreshold is the share of its HP below which ValueBased heals a tower.
+const queenHealThreshold = 0.5
+
+// ValueBased spends mana where it counts:
+//   - it deploys the Queen when one of its towers is below half its HP;
+//   - while the opponent has no troops on the field it saves up, deploying only once its
+//     mana is full so none goes to waste;
+//   - otherwise it saves up for the troop dealing the most damage per point of mana to the
+//     opponent's weakest tower, and deploys it as soon as it can afford it.
+type ValueBased struct {
+	player
+}
+
+// NewValueBased creates a ValueBased strategy.
+func NewValueBased(opts Options) *ValueBased {
+	return &ValueBased{player: newPlayer(opts)}
+}
+
+// OnState implements Strategy.
+func (s *ValueBased) OnState(update network.GameStateUpdateUDP) []Action {
+	if !canAct(update) {
+		return nil
+	}
+	mana := s.mana(update)
+	if _, share, ok := s.weakestTower(update); ok && share < queenHealThreshold {
+		if queen, ok := s.queen(); ok && queen.ManaCost <= mana {
+			return []Action{{Deploy: queen.ID}}
+		}
+	}
+	if s.opponentTroops(update) == 0 && mana < s.opts.MaxMana {
+		return nil
+	}
+	fighters := s.fighters(math.MaxInt)
+	if len(fighters) == 0 {
+		return nil
+	}
+	def := s.targetDEF(update)
+	best := s.pickBest(fighters, func(spec models.TroopSpec) int {
+		return max(spec.BaseATK-def, 0) * 1000 / max(spec.ManaCost, 1) // Damage per thousandth of a mana point
+	})
+	if best.ManaCost > mana {
+		return nil // Saving up for it
+	}
+	return []Action{{Deploy: best.ID}}
+}
+
+// targetDEF returns the base DEF of the opponent's weakest standing tower, which the
+// player's troops attack, or 0 if it is unknown.
+func (s *ValueBased) targetDEF(update network.GameStateUpdateUDP) int {
+	var target *network.TowerState
+	for i, tower := range update.Towers {
+		if tower.Owner == s.opts.Username || tower.Destroyed || tower.HP <= 0 {
+			continue
+		}
+		if target == nil || tower.HP < target.HP {
+			target = &update.Towers[i]
+		}
+	}
+	if target == nil {
+		return 0
+	}
+	return s.opts.Towers[target.Spec].BaseDEF
+}
+
+// queen returns the Queen's spec, if the strategy may deploy her.
+func (s *ValueBased) queen() (models.TroopSpec, bool) {
+	for _, spec := range s.specs {
+		if isQueen(spec) {
+			return spec, true
+		}
+	}
+	return models.TroopSpec{}, false
+}
@@ -0,0 +1,178 @@
+package bot
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// loadConfig reads the game config the server ships with.
+func loadConfig(t testing.TB) models.GameConfig {
+	t.Helper()
+	var cfg models.GameConfig
+	for name, v := range map[string]interface{}{"troops.json": &cfg.Troops, "towers.json": &cfg.Towers} {
+		data, err := os.ReadFile(filepath.Join("..", "..", "config_enhanced", name))
+		if err != nil {
+			t.Fatalf("reading %s: %v", name, err)
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			t.Fatalf("decoding %s: %v", name, err)
+		}
+	}
+	return cfg
+}
+
+// canned is a state update in which alice has mana and her King Tower has kingHP of 2000
+// HP left; bob has the given number of troops on the field, and his Guard Tower bobGuardHP.
+func canned(mana, kingHP, bobTroops, bobGuardHP int) network.GameStateUpdateUDP {
+	update := network.GameStateUpdateUDP{
+		PlayerMana: map[string]int{"alice": mana, "bob": 5},
+		Towers: []network.TowerState{
+			{ID: "player1_king_tower", Spec: "king_tower", Owner: "alice", HP: kingHP, MaxHP: 2000},
+			{ID: "player1_guard_tower", Spec: "guard_tower", Owner: "alice", HP: 1000, MaxHP: 1000},
+			{ID: "player2_king_tower", Spec: "king_tower", Owner: "bob", HP: 2000, MaxHP: 2000},
+			{ID: "player2_guard_tower", Spec: "guard_tower", Owner: "bob", HP: bobGuardHP, MaxHP: 1000, Destroyed: bobGuardHP == 0},
+		},
+		ActiveTroops: map[string]network.TroopState{},
+	}
+	for i := 0; i < bobTroops; i++ {
+		update.ActiveTroops[string(rune('a'+i))] = network.TroopState{Spec: "pawn", Owner: "bob", HP: 50, MaxHP: 50}
+	}
+	return update
+}
+
+func deploys(actions []Action) []string {
+	ids := []string{}
+	for _, action := range actions {
+		ids = append(ids, action.Deploy)
+	}
+	return ids
+}
+
+func TestValueBased(t *testing.T) {
+	cfg := loadConfig(t)
+	tests := []struct {
+		name   string
+		update network.GameStateUpdateUDP
+		want   []string
+	}{
+		{"queen heals a tower under half its HP", canned(5, 999, 1, 1000), []string{"queen"}},
+		{"queen heals even with nothing to fight", canned(5, 999, 0, 1000), []string{"queen"}},
+		{"saves for the prince at exactly half", canned(5, 1000, 1, 1000), []string{}},
+		{"saves when the queen is unaffordable", canned(4, 500, 1, 1000), []string{}},
+		{"saves when the opponent's field is empty", canned(9, 2000, 0, 1000), []string{}},
+		{"spends once mana is full", canned(10, 2000, 0, 1000), []string{"prince"}},
+		{"most damage per mana against troops", canned(6, 2000, 2, 1000), []string{"prince"}},
+		{"only the prince hurts a King Tower", canned(6, 2000, 2, 0), []string{"prince"}},
+		{"no deploys in the warm-up", func() network.GameStateUpdateUDP { u := canned(10, 500, 1, 1000); u.Warmup = true; return u }(), []string{}},
+		{"no deploys after the end", func() network.GameStateUpdateUDP { u := canned(10, 500, 1, 1000); u.Final = true; return u }(), []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewValueBased(Options{Username: "alice", PlayerOne: true, Troops: cfg.Troops, Towers: cfg.Towers, Seed: 1})
+			if got := deploys(s.OnState(tt.update)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("OnState() deploys %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Damage per mana depends on the DEF of the tower under attack: a cheap troop that gets
+// through a weak tower's DEF can be worth more than a strong one that a tough tower blunts.
+func TestValueBasedWeighsTowerDEF(t *testing.T) {
+	troops := map[string]models.TroopSpec{
+		"archer": {ID: "archer", ManaCost: 2, BaseATK: 250},
+		"golem":  {ID: "golem", ManaCost: 8, BaseATK: 500},
+	}
+	towers := loadConfig(t).Towers
+	tests := []struct {
+		name       string
+		bobGuardHP int
+		want       string
+	}{
+		{"guard tower, DEF 100", 1000, "archer"}, // 75 vs 50 damage per mana
+		{"king tower, DEF 300", 0, "golem"},      // 0 vs 25 damage per mana
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewValueBased(Options{Username: "alice", PlayerOne: true, Troops: troops, Towers: towers, Seed: 1})
+			if got := deploys(s.OnState(canned(10, 2000, 1, tt.bobGuardHP))); !reflect.DeepEqual(got, []string{tt.want}) {
+				t.Errorf("OnState() deploys %v, want [%s]", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheapestSpam(t *testing.T) {
+	troops := loadConfig(t).Troops
+	s := NewCheapestSpam(Options{Username: "alice", Troops: troops, Seed: 1})
+	for _, tt := range []struct {
+		mana int
+		want []string
+	}{{2, []string{}}, {3, []string{"pawn"}}, {10, []string{"pawn"}}} {
+		// It never heals, however low its towers
+		if got := deploys(s.OnState(canned(tt.mana, 100, 0, 1000))); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("OnState() with %d mana deploys %v, want %v", tt.mana, got, tt.want)
+		}
+	}
+}
+
+func TestStrategiesReadPositionalMana(t *testing.T) {
+	troops := loadConfig(t).Troops
+	update := canned(0, 2000, 1, 1000)
+	update.PlayerMana = nil
+	update.Player1Mana, update.Player2Mana = 2, 3
+	if got := deploys(NewCheapestSpam(Options{Username: "alice", PlayerOne: true, Troops: troops}).OnState(update)); len(got) != 0 {
+		t.Errorf("player one with 2 mana deploys %v", got)
+	}
+	if got := deploys(NewCheapestSpam(Options{Username: "alice", Troops: troops}).OnState(update)); !reflect.DeepEqual(got, []string{"pawn"}) {
+		t.Errorf("player two with 3 mana deploys %v, want [pawn]", got)
+	}
+}
+
+// Troops tied on the score a strategy picks by are chosen between with the seeded RNG.
+func TestSeededChoicesRepeat(t *testing.T) {
+	troops := map[string]models.TroopSpec{
+		"a": {ID: "a", ManaCost: 3, BaseATK: 100},
+		"b": {ID: "b", ManaCost: 3, BaseATK: 100},
+		"c": {ID: "c", ManaCost: 3, BaseATK: 100},
+	}
+	play := func(seed int64) []string {
+		s := NewCheapestSpam(Options{Username: "alice", Troops: troops, Seed: seed})
+		var picks []string
+		for i := 0; i < 20; i++ {
+			picks = append(picks, deploys(s.OnState(canned(3, 2000, 1, 1000)))...)
+		}
+		return picks
+	}
+	first := play(42)
+	if again := play(42); !reflect.DeepEqual(first, again) {
+		t.Errorf("seed 42 picked %v, then %v", first, again)
+	}
+	seen := map[string]bool{}
+	for _, id := range first {
+		seen[id] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("seed 42 always picked the same of three tied troops: %v", first)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, name := range Names() {
+		if _, err := New(name, Options{}); err != nil {
+			t.Errorf("New(%q): %v", name, err)
+		}
+	}
+	if _, err := New("Value-Based", Options{}); err != nil {
+		t.Errorf("names are not case-insensitive: %v", err)
+	}
+	if _, err := New("random", Options{}); err == nil {
+		t.Error("New accepted an unknown strategy")
+	}
+}
@@ -0,0 +1,165 @@
+package bot
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// QueenID is the TroopSpec.ID of the Queen, which heals a tower instead of fighting.
+const QueenID = "queen"
+
+// Action is something a strategy wants its player to do.
+type Action struct {
+	Deploy string // TroopSpec.ID of the troop to deploy
+}
+
+// Command returns the UDP command carrying out the action.
+func (a Action) Command() network.DeployTroopCommandUDP {
+	return network.DeployTroopCommandUDP{TroopID: a.Deploy}
+}
+
+// Strategy decides what a computer-controlled player does. OnState is called with every
+// full state update the player receives and returns the actions to take now, usually none
+// or one. Strategies are not safe for concurrent use.
+type Strategy interface {
+	OnState(update network.GameStateUpdateUDP) []Action
+}
+
+// Options configures a strategy.
+type Options struct {
+	Username  string                      // The player the strategy plays for
+	PlayerOne bool                        // Used to read mana from servers that send no PlayerMana
+	Troops    map[string]models.TroopSpec // The troops it may deploy, usually GameConfig.Troops
+	Towers    map[string]models.TowerSpec // The towers in play, usually GameConfig.Towers; unknown towers count as DEF 0
+	MaxMana   int                         // GameRules.MaxMana; 0 means the default rules' value
+	// Seed seeds the choice between equally good troops, so that a game against the same
+	// moves plays out the same way. 0 picks a random seed.
+	Seed int64
+}
+
+// strategies maps the names accepted by New to their constructors.
+var strategies = map[string]func(Options) Strategy{
+	"cheapest-spam": func(opts Options) Strategy { return NewCheapestSpam(opts) },
+	"value-based":   func(opts Options) Strategy { return NewValueBased(opts) },
+}
+
+// Names returns the strategy names New accepts, sorted.
+func Names() []string {
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the strategy called name, e.g. from a command-line flag.
+func New(name string, opts Options) (Strategy, error) {
+	newStrategy, ok := strategies[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %q (want one of %s)", name, strings.Join(Names(), ", "))
+	}
+	return newStrategy(opts), nil
+}
+
+// player is the state and configuration every strategy works from.
+type player struct {
+	opts  Options
+	rng   *rand.Rand
+	specs []models.TroopSpec // opts.Troops sorted by ID, so choices do not depend on map order
+}
+
+func newPlayer(opts Options) player {
+	if opts.MaxMana <= 0 {
+		opts.MaxMana = models.DefaultGameRules().MaxMana
+	}
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	specs := make([]models.TroopSpec, 0, len(opts.Troops))
+	for _, spec := range opts.Troops {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].ID < specs[j].ID })
+	return player{opts: opts, rng: rand.New(rand.NewSource(seed)), specs: specs}
+}
+
+// canAct reports whether deploys are accepted in the game the update describes.
+func canAct(update network.GameStateUpdateUDP) bool {
+	return !update.Warmup && !update.Final
+}
+
+// mana returns the player's mana in an update.
+func (p *player) mana(update network.GameStateUpdateUDP) int {
+	if mana, ok := update.PlayerMana[p.opts.Username]; ok {
+		return mana
+	}
+	if p.opts.PlayerOne {
+		return update.Player1Mana
+	}
+	return update.Player2Mana
+}
+
+// opponentTroops counts the opponent's troops on the field.
+func (p *player) opponentTroops(update network.GameStateUpdateUDP) int {
+	n := 0
+	for _, troop := range update.ActiveTroops {
+		if troop.Owner != p.opts.Username {
+			n++
+		}
+	}
+	return n
+}
+
+// weakestTower returns the player's standing tower with the lowest share of its HP left,
+// and that share; ok is false when every tower is destroyed.
+func (p *player) weakestTower(update network.GameStateUpdateUDP) (tower network.TowerState, share float64, ok bool) {
+	share = 2 // Above any real share
+	for _, t := range update.Towers {
+		if t.Owner != p.opts.Username || t.Destroyed || t.MaxHP <= 0 {
+			continue
+		}
+		if s := float64(t.HP) / float64(t.MaxHP); s < share {
+			tower, share, ok = t, s, true
+		}
+	}
+	return tower, share, ok
+}
+
+// fighters returns the affordable troops other than the Queen.
+func (p *player) fighters(mana int) []models.TroopSpec {
+	var affordable []models.TroopSpec
+	for _, spec := range p.specs {
+		if spec.ManaCost <= mana && !isQueen(spec) {
+			affordable = append(affordable, spec)
+		}
+	}
+	return affordable
+}
+
+// pickBest returns the troop among specs scoring highest, choosing at random between ties.
+// specs must not be empty.
+func (p *player) pickBest(specs []models.TroopSpec, score func(models.TroopSpec) int) models.TroopSpec {
+	var best []models.TroopSpec
+	for _, spec := range specs {
+		switch {
+		case len(best) == 0 || score(spec) > score(best[0]):
+			best = []models.TroopSpec{spec}
+		case score(spec) == score(best[0]):
+			best = append(best, spec)
+		}
+	}
+	return best[p.rng.Intn(len(best))]
+}
+
+// isQueen reports whether spec is the Queen, matched as the server does.
+func isQueen(spec models.TroopSpec) bool {
+	return strings.EqualFold(spec.ID, QueenID)
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// AlertKind identifies one kind of critical event the player is alerted to.
+type AlertKind string
+
+const (
+	AlertOwnTowerDestroyed AlertKind = "own_tower_destroyed"
+	AlertKingLow           AlertKind = "king_low"
+	AlertCritReceived      AlertKind = "crit_received"
+	AlertTimeWarning       AlertKind = "time_warning"
+	AlertGameOver          AlertKind = "game_over"
+)
+
+// AlertManager decides when to raise alerts and holds the banner currently on screen.
+// Alerts that follow from state (King HP, time left) fire once per game. It is safe for
+// concurrent use: the network goroutines raise alerts while the UI goroutine draws them.
+type AlertManager struct {
+	mu   sync.Mutex
+	cfg  AlertConfig
+	now  func() time.Time // Clock, replaceable for deterministic use
+	bell func()           // Rings the terminal bell
+
+	banner      string
+	bannerUntil time.Time
+
+	kingLowFired     bool
+	timeWarningFired bool
+}
+
+// NewAlertManager creates an alert manager. A nil clock means time.Now.
+func NewAlertManager(cfg AlertConfig, now func() time.Time) *AlertManager {
+	if now == nil {
+		now = time.Now
+	}
+	return &AlertManager{
+		cfg:  cfg,
+		now:  now,
+		bell: func() { os.Stdout.WriteString("\a") },
+	}
+}
+
+// Enabled reports whether alerts of the given kind are switched on.
+func (a *AlertManager) Enabled(kind AlertKind) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.enabled(kind)
+}
+
+// enabled is Enabled for callers holding a.mu.
+func (a *AlertManager) enabled(kind AlertKind) bool {
+	enabled, listed := a.cfg.Enabled[kind]
+	return !listed || enabled
+}
+
+// Trigger raises an alert: the banner shows text for the configured time and the bell rings
+// if enabled. It returns false if alerts of this kind are switched off.
+func (a *AlertManager) Trigger(kind AlertKind, text string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.trigger(kind, text)
+}
+
+// trigger is Trigger for callers holding a.mu.
+func (a *AlertManager) trigger(kind AlertKind, text string) bool {
+	if !a.enabled(kind) {
+		return false
+	}
+	a.banner = text
+	a.bannerUntil = a.now().Add(time.Duration(a.cfg.BannerSeconds) * time.Second)
+	if a.cfg.Bell && a.bell != nil {
+		a.bell()
+	}
+	return true
+}
+
+// ObserveState raises the state-driven alerts from a game state update: the time warning and
+// the King Tower HP threshold. kingMaxHP <= 0 means the King Tower is unknown.
+func (a *AlertManager) ObserveState(secondsRemaining, kingHP, kingMaxHP int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.timeWarningFired && secondsRemaining > 0 && secondsRemaining <= a.cfg.TimeWarningSeconds {
+		a.timeWarningFired = true
+		a.trigger(AlertTimeWarning, fmt.Sprintf("Less than %d seconds left!", a.cfg.TimeWarningSeconds))
+	}
+	if !a.kingLowFired && kingMaxHP > 0 && kingHP > 0 && float64(kingHP) < a.cfg.KingHPThreshold*float64(kingMaxHP) {
+		a.kingLowFired = true
+		a.trigger(AlertKingLow, fmt.Sprintf("Your King Tower is below %.0f%% HP!", a.cfg.KingHPThreshold*100))
+	}
+}
+
+// Banner returns the banner text while it should still be shown.
+func (a *AlertManager) Banner() (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.banner == "" || !a.now().Before(a.bannerUntil) {
+		return "", false
+	}
+	return a.banner, true
+}
+
+// Reset clears the banner and re-arms the once-per-game alerts.
+func (a *AlertManager) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.banner = ""
+	a.bannerUntil = time.Time{}
+	a.kingLowFired = false
+	a.timeWarningFired = false
+}
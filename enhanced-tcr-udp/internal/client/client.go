@@ -2,57 +2,103 @@ package client
 
 import (
 	"bufio"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
+	"log"
 	"net"
 	"os"
 	"strings"
 	"sync"
 	"time"
 
-	"enhanced-tcr-udp/internal/models"
-	"enhanced-tcr-udp/internal/network"
+	"enhanced-tcr-udp/internal/persistence"
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+	"enhanced-tcr-udp/pkg/tcrclient"
 
 	"github.com/nsf/termbox-go"
 )
 
 const (
 	ServerAddressTCP = "localhost:8080" // Assuming server runs on this TCP port
-	ResendTimeout    = 1 * time.Second
-	MaxResends       = 3
+
+	// gameConfigWaitTimeout is how long matchmaking waits for the GameConfigData message
+	// that follows MatchFoundResponse before falling back to the local config files.
+	gameConfigWaitTimeout = 3 * time.Second
 )
 
-// UnackedDeployInfo stores information about a deploy command awaiting acknowledgment.
-type UnackedDeployInfo struct {
-	Message    network.UDPMessage
-	SentAt     time.Time
-	RetryCount int
+// ErrAlreadyInGame is returned by RequestMatchmakingWithUI when the server refuses to queue
+// a player who is still in a running game. Client.ActiveGameID then names that game, which
+// ReconnectWithUI can rejoin.
+var ErrAlreadyInGame = errors.New("you are already in a running game")
+
+// errNotInGame is returned by the UDP calls when the client has no game to talk to.
+var errNotInGame = errors.New("cannot ping: client not in a valid game state")
+
+// UDPUnreachableError is returned by ProbeUDP when the game's UDP port never answered.
+type UDPUnreachableError struct {
+	Port     int
+	Attempts int   // Pings sent
+	Err      error // Why the last ping failed
 }
 
+func (e *UDPUnreachableError) Error() string {
+	return fmt.Sprintf("UDP port %d unreachable — check firewall", e.Port)
+}
+
+func (e *UDPUnreachableError) Unwrap() error { return e.Err }
+
 // Client holds the state for a game client
 type Client struct {
+	ServerAddress string // TCP address of the server, defaults to ServerAddressTCP
 	PlayerAccount *models.PlayerAccount
-	TCPConn       net.Conn
-	UDPConn       *net.UDPConn       // For UDP communication
-	ServerUDPAddr *net.UDPAddr       // To store the resolved server UDP address
-	ui            *TermboxUI         // Reference to the termbox UI
-	SessionToken  string             // Token for the current game session
-	IsPlayerOne   bool               // True if this client is Player 1 in the game
-	GameConfig    *models.GameConfig // Loaded game configuration
-
-	nextSequenceNumber           uint32                       // For outgoing UDP messages
-	unacknowledgedDeployCommands map[uint32]UnackedDeployInfo // Seq -> Info
-	mu                           sync.Mutex                   // To protect sequence number and unacked commands
+	tcr           *tcrclient.Client      // The connection to the server. Guarded by mu; use server()
+	session       *tcrclient.Session     // The current game's UDP session. Guarded by mu; use udp()
+	ServerUDPAddr *net.UDPAddr           // To store the resolved server UDP address
+	ui            *TermboxUI             // Reference to the termbox UI
+	SessionToken  string                 // Token for the current game session
+	IsPlayerOne   bool                   // True if this client is Player 1 in the game
+	GameConfig    *models.GameConfig     // Loaded game configuration
+	Opponent      *network.PublicProfile // Opponent of the current game, from MatchFoundResponse
+	serverConfig  bool                   // GameConfig came from the server for the current game. Guarded by mu
+	// Handicaps of the current game's players, by username, from MatchFoundResponse
+	Handicaps map[string]models.PlayerOverrides
+
+	// lobbyConfig is the game config last fetched with FetchGameConfig, the fallback for a
+	// game whose config does not arrive. Guarded by mu.
+	lobbyConfig *models.GameConfig
+
+	// PendingResults holds results of earlier games the server could not deliver at the time.
+	// They arrive right after a successful login.
+	PendingResults []network.GameOverResults
+
+	ProtocolVersion int    // Protocol version negotiated at login
+	ActiveGameID    string // Running game the player is still in, from login; see ReconnectWithUI
+	QueueType       string // Matchmaking queue to join, network.QueueRanked or network.QueueCasual; "" means ranked
+	// PreferredDuration is the game duration to ask for when queueing; 0 means any. The
+	// server decides the actual one and reports it in MatchFoundResponse.
+	PreferredDuration time.Duration
+
+	mana   *manaPrediction        // This player's mana as shown in the HUD. Guarded by mu
+	resend tcrclient.ResendConfig // When each game's session resends unacknowledged commands. Guarded by mu
+	mu     sync.Mutex             // To protect the game's session and mana
+
+	inboundDrops *network.UDPDrops // Inbound UDP datagrams dropped before handling, by reason
+
+	capture  *network.Capture // Records every message sent and received when set; see SetCapture
+	recorder *GameRecorder    // Saves each game's UDP messages for the player when set; see SetGameRecorder
 }
 
 // NewClient creates a new client instance
 func NewClient(ui *TermboxUI) *Client {
 	c := &Client{
-		ui:                           ui,
-		nextSequenceNumber:           1, // Start sequence numbers from 1
-		unacknowledgedDeployCommands: make(map[uint32]UnackedDeployInfo),
-		GameConfig:                   nil, // Initialize GameConfig
+		ServerAddress: ServerAddressTCP,
+		ui:            ui,
+		mana:          newManaPrediction(),
+		resend:        tcrclient.DefaultResendConfig(),
+		inboundDrops:  network.NewUDPDrops(nil),
+		GameConfig:    nil, // Initialize GameConfig
 	}
 	if ui != nil {
 		ui.SetClient(c) // Pass client reference to UI
@@ -60,6 +106,83 @@ func NewClient(ui *TermboxUI) *Client {
 	return c
 }
 
+// SetCapture makes the client record every TCP and UDP message it sends or receives to
+// capture (nil turns recording off). Call it before logging in; the caller closes it.
+func (c *Client) SetCapture(capture *network.Capture) {
+	c.capture = capture
+}
+
+// SetGameRecorder makes the client save every game it plays from now on with recorder (nil
+// turns recording off).
+func (c *Client) SetGameRecorder(recorder *GameRecorder) {
+	c.recorder = recorder
+}
+
+// traceUDP records a UDP datagram sent or received in the capture, and a sent one in the
+// game recording; the session hands received ones to the recording once it has checked
+// they belong to the game.
+func (c *Client) traceUDP(direction string, data []byte) {
+	c.capture.Record(direction, network.CaptureUDP, data)
+	if direction == network.CaptureSent {
+		c.recorder.Record(direction, data)
+	}
+}
+
+// startRecording begins recording the game that is starting, if games are being recorded.
+func (c *Client) startRecording() {
+	opponent := ""
+	if c.Opponent != nil {
+		opponent = c.Opponent.Username
+	}
+	if _, err := c.recorder.Start(opponent, time.Now()); err != nil && c.ui != nil {
+		c.ui.AddEventMessage(LogError, fmt.Sprintf("Could not record this game: %v", err))
+	}
+}
+
+// SetResendConfig replaces the command resend settings, from the next game on.
+func (c *Client) SetResendConfig(cfg tcrclient.ResendConfig) {
+	c.mu.Lock()
+	c.resend = cfg
+	c.mu.Unlock()
+	if tc := c.server(); tc != nil {
+		tc.SetSessionConfig(c.sessionConfig())
+	}
+}
+
+// traceTCP records a TCP message sent to or received from the server in the capture.
+func (c *Client) traceTCP(direction string, data []byte) {
+	c.capture.Record(direction, network.CaptureTCP, data)
+}
+
+// sessionConfig returns what each game's UDP session starts from. The connection fills in
+// the match and routes state updates and events to the handlers performLogin sets.
+func (c *Client) sessionConfig() tcrclient.SessionConfig {
+	c.mu.Lock()
+	resend := c.resend
+	c.mu.Unlock()
+	return tcrclient.SessionConfig{
+		Resend: resend,
+		Drops:  c.inboundDrops,
+		Logf:   log.Printf,
+		Trace:  c.traceUDP,
+		OnMessage: func(_ network.UDPMessage, data []byte) {
+			defer RecoverCrash()
+			c.recorder.Record(network.CaptureReceived, data)
+		},
+		OnTimer:  guarded(c.handleGameTimerUpdate),
+		OnGiveUp: guarded(c.deployGivenUp),
+	}
+}
+
+// guarded wraps a handler the connection calls from its own goroutines, so a panic in it
+// is reported like one in a goroutine the client starts (see RecoverCrash).
+func guarded[T any](handler func(T)) func(T) {
+	return func(v T) {
+		defer RecoverCrash()
+		handler(v)
+	}
+}
+
 // AuthenticateWithUI prompts the user for credentials via TermboxUI and attempts to log in.
 func (c *Client) AuthenticateWithUI() (*models.PlayerAccount, error) {
 	if c.ui == nil {
@@ -97,64 +220,134 @@ func (c *Client) authenticateWithConsole() (*models.PlayerAccount, error) {
 	return c.performLogin(username, password)
 }
 
-// performLogin contains the common logic for sending login request and handling response.
+// performLogin connects to the server and logs in. A refused login leaves the connection
+// open; main decides what to do about it.
 func (c *Client) performLogin(username, password string) (*models.PlayerAccount, error) {
-	conn, err := net.Dial("tcp", ServerAddressTCP)
+	tc, err := tcrclient.Connect(context.Background(), c.ServerAddress)
 	if err != nil {
-		// log.Printf("Failed to connect to server at %s: %v", ServerAddressTCP, err)
+		// log.Printf("Failed to connect to server at %s: %v", c.ServerAddress, err)
 		return nil, err
 	}
-	c.TCPConn = conn
+	tc.TraceTCP(c.traceTCP)
+	tc.SetSessionConfig(c.sessionConfig())
+	tc.SetConfigWait(gameConfigWaitTimeout)
+	tc.OnMatch(c.prepareMatch)
+	tc.OnGameConfig(c.setGameConfig)
+	tc.OnStateUpdate(guarded(c.handleGameStateUpdate))
+	tc.OnEvent(guarded(c.handleGameEvent))
+	c.mu.Lock()
+	c.tcr = tc
+	c.mu.Unlock()
 
-	loginReq := network.LoginRequest{Username: username, Password: password}
-	// Use TCPMessage envelope if server expects it, for now direct object.
-	encoder := json.NewEncoder(c.TCPConn)
-	if err := encoder.Encode(loginReq); err != nil {
-		// log.Printf("Error sending login request: %v", err)
-		c.CloseConnections() // Close connection on error
-		return nil, err
+	account, err := tc.Login(context.Background(), username, password)
+	var refused *tcrclient.LoginError
+	if errors.As(err, &refused) {
+		return nil, fmt.Errorf("server: %s", refused.Message)
 	}
-
-	decoder := json.NewDecoder(c.TCPConn)
-	var loginResp network.LoginResponse
-	if err := decoder.Decode(&loginResp); err != nil {
-		// log.Printf("Error receiving login response: %v", err)
+	if err != nil {
+		// log.Printf("Error logging in: %v", err)
 		c.CloseConnections()
 		return nil, err
 	}
+	c.PlayerAccount = account // The connection applies each game's results to it
+	c.ProtocolVersion = tc.Protocol()
+	c.ActiveGameID = tc.ActiveGameID()
+	c.PendingResults = tc.PendingResults()
+	// log.Printf("Login successful for %s.", c.PlayerAccount.Username)
+	return c.PlayerAccount, nil
+}
 
-	if !loginResp.Success {
-		// log.Printf("Login failed: %s", loginResp.Message)
-		// Don't close connection here, server already sent response, client main loop may want to show message.
-		// c.CloseConnections() // No, let main handle this based on error.
-		return nil, fmt.Errorf("server: %s", loginResp.Message)
+// UpdateSettings asks the server to change the account settings given (an empty value clears
+// one) and returns the settings now in effect. It must be called after login and before
+// RequestMatchmakingWithUI.
+func (c *Client) UpdateSettings(settings map[string]string) (map[string]string, error) {
+	tc := c.server()
+	if tc == nil || c.PlayerAccount == nil {
+		return nil, fmt.Errorf("client is not authenticated or connected")
 	}
+	if c.ProtocolVersion < network.ProtocolVersionRequests {
+		return nil, fmt.Errorf("the server does not support account settings (protocol version %d)", c.ProtocolVersion)
+	}
+	current, err := tc.UpdateSettings(context.Background(), settings)
+	var rejected *tcrclient.SettingsError
+	if errors.As(err, &rejected) {
+		return current, fmt.Errorf("server rejected settings: %s", rejected.Message)
+	}
+	return current, err
+}
 
-	c.PlayerAccount = loginResp.Player
-	// log.Printf("Login successful for %s.", c.PlayerAccount.Username)
-	return c.PlayerAccount, nil
+// FetchGameConfig asks the server for the troops and towers games are currently played
+// with, for browsing before a match. The config is kept for the session: when the server
+// reports that it has not changed, the kept copy is returned without downloading it again.
+func (c *Client) FetchGameConfig() (*models.GameConfig, error) {
+	tc := c.server()
+	if tc == nil || c.PlayerAccount == nil {
+		return nil, fmt.Errorf("client is not authenticated or connected")
+	}
+	if c.ProtocolVersion < network.ProtocolVersionGameConfigRequest {
+		return nil, fmt.Errorf("the server does not send the game config before a match (protocol version %d)", c.ProtocolVersion)
+	}
+	cfg, err := tc.GameConfig(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.lobbyConfig = cfg
+	c.mu.Unlock()
+	return cfg, nil
+}
+
+// server returns the connection to the server, or nil if there is none.
+func (c *Client) server() *tcrclient.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tcr
 }
 
-// CloseConnections closes any active network connections.
+// udp returns the current game's UDP session, or nil if there is none.
+func (c *Client) udp() *tcrclient.Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.session
+}
+
+// CloseConnections closes any active network connections, ending the game in progress on
+// this side. It is safe to call more than once and from several goroutines.
 func (c *Client) CloseConnections() {
-	if c.TCPConn != nil {
-		c.TCPConn.Close()
-		c.TCPConn = nil
+	c.EndGame()
+
+	c.mu.Lock()
+	tc, session := c.tcr, c.session
+	c.tcr, c.session = nil, nil
+	c.mu.Unlock()
+
+	if tc != nil {
+		tc.Close() // Stops the game's UDP listener and resender and closes its session
 		// log.Println("TCP connection closed.")
 	}
-	if c.UDPConn != nil {
-		c.UDPConn.Close()
-		c.UDPConn = nil
+	if session != nil {
+		session.Close()
 		// log.Println("UDP connection closed.")
 	}
 }
 
+// Shutdown ends the client: if sendQuit is set it first tells the server the player quit,
+// then closes all connections. Like CloseConnections it is safe to call more than once.
+func (c *Client) Shutdown(sendQuit bool) error {
+	var quitErr error
+	if sendQuit {
+		quitErr = c.SendPlayerQuitMessage()
+	}
+	c.CloseConnections()
+	return quitErr
+}
+
 // Main client logic (TCP/UDP connection, termbox setup)
 
 // MatchmakingInfo stores details received when a match is found.
 type MatchmakingInfo struct {
 	GameID      string
-	Opponent    models.PlayerAccount
+	Opponent    network.PublicProfile
 	UDPPort     int
 	IsPlayerOne bool
 	GameConfig  models.GameConfig
@@ -162,7 +355,8 @@ type MatchmakingInfo struct {
 
 // RequestMatchmakingWithUI sends a matchmaking request and updates UI.
 func (c *Client) RequestMatchmakingWithUI() (*network.MatchFoundResponse, error) {
-	if c.TCPConn == nil || c.PlayerAccount == nil {
+	tc := c.server()
+	if tc == nil || c.PlayerAccount == nil {
 		return nil, fmt.Errorf("client is not authenticated or connected")
 	}
 
@@ -171,366 +365,361 @@ func (c *Client) RequestMatchmakingWithUI() (*network.MatchFoundResponse, error)
 	} else {
 		// log.Println("Sending matchmaking request...")
 	}
-
-	// TODO (Sprint 2+): Implement explicit PDU-driven matchmaking.
-	// The client should send a network.TCPMessage with Type network.MsgTypeMatchmakingRequest
-	// and Payload network.MatchmakingRequest{PlayerID: c.PlayerAccount.Username}.
-	// The server's handleConnection would then need to decode this TCPMessage and dispatch
-	// to HandleMatchmakingRequest, instead of calling it implicitly after login.
-	// Example PDU construction:
-	// matchmakingPDU := network.TCPMessage{
-	// 	Type:    network.MsgTypeMatchmakingRequest,
-	// 	Payload: network.MatchmakingRequest{PlayerID: c.PlayerAccount.Username},
-	// }
-	// encoder := json.NewEncoder(c.TCPConn)
-	// if err := encoder.Encode(matchmakingPDU); err != nil {
-	// 	log.Printf("Error sending matchmaking PDU: %v", err)
-	// 	return nil, err
-	// }
-	// log.Println("Matchmaking PDU sent, awaiting MatchFoundResponse.")
-
-	// Current (Sprint 1) server directly sends MatchFoundResponse after auth completes and matchmaking happens implicitly.
-	// Server `internal/server/server.go`'s `handleConnection` calls `HandleMatchmakingRequest` directly.
-	// So client just waits for `MatchFoundResponse`.
-
+	// An older server put us in matchmaking straight after login, in its only queue
+	if c.ProtocolVersion < network.ProtocolVersionRequests && c.QueueType == network.QueueCasual && c.ui != nil {
+		c.ui.DisplayStaticText(1, 4, "This server has no casual queue; searching for a ranked game.", termbox.ColorYellow, termbox.ColorBlack)
+	}
 	if c.ui != nil {
 		c.ui.DisplayStaticText(1, 6, "Waiting for match...", termbox.ColorYellow, termbox.ColorBlack)
 	} else {
 		// log.Println("Waiting for match...")
 	}
 
-	decoder := json.NewDecoder(c.TCPConn)
-	var matchResponse network.MatchFoundResponse
-
-	if err := decoder.Decode(&matchResponse); err != nil {
+	match, err := tc.Queue(context.Background(), tcrclient.QueueOptions{
+		QueueType:         c.QueueType,
+		PreferredDuration: c.PreferredDuration,
+		OnRequeued:        c.showRequeued,
+	})
+	var setup *tcrclient.MatchSetupError
+	if errors.As(err, &setup) {
+		failure := setup.MatchSetupFailed
+		if failure.ActiveGameID != "" {
+			c.ActiveGameID = failure.ActiveGameID
+			return nil, fmt.Errorf("%w (game %s)", ErrAlreadyInGame, failure.ActiveGameID)
+		}
+		if c.ui != nil {
+			c.ui.DisplayStaticText(1, 7, fmt.Sprintf("Match setup failed: %s", failure.Reason), termbox.ColorRed, termbox.ColorBlack)
+		}
+		if failure.Retryable && failure.Prioritized {
+			return nil, fmt.Errorf("match setup failed, please try again; you will be matched first: %s", failure.Reason)
+		}
+		if failure.Retryable {
+			return nil, fmt.Errorf("match setup failed, please try again: %s", failure.Reason)
+		}
+		return nil, fmt.Errorf("match setup failed: %s", failure.Reason)
+	}
+	if err != nil {
 		if c.ui != nil {
 			c.ui.DisplayStaticText(1, 7, fmt.Sprintf("Error receiving match: %v", err), termbox.ColorRed, termbox.ColorBlack)
 		}
 		// log.Printf("Error receiving matchmaking response: %v", err)
 		return nil, err
 	}
-
-	if c.ui != nil {
-		// Message already displayed by main.go after this returns
-	}
 	// log.Printf("Match found! Opponent: %s, GameID: %s, UDP Port: %d, PlayerToken: %s, IsPlayerOne: %t",
-	// 	matchResponse.Opponent.Username, matchResponse.GameID, matchResponse.UDPPort, matchResponse.PlayerSessionToken, matchResponse.IsPlayerOne)
-
-	c.PlayerAccount.GameID = matchResponse.GameID
-	c.SessionToken = matchResponse.PlayerSessionToken // Store the session token
-	c.IsPlayerOne = matchResponse.IsPlayerOne         // Store if this client is player one
-	c.GameConfig = &matchResponse.GameConfig          // Store the game config
-
-	// Establish UDP connection
-	// TODO: Get server IP from config or a more robust mechanism
-	serverIP := "127.0.0.1" // Assuming localhost for now
-	err := c.EstablishUDPConnection(serverIP, matchResponse.UDPPort)
-	if err != nil {
-		// log.Printf("Failed to establish UDP connection: %v", err)
-		// Decide if this is a fatal error for matchmaking
-		return &matchResponse, fmt.Errorf("failed to establish UDP connection: %w", err)
-	}
-	// log.Printf("UDP connection established to %s:%d", serverIP, matchResponse.UDPPort)
-
-	// Start listening for UDP messages in a new goroutine
-	go c.ListenForUDPMessages()
-
-	// Start the resend manager goroutine
-	go c.manageResends()
-
-	// Start listening for TCP messages for game end results
-	go c.listenForTCPEndGameMessages()
-
-	return &matchResponse, nil
-}
-
-// manageResends periodically checks for unacknowledged deploy commands and resends them.
-// This should be run in a goroutine.
-func (c *Client) manageResends() {
-	ticker := time.NewTicker(500 * time.Millisecond) // Check every 500ms
-	defer ticker.Stop()
-
-	for range ticker.C {
-		c.mu.Lock()
-		for seq, unackedInfo := range c.unacknowledgedDeployCommands {
-			if time.Since(unackedInfo.SentAt) > ResendTimeout {
-				if unackedInfo.RetryCount < MaxResends {
-					// Resend the message
-					msgBytes, err := json.Marshal(unackedInfo.Message) // Re-marshal, could store bytes if preferred
-					if err != nil {
-						// log.Printf("Error re-marshalling message for resend (Seq: %d): %v", seq, err)
-						continue // Skip this one for now
-					}
-					_, err = c.UDPConn.Write(msgBytes)
-					if err != nil {
-						// log.Printf("Error resending deploy command (Seq: %d): %v", seq, err)
-						// Don't remove or increment retry count if send fails, try again next tick
-						continue
-					}
-
-					unackedInfo.SentAt = time.Now()
-					unackedInfo.RetryCount++
-					c.unacknowledgedDeployCommands[seq] = unackedInfo // Update the map
-					// log.Printf("Client: Resent DeployTroop command Seq: %d (Attempt: %d)", seq, unackedInfo.RetryCount)
-				} else {
-					// Max resends reached, give up
-					// log.Printf("Client: Max resends reached for DeployTroop command Seq: %d. Giving up.", seq)
-					delete(c.unacknowledgedDeployCommands, seq)
-					// Optionally, inform the UI or player that the command failed permanently
-					if c.ui != nil {
-						c.ui.AddEventMessage(fmt.Sprintf("Failed to deploy troop (Seq: %d) after max retries.", seq))
-						c.ui.Render()
-					}
-				}
-			}
-		}
-		c.mu.Unlock()
-
-		// Check if client UDP connection is still alive or if we should stop this goroutine
-		if c.UDPConn == nil {
-			// log.Println("Client manageResends: UDP connection is nil, stopping resend manager.")
-			return
-		}
-	}
+	// 	match.Opponent.Username, match.GameID, match.UDPPort, match.PlayerSessionToken, match.IsPlayerOne)
+	return c.beginGame(tc, match), nil
 }
 
-// listenForTCPEndGameMessages waits for game over results via TCP.
-// It should be run in a goroutine after a match is found.
-func (c *Client) listenForTCPEndGameMessages() {
-	if c.TCPConn == nil {
-		// log.Println("TCP connection is not established. Cannot listen for end game messages.")
+// showRequeued tells the player the server failed to set up a match and is still searching.
+func (c *Client) showRequeued(failure network.MatchSetupFailed) {
+	if c.ui == nil {
 		return
 	}
-	// log.Println("Starting to listen for TCP end game messages from server...")
-
-	decoder := json.NewDecoder(c.TCPConn)
-	for {
-		var msg network.TCPMessage
-		if err := decoder.Decode(&msg); err != nil {
-			// Check if the error is due to the connection being closed or EOF
-			if err == io.EOF || strings.Contains(err.Error(), "use of closed network connection") || strings.Contains(err.Error(), "reset by peer") {
-				// log.Println("TCP connection closed by server, EOF, or reset. Stopping TCP listener for game results.")
-				// Optionally inform the UI that the connection for results was lost
-				if c.ui != nil {
-					// c.ui.DisplayError("Connection lost while waiting for game results.")
-				}
-				return // Exit goroutine
-			}
-			// log.Printf("Error decoding TCP message: %v. TCP listener for game results might stop.", err)
-			return // Or handle error more gracefully
-		}
-
-		// log.Printf("Client: Received TCP Message: Type=%s", msg.Type)
-
-		switch msg.Type {
-		case network.MsgTypeGameOverResults:
-			payloadBytes, err := json.Marshal(msg.Payload) // Payload is interface{}, remarshal to parse into specific struct
-			if err != nil {
-				// log.Printf("Client: Error marshaling GameOverResults payload: %v", err)
-				continue
-			}
-			var results network.GameOverResults
-			if err := json.Unmarshal(payloadBytes, &results); err != nil {
-				// log.Printf("Client: Error unmarshalling GameOverResults: %v. Raw: %s", err, string(payloadBytes))
-				continue
-			}
-
-			// log.Printf("Client: Game Over! Outcome: %s, EXP Change: %d, New EXP: %d, New Level: %d, Leveled Up: %t",
-			// 	results.Outcome, results.EXPChange, results.NewEXP, results.NewLevel, results.LevelUp)
-
-			// Update client's own account details (EXP, Level)
-			if c.PlayerAccount != nil {
-				c.PlayerAccount.EXP = results.NewEXP
-				c.PlayerAccount.Level = results.NewLevel
-			}
-
-			if c.ui != nil {
-				c.ui.SetCurrentView(ViewGameOver) // Switch UI to game over view
-				c.ui.SetGameOverDetails(results)  // Pass results to UI to store
-				c.ui.Render()                     // Ensure UI is updated (Render will call DisplayGameOver)
-			}
-			// After processing game over, this goroutine can terminate as its job is done for this game.
-			// log.Println("Client: Processed GameOverResults. TCP listener for game results is stopping.")
-			return
-		default:
-			// log.Printf("Client: Received unhandled TCP message type during game: %s", msg.Type)
-		}
+	status := fmt.Sprintf("Match setup failed (%s). Still searching...", failure.Reason)
+	if failure.Prioritized {
+		status = fmt.Sprintf("Match setup failed (%s). You have been moved to the front of the queue...", failure.Reason)
 	}
+	c.ui.DisplayStaticText(1, 7, status, termbox.ColorYellow, termbox.ColorBlack)
 }
 
-// EstablishUDPConnection resolves the server's UDP address and prepares the UDPConn.
-// It doesn't "connect" in the TCP sense but sets up the remote address.
-func (c *Client) EstablishUDPConnection(serverIP string, udpPort int) error {
-	if c.UDPConn != nil {
-		// Close existing UDP connection if any, before creating a new one.
-		// This might be needed if the client could go through matchmaking multiple times.
-		c.UDPConn.Close()
-		c.UDPConn = nil
+// ReconnectWithUI rejoins the running game named by ActiveGameID, which a new login on a
+// server speaking network.ProtocolVersionReconnect reports. The server issues a new session
+// token for it; the one the lost client used no longer works.
+func (c *Client) ReconnectWithUI() (*network.MatchFoundResponse, error) {
+	tc := c.server()
+	if tc == nil || c.PlayerAccount == nil {
+		return nil, fmt.Errorf("client is not authenticated or connected")
+	}
+	if c.ProtocolVersion < network.ProtocolVersionReconnect {
+		return nil, fmt.Errorf("the server cannot rejoin running games (protocol version %d)", c.ProtocolVersion)
+	}
+	if c.ActiveGameID == "" {
+		return nil, fmt.Errorf("there is no running game to rejoin")
 	}
 
-	serverAddr := fmt.Sprintf("%s:%d", serverIP, udpPort)
-	raddr, err := net.ResolveUDPAddr("udp", serverAddr)
+	if c.ui != nil {
+		c.ui.DisplayStaticText(1, 5, "Rejoining your game...", termbox.ColorYellow, termbox.ColorBlack)
+	}
+	match, err := tc.Rejoin(context.Background())
+	c.ActiveGameID = ""
+	var refused *tcrclient.RejoinError
+	if errors.As(err, &refused) {
+		return nil, fmt.Errorf("server: %s", refused.Message)
+	}
 	if err != nil {
-		// log.Printf("Failed to resolve UDP server address %s: %v", serverAddr, err)
-		return err
+		return nil, err
 	}
-	c.ServerUDPAddr = raddr // Store the resolved remote address
 
-	// For a client, DialUDP can be used to set a default destination,
-	// allowing use of Read and Write. Or ListenUDP can be used to receive from any source
-	// and then SendTo to send to specific server.
-	// Using DialUDP here for simplicity if we assume most comms are with this server.
-	// If client needs to receive from other peers or multiple servers on same port, ListenUDP is better.
-	conn, err := net.DialUDP("udp", nil, raddr) // nil for local address, OS will pick
-	if err != nil {
-		// log.Printf("Failed to dial UDP for server %s: %v", serverAddr, err)
-		return err
+	found := c.beginGame(tc, match)
+	// The new socket has seen nothing of the game so far
+	if match.State != nil {
+		c.handleGameStateUpdate(*match.State)
+	} else {
+		c.RequestFullState()
 	}
-	c.UDPConn = conn
-	// log.Printf("UDP 'connection' established (DialUDP) to %s", serverAddr)
-	return nil
+	return found, nil
 }
 
-// SendDeployTroopCommand sends a request to the server to deploy a specific troop.
-func (c *Client) SendDeployTroopCommand(troopID string) error {
-	if c.UDPConn == nil || c.PlayerAccount == nil || c.PlayerAccount.GameID == "" || c.SessionToken == "" {
-		return fmt.Errorf("cannot send deploy troop command: client not in a valid game state")
+// prepareMatch sets the client up for the match described by a MatchFoundResponse, from
+// matchmaking or a reconnect, before its UDP session starts: it stores the session token
+// and opponent, resets the mana and alerts, and starts recording the game.
+func (c *Client) prepareMatch(match network.MatchFoundResponse) {
+	c.PlayerAccount.GameID = match.GameID
+	c.SessionToken = match.PlayerSessionToken // Store the session token
+	c.IsPlayerOne = match.IsPlayerOne         // Store if this client is player one
+	c.Opponent = &match.Opponent              // Store the opponent (level scales their towers)
+	c.Handicaps = match.Handicaps
+	c.mu.Lock()
+	c.serverConfig = false
+	c.mana = newManaPrediction()
+	c.mu.Unlock()
+	if c.ui != nil {
+		c.ui.Alerts().Reset() // Re-arm once-per-game alerts
 	}
+	c.startRecording()
+}
 
-	// Construct the payload
-	deployPayload := network.DeployTroopCommandUDP{
-		TroopID: troopID,
+// beginGame takes up the match tc found or rejoined once its UDP session runs: it falls
+// back to a default game config if the server's did not arrive, and waits for the results
+// in the background. It returns the match as the server described it.
+func (c *Client) beginGame(tc *tcrclient.Client, match *tcrclient.Match) *network.MatchFoundResponse {
+	if match.Config == nil {
+		c.useDefaultConfig()
 	}
+	session := tc.Session() // nil if the match already ended
+	c.mu.Lock()
+	c.session = session
+	c.mu.Unlock()
+	if session != nil {
+		c.ServerUDPAddr = session.RemoteAddr()
+	}
+	go func() {
+		defer RecoverCrash()
+		c.awaitResults(tc, match.GameID)
+	}()
+	found := match.MatchFoundResponse
+	return &found
+}
+
+// setGameConfig stores the game config the server sent for the current match; the next
+// state update redraws with it.
+func (c *Client) setGameConfig(cfg *models.GameConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.GameConfig = cfg
+	c.serverConfig = true
+}
 
+// useDefaultConfig stands in for a game config the server did not send within
+// gameConfigWaitTimeout: the config fetched before the match, or else the client's local
+// copy of the config files, so the game can still be played. A config arriving later
+// replaces it.
+func (c *Client) useDefaultConfig() {
+	// log.Printf("Warning: no game config from the server within %v; using the local defaults.", gameConfigWaitTimeout)
+	if c.ui != nil {
+		c.ui.AddEventMessage(LogSystem, fmt.Sprintf("Warning: no game config from the server within %v; using local defaults.", gameConfigWaitTimeout))
+	}
 	c.mu.Lock()
-	currentSeq := c.nextSequenceNumber
-	c.nextSequenceNumber++
+	defaults := c.lobbyConfig // The server's own config from before the match, if we fetched it
 	c.mu.Unlock()
+	if defaults == nil {
+		defaults = &models.GameConfig{}
+		if towers, err := persistence.LoadTowerConfig(); err == nil {
+			defaults.Towers = towers
+		}
+		if troops, err := persistence.LoadTroopConfig(); err == nil {
+			defaults.Troops = troops
+		}
+		_ = defaults.Normalize()
+	}
+	c.mu.Lock()
+	if !c.serverConfig { // Still missing; don't overwrite one that just arrived
+		c.GameConfig = defaults
+	}
+	c.mu.Unlock()
+}
 
-	// Construct the main UDP message
-	udpMsg := network.UDPMessage{
-		Seq:         currentSeq,
-		Timestamp:   time.Now(),
-		SessionID:   c.PlayerAccount.GameID,
-		PlayerToken: c.SessionToken,
-		Type:        network.UDPMsgTypeDeployTroop,
-		Payload:     deployPayload,
+// deployGivenUp tells the player the deploy with Seq seq was never acknowledged.
+func (c *Client) deployGivenUp(seq uint32) {
+	if c.ui != nil {
+		c.ui.AddEventMessage(LogError, fmt.Sprintf("Failed to deploy troop (Seq: %d) after max retries.", seq))
+		c.ui.RequestRender()
 	}
+}
 
-	// Serialize the message
-	msgBytes, err := json.Marshal(udpMsg)
-	if err != nil {
-		// log.Printf("Error marshalling deploy troop command: %v", err)
-		return err
+// awaitResults waits for the end of game gameID and shows how it ended. The connection has
+// already applied the EXP and level of its results to PlayerAccount.
+func (c *Client) awaitResults(tc *tcrclient.Client, gameID string) {
+	results, err := tc.Results(context.Background())
+	c.EndGame()
+	var cancelled *tcrclient.MatchCancelledError
+	switch {
+	case errors.As(err, &cancelled):
+		// The session was torn down before it started
+		if c.ui != nil {
+			message := fmt.Sprintf("Match cancelled: %s. Press ESC to exit.", cancelled.Reason)
+			if cancelled.Prioritized {
+				message = fmt.Sprintf("Match cancelled: %s. You will be matched first when you search again. Press ESC to exit.", cancelled.Reason)
+			}
+			c.ui.AddEventMessage(LogSystem, message)
+			c.ui.RequestRender()
+		}
+	case err != nil:
+		// log.Printf("Connection lost while waiting for game results: %v", err)
+	case c.ui != nil:
+		// log.Printf("Client: Game Over! Outcome: %s, EXP Change: %d, New EXP: %d, New Level: %d, Leveled Up: %t",
+		// 	results.Outcome, results.EXPChange, results.NewEXP, results.NewLevel, results.LevelUp)
+		if results.GameID != "" && results.GameID != gameID {
+			// Results for another game, e.g. delivered late, may be older than the account
+			c.ui.AddEventMessage(LogSystem, fmt.Sprintf("These results are for another game (%s); your profile was not updated from them.", results.GameID))
+		}
+		c.ui.Alerts().Trigger(AlertGameOver, fmt.Sprintf("Game over: %s", results.Outcome))
+		c.ui.SetGameOverDetails(results)  // Pass results to UI to store, before the view switch draws them
+		c.ui.SetCurrentView(ViewGameOver) // Switch UI to game over view; this redraws right away
+	}
+}
+
+// EndGame finishes the game's recording. The game's UDP listener and resender stop on
+// their own when its results arrive, or when CloseConnections closes the connection.
+// It is safe to call more than once and when no game is running.
+func (c *Client) EndGame() {
+	if path := c.recorder.Stop(); path != "" && c.ui != nil {
+		c.ui.AddEventMessage(LogSystem, "Game recorded to "+path)
 	}
+}
 
-	// Send the message
-	_, err = c.UDPConn.Write(msgBytes)
+// SendDeployTroopCommand sends a request to the server to deploy a specific troop, into lane
+// in a two-lane game ("" otherwise). The session resends it until the server acknowledges it.
+func (c *Client) SendDeployTroopCommand(troopID, lane string) error {
+	session := c.udp()
+	if session == nil {
+		return fmt.Errorf("cannot send deploy troop command: client not in a valid game state")
+	}
+	seq, err := session.Deploy(troopID, lane)
 	if err != nil {
 		// log.Printf("Error sending deploy troop command over UDP: %v", err)
-		// Note: If Write fails, we might not add to unacknowledgedDeployCommands
-		// or we add it and let the resend mechanism handle it if it was a temporary issue.
-		// For now, let's assume if Write fails, it's a more significant issue and don't track for resend.
 		return err
 	}
 
-	// Track for acknowledgment
+	// Show the cost in the HUD now; a rejection gives it back (see rejectDeploy)
 	c.mu.Lock()
-	c.unacknowledgedDeployCommands[currentSeq] = UnackedDeployInfo{
-		Message:    udpMsg,
-		SentAt:     time.Now(), // Record time after successful send
-		RetryCount: 0,
+	if c.GameConfig != nil {
+		c.mana.Deduct(seq, c.GameConfig.Troops[troopID].ManaCost)
 	}
+	predictedMana := c.mana.Mana()
 	c.mu.Unlock()
+	if c.ui != nil {
+		c.ui.SetMyMana(predictedMana)
+	}
 
-	// log.Printf("Sent deploy troop command for TroopID: %s, Seq: %d", troopID, currentSeq)
+	// log.Printf("Sent deploy troop command for TroopID: %s, Seq: %d", troopID, seq)
 	return nil
 }
 
 // SendPlayerQuitMessage informs the server that the client is quitting the game.
 func (c *Client) SendPlayerQuitMessage() error {
-	if c.UDPConn == nil || c.PlayerAccount == nil || c.PlayerAccount.GameID == "" {
-		// log.Println("Cannot send quit message: UDP not connected, not authenticated, or no game ID.")
+	session := c.udp()
+	if session == nil {
+		// log.Println("Cannot send quit message: not in a game.")
 		return fmt.Errorf("client not in a state to send quit message")
 	}
-
-	quitMsg := network.UDPMessage{
-		// Seq: Sequence numbers might be useful here if reliable quit is critical
-		Timestamp:   time.Now(),
-		SessionID:   c.PlayerAccount.GameID,
-		PlayerToken: c.PlayerAccount.Username, // Or a specific session token if used
-		Type:        network.UDPMsgTypePlayerQuit,
-		Payload:     network.PlayerQuitUDP{}, // Empty payload for now
-	}
-
-	jsonData, err := json.Marshal(quitMsg)
-	if err != nil {
-		// log.Printf("Error marshalling PlayerQuitUDP message: %v", err)
-		return err
-	}
-
 	// log.Printf("Sending PlayerQuitUDP message for session %s", c.PlayerAccount.GameID)
-	_, err = c.UDPConn.Write(jsonData)
-	if err != nil {
-		// log.Printf("Error sending PlayerQuitUDP message: %v", err)
-		return err
-	}
-	return nil
+	_, err := session.Send(network.UDPMsgTypePlayerQuit, network.PlayerQuitUDP{})
+	return err
 }
 
-// SendBasicUDPMessage sends a simple string message over UDP to the game server's assigned UDP port.
-// This function seems to be for a basic ping and creates its own temporary connection.
-// For game state, we'll likely use the persistent c.UDPConn.
-func (c *Client) SendBasicUDPMessage(gameID string, playerToken string, udpPort int, message string) (string, error) {
-	if c.PlayerAccount == nil {
-		return "", fmt.Errorf("player not authenticated")
-	}
+// SendSurrender concedes the current game. The server ends it at once and reports the
+// result over TCP like any other game end. Surrender is not acknowledged, so if the
+// datagram is lost the game simply continues and the player can surrender again.
+func (c *Client) SendSurrender() error {
+	session := c.udp()
+	if session == nil {
+		return fmt.Errorf("client not in a state to surrender")
+	}
+	// log.Printf("Sending SurrenderUDP message for session %s", c.PlayerAccount.GameID)
+	_, err := session.Send(network.UDPMsgTypeSurrender, network.SurrenderUDP{})
+	return err
+}
 
-	serverAddr := fmt.Sprintf("localhost:%d", udpPort)
-	remoteAddr, err := net.ResolveUDPAddr("udp", serverAddr)
-	if err != nil {
-		return "", fmt.Errorf("failed to resolve UDP server address %s: %v", serverAddr, err)
+// RequestFullState asks the server for a full state update at once, for when this client's
+// view of the game may be wrong: after lost packets, an event about a troop it does not
+// know, or rejoining the game. The server answers at most once per
+// network.FullStateRequestInterval, so a request within that time of the last one is not
+// sent and RequestFullState returns false.
+func (c *Client) RequestFullState() (bool, error) {
+	session := c.udp()
+	if session == nil {
+		return false, fmt.Errorf("client not in a game")
 	}
+	if c.ProtocolVersion < network.ProtocolVersionFullState {
+		return false, fmt.Errorf("the server cannot resend the game state (protocol version %d)", c.ProtocolVersion)
+	}
+	return session.RequestFullState()
+}
 
-	// Establish UDP connection if not already done or if port changed (simple case: always dial)
-	// A more robust client might maintain c.UDPConn across calls if appropriate.
-	conn, err := net.DialUDP("udp", nil, remoteAddr) // nil for local address, OS will pick
-	if err != nil {
-		return "", fmt.Errorf("failed to dial UDP %s: %v", serverAddr, err)
+// CheckUDPConnectivity sends a ping over the game socket to the session's UDP port and
+// waits up to timeout for the pong, returning the round-trip time. Because the ping leaves
+// from the same socket as every later command, the session also learns the right address
+// for this player. The game's UDP listener must be running to receive the pong.
+func (c *Client) CheckUDPConnectivity(timeout time.Duration) (time.Duration, error) {
+	session := c.udp()
+	if session == nil {
+		return 0, errNotInGame
 	}
-	defer conn.Close() // Close this specific connection after use
-	// c.UDPConn = conn   // DO NOT OVERWRITE THE MAIN GAME UDP CONNECTION
+	return session.Ping(timeout)
+}
 
-	// log.Printf("Sending UDP message to %s: %s", serverAddr, message)
-	udpPDU := network.UDPMessage{
-		// Seq: We are not tracking sequence numbers in this basic send yet
-		Timestamp:   time.Now(),
-		SessionID:   gameID,
-		PlayerToken: playerToken,  // Could be c.PlayerAccount.Username or a session-specific token
-		Type:        "basic_ping", // Example type
-		Payload:     message,
+// ProbeUDP checks that the game's UDP port answers, pinging it with CheckUDPConnectivity up
+// to attempts times. Dialling a UDP socket succeeds even when a firewall drops everything
+// sent to the port, so only a pong proves the game can be played. If no pong comes back it
+// returns a *UDPUnreachableError, which ReportUDPUnreachable passes on to the server.
+func (c *Client) ProbeUDP(attempts int, timeout time.Duration) (time.Duration, error) {
+	var err error
+	for i := 0; i < attempts; i++ {
+		var rtt time.Duration
+		if rtt, err = c.CheckUDPConnectivity(timeout); err == nil {
+			return rtt, nil
+		}
+		if errors.Is(err, errNotInGame) {
+			return 0, err
+		}
 	}
-	jsonData, jsonErr := json.Marshal(udpPDU)
-	if jsonErr != nil {
-		return "", fmt.Errorf("failed to marshal UDP PDU: %v", jsonErr)
+	port := 0
+	if session := c.udp(); session != nil {
+		port = session.RemoteAddr().Port
 	}
+	return 0, &UDPUnreachableError{Port: port, Attempts: attempts, Err: err}
+}
 
-	_, err = conn.Write(jsonData)
-	if err != nil {
-		return "", fmt.Errorf("failed to send UDP message: %v", err)
+// ReportUDPUnreachable tells the server over TCP that the game's UDP port is unreachable,
+// so it cancels the match for both players; the MsgTypeMatchCancelled that follows ends the
+// game here. It reports whether the server was told: one speaking a protocol version before
+// network.ProtocolVersionUDPUnreachable does not listen, and the opponent is left waiting.
+func (c *Client) ReportUDPUnreachable(unreachable *UDPUnreachableError) (bool, error) {
+	tc := c.server()
+	if tc == nil || c.PlayerAccount == nil {
+		return false, fmt.Errorf("client is not authenticated or connected")
 	}
+	return tc.ReportUDPUnreachable(context.Background(), unreachable.Port, unreachable.Attempts)
+}
 
-	// Wait for a response (simple echo)
-	buffer := make([]byte, 1024)
-	conn.SetReadDeadline(time.Now().Add(5 * time.Second)) // Timeout for response
-	n, _, err := conn.ReadFromUDP(buffer)                 // Read from the connected UDP socket
-	if err != nil {
-		return "", fmt.Errorf("failed to read UDP response: %v", err)
-	}
+// rejectDeploy handles the server rejecting the deploy with Seq seq, which the session no
+// longer resends: the predicted mana is reconciled with mana, the server's figure at the
+// time of the rejection. It returns the mana to show.
+func (c *Client) rejectDeploy(seq uint32, mana int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mana.Reconcile(seq, mana)
+	return c.mana.Mana()
+}
 
-	responsePayload := string(buffer[:n])
-	// log.Printf("Received UDP response: %s", responsePayload)
-	return responsePayload, nil
+// reconcileMana applies the mana from a state update whose watermark for this player is
+// watermark, and returns the mana to show.
+func (c *Client) reconcileMana(watermark uint32, mana int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mana.Reconcile(watermark, mana)
+	return c.mana.Mana()
 }
 
 // Authenticate is the old method, preserved for now if needed or for non-UI contexts.
@@ -538,25 +727,6 @@ func (c *Client) Authenticate() (*models.PlayerAccount, error) {
 	return c.authenticateWithConsole()
 }
 
-// RequestMatchmaking is the old method, preserved for now if needed or for non-UI contexts.
-func (c *Client) RequestMatchmaking() (*network.MatchFoundResponse, error) {
-	// This is a simplified version. The new RequestMatchmakingWithUI is preferred.
-	if c.TCPConn == nil || c.PlayerAccount == nil {
-		return nil, fmt.Errorf("client is not authenticated or connected")
-	}
-	// log.Println("Waiting for match (console mode)...")
-	decoder := json.NewDecoder(c.TCPConn)
-	var matchResponse network.MatchFoundResponse
-	if err := decoder.Decode(&matchResponse); err != nil {
-		// log.Printf("Error receiving matchmaking response (console): %v", err)
-		return nil, err
-	}
-	// log.Printf("Match found (console)! Opponent: %s, GameID: %s, UDP Port: %d",
-	// 	matchResponse.Opponent.Username, matchResponse.GameID, matchResponse.UDPPort)
-	c.PlayerAccount.GameID = matchResponse.GameID
-	return &matchResponse, nil
-}
-
 // Add to PlayerAccount in models/player.go: GameID string `json:"game_id,omitempty"`
 
 // ListenForUDPMessages was moved to network_handler.go
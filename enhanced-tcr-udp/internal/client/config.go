@@ -0,0 +1,64 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"enhanced-tcr-udp/pkg/tcrclient"
+)
+
+// DefaultClientConfigPath is where the client looks for its config file unless told otherwise.
+const DefaultClientConfigPath = "client_config.json"
+
+// ClientConfig holds per-user client preferences. Every field is optional in the file;
+// anything missing keeps its default.
+type ClientConfig struct {
+	Alerts AlertConfig            `json:"alerts"`
+	Resend tcrclient.ResendConfig `json:"resend"`
+	Keys   map[string]string      `json:"keys"` // Action name -> key, overriding DefaultKeymap (e.g. "deploy_pawn": "a")
+}
+
+// AlertConfig controls the alert banner and terminal bell.
+type AlertConfig struct {
+	Bell               bool               `json:"bell"`                 // Ring the terminal bell with each alert
+	BannerSeconds      int                `json:"banner_seconds"`       // How long the banner stays up
+	KingHPThreshold    float64            `json:"king_hp_threshold"`    // Alert once your King Tower drops below this fraction of max HP
+	TimeWarningSeconds int                `json:"time_warning_seconds"` // Alert once this many seconds remain
+	Enabled            map[AlertKind]bool `json:"enabled"`              // Per-alert switch; kinds not listed are enabled
+}
+
+// DefaultClientConfig returns the configuration used when no config file exists.
+func DefaultClientConfig() ClientConfig {
+	return ClientConfig{
+		Alerts: AlertConfig{
+			Bell:               true,
+			BannerSeconds:      3,
+			KingHPThreshold:    0.25,
+			TimeWarningSeconds: 30,
+			Enabled:            map[AlertKind]bool{},
+		},
+		Resend: tcrclient.DefaultResendConfig(),
+	}
+}
+
+// LoadClientConfig reads the config file at path over the defaults.
+// A missing file is not an error: the defaults are returned.
+func LoadClientConfig(path string) (ClientConfig, error) {
+	cfg := DefaultClientConfig()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("reading client config %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return DefaultClientConfig(), fmt.Errorf("parsing client config %s: %w", path, err)
+	}
+	if cfg.Alerts.Enabled == nil {
+		cfg.Alerts.Enabled = map[AlertKind]bool{}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"enhanced-tcr-udp/pkg/network"
+
+	"github.com/nsf/termbox-go"
+)
+
+// CrashLogLines is how many log lines a crash report includes; the LogRing given to
+// NewCrashReport should keep at least this many.
+const CrashLogLines = 50
+
+// CrashReport describes a client panic. It is written to a local file for the player to
+// attach to a bug report; nothing is sent anywhere.
+type CrashReport struct {
+	Time            time.Time         `json:"time"`
+	Panic           string            `json:"panic"`
+	Stack           string            `json:"stack"`
+	ClientVersion   string            `json:"client_version"`
+	ProtocolVersion int               `json:"protocol_version"`
+	GoVersion       string            `json:"go_version"`
+	Platform        string            `json:"platform"`
+	Log             []string          `json:"log"`                       // The last CrashLogLines log lines, oldest first
+	RecentMessages  []json.RawMessage `json:"recent_messages,omitempty"` // The last captured messages, when capturing was on
+}
+
+// NewCrashReport builds the report for a recovered panic value and the stack of the
+// goroutine that panicked. logs and capture may be nil.
+func NewCrashReport(value interface{}, stack []byte, logs *LogRing, capture *network.Capture) CrashReport {
+	lines := logs.Lines()
+	if len(lines) > CrashLogLines {
+		lines = lines[len(lines)-CrashLogLines:]
+	}
+	return CrashReport{
+		Time:            time.Now(),
+		Panic:           fmt.Sprint(value),
+		Stack:           string(stack),
+		ClientVersion:   ClientVersion(),
+		ProtocolVersion: network.ProtocolVersion,
+		GoVersion:       runtime.Version(),
+		Platform:        runtime.GOOS + "/" + runtime.GOARCH,
+		Log:             lines,
+		RecentMessages:  capture.Recent(),
+	}
+}
+
+// crash holds what RecoverCrash does with a recovered panic; see SetCrashHandler.
+var crash struct {
+	mu      sync.Mutex // Held while a crash is handled, so a second panic waits for the first
+	handler func(value interface{}, stack []byte)
+}
+
+// SetCrashHandler sets what RecoverCrash does with a recovered panic once the terminal is
+// restored, typically writing a crash report and exiting. Without a handler (nil) the panic
+// is raised again.
+func SetCrashHandler(handler func(value interface{}, stack []byte)) {
+	crash.mu.Lock()
+	defer crash.mu.Unlock()
+	crash.handler = handler
+}
+
+// RecoverCrash recovers a panic in the goroutine that defers it, tears down termbox so
+// the player can read stderr again, and passes the panic and its stack to the crash
+// handler. Every goroutine the client starts defers it: a panic in any of them would
+// otherwise end the process with the terminal left in raw mode and no report written.
+func RecoverCrash() {
+	value := recover()
+	if value == nil {
+		return
+	}
+	stack := debug.Stack()
+	crash.mu.Lock()
+	defer crash.mu.Unlock()
+	if crash.handler == nil {
+		panic(value)
+	}
+	termbox.Close()
+	crash.handler(value, stack)
+}
+
+// WriteCrashReport writes report as JSON to a new file in dir and returns its path.
+func WriteCrashReport(dir string, report CrashReport) (string, error) {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(dir, "tcr-client-crash-"+report.Time.Format("20060102-150405")+"-*.json")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return filepath.Abs(f.Name())
+}
+
+// ClientVersion describes the build of the running client: its module version and, when
+// built from a checkout, the commit.
+func ClientVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := info.Main.Version
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			if setting.Value == "true" {
+				modified = "+dirty"
+			}
+		}
+	}
+	if revision != "" {
+		version += " (" + revision + modified + ")"
+	}
+	return version
+}
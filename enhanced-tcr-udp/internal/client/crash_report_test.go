@@ -0,0 +1,72 @@
+package client
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// explode panics the way a bug in one of the client's goroutines would.
+func explode() {
+	var troops map[string]int
+	troops["pawn"]++
+}
+
+func TestRecoverCrashReportsGoroutinePanic(t *testing.T) {
+	logs := NewLogRing(CrashLogLines)
+	logs.Write([]byte("Deploying pawn\nDeploying knight\n"))
+	dir := t.TempDir()
+	written := make(chan string, 1)
+	SetCrashHandler(func(value interface{}, stack []byte) {
+		path, err := WriteCrashReport(dir, NewCrashReport(value, stack, logs, nil))
+		if err != nil {
+			t.Errorf("WriteCrashReport: %v", err)
+		}
+		written <- path
+	})
+	t.Cleanup(func() { SetCrashHandler(nil) })
+
+	go func() {
+		defer RecoverCrash()
+		explode()
+	}()
+	var path string
+	select {
+	case path = <-written:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the panic never reached the crash handler")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading the crash report: %v", err)
+	}
+	var report CrashReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("the crash report is not valid JSON: %v\n%s", err, data)
+	}
+	if !strings.Contains(report.Panic, "assignment to entry in nil map") {
+		t.Errorf("Panic = %q, want the runtime error", report.Panic)
+	}
+	if !strings.Contains(report.Stack, "client.explode") {
+		t.Errorf("Stack does not show the panicking goroutine's frames:\n%s", report.Stack)
+	}
+	if want := []string{"Deploying pawn", "Deploying knight"}; strings.Join(report.Log, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Log = %q, want %q", report.Log, want)
+	}
+	if report.Time.IsZero() || report.ClientVersion == "" || report.GoVersion == "" || report.Platform == "" || report.ProtocolVersion == 0 {
+		t.Errorf("report is missing build details: %+v", report)
+	}
+}
+
+func TestRecoverCrashWithoutHandlerPanicsAgain(t *testing.T) {
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Errorf("recovered %v, want the original panic raised again", r)
+		}
+	}()
+	defer RecoverCrash()
+	panic("boom")
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"strings"
+	"sync"
+)
+
+// LogCategory groups event log lines so the player can hide the noisy ones.
+type LogCategory string
+
+const (
+	LogCombat LogCategory = "combat" // Damage, crits, heals, destroyed towers and defeated troops
+	LogDeploy LogCategory = "deploy" // Troop deployments by either player
+	LogSystem LogCategory = "system" // Match and connection notices
+	LogChat   LogCategory = "chat"
+	LogError  LogCategory = "error"
+)
+
+// LogSide says which player's troops or towers a log line reports acting, so the line can
+// be coloured for that side.
+type LogSide int
+
+const (
+	SideNone   LogSide = iota // Not about either player's troops or towers
+	SideMine                  // This player's troop or tower acted
+	SideTheirs                // The opponent's troop or tower acted
+)
+
+// LogCategories lists every category in the order the log header shows them.
+var LogCategories = []LogCategory{LogCombat, LogDeploy, LogSystem, LogChat, LogError}
+
+// eventLogHistorySize is how many lines the log keeps, shown or not, so that
+// turning a category back on brings back its recent lines.
+const eventLogHistorySize = 200
+
+// LogEntry is one event log line.
+type LogEntry struct {
+	Category LogCategory
+	Side     LogSide
+	Text     string
+}
+
+// LogModel holds the event log history and the category filter. It has no termbox
+// dependency; the UI only asks it which lines to draw.
+type LogModel struct {
+	mu      sync.Mutex
+	history []LogEntry
+	limit   int
+	hidden  map[LogCategory]bool
+}
+
+// NewLogModel creates a log that keeps the last limit entries.
+func NewLogModel(limit int) *LogModel {
+	if limit < 1 {
+		limit = 1
+	}
+	return &LogModel{
+		history: make([]LogEntry, 0, limit),
+		limit:   limit,
+		hidden:  make(map[LogCategory]bool),
+	}
+}
+
+// Add appends a line, dropping the oldest once the history is full.
+func (m *LogModel) Add(category LogCategory, text string) {
+	m.AddSided(category, SideNone, text)
+}
+
+// AddSided appends a line about one side's troops or towers.
+func (m *LogModel) AddSided(category LogCategory, side LogSide, text string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.history) >= m.limit {
+		m.history = m.history[1:]
+	}
+	m.history = append(m.history, LogEntry{Category: category, Side: side, Text: text})
+}
+
+// Toggle shows or hides a category and reports whether it is now shown.
+func (m *LogModel) Toggle(category LogCategory) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hidden[category] = !m.hidden[category]
+	return !m.hidden[category]
+}
+
+// Shown reports whether a category's lines are displayed.
+func (m *LogModel) Shown(category LogCategory) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return !m.hidden[category]
+}
+
+// Visible returns up to n of the most recent lines whose category is shown, oldest first.
+func (m *LogModel) Visible(n int) []LogEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []LogEntry
+	for i := len(m.history) - 1; i >= 0 && len(out) < n; i-- {
+		if !m.hidden[m.history[i].Category] {
+			out = append(out, m.history[i])
+		}
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// Len returns the number of lines in the history, including hidden ones.
+func (m *LogModel) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.history)
+}
+
+// FilterLabel describes the filter for the log header, e.g. "combat deploy -system chat error",
+// where a leading '-' marks a hidden category.
+func (m *LogModel) FilterLabel() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	parts := make([]string, 0, len(LogCategories))
+	for _, category := range LogCategories {
+		if m.hidden[category] {
+			parts = append(parts, "-"+string(category))
+		} else {
+			parts = append(parts, string(category))
+		}
+	}
+	return strings.Join(parts, " ")
+}
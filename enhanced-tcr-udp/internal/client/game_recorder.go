@@ -0,0 +1,136 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// DefaultReplayDir is where a GameRecorder saves games unless told otherwise.
+const DefaultReplayDir = "replays"
+
+// recorderQueueSize is how many messages may wait for the recorder's writer before more
+// are dropped.
+const recorderQueueSize = 1024
+
+// recordedMessage is a message waiting to be written by a GameRecorder.
+type recordedMessage struct {
+	at        time.Time
+	direction string // network.CaptureSent or network.CaptureReceived
+	data      []byte
+}
+
+// GameRecorder saves the UDP messages of each game the client plays, in both directions,
+// to a capture file of its own (see network.Capture), which network.ReadCapture reads back.
+// Recording never holds up the network goroutines: messages are queued for a writer
+// goroutine, and dropped with a warning if it falls behind. A nil *GameRecorder records
+// nothing. It is safe for concurrent use.
+type GameRecorder struct {
+	dir  string
+	warn func(message string) // Tells the player about a problem with the recording; may be nil
+
+	mu      sync.Mutex
+	queue   chan recordedMessage // nil while no game is being recorded
+	done    chan struct{}        // Closed once the writer has closed the file
+	path    string
+	dropped int
+}
+
+// NewGameRecorder creates a recorder saving games under dir. warn, if not nil, is told when
+// a recording cannot be made or loses messages.
+func NewGameRecorder(dir string, warn func(message string)) *GameRecorder {
+	return &GameRecorder{dir: dir, warn: warn}
+}
+
+// Start begins recording a game against opponent that started at now, ending the recording
+// of any previous game. The file is named by the start time and the opponent, e.g.
+// replays/2026-10-16_153000_vs_bob.capture.jsonl. It returns the file's path.
+func (r *GameRecorder) Start(opponent string, now time.Time) (string, error) {
+	if r == nil {
+		return "", nil
+	}
+	r.Stop()
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(r.dir, fmt.Sprintf("%s_vs_%s.capture.jsonl", now.Format("2006-01-02_150405"), replayFileName(opponent)))
+	capture, err := network.OpenCapture(path)
+	if err != nil {
+		return "", err
+	}
+	queue, done := make(chan recordedMessage, recorderQueueSize), make(chan struct{})
+	go func() {
+		defer close(done)
+		defer RecoverCrash()
+		for m := range queue {
+			capture.RecordAt(m.at, m.direction, network.CaptureUDP, m.data)
+		}
+		if err := capture.Close(); err != nil && r.warn != nil {
+			r.warn(fmt.Sprintf("Could not save the game recording %s: %v", path, err))
+		}
+	}()
+
+	r.mu.Lock()
+	r.queue, r.done, r.path, r.dropped = queue, done, path, 0
+	r.mu.Unlock()
+	return path, nil
+}
+
+// Record queues a UDP message sent or received during the game for the recording. It never
+// blocks; if the writer has fallen behind the message is dropped, and the first drop of the
+// game is reported to warn.
+func (r *GameRecorder) Record(direction string, data []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.queue == nil {
+		return
+	}
+	select {
+	case r.queue <- recordedMessage{at: time.Now(), direction: direction, data: append([]byte(nil), data...)}:
+	default:
+		r.dropped++
+		if r.dropped == 1 && r.warn != nil {
+			r.warn("Game recording is falling behind; some messages are missing from it.")
+		}
+	}
+}
+
+// Stop ends the current recording, waiting for the queued messages to be written, and
+// returns the file's path; "" if nothing was being recorded. The path of a recording that
+// lost messages is still returned.
+func (r *GameRecorder) Stop() string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	queue, done, path := r.queue, r.done, r.path
+	r.queue, r.done, r.path = nil, nil, ""
+	r.mu.Unlock()
+	if queue == nil {
+		return ""
+	}
+	close(queue)
+	<-done
+	return path
+}
+
+// replayFileName makes a username safe to use in a file name.
+func replayFileName(name string) string {
+	if name == "" {
+		return "unknown"
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,156 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// Names of the steps of RunHealthCheck, in the order they run.
+const (
+	HealthStepTCPConnect = "TCP connect"
+	HealthStepHealthPing = "Health ping"
+	HealthStepUDPCheck   = "UDP round trip"
+)
+
+// errHealthStepSkipped is the error of a step that could not run because an earlier one failed.
+var errHealthStepSkipped = errors.New("skipped")
+
+// HealthCheckStep is the outcome of one step of RunHealthCheck.
+type HealthCheckStep struct {
+	Name    string
+	Latency time.Duration // How long the step took; 0 if it did not run
+	Detail  string        // What the step found out, e.g. the server's protocol version
+	Err     error         // nil if the step passed
+}
+
+// HealthCheckPassed reports whether every step passed.
+func HealthCheckPassed(steps []HealthCheckStep) bool {
+	for _, step := range steps {
+		if step.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// RunHealthCheck checks that the server at serverAddr can be reached, without logging in or
+// starting the UI: it connects over TCP, sends a MsgTypeHealthPing, and sends a UDP check
+// datagram to the port the server's HealthPong names. Each step waits at most timeout. A
+// step that depends on one that failed is reported as skipped.
+func RunHealthCheck(serverAddr string, timeout time.Duration) []HealthCheckStep {
+	steps := []HealthCheckStep{{Name: HealthStepTCPConnect}, {Name: HealthStepHealthPing}, {Name: HealthStepUDPCheck}}
+	skipFrom := func(i int) []HealthCheckStep {
+		for ; i < len(steps); i++ {
+			steps[i].Err = errHealthStepSkipped
+		}
+		return steps
+	}
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", serverAddr, timeout)
+	steps[0].Latency = time.Since(start)
+	if err != nil {
+		steps[0].Err = err
+		return skipFrom(1)
+	}
+	defer conn.Close()
+	steps[0].Detail = conn.RemoteAddr().String()
+
+	start = time.Now()
+	pong, err := healthPing(conn, timeout)
+	steps[1].Latency = time.Since(start)
+	if err != nil {
+		steps[1].Err = err
+		return skipFrom(2)
+	}
+	steps[1].Detail = fmt.Sprintf("protocol version %d", pong.ProtocolVersion)
+	if pong.UDPCheckPort == 0 {
+		steps[2].Err = errors.New("the server has no UDP check port")
+		return steps
+	}
+
+	host := pong.UDPCheckHost
+	if host == "" { // Same host as TCP, as for game UDP
+		host, _, _ = net.SplitHostPort(serverAddr)
+	}
+	udpAddr := net.JoinHostPort(host, strconv.Itoa(pong.UDPCheckPort))
+	steps[2].Detail = udpAddr
+	start = time.Now()
+	steps[2].Err = udpRoundTrip(udpAddr, timeout)
+	steps[2].Latency = time.Since(start)
+	return steps
+}
+
+// healthPing sends a MsgTypeHealthPing on conn and reads the server's HealthPong.
+func healthPing(conn net.Conn, timeout time.Duration) (network.HealthPong, error) {
+	var pong network.HealthPong
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return pong, err
+	}
+	if err := json.NewEncoder(conn).Encode(network.TCPMessage{Type: network.MsgTypeHealthPing}); err != nil {
+		return pong, err
+	}
+	var reply struct {
+		Type    string          `json:"type"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.NewDecoder(conn).Decode(&reply); err != nil {
+		return pong, err
+	}
+	if reply.Type != network.MsgTypeHealthPong {
+		// Older servers take the ping for a failed login
+		return pong, errors.New("the server does not answer health pings; it may be too old")
+	}
+	if err := json.Unmarshal(reply.Payload, &pong); err != nil {
+		return pong, fmt.Errorf("malformed health pong: %w", err)
+	}
+	return pong, nil
+}
+
+// udpRoundTrip sends a check datagram to addr and waits for the same datagram to come back.
+func udpRoundTrip(addr string, timeout time.Duration) error {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	probe := []byte(network.UDPCheckPrefix + hex.EncodeToString(nonce))
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	if _, err := conn.Write(probe); err != nil {
+		return err
+	}
+	buf := make([]byte, 512)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return fmt.Errorf("no answer within %v; a firewall may block UDP", timeout)
+			}
+			return err
+		}
+		if bytes.Equal(buf[:n], probe) {
+			return nil
+		}
+	}
+}
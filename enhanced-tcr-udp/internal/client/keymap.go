@@ -0,0 +1,255 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nsf/termbox-go"
+)
+
+// Action is something the player can do with a key during a game.
+type Action string
+
+const (
+	ActionDeployPawn    Action = "deploy_pawn"
+	ActionDeployBishop  Action = "deploy_bishop"
+	ActionDeployRook    Action = "deploy_rook"
+	ActionDeployKnight  Action = "deploy_knight"
+	ActionDeployPrince  Action = "deploy_prince"
+	ActionDeployQueen   Action = "deploy_queen"
+	ActionConfirm       Action = "confirm"     // Deploy the selected troop
+	ActionCancel        Action = "cancel"      // Deselect, close an overlay, or quit
+	ActionInspector     Action = "inspector"   // Toggle the troop/tower inspector
+	ActionBattlefield   Action = "battlefield" // Toggle the battlefield panel
+	ActionChat          Action = "chat"
+	ActionSurrender     Action = "surrender"
+	ActionScrollLogUp   Action = "scroll_log_up"
+	ActionScrollLogDown Action = "scroll_log_down"
+	ActionFinalState    Action = "final_state" // On the results screen, toggle the frozen final board
+	ActionResync        Action = "resync"      // Ask the server for the full game state
+	ActionLaneLeft      Action = "lane_left"   // In two-lane games, send the selected troop down the left lane
+	ActionLaneRight     Action = "lane_right"  // ... or the right lane
+
+	// Event log filter toggles
+	ActionToggleCombatLog Action = "toggle_log_combat"
+	ActionToggleDeployLog Action = "toggle_log_deploy"
+	ActionToggleSystemLog Action = "toggle_log_system"
+	ActionToggleChatLog   Action = "toggle_log_chat"
+	ActionToggleErrorLog  Action = "toggle_log_error"
+)
+
+// logToggles maps each filter toggle action to the category it shows or hides.
+var logToggles = map[Action]LogCategory{
+	ActionToggleCombatLog: LogCombat,
+	ActionToggleDeployLog: LogDeploy,
+	ActionToggleSystemLog: LogSystem,
+	ActionToggleChatLog:   LogChat,
+	ActionToggleErrorLog:  LogError,
+}
+
+// deploySelection ties a deploy action to the troop it selects, in on-screen order.
+type deploySelection struct {
+	Action  Action
+	TroopID string
+	Name    string
+}
+
+var deploySelections = []deploySelection{
+	{ActionDeployPawn, "pawn", "Pawn"},
+	{ActionDeployBishop, "bishop", "Bishop"},
+	{ActionDeployRook, "rook", "Rook"},
+	{ActionDeployKnight, "knight", "Knight"},
+	{ActionDeployPrince, "prince", "Prince"},
+	{ActionDeployQueen, "queen", "Queen"},
+}
+
+// deploySelectionFor returns the troop a deploy action selects.
+func deploySelectionFor(action Action) (deploySelection, bool) {
+	for _, sel := range deploySelections {
+		if sel.Action == action {
+			return sel, true
+		}
+	}
+	return deploySelection{}, false
+}
+
+// KeyBinding is a single key: either a special key (Key) or a printable character (Ch).
+type KeyBinding struct {
+	Key termbox.Key
+	Ch  rune
+}
+
+// namedKeys are the special keys a binding may name, matched case-insensitively.
+var namedKeys = map[string]termbox.Key{
+	"enter":     termbox.KeyEnter,
+	"esc":       termbox.KeyEsc,
+	"tab":       termbox.KeyTab,
+	"space":     termbox.KeySpace,
+	"backspace": termbox.KeyBackspace2,
+	"up":        termbox.KeyArrowUp,
+	"down":      termbox.KeyArrowDown,
+	"left":      termbox.KeyArrowLeft,
+	"right":     termbox.KeyArrowRight,
+	"pgup":      termbox.KeyPgup,
+	"pgdn":      termbox.KeyPgdn,
+	"home":      termbox.KeyHome,
+	"end":       termbox.KeyEnd,
+	"f1":        termbox.KeyF1,
+	"f2":        termbox.KeyF2,
+	"f3":        termbox.KeyF3,
+	"f4":        termbox.KeyF4,
+	"f5":        termbox.KeyF5,
+	"f6":        termbox.KeyF6,
+	"f7":        termbox.KeyF7,
+	"f8":        termbox.KeyF8,
+}
+
+// ParseKeyBinding parses a key as written in the config file: a single character such as
+// "1" or "i", or a key name such as "Enter", "Esc" or "PgUp".
+func ParseKeyBinding(s string) (KeyBinding, error) {
+	if utf8.RuneCountInString(s) == 1 {
+		r, _ := utf8.DecodeRuneInString(s)
+		if r == ' ' {
+			return KeyBinding{Key: termbox.KeySpace}, nil
+		}
+		return KeyBinding{Ch: r}, nil
+	}
+	if key, ok := namedKeys[strings.ToLower(s)]; ok {
+		return KeyBinding{Key: key}, nil
+	}
+	return KeyBinding{}, fmt.Errorf("unknown key %q", s)
+}
+
+// String returns the binding as it would be written in the config file.
+func (b KeyBinding) String() string {
+	if b.Ch != 0 {
+		return string(b.Ch)
+	}
+	names := make([]string, 0, len(namedKeys))
+	for name := range namedKeys {
+		names = append(names, name)
+	}
+	sort.Strings(names) // Deterministic when several names map to one key
+	for _, name := range names {
+		if namedKeys[name] == b.Key {
+			return strings.ToUpper(name[:1]) + name[1:]
+		}
+	}
+	return fmt.Sprintf("key(%d)", b.Key)
+}
+
+// matches reports whether a termbox key event is this binding.
+func (b KeyBinding) matches(ev termbox.Event) bool {
+	if b.Ch != 0 {
+		return ev.Ch == b.Ch
+	}
+	return ev.Ch == 0 && ev.Key == b.Key
+}
+
+// Keymap maps each action to its key.
+type Keymap map[Action]KeyBinding
+
+// DefaultKeymap returns the built-in bindings.
+func DefaultKeymap() Keymap {
+	return Keymap{
+		ActionDeployPawn:    {Ch: '1'},
+		ActionDeployBishop:  {Ch: '2'},
+		ActionDeployRook:    {Ch: '3'},
+		ActionDeployKnight:  {Ch: '4'},
+		ActionDeployPrince:  {Ch: '5'},
+		ActionDeployQueen:   {Ch: '6'},
+		ActionConfirm:       {Key: termbox.KeyEnter},
+		ActionCancel:        {Key: termbox.KeyEsc},
+		ActionInspector:     {Ch: 'i'},
+		ActionBattlefield:   {Ch: 'b'},
+		ActionChat:          {Ch: 't'},
+		ActionSurrender:     {Ch: 'q'},
+		ActionScrollLogUp:   {Key: termbox.KeyPgup},
+		ActionScrollLogDown: {Key: termbox.KeyPgdn},
+		ActionFinalState:    {Ch: 'v'},
+		ActionResync:        {Ch: 'r'},
+		ActionLaneLeft:      {Key: termbox.KeyArrowLeft},
+		ActionLaneRight:     {Key: termbox.KeyArrowRight},
+
+		ActionToggleCombatLog: {Key: termbox.KeyF1},
+		ActionToggleDeployLog: {Key: termbox.KeyF2},
+		ActionToggleSystemLog: {Key: termbox.KeyF3},
+		ActionToggleChatLog:   {Key: termbox.KeyF4},
+		ActionToggleErrorLog:  {Key: termbox.KeyF5},
+	}
+}
+
+// BuildKeymap applies config overrides (action name -> key) to the default bindings.
+// Unknown actions, unparseable keys and two actions sharing a key are errors.
+func BuildKeymap(overrides map[string]string) (Keymap, error) {
+	km := DefaultKeymap()
+	for name, keyText := range overrides {
+		action := Action(name)
+		if _, known := km[action]; !known {
+			return nil, fmt.Errorf("unknown action %q in key bindings", name)
+		}
+		binding, err := ParseKeyBinding(keyText)
+		if err != nil {
+			return nil, fmt.Errorf("binding for %s: %w", name, err)
+		}
+		km[action] = binding
+	}
+	if err := km.Validate(); err != nil {
+		return nil, err
+	}
+	return km, nil
+}
+
+// Validate reports the first key bound to more than one action.
+func (k Keymap) Validate() error {
+	actions := k.sortedActions()
+	owner := make(map[KeyBinding]Action, len(actions))
+	for _, action := range actions {
+		binding := k[action]
+		if other, taken := owner[binding]; taken {
+			return fmt.Errorf("key %s is bound to both %s and %s", binding, other, action)
+		}
+		owner[binding] = action
+	}
+	return nil
+}
+
+// Resolve returns the action bound to a termbox key event, after normalizeKeyEvent.
+func (k Keymap) Resolve(ev termbox.Event) (Action, bool) {
+	ev = normalizeKeyEvent(ev)
+	for action, binding := range k {
+		if binding.matches(ev) {
+			return action, true
+		}
+	}
+	return "", false
+}
+
+// Label returns the key for an action as shown in on-screen hints.
+func (k Keymap) Label(action Action) string {
+	if binding, ok := k[action]; ok {
+		return binding.String()
+	}
+	return "?"
+}
+
+// Dump lists every binding, one "action = key" per line, sorted by action.
+func (k Keymap) Dump() string {
+	var b strings.Builder
+	for _, action := range k.sortedActions() {
+		fmt.Fprintf(&b, "%-16s = %s\n", action, k[action])
+	}
+	return b.String()
+}
+
+// sortedActions returns the bound actions in a stable order.
+func (k Keymap) sortedActions() []Action {
+	actions := make([]Action, 0, len(k))
+	for action := range k {
+		actions = append(actions, action)
+	}
+	sort.Slice(actions, func(i, j int) bool { return actions[i] < actions[j] })
+	return actions
+}
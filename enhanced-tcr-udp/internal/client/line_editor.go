@@ -0,0 +1,151 @@
+package client
+
+import (
+	"unicode"
+
+	"github.com/nsf/termbox-go"
+)
+
+// LineEditor holds one line of text being typed and the cursor within it. It knows nothing
+// about terminals; editLine turns termbox key events into calls on it.
+type LineEditor struct {
+	text   []rune
+	cursor int // Index in text where the next rune goes
+}
+
+// Text returns the line typed so far.
+func (e *LineEditor) Text() string {
+	return string(e.text)
+}
+
+// Len returns the length of the line in runes.
+func (e *LineEditor) Len() int {
+	return len(e.text)
+}
+
+// Cursor returns the cursor position, in runes from the start of the line.
+func (e *LineEditor) Cursor() int {
+	return e.cursor
+}
+
+// Insert types r at the cursor.
+func (e *LineEditor) Insert(r rune) {
+	e.text = append(e.text, 0)
+	copy(e.text[e.cursor+1:], e.text[e.cursor:])
+	e.text[e.cursor] = r
+	e.cursor++
+}
+
+// Backspace deletes the rune before the cursor, if any.
+func (e *LineEditor) Backspace() {
+	if e.cursor == 0 {
+		return
+	}
+	e.text = append(e.text[:e.cursor-1], e.text[e.cursor:]...)
+	e.cursor--
+}
+
+// Delete deletes the rune under the cursor, if any.
+func (e *LineEditor) Delete() {
+	if e.cursor == len(e.text) {
+		return
+	}
+	e.text = append(e.text[:e.cursor], e.text[e.cursor+1:]...)
+}
+
+// Left moves the cursor one rune back.
+func (e *LineEditor) Left() {
+	if e.cursor > 0 {
+		e.cursor--
+	}
+}
+
+// Right moves the cursor one rune forward.
+func (e *LineEditor) Right() {
+	if e.cursor < len(e.text) {
+		e.cursor++
+	}
+}
+
+// Home moves the cursor to the start of the line.
+func (e *LineEditor) Home() {
+	e.cursor = 0
+}
+
+// End moves the cursor past the end of the line.
+func (e *LineEditor) End() {
+	e.cursor = len(e.text)
+}
+
+// lineInput is what a key press did to a line being typed.
+type lineInput int
+
+const (
+	lineEditing   lineInput = iota // Keep reading keys
+	lineSubmitted                  // Enter: the line is done
+	lineCancelled                  // Esc or Ctrl+C: the input was abandoned
+)
+
+// editLine applies a key event to e. Keys that mean nothing in a line of text are ignored.
+func editLine(e *LineEditor, ev termbox.Event) lineInput {
+	ev = normalizeKeyEvent(ev)
+	switch ev.Key {
+	case termbox.KeyEnter:
+		return lineSubmitted
+	case termbox.KeyEsc, termbox.KeyCtrlC:
+		return lineCancelled
+	case termbox.KeySpace:
+		e.Insert(' ')
+	case termbox.KeyBackspace2:
+		e.Backspace()
+	case termbox.KeyDelete:
+		e.Delete()
+	case termbox.KeyArrowLeft:
+		e.Left()
+	case termbox.KeyArrowRight:
+		e.Right()
+	case termbox.KeyHome, termbox.KeyCtrlA:
+		e.Home()
+	case termbox.KeyEnd, termbox.KeyCtrlE:
+		e.End()
+	default:
+		if ev.Ch != 0 {
+			e.Insert(ev.Ch)
+		}
+	}
+	return lineEditing
+}
+
+// normalizeKeyEvent maps the ways terminals on different systems report the same key onto
+// one event. Windows consoles may send Enter, Tab, Esc and Space as characters, Enter as a
+// line feed, Backspace as Ctrl+H, and a printable character together with a key code;
+// Unix terminals send most of these as key codes.
+func normalizeKeyEvent(ev termbox.Event) termbox.Event {
+	if ev.Type != termbox.EventKey {
+		return ev
+	}
+	switch ev.Ch {
+	case '\r', '\n':
+		ev.Key, ev.Ch = termbox.KeyEnter, 0
+	case '\t':
+		ev.Key, ev.Ch = termbox.KeyTab, 0
+	case '\x1b':
+		ev.Key, ev.Ch = termbox.KeyEsc, 0
+	case '\b', '\x7f':
+		ev.Key, ev.Ch = termbox.KeyBackspace2, 0
+	case ' ':
+		ev.Key, ev.Ch = termbox.KeySpace, 0
+	case 0:
+	default:
+		if unicode.IsPrint(ev.Ch) {
+			ev.Key = 0 // The character is what was typed
+		}
+	}
+	switch ev.Key {
+	case termbox.KeyCtrlJ: // Line feed
+		ev.Key = termbox.KeyEnter
+	case termbox.KeyBackspace: // Ctrl+H
+		ev.Key = termbox.KeyBackspace2
+	}
+	return ev
+}
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"strings"
+	"sync"
+)
+
+// LogRing is an io.Writer for the standard logger that keeps the last lines written to it
+// in memory, so a crash report can show what led up to the crash. It is safe for
+// concurrent use.
+type LogRing struct {
+	mu      sync.Mutex
+	lines   []string
+	limit   int
+	partial strings.Builder // A line written without its newline yet
+}
+
+// NewLogRing creates a ring that keeps the last limit lines.
+func NewLogRing(limit int) *LogRing {
+	if limit < 1 {
+		limit = 1
+	}
+	return &LogRing{lines: make([]string, 0, limit), limit: limit}
+}
+
+// Write adds the complete lines in p to the ring. It never fails.
+func (r *LogRing) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rest := string(p)
+	for {
+		line, after, found := strings.Cut(rest, "\n")
+		r.partial.WriteString(line)
+		if !found {
+			break
+		}
+		r.add(r.partial.String())
+		r.partial.Reset()
+		rest = after
+	}
+	return len(p), nil
+}
+
+// add appends a line, dropping the oldest once the ring is full. r.mu must be held.
+func (r *LogRing) add(line string) {
+	if len(r.lines) >= r.limit {
+		r.lines = r.lines[1:]
+	}
+	r.lines = append(r.lines, line)
+}
+
+// Lines returns the kept lines, oldest first, including an unfinished last line.
+func (r *LogRing) Lines() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lines := append([]string(nil), r.lines...)
+	if r.partial.Len() > 0 {
+		lines = append(lines, r.partial.String())
+	}
+	return lines
+}
@@ -0,0 +1,56 @@
+package client
+
+// manaPrediction tracks this player's mana between authoritative state updates. Sending a
+// deploy deducts its cost straight away so the HUD doesn't lag a round trip behind the key
+// press; each deduction is kept under its command Seq until the server settles it.
+//
+// The server handles a player's commands in Seq order, so any mana figure it reports
+// together with a Seq (the state update watermark, or a rejected deploy) already reflects
+// every command up to that Seq. Reconciling drops those deductions and keeps the rest.
+type manaPrediction struct {
+	authoritative int            // Mana last reported by the server
+	watermark     uint32         // Highest Seq the server reported as handled
+	pending       map[uint32]int // Seq -> mana deducted for a deploy the server has not settled
+}
+
+// newManaPrediction creates an empty prediction.
+func newManaPrediction() *manaPrediction {
+	return &manaPrediction{pending: make(map[uint32]int)}
+}
+
+// Deduct records that the deploy with Seq seq was sent and will cost cost mana.
+func (m *manaPrediction) Deduct(seq uint32, cost int) {
+	if seq <= m.watermark || cost <= 0 {
+		return // Already settled; a late deduction would never be given back
+	}
+	m.pending[seq] = cost
+}
+
+// Reconcile takes the server's mana as of command seq, from a state update watermark or a
+// rejection. Deductions for seq and earlier are settled: the figure already includes the
+// ones that succeeded, and a rejected deploy is given back by dropping its deduction.
+// A report older than one already applied is ignored.
+func (m *manaPrediction) Reconcile(seq uint32, authoritative int) {
+	if seq < m.watermark {
+		return
+	}
+	m.watermark = seq
+	m.authoritative = authoritative
+	for pendingSeq := range m.pending {
+		if pendingSeq <= seq {
+			delete(m.pending, pendingSeq)
+		}
+	}
+}
+
+// Mana returns the predicted mana: the authoritative figure less unsettled deductions.
+func (m *manaPrediction) Mana() int {
+	mana := m.authoritative
+	for _, cost := range m.pending {
+		mana -= cost
+	}
+	if mana < 0 {
+		return 0
+	}
+	return mana
+}
@@ -1,216 +1,63 @@
 package client
 
 import (
-	"encoding/json"
 	"fmt"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
-	// "log"
-	"net"
-	"strings"
-
-	"enhanced-tcr-udp/internal/network"
-	// "enhanced-tcr-udp/internal/models" // For game state model access if needed directly here
+	"enhanced-tcr-udp/pkg/network"
 )
 
 // Handles incoming TCP/UDP messages
 
-// ListenForUDPMessages continuously listens for incoming UDP messages from the server.
-// It should be run in a goroutine.
-func (c *Client) ListenForUDPMessages() {
-	if c.UDPConn == nil {
-		// log.Println("UDP connection is not established. Cannot listen for UDP messages.")
-		return
-	}
-	// log.Println("Starting to listen for UDP messages from server...")
+// InboundUDPDrops returns the UDP datagrams the client has dropped before handling them, by
+// reason.
+func (c *Client) InboundUDPDrops() network.UDPDropCounts {
+	return c.inboundDrops.Counts()
+}
 
-	buffer := make([]byte, 2048) // Adjust buffer size as needed for expected message sizes
+// handleGameStateUpdate shows a complete state update; the session has already assembled
+// a split one and settled the deploys its watermark covers.
+func (c *Client) handleGameStateUpdate(updateData network.GameStateUpdateUDP) {
+	watermark := updateData.LastProcessedClientSeq[c.SessionToken]
 
-	for {
-		n, _, err := c.UDPConn.ReadFromUDP(buffer) // Can use Read() since we used DialUDP
-		if err != nil {
-			// Check if the error is due to the connection being closed
-			// This can happen when the client is shutting down or the connection is intentionally closed
-			netErr, ok := err.(net.Error)
-			if ok && netErr.Timeout() {
-				// log.Println("UDP read timeout. Continuing to listen...")
-				continue
-			}
-			if strings.Contains(err.Error(), "use of closed network connection") {
-				// log.Println("UDP connection closed. Stopping listener.")
-				return // Exit goroutine
-			}
-			// log.Printf("Error reading from UDP: %v. Listener might stop.", err)
-			if c.ui != nil {
-				c.ui.AddEventMessage(fmt.Sprintf("UDP Listen Error: %v. Game may be unresponsive.", err))
-				c.ui.Render() // Try to show the error
-				// Consider setting a specific error view or flag in ui
-			}
-			return // Or handle error more gracefully, e.g. attempt to re-establish for some errors
-		}
+	// log.Printf("Game State Update: Time Left: %ds, P1 Mana: %d, P2 Mana: %d",
+	// 	updateData.GameTimeRemainingSeconds, updateData.Player1Mana, updateData.Player2Mana)
 
-		var udpMsg network.UDPMessage
-		if err := json.Unmarshal(buffer[:n], &udpMsg); err != nil {
-			// log.Printf("Error unmarshalling UDP message: %v. Raw: %s", err, string(buffer[:n]))
-			continue
+	if c.ui != nil {
+		// Determine which mana belongs to this client
+		username := ""
+		if c.PlayerAccount != nil {
+			username = c.PlayerAccount.Username
 		}
+		myMana, opponentMana := manaFor(updateData, username, c.IsPlayerOne)
+		// Deploys sent after the watermark are still deducted until the server handles them
+		myMana = c.reconcileMana(watermark, myMana)
 
-		// Log the raw message type for now
-		// log.Printf("Received UDP PDU: Type=%s, SessionID=%s, PlayerToken=%s, Seq=%d",
-		// 	udpMsg.Type, udpMsg.SessionID, udpMsg.PlayerToken, udpMsg.Seq)
-
-		switch udpMsg.Type {
-		case network.UDPMsgTypeGameStateUpdate:
-			c.handleGameStateUpdate(udpMsg.Payload)
-		case network.UDPMsgTypeCommandAck:
-			var ackPayload network.CommandAckUDP
-			payloadBytes, err := json.Marshal(udpMsg.Payload)
-			if err != nil {
-				// log.Printf("Error marshalling CommandAckUDP payload: %v", err)
-				continue
-			}
-			if err := json.Unmarshal(payloadBytes, &ackPayload); err != nil {
-				// log.Printf("Error unmarshalling CommandAckUDP: %v. Raw: %s", err, string(payloadBytes))
-				continue
-			}
-
-			c.mu.Lock()
-			if _, exists := c.unacknowledgedDeployCommands[ackPayload.AckSeq]; exists {
-				delete(c.unacknowledgedDeployCommands, ackPayload.AckSeq)
-				// log.Printf("Client: Received ACK for DeployTroop command Seq: %d", ackPayload.AckSeq)
+		// Tower score for the HUD: ours under our username, the opponent's under the other key
+		myTowers, opponentTowers := 0, 0
+		for username, destroyed := range updateData.PlayerScores {
+			if c.PlayerAccount != nil && username == c.PlayerAccount.Username {
+				myTowers = destroyed
 			} else {
-				// log.Printf("Client: Received ACK for unknown or already acked Seq: %d", ackPayload.AckSeq)
-			}
-			c.mu.Unlock()
-		case network.UDPMsgTypeGameEvent:
-			var gameEventPayload network.GameEventUDP
-			payloadMap, ok := udpMsg.Payload.(map[string]interface{})
-			if !ok {
-				// log.Printf("Error: GameEvent payload is not map[string]interface{}. Type: %T", udpMsg.Payload)
-				continue
-			}
-			payloadBytes, err := json.Marshal(payloadMap)
-			if err != nil {
-				// log.Printf("Error re-marshalling GameEvent payload: %v", err)
-				continue
-			}
-			if err := json.Unmarshal(payloadBytes, &gameEventPayload); err != nil {
-				// log.Printf("Error unmarshalling GameEventUDP payload: %v. Raw: %s", err, string(payloadBytes))
-				continue
+				opponentTowers = destroyed
 			}
-
-			// log.Printf("Client %s received Game Event: Type=%s, Details=%v", c.PlayerAccount.Username, gameEventPayload.EventType, gameEventPayload.Details)
-
-			// Format and add to UI event log
-			if c.ui != nil {
-				message := ""
-				// Ensure detailsMap is initialized even if details are nil to prevent panic
-				var detailsMap map[string]interface{}
-				if gameEventPayload.Details != nil {
-					detailsMap, _ = gameEventPayload.Details.(map[string]interface{})
-				} else {
-					detailsMap = make(map[string]interface{}) // Initialize to empty map
-				}
-
-				switch gameEventPayload.EventType {
-				case network.GameEventTroopDeployed:
-					playerID, _ := detailsMap["player_id"].(string)
-					troopSpecID, _ := detailsMap["troop_spec"].(string)
-					if playerID == c.PlayerAccount.Username {
-						message = fmt.Sprintf("You deployed %s.", troopSpecID)
-					} else {
-						message = fmt.Sprintf("Opponent deployed %s.", troopSpecID)
-					}
-				case network.GameEventQueenHeal:
-					msgFromServer, _ := detailsMap["message"].(string)
-					if msgFromServer != "" {
-						message = msgFromServer // Use the pre-formatted message from server
-					} else {
-						playerID, _ := detailsMap["player_id"].(string)
-						towerSpecID, _ := detailsMap["tower_spec"].(string)
-						healedAmount, _ := detailsMap["healed_amount"].(float64) // JSON numbers are float64
-						newHP, _ := detailsMap["new_hp"].(float64)
-						if playerID == c.PlayerAccount.Username {
-							message = fmt.Sprintf("Your Queen healed tower %s for %.0f HP (now %.0f).", towerSpecID, healedAmount, newHP)
-						} else {
-							message = fmt.Sprintf("Opponent's Queen healed tower %s for %.0f HP (now %.0f).", towerSpecID, healedAmount, newHP)
-						}
-					}
-				case network.GameEventTowerDamaged:
-					attackerSpec, _ := detailsMap["attacker_spec"].(string)
-					defenderSpec, _ := detailsMap["defender_spec"].(string)
-					damage, _ := detailsMap["damage"].(float64)
-					newHP, _ := detailsMap["new_hp"].(float64)
-					message = fmt.Sprintf("%s damaged %s for %.0f! (HP: %.0f)", attackerSpec, defenderSpec, damage, newHP)
-				case network.GameEventTroopDamaged:
-					attackerSpec, _ := detailsMap["attacker_spec"].(string)
-					defenderSpec, _ := detailsMap["defender_spec"].(string)
-					damage, _ := detailsMap["damage"].(float64)
-					newHP, _ := detailsMap["new_hp"].(float64)
-					message = fmt.Sprintf("%s damaged %s for %.0f! (HP: %.0f)", attackerSpec, defenderSpec, damage, newHP)
-				case network.GameEventTowerDestroyed:
-					towerSpec, _ := detailsMap["tower_spec"].(string)
-					destroyerTroopSpec, _ := detailsMap["destroyed_by_troop_id"].(string) // This might be troop instance ID or spec based on server
-					message = fmt.Sprintf("Tower %s DESTROYED by %s!", towerSpec, destroyerTroopSpec)
-				case network.GameEventTroopDefeated:
-					troopSpec, _ := detailsMap["troop_spec"].(string)
-					defeatedByTowerSpec, _ := detailsMap["defeated_by_tower_id"].(string) // This might be tower instance ID or spec
-					message = fmt.Sprintf("Troop %s DEFEATED by %s!", troopSpec, defeatedByTowerSpec)
-				case network.GameEventCritHit:
-					attackerSpec, _ := detailsMap["attacker_spec"].(string)
-					defenderSpec, _ := detailsMap["defender_spec"].(string)
-					damage, _ := detailsMap["damage"].(float64)
-					message = fmt.Sprintf("CRITICAL HIT! %s smashes %s for %.0f damage!", attackerSpec, defenderSpec, damage)
-				case network.GameEventError: // Display errors sent by server
-					errorMsg, _ := detailsMap["message"].(string)
-					message = fmt.Sprintf("Server Error: %s", errorMsg)
-				case "DeployFailed": // Legacy, consider replacing with GameEventError
-					reason, _ := detailsMap["reason"].(string)
-					message = fmt.Sprintf("Deployment failed: %s", reason)
-				default:
-					message = fmt.Sprintf("Event: %s - %v", gameEventPayload.EventType, gameEventPayload.Details)
-				}
-				if message != "" {
-					c.ui.AddEventMessage(message)
-					c.ui.Render() // Re-render immediately after adding an event message
-				}
-			}
-		default:
-			// log.Printf("Received unknown UDP message type: %s", udpMsg.Type)
 		}
-	}
-}
-
-func (c *Client) handleGameStateUpdate(payload interface{}) {
-	// The payload from UDPMessage is interface{}. We need to assert it to the correct type.
-	// One way is to remarshal and unmarshal, or use map[string]interface{}.
-	// For more type safety, remarshaling is often cleaner if performance isn't critical.
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		// log.Printf("Error marshaling GameStateUpdate payload: %v", err)
-		return
-	}
-
-	var updateData network.GameStateUpdateUDP
-	if err := json.Unmarshal(payloadBytes, &updateData); err != nil {
-		// log.Printf("Error unmarshalling GameStateUpdateUDP: %v", err)
-		return
-	}
-
-	// log.Printf("Game State Update: Time Left: %ds, P1 Mana: %d, P2 Mana: %d",
-	// 	updateData.GameTimeRemainingSeconds, updateData.Player1Mana, updateData.Player2Mana)
+		c.ui.SetTowerScore(myTowers, opponentTowers)
 
-	if c.ui != nil {
-		// Determine which mana belongs to this client
-		myMana := 0
-		opponentMana := 0
-		if c.IsPlayerOne { // Assuming c.IsPlayerOne is set based on MatchFoundResponse
-			myMana = updateData.Player1Mana
-			opponentMana = updateData.Player2Mana
-		} else {
-			myMana = updateData.Player2Mana
-			opponentMana = updateData.Player1Mana
+		// State-driven alerts: time running out, own King Tower low
+		kingHP, kingMaxHP := 0, 0
+		for _, tower := range updateData.Towers {
+			if tower.Spec == "king_tower" && c.PlayerAccount != nil && tower.Owner == c.PlayerAccount.Username {
+				kingHP, kingMaxHP = tower.HP, tower.MaxHP
+			}
 		}
+		c.ui.Alerts().ObserveState(updateData.GameTimeRemainingSeconds, kingHP, kingMaxHP)
 
+		c.ui.SetLastState(updateData)
+		c.ui.SetWarmup(updateData.Warmup, updateData.StartsIn)
+		c.ui.SetSpectatorCount(updateData.SpectatorCount)
 		c.ui.UpdateGameInfo(
 			updateData.GameTimeRemainingSeconds,
 			myMana,
@@ -219,10 +66,300 @@ func (c *Client) handleGameStateUpdate(payload interface{}) {
 			updateData.Towers,
 		)
 		// TODO: Update towers and troops in UI (Sprint 2/3) - This is now done by passing troops/towers to UpdateGameInfo
-		c.ui.Render() // Re-render the UI with new information
+		c.ui.RequestRender() // Re-render the UI with new information
 	} else {
 		// Fallback for non-UI or headless mode if ever needed
 		// log.Printf("Received GameStateUpdate: Timer=%d, P1_Mana=%d", updateData.GameTimeRemainingSeconds, updateData.Player1Mana)
 	}
 	// TODO: Further process the game state, update local client model, etc.
 }
+
+// manaFor returns the mana of the player called username and of their opponent in a state
+// update. It goes by PlayerMana when the server sends it, and otherwise by the positional
+// fields of earlier servers, using isPlayerOne from MatchFoundResponse.
+func manaFor(update network.GameStateUpdateUDP, username string, isPlayerOne bool) (mine, opponent int) {
+	if mana, ok := update.PlayerMana[username]; ok {
+		for player, other := range update.PlayerMana {
+			if player != username {
+				opponent = other
+			}
+		}
+		return mana, opponent
+	}
+	if isPlayerOne {
+		return update.Player1Mana, update.Player2Mana
+	}
+	return update.Player2Mana, update.Player1Mana
+}
+
+// handleGameTimerUpdate applies a clock-only update; the rest of the last state update stands.
+func (c *Client) handleGameTimerUpdate(timer network.GameTimerUpdateUDP) {
+	if c.ui != nil {
+		c.ui.Alerts().ObserveState(timer.GameTimeRemainingSeconds, 0, 0) // The King Tower's HP only comes with full updates
+		c.ui.SetClock(timer.GameTimeRemainingSeconds, timer.StartsIn)
+		c.ui.RequestRender()
+	}
+}
+
+// handleGameEvent formats a game event for the UI event log, and acts on the ones that
+// change client state (deploy rejections, busy notices, spectator counts).
+func (c *Client) handleGameEvent(event network.GameEventUDP) {
+	// log.Printf("Client %s received Game Event: Type=%s, Details=%+v", c.PlayerAccount.Username, event.EventType, event.Details)
+	if c.ui == nil {
+		return
+	}
+
+	message := ""
+	category := LogCombat // Most events are fights; the cases below override it
+	side := SideNone      // Whose troop or tower acted, for the line's colour
+	switch details := event.Details.(type) {
+	case network.TroopDeployedEvent:
+		category = LogDeploy
+		troopName := c.GameConfig.TroopDisplayName(details.TroopSpec)
+		owner := details.OwnerID
+		if owner == "" {
+			owner = details.PlayerID
+		}
+		// The deploy event can beat the state update that shows the troop
+		c.ui.NoteDeployedTroop(details.TroopID, owner)
+		side = c.logSide(owner)
+		if side == SideMine {
+			message = fmt.Sprintf("You deployed %s.", troopName)
+		} else {
+			message = fmt.Sprintf("Opponent deployed %s.", troopName)
+		}
+	case network.QueenHealEvent:
+		side = c.logSide(details.PlayerID)
+		if details.TowerSpec != "" {
+			queen, tower := ownedName(side, "Queen"), ownedName(side, c.GameConfig.TowerDisplayName(details.TowerSpec))
+			message = fmt.Sprintf("%s healed %s for %d HP (now %d).", capitalize(queen), tower, details.HealedAmount, details.NewHP)
+		} else {
+			message = details.Message // No tower was healed; the server's message says so
+		}
+	case network.TowerDamagedEvent:
+		c.resyncIfUnknownTroop(details.AttackerID)
+		troopSide, towerSide := c.combatSides(details.AttackerID, details.DefenderID)
+		attacker := ownedName(troopSide, c.troopName(details.AttackerID, details.AttackerSpec))
+		defender := ownedName(towerSide, c.GameConfig.TowerDisplayName(details.DefenderSpec))
+		message = fmt.Sprintf("%s damaged %s for %d! (HP: %d)", capitalize(attacker), defender, details.Damage, details.NewHP)
+		message += effectivenessNote(details.Effectiveness)
+		side = troopSide
+	case network.TroopDamagedEvent:
+		c.resyncIfUnknownTroop(details.DefenderID)
+		troopSide, towerSide := c.combatSides(details.DefenderID, details.AttackerID)
+		attacker := ownedName(towerSide, c.GameConfig.TowerDisplayName(details.AttackerSpec))
+		defender := ownedName(troopSide, c.troopName(details.DefenderID, details.DefenderSpec))
+		message = fmt.Sprintf("%s damaged %s for %d! (HP: %d)", capitalize(attacker), defender, details.Damage, details.NewHP)
+		message += shieldNote(details) + effectivenessNote(details.Effectiveness)
+		side = towerSide
+	case network.TowerDestroyedEvent:
+		towerSide := c.logSide(details.OwnerID)
+		towerName := c.GameConfig.TowerDisplayName(details.TowerSpec)
+		destroyer := details.DestroyedByTroopID // Earlier servers only name the troop instance
+		if details.DestroyedByTroopSpec != "" {
+			destroyer = c.GameConfig.TroopDisplayName(details.DestroyedByTroopSpec)
+		}
+		message = fmt.Sprintf("%s DESTROYED by %s!", capitalize(ownedName(towerSide, towerName)), ownedName(opposingSide(towerSide), destroyer))
+		side = opposingSide(towerSide)
+		if towerSide == SideMine {
+			c.ui.Alerts().Trigger(AlertOwnTowerDestroyed, fmt.Sprintf("Your %s was destroyed!", towerName))
+		}
+	case network.TroopDefeatedEvent:
+		troopSide := c.logSide(details.OwnerID)
+		troopName := c.GameConfig.TroopDisplayName(details.TroopSpec)
+		defeatedBy := details.DefeatedByTowerID // Earlier servers only name the tower instance
+		if details.DefeatedByTowerSpec != "" {
+			defeatedBy = c.GameConfig.TowerDisplayName(details.DefeatedByTowerSpec)
+		}
+		message = fmt.Sprintf("%s DEFEATED by %s!", capitalize(ownedName(troopSide, troopName)), ownedName(opposingSide(troopSide), defeatedBy))
+		side = opposingSide(troopSide)
+		c.ui.RemoveTroop(details.TroopID)
+	case network.TroopExpiredEvent:
+		side = c.logSide(details.OwnerID)
+		message = fmt.Sprintf("%s expired.", capitalize(ownedName(side, c.GameConfig.TroopDisplayName(details.TroopSpec))))
+		c.ui.RemoveTroop(details.TroopID)
+	case network.ChargeHitEvent:
+		c.resyncIfUnknownTroop(details.AttackerID)
+		troopSide, towerSide := c.combatSides(details.AttackerID, details.DefenderID)
+		attacker := ownedName(troopSide, c.troopName(details.AttackerID, details.AttackerSpec))
+		defender := ownedName(towerSide, c.GameConfig.TowerDisplayName(details.DefenderSpec))
+		message = fmt.Sprintf("CHARGE! %s slams %s for %d damage (x%.1f)!", capitalize(attacker), defender, details.Damage, details.Multiplier)
+		message += effectivenessNote(details.Effectiveness)
+		side = troopSide
+	case network.CritHitEvent:
+		c.resyncIfUnknownTroop(details.DefenderID)
+		// Crits come from towers hitting troops
+		troopSide, towerSide := c.combatSides(details.DefenderID, details.AttackerID)
+		troopName := c.troopName(details.DefenderID, details.DefenderSpec)
+		attacker := ownedName(towerSide, c.GameConfig.TowerDisplayName(details.AttackerSpec))
+		message = fmt.Sprintf("CRITICAL HIT! %s smashes %s for %d damage!", capitalize(attacker), ownedName(troopSide, troopName), details.Damage)
+		message += shieldNote(details.TroopDamagedEvent) + effectivenessNote(details.Effectiveness)
+		side = towerSide
+		if troopSide == SideMine {
+			c.ui.Alerts().Trigger(AlertCritReceived, fmt.Sprintf("Your %s took a critical hit!", troopName))
+		}
+	case network.GameErrorEvent: // Display errors sent by server
+		category = LogError
+		message = fmt.Sprintf("Server Error: %s", details.Message)
+		switch details.Code {
+		case network.GameErrorServerBusy:
+			if session := c.udp(); session != nil && session.Pending(details.Seq) { // Backing off, then resent
+				category = LogSystem
+				message = "Server busy; retrying your command shortly."
+			}
+		case network.GameErrorDeployRejected:
+			c.ui.SetMyMana(c.rejectDeploy(details.Seq, details.Mana))
+			message = fmt.Sprintf("Deploy rejected: %s", details.Message)
+		case network.GameErrorWarmup:
+			c.ui.SetMyMana(c.rejectDeploy(details.Seq, details.Mana))
+			category = LogSystem
+			message = "The battle has not started yet; wait for the countdown to finish."
+		case network.GameErrorPaused:
+			c.ui.SetMyMana(c.rejectDeploy(details.Seq, details.Mana))
+			category = LogSystem
+			message = "The battle is paused until your opponent reconnects."
+		}
+	case network.CountdownEvent:
+		category = LogSystem
+		message = fmt.Sprintf("Battle starts in %d...", details.SecondsLeft)
+	case network.CombatStartEvent:
+		category = LogSystem
+		message = "FIGHT!"
+	case network.SpectatorEvent:
+		category = LogSystem
+		verb := "is now watching"
+		if event.EventType == network.GameEventSpectatorLeft {
+			verb = "stopped watching"
+		}
+		message = fmt.Sprintf("%s %s (%d watching).", details.Spectator, verb, details.Count)
+		c.ui.SetSpectatorCount(details.Count)
+	case network.OpponentConnectionEvent:
+		category = LogSystem
+		switch {
+		case event.EventType == network.GameEventOpponentReconnected:
+			c.ui.ClearOpponentAway()
+			message = fmt.Sprintf("%s reconnected. The battle resumes!", details.Opponent)
+		case details.GraceSeconds > 0: // Resent each second; only the first is logged
+			if !c.ui.SetOpponentAway(details.Opponent, time.Duration(details.GraceSeconds)*time.Second) {
+				message = fmt.Sprintf("%s disconnected. The battle is paused for up to %ds while they reconnect.", details.Opponent, details.GraceSeconds)
+			}
+			c.ui.RequestRender()
+		default:
+			c.ui.ClearOpponentAway()
+			message = fmt.Sprintf("%s did not come back. The battle goes on without them.", details.Opponent)
+		}
+	case network.DeployFailedEvent: // Sent by early servers instead of GameEventError
+		category = LogError
+		message = fmt.Sprintf("Deployment failed: %s", details.Reason)
+	default:
+		category = LogSystem
+		message = fmt.Sprintf("Event: %s - %v", event.EventType, event.Details)
+	}
+	if message != "" {
+		c.ui.AddSidedEventMessage(category, side, message)
+		c.ui.RequestRender() // Coalesced with the rest of the tick's events
+	}
+}
+
+// logSide returns the side an owner username is on: this player's, the opponent's, or
+// SideNone if the owner is unknown. Must be called with a UI.
+func (c *Client) logSide(owner string) LogSide {
+	switch {
+	case owner == "":
+		return SideNone
+	case c.PlayerAccount != nil && owner == c.PlayerAccount.Username:
+		return SideMine
+	default:
+		return SideTheirs
+	}
+}
+
+// combatSides returns the sides of a troop and the tower it is fighting. Troops only fight
+// the opponent's towers, so either owner tells both sides: the troop's is looked up first,
+// and the tower's (known from the start of the game) when the troop is not known yet.
+// Must be called with a UI.
+func (c *Client) combatSides(troopID, towerID string) (troopSide, towerSide LogSide) {
+	if troopSide = c.logSide(c.ui.eventTroopOwner(troopID)); troopSide != SideNone {
+		return troopSide, opposingSide(troopSide)
+	}
+	towerSide = c.logSide(c.ui.towerOwner(towerID))
+	return opposingSide(towerSide), towerSide
+}
+
+// opposingSide returns the other player's side; SideNone stays SideNone.
+func opposingSide(side LogSide) LogSide {
+	switch side {
+	case SideMine:
+		return SideTheirs
+	case SideTheirs:
+		return SideMine
+	}
+	return SideNone
+}
+
+// ownedName prefixes the name of a troop or tower with whose it is, e.g. "your Rook" or
+// "enemy Guard Tower". A name of unknown side is left bare.
+func ownedName(side LogSide, name string) string {
+	switch side {
+	case SideMine:
+		return "your " + name
+	case SideTheirs:
+		return "enemy " + name
+	}
+	return name
+}
+
+// capitalize upper-cases the first letter of s, for an ownedName starting a sentence.
+func capitalize(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 {
+		return s
+	}
+	return string(unicode.ToUpper(r)) + s[size:]
+}
+
+// resyncIfUnknownTroop asks for a full state update when an event names a troop that the
+// last state update did not include: a troop only fights once it has spawned, long after
+// the update announcing it, so updates must have been lost. A troop that was recently
+// defeated is not unknown; events about it may simply arrive late. Must be called with a UI.
+func (c *Client) resyncIfUnknownTroop(instanceID string) {
+	if c.ui.troopOwner(instanceID) == "" {
+		// log.Printf("Event names unknown troop %s; requesting a full state update.", instanceID)
+		c.RequestFullState()
+	}
+}
+
+// troopName names a troop in an event, marking one that has already been defeated so a
+// late event about it reads sensibly. Must be called with a UI.
+func (c *Client) troopName(instanceID, specID string) string {
+	name := c.GameConfig.TroopDisplayName(specID)
+	if c.ui.troopRemoved(instanceID) {
+		name += " (defeated)"
+	}
+	return name
+}
+
+// shieldNote describes how much of a hit a troop's shield took, or returns "" if the
+// shield wasn't involved.
+func shieldNote(hit network.TroopDamagedEvent) string {
+	if hit.ShieldAbsorbed <= 0 {
+		return ""
+	}
+	if hit.ShieldLeft <= 0 {
+		return fmt.Sprintf(" [shield absorbed %d and broke]", hit.ShieldAbsorbed)
+	}
+	return fmt.Sprintf(" [shield absorbed %d, %d left]", hit.ShieldAbsorbed, hit.ShieldLeft)
+}
+
+// effectivenessNote annotates a hit whose damage type was strong or weak against the
+// defender's armor. An effectiveness of 0 (left out of the event) or 1 means neutral.
+func effectivenessNote(effectiveness float64) string {
+	switch {
+	case effectiveness == 0 || effectiveness == 1.0:
+		return ""
+	case effectiveness > 1.0:
+		return " (effective)"
+	default:
+		return " (resisted)"
+	}
+}
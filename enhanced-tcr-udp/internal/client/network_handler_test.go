@@ -0,0 +1,155 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+	"enhanced-tcr-udp/pkg/tcrclient"
+)
+
+const (
+	testGameID = "game-1"
+	testToken  = "token-alice"
+)
+
+// testGame is a client in game testGameID as alice, with its UDP listener running against a
+// loopback socket standing in for the game server.
+type testGame struct {
+	client *Client
+	ui     *TermboxUI
+	server *net.UDPConn
+	addr   *net.UDPAddr // The client's end
+}
+
+func newTestGame(t *testing.T) *testGame {
+	t.Helper()
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listening for UDP: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	ui := NewTermboxUI()
+	c := NewClient(ui)
+	c.PlayerAccount = &models.PlayerAccount{Username: "alice", Level: 1, GameID: testGameID}
+	c.SessionToken = testToken
+	c.IsPlayerOne = true
+	// The session as tcrclient.Client dials it for a match, with the client's handlers
+	cfg := c.sessionConfig()
+	cfg.GameID, cfg.PlayerToken, cfg.Protocol = testGameID, testToken, network.ProtocolVersion
+	cfg.OnState, cfg.OnEvent = c.handleGameStateUpdate, c.handleGameEvent
+	session, err := tcrclient.DialSession("127.0.0.1", server.LocalAddr().(*net.UDPAddr).Port, cfg)
+	if err != nil {
+		t.Fatalf("DialSession: %v", err)
+	}
+	c.session = session
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		session.Listen(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		c.CloseConnections()
+	})
+	return &testGame{client: c, ui: ui, server: server, addr: session.LocalAddr()}
+}
+
+// send sends msg to the client as the game server.
+func (g *testGame) send(t *testing.T, msg network.UDPMessage) {
+	t.Helper()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("encoding %s message: %v", msg.Type, err)
+	}
+	if _, err := g.server.WriteToUDP(data, g.addr); err != nil {
+		t.Fatalf("sending %s message: %v", msg.Type, err)
+	}
+}
+
+// sync sends a clock update for the current game and waits until the UI shows it. Datagrams
+// on loopback arrive in order, so everything sent before has been handled by then.
+func (g *testGame) sync(t *testing.T, seconds int) {
+	t.Helper()
+	g.send(t, network.UDPMessage{
+		Type:      network.UDPMsgTypeGameTimer,
+		SessionID: testGameID,
+		Payload:   network.GameTimerUpdateUDP{GameTimeRemainingSeconds: seconds},
+	})
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		g.ui.mu.Lock()
+		timer := g.ui.gameTimer
+		g.ui.mu.Unlock()
+		if timer == seconds {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the client never showed the clock update of %ds", seconds)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// stateUpdate is a game state update that would change everything the UI shows.
+func stateUpdate(sessionID, token string) network.UDPMessage {
+	return network.UDPMessage{
+		Type:        network.UDPMsgTypeGameStateUpdate,
+		SessionID:   sessionID,
+		PlayerToken: token,
+		Payload: network.GameStateUpdateUDP{
+			GameTimeRemainingSeconds: 42,
+			PlayerMana:               map[string]int{"alice": 7, "bob": 9},
+			Towers:                   []network.TowerState{{ID: "player2_king", Spec: "king_tower", Owner: "bob", HP: 1, MaxHP: 2000}},
+			ActiveTroops:             map[string]network.TroopState{"bob_troop_1": {Spec: "pawn", Owner: "bob", HP: 50, MaxHP: 50}},
+		},
+	}
+}
+
+func TestMismatchedPacketsLeaveUIUntouched(t *testing.T) {
+	var logs bytes.Buffer
+	previous := log.Writer()
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(previous) })
+	g := newTestGame(t)
+
+	g.send(t, stateUpdate("stale-game", ""))            // A previous game's session
+	g.send(t, stateUpdate("stale-game", testToken))     // Again: counted, but not logged again
+	g.send(t, stateUpdate(testGameID, "token-mallory")) // Another player's token
+	g.sync(t, 100)
+
+	g.ui.mu.Lock()
+	if g.ui.lastState != nil || len(g.ui.towers) != 0 || len(g.ui.activeTroops) != 0 || g.ui.myMana != 0 || g.ui.opponentMana != 0 {
+		t.Errorf("mismatched updates reached the UI: state %v, towers %v, troops %v, mana %d/%d",
+			g.ui.lastState, g.ui.towers, g.ui.activeTroops, g.ui.myMana, g.ui.opponentMana)
+	}
+	g.ui.mu.Unlock()
+	if drops := g.client.InboundUDPDrops(); drops.BadSession != 3 {
+		t.Errorf("InboundUDPDrops().BadSession = %d, want 3", drops.BadSession)
+	}
+	if n := strings.Count(logs.String(), "Dropping UDP"); n != 1 {
+		t.Errorf("logged %d drops, want only the first within the sample interval:\n%s", n, logs.String())
+	}
+	if !strings.Contains(logs.String(), "for session stale-game (current game "+testGameID+")") {
+		t.Errorf("log does not name the stray session:\n%s", logs.String())
+	}
+
+	// The same update for this game and player goes through
+	g.send(t, stateUpdate(testGameID, testToken))
+	g.sync(t, 99)
+	g.ui.mu.Lock()
+	defer g.ui.mu.Unlock()
+	if g.ui.lastState == nil || len(g.ui.towers) != 1 || len(g.ui.activeTroops) != 1 || g.ui.myMana != 7 || g.ui.opponentMana != 9 {
+		t.Errorf("matching update did not reach the UI: towers %v, troops %v, mana %d/%d", g.ui.towers, g.ui.activeTroops, g.ui.myMana, g.ui.opponentMana)
+	}
+}
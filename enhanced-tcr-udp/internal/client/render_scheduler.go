@@ -0,0 +1,108 @@
+package client
+
+import (
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+// renderInterval is the shortest gap between two scheduled redraws, capping them at 20 a second.
+const renderInterval = 50 * time.Millisecond
+
+// renderScheduler collects redraw requests from the network goroutines for the UI goroutine,
+// which is the only one that draws while the event loop runs (termbox is not safe for
+// concurrent use). Requests made between two redraws coalesce into one.
+type renderScheduler struct {
+	dirty     chan struct{} // A throttled redraw is wanted; capacity 1
+	immediate chan struct{} // A redraw is wanted right away, e.g. after a view change; capacity 1
+}
+
+// newRenderScheduler creates a scheduler with no redraw pending.
+func newRenderScheduler() *renderScheduler {
+	return &renderScheduler{
+		dirty:     make(chan struct{}, 1),
+		immediate: make(chan struct{}, 1),
+	}
+}
+
+// request marks the screen dirty. It never blocks.
+func (s *renderScheduler) request() {
+	select {
+	case s.dirty <- struct{}{}:
+	default: // A redraw is already pending
+	}
+}
+
+// requestNow asks for a redraw without waiting out renderInterval. It never blocks.
+func (s *renderScheduler) requestNow() {
+	select {
+	case s.immediate <- struct{}{}:
+	default:
+	}
+}
+
+// run is the UI goroutine's loop. It passes each event to handle and redraws with render:
+// right away when asked to, otherwise at most once per renderInterval while the screen is
+// dirty. It returns once handle reports false or events is closed.
+func (s *renderScheduler) run(events <-chan termbox.Event, handle func(termbox.Event) bool, render func()) {
+	var lastRender time.Time
+	var throttle <-chan time.Time // Fires when a deferred redraw is due; nil if none is
+	draw := func() {
+		render()
+		lastRender = time.Now()
+		throttle = nil
+	}
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok || !handle(ev) {
+				return
+			}
+		case <-s.immediate:
+			draw()
+		case <-s.dirty:
+			if throttle != nil {
+				continue // Already due
+			}
+			if wait := renderInterval - time.Since(lastRender); wait > 0 {
+				throttle = time.After(wait)
+			} else {
+				draw()
+			}
+		case <-throttle:
+			draw()
+		}
+	}
+}
+
+// pollTermboxEvents forwards termbox events to the returned channel from a goroutine of its
+// own, so the UI goroutine can wait on them alongside redraw requests. The returned stop
+// function ends the forwarding and returns once termbox is no longer being polled, so
+// PollEvent can be called directly again.
+func pollTermboxEvents() (<-chan termbox.Event, func()) {
+	events := make(chan termbox.Event)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer RecoverCrash()
+		for {
+			ev := termbox.PollEvent()
+			if ev.Type == termbox.EventInterrupt {
+				return
+			}
+			select {
+			case events <- ev:
+			case <-quit:
+				// Drop the event and poll again, where the interrupt from stop lands
+			}
+		}
+	}()
+	stop := func() {
+		close(quit)
+		termbox.Interrupt() // Blocks until the poller receives it
+		<-done
+	}
+	return events, stop
+}
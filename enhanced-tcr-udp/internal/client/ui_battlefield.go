@@ -0,0 +1,349 @@
+package client
+
+import (
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+const (
+	// battlefieldMinWidth is the narrowest terminal the battlefield panel is drawn on;
+	// below it the game screen falls back to the plain tower and troop lists.
+	battlefieldMinWidth = 72
+	// battlefieldMaxWidth caps the panel so boxes don't stretch across very wide terminals.
+	battlefieldMaxWidth = 100
+	// battlefieldAdvancing heads the troops that have not attacked a tower yet.
+	battlefieldAdvancing = "advancing"
+)
+
+// gridCell is one character of a cellGrid.
+type gridCell struct {
+	Ch rune
+	Fg termbox.Attribute
+}
+
+// cellGrid is a fixed-width block of characters drawn off-screen, so the battlefield
+// layout can be computed (and checked) without a terminal. Rows grow as they are written.
+type cellGrid struct {
+	Width int
+	rows  [][]gridCell
+}
+
+// newCellGrid creates an empty grid width cells wide.
+func newCellGrid(width int) *cellGrid {
+	return &cellGrid{Width: width}
+}
+
+// Height returns the number of rows written so far.
+func (g *cellGrid) Height() int {
+	return len(g.rows)
+}
+
+// Set writes one character; writes past the right edge are dropped.
+func (g *cellGrid) Set(x, y int, ch rune, fg termbox.Attribute) {
+	if x < 0 || x >= g.Width || y < 0 {
+		return
+	}
+	for len(g.rows) <= y {
+		row := make([]gridCell, g.Width)
+		for i := range row {
+			row[i] = gridCell{Ch: ' ', Fg: termbox.ColorDefault}
+		}
+		g.rows = append(g.rows, row)
+	}
+	g.rows[y][x] = gridCell{Ch: ch, Fg: fg}
+}
+
+// WriteString writes s starting at (x, y), clipped to maxLen characters and the grid edge.
+func (g *cellGrid) WriteString(x, y int, s string, maxLen int, fg termbox.Attribute) {
+	for i, r := range []rune(s) {
+		if i >= maxLen {
+			return
+		}
+		g.Set(x+i, y, r, fg)
+	}
+}
+
+// At returns the cell at (x, y); cells never written are blank.
+func (g *cellGrid) At(x, y int) gridCell {
+	if x < 0 || x >= g.Width || y < 0 || y >= len(g.rows) {
+		return gridCell{Ch: ' ', Fg: termbox.ColorDefault}
+	}
+	return g.rows[y][x]
+}
+
+// Row returns row y as text, which is how layouts are inspected outside the UI.
+func (g *cellGrid) Row(y int) string {
+	runes := make([]rune, g.Width)
+	for x := range runes {
+		runes[x] = g.At(x, y).Ch
+	}
+	return string(runes)
+}
+
+// battlefieldColumns returns the width of each side's column for a panel width total wide;
+// the two columns are separated by a three-character gutter.
+func battlefieldColumns(total int) (colWidth, rightX int) {
+	if total > battlefieldMaxWidth {
+		total = battlefieldMaxWidth
+	}
+	colWidth = (total - 3) / 2
+	return colWidth, colWidth + 3
+}
+
+// battlefieldSide is one column of the panel: a player's towers and the troops attacking them.
+type battlefieldSide struct {
+	Title     string
+	Towers    []network.TowerState
+	Attackers map[string][]battlefieldTroop // Keyed by tower ID, or battlefieldAdvancing
+	TowerFg   termbox.Attribute
+	TroopFg   termbox.Attribute
+}
+
+// battlefieldTroop is an active troop placed on the panel.
+type battlefieldTroop struct {
+	ID    string
+	State network.TroopState
+}
+
+// layoutBattlefield draws both sides of the battlefield into a grid width cells wide.
+// The left column is myID's side: their towers, with the opponent's troops grouped under
+// the tower each is attacking. The right column is the opponent's side the same way, so a
+// troop always appears on the side of the field it is fighting on. Troops that have not
+// picked a target yet are listed as advancing at the bottom of the side they are heading to.
+func layoutBattlefield(towers []network.TowerState, troops map[string]network.TroopState, myID string, cfg *models.GameConfig, width int) *cellGrid {
+	colWidth, rightX := battlefieldColumns(width)
+	grid := newCellGrid(rightX + colWidth)
+
+	mine := battlefieldSide{Title: "YOUR SIDE", Attackers: make(map[string][]battlefieldTroop), TowerFg: termbox.ColorGreen, TroopFg: termbox.ColorMagenta}
+	theirs := battlefieldSide{Title: "OPPONENT", Attackers: make(map[string][]battlefieldTroop), TowerFg: termbox.ColorRed, TroopFg: termbox.ColorCyan}
+	towerSide := make(map[string]*battlefieldSide, len(towers))
+	for _, tower := range towers {
+		side := &theirs
+		if tower.Owner == myID {
+			side = &mine
+		}
+		side.Towers = append(side.Towers, tower)
+		towerSide[tower.ID] = side
+	}
+
+	troopIDs := make([]string, 0, len(troops))
+	for id := range troops {
+		troopIDs = append(troopIDs, id)
+	}
+	sort.Strings(troopIDs)
+	for _, id := range troopIDs {
+		troop := troops[id]
+		if troop.HP <= 0 {
+			continue
+		}
+		target := troop.Target
+		side, known := towerSide[target]
+		if !known {
+			target = battlefieldAdvancing
+			side = &mine
+			if troop.Owner == myID {
+				side = &theirs
+			}
+		}
+		side.Attackers[target] = append(side.Attackers[target], battlefieldTroop{ID: id, State: troop})
+	}
+
+	// Divider between the columns, drawn after both sides so it spans the taller one
+	leftRows := layoutBattlefieldSide(grid, 0, colWidth, mine, cfg)
+	rightRows := layoutBattlefieldSide(grid, rightX, colWidth, theirs, cfg)
+	rows := leftRows
+	if rightRows > rows {
+		rows = rightRows
+	}
+	for y := 0; y < rows; y++ {
+		grid.Set(colWidth+1, y, '|', termbox.ColorWhite)
+	}
+	return grid
+}
+
+// layoutBattlefieldSide draws one column at x and returns the number of rows it used. In a
+// two-lane game the towers guarding each lane are drawn in a sub-column of their own, left
+// lane first, above the towers covering both lanes.
+func layoutBattlefieldSide(grid *cellGrid, x, colWidth int, side battlefieldSide, cfg *models.GameConfig) int {
+	y := 0
+	grid.WriteString(x, y, side.Title, colWidth, termbox.ColorYellow)
+	y++
+
+	sort.Slice(side.Towers, func(i, j int) bool { return side.Towers[i].ID < side.Towers[j].ID })
+	var shared []network.TowerState
+	laneTowers := make(map[string][]network.TowerState)
+	for _, tower := range side.Towers {
+		if tower.Lane == "" {
+			shared = append(shared, tower)
+		} else {
+			laneTowers[tower.Lane] = append(laneTowers[tower.Lane], tower)
+		}
+	}
+	if len(laneTowers) > 0 {
+		laneWidth := (colWidth - 1) / 2
+		bottom := y
+		for i, lane := range models.Lanes {
+			laneX := x + i*(colWidth-laneWidth)
+			grid.WriteString(laneX, y, lane+" lane", laneWidth, termbox.ColorYellow)
+			bottom = max(bottom, layoutTowerStack(grid, laneX, y+1, laneWidth, laneTowers[lane], side, cfg))
+		}
+		y = bottom
+	}
+	y = layoutTowerStack(grid, x, y, colWidth, shared, side, cfg)
+	if advancing := side.Attackers[battlefieldAdvancing]; len(advancing) > 0 {
+		grid.WriteString(x, y, "~ "+battlefieldAdvancing+" ~", colWidth, termbox.ColorYellow)
+		y++
+		for _, troop := range advancing {
+			grid.WriteString(x, y, troopRow(troop, cfg, colWidth), colWidth, troopFg(troop, side))
+			y++
+		}
+	}
+	return y
+}
+
+// layoutTowerStack draws towers one below the other from row y, each followed by the troops
+// attacking it, and returns the next free row.
+func layoutTowerStack(grid *cellGrid, x, y, width int, towers []network.TowerState, side battlefieldSide, cfg *models.GameConfig) int {
+	for _, tower := range towers {
+		y = layoutTowerBox(grid, x, y, width, tower, side.TowerFg, cfg)
+		for _, troop := range side.Attackers[tower.ID] {
+			grid.WriteString(x, y, troopRow(troop, cfg, width), width, troopFg(troop, side))
+			y++
+		}
+	}
+	return y
+}
+
+// layoutTowerBox draws a boxed tower with its symbol, name and HP bar starting at row y,
+// and returns the next free row:
+//
+//	+----------------------+
+//	|K King Tower          |
+//	|[######....] 1200/2000|
+//	+----------------------+
+func layoutTowerBox(grid *cellGrid, x, y, colWidth int, tower network.TowerState, fg termbox.Attribute, cfg *models.GameConfig) int {
+	if tower.Destroyed {
+		fg = termbox.ColorDarkGray
+	}
+	inner := colWidth - 2
+	border := "+" + strings.Repeat("-", inner) + "+"
+	grid.WriteString(x, y, border, colWidth, fg)
+
+	label := fmt.Sprintf("%s %s", symbolFor(cfg, tower.Spec, true), cfg.TowerDisplayName(tower.Spec))
+	grid.Set(x, y+1, '|', fg)
+	grid.WriteString(x+1, y+1, label, inner, fg)
+	grid.Set(x+colWidth-1, y+1, '|', fg)
+
+	status := "DESTROYED"
+	if !tower.Destroyed {
+		hpText := fmt.Sprintf(" %d/%d", tower.HP, tower.MaxHP)
+		barLen := inner - len([]rune(hpText)) - 2 // makeBar adds the brackets
+		if barLen < 1 {
+			barLen = 1
+		}
+		status = makeBar(tower.HP, tower.MaxHP, barLen, '#', '.') + hpText
+	}
+	grid.Set(x, y+2, '|', fg)
+	grid.WriteString(x+1, y+2, status, inner, fg)
+	grid.Set(x+colWidth-1, y+2, '|', fg)
+
+	grid.WriteString(x, y+3, border, colWidth, fg)
+	return y + 4
+}
+
+// troopRow formats a troop as "  K [####..] 120/200", plus " +50" for a shield,
+// " (1.5s)" while it is still spawning and " ~4.0s" for the lifetime it has left.
+func troopRow(troop battlefieldTroop, cfg *models.GameConfig, colWidth int) string {
+	hpText := fmt.Sprintf(" %d/%d", troop.State.HP, troop.State.MaxHP)
+	barLen := colWidth - 4 - len([]rune(hpText)) - 2
+	if barLen > 10 {
+		barLen = 10
+	}
+	if barLen < 1 {
+		barLen = 1
+	}
+	row := fmt.Sprintf("  %s %s%s", symbolFor(cfg, troop.State.Spec, false), makeBar(troop.State.HP, troop.State.MaxHP, barLen, '#', '.'), hpText)
+	if troop.State.Shield > 0 {
+		row += fmt.Sprintf(" +%d", troop.State.Shield)
+	}
+	if troop.State.Spawning {
+		row += fmt.Sprintf(" (%.1fs)", float64(troop.State.SpawnsInMs)/1000)
+	}
+	if troop.State.ExpiresInMs > 0 {
+		row += fmt.Sprintf(" ~%.1fs", float64(troop.State.ExpiresInMs)/1000)
+	}
+	return row
+}
+
+// troopFg returns the colour of a troop's row: dimmed while it is still spawning.
+func troopFg(troop battlefieldTroop, side battlefieldSide) termbox.Attribute {
+	if troop.State.Spawning {
+		return termbox.ColorDarkGray
+	}
+	return side.TroopFg
+}
+
+// symbolFor returns the compact symbol for a tower or troop spec, or "?" if it has none.
+func symbolFor(cfg *models.GameConfig, specID string, tower bool) string {
+	if cfg != nil {
+		if tower {
+			if spec, ok := cfg.Towers[specID]; ok && spec.Symbol != "" {
+				return spec.Symbol
+			}
+		} else if spec, ok := cfg.Troops[specID]; ok && spec.Symbol != "" {
+			return spec.Symbol
+		}
+	}
+	return "?"
+}
+
+// twoLaneGame reports whether towers come from a two-lane game, whose towers guard lanes.
+func twoLaneGame(towers []network.TowerState) bool {
+	for _, tower := range towers {
+		if tower.Lane != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// deployLane returns the lane the selected troop goes into in a two-lane game: the one last
+// picked, or the left lane.
+func (ui *TermboxUI) deployLane() string {
+	if ui.selectedLane == "" {
+		return models.LaneLeft
+	}
+	return ui.selectedLane
+}
+
+// battlefieldFits reports whether a terminal width cells wide can show the panel.
+func battlefieldFits(width int) bool {
+	return width >= battlefieldMinWidth
+}
+
+// drawBattlefield draws the battlefield panel at row y, cut off above row maxY, and returns
+// the next free row.
+func (ui *TermboxUI) drawBattlefield(y, width, maxY int) int {
+	myID := ""
+	var cfg *models.GameConfig
+	if ui.client != nil {
+		if ui.client.PlayerAccount != nil {
+			myID = ui.client.PlayerAccount.Username
+		}
+		cfg = ui.client.GameConfig
+	}
+	grid := layoutBattlefield(ui.towers, ui.activeTroops, myID, cfg, width-2)
+	rows := rowsThatFit(y, grid.Height(), maxY)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < grid.Width; col++ {
+			cell := grid.At(col, row)
+			termbox.SetCell(1+col, y+row, cell.Ch, cell.Fg, termbox.ColorBlack)
+		}
+	}
+	return y + rows
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+
+	"enhanced-tcr-udp/internal/game"
+	"enhanced-tcr-udp/pkg/models"
+
+	"github.com/nsf/termbox-go"
+)
+
+// troopAbilities describes troop behaviour the specs don't capture; it mirrors the server's deploy handler.
+var troopAbilities = map[string]string{
+	"queen": "Heals your most damaged tower by 300 HP; does not stay on the field",
+}
+
+// statMultiplier returns the multiplier the server scales a player's stats with: the stat
+// handicap the player has in handicaps, or else the one for their level.
+func statMultiplier(handicaps map[string]models.PlayerOverrides, username string, level int) float64 {
+	if multiplier := handicaps[username].StatMultiplier; multiplier != 0 {
+		return multiplier
+	}
+	return game.LevelMultiplier(level, models.DefaultGameRules().LevelStatBonus)
+}
+
+// statsLabel describes what a player's stats are scaled for, e.g. "level 3".
+func statsLabel(handicaps map[string]models.PlayerOverrides, username string, level int) string {
+	if multiplier := handicaps[username].StatMultiplier; multiplier != 0 {
+		return fmt.Sprintf("level %d, handicap x%.2f", level, multiplier)
+	}
+	return fmt.Sprintf("level %d", level)
+}
+
+// scaledStats returns HP/ATK/DEF scaled by multiplier, exactly as the server scales them.
+func scaledStats(baseHP, baseATK, baseDEF int, multiplier float64) (hp, atk, def int) {
+	return game.ScaleStat(baseHP, multiplier), game.ScaleStat(baseATK, multiplier), game.ScaleStat(baseDEF, multiplier)
+}
+
+// displayInspector renders the troop/tower spec overlay toggled with 'i'.
+func (ui *TermboxUI) displayInspector() {
+	y := 1
+	ui.DisplayStaticText(1, y, "--- Inspector (i or ESC to close) ---", termbox.ColorYellow, termbox.ColorBlack)
+	y += 2
+
+	if ui.client == nil || ui.client.GameConfig == nil {
+		ui.DisplayStaticText(1, y, "(No game config received yet)", termbox.ColorDefault, termbox.ColorBlack)
+		return
+	}
+	cfg := ui.client.GameConfig
+
+	myName, myLevel := "", 1
+	if ui.client.PlayerAccount != nil {
+		myName, myLevel = ui.client.PlayerAccount.Username, ui.client.PlayerAccount.Level
+	}
+	handicaps := ui.client.Handicaps
+	mine := statsLabel(handicaps, myName, myLevel)
+
+	y = ui.displayTroopSpecs(y, cfg, mine, statMultiplier(handicaps, myName, myLevel))
+	y++
+
+	y = ui.displayTowerSpecs(y, fmt.Sprintf("Your towers (%s):", mine), cfg.Towers, statMultiplier(handicaps, myName, myLevel))
+	if opponent := ui.client.Opponent; opponent != nil {
+		y++
+		ui.displayTowerSpecs(y, fmt.Sprintf("%s's towers (%s):", opponent.Username, statsLabel(handicaps, opponent.Username, opponent.Level)), cfg.Towers, statMultiplier(handicaps, opponent.Username, opponent.Level))
+	}
+}
+
+// displayTroopSpecs lists the troops of cfg scaled by multiplier, cheapest first, starting
+// at row y, and returns the next free row. scaling says what the stats are scaled for.
+func (ui *TermboxUI) displayTroopSpecs(y int, cfg *models.GameConfig, scaling string, multiplier float64) int {
+	ui.DisplayStaticText(1, y, fmt.Sprintf("Troops (your %s):", scaling), termbox.ColorCyan, termbox.ColorBlack)
+	y++
+	troopIDs := make([]string, 0, len(cfg.Troops))
+	for id := range cfg.Troops {
+		troopIDs = append(troopIDs, id)
+	}
+	sort.Slice(troopIDs, func(i, j int) bool {
+		a, b := cfg.Troops[troopIDs[i]], cfg.Troops[troopIDs[j]]
+		if a.ManaCost != b.ManaCost {
+			return a.ManaCost < b.ManaCost
+		}
+		return a.ID < b.ID
+	})
+	for _, id := range troopIDs {
+		spec := cfg.Troops[id]
+		hp, atk, def := scaledStats(spec.BaseHP, spec.BaseATK, spec.BaseDEF, multiplier)
+		line := fmt.Sprintf("  %-8s mana %2d  HP %5d  ATK %4d  DEF %4d", cfg.TroopDisplayName(id), spec.ManaCost, hp, atk, def)
+		if ability, ok := troopAbilities[id]; ok {
+			line += "  - " + ability
+		} else if spec.Special == models.SpecialCharge {
+			line += fmt.Sprintf("  - Charge: first hit deals x%.1f damage", spec.ChargeMultiplier)
+		} else if spec.Special == models.SpecialShield && spec.ShieldHP > 0 {
+			line += fmt.Sprintf("  - Shield: absorbs %d damage before HP", game.ScaleStat(spec.ShieldHP, multiplier))
+		}
+		if spec.SpawnDelayMs > 0 {
+			line += fmt.Sprintf("  (spawns in %.1fs)", spec.SpawnDelay().Seconds())
+		}
+		if spec.LifetimeMs > 0 {
+			line += fmt.Sprintf("  (lasts %.1fs)", spec.Lifetime().Seconds())
+		}
+		ui.DisplayStaticText(1, y, line, termbox.ColorWhite, termbox.ColorBlack)
+		y++
+	}
+	return y
+}
+
+// displayTowerSpecs lists tower specs scaled by multiplier starting at row y and returns the next free row.
+func (ui *TermboxUI) displayTowerSpecs(y int, title string, towers map[string]models.TowerSpec, multiplier float64) int {
+	ui.DisplayStaticText(1, y, title, termbox.ColorCyan, termbox.ColorBlack)
+	y++
+	towerIDs := make([]string, 0, len(towers))
+	for id := range towers {
+		towerIDs = append(towerIDs, id)
+	}
+	sort.Strings(towerIDs)
+	for _, id := range towerIDs {
+		spec := towers[id]
+		hp, atk, def := scaledStats(spec.BaseHP, spec.BaseATK, spec.BaseDEF, multiplier)
+		name := spec.DisplayName
+		if name == "" {
+			name = spec.Name
+		}
+		line := fmt.Sprintf("  %-12s HP %5d  ATK %4d  DEF %4d  CRIT %2.0f%%", name, hp, atk, def, spec.CritChance*100)
+		ui.DisplayStaticText(1, y, line, termbox.ColorWhite, termbox.ColorBlack)
+		y++
+	}
+	return y
+}
+
+// BrowseGameConfig shows the troops and towers of cfg, scaled for level, until a key is
+// pressed. It is the "Troops & Towers" screen offered before matchmaking.
+func (ui *TermboxUI) BrowseGameConfig(cfg *models.GameConfig, level int) {
+	ui.ClearScreen()
+	y := 1
+	ui.DisplayStaticText(1, y, "--- Troops & Towers (any key to go back) ---", termbox.ColorYellow, termbox.ColorBlack)
+	y += 2
+	multiplier := statMultiplier(nil, "", level) // No match, so no handicap
+	y = ui.displayTroopSpecs(y, cfg, fmt.Sprintf("level %d", level), multiplier)
+	y++
+	ui.displayTowerSpecs(y, fmt.Sprintf("Towers (level %d):", level), cfg.Towers, multiplier)
+	ui.WaitForKeyPress()
+}
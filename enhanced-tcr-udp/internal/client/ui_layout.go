@@ -0,0 +1,52 @@
+package client
+
+import "fmt"
+
+const (
+	// minScreenWidth and minScreenHeight are the smallest terminal the game, versus and
+	// results screens are laid out for; smaller ones get tooSmallMessage instead.
+	minScreenWidth  = 80
+	minScreenHeight = 24
+	// gamePromptRows is the space the game screen keeps at the bottom for the deploy prompt,
+	// the selection and the spectator count.
+	gamePromptRows = 3
+	// eventLogRows is the height of the event log panel: header, messages and separator.
+	eventLogRows = 1 + maxEventLogMessages + 1
+)
+
+// screenTooSmall reports whether a w x h terminal is below the minimum size.
+func screenTooSmall(w, h int) bool {
+	return w < minScreenWidth || h < minScreenHeight
+}
+
+// tooSmallMessage is shown in place of a screen that does not fit a w x h terminal.
+func tooSmallMessage(w, h int) string {
+	return fmt.Sprintf("Terminal too small (need %dx%d, have %dx%d)", minScreenWidth, minScreenHeight, w, h)
+}
+
+// truncateLine shortens text to at most width characters, ending it with "..." when
+// anything was cut.
+func truncateLine(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) <= width {
+		return text
+	}
+	if width <= 3 {
+		if width < 0 {
+			width = 0
+		}
+		return string(runes[:width])
+	}
+	return string(runes[:width-3]) + "..."
+}
+
+// rowsThatFit returns how many of rows rows starting at row y are above row limit.
+func rowsThatFit(y, rows, limit int) int {
+	switch {
+	case y >= limit:
+		return 0
+	case y+rows > limit:
+		return limit - y
+	}
+	return rows
+}
@@ -1,10 +1,15 @@
 package client
 
 import (
-	"enhanced-tcr-udp/internal/models"
-	"enhanced-tcr-udp/internal/network" // Added for network.GameOverResults
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network" // Added for network.GameOverResults
 	"fmt"
+	"math"
+	"os"
+	"sort"
 	"strings" // Ensure strings is imported
+	"sync"
+	"time"
 
 	// "log"
 
@@ -13,6 +18,12 @@ import (
 
 const (
 	maxEventLogMessages = 5 // Number of recent event messages to display
+	// surrenderConfirmWindow is how long after a first surrender key press a second one
+	// actually surrenders, so a stray key press can't end the game.
+	surrenderConfirmWindow = 3 * time.Second
+	// removedTroopMemory is how long the UI remembers a troop after it left the field, so
+	// events about it that arrive late can still name it.
+	removedTroopMemory = 5 * time.Second
 )
 
 // UIView defines the different states or screens the UI can be in.
@@ -25,32 +36,163 @@ const (
 	ViewMatchmaking
 	ViewGame
 	ViewGameOver
+	ViewVersus // Pre-game splash naming both players
 )
 
-// TermboxUI holds state for the termbox interface
+// TermboxUI holds state for the termbox interface. The network goroutines update it through
+// the Set methods and RequestRender; only the goroutine running the event loop draws.
 type TermboxUI struct {
-	gameTimer         int
-	myMana            int                           // Renamed from player1Mana for clarity from client's perspective
-	opponentMana      int                           // Renamed from player2Mana
-	towers            []models.TowerInstance        // All towers in the game state
-	activeTroops      map[string]models.ActiveTroop // All active troops
-	eventLog          []string                      // To store recent event messages
-	inputLine         string
-	lastSelectedTroop rune
-	client            *Client
-
-	currentView     UIView                  // Current UI state (e.g., game, game over)
-	gameOverDetails network.GameOverResults // Stores details for the game over screen
+	mu        sync.Mutex       // Guards the display state below against the network goroutines
+	renders   *renderScheduler // Redraw requests for the event loop
+	rendering bool             // Render is drawing; DisplayStaticText leaves the flush to it
+
+	gameTimer          int
+	myMana             int                           // Renamed from player1Mana for clarity from client's perspective
+	opponentMana       int                           // Renamed from player2Mana
+	myTowerScore       int                           // Enemy towers destroyed by this client so far
+	opponentTowerScore int                           // This client's towers destroyed by the opponent so far
+	warmup             bool                          // The server is still in the warm-up before combat
+	startsIn           int                           // Seconds until combat starts; 0 while waiting for the opponent
+	spectators         int                           // Spectators watching the game
+	opponentAway       string                        // Name of the disconnected opponent the game is paused for; empty if none
+	opponentAwayUntil  time.Time                     // When the server stops waiting for them
+	unicodeSymbols     bool                          // The locale looks like UTF-8; see terminalSupportsUnicode
+	towers             []network.TowerState          // All towers in the game state
+	activeTroops       map[string]network.TroopState // All active troops
+	removedTroops      map[string]removedTroop       // Troops that left the field within removedTroopMemory
+	deployedOwners     map[string]string             // Troop instance ID -> owner, for troops deployed but not yet in a state update
+	eventLog           *LogModel                     // Event log history and category filter
+	inputLine          string
+	selectedTroop      deploySelection // Troop chosen with a deploy key, awaiting confirm
+	selectedLane       string          // Lane the selected troop goes into in two-lane games; kept between deploys
+	keymap             Keymap          // Key bindings for the game loop
+	client             *Client
+
+	currentView     UIView                      // Current UI state (e.g., game, game over)
+	showInspector   bool                        // Troop/tower spec overlay is open; deploy input is paused
+	showBattlefield bool                        // Draw the battlefield panel instead of the tower/troop lists (if the terminal is wide enough)
+	alerts          *AlertManager               // Alert banner and bell
+	gameOverDetails network.GameOverResults     // Stores details for the game over screen
+	surrenderArmed  time.Time                   // When the surrender key was first pressed; zero if not awaiting confirmation
+	lastState       *network.GameStateUpdateUDP // Last state update received, kept for the final board
+	showFinalState  bool                        // On the results screen, show the frozen final board instead
 	// TODO: Store TroopSpec (from GameConfig) to display mana costs dynamically
 }
 
 // NewTermboxUI creates a new TermboxUI manager.
 func NewTermboxUI() *TermboxUI {
 	return &TermboxUI{
-		activeTroops: make(map[string]models.ActiveTroop),
-		towers:       make([]models.TowerInstance, 0),
-		eventLog:     make([]string, 0, maxEventLogMessages),
-		currentView:  ViewGame, // Default to game view, might be set to login/matchmaking by main flow
+		activeTroops:    make(map[string]network.TroopState),
+		removedTroops:   make(map[string]removedTroop),
+		deployedOwners:  make(map[string]string),
+		towers:          make([]network.TowerState, 0),
+		eventLog:        NewLogModel(eventLogHistorySize),
+		renders:         newRenderScheduler(),
+		currentView:     ViewGame, // Default to game view, might be set to login/matchmaking by main flow
+		alerts:          NewAlertManager(DefaultClientConfig().Alerts, nil),
+		keymap:          DefaultKeymap(),
+		showBattlefield: true,
+		unicodeSymbols:  terminalSupportsUnicode(),
+	}
+}
+
+// SetKeymap replaces the key bindings used by the game loop.
+func (ui *TermboxUI) SetKeymap(km Keymap) {
+	ui.keymap = km
+}
+
+// SetAlertConfig replaces the alert settings, e.g. with those from the client config file.
+func (ui *TermboxUI) SetAlertConfig(cfg AlertConfig) {
+	ui.alerts = NewAlertManager(cfg, nil)
+}
+
+// Alerts returns the UI's alert manager.
+func (ui *TermboxUI) Alerts() *AlertManager {
+	return ui.alerts
+}
+
+// removedTroop is a troop that has left the field, as last seen.
+type removedTroop struct {
+	troop     network.TroopState
+	removedAt time.Time
+}
+
+// troopOwner returns the owner of an active or recently removed troop, or "" if the troop
+// is unknown.
+func (ui *TermboxUI) troopOwner(instanceID string) string {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	if troop, ok := ui.activeTroops[instanceID]; ok {
+		return troop.Owner
+	}
+	return ui.removedTroops[instanceID].troop.Owner
+}
+
+// NoteDeployedTroop remembers the owner of a troop whose deploy event came in before the
+// state update that shows it, so events about it can be told apart until that update.
+func (ui *TermboxUI) NoteDeployedTroop(instanceID, owner string) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	if _, ok := ui.activeTroops[instanceID]; !ok {
+		ui.deployedOwners[instanceID] = owner
+	}
+}
+
+// eventTroopOwner returns the owner of a troop an event names: as troopOwner, or else as
+// its deploy event said. It is "" if the troop is unknown.
+func (ui *TermboxUI) eventTroopOwner(instanceID string) string {
+	if owner := ui.troopOwner(instanceID); owner != "" {
+		return owner
+	}
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	return ui.deployedOwners[instanceID]
+}
+
+// towerOwner returns the owner of a tower in the last state update, or "" if it has none.
+func (ui *TermboxUI) towerOwner(towerID string) string {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	for _, tower := range ui.towers {
+		if tower.ID == towerID {
+			return tower.Owner
+		}
+	}
+	return ""
+}
+
+// troopRemoved reports whether a troop left the field within the last removedTroopMemory.
+func (ui *TermboxUI) troopRemoved(instanceID string) bool {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	_, ok := ui.removedTroops[instanceID]
+	return ok
+}
+
+// RemoveTroop takes a defeated troop off the field without waiting for the next state
+// update, remembering it for removedTroopMemory.
+func (ui *TermboxUI) RemoveTroop(instanceID string) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	delete(ui.deployedOwners, instanceID)
+	if troop, ok := ui.activeTroops[instanceID]; ok {
+		delete(ui.activeTroops, instanceID)
+		ui.removedTroops[instanceID] = removedTroop{troop: troop, removedAt: time.Now()}
+	}
+}
+
+// rememberRemovedTroops records the troops missing from troops as removed, and forgets
+// those removed more than removedTroopMemory ago. The caller must hold ui.mu.
+func (ui *TermboxUI) rememberRemovedTroops(troops map[string]network.TroopState, now time.Time) {
+	for id, troop := range ui.activeTroops {
+		if _, ok := troops[id]; !ok {
+			ui.removedTroops[id] = removedTroop{troop: troop, removedAt: now}
+		}
+	}
+	for id, removed := range ui.removedTroops {
+		if now.Sub(removed.removedAt) > removedTroopMemory {
+			delete(ui.removedTroops, id)
+		}
 	}
 }
 
@@ -59,17 +201,36 @@ func (ui *TermboxUI) SetClient(c *Client) {
 	ui.client = c
 }
 
-// SetCurrentView changes the current UI view (e.g., game, game_over).
+// SetCurrentView changes the current UI view (e.g., game, game_over). A running event loop
+// redraws right away rather than waiting out the render throttle.
 func (ui *TermboxUI) SetCurrentView(view UIView) {
 	// log.Printf("UI View changing from %v to %v", ui.currentView, view)
+	ui.mu.Lock()
 	ui.currentView = view
-	ui.ClearScreen() // Clear screen when view changes
-	// ui.Render() // Render immediately after view change - let the main loop control render calls.
+	ui.mu.Unlock()
+	ui.renders.requestNow() // Render clears the screen, so the old view doesn't linger
+}
+
+// view returns the current UI view.
+func (ui *TermboxUI) view() UIView {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	return ui.currentView
+}
+
+// RequestRender marks the screen as needing a redraw. The event loop redraws at most once
+// per renderInterval however often this is called, so network handlers may call it freely.
+func (ui *TermboxUI) RequestRender() {
+	ui.renders.request()
 }
 
 // SetGameOverDetails stores the results to be displayed on the game over screen.
 func (ui *TermboxUI) SetGameOverDetails(results network.GameOverResults) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
 	ui.gameOverDetails = results
+	ui.opponentAway = ""      // Nothing left to wait for
+	ui.showFinalState = false // Results come first; the final board is a key press away
 	// log.Printf("Game over details set in UI: Outcome %s, EXP %d", results.Outcome, results.EXPChange)
 }
 
@@ -85,11 +246,21 @@ func (ui *TermboxUI) Close() {
 
 // DisplayStaticText draws some static text at given coordinates.
 // A more advanced version would take a list of strings or a buffer.
+// Within Render the text is flushed with the rest of the frame. Text running past the
+// right edge is truncated; rows below the screen are not drawn.
 func (ui *TermboxUI) DisplayStaticText(x, y int, text string, fg, bg termbox.Attribute) {
+	if w, h := termbox.Size(); w > 0 && h > 0 {
+		if y >= h {
+			return
+		}
+		text = truncateLine(text, w-x)
+	}
 	for i, r := range []rune(text) {
 		termbox.SetCell(x+i, y, r, fg, bg)
 	}
-	termbox.Flush()
+	if !ui.rendering {
+		termbox.Flush()
+	}
 }
 
 // makeBar creates a text-based progress bar string.
@@ -114,25 +285,251 @@ func makeBar(current, max, barLength int, filledChar, emptyChar rune) string {
 	return fmt.Sprintf("[%s%s]", strings.Repeat(string(filledChar), filledCount), strings.Repeat(string(emptyChar), emptyCount))
 }
 
+// towerSummary is one side's standing towers, as shown in the HUD header.
+type towerSummary struct {
+	Alive int // Towers not destroyed
+	HP    int // Remaining HP over all towers; destroyed towers count as 0
+	MaxHP int // Full HP over all towers, destroyed ones included
+}
+
+// HPPercent returns the side's remaining tower HP as a whole percentage of its full HP.
+func (s towerSummary) HPPercent() int {
+	if s.MaxHP <= 0 {
+		return 0
+	}
+	return s.HP * 100 / s.MaxHP
+}
+
+// summarizeTowers totals the towers owned by myID and by everyone else. Each side's Alive
+// count falls by exactly the towers the other side has destroyed, which is what decides a
+// game that runs out of time; HP is only a guide to who is closer to the next tower.
+func summarizeTowers(towers []network.TowerState, myID string) (mine, opponent towerSummary) {
+	for _, tower := range towers {
+		side := &opponent
+		if tower.Owner == myID {
+			side = &mine
+		}
+		side.MaxHP += tower.MaxHP
+		if tower.Destroyed {
+			continue
+		}
+		side.Alive++
+		if tower.HP > 0 {
+			side.HP += tower.HP
+		}
+	}
+	return mine, opponent
+}
+
 // UpdateGameInfo updates the game state information to be displayed.
-func (ui *TermboxUI) UpdateGameInfo(timer, clientMana, oppMana int, troops map[string]models.ActiveTroop, allTowers []models.TowerInstance) {
+func (ui *TermboxUI) UpdateGameInfo(timer, clientMana, oppMana int, troops map[string]network.TroopState, allTowers []network.TowerState) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
 	ui.gameTimer = timer
 	ui.myMana = clientMana
 	ui.opponentMana = oppMana
+	ui.rememberRemovedTroops(troops, time.Now())
 	ui.activeTroops = troops
+	for id := range troops {
+		delete(ui.deployedOwners, id)
+	}
 	ui.towers = allTowers
 }
 
-// AddEventMessage adds a message to the event log.
-func (ui *TermboxUI) AddEventMessage(message string) {
-	if len(ui.eventLog) >= maxEventLogMessages {
-		// Remove the oldest message
-		ui.eventLog = ui.eventLog[1:]
+// SetLastState keeps the latest state update so the board can still be shown, frozen,
+// once the game is over.
+func (ui *TermboxUI) SetLastState(update network.GameStateUpdateUDP) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	ui.lastState = &update
+}
+
+// SetClock updates the time left and the warm-up countdown from a clock-only update.
+func (ui *TermboxUI) SetClock(timer, startsIn int) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	ui.gameTimer = timer
+	ui.startsIn = startsIn
+}
+
+// SetMyMana updates this player's mana between state updates, e.g. with a predicted value.
+func (ui *TermboxUI) SetMyMana(mana int) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	ui.myMana = mana
+}
+
+// SetWarmup records whether the game is still warming up and how long until combat starts.
+func (ui *TermboxUI) SetWarmup(warmup bool, startsIn int) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	ui.warmup = warmup
+	ui.startsIn = startsIn
+}
+
+// SetOpponentAway shows that the game is paused for the disconnected opponent, for at
+// most grace from now. It reports whether the banner was already showing.
+func (ui *TermboxUI) SetOpponentAway(opponent string, grace time.Duration) bool {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	showing := ui.opponentAway != ""
+	ui.opponentAway = opponent
+	ui.opponentAwayUntil = time.Now().Add(grace)
+	return showing
+}
+
+// ClearOpponentAway removes the banner shown by SetOpponentAway.
+func (ui *TermboxUI) ClearOpponentAway() {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	ui.opponentAway = ""
+}
+
+// SetSpectatorCount records how many spectators are watching, for the footer.
+func (ui *TermboxUI) SetSpectatorCount(count int) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	ui.spectators = count
+}
+
+// spectatorLabel returns the footer text for count spectators, e.g. "👁 2 watching", or ""
+// if nobody is watching. Terminals without a UTF-8 locale get "(o) 2 watching" instead.
+func spectatorLabel(count int, unicode bool) string {
+	if count <= 0 {
+		return ""
+	}
+	icon := "(o)"
+	if unicode {
+		icon = "\U0001F441"
+	}
+	return fmt.Sprintf("%s %d watching", icon, count)
+}
+
+// terminalSupportsUnicode guesses from the locale whether the terminal can show non-ASCII symbols.
+func terminalSupportsUnicode() bool {
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			value = strings.ToUpper(value)
+			return strings.Contains(value, "UTF-8") || strings.Contains(value, "UTF8")
+		}
 	}
-	ui.eventLog = append(ui.eventLog, message)
-	// It's important to call Render() after adding an event if immediate update is desired.
-	// However, typically the main loop calls Render periodically.
-	// For critical events, a direct call to ui.Render() might be added here or after the call to AddEventMessage.
+	return false
+}
+
+// SetTowerScore updates the running count of towers each side has destroyed.
+func (ui *TermboxUI) SetTowerScore(mine, opponent int) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	ui.myTowerScore = mine
+	ui.opponentTowerScore = opponent
+}
+
+// AddEventMessage adds a message to the event log under a category the player can filter on.
+// Callers on a network goroutine follow it with RequestRender to have it shown.
+func (ui *TermboxUI) AddEventMessage(category LogCategory, message string) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	ui.eventLog.Add(category, message)
+}
+
+// AddSidedEventMessage adds a message about one side's troops or towers to the event log,
+// coloured for that side.
+func (ui *TermboxUI) AddSidedEventMessage(category LogCategory, side LogSide, message string) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	ui.eventLog.AddSided(category, side, message)
+}
+
+// logEntryColor picks the text colour for an event log line: the colours of the troop list
+// for lines about either side, otherwise its category's.
+func logEntryColor(entry LogEntry) termbox.Attribute {
+	switch entry.Side {
+	case SideMine:
+		return termbox.ColorCyan
+	case SideTheirs:
+		return termbox.ColorMagenta
+	}
+	return logCategoryColor(entry.Category)
+}
+
+// logCategoryColor picks the text colour for an event log line.
+func logCategoryColor(category LogCategory) termbox.Attribute {
+	switch category {
+	case LogError:
+		return termbox.ColorRed
+	case LogDeploy:
+		return termbox.ColorCyan
+	case LogChat:
+		return termbox.ColorGreen
+	case LogSystem:
+		return termbox.ColorYellow
+	}
+	return termbox.ColorWhite
+}
+
+// gameOverHeadline says how the game ended from this player's side, e.g.
+// "Victory — opponent's King Tower destroyed". It is empty if the results carry no reason.
+func gameOverHeadline(results network.GameOverResults) string {
+	var win, loss, draw string
+	switch results.Reason {
+	case network.GameEndKingTowerDestroyed:
+		win, loss, draw = "opponent's King Tower destroyed", "your King Tower was destroyed", "both King Towers fell"
+	case network.GameEndTimeout:
+		win, loss, draw = "more towers destroyed when time ran out", "fewer towers destroyed when time ran out", "time ran out with towers level"
+	case network.GameEndPlayerQuit:
+		win, loss, draw = "opponent quit", "you left the game", "both players left"
+	case network.GameEndSurrender:
+		win, loss, draw = "opponent surrendered", "you surrendered", "surrender"
+	case network.GameEndForced:
+		win, loss, draw = "ended by the server", "ended by the server", "ended by the server"
+	default:
+		return ""
+	}
+	switch strings.ToLower(results.Outcome) {
+	case "win":
+		return "Victory — " + win
+	case "loss":
+		return "Defeat — " + loss
+	}
+	return "Draw — " + draw
+}
+
+// summaryLines formats the players' game summaries for the results screen, the player's own
+// first, e.g. "MVP: Prince (740 dmg) | Damage taken: 1200 | Healed: 300".
+func (ui *TermboxUI) summaryLines(summaries map[string]network.PlayerGameSummary) []string {
+	myID := ""
+	var cfg *models.GameConfig
+	if ui.client != nil {
+		if ui.client.PlayerAccount != nil {
+			myID = ui.client.PlayerAccount.Username
+		}
+		cfg = ui.client.GameConfig
+	}
+	names := make([]string, 0, len(summaries))
+	for name := range summaries {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if (names[i] == myID) != (names[j] == myID) {
+			return names[i] == myID
+		}
+		return names[i] < names[j]
+	})
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		s := summaries[name]
+		prefix := name + "'s "
+		if name == myID {
+			prefix = ""
+		}
+		mvp := prefix + "MVP: none"
+		if s.MVPTroop != "" {
+			mvp = fmt.Sprintf("%sMVP: %s (%d dmg)", prefix, cfg.TroopDisplayName(s.MVPTroop), s.MVPDamage)
+		}
+		lines = append(lines, fmt.Sprintf("%s | Damage dealt: %d, taken: %d | Healed: %d", mvp, s.DamageDealt, s.DamageTaken, s.Heals))
+	}
+	return lines
 }
 
 // displayGameOverScreen renders the game over information.
@@ -157,9 +554,43 @@ func (ui *TermboxUI) displayGameOverScreen() {
 	ui.DisplayStaticText(1, y, outcomeMsg, outcomeColor, termbox.ColorDefault)
 	y++
 
+	if headline := gameOverHeadline(ui.gameOverDetails); headline != "" {
+		ui.DisplayStaticText(1, y, headline, outcomeColor, termbox.ColorDefault)
+		y++
+	} else if ui.gameOverDetails.Surrendered {
+		ui.DisplayStaticText(1, y, "You surrendered.", termbox.ColorRed, termbox.ColorDefault)
+		y++
+	} else if ui.gameOverDetails.OpponentSurrendered {
+		ui.DisplayStaticText(1, y, "Your opponent surrendered.", termbox.ColorGreen, termbox.ColorDefault)
+		y++
+	}
+	if ui.gameOverDetails.GameID != "" {
+		ui.DisplayStaticText(1, y, "Game ID: "+ui.gameOverDetails.GameID, termbox.ColorDarkGray, termbox.ColorDefault)
+		y++
+	}
+	if ui.gameOverDetails.Casual {
+		ui.DisplayStaticText(1, y, "Casual game: your win/loss record is unchanged.", termbox.ColorCyan, termbox.ColorDefault)
+		y++
+	}
+
 	expMsg := fmt.Sprintf("EXP Earned this game: %+d", ui.gameOverDetails.EXPChange)
+	if ui.gameOverDetails.ConsolationEXP > 0 && len(ui.gameOverDetails.EXPBreakdown) == 0 {
+		expMsg += fmt.Sprintf(" (includes %d for the surrender)", ui.gameOverDetails.ConsolationEXP)
+	}
 	ui.DisplayStaticText(1, y, expMsg, termbox.ColorWhite, termbox.ColorDefault)
 	y++
+	if ui.gameOverDetails.FirstWinBonus > 0 {
+		ui.DisplayStaticText(1, y, fmt.Sprintf("*** First win of the day! +%d bonus EXP ***", ui.gameOverDetails.FirstWinBonus), termbox.ColorYellow|termbox.AttrBold, termbox.ColorDefault)
+		y++
+	}
+	for _, item := range ui.gameOverDetails.EXPBreakdown {
+		fg := termbox.ColorWhite
+		if item.EXP < 0 {
+			fg = termbox.ColorDarkGray
+		}
+		ui.DisplayStaticText(3, y, fmt.Sprintf("%+5d  %s", item.EXP, item.Label), fg, termbox.ColorDefault)
+		y++
+	}
 
 	totalExpMsg := fmt.Sprintf("Your Total EXP: %d", ui.gameOverDetails.NewEXP)
 	ui.DisplayStaticText(1, y, totalExpMsg, termbox.ColorWhite, termbox.ColorDefault)
@@ -179,12 +610,34 @@ func (ui *TermboxUI) displayGameOverScreen() {
 	// We can iterate through this if it's populated.
 	if len(ui.gameOverDetails.DestroyedTowers) > 0 {
 		for opponent, count := range ui.gameOverDetails.DestroyedTowers {
+			if y >= h-2 { // Keep the last rows for the instructions
+				break
+			}
 			destroyedMsg := fmt.Sprintf("You destroyed %d of %s's towers.", count, opponent)
 			ui.DisplayStaticText(1, y, destroyedMsg, termbox.ColorCyan, termbox.ColorDefault)
 			y++
 		}
 	}
-	y++
+
+	if d := ui.gameOverDetails; y < h-2 {
+		statsMsg := fmt.Sprintf("Game length: %d:%02d | Troops deployed: you %d, opponent %d | Damage dealt: %d",
+			d.DurationSeconds/60, d.DurationSeconds%60, d.TroopsDeployedByYou, d.TroopsDeployedByOpponent, d.TotalDamageDealt)
+		ui.DisplayStaticText(1, y, statsMsg, termbox.ColorWhite, termbox.ColorDefault)
+		y++
+		for _, line := range ui.summaryLines(d.Summaries) {
+			if y >= h-2 {
+				break
+			}
+			ui.DisplayStaticText(1, y, line, termbox.ColorCyan, termbox.ColorDefault)
+			y++
+		}
+		y++
+	}
+
+	if ui.lastState != nil && y < h-2 {
+		ui.DisplayStaticText(1, y, fmt.Sprintf("[%s] View final battlefield", ui.keymap.Label(ActionFinalState)), termbox.ColorCyan, termbox.ColorDefault)
+		y++
+	}
 
 	// Instructions to continue
 	if y < h-1 {
@@ -198,15 +651,40 @@ func (ui *TermboxUI) displayGameOverScreen() {
 	// termbox.Flush() // Flush is handled by Render
 }
 
-// Render draws the entire game UI based on current state.
+// Render draws the entire game UI based on current state. It must only be called from the
+// goroutine that owns the screen: the event loop while it runs, the main flow otherwise.
+// Other goroutines call RequestRender instead.
 func (ui *TermboxUI) Render() {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	ui.rendering = true
+	defer func() { ui.rendering = false }()
 	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
 
+	if w, h := termbox.Size(); screenTooSmall(w, h) && (ui.currentView == ViewGame || ui.currentView == ViewGameOver || ui.currentView == ViewVersus) {
+		// Keys keep working; the screen comes back once the terminal is made larger
+		ui.DisplayStaticText(0, min(1, h-1), tooSmallMessage(w, h), termbox.ColorYellow|termbox.AttrBold, termbox.ColorDefault)
+		ui.drawAlertBanner()
+		termbox.Flush()
+		return
+	}
+
 	switch ui.currentView {
 	case ViewGame:
-		ui.displayGameScreen()
+		if ui.showInspector {
+			ui.displayInspector()
+		} else {
+			ui.displayGameScreen()
+		}
 	case ViewGameOver:
-		ui.displayGameOverScreen()
+		if ui.showFinalState && ui.lastState != nil {
+			ui.displayGameScreen()
+			ui.drawFinalStateBanner()
+		} else {
+			ui.displayGameOverScreen()
+		}
+	case ViewVersus:
+		ui.displayVersusScreen()
 	case ViewLogin: // Login screen is handled by GetTextInput calls, may not need explicit render state here.
 		ui.DisplayStaticText(1, 1, "Login View (typically handled by input prompts)", termbox.ColorWhite, termbox.ColorDefault)
 	case ViewMatchmaking: // Matchmaking screen similarly might be simple text updates.
@@ -214,35 +692,193 @@ func (ui *TermboxUI) Render() {
 	default:
 		ui.DisplayStaticText(1, 1, fmt.Sprintf("Error: Unknown UI View (%d)", ui.currentView), termbox.ColorRed, termbox.ColorDefault)
 	}
+	ui.drawAlertBanner() // Last, so nothing draws over it
 	termbox.Flush()
 }
 
+// drawFinalStateBanner marks the frozen board shown from the results screen. If the
+// server's final update never arrived, the board is the last one received and says so.
+func (ui *TermboxUI) drawFinalStateBanner() {
+	w, _ := termbox.Size()
+	text := fmt.Sprintf(" FINAL STATE - [%s] back to results ", ui.keymap.Label(ActionFinalState))
+	if !ui.lastState.Final {
+		text = fmt.Sprintf(" FINAL STATE (last update received) - [%s] back to results ", ui.keymap.Label(ActionFinalState))
+	}
+	for x := 0; x < w; x++ {
+		termbox.SetCell(x, 0, ' ', termbox.ColorBlack|termbox.AttrBold, termbox.ColorYellow)
+	}
+	for i, r := range []rune(text) {
+		termbox.SetCell(1+i, 0, r, termbox.ColorBlack|termbox.AttrBold, termbox.ColorYellow)
+	}
+}
+
+// drawAlertBanner draws the current alert, if any, across the top row.
+func (ui *TermboxUI) drawAlertBanner() {
+	banner, ok := ui.alerts.Banner()
+	if !ok {
+		return
+	}
+	w, _ := termbox.Size()
+	text := " !! " + banner + " !! "
+	for x := 0; x < w; x++ {
+		termbox.SetCell(x, 0, ' ', termbox.ColorWhite|termbox.AttrBold, termbox.ColorRed)
+	}
+	for i, r := range []rune(text) {
+		termbox.SetCell(1+i, 0, r, termbox.ColorWhite|termbox.AttrBold, termbox.ColorRed)
+	}
+}
+
 // displayGameScreen renders the main game interface.
 func (ui *TermboxUI) displayGameScreen() {
 	// termbox.Clear(termbox.ColorDefault, termbox.ColorDefault) // Moved to Render()
 
-	currentY := 1 // Start rendering from Y=1
+	w, h := termbox.Size()
+	promptY := h - gamePromptRows // Panels that would reach the prompt are cut short or dropped
+	currentY := 1                 // Start rendering from Y=1
 
 	// Game Info Area (Top)
 	infoLine1 := fmt.Sprintf("Time: %ds | My PlayerID: %s", ui.gameTimer, ui.client.PlayerAccount.Username)
+	if ui.client.Opponent != nil {
+		infoLine1 += " | vs " + opponentSummary(*ui.client.Opponent)
+	}
 
 	myManaBar := makeBar(ui.myMana, 10, 10, '|', '-') // Max mana is 10, bar length 10
 	opponentManaBar := makeBar(ui.opponentMana, 10, 10, '|', '-')
 	infoLine2 := fmt.Sprintf("My Mana: %s %d/10 | Opponent Mana: %s %d/10", myManaBar, ui.myMana, opponentManaBar, ui.opponentMana)
+	if ui.client.PlayerAccount.SettingEnabled(models.SettingHideOpponentMana) {
+		infoLine2 = fmt.Sprintf("My Mana: %s %d/10 | Opponent Mana: hidden", myManaBar, ui.myMana)
+	}
+	mySide, opponentSide := summarizeTowers(ui.towers, ui.client.PlayerAccount.Username)
+	infoLine3 := fmt.Sprintf("You: %d towers (%d%%) | Opp: %d towers (%d%%) | Destroyed: You %d - %d Opponent",
+		mySide.Alive, mySide.HPPercent(), opponentSide.Alive, opponentSide.HPPercent(), ui.myTowerScore, ui.opponentTowerScore)
 
 	ui.DisplayStaticText(1, currentY, infoLine1, termbox.ColorWhite, termbox.ColorBlack)
 	currentY++
 	ui.DisplayStaticText(1, currentY, infoLine2, termbox.ColorWhite, termbox.ColorBlack)
-	currentY += 2 // Add some space
+	currentY++
+	ui.DisplayStaticText(1, currentY, infoLine3, termbox.ColorWhite, termbox.ColorBlack)
+	currentY++
+	if ui.opponentAway != "" { // Only a notice: every key still works, quitting included
+		left := int(math.Ceil(time.Until(ui.opponentAwayUntil).Seconds()))
+		if left < 0 {
+			left = 0
+		}
+		banner := fmt.Sprintf(" %s disconnected - PAUSED, waiting up to %ds for them ([%s] to quit) ", ui.opponentAway, left, ui.keymap.Label(ActionCancel))
+		ui.DisplayStaticText(1, currentY, banner, termbox.ColorBlack|termbox.AttrBold, termbox.ColorYellow)
+	} else if ui.warmup {
+		banner := "Waiting for your opponent to connect..."
+		if ui.startsIn > 0 {
+			banner = fmt.Sprintf("Battle starts in %d...", ui.startsIn)
+		}
+		ui.DisplayStaticText(1, currentY, banner, termbox.ColorYellow|termbox.AttrBold, termbox.ColorBlack)
+	}
+	currentY++ // Add some space
 
 	// Horizontal Separator
 	ui.DisplayStaticText(1, currentY, strings.Repeat("-", 50), termbox.ColorWhite, termbox.ColorBlack)
 	currentY++
 
-	// Display Towers
-	towerHeaderY := currentY
-	ui.DisplayStaticText(1, towerHeaderY, "--- Towers ---", termbox.ColorYellow, termbox.ColorBlack)
+	// Towers and troops: the battlefield panel when it's on and fits, otherwise plain lists.
+	// The event log goes below when there is room left for it.
+	if ui.showBattlefield && battlefieldFits(w) {
+		currentY = ui.drawBattlefield(currentY, w, promptY)
+		if rowsThatFit(currentY, 2, promptY) == 2 {
+			currentY++ // Add some space
+			ui.DisplayStaticText(1, currentY, strings.Repeat("-", 50), termbox.ColorWhite, termbox.ColorBlack)
+			currentY++
+		}
+	} else {
+		currentY = ui.displayEntityLists(currentY, promptY)
+	}
+	if rowsThatFit(currentY, eventLogRows, promptY) == eventLogRows {
+		currentY = ui.displayEventLog(currentY)
+	}
+
+	// Input Area (Bottom)
+	ui.displayDeployPrompt(currentY)
+
+	// termbox.Flush() // Moved to Render()
+}
+
+// displayEventLog renders the event log panel, eventLogRows high, at row currentY and
+// returns the next free row.
+func (ui *TermboxUI) displayEventLog(currentY int) int {
+	eventLogHeaderY := currentY
+	eventLogHeader := fmt.Sprintf("--- Event Log [%s] (%s-%s toggle) ---", ui.eventLog.FilterLabel(),
+		ui.keymap.Label(ActionToggleCombatLog), ui.keymap.Label(ActionToggleErrorLog))
+	ui.DisplayStaticText(1, eventLogHeaderY, eventLogHeader, termbox.ColorYellow, termbox.ColorBlack)
 	currentY++
+	logStartY := currentY
+	visibleLog := ui.eventLog.Visible(maxEventLogMessages)
+	for i, entry := range visibleLog {
+		ui.DisplayStaticText(1, logStartY+i, entry.Text, logEntryColor(entry), termbox.ColorBlack)
+		currentY++
+	}
+	if len(visibleLog) == 0 {
+		emptyMsg := "(No recent events)"
+		if ui.eventLog.Len() > 0 {
+			emptyMsg = "(No events in the shown categories)"
+		}
+		ui.DisplayStaticText(1, currentY, emptyMsg, termbox.ColorDefault, termbox.ColorBlack)
+		// currentY++ // Don't increment if no messages, let logStartY define the block
+	}
+	// Ensure currentY is set correctly for prompts below, accounting for the full height of the log area.
+	currentY = logStartY + maxEventLogMessages // Position right after log messages
+
+	// Horizontal Separator
+	ui.DisplayStaticText(1, currentY, strings.Repeat("-", 50), termbox.ColorWhite, termbox.ColorBlack)
+	currentY++
+	return currentY
+}
+
+// displayDeployPrompt renders the deploy prompt, the selected troop and the spectator count
+// from row currentY down.
+func (ui *TermboxUI) displayDeployPrompt(currentY int) {
+	troopSelectionPromptY := currentY
+	// Build the deploy hint from the keymap; costs come from the received game config
+	var promptParts []string
+	for _, sel := range deploySelections {
+		cost := "?"
+		name := sel.Name
+		if ui.client != nil && ui.client.GameConfig != nil {
+			if spec, ok := ui.client.GameConfig.Troops[sel.TroopID]; ok {
+				cost = fmt.Sprintf("%d", spec.ManaCost)
+				name = ui.client.GameConfig.TroopDisplayName(sel.TroopID)
+			}
+		}
+		promptParts = append(promptParts, fmt.Sprintf("[%s]%s(%s)", ui.keymap.Label(sel.Action), name, cost))
+	}
+	troopSelectionPrompt := fmt.Sprintf("Deploy: %s. %s to Deselect. [%s]Inspect [%s]Battlefield [%s]Resync [%s]Surrender",
+		strings.Join(promptParts, " "), ui.keymap.Label(ActionCancel), ui.keymap.Label(ActionInspector), ui.keymap.Label(ActionBattlefield), ui.keymap.Label(ActionResync), ui.keymap.Label(ActionSurrender))
+	ui.DisplayStaticText(1, troopSelectionPromptY, troopSelectionPrompt, termbox.ColorCyan, termbox.ColorBlack)
+	selectedMsgY := troopSelectionPromptY + 1
+	selectedMsg := "Selected: None"
+	if ui.selectedTroop.TroopID != "" {
+		selectedMsg = fmt.Sprintf("Selected: %s (Press %s to deploy)", ui.selectedTroop.Name, ui.keymap.Label(ActionConfirm))
+		if twoLaneGame(ui.towers) {
+			selectedMsg = fmt.Sprintf("Selected: %s, %s lane (%s/%s to change lane, %s to deploy)", ui.selectedTroop.Name, ui.deployLane(),
+				ui.keymap.Label(ActionLaneLeft), ui.keymap.Label(ActionLaneRight), ui.keymap.Label(ActionConfirm))
+		}
+	}
+	ui.DisplayStaticText(1, selectedMsgY, selectedMsg, termbox.ColorWhite, termbox.ColorBlack)
+	if label := spectatorLabel(ui.spectators, ui.unicodeSymbols); label != "" {
+		ui.DisplayStaticText(1, selectedMsgY+1, label, termbox.ColorDarkGray, termbox.ColorBlack)
+	}
+}
+
+// displayEntityLists renders towers and active troops as text lists starting at row currentY,
+// leaving out the rows from maxY down, and returns the next free row.
+func (ui *TermboxUI) displayEntityLists(currentY, maxY int) int {
+	// line draws one row of the lists, unless it is below the space they have
+	line := func(text string, fg termbox.Attribute) {
+		if currentY < maxY {
+			ui.DisplayStaticText(1, currentY, text, fg, termbox.ColorBlack)
+			currentY++
+		}
+	}
+
+	// Display Towers
+	line("--- Towers ---", termbox.ColorYellow)
 	if len(ui.towers) > 0 {
 		myPlayerID := ""
 		if ui.client != nil && ui.client.PlayerAccount != nil {
@@ -251,36 +887,31 @@ func (ui *TermboxUI) displayGameScreen() {
 		for _, tower := range ui.towers {
 			fgColor := termbox.ColorWhite
 			prefix := "Opponent"
-			if tower.OwnerID == myPlayerID {
+			if tower.Owner == myPlayerID {
 				fgColor = termbox.ColorGreen
 				prefix = "My"
 			} else {
 				fgColor = termbox.ColorRed
 			}
 
-			hpBar := makeBar(tower.CurrentHP, tower.MaxHP, 15, '#', '.') // Bar length 15 for HP
-			towerInfo := fmt.Sprintf("%s %s (ID: %s): HP %s %d/%d", prefix, tower.SpecID, tower.GameSpecificID, hpBar, tower.CurrentHP, tower.MaxHP)
-			if tower.IsDestroyed {
+			hpBar := makeBar(tower.HP, tower.MaxHP, 15, '#', '.') // Bar length 15 for HP
+			towerInfo := fmt.Sprintf("%s %s (ID: %s): HP %s %d/%d", prefix, ui.client.GameConfig.TowerDisplayName(tower.Spec), tower.ID, hpBar, tower.HP, tower.MaxHP)
+			if tower.Destroyed {
 				towerInfo += " [DESTROYED]"
 				fgColor = termbox.ColorDarkGray // Or some other color to indicate destroyed
 			}
-			ui.DisplayStaticText(1, currentY, towerInfo, fgColor, termbox.ColorBlack)
-			currentY++
+			line(towerInfo, fgColor)
 		}
 	} else {
-		ui.DisplayStaticText(1, currentY, "(No tower data yet)", termbox.ColorDefault, termbox.ColorBlack)
-		currentY++
+		line("(No tower data yet)", termbox.ColorDefault)
 	}
-	currentY++ // Add some space
+	line("", termbox.ColorDefault) // Add some space
 
 	// Horizontal Separator
-	ui.DisplayStaticText(1, currentY, strings.Repeat("-", 50), termbox.ColorWhite, termbox.ColorBlack)
-	currentY++
+	line(strings.Repeat("-", 50), termbox.ColorWhite)
 
 	// Display Active Troops
-	troopHeaderY := currentY
-	ui.DisplayStaticText(1, troopHeaderY, "--- Active Troops ---", termbox.ColorYellow, termbox.ColorBlack)
-	currentY++
+	line("--- Active Troops ---", termbox.ColorYellow)
 	if len(ui.activeTroops) > 0 {
 		myPlayerID := ""
 		if ui.client != nil && ui.client.PlayerAccount != nil {
@@ -289,77 +920,63 @@ func (ui *TermboxUI) displayGameScreen() {
 		for id, troop := range ui.activeTroops {
 			fgColor := termbox.ColorWhite
 			prefix := "Opponent's"
-			if troop.OwnerID == myPlayerID {
+			if troop.Owner == myPlayerID {
 				fgColor = termbox.ColorCyan // Friendly troops in Cyan
 				prefix = "My"
 			} else {
 				fgColor = termbox.ColorMagenta // Enemy troops in Magenta
 			}
 
-			hpBar := makeBar(troop.CurrentHP, troop.MaxHP, 10, '#', '.') // Bar length 10 for troop HP
-			troopInfo := fmt.Sprintf("%s %s (ID: %s): HP %s %d/%d, ATK %d", prefix, troop.SpecID, id, hpBar, troop.CurrentHP, troop.MaxHP, troop.CurrentATK)
-			if troop.CurrentHP <= 0 {
+			hpBar := makeBar(troop.HP, troop.MaxHP, 10, '#', '.') // Bar length 10 for troop HP
+			troopInfo := fmt.Sprintf("%s %s (ID: %s): HP %s %d/%d", prefix, ui.client.GameConfig.TroopDisplayName(troop.Spec), id, hpBar, troop.HP, troop.MaxHP)
+			if troop.Shield > 0 {
+				troopInfo += fmt.Sprintf(" (+%d shield)", troop.Shield)
+			}
+			troopInfo += fmt.Sprintf(", ATK %d", troop.ATK)
+			if troop.Lane != "" {
+				troopInfo += fmt.Sprintf(", %s lane", troop.Lane)
+			}
+			if troop.Spawning {
+				troopInfo += fmt.Sprintf(" [spawning %.1fs]", float64(troop.SpawnsInMs)/1000)
+				fgColor = termbox.ColorDarkGray
+			}
+			if troop.ExpiresInMs > 0 {
+				troopInfo += fmt.Sprintf(" [expires in %.1fs]", float64(troop.ExpiresInMs)/1000)
+			}
+			if troop.HP <= 0 {
 				troopInfo += " [DEFEATED]"
 				fgColor = termbox.ColorDarkGray // Or some other color
 			}
-			ui.DisplayStaticText(1, currentY, troopInfo, fgColor, termbox.ColorBlack)
-			currentY++
+			line(troopInfo, fgColor)
 		}
 	} else {
-		ui.DisplayStaticText(1, currentY, "(No active troops on field)", termbox.ColorDefault, termbox.ColorBlack)
-		currentY++
+		line("(No active troops on field)", termbox.ColorDefault)
 	}
-	currentY++ // Add some space
+	line("", termbox.ColorDefault) // Add some space
 
 	// Horizontal Separator
-	ui.DisplayStaticText(1, currentY, strings.Repeat("-", 50), termbox.ColorWhite, termbox.ColorBlack)
-	currentY++
-
-	// Event Log Area
-	eventLogHeaderY := currentY
-	ui.DisplayStaticText(1, eventLogHeaderY, "--- Event Log ---", termbox.ColorYellow, termbox.ColorBlack)
-	currentY++
-	logStartY := currentY
-	for i, msg := range ui.eventLog {
-		if i < maxEventLogMessages { // Ensure we don't try to print too many if log somehow exceeds max
-			ui.DisplayStaticText(1, logStartY+i, msg, termbox.ColorWhite, termbox.ColorBlack)
-			currentY++
-		}
-	}
-	if len(ui.eventLog) == 0 {
-		ui.DisplayStaticText(1, currentY, "(No recent events)", termbox.ColorDefault, termbox.ColorBlack)
-		// currentY++ // Don't increment if no messages, let logStartY define the block
-	}
-	// Ensure currentY is set correctly for prompts below, accounting for the full height of the log area.
-	currentY = logStartY + maxEventLogMessages // Position right after log messages
+	line(strings.Repeat("-", 50), termbox.ColorWhite)
 
-	// Horizontal Separator
-	ui.DisplayStaticText(1, currentY, strings.Repeat("-", 50), termbox.ColorWhite, termbox.ColorBlack)
-	currentY++
+	return currentY
+}
 
-	// Input Area (Bottom)
-	troopSelectionPromptY := currentY
-	var troopSelectionPrompt string
-	if ui.client != nil && ui.client.GameConfig != nil && len(ui.client.GameConfig.Troops) > 0 {
-		pawnCost := ui.client.GameConfig.Troops["pawn"].ManaCost
-		bishopCost := ui.client.GameConfig.Troops["bishop"].ManaCost
-		rookCost := ui.client.GameConfig.Troops["rook"].ManaCost
-		knightCost := ui.client.GameConfig.Troops["knight"].ManaCost
-		princeCost := ui.client.GameConfig.Troops["prince"].ManaCost
-		queenCost := ui.client.GameConfig.Troops["queen"].ManaCost
-		troopSelectionPrompt = fmt.Sprintf("Deploy: [1]Pawn(%d) [2]Bishop(%d) [3]Rook(%d) [4]Knight(%d) [5]Prince(%d) [6]Queen(%d). ESC to Deselect.", pawnCost, bishopCost, rookCost, knightCost, princeCost, queenCost)
-	} else {
-		troopSelectionPrompt = "Deploy: [1]Pawn(?) [2]Bishop(?) [3]Rook(?) [4]Knight(?) [5]Prince(?) [6]Queen(?). ESC to Deselect. (Costs N/A)"
+// handleSurrenderKey asks for confirmation on the first press of the surrender key and
+// surrenders on a second press within surrenderConfirmWindow.
+func (ui *TermboxUI) handleSurrenderKey(now time.Time) {
+	if ui.surrenderArmed.IsZero() || now.Sub(ui.surrenderArmed) > surrenderConfirmWindow {
+		ui.surrenderArmed = now
+		ui.AddEventMessage(LogSystem, fmt.Sprintf("Press %s again within %d seconds to surrender.", ui.keymap.Label(ActionSurrender), int(surrenderConfirmWindow.Seconds())))
+		return
 	}
-	ui.DisplayStaticText(1, troopSelectionPromptY, troopSelectionPrompt, termbox.ColorCyan, termbox.ColorBlack)
-	selectedMsgY := troopSelectionPromptY + 1
-	selectedMsg := "Selected: None"
-	if ui.lastSelectedTroop != 0 {
-		selectedMsg = fmt.Sprintf("Selected: %c (Press Enter to deploy)", ui.lastSelectedTroop)
+	ui.surrenderArmed = time.Time{}
+	if ui.client == nil {
+		return
 	}
-	ui.DisplayStaticText(1, selectedMsgY, selectedMsg, termbox.ColorWhite, termbox.ColorBlack)
-
-	// termbox.Flush() // Moved to Render()
+	if err := ui.client.SendSurrender(); err != nil {
+		ui.AddEventMessage(LogError, fmt.Sprintf("Surrender Error: %v", err))
+		return
+	}
+	ui.AddEventMessage(LogSystem, "You surrendered.")
 }
 
 // ClearScreen clears the termbox screen.
@@ -370,151 +987,198 @@ func (ui *TermboxUI) ClearScreen() {
 // RunSimpleEvacuateLoop runs a basic event loop that waits for Escape key to quit.
 // This is a placeholder for a more complex game UI event loop.
 // Returns true if the loop was exited via ESC (quit), false otherwise (e.g. error).
+// While it runs, this goroutine does all the drawing; see RequestRender.
 func (ui *TermboxUI) RunSimpleEvacuateLoop() bool {
 	// ui.DisplayStaticText(1, 1, "Basic Termbox UI Active. Press ESC to quit.", termbox.ColorWhite, termbox.ColorBlack)
-	ui.Render() // Initial render of the game screen
+	events, stopPolling := pollTermboxEvents()
+	defer stopPolling() // Later prompts poll termbox themselves
+	ui.Render()         // Initial render of the game screen
 	quitRequested := false
 
-mainloop:
-	for {
-		switch ev := termbox.PollEvent(); ev.Type {
+	ui.renders.run(events, func(ev termbox.Event) bool {
+		switch ev.Type {
 		case termbox.EventKey:
-			switch ev.Key {
-			case termbox.KeyEsc:
-				if ui.lastSelectedTroop != 0 {
-					ui.lastSelectedTroop = 0 // Deselect troop
-					// log.Println("Troop selection cleared.")
-				} else {
-					// log.Println("ESC key pressed. Quit requested from UI loop.")
-					quitRequested = true // Signal quit
-					// No longer sending quit message from here
-					break mainloop
+			quit, done := ui.handleKey(ev)
+			quitRequested = quit
+			return !done
+
+		case termbox.EventResize:
+			// log.Println("Screen resized. Redrawing.")
+			ui.Render() // Lays the current view out again for the new size
+
+		case termbox.EventError:
+			// log.Printf("Termbox event error: %v", ev.Err)
+			return false // Exit on error, quitRequested will be false
+		}
+		return true
+	}, ui.Render)
+	return quitRequested
+}
+
+// handleKey acts on a key press in the event loop and redraws. It reports whether the player
+// asked to quit and whether the loop should end.
+func (ui *TermboxUI) handleKey(ev termbox.Event) (quit, done bool) {
+	if ev.Key == termbox.KeyCtrlC {
+		return true, true // Quits from any screen, whatever is selected
+	}
+	action, bound := ui.keymap.Resolve(ev)
+	if ui.view() == ViewGameOver && (!bound || action != ActionCancel) {
+		// The results screen only toggles the final board; Cancel still quits below
+		if bound && action == ActionFinalState {
+			ui.mu.Lock()
+			if ui.lastState != nil {
+				ui.showFinalState = !ui.showFinalState
+			}
+			ui.mu.Unlock()
+			ui.Render()
+		}
+		return false, false
+	}
+	if ui.showInspector {
+		// The overlay swallows all input until it is closed
+		if bound && (action == ActionCancel || action == ActionInspector) {
+			ui.showInspector = false
+		}
+		ui.Render()
+		return false, false
+	}
+	if !bound {
+		// Unbound keys are ignored; free text input would be collected into ui.inputLine here
+		return false, false
+	}
+	switch action {
+	case ActionCancel:
+		if ui.selectedTroop.TroopID != "" {
+			ui.selectedTroop = deploySelection{} // Deselect troop
+			// log.Println("Troop selection cleared.")
+		} else {
+			// log.Println("Cancel pressed with nothing selected. Quit requested from UI loop.")
+			// No longer sending quit message from here
+			return true, true // Signal quit
+		}
+	case ActionConfirm:
+		if ui.selectedTroop.TroopID != "" {
+			if ui.client != nil {
+				lane := ""
+				ui.mu.Lock()
+				if twoLaneGame(ui.towers) {
+					lane = ui.deployLane()
 				}
-			case termbox.KeyEnter:
-				if ui.lastSelectedTroop != 0 {
-					// Convert rune to TroopID string
-					// TODO: This mapping should come from game config or a shared model
-					troopID := ""
-					switch ui.lastSelectedTroop {
-					case '1':
-						troopID = "pawn"
-					case '2':
-						troopID = "bishop"
-					case '3':
-						troopID = "rook"
-					case '4':
-						troopID = "knight"
-					case '5':
-						troopID = "prince"
-					case '6':
-						troopID = "queen"
-					default:
-						// log.Printf("Invalid troop selection: %c", ui.lastSelectedTroop)
-					}
-
-					if troopID != "" && ui.client != nil {
-						err := ui.client.SendDeployTroopCommand(troopID)
-						if err != nil {
-							// log.Printf("Error sending deploy troop command: %v", err)
-							ui.AddEventMessage(fmt.Sprintf("Deploy Error: %v", err))
-						} else {
-							// log.Printf("Deploy troop command sent for: %s (%c)", troopID, ui.lastSelectedTroop)
-							troopName := troopID
-							switch ui.lastSelectedTroop {
-							case '1':
-								troopName = "Pawn"
-							case '2':
-								troopName = "Bishop"
-							case '3':
-								troopName = "Rook"
-							case '4':
-								troopName = "Knight"
-							case '5':
-								troopName = "Prince"
-							case '6':
-								troopName = "Queen"
-							}
-							ui.AddEventMessage(fmt.Sprintf("Deploy command for %s sent.", troopName))
-						}
-					} else if ui.client == nil {
-						// log.Println("Cannot send deploy command: client reference is nil in UI")
-					}
-					ui.lastSelectedTroop = 0 // Clear selection after attempted deployment
+				ui.mu.Unlock()
+				err := ui.client.SendDeployTroopCommand(ui.selectedTroop.TroopID, lane)
+				if err != nil {
+					// log.Printf("Error sending deploy troop command: %v", err)
+					ui.AddEventMessage(LogError, fmt.Sprintf("Deploy Error: %v", err))
+				} else if lane != "" {
+					ui.AddEventMessage(LogDeploy, fmt.Sprintf("Deploy command for %s sent (%s lane).", ui.selectedTroop.Name, lane))
 				} else {
-					// Handle command input if any, from ui.inputLine
-					// log.Printf("Enter pressed. Current input (if any): %s", ui.inputLine)
-					ui.inputLine = "" // Clear input line
-				}
-			default:
-				// Check for troop selection keys '1' through '6'
-				if ev.Ch >= '1' && ev.Ch <= '6' {
-					ui.lastSelectedTroop = ev.Ch
-					// log.Printf("Troop %c selected.", ui.lastSelectedTroop)
-				} else if ev.Ch != 0 {
-					// Append to general input line if not a troop selection
-					// ui.inputLine += string(ev.Ch)
-					// log.Printf("Other key: %c", ev.Ch) // For debugging other inputs
+					// log.Printf("Deploy troop command sent for: %s", ui.selectedTroop.TroopID)
+					ui.AddEventMessage(LogDeploy, fmt.Sprintf("Deploy command for %s sent.", ui.selectedTroop.Name))
 				}
-				// For backspace on ui.inputLine etc., more complex input handling would be needed here
+			} else {
+				// log.Println("Cannot send deploy command: client reference is nil in UI")
+			}
+			ui.selectedTroop = deploySelection{} // Clear selection after attempted deployment
+		} else {
+			// Handle command input if any, from ui.inputLine
+			// log.Printf("Enter pressed. Current input (if any): %s", ui.inputLine)
+			ui.inputLine = "" // Clear input line
+		}
+	case ActionLaneLeft, ActionLaneRight:
+		// Lanes are picked between selecting a troop and deploying it
+		if ui.selectedTroop.TroopID != "" {
+			ui.selectedLane = models.LaneLeft
+			if action == ActionLaneRight {
+				ui.selectedLane = models.LaneRight
 			}
-			ui.Render() // Re-render after any key press that changes state
+		}
+	case ActionInspector:
+		ui.showInspector = true
+	case ActionBattlefield:
+		ui.showBattlefield = !ui.showBattlefield
+	case ActionSurrender:
+		ui.handleSurrenderKey(time.Now())
+	case ActionResync:
+		if ui.client != nil {
+			if sent, err := ui.client.RequestFullState(); err != nil {
+				ui.AddEventMessage(LogError, fmt.Sprintf("Resync Error: %v", err))
+			} else if sent {
+				ui.AddEventMessage(LogSystem, "Asked the server for the full game state.")
+			} else {
+				ui.AddEventMessage(LogSystem, "The game state was requested moments ago; try again shortly.")
+			}
+		}
+	case ActionChat, ActionScrollLogUp, ActionScrollLogDown:
+		// Bound so their keys are reserved; nothing to do until those features exist
+	default:
+		if category, ok := logToggles[action]; ok {
+			ui.mu.Lock()
+			ui.eventLog.Toggle(category)
+			ui.mu.Unlock()
+		} else if sel, ok := deploySelectionFor(action); ok {
+			ui.selectedTroop = sel
+			// log.Printf("Troop %s selected.", sel.TroopID)
+		}
+	}
+	ui.Render() // Re-render after any key press that changes state
+	return false, false
+}
 
-		case termbox.EventResize:
-			// log.Println("Screen resized. Redrawing.")
-			ui.ClearScreen()
-			ui.DisplayStaticText(1, 1, "Basic Termbox UI Active. Press ESC to quit. (Resized)", termbox.ColorWhite, termbox.ColorBlack)
+// WaitForKeyPress blocks until any key is pressed.
+func (ui *TermboxUI) WaitForKeyPress() {
+	for {
+		ev := termbox.PollEvent()
+		if ev.Type == termbox.EventKey || ev.Type == termbox.EventError {
+			return
+		}
+	}
+}
 
+// WaitForKey blocks until a key is pressed and returns its event; a termbox error returns
+// the zero event.
+func (ui *TermboxUI) WaitForKey() termbox.Event {
+	for {
+		ev := termbox.PollEvent()
+		switch ev.Type {
+		case termbox.EventKey:
+			return ev
 		case termbox.EventError:
-			// log.Printf("Termbox event error: %v", ev.Err)
-			break mainloop // Exit on error, quitRequested will be false
+			return termbox.Event{}
 		}
 	}
-	return quitRequested
 }
 
 // GetTextInput prompts the user for text input at a specific location on the termbox screen.
-// This is a very basic implementation.
+// The line can be edited with the arrow keys, Home/End, Backspace and Delete (see editLine).
+// Esc or Ctrl+C cancel the input and return "".
 func (ui *TermboxUI) GetTextInput(prompt string, x, y int, fg, bg termbox.Attribute) string {
 	ui.DisplayStaticText(x, y, prompt, fg, bg)
-	termbox.Flush()
-
-	var runes []rune
 	inputX := x + len(prompt)
+	var editor LineEditor
+	drawn := 0 // Width of the text last drawn, to blank out what a deletion left behind
+	defer termbox.HideCursor()
 
 	for {
+		for i := 0; i < drawn; i++ {
+			termbox.SetCell(inputX+i, y, ' ', fg, bg)
+		}
+		for i, r := range []rune(editor.Text()) {
+			termbox.SetCell(inputX+i, y, r, fg, bg)
+		}
+		drawn = editor.Len()
+		termbox.SetCursor(inputX+editor.Cursor(), y)
+		termbox.Flush()
+
 		ev := termbox.PollEvent()
 		if ev.Type != termbox.EventKey {
 			continue
 		}
-
-		switch ev.Key {
-		case termbox.KeyEnter:
-			return string(runes)
-		case termbox.KeyEsc:
+		switch editLine(&editor, ev) {
+		case lineSubmitted:
+			return editor.Text()
+		case lineCancelled:
 			return "" // Cancel input
-		case termbox.KeySpace:
-			runes = append(runes, ' ')
-		case termbox.KeyBackspace, termbox.KeyBackspace2:
-			if len(runes) > 0 {
-				runes = runes[:len(runes)-1]
-				// Clear the last character
-				termbox.SetCell(inputX+len(runes), y, ' ', fg, bg)
-			}
-		default:
-			if ev.Ch != 0 {
-				runes = append(runes, ev.Ch)
-			}
 		}
-
-		// Display current input
-		// Clear previous input (simple way, could be optimized)
-		for i := 0; i < 50; i++ { // Clear a reasonable width
-			termbox.SetCell(inputX+i, y, ' ', fg, bg)
-		}
-		for i, r := range runes {
-			termbox.SetCell(inputX+i, y, r, fg, bg)
-		}
-		termbox.Flush()
 	}
 }
 
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+
+	"github.com/nsf/termbox-go"
+)
+
+// VersusSplashDuration is how long the "VS" screen stays up before the game view.
+const VersusSplashDuration = 3 * time.Second
+
+// opponentSummary formats an opponent for the HUD, e.g. "Bob (Lv 4, 12W/3L)".
+func opponentSummary(p network.PublicProfile) string {
+	return fmt.Sprintf("%s (Lv %d, %dW/%dL)", p.Username, p.Level, p.Wins, p.Losses)
+}
+
+// streakText describes a win/loss streak, or returns "" when there is none worth showing.
+func streakText(streak int) string {
+	switch {
+	case streak >= 2:
+		return fmt.Sprintf("%d-game win streak", streak)
+	case streak <= -2:
+		return fmt.Sprintf("%d-game losing streak", -streak)
+	}
+	return ""
+}
+
+// profileDetail describes a profile's streak and favorite troop for the "VS" screen, e.g.
+// "3-game win streak, favorite troop: Knight", or returns "" when there is neither.
+func profileDetail(p network.PublicProfile, cfg *models.GameConfig) string {
+	detail := streakText(p.Streak)
+	if p.FavoriteTroop != "" {
+		if detail != "" {
+			detail += ", "
+		}
+		detail += "favorite troop: " + cfg.TroopDisplayName(p.FavoriteTroop)
+	}
+	return detail
+}
+
+// ProfileTotals describes a player's all-time totals, e.g. "12 games played, 840 EXP
+// earned, favorite troop: knight". It returns "" for a player who has not played yet.
+func ProfileTotals(acc *models.PlayerAccount) string {
+	if acc == nil || acc.GamesPlayed == 0 {
+		return ""
+	}
+	totals := fmt.Sprintf("%d games played, %d EXP earned", acc.GamesPlayed, acc.TotalEXPEarned)
+	if favorite := acc.FavoriteTroop(); favorite != "" {
+		totals += ", favorite troop: " + favorite
+	}
+	return totals
+}
+
+// HandicapSummary describes the handicaps of a match, e.g. "alice starting-mana=8; bob
+// stats=x0.80". It returns "" for a match without any.
+func HandicapSummary(handicaps map[string]models.PlayerOverrides) string {
+	usernames := make([]string, 0, len(handicaps))
+	for username := range handicaps {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	parts := make([]string, len(usernames))
+	for i, username := range usernames {
+		parts[i] = username + " " + handicaps[username].String()
+	}
+	return strings.Join(parts, "; ")
+}
+
+// ShowVersusSplash shows the "VS" screen for VersusSplashDuration, then switches to the game view.
+// State updates that arrive meanwhile are kept and drawn once the event loop starts.
+func (ui *TermboxUI) ShowVersusSplash() {
+	ui.SetCurrentView(ViewVersus)
+	ui.Render()
+	time.Sleep(VersusSplashDuration)
+	ui.SetCurrentView(ViewGame)
+}
+
+// displayVersusScreen renders both players' public profiles around a centred "VS".
+func (ui *TermboxUI) displayVersusScreen() {
+	w, h := termbox.Size()
+	y := h/2 - 3
+	if y < 1 {
+		y = 1
+	}
+	centre := func(row int, text string, fg termbox.Attribute) {
+		x := (w - len(text)) / 2
+		if x < 1 {
+			x = 1
+		}
+		ui.DisplayStaticText(x, row, text, fg, termbox.ColorDefault)
+	}
+
+	var me, opponent network.PublicProfile
+	var cfg *models.GameConfig
+	if ui.client != nil && ui.client.PlayerAccount != nil {
+		me = network.NewPublicProfile(ui.client.PlayerAccount)
+	}
+	if ui.client != nil && ui.client.Opponent != nil {
+		opponent = *ui.client.Opponent
+	}
+	if ui.client != nil {
+		cfg = ui.client.GameConfig
+	}
+
+	centre(y, opponentSummary(me), termbox.ColorGreen|termbox.AttrBold)
+	if detail := profileDetail(me, cfg); detail != "" {
+		centre(y+1, detail, termbox.ColorGreen)
+	}
+	centre(y+3, "-- VS --", termbox.ColorYellow|termbox.AttrBold)
+	centre(y+5, opponentSummary(opponent), termbox.ColorRed|termbox.AttrBold)
+	if detail := profileDetail(opponent, cfg); detail != "" {
+		centre(y+6, detail, termbox.ColorRed)
+	}
+}
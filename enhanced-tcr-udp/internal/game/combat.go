@@ -1,44 +1,105 @@
 package game
 
 import (
-	"enhanced-tcr-udp/internal/models"
+	"enhanced-tcr-udp/pkg/models"
 	"math/rand"
+	"sync"
 	"time"
 )
 
-// CalculateDamage calculates damage based on attacker and defender stats.
-// Returns the damage dealt.
-func CalculateDamage(attackerATK, defenderDEF int, isTowerAttack bool, towerCritChance float64) int {
+var (
+	// rng is shared by all game sessions; rand.Rand is not safe for concurrent use, hence rngMu.
+	rng   = rand.New(rand.NewSource(time.Now().UnixNano()))
+	rngMu sync.Mutex
+)
+
+// SeedRNG reseeds the random source used for CRIT rolls.
+// Seeding with a fixed value makes crit-dependent outcomes reproducible across runs.
+func SeedRNG(seed int64) {
+	rngMu.Lock()
+	defer rngMu.Unlock()
+	rng = rand.New(rand.NewSource(seed))
+}
+
+// randFloat64 returns a pseudo-random number in [0.0, 1.0) from the shared source.
+func randFloat64() float64 {
+	rngMu.Lock()
+	defer rngMu.Unlock()
+	return rng.Float64()
+}
+
+// CalculateDamage calculates damage based on attacker and defender stats, scaled by the
+// damage/armor effectiveness multiplier (see Effectiveness; 1.0 is neutral).
+// Returns the damage dealt and whether it was a critical hit.
+func CalculateDamage(attackerATK, defenderDEF int, isTowerAttack bool, towerCritChance float64, effectiveness float64) (int, bool) {
 	dmg := attackerATK - defenderDEF
-	if isTowerAttack && rand.Float64() < towerCritChance { // Check for CRIT
+	crit := false
+	if isTowerAttack && randFloat64() < towerCritChance { // Check for CRIT
 		// Critical Hit Damage: DMG = (Attacker_ATK * 1.2) - Defender_DEF
 		// Ensure ATK is treated as float for multiplication, then convert result to int.
 		dmg = int(float64(attackerATK)*1.2) - defenderDEF
-		// Optionally, log or signal that a CRIT occurred
+		crit = true
 	}
+	dmg = int(float64(dmg) * effectiveness)
 
 	if dmg < 0 {
 		dmg = 0
 	}
-	return dmg
+	return dmg, crit
+}
+
+// ChargedATK returns the attack value for a charge troop's first hit. The multiplier
+// applies to ATK before CalculateDamage, so a charge that also crits is multiplicative:
+// (ATK * multiplier * 1.2) - DEF.
+func ChargedATK(atk int, multiplier float64) int {
+	return int(float64(atk) * multiplier)
+}
+
+// DamageResult is what ApplyDamageToTower or ApplyDamageToTroop did to its target. Callers
+// turn it into events; they do not check the target's HP themselves.
+type DamageResult struct {
+	HPRemoved int  // HP the hit took, not counting overkill
+	Absorbed  int  // Damage a troop's shield took instead of its HP
+	Destroyed bool // This hit brought the target to 0 HP; never set for a target already down
 }
 
 // ApplyDamage reduces defender's HP by the calculated damage.
-// It modifies the CurrentHP of the tower or troop directly.
-func ApplyDamageToTower(tower *models.TowerInstance, damage int) {
-	tower.CurrentHP -= damage
-	if tower.CurrentHP < 0 {
-		tower.CurrentHP = 0
+// It modifies the CurrentHP of the tower or troop directly, and marks a tower brought to 0
+// HP destroyed. attackerID is the instance ID of the troop dealing the damage, remembered
+// so the tower can retaliate; "" if none. A tower already destroyed takes no damage.
+func ApplyDamageToTower(tower *models.TowerInstance, damage int, attackerID string) DamageResult {
+	if tower.IsDestroyed || tower.CurrentHP <= 0 {
+		return DamageResult{}
+	}
+	if attackerID != "" {
+		tower.LastAttackerInstanceID = attackerID
+	}
+	result := DamageResult{HPRemoved: min(damage, tower.CurrentHP)}
+	tower.CurrentHP -= result.HPRemoved
+	if tower.CurrentHP == 0 {
+		tower.IsDestroyed = true
+		result.Destroyed = true
 	}
+	return result
 }
 
 // ApplyDamageToTroop reduces defender's HP by the calculated damage.
-// It modifies the CurrentHP of the tower or troop directly.
-func ApplyDamageToTroop(troop *models.ActiveTroop, damage int) {
-	troop.CurrentHP -= damage
-	if troop.CurrentHP < 0 {
-		troop.CurrentHP = 0
+// It modifies the CurrentHP of the tower or troop directly. A shield takes the damage
+// first and only the overflow reaches HP. A troop already at 0 HP takes no damage.
+func ApplyDamageToTroop(troop *models.ActiveTroop, damage int) DamageResult {
+	var result DamageResult
+	if troop.CurrentHP <= 0 {
+		return result
 	}
+	if troop.ShieldHP > 0 {
+		result.Absorbed = min(damage, troop.ShieldHP)
+		troop.ShieldHP -= result.Absorbed
+		damage -= result.Absorbed
+	}
+	result.HPRemoved = min(damage, troop.CurrentHP)
+	troop.CurrentHP -= result.HPRemoved
+	result.Destroyed = troop.CurrentHP == 0
+	return result
 }
 
 // HealTower increases tower's HP by the heal amount, up to its MaxHP.
@@ -49,8 +110,4 @@ func HealTower(tower *models.TowerInstance, healAmount int) {
 	}
 }
 
-func init() {
-	rand.Seed(time.Now().UnixNano()) // Initialize random seed for CRIT chance
-}
-
 // Damage, CRIT calculation, etc.
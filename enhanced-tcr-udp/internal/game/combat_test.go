@@ -0,0 +1,91 @@
+package game
+
+import (
+	"testing"
+
+	"enhanced-tcr-udp/pkg/models"
+)
+
+// A charge scales ATK before DEF is taken off, and a crit on top of it multiplies again;
+// troop attacks never crit, charged or not.
+func TestChargedDamage(t *testing.T) {
+	tests := []struct {
+		name        string
+		atk         int
+		multiplier  float64
+		towerAttack bool // Only tower attacks roll for a crit
+		critChance  float64
+		want        int
+		wantCrit    bool
+	}{
+		{"second hit", 400, 1, false, 0, 300, false},
+		{"first hit", 400, 2, false, 0, 700, false},
+		{"fractional multiplier truncates", 333, 1.5, false, 0, 399, false},
+		{"troops never crit", 400, 2, false, 1, 700, false},
+		{"crit on a charge multiplies", 400, 2, true, 1, 860, true}, // 400 * 2 * 1.2 - 100
+	}
+	for _, tt := range tests {
+		damage, crit := CalculateDamage(ChargedATK(tt.atk, tt.multiplier), 100, tt.towerAttack, tt.critChance, 1)
+		if damage != tt.want || crit != tt.wantCrit {
+			t.Errorf("%s: damage %d (crit %t), want %d (crit %t)", tt.name, damage, crit, tt.want, tt.wantCrit)
+		}
+	}
+}
+
+// A shield takes damage before HP, and only the overflow of a hit that breaks it reaches HP.
+func TestShieldAbsorbsBeforeHP(t *testing.T) {
+	tests := []struct {
+		name       string
+		shield, hp int
+		damage     int
+		want       DamageResult
+		shieldLeft int
+		hpLeft     int
+	}{
+		{"no shield", 0, 500, 60, DamageResult{HPRemoved: 60}, 0, 440},
+		{"absorbed whole", 100, 500, 60, DamageResult{Absorbed: 60}, 40, 500},
+		{"breaks exactly", 100, 500, 100, DamageResult{Absorbed: 100}, 0, 500},
+		{"overflow reaches HP", 100, 500, 150, DamageResult{Absorbed: 100, HPRemoved: 50}, 0, 450},
+		{"overflow defeats", 100, 50, 200, DamageResult{Absorbed: 100, HPRemoved: 50, Destroyed: true}, 0, 0},
+		{"exactly defeated", 0, 60, 60, DamageResult{HPRemoved: 60, Destroyed: true}, 0, 0},
+		{"overkill", 0, 60, 500, DamageResult{HPRemoved: 60, Destroyed: true}, 0, 0},
+		{"already defeated", 100, 0, 60, DamageResult{}, 100, 0},
+	}
+	for _, tt := range tests {
+		troop := &models.ActiveTroop{ShieldHP: tt.shield, CurrentHP: tt.hp, MaxHP: 500}
+		got := ApplyDamageToTroop(troop, tt.damage)
+		if got != tt.want || troop.ShieldHP != tt.shieldLeft || troop.CurrentHP != tt.hpLeft {
+			t.Errorf("%s: %+v leaving shield %d and HP %d, want %+v leaving %d and %d", tt.name, got, troop.ShieldHP, troop.CurrentHP, tt.want, tt.shieldLeft, tt.hpLeft)
+		}
+	}
+}
+
+// A hit on a tower removes at most the HP it has left, and the hit that brings it to 0
+// destroys it; a destroyed tower takes no more damage and keeps its last attacker.
+func TestApplyDamageToTower(t *testing.T) {
+	tests := []struct {
+		name      string
+		hp        int
+		destroyed bool
+		damage    int
+		want      DamageResult
+		hpLeft    int
+		attacker  string // LastAttackerInstanceID afterwards
+	}{
+		{"survives", 500, false, 60, DamageResult{HPRemoved: 60}, 440, "troop-2"},
+		{"exactly zero", 60, false, 60, DamageResult{HPRemoved: 60, Destroyed: true}, 0, "troop-2"},
+		{"overkill", 60, false, 500, DamageResult{HPRemoved: 60, Destroyed: true}, 0, "troop-2"},
+		{"already destroyed", 0, true, 60, DamageResult{}, 0, "troop-1"},
+	}
+	for _, tt := range tests {
+		tower := &models.TowerInstance{CurrentHP: tt.hp, MaxHP: 500, IsDestroyed: tt.destroyed, LastAttackerInstanceID: "troop-1"}
+		got := ApplyDamageToTower(tower, tt.damage, "troop-2")
+		if got != tt.want || tower.CurrentHP != tt.hpLeft || tower.IsDestroyed != (tt.hpLeft == 0) || tower.LastAttackerInstanceID != tt.attacker {
+			t.Errorf("%s: %+v leaving HP %d (destroyed %t, last attacker %s), want %+v leaving %d (last attacker %s)",
+				tt.name, got, tower.CurrentHP, tower.IsDestroyed, tower.LastAttackerInstanceID, tt.want, tt.hpLeft, tt.attacker)
+		}
+		if again := ApplyDamageToTower(tower, tt.damage, "troop-3"); tower.IsDestroyed && again != (DamageResult{}) {
+			t.Errorf("%s: a second hit on the destroyed tower did %+v, want nothing", tt.name, again)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package game
+
+import "enhanced-tcr-udp/pkg/models"
+
+// Effectiveness returns the damage multiplier for an attack of damageType against armorType,
+// as set in rules.DamageMatrix. Untyped attacks or defenders, and pairs the matrix does not
+// list, are neutral (1.0), so configs without types play exactly as before.
+func Effectiveness(rules models.GameRules, damageType, armorType string) float64 {
+	if damageType == "" || armorType == "" {
+		return 1.0
+	}
+	if multiplier, ok := rules.DamageMatrix[damageType][armorType]; ok {
+		return multiplier
+	}
+	return 1.0
+}
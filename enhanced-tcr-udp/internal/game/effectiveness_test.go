@@ -0,0 +1,50 @@
+package game
+
+import (
+	"testing"
+
+	"enhanced-tcr-udp/pkg/models"
+)
+
+// The default matrix sets each damage type against each armor type; untyped attacks and
+// defenders, and pairs the matrix leaves out, are neutral.
+func TestEffectiveness(t *testing.T) {
+	rules := models.DefaultGameRules()
+	want := map[string]map[string]float64{
+		"piercing": {"light": 1.25, "heavy": 1.0, "fortified": 0.8},
+		"blunt":    {"light": 1.0, "heavy": 1.25, "fortified": 0.8},
+		"siege":    {"light": 0.8, "heavy": 1.0, "fortified": 1.5},
+	}
+	for damageType, row := range want {
+		for armorType, multiplier := range row {
+			if got := Effectiveness(rules, damageType, armorType); got != multiplier {
+				t.Errorf("Effectiveness(%s, %s) = %v, want %v", damageType, armorType, got, multiplier)
+			}
+		}
+	}
+	for _, pair := range [][2]string{{"", "light"}, {"piercing", ""}, {"", ""}, {"magic", "light"}, {"piercing", "ethereal"}} {
+		if got := Effectiveness(rules, pair[0], pair[1]); got != 1.0 {
+			t.Errorf("Effectiveness(%q, %q) = %v, want the neutral 1.0", pair[0], pair[1], got)
+		}
+	}
+}
+
+// Effectiveness scales the damage left after DEF, truncating, and never below zero.
+func TestCalculateDamageEffectiveness(t *testing.T) {
+	tests := []struct {
+		atk, def      int
+		effectiveness float64
+		want          int
+	}{
+		{150, 100, 1.0, 50},
+		{150, 100, 1.25, 62},
+		{150, 100, 0.8, 40},
+		{150, 100, 1.5, 75},
+		{100, 150, 1.5, 0},
+	}
+	for _, tt := range tests {
+		if got, _ := CalculateDamage(tt.atk, tt.def, false, 0, tt.effectiveness); got != tt.want {
+			t.Errorf("CalculateDamage(%d, %d, x%v) = %d, want %d", tt.atk, tt.def, tt.effectiveness, got, tt.want)
+		}
+	}
+}
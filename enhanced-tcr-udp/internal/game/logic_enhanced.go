@@ -1,7 +1,7 @@
 package game
 
 import (
-	"enhanced-tcr-udp/internal/models"
+	"enhanced-tcr-udp/pkg/models"
 	"fmt"
 	"sort"
 	"strings"
@@ -70,12 +70,77 @@ func FindLowestHPTower(attackingPlayerID string, game *models.GameSession) *mode
 	return validTargets[0] // Return the one with the lowest HP
 }
 
-// FindTroopToAttack selects a troop for a tower to attack.
-// Logic: last attacker or oldest attacker.
-// This simplified version attacks the "oldest" deployed troop (by DeployedAt timestamp).
-func FindTroopToAttack(towerOwnerID string, game *models.GameSession) *models.ActiveTroop {
+// FindTargetTower finds the opponent's tower a troop attacks. A troop deployed into a lane
+// (two-lane mode) attacks the weakest tower guarding its lane; once all of them have
+// fallen, the towers covering both lanes (the King Tower) are its targets. A troop without
+// a lane picks from every tower, as FindLowestHPTower does.
+func FindTargetTower(troop *models.ActiveTroop, game *models.GameSession) *models.TowerInstance {
+	if troop.Lane == "" {
+		return FindLowestHPTower(troop.OwnerID, game)
+	}
+	opponentPlayer := game.Player1
+	if game.Player1 != nil && game.Player1.Account.Username == troop.OwnerID {
+		opponentPlayer = game.Player2
+	}
+	if opponentPlayer == nil {
+		return nil
+	}
+
+	var laneTowers, sharedTowers []*models.TowerInstance
+	for _, t := range opponentPlayer.Towers {
+		switch {
+		case t.CurrentHP <= 0:
+		case t.Lane == troop.Lane:
+			laneTowers = append(laneTowers, t)
+		case t.Lane == "":
+			sharedTowers = append(sharedTowers, t)
+		}
+	}
+	if len(laneTowers) == 0 {
+		laneTowers = sharedTowers // The lane is open: the King Tower is exposed
+	}
+
+	var target *models.TowerInstance
+	for _, t := range laneTowers {
+		if target == nil || t.CurrentHP < target.CurrentHP {
+			target = t
+		}
+	}
+	return target
+}
+
+// PlayersWithTargets returns, by username, whether each player of the game has an opponent
+// tower standing for their troops to attack. FindTargetTower finds nothing for a troop whose
+// owner maps to false, so a game loop can skip those troops for the tick.
+func PlayersWithTargets(game *models.GameSession) map[string]bool {
+	hasTargets := make(map[string]bool, 2)
+	for _, pair := range [][2]*models.PlayerInGame{{game.Player1, game.Player2}, {game.Player2, game.Player1}} {
+		attacker, defender := pair[0], pair[1]
+		if attacker == nil {
+			continue
+		}
+		hasTargets[attacker.Account.Username] = defender != nil && hasStandingTower(defender)
+	}
+	return hasTargets
+}
+
+// hasStandingTower reports whether any of the player's towers still has HP.
+func hasStandingTower(player *models.PlayerInGame) bool {
+	for _, t := range player.Towers {
+		if t.CurrentHP > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// FindTroopToAttack selects a troop for a tower to attack, following the tower spec's
+// TargetPriority. With "retaliate" (the default) the tower attacks its last attacker while
+// that troop is still alive; otherwise, and with "oldest", it attacks the oldest deployed
+// troop (by DeployedAt timestamp).
+func FindTroopToAttack(tower *models.TowerInstance, game *models.GameSession) *models.ActiveTroop {
 	var opponentPlayer *models.PlayerInGame
-	if game.Player1.Account.Username == towerOwnerID {
+	if game.Player1.Account.Username == tower.OwnerID {
 		opponentPlayer = game.Player2
 	} else {
 		opponentPlayer = game.Player1
@@ -98,6 +163,16 @@ func FindTroopToAttack(towerOwnerID string, game *models.GameSession) *models.Ac
 		return nil
 	}
 
+	targeting := models.TargetPriorityRetaliate
+	if game.GameConfig != nil {
+		targeting = game.GameConfig.Towers[tower.SpecID].Targeting()
+	}
+	if targeting == models.TargetPriorityRetaliate && tower.LastAttackerInstanceID != "" {
+		if attacker, ok := opponentPlayer.DeployedTroops[tower.LastAttackerInstanceID]; ok && attacker.CurrentHP > 0 {
+			return attacker
+		}
+	}
+
 	// Sort by DeployedAt time to find the "oldest"
 	sort.Slice(opponentActiveTroops, func(i, j int) bool {
 		return opponentActiveTroops[i].DeployedAt.Before(opponentActiveTroops[j].DeployedAt)
@@ -0,0 +1,60 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"enhanced-tcr-udp/pkg/models"
+)
+
+// A retaliating tower attacks the troop that last hit it, not the oldest one, and goes back
+// to the oldest troop once that attacker is dead or gone. An "oldest" tower ignores who hit it.
+func TestTowerRetaliatesAgainstLastAttacker(t *testing.T) {
+	deployed := time.Now()
+	setup := func(targeting string) (*models.GameSession, *models.TowerInstance) {
+		game := testSession(towerHPLeft{1000, 1000}, towerHPLeft{1000, 1000}, 1000)
+		if targeting != "" {
+			game.GameConfig = &models.GameConfig{Towers: map[string]models.TowerSpec{
+				"king_tower": {ID: "king_tower", TargetPriority: targeting},
+			}}
+		}
+		game.Player2.DeployedTroops = map[string]*models.ActiveTroop{
+			"old": {InstanceID: "old", OwnerID: "bob", CurrentHP: 100, DeployedAt: deployed},
+			"new": {InstanceID: "new", OwnerID: "bob", CurrentHP: 100, DeployedAt: deployed.Add(time.Second)},
+		}
+		return game, game.Player1.Towers[0]
+	}
+	targetOf := func(tower *models.TowerInstance, game *models.GameSession) string {
+		if troop := FindTroopToAttack(tower, game); troop != nil {
+			return troop.InstanceID
+		}
+		return ""
+	}
+
+	game, king := setup("")
+	if got := targetOf(king, game); got != "old" {
+		t.Fatalf("before any hit the tower targets %q, want the oldest troop", got)
+	}
+	ApplyDamageToTower(king, 10, "new")
+	if got := targetOf(king, game); got != "new" {
+		t.Errorf("after new hit it the tower targets %q, want new", got)
+	}
+	ApplyDamageToTower(king, 10, "") // Damage with no attacker does not change the target
+	if got := targetOf(king, game); got != "new" {
+		t.Errorf("after an unattributed hit the tower targets %q, want new still", got)
+	}
+	game.Player2.DeployedTroops["new"].CurrentHP = 0
+	if got := targetOf(king, game); got != "old" {
+		t.Errorf("with its attacker dead the tower targets %q, want the oldest troop", got)
+	}
+	delete(game.Player2.DeployedTroops, "new")
+	if got := targetOf(king, game); got != "old" {
+		t.Errorf("with its attacker removed the tower targets %q, want the oldest troop", got)
+	}
+
+	game, king = setup(models.TargetPriorityOldest)
+	ApplyDamageToTower(king, 10, "new")
+	if got := targetOf(king, game); got != "old" {
+		t.Errorf("an %q tower hit by new targets %q, want the oldest troop", models.TargetPriorityOldest, got)
+	}
+}
@@ -1,3 +1,34 @@
 package game
 
+import (
+	"math"
+	"time"
+)
+
 // EXP, leveling, etc.
+
+// LevelMultiplier returns the stat multiplier for a player of the given level:
+// (1 + perLevelBonus)^(level-1). Level 1 (and anything below) plays with base stats.
+func LevelMultiplier(level int, perLevelBonus float64) float64 {
+	if level <= 1 {
+		return 1.0
+	}
+	return math.Pow(1+perLevelBonus, float64(level-1))
+}
+
+// ScaleStat applies a level multiplier to a base stat. Results are floored, matching how
+// stats have always been truncated; the small epsilon keeps float error in the multiplier
+// (e.g. 1.1^2 = 1.2100000000000002 or 1.2099999999999999) from costing a point.
+func ScaleStat(base int, multiplier float64) int {
+	return int(math.Floor(float64(base)*multiplier + 1e-9))
+}
+
+// SurrenderConsolationEXP returns the extra EXP for the winner of a game their opponent
+// surrendered after played of game time: perMinute for each minute, rounded down. A longer
+// game means more tower EXP the winner was denied, so it grows with time played.
+func SurrenderConsolationEXP(played time.Duration, perMinute float64) int {
+	if played <= 0 || perMinute <= 0 {
+		return 0
+	}
+	return int(math.Floor(played.Minutes()*perMinute + 1e-9))
+}
@@ -0,0 +1,48 @@
+package game
+
+import (
+	"math"
+	"testing"
+)
+
+// Stats grow by the per-level bonus compounded once per level above 1; a 0% bonus leaves
+// every level on base stats.
+func TestLevelMultiplier(t *testing.T) {
+	tests := []struct {
+		level int
+		bonus float64
+		want  float64
+		hp    int // ScaleStat of a 1000 HP base
+	}{
+		{level: 0, bonus: 0.10, want: 1, hp: 1000},
+		{level: 1, bonus: 0.10, want: 1, hp: 1000},
+		{level: 2, bonus: 0.10, want: 1.1, hp: 1100},
+		{level: 5, bonus: 0.10, want: 1.4641, hp: 1464},
+		{level: 20, bonus: 0.10, want: 6.115909044841462, hp: 6115},
+		{level: 1, bonus: 0, want: 1, hp: 1000},
+		{level: 2, bonus: 0, want: 1, hp: 1000},
+		{level: 5, bonus: 0, want: 1, hp: 1000},
+		{level: 20, bonus: 0, want: 1, hp: 1000},
+	}
+	for _, tt := range tests {
+		got := LevelMultiplier(tt.level, tt.bonus)
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("LevelMultiplier(%d, %v) = %v, want %v", tt.level, tt.bonus, got, tt.want)
+		}
+		if hp := ScaleStat(1000, got); hp != tt.hp {
+			t.Errorf("ScaleStat(1000, LevelMultiplier(%d, %v)) = %d, want %d", tt.level, tt.bonus, hp, tt.hp)
+		}
+	}
+}
+
+// ScaleStat floors, but float error in the multiplier does not cost a point.
+func TestScaleStatFloatError(t *testing.T) {
+	for _, multiplier := range []float64{1.2100000000000002, 1.2099999999999999} {
+		if got := ScaleStat(100, multiplier); got != 121 {
+			t.Errorf("ScaleStat(100, %v) = %d, want 121", multiplier, got)
+		}
+	}
+	if got := ScaleStat(100, 1.219); got != 121 {
+		t.Errorf("ScaleStat(100, 1.219) = %d, want 121 (floored)", got)
+	}
+}
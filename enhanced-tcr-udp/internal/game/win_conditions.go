@@ -0,0 +1,273 @@
+package game
+
+import (
+	"fmt"
+	"time"
+
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// Per-player game results, as recorded by models.PlayerAccount.RecordOutcome.
+const (
+	ResultWin  = "win"
+	ResultLoss = "loss"
+	ResultDraw = "draw"
+)
+
+// EXP bonuses on top of the EXP for destroyed towers, from the project plan.
+const (
+	WinEXPBonus  = 30
+	DrawEXPBonus = 10
+)
+
+// EndTrigger describes why a game is ending, with whatever the reason needs to be judged.
+type EndTrigger struct {
+	Reason string // One of the network.GameEnd* reasons
+
+	Player1Quit, Player2Quit bool   // network.GameEndPlayerQuit: who left
+	SurrenderedBy            string // network.GameEndSurrender: username of the player who gave up
+	ForcedWinner             string // network.GameEndForced: username the operator declared the winner; "" for a draw
+	ForcedNote               string // network.GameEndForced: the operator's reason
+
+	Elapsed time.Duration // Game clock run so far; sizes the surrender consolation EXP
+}
+
+// PlayerOutcome is one player's share of an Outcome.
+type PlayerOutcome struct {
+	Result          string // ResultWin, ResultLoss or ResultDraw
+	EXP             int    // EXP earned this game, bonuses included
+	TowersDestroyed int    // Opponent towers this player destroyed; the timeout tiebreak
+
+	// Breakdown itemizes EXP. Evaluators that leave parts out have them reported as
+	// network.EXPSourceOther.
+	Breakdown network.EXPBreakdown
+}
+
+// Outcome is the judged end of a game.
+type Outcome struct {
+	Reason           string
+	Winner           string // Username of the winner; "" for a draw
+	Summary          string // e.g. "alice won (King Tower)"
+	Detail           string // Extra context for the session log, such as the tiebreak; may be empty
+	Player1, Player2 PlayerOutcome
+	ConsolationEXP   int // Part of the winner's EXP paid for time played in a surrendered game
+}
+
+// WinConditionEvaluator decides who won a game that is ending. Implementations must not
+// modify the session; the caller persists and announces the Outcome.
+type WinConditionEvaluator interface {
+	Evaluate(session *models.GameSession, trigger EndTrigger, rules models.GameRules) Outcome
+}
+
+// DefaultWinConditions implements the rules from the project plan. Destroying the King Tower
+// wins outright; at timeout the player who destroyed more towers wins, and equal counts draw
+// unless rules.TimeoutHPTiebreak settles them by the share of tower HP left.
+// A player who quits or surrenders loses, and an operator's forced result stands. Everyone
+// earns the EXP of the towers they destroyed plus WinEXPBonus or DrawEXPBonus, and the winner
+// of a surrendered game also gets rules.SurrenderEXPPerMinute for each minute played.
+type DefaultWinConditions struct{}
+
+// Evaluate implements WinConditionEvaluator.
+func (DefaultWinConditions) Evaluate(session *models.GameSession, trigger EndTrigger, rules models.GameRules) Outcome {
+	p1, p2 := session.Player1.Account.Username, session.Player2.Account.Username
+	outcome := Outcome{Reason: trigger.Reason}
+	outcome.Player1.TowersDestroyed = destroyedTowers(session.Player2)
+	outcome.Player2.TowersDestroyed = destroyedTowers(session.Player1)
+
+	switch trigger.Reason {
+	case network.GameEndKingTowerDestroyed:
+		// The player whose King Tower still stands wins
+		p1KingDestroyed := kingDestroyed(session.GameConfig, session.Player1)
+		p2KingDestroyed := kingDestroyed(session.GameConfig, session.Player2)
+		switch {
+		case p1KingDestroyed && !p2KingDestroyed:
+			outcome.setWinner(p2, p1, p2, "King Tower")
+		case p2KingDestroyed && !p1KingDestroyed:
+			outcome.setWinner(p1, p1, p2, "King Tower")
+		default:
+			// Both fell in the same tick, or neither did and the caller was mistaken
+			outcome.Detail = fmt.Sprintf("Ambiguous King Tower destruction state (p1King: %v, p2King: %v). Declaring draw.", p1KingDestroyed, p2KingDestroyed)
+			outcome.setDraw("Simultaneous King Tower Destruction or Error")
+		}
+
+	case network.GameEndTimeout:
+		outcome.Detail = fmt.Sprintf("Timeout: Player 1 destroyed %d towers, Player 2 destroyed %d towers.", outcome.Player1.TowersDestroyed, outcome.Player2.TowersDestroyed)
+		switch {
+		case outcome.Player1.TowersDestroyed > outcome.Player2.TowersDestroyed:
+			outcome.setWinner(p1, p1, p2, "Most Towers")
+		case outcome.Player2.TowersDestroyed > outcome.Player1.TowersDestroyed:
+			outcome.setWinner(p2, p1, p2, "Most Towers")
+		case rules.TimeoutHPTiebreak:
+			p1HP, p1MaxHP := towerHP(session.Player1)
+			p2HP, p2MaxHP := towerHP(session.Player2)
+			outcome.Detail += fmt.Sprintf(" Tower HP left: Player 1 %d/%d, Player 2 %d/%d.", p1HP, p1MaxHP, p2HP, p2MaxHP)
+			// Compares p1HP/p1MaxHP with p2HP/p2MaxHP, so higher-level towers are no advantage
+			switch p1Share, p2Share := p1HP*p2MaxHP, p2HP*p1MaxHP; {
+			case p1Share > p2Share:
+				outcome.setWinner(p1, p1, p2, "Most Tower HP Left")
+			case p2Share > p1Share:
+				outcome.setWinner(p2, p1, p2, "Most Tower HP Left")
+			default:
+				outcome.setDraw("Equal Towers Destroyed and HP Left")
+			}
+		default:
+			outcome.setDraw("Equal Towers Destroyed")
+		}
+
+	case network.GameEndPlayerQuit:
+		// The quitter loses
+		switch {
+		case trigger.Player1Quit && !trigger.Player2Quit:
+			outcome.setWinner(p2, p1, p2, "Opponent Quit")
+		case trigger.Player2Quit && !trigger.Player1Quit:
+			outcome.setWinner(p1, p1, p2, "Opponent Quit")
+		default:
+			outcome.Detail = "Both players quit or quit state unclear. Declaring draw."
+			outcome.setDraw("Both Players Quit or Undetermined")
+		}
+
+	case network.GameEndSurrender:
+		// The player who did not surrender wins
+		if trigger.SurrenderedBy == p1 {
+			outcome.setWinner(p2, p1, p2, "Opponent Surrendered")
+		} else {
+			outcome.setWinner(p1, p1, p2, "Opponent Surrendered")
+		}
+
+	case network.GameEndForced:
+		switch trigger.ForcedWinner {
+		case p1, p2:
+			outcome.setWinner(trigger.ForcedWinner, p1, p2, "Forced: "+trigger.ForcedNote)
+		default:
+			outcome.setDraw("Forced: " + trigger.ForcedNote)
+		}
+
+	default:
+		outcome.Detail = fmt.Sprintf("Unknown game end reason: %s. Declaring draw.", trigger.Reason)
+		outcome.setDraw("Unknown Reason")
+	}
+
+	// EXP for the towers each player destroyed, then the result bonus
+	outcome.Player1.addEXP(network.EXPSourceTowers, fmt.Sprintf("Towers destroyed (%d)", outcome.Player1.TowersDestroyed), destroyedTowerEXP(session.GameConfig, session.Player2))
+	outcome.Player2.addEXP(network.EXPSourceTowers, fmt.Sprintf("Towers destroyed (%d)", outcome.Player2.TowersDestroyed), destroyedTowerEXP(session.GameConfig, session.Player1))
+	outcome.Player1.addEXP(network.EXPSourceResult, resultBonusLabel(outcome.Player1.Result), resultBonus(outcome.Player1.Result))
+	outcome.Player2.addEXP(network.EXPSourceResult, resultBonusLabel(outcome.Player2.Result), resultBonus(outcome.Player2.Result))
+
+	// The winner of a surrendered game is also paid for the time played, since the early
+	// end cost them the chance to take more towers
+	if trigger.Reason == network.GameEndSurrender {
+		outcome.ConsolationEXP = SurrenderConsolationEXP(trigger.Elapsed, rules.SurrenderEXPPerMinute)
+		winner := &outcome.Player2
+		if outcome.Winner == p1 {
+			winner = &outcome.Player1
+		}
+		winner.addEXP(network.EXPSourceSurrender, "Opponent surrendered (time played)", outcome.ConsolationEXP)
+	}
+	return outcome
+}
+
+// addEXP adds exp to the player's EXP and, unless it is 0, itemizes it in the breakdown.
+func (p *PlayerOutcome) addEXP(source, label string, exp int) {
+	p.EXP += exp
+	if exp != 0 {
+		p.Breakdown = append(p.Breakdown, network.EXPItem{Source: source, Label: label, EXP: exp})
+	}
+}
+
+// setWinner records winner (one of p1 and p2) as having won for the given reason label.
+func (o *Outcome) setWinner(winner, p1, p2, label string) {
+	o.Winner = winner
+	o.Summary = fmt.Sprintf("%s won (%s)", winner, label)
+	o.Player1.Result, o.Player2.Result = ResultLoss, ResultWin
+	if winner == p1 {
+		o.Player1.Result, o.Player2.Result = ResultWin, ResultLoss
+	}
+}
+
+// setDraw records a draw for the given reason label.
+func (o *Outcome) setDraw(label string) {
+	o.Winner = ""
+	o.Summary = fmt.Sprintf("Draw (%s)", label)
+	o.Player1.Result, o.Player2.Result = ResultDraw, ResultDraw
+}
+
+// IsKingTower reports whether tower is a King Tower according to cfg.
+func IsKingTower(cfg *models.GameConfig, tower *models.TowerInstance) bool {
+	if cfg == nil {
+		return false
+	}
+	spec, ok := cfg.Towers[tower.SpecID]
+	return ok && IsKingTowerSpec(spec)
+}
+
+// IsKingTowerSpec reports whether spec describes a King Tower.
+func IsKingTowerSpec(spec models.TowerSpec) bool {
+	return spec.Name == "King Tower"
+}
+
+// kingDestroyed reports whether the player's King Tower has fallen.
+func kingDestroyed(cfg *models.GameConfig, player *models.PlayerInGame) bool {
+	for _, tower := range player.Towers {
+		if tower.IsDestroyed && IsKingTower(cfg, tower) {
+			return true
+		}
+	}
+	return false
+}
+
+// destroyedTowers counts the player's towers that have been destroyed.
+func destroyedTowers(player *models.PlayerInGame) int {
+	n := 0
+	for _, tower := range player.Towers {
+		if tower.IsDestroyed {
+			n++
+		}
+	}
+	return n
+}
+
+// towerHP returns the HP the player's towers have left and their combined max HP.
+func towerHP(player *models.PlayerInGame) (hp, maxHP int) {
+	for _, tower := range player.Towers {
+		if !tower.IsDestroyed {
+			hp += tower.CurrentHP
+		}
+		maxHP += tower.MaxHP
+	}
+	return hp, maxHP
+}
+
+// destroyedTowerEXP sums the EXP yield of the player's destroyed towers, which goes to
+// their opponent.
+func destroyedTowerEXP(cfg *models.GameConfig, player *models.PlayerInGame) int {
+	exp := 0
+	for _, tower := range player.Towers {
+		if !tower.IsDestroyed || cfg == nil {
+			continue
+		}
+		if spec, ok := cfg.Towers[tower.SpecID]; ok {
+			exp += spec.EXPYield
+		}
+	}
+	return exp
+}
+
+// resultBonus returns the EXP bonus for a result.
+func resultBonus(result string) int {
+	switch result {
+	case ResultWin:
+		return WinEXPBonus
+	case ResultDraw:
+		return DrawEXPBonus
+	}
+	return 0
+}
+
+// resultBonusLabel describes the bonus resultBonus returns for a result.
+func resultBonusLabel(result string) string {
+	if result == ResultDraw {
+		return "Draw bonus"
+	}
+	return "Win bonus"
+}
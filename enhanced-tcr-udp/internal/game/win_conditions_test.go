@@ -0,0 +1,179 @@
+package game
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// testTowerConfig has a King Tower worth 100 EXP and a Guard Tower worth 50.
+var testTowerConfig = &models.GameConfig{
+	Towers: map[string]models.TowerSpec{
+		"king_tower":  {ID: "king_tower", Name: "King Tower", EXPYield: 100},
+		"guard_tower": {ID: "guard_tower", Name: "Guard Tower", EXPYield: 50},
+	},
+}
+
+// towerHPLeft is the HP a player's towers are left with; a tower at 0 is destroyed.
+type towerHPLeft struct{ king, guard int }
+
+// testSession returns a session between alice and bob whose towers have the HP left given.
+// Each of alice's towers has 1000 max HP and each of bob's bobMaxHP.
+func testSession(alice, bob towerHPLeft, bobMaxHP int) *models.GameSession {
+	player := func(username, prefix string, left towerHPLeft, maxHP int) *models.PlayerInGame {
+		tower := func(specID string, hp int) *models.TowerInstance {
+			return &models.TowerInstance{
+				SpecID: specID, OwnerID: username, GameSpecificID: prefix + "_" + specID,
+				CurrentHP: hp, MaxHP: maxHP, IsDestroyed: hp == 0,
+			}
+		}
+		return &models.PlayerInGame{
+			Account: models.PlayerAccount{Username: username},
+			Towers:  []*models.TowerInstance{tower("king_tower", left.king), tower("guard_tower", left.guard)},
+		}
+	}
+	return &models.GameSession{
+		Player1:    player("alice", "player1", alice, 1000),
+		Player2:    player("bob", "player2", bob, bobMaxHP),
+		GameConfig: testTowerConfig,
+	}
+}
+
+func TestDefaultWinConditions(t *testing.T) {
+	full := towerHPLeft{1000, 1000}
+	rules := models.DefaultGameRules()
+	hpTiebreak := models.DefaultGameRules()
+	hpTiebreak.TimeoutHPTiebreak = true
+
+	tests := []struct {
+		name         string
+		alice, bob   towerHPLeft
+		bobMaxHP     int
+		trigger      EndTrigger
+		rules        models.GameRules
+		winner       string
+		summary      string
+		aliceResult  string
+		bobResult    string
+		aliceEXP     int
+		bobEXP       int
+		consolation  int
+		detailSubstr string
+	}{
+		{
+			name: "king tower destroyed", alice: full, bob: towerHPLeft{0, 0},
+			trigger: EndTrigger{Reason: network.GameEndKingTowerDestroyed}, rules: rules,
+			winner: "alice", summary: "alice won (King Tower)", aliceResult: ResultWin, bobResult: ResultLoss,
+			aliceEXP: 150 + WinEXPBonus, bobEXP: 0,
+		},
+		{
+			name: "double king", alice: towerHPLeft{0, 500}, bob: towerHPLeft{0, 0},
+			trigger: EndTrigger{Reason: network.GameEndKingTowerDestroyed}, rules: rules,
+			summary: "Draw (Simultaneous King Tower Destruction or Error)", aliceResult: ResultDraw, bobResult: ResultDraw,
+			aliceEXP: 150 + DrawEXPBonus, bobEXP: 100 + DrawEXPBonus, detailSubstr: "p1King: true, p2King: true",
+		},
+		{
+			name: "timeout, more towers", alice: full, bob: towerHPLeft{1000, 0},
+			trigger: EndTrigger{Reason: network.GameEndTimeout}, rules: rules,
+			winner: "alice", summary: "alice won (Most Towers)", aliceResult: ResultWin, bobResult: ResultLoss,
+			aliceEXP: 50 + WinEXPBonus, bobEXP: 0, detailSubstr: "Player 1 destroyed 1 towers, Player 2 destroyed 0",
+		},
+		{
+			name: "timeout, tied towers without HP tiebreak", alice: towerHPLeft{1000, 0}, bob: towerHPLeft{100, 0},
+			trigger: EndTrigger{Reason: network.GameEndTimeout}, rules: rules,
+			summary: "Draw (Equal Towers Destroyed)", aliceResult: ResultDraw, bobResult: ResultDraw,
+			aliceEXP: 50 + DrawEXPBonus, bobEXP: 50 + DrawEXPBonus,
+		},
+		{
+			name: "timeout, tied towers, HP tiebreak", alice: towerHPLeft{1000, 0}, bob: towerHPLeft{100, 0},
+			trigger: EndTrigger{Reason: network.GameEndTimeout}, rules: hpTiebreak,
+			winner: "alice", summary: "alice won (Most Tower HP Left)", aliceResult: ResultWin, bobResult: ResultLoss,
+			aliceEXP: 50 + WinEXPBonus, bobEXP: 50, detailSubstr: "Tower HP left: Player 1 1000/2000, Player 2 100/2000.",
+		},
+		{
+			name: "timeout, tied towers, HP tiebreak by share of max HP", alice: towerHPLeft{600, 600}, bob: towerHPLeft{1000, 1000},
+			bobMaxHP: 2000, trigger: EndTrigger{Reason: network.GameEndTimeout}, rules: hpTiebreak,
+			winner: "alice", summary: "alice won (Most Tower HP Left)", aliceResult: ResultWin, bobResult: ResultLoss,
+			aliceEXP: WinEXPBonus, bobEXP: 0,
+		},
+		{
+			name: "timeout, tied towers and HP", alice: towerHPLeft{500, 500}, bob: towerHPLeft{1000, 1000},
+			bobMaxHP: 2000, trigger: EndTrigger{Reason: network.GameEndTimeout}, rules: hpTiebreak,
+			summary: "Draw (Equal Towers Destroyed and HP Left)", aliceResult: ResultDraw, bobResult: ResultDraw,
+			aliceEXP: DrawEXPBonus, bobEXP: DrawEXPBonus,
+		},
+		{
+			name: "player 2 quit", alice: full, bob: full,
+			trigger: EndTrigger{Reason: network.GameEndPlayerQuit, Player2Quit: true}, rules: rules,
+			winner: "alice", summary: "alice won (Opponent Quit)", aliceResult: ResultWin, bobResult: ResultLoss,
+			aliceEXP: WinEXPBonus, bobEXP: 0,
+		},
+		{
+			name: "quit vs quit", alice: full, bob: towerHPLeft{1000, 0},
+			trigger: EndTrigger{Reason: network.GameEndPlayerQuit, Player1Quit: true, Player2Quit: true}, rules: rules,
+			summary: "Draw (Both Players Quit or Undetermined)", aliceResult: ResultDraw, bobResult: ResultDraw,
+			aliceEXP: 50 + DrawEXPBonus, bobEXP: DrawEXPBonus, detailSubstr: "Both players quit",
+		},
+		{
+			name: "surrender", alice: full, bob: towerHPLeft{1000, 0},
+			trigger: EndTrigger{Reason: network.GameEndSurrender, SurrenderedBy: "alice", Elapsed: 150 * time.Second}, rules: rules,
+			winner: "bob", summary: "bob won (Opponent Surrendered)", aliceResult: ResultLoss, bobResult: ResultWin,
+			aliceEXP: 50, bobEXP: WinEXPBonus + 25, consolation: 25,
+		},
+		{
+			name: "forced winner", alice: full, bob: full,
+			trigger: EndTrigger{Reason: network.GameEndForced, ForcedWinner: "bob", ForcedNote: "admin"}, rules: rules,
+			winner: "bob", summary: "bob won (Forced: admin)", aliceResult: ResultLoss, bobResult: ResultWin,
+			aliceEXP: 0, bobEXP: WinEXPBonus,
+		},
+		{
+			name: "forced draw", alice: full, bob: full,
+			trigger: EndTrigger{Reason: network.GameEndForced, ForcedNote: "admin"}, rules: rules,
+			summary: "Draw (Forced: admin)", aliceResult: ResultDraw, bobResult: ResultDraw,
+			aliceEXP: DrawEXPBonus, bobEXP: DrawEXPBonus,
+		},
+		{
+			name: "unknown reason", alice: full, bob: full,
+			trigger: EndTrigger{Reason: "meteor"}, rules: rules,
+			summary: "Draw (Unknown Reason)", aliceResult: ResultDraw, bobResult: ResultDraw,
+			aliceEXP: DrawEXPBonus, bobEXP: DrawEXPBonus, detailSubstr: "Unknown game end reason: meteor",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bobMaxHP := tt.bobMaxHP
+			if bobMaxHP == 0 {
+				bobMaxHP = 1000
+			}
+			got := DefaultWinConditions{}.Evaluate(testSession(tt.alice, tt.bob, bobMaxHP), tt.trigger, tt.rules)
+
+			if got.Reason != tt.trigger.Reason || got.Winner != tt.winner || got.Summary != tt.summary {
+				t.Errorf("Evaluate() = reason %q, winner %q, summary %q; want %q, %q, %q", got.Reason, got.Winner, got.Summary, tt.trigger.Reason, tt.winner, tt.summary)
+			}
+			if got.Player1.Result != tt.aliceResult || got.Player2.Result != tt.bobResult {
+				t.Errorf("results = %s/%s, want %s/%s", got.Player1.Result, got.Player2.Result, tt.aliceResult, tt.bobResult)
+			}
+			if got.Player1.EXP != tt.aliceEXP || got.Player2.EXP != tt.bobEXP {
+				t.Errorf("EXP = %d/%d, want %d/%d", got.Player1.EXP, got.Player2.EXP, tt.aliceEXP, tt.bobEXP)
+			}
+			if got.ConsolationEXP != tt.consolation {
+				t.Errorf("ConsolationEXP = %d, want %d", got.ConsolationEXP, tt.consolation)
+			}
+			if !strings.Contains(got.Detail, tt.detailSubstr) {
+				t.Errorf("Detail = %q, want it to mention %q", got.Detail, tt.detailSubstr)
+			}
+			for _, p := range []PlayerOutcome{got.Player1, got.Player2} {
+				itemized := 0
+				for _, item := range p.Breakdown {
+					itemized += item.EXP
+				}
+				if itemized != p.EXP {
+					t.Errorf("breakdown %v adds up to %d EXP, want %d", p.Breakdown, itemized, p.EXP)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package persistence
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AnalyticsMaxFileBytes is the size past which the current analytics file is rotated, and
+// AnalyticsMaxFiles how many analytics files (the current one included) are kept; the
+// oldest rotated files are removed beyond that.
+var (
+	AnalyticsMaxFileBytes int64 = 4 << 20
+	AnalyticsMaxFiles           = 8
+)
+
+// AnalyticsRecord is the anonymized record of one finished game written for balance
+// analysis. Players are identified only by AnonymizeUsername, so the records cannot be
+// joined to accounts without the server's salt.
+type AnalyticsRecord struct {
+	EndedOn         string             `json:"ended_on"` // UTC date the game ended, as 2006-01-02
+	DurationSeconds int                `json:"duration_seconds"`
+	EndReason       string             `json:"end_reason"`
+	Casual          bool               `json:"casual,omitempty"`
+	Players         [2]AnalyticsPlayer `json:"players"`
+}
+
+// AnalyticsPlayer is one side of an AnalyticsRecord.
+type AnalyticsPlayer struct {
+	ID      string         `json:"id"` // Salted hash of the username; see AnonymizeUsername
+	Level   int            `json:"level"`
+	Outcome string         `json:"outcome"` // "win", "loss" or "draw"
+	Deck    map[string]int `json:"deck"`    // TroopSpec.ID -> times deployed
+}
+
+var (
+	analyticsMu   sync.Mutex // Serialises appends and rotation of the analytics files
+	analyticsSalt []byte     // Loaded by loadAnalyticsSalt
+	saltRoot      string     // dataRoot analyticsSalt was loaded from
+)
+
+// analyticsDir returns the directory holding the analytics files and the salt.
+func analyticsDir() string {
+	return filepath.Join(dataRoot, "analytics")
+}
+
+// analyticsPath returns the analytics file currently appended to.
+func analyticsPath() string {
+	return filepath.Join(analyticsDir(), "matches.jsonl")
+}
+
+// loadAnalyticsSalt returns the server's analytics salt, creating it on first use. The
+// caller must hold analyticsMu.
+func loadAnalyticsSalt() ([]byte, error) {
+	if analyticsSalt != nil && saltRoot == dataRoot {
+		return analyticsSalt, nil
+	}
+	path := filepath.Join(analyticsDir(), "salt")
+	encoded, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		salt := make([]byte, 32)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(analyticsDir(), 0755); err != nil {
+			return nil, err
+		}
+		encoded = []byte(hex.EncodeToString(salt))
+		err = os.WriteFile(path, encoded, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	salt, err := hex.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil || len(salt) == 0 {
+		return nil, fmt.Errorf("analytics salt %s is corrupt", path)
+	}
+	analyticsSalt, saltRoot = salt, dataRoot
+	return salt, nil
+}
+
+// AnonymizeUsername returns the ID a player has in the analytics records: a hash of the
+// username keyed with this server's salt, the same for every game the player plays here.
+func AnonymizeUsername(username string) (string, error) {
+	analyticsMu.Lock()
+	defer analyticsMu.Unlock()
+	salt, err := loadAnalyticsSalt()
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(username))
+	return hex.EncodeToString(mac.Sum(nil)[:16]), nil
+}
+
+// AppendAnalyticsRecord adds a game to the current analytics file, first rotating the file
+// if the record would take it past AnalyticsMaxFileBytes.
+func AppendAnalyticsRecord(record AnalyticsRecord) error {
+	analyticsMu.Lock()
+	defer analyticsMu.Unlock()
+
+	if err := os.MkdirAll(analyticsDir(), 0755); err != nil {
+		return err
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(analyticsPath()); err == nil && info.Size() > 0 && info.Size()+int64(len(line))+1 > AnalyticsMaxFileBytes {
+		if err := rotateAnalytics(time.Now()); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(analyticsPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotateAnalytics renames the current analytics file after the time it was rotated and
+// removes the oldest rotated files past AnalyticsMaxFiles. The caller must hold analyticsMu.
+func rotateAnalytics(now time.Time) error {
+	rotated := filepath.Join(analyticsDir(), "matches-"+now.UTC().Format("20060102T150405.000")+".jsonl")
+	if err := os.Rename(analyticsPath(), rotated); err != nil {
+		return err
+	}
+	files, err := analyticsFiles()
+	if err != nil {
+		return err
+	}
+	// files lists the rotated files oldest first; the current file no longer exists.
+	for len(files) >= AnalyticsMaxFiles && len(files) > 0 {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// analyticsFiles returns the analytics files on disk, oldest first, ending with the current
+// file if it exists. A missing analytics directory gives none.
+func analyticsFiles() ([]string, error) {
+	entries, err := os.ReadDir(analyticsDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rotated []string
+	current := false
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case entry.IsDir():
+		case name == "matches.jsonl":
+			current = true
+		case strings.HasPrefix(name, "matches-") && strings.HasSuffix(name, ".jsonl"):
+			rotated = append(rotated, filepath.Join(analyticsDir(), name))
+		}
+	}
+	sort.Strings(rotated) // The timestamps in the names sort chronologically
+	if current {
+		rotated = append(rotated, analyticsPath())
+	}
+	return rotated, nil
+}
+
+// ReadAnalyticsRecords reads every record from the analytics files on disk, oldest first.
+// Lines that do not parse, e.g. one cut short by a crash, are counted in skipped.
+func ReadAnalyticsRecords() (records []AnalyticsRecord, skipped int, err error) {
+	analyticsMu.Lock()
+	defer analyticsMu.Unlock()
+
+	files, err := analyticsFiles()
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			return records, skipped, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var record AnalyticsRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				skipped++
+				continue
+			}
+			records = append(records, record)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return records, skipped, err
+		}
+	}
+	return records, skipped, nil
+}
+
+// AnalyticsSummary aggregates a set of AnalyticsRecords.
+type AnalyticsSummary struct {
+	Games                  int
+	Draws                  int
+	AverageDurationSeconds float64
+	EndReasons             map[string]int
+	// LevelGaps counts, by how many levels apart the players were, the games and the wins
+	// of the higher-level player. Games between players of the same level are not counted.
+	LevelGaps map[int]LevelGapStats
+	// Troops counts, per TroopSpec.ID, the sides that deployed the troop at least once and
+	// how many of those won.
+	Troops map[string]TroopPickStats
+}
+
+// LevelGapStats is one entry of AnalyticsSummary.LevelGaps.
+type LevelGapStats struct {
+	Games           int
+	HigherLevelWins int
+}
+
+// TroopPickStats is one entry of AnalyticsSummary.Troops.
+type TroopPickStats struct {
+	Picks int
+	Wins  int
+}
+
+// PickRate is the share of sides that deployed the troop, out of the sides in games.
+func (s TroopPickStats) PickRate(games int) float64 {
+	if games == 0 {
+		return 0
+	}
+	return float64(s.Picks) / float64(2*games)
+}
+
+// SummarizeAnalytics aggregates records.
+func SummarizeAnalytics(records []AnalyticsRecord) AnalyticsSummary {
+	summary := AnalyticsSummary{
+		EndReasons: make(map[string]int),
+		LevelGaps:  make(map[int]LevelGapStats),
+		Troops:     make(map[string]TroopPickStats),
+	}
+	totalSeconds := 0
+	for _, record := range records {
+		summary.Games++
+		summary.EndReasons[record.EndReason]++
+		totalSeconds += record.DurationSeconds
+
+		p1, p2 := record.Players[0], record.Players[1]
+		if p1.Outcome == "draw" {
+			summary.Draws++
+		}
+		if p1.Level != p2.Level {
+			higher, gap := p1, p1.Level-p2.Level
+			if gap < 0 {
+				higher, gap = p2, -gap
+			}
+			stats := summary.LevelGaps[gap]
+			stats.Games++
+			if higher.Outcome == "win" {
+				stats.HigherLevelWins++
+			}
+			summary.LevelGaps[gap] = stats
+		}
+		for _, player := range record.Players {
+			for troop, deploys := range player.Deck {
+				if deploys <= 0 {
+					continue
+				}
+				stats := summary.Troops[troop]
+				stats.Picks++
+				if player.Outcome == "win" {
+					stats.Wins++
+				}
+				summary.Troops[troop] = stats
+			}
+		}
+	}
+	if summary.Games > 0 {
+		summary.AverageDurationSeconds = float64(totalSeconds) / float64(summary.Games)
+	}
+	return summary
+}
@@ -0,0 +1,49 @@
+package persistence
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A username gets the same analytics ID every time under one salt, kept in the data root
+// across restarts, and a different one under another data root's salt. Different usernames
+// get different IDs, and none gives away the username.
+func TestAnonymizeUsernameSalting(t *testing.T) {
+	useTempDataRoot(t)
+	anonymize := func(username string) string {
+		t.Helper()
+		id, err := AnonymizeUsername(username)
+		if err != nil {
+			t.Fatalf("anonymizing %s: %v", username, err)
+		}
+		return id
+	}
+
+	alice := anonymize("alice")
+	if again := anonymize("alice"); again != alice {
+		t.Errorf("alice is %s, then %s under the same salt", alice, again)
+	}
+	if bob := anonymize("bob"); bob == alice {
+		t.Errorf("alice and bob share the ID %s", alice)
+	}
+	if len(alice) != 32 || alice == "alice" {
+		t.Errorf("alice's ID is %q, want 32 hex digits", alice)
+	}
+	info, err := os.Stat(filepath.Join(analyticsDir(), "salt"))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("salt file: %v (%v), want it readable by the server only", info, err)
+	}
+
+	analyticsMu.Lock()
+	analyticsSalt = nil // As after a restart: the salt is read back from the data root
+	analyticsMu.Unlock()
+	if restarted := anonymize("alice"); restarted != alice {
+		t.Errorf("alice is %s after a restart, want %s as before", restarted, alice)
+	}
+
+	SetDataRoot(t.TempDir()) // Another server, with its own salt
+	if other := anonymize("alice"); other == alice {
+		t.Errorf("alice is %s under two different salts", alice)
+	}
+}
@@ -0,0 +1,80 @@
+package persistence
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// MatchRecord is the history entry written when a game ends. Per-player maps are keyed by username.
+type MatchRecord struct {
+	GameID          string         `json:"game_id"`
+	Player1         string         `json:"player1"`
+	Player2         string         `json:"player2"`
+	WinnerID        string         `json:"winner_id,omitempty"` // Empty for a draw
+	EndReason       string         `json:"end_reason"`
+	EndedAt         time.Time      `json:"ended_at"`
+	DurationSeconds int            `json:"duration_seconds"`
+	TroopsDeployed  map[string]int `json:"troops_deployed"`
+	DamageDealt     map[string]int `json:"damage_dealt"`
+	TowersDestroyed map[string]int `json:"towers_destroyed"` // Towers each player destroyed
+	// EXP itemizes the EXP each player earned; absent in older records.
+	EXP map[string]network.EXPBreakdown `json:"exp,omitempty"`
+	// Summaries sums up each player's game, including their MVP troop; absent in older records.
+	Summaries     map[string]network.PlayerGameSummary `json:"summaries,omitempty"`
+	SurrenderedBy string                               `json:"surrendered_by,omitempty"` // Username of the player who surrendered, if one did
+	Casual        bool                                 `json:"casual,omitempty"`         // Played in the casual queue; not counted in the players' records
+	Perf          *MatchPerf                           `json:"perf,omitempty"`           // How hard the server worked to run the game; absent in older records
+}
+
+// MatchPerf summarises the load a game put on the server. Durations are in nanoseconds in
+// JSON.
+type MatchPerf struct {
+	Ticks          int           `json:"ticks"`            // Game loop ticks completed
+	TickP50        time.Duration `json:"tick_p50"`         // Median tick duration
+	TickP95        time.Duration `json:"tick_p95"`         // 95th percentile tick duration
+	TickMax        time.Duration `json:"tick_max"`         // Longest tick
+	PeakTroops     int           `json:"peak_troops"`      // Most troops on the field at once
+	PeakQueueDepth int           `json:"peak_queue_depth"` // Most actions waiting in one player's queue
+}
+
+// OverBudget reports whether the 95th percentile tick took longer than tickInterval, i.e.
+// the game loop regularly could not keep up with its ticker.
+func (p MatchPerf) OverBudget(tickInterval time.Duration) bool {
+	return p.TickP95 > tickInterval
+}
+
+// matchHistoryMu serialises appends to the match history file.
+var matchHistoryMu sync.Mutex
+
+// matchHistoryPath returns the file holding one JSON match record per line.
+func matchHistoryPath() string {
+	return filepath.Join(dataRoot, "match_history.jsonl")
+}
+
+// AppendMatchRecord adds a finished game to the match history.
+func AppendMatchRecord(record MatchRecord) error {
+	matchHistoryMu.Lock()
+	defer matchHistoryMu.Unlock()
+
+	if err := os.MkdirAll(dataRoot, 0755); err != nil {
+		return err
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(matchHistoryPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,103 @@
+package persistence
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// PendingResultTTL is how long undelivered game results are kept for a player.
+// Results older than this are discarded instead of being delivered on login.
+const PendingResultTTL = 7 * 24 * time.Hour
+
+// pendingResult is the on-disk record of one undelivered GameOverResults.
+type pendingResult struct {
+	Result  network.GameOverResults `json:"result"`
+	SavedAt time.Time               `json:"saved_at"`
+}
+
+// pendingResultsMu serialises read-modify-write cycles on pending result files.
+var pendingResultsMu sync.Mutex
+
+// pendingResultsDir returns the directory holding one pending results file per player.
+func pendingResultsDir() string {
+	return filepath.Join(dataRoot, "pending_results")
+}
+
+// SavePendingResult stores game results that could not be delivered to a player,
+// so they can be handed over on the player's next login. Results are keyed by GameID:
+// saving a game's results again replaces the earlier copy instead of queueing a duplicate.
+func SavePendingResult(username string, result network.GameOverResults) error {
+	pendingResultsMu.Lock()
+	defer pendingResultsMu.Unlock()
+
+	records, err := readPendingResults(username)
+	if err != nil {
+		return err
+	}
+	record := pendingResult{Result: result, SavedAt: time.Now()}
+	replaced := false
+	for i := range records {
+		if result.GameID != "" && records[i].Result.GameID == result.GameID {
+			records[i] = record
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		records = append(records, record)
+	}
+
+	if err := os.MkdirAll(pendingResultsDir(), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(pendingResultsDir(), username+".json"), data, 0644)
+}
+
+// LoadAndClearPendingResults returns the player's undelivered results (oldest first),
+// skipping any older than PendingResultTTL, and removes them from storage.
+func LoadAndClearPendingResults(username string) ([]network.GameOverResults, error) {
+	pendingResultsMu.Lock()
+	defer pendingResultsMu.Unlock()
+
+	records, err := readPendingResults(username)
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	if err := os.Remove(filepath.Join(pendingResultsDir(), username+".json")); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	results := make([]network.GameOverResults, 0, len(records))
+	for _, record := range records {
+		if time.Since(record.SavedAt) > PendingResultTTL {
+			continue
+		}
+		results = append(results, record.Result)
+	}
+	return results, nil
+}
+
+// readPendingResults loads a player's pending result records; a missing file means none.
+func readPendingResults(username string) ([]pendingResult, error) {
+	data, err := os.ReadFile(filepath.Join(pendingResultsDir(), username+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var records []pendingResult
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
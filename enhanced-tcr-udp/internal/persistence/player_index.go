@@ -0,0 +1,185 @@
+package persistence
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"enhanced-tcr-udp/pkg/models"
+)
+
+// PlayerSummary is what the player index keeps about an account: enough to list, rank and
+// look up players without reading their files.
+type PlayerSummary struct {
+	Username  string    `json:"username"`
+	Level     int       `json:"level"`
+	EXP       int       `json:"exp"`
+	Wins      int       `json:"wins"`
+	Losses    int       `json:"losses"`
+	LastLogin time.Time `json:"last_login"`
+}
+
+// summarizePlayer returns the index entry for an account.
+func summarizePlayer(acc *models.PlayerAccount) PlayerSummary {
+	return PlayerSummary{
+		Username:  acc.Username,
+		Level:     acc.Level,
+		EXP:       acc.EXP,
+		Wins:      acc.Wins,
+		Losses:    acc.Losses,
+		LastLogin: acc.LastLogin,
+	}
+}
+
+// PlayerIndex holds a summary of every account in the data root. It is built by scanning the
+// account files once, on first use or by RebuildPlayerIndex, and SavePlayerAccount keeps it
+// up to date from then on. It is safe for concurrent use.
+type PlayerIndex struct {
+	mu      sync.RWMutex
+	players map[string]PlayerSummary // Username -> summary; nil until built
+}
+
+// playerIndex indexes the accounts under dataRoot. SetDataRoot discards it.
+var playerIndex = &PlayerIndex{}
+
+// ensureBuilt scans the account files if the index has not been built yet.
+func (idx *PlayerIndex) ensureBuilt() error {
+	idx.mu.RLock()
+	built := idx.players != nil
+	idx.mu.RUnlock()
+	if built {
+		return nil
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.players != nil { // Built by another caller meanwhile
+		return nil
+	}
+	_, err := idx.scanLocked()
+	return err
+}
+
+// rebuild replaces the index with a fresh scan of the account files and returns how many
+// files could not be read.
+func (idx *PlayerIndex) rebuild() (int, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.scanLocked()
+}
+
+// scanLocked reads every account file into a new index. Saves wait for it, so none can be
+// lost between reading a file and installing the result. Unreadable files are skipped and
+// counted. idx.mu must be held for writing.
+func (idx *PlayerIndex) scanLocked() (int, error) {
+	entries, err := os.ReadDir(playerDataDir())
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	players := make(map[string]PlayerSummary, len(entries))
+	skipped := 0
+	for _, entry := range entries {
+		username, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok {
+			continue
+		}
+		acc, err := LoadPlayerAccount(username)
+		if err != nil {
+			skipped++
+			continue
+		}
+		players[username] = summarizePlayer(acc)
+	}
+	idx.players = players
+	return skipped, nil
+}
+
+// update records a saved account.
+func (idx *PlayerIndex) update(acc *models.PlayerAccount) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.players != nil { // An index not built yet reads the file when it is
+		idx.players[acc.Username] = summarizePlayer(acc)
+	}
+}
+
+// reset discards the index, to be rebuilt on next use.
+func (idx *PlayerIndex) reset() {
+	idx.mu.Lock()
+	idx.players = nil
+	idx.mu.Unlock()
+}
+
+// summaries returns a copy of every entry, in no particular order.
+func (idx *PlayerIndex) summaries() ([]PlayerSummary, error) {
+	if err := idx.ensureBuilt(); err != nil {
+		return nil, err
+	}
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	players := make([]PlayerSummary, 0, len(idx.players))
+	for _, summary := range idx.players {
+		players = append(players, summary)
+	}
+	return players, nil
+}
+
+// RebuildPlayerIndex rescans the account files, for startup and for when the index may have
+// drifted from them (e.g. files edited by hand). It returns how many accounts are indexed
+// and how many files could not be read.
+func RebuildPlayerIndex() (indexed, skipped int, err error) {
+	if skipped, err = playerIndex.rebuild(); err != nil {
+		return 0, 0, err
+	}
+	playerIndex.mu.RLock()
+	indexed = len(playerIndex.players)
+	playerIndex.mu.RUnlock()
+	return indexed, skipped, nil
+}
+
+// PlayerExists reports whether an account with the given username is indexed.
+func PlayerExists(username string) (bool, error) {
+	if err := playerIndex.ensureBuilt(); err != nil {
+		return false, err
+	}
+	playerIndex.mu.RLock()
+	defer playerIndex.mu.RUnlock()
+	_, ok := playerIndex.players[username]
+	return ok, nil
+}
+
+// ListPlayers returns every indexed account, sorted by username.
+func ListPlayers() ([]PlayerSummary, error) {
+	players, err := playerIndex.summaries()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(players, func(i, j int) bool { return players[i].Username < players[j].Username })
+	return players, nil
+}
+
+// GetLeaderboard returns the top n indexed accounts by level, then EXP, then wins; ties go
+// to the earlier username. n <= 0 returns every account.
+func GetLeaderboard(n int) ([]PlayerSummary, error) {
+	players, err := playerIndex.summaries()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(players, func(i, j int) bool {
+		a, b := players[i], players[j]
+		switch {
+		case a.Level != b.Level:
+			return a.Level > b.Level
+		case a.EXP != b.EXP:
+			return a.EXP > b.EXP
+		case a.Wins != b.Wins:
+			return a.Wins > b.Wins
+		}
+		return a.Username < b.Username
+	})
+	if n > 0 && len(players) > n {
+		players = players[:n]
+	}
+	return players, nil
+}
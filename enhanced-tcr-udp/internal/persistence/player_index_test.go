@@ -0,0 +1,104 @@
+package persistence
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"enhanced-tcr-udp/pkg/models"
+)
+
+// usernames returns the usernames of players, in order.
+func usernames(players []PlayerSummary) []string {
+	names := make([]string, 0, len(players))
+	for _, p := range players {
+		names = append(names, p.Username)
+	}
+	return names
+}
+
+// The player index picks up every save at once, and a rebuild brings it back in line with
+// account files changed behind its back, skipping the ones it cannot read.
+func TestPlayerIndexFollowsSavesAndRebuilds(t *testing.T) {
+	useTempDataRoot(t)
+	hash := strings.Repeat("h", 60) // Long enough to be taken as already hashed
+	save := func(username string, level, exp int) {
+		t.Helper()
+		if err := SavePlayerAccount(&models.PlayerAccount{Username: username, HashedPassword: hash, Level: level, EXP: exp}); err != nil {
+			t.Fatalf("saving %s: %v", username, err)
+		}
+	}
+	leaders := func() []string {
+		t.Helper()
+		players, err := GetLeaderboard(0)
+		if err != nil {
+			t.Fatalf("leaderboard: %v", err)
+		}
+		return usernames(players)
+	}
+
+	save("carol", 2, 10) // Saved before the index is built: found by the first scan
+	save("alice", 1, 50)
+	if got := leaders(); !reflect.DeepEqual(got, []string{"carol", "alice"}) {
+		t.Errorf("leaderboard %v, want carol then alice", got)
+	}
+
+	save("bob", 2, 30) // Saved into the built index
+	if exists, err := PlayerExists("bob"); err != nil || !exists {
+		t.Errorf("bob exists: %t (%v), want true right after the save", exists, err)
+	}
+	if got := leaders(); !reflect.DeepEqual(got, []string{"bob", "carol", "alice"}) {
+		t.Errorf("leaderboard %v, want bob, carol, alice", got)
+	}
+	save("alice", 3, 0)
+	if got := leaders(); !reflect.DeepEqual(got, []string{"alice", "bob", "carol"}) {
+		t.Errorf("leaderboard after alice levelled up %v, want alice first", got)
+	}
+	if top, err := GetLeaderboard(1); err != nil || !reflect.DeepEqual(usernames(top), []string{"alice"}) {
+		t.Errorf("top 1: %v (%v), want alice", usernames(top), err)
+	}
+
+	// Files edited by hand: carol promoted, bob removed and an unreadable file added
+	data, err := os.ReadFile(playerFilePath("carol"))
+	if err != nil {
+		t.Fatalf("reading carol's file: %v", err)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatalf("decoding carol's file: %v", err)
+	}
+	raw["level"] = 9
+	if data, err = json.Marshal(raw); err != nil {
+		t.Fatalf("encoding carol's file: %v", err)
+	}
+	if err := os.WriteFile(playerFilePath("carol"), data, 0644); err != nil {
+		t.Fatalf("writing carol's file: %v", err)
+	}
+	if err := os.Remove(playerFilePath("bob")); err != nil {
+		t.Fatalf("removing bob's file: %v", err)
+	}
+	if err := os.WriteFile(playerFilePath("broken"), []byte("{not json"), 0644); err != nil {
+		t.Fatalf("writing an unreadable file: %v", err)
+	}
+	if got := leaders(); !reflect.DeepEqual(got, []string{"alice", "bob", "carol"}) {
+		t.Errorf("leaderboard before the rebuild %v, want it unchanged", got)
+	}
+
+	indexed, skipped, err := RebuildPlayerIndex()
+	if err != nil || indexed != 2 || skipped != 1 {
+		t.Fatalf("rebuild: %d indexed, %d skipped (%v); want 2 and 1", indexed, skipped, err)
+	}
+	if got := leaders(); !reflect.DeepEqual(got, []string{"carol", "alice"}) {
+		t.Errorf("leaderboard after the rebuild %v, want carol then alice", got)
+	}
+	players, err := ListPlayers()
+	if err != nil || len(players) != 2 || players[1].Username != "carol" || players[1].Level != 9 {
+		t.Errorf("players after the rebuild %+v (%v), want alice and carol at level 9", players, err)
+	}
+	save("dave", 1, 0) // The rebuilt index still follows saves
+	if exists, err := PlayerExists("dave"); err != nil || !exists {
+		t.Errorf("dave exists: %t (%v), want true after a save following the rebuild", exists, err)
+	}
+}
@@ -0,0 +1,79 @@
+package persistence
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrServerLocked is returned by AcquireServerLock while a live process holds the lock.
+var ErrServerLocked = errors.New("data root is locked by another process")
+
+// ServerLock is the exclusive lock a server, or an offline account tool, holds on the data
+// root while it writes there. Two servers sharing one data root would overwrite each
+// other's account files.
+type ServerLock struct {
+	file *os.File
+	path string
+
+	// Reclaimed is the process ID in a lock file left behind by a process that is gone,
+	// e.g. a server that crashed; 0 if there was none.
+	Reclaimed int
+}
+
+// ServerLockPath returns the lock file kept in the data root while it is locked.
+func ServerLockPath() string {
+	return filepath.Join(dataRoot, "server.lock")
+}
+
+// AcquireServerLock locks the data root, writing this process's ID to the lock file. While
+// a live process holds the lock it fails with an error wrapping ErrServerLocked; a lock
+// left by a process that has died is taken over and reported in ServerLock.Reclaimed.
+// Where the system offers flock the lock is held by the open file, so it dies with the
+// process; elsewhere the file is created exclusively and its process ID checked.
+func AcquireServerLock() (*ServerLock, error) {
+	if err := os.MkdirAll(dataRoot, 0755); err != nil {
+		return nil, err
+	}
+	return acquireServerLock(ServerLockPath())
+}
+
+// Release unlocks the data root and removes the lock file. Releasing twice does nothing.
+func (l *ServerLock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	err := l.release()
+	l.file = nil
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// readLockPID returns the process ID written in a lock file, or 0 if there is none.
+func readLockPID(f *os.File) int {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
+
+// writeLockPID replaces the contents of a lock file with this process's ID.
+func writeLockPID(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		return err
+	}
+	return f.Sync()
+}
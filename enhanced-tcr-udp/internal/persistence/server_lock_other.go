@@ -0,0 +1,63 @@
+//go:build !unix
+
+package persistence
+
+import (
+	"fmt"
+	"os"
+)
+
+// acquireServerLock creates the lock file exclusively. An existing one is taken over only
+// if the process it names is no longer running.
+func acquireServerLock(path string) (*ServerLock, error) {
+	reclaimed := 0
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			if err := writeLockPID(f); err != nil {
+				f.Close()
+				os.Remove(path)
+				return nil, err
+			}
+			return &ServerLock{file: f, path: path, Reclaimed: reclaimed}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		existing, err := os.Open(path)
+		if err != nil {
+			continue // Removed meanwhile; try to create it again
+		}
+		pid := readLockPID(existing)
+		existing.Close()
+		if pid != 0 && pid != os.Getpid() && processAlive(pid) {
+			return nil, fmt.Errorf("%w: %s is held by process %d", ErrServerLocked, path, pid)
+		}
+		reclaimed = pid
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("%w: %s was recreated while being reclaimed", ErrServerLocked, path)
+}
+
+// processAlive reports whether a process with the given ID is running. On Windows,
+// os.FindProcess fails for a process that does not exist.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
+
+// release closes the lock file before removing it, since Windows cannot remove open files.
+func (l *ServerLock) release() error {
+	closeErr := l.file.Close()
+	if err := os.Remove(l.path); err != nil {
+		return err
+	}
+	return closeErr
+}
@@ -0,0 +1,82 @@
+package persistence
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Contenders racing for the data root lock, some releasing it while others open the lock
+// file, never hold it two at a time, and are only ever refused with ErrServerLocked. Run
+// with -race.
+func TestServerLockContended(t *testing.T) {
+	useTempDataRoot(t)
+	const contenders, attempts = 8, 200
+
+	var holders, acquired atomic.Int32
+	var wg sync.WaitGroup
+	errs := make(chan error, contenders*attempts)
+	for i := 0; i < contenders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < attempts; j++ {
+				lock, err := AcquireServerLock()
+				if err != nil {
+					if !errors.Is(err, ErrServerLocked) {
+						errs <- err
+					}
+					continue
+				}
+				if n := holders.Add(1); n != 1 {
+					errs <- errors.New("lock held by " + strconv.Itoa(int(n)) + " contenders at once")
+				}
+				acquired.Add(1)
+				time.Sleep(50 * time.Microsecond) // Let others race for the lock while it is held
+				if lock.Reclaimed != 0 {
+					errs <- errors.New("lock reclaimed from process " + strconv.Itoa(lock.Reclaimed) + " while still held here")
+				}
+				holders.Add(-1)
+				if err := lock.Release(); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if acquired.Load() == 0 {
+		t.Fatal("no contender ever got the lock")
+	}
+	if _, err := os.Stat(ServerLockPath()); !os.IsNotExist(err) {
+		t.Errorf("lock file after every release: %v, want it removed", err)
+	}
+
+	// A held lock refuses others and names its holder; released, it can be taken again
+	lock, err := AcquireServerLock()
+	if err != nil {
+		t.Fatalf("acquiring the free lock: %v", err)
+	}
+	if _, err := AcquireServerLock(); !errors.Is(err, ErrServerLocked) || !strings.Contains(err.Error(), "process "+strconv.Itoa(os.Getpid())) {
+		t.Errorf("acquiring the held lock: %v, want %v naming this process", err, ErrServerLocked)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatalf("releasing: %v", err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Errorf("releasing twice: %v, want nothing done", err)
+	}
+	again, err := AcquireServerLock()
+	if err != nil {
+		t.Fatalf("acquiring the released lock: %v", err)
+	}
+	again.Release()
+}
@@ -0,0 +1,60 @@
+//go:build unix
+
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// acquireServerLock takes an flock on the lock file. The kernel drops it when the holder
+// exits, so a lock file without an flock was left by a process that is gone.
+func acquireServerLock(path string) (*ServerLock, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			pid := readLockPID(f)
+			f.Close()
+			if errors.Is(err, syscall.EWOULDBLOCK) {
+				return nil, fmt.Errorf("%w: %s is held by process %d", ErrServerLocked, path, pid)
+			}
+			return nil, err
+		}
+		// A holder releasing just now removes the file before unlocking it; if that
+		// happened between our open and flock, the lock we hold is on a deleted file
+		if onDisk, err := os.Stat(path); err != nil || !sameFile(f, onDisk) {
+			f.Close()
+			continue
+		}
+
+		lock := &ServerLock{file: f, path: path}
+		if pid := readLockPID(f); pid != 0 && pid != os.Getpid() {
+			lock.Reclaimed = pid
+		}
+		if err := writeLockPID(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return lock, nil
+	}
+}
+
+// sameFile reports whether the open file f is the file described by info.
+func sameFile(f *os.File, info os.FileInfo) bool {
+	opened, err := f.Stat()
+	return err == nil && os.SameFile(opened, info)
+}
+
+// release removes the lock file while still holding the flock, then drops it.
+func (l *ServerLock) release() error {
+	err := os.Remove(l.path)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package persistence
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// sessionLogDir returns the directory holding one log file per game session.
+func sessionLogDir() string {
+	return filepath.Join(dataRoot, "session_logs")
+}
+
+// SessionLogPath returns the log file path for a game session.
+func SessionLogPath(gameID string) string {
+	return filepath.Join(sessionLogDir(), gameID+".log")
+}
+
+// OpenSessionLog opens (creating it if needed) the log file for a game session, appending
+// to any earlier file of the same name.
+func OpenSessionLog(gameID string) (*os.File, error) {
+	if err := os.MkdirAll(sessionLogDir(), 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(SessionLogPath(gameID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// SessionCapturePath returns the message capture file path for a game session.
+func SessionCapturePath(gameID string) string {
+	return filepath.Join(sessionLogDir(), gameID+".capture.jsonl")
+}
+
+// OpenSessionCapture opens a capture appending to the game session's capture file.
+func OpenSessionCapture(gameID string) (*network.Capture, error) {
+	if err := os.MkdirAll(sessionLogDir(), 0755); err != nil {
+		return nil, err
+	}
+	return network.OpenCapture(SessionCapturePath(gameID))
+}
+
+// CleanupSessionLogs removes session logs and captures last written before now minus retention and
+// returns how many were removed. A missing log directory is not an error. Retention is meant
+// to be far longer than a game, so the log of a running session is never old enough to go.
+func CleanupSessionLogs(retention time.Duration, now time.Time) (int, error) {
+	entries, err := os.ReadDir(sessionLogDir())
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := now.Add(-retention)
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !(strings.HasSuffix(entry.Name(), ".log") || strings.HasSuffix(entry.Name(), ".capture.jsonl")) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(sessionLogDir(), entry.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -4,37 +4,86 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"sync"
 
-	"enhanced-tcr-udp/internal/models"
+	"enhanced-tcr-udp/pkg/models"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 const (
-	playerDataDir = "data/players_enhanced/"
-	gameConfigDir = "config_enhanced/"
+	DefaultDataRoot = "data"
+	gameConfigDir   = "config_enhanced/"
 )
 
+// dataRoot is the directory under which all server-written data lives.
+// It defaults to DefaultDataRoot relative to the working directory and can be
+// redirected (e.g. to a temporary directory for end-to-end runs) via SetDataRoot.
+var dataRoot = DefaultDataRoot
+
+// SetDataRoot changes the root directory used for player data.
+// It should be called before the server starts handling connections.
+func SetDataRoot(root string) {
+	if root == "" {
+		root = DefaultDataRoot
+	}
+	dataRoot = root
+	playerIndex.reset() // Indexes the old root
+}
+
+// DataRoot returns the directory currently used as the data root.
+func DataRoot() string {
+	return dataRoot
+}
+
+// playerDataDir returns the directory holding one JSON file per player account.
+func playerDataDir() string {
+	return filepath.Join(dataRoot, "players_enhanced")
+}
+
+// playerFilePath returns the account file of a player.
+func playerFilePath(username string) string {
+	return filepath.Join(playerDataDir(), username+".json")
+}
+
+// storedPlayerAccount is the on-disk form of an account. PlayerAccount keeps its password
+// hash out of JSON so it can never reach the wire; the account file is the one place it belongs.
+type storedPlayerAccount struct {
+	*models.PlayerAccount
+	HashedPassword string `json:"hashed_password"`
+}
+
 // LoadPlayerAccount loads a player's account data from a JSON file.
 func LoadPlayerAccount(username string) (*models.PlayerAccount, error) {
-	filePath := filepath.Join(playerDataDir, username+".json")
-	data, err := os.ReadFile(filePath)
+	data, err := os.ReadFile(playerFilePath(username))
 	if err != nil {
 		return nil, err
 	}
 
-	var acc models.PlayerAccount
-	if err := json.Unmarshal(data, &acc); err != nil {
+	stored := storedPlayerAccount{PlayerAccount: &models.PlayerAccount{}}
+	if err := json.Unmarshal(data, &stored); err != nil {
 		return nil, err
 	}
-	return &acc, nil
+	stored.PlayerAccount.HashedPassword = stored.HashedPassword
+	return stored.PlayerAccount, nil
 }
 
-// SavePlayerAccount saves a player's account data to a JSON file.
+// SavePlayerAccount saves a player's account data to a JSON file and updates the player index.
 // It also handles hashing the password if it's not already hashed.
 func SavePlayerAccount(acc *models.PlayerAccount) error {
+	return writePlayerAccount(acc, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+}
+
+// CreatePlayerAccount saves a new account like SavePlayerAccount, but fails with an error
+// satisfying os.IsExist if the account file already exists, whatever the index says.
+func CreatePlayerAccount(acc *models.PlayerAccount) error {
+	return writePlayerAccount(acc, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+}
+
+// writePlayerAccount writes an account file opened with the given flags, then indexes it.
+func writePlayerAccount(acc *models.PlayerAccount, flags int) error {
 	// Ensure player data directory exists
-	if err := os.MkdirAll(playerDataDir, 0755); err != nil {
+	if err := os.MkdirAll(playerDataDir(), 0755); err != nil {
 		return err
 	}
 
@@ -48,13 +97,25 @@ func SavePlayerAccount(acc *models.PlayerAccount) error {
 		acc.HashedPassword = string(hashedBytes)
 	}
 
-	filePath := filepath.Join(playerDataDir, acc.Username+".json")
-	data, err := json.MarshalIndent(acc, "", "  ")
+	stored := storedPlayerAccount{PlayerAccount: acc, HashedPassword: acc.HashedPassword}
+	data, err := json.MarshalIndent(stored, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(filePath, data, 0644)
+	f, err := os.OpenFile(playerFilePath(acc.Username), flags, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	playerIndex.update(acc)
+	return nil
 }
 
 // LoadTroopConfig loads troop specifications from troops.json.
@@ -100,9 +161,57 @@ func calculateExpForNextLevel(currentLevel int) int {
 	return int(expNeeded)
 }
 
-// UpdatePlayerAfterGame updates a player's EXP and handles leveling up.
-// It then saves the account.
-func UpdatePlayerAfterGame(acc *models.PlayerAccount, expGained int) (bool, error) {
+// accountLocks holds one *sync.Mutex per username, held while that player's account file is
+// read, changed and written back; see UpdatePlayerAccount.
+var accountLocks sync.Map
+
+// lockAccount takes the lock of a player's account and returns the function releasing it.
+func lockAccount(username string) func() {
+	mu, _ := accountLocks.LoadOrStore(username, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// UpdatePlayerAccount rereads a player's account as saved, applies update to it and saves
+// it, holding the player's lock throughout. A copy of the account taken earlier (at login,
+// or when a game started) may miss changes saved since; changing the saved account instead
+// keeps them. If update fails nothing is saved. It returns the account as saved.
+func UpdatePlayerAccount(username string, update func(acc *models.PlayerAccount) error) (*models.PlayerAccount, error) {
+	unlock := lockAccount(username)
+	defer unlock()
+	acc, err := LoadPlayerAccount(username)
+	if err != nil {
+		return nil, err
+	}
+	if err := update(acc); err != nil {
+		return nil, err
+	}
+	if err := SavePlayerAccount(acc); err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// UpdatePlayerAfterGame adds a game's EXP to a player's saved account and handles leveling
+// up, after record has applied the game's other changes (its outcome, say), then saves the
+// account, all through UpdatePlayerAccount. It returns the account as saved and whether the
+// player leveled up.
+func UpdatePlayerAfterGame(username string, expGained int, record func(acc *models.PlayerAccount)) (*models.PlayerAccount, bool, error) {
+	didLevelUp := false
+	acc, err := UpdatePlayerAccount(username, func(acc *models.PlayerAccount) error {
+		record(acc)
+		didLevelUp = AddEXP(acc, expGained)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return acc, didLevelUp, nil
+}
+
+// AddEXP adds EXP to an account and handles leveling up, without saving it. It reports
+// whether the player leveled up.
+func AddEXP(acc *models.PlayerAccount, expGained int) bool {
 	acc.EXP += expGained
 	didLevelUp := false
 
@@ -114,9 +223,5 @@ func UpdatePlayerAfterGame(acc *models.PlayerAccount, expGained int) (bool, erro
 		acc.EXP -= expForNext                            // Deduct only the EXP needed for that level up
 		expForNext = calculateExpForNextLevel(acc.Level) // Recalculate for potential multi-level up
 	}
-
-	if err := SavePlayerAccount(acc); err != nil {
-		return didLevelUp, err
-	}
-	return didLevelUp, nil
+	return didLevelUp
 }
@@ -0,0 +1,84 @@
+package persistence
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"enhanced-tcr-udp/pkg/models"
+)
+
+// useTempDataRoot points the data root at a directory of the test's own until it ends.
+func useTempDataRoot(t *testing.T) {
+	t.Helper()
+	SetDataRoot(t.TempDir())
+	t.Cleanup(func() { SetDataRoot("") })
+}
+
+// An account saved and loaded again comes back field for field, password hash included:
+// the account file keeps the hash under "hashed_password" although PlayerAccount leaves it
+// out of JSON.
+func TestPlayerAccountRoundTrip(t *testing.T) {
+	useTempDataRoot(t)
+	acc := &models.PlayerAccount{
+		Username:              "roundtrip",
+		HashedPassword:        "secret", // Hashed on save
+		EXP:                   40,
+		Level:                 3,
+		Wins:                  5,
+		Losses:                2,
+		Streak:                -1,
+		LastLogin:             time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		Settings:              map[string]string{models.SettingPreferredDeck: "rush"},
+		LastFirstWinBonusDate: "2026-03-01",
+		GamesPlayed:           7,
+		TotalEXPEarned:        310,
+		TroopDeploys:          map[string]int{"knight": 4},
+	}
+	if err := SavePlayerAccount(acc); err != nil {
+		t.Fatalf("SavePlayerAccount: %v", err)
+	}
+	if acc.HashedPassword == "secret" || !strings.HasPrefix(acc.HashedPassword, "$2") {
+		t.Fatalf("password saved as %q, want a bcrypt hash", acc.HashedPassword)
+	}
+
+	data, err := os.ReadFile(playerFilePath(acc.Username))
+	if err != nil {
+		t.Fatalf("reading the account file: %v", err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("decoding the account file: %v", err)
+	}
+	if fields["hashed_password"] != acc.HashedPassword {
+		t.Errorf("account file has hashed_password %v, want %q", fields["hashed_password"], acc.HashedPassword)
+	}
+
+	loaded, err := LoadPlayerAccount(acc.Username)
+	if err != nil {
+		t.Fatalf("LoadPlayerAccount: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, acc) {
+		t.Errorf("loaded %+v, want %+v", loaded, acc)
+	}
+
+	// Saving the loaded account again keeps the hash rather than hashing it a second time
+	if err := SavePlayerAccount(loaded); err != nil {
+		t.Fatalf("saving again: %v", err)
+	}
+	if again, err := LoadPlayerAccount(acc.Username); err != nil || again.HashedPassword != acc.HashedPassword {
+		t.Errorf("hash after saving again = %q (%v), want %q", again.HashedPassword, err, acc.HashedPassword)
+	}
+
+	// The hash is only ever in the account file: the account itself marshals without it
+	public, err := json.Marshal(loaded)
+	if err != nil {
+		t.Fatalf("marshalling the account: %v", err)
+	}
+	if strings.Contains(string(public), acc.HashedPassword) || strings.Contains(string(public), "password") {
+		t.Errorf("the account marshals with its password: %s", public)
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"enhanced-tcr-udp/internal/persistence"
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// inboundTCPMessage is a TCPMessage whose payload stays raw until its type is known.
+type inboundTCPMessage struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// accountRequestsEnd is how serveAccountRequests finished.
+type accountRequestsEnd int
+
+const (
+	requestsDisconnected accountRequestsEnd = iota // The connection failed
+	requestsMatchmaking                            // The client asked for matchmaking
+	requestsReconnected                            // The client rejoined its running game, which has since ended
+)
+
+// serveAccountRequests answers the requests a client speaking network.ProtocolVersionRequests
+// or later sends after login, until it asks for matchmaking or rejoins its game. It returns
+// how it finished and, for matchmaking, the queue and game duration the client asked for.
+func serveAccountRequests(conn net.Conn, decoder *json.Decoder, player *models.PlayerAccount, protocolVersion int) (accountRequestsEnd, string, time.Duration) {
+	for {
+		var msg inboundTCPMessage
+		if err := decoder.Decode(&msg); err != nil {
+			log.Printf("Error reading request from %s before matchmaking: %v", player.Username, err)
+			return requestsDisconnected, "", 0
+		}
+		switch msg.Type {
+		case network.MsgTypeMatchmakingRequest:
+			// A player still in a game is told which, and may rejoin it on this connection
+			if session, ok := GlobalSessionManager.FindPlayerSession(player.Username); ok {
+				log.Printf("Player %s asked for matchmaking while still in game %s. Offering to rejoin it.", player.Username, session.ID)
+				if err := notifyAlreadyInGame(conn, session.ID); err != nil {
+					log.Printf("Error answering matchmaking request from %s: %v", player.Username, err)
+					return requestsDisconnected, "", 0
+				}
+				continue
+			}
+			queueType, duration := matchmakingPreferences(player, msg.Payload)
+			return requestsMatchmaking, queueType, duration
+		case network.MsgTypeReconnectRequest:
+			if reconnectPlayer(conn, player, protocolVersion, msg.Payload) {
+				return requestsReconnected, "", 0
+			}
+		case network.MsgTypeUpdateSettings:
+			response := updateAccountSettings(player, msg.Payload)
+			reply := network.TCPMessage{Type: network.MsgTypeSettingsUpdated, Payload: response}
+			if err := writeTCPMessage(conn, reply); err != nil {
+				log.Printf("Error answering settings update from %s: %v", player.Username, err)
+				return requestsDisconnected, "", 0
+			}
+		case network.MsgTypeGetGameConfig:
+			reply := network.TCPMessage{Type: network.MsgTypeGameConfigResponse, Payload: gameConfigResponse(player, msg.Payload)}
+			if err := writeTCPMessage(conn, reply); err != nil {
+				log.Printf("Error answering game config request from %s: %v", player.Username, err)
+				return requestsDisconnected, "", 0
+			}
+		default:
+			log.Printf("Ignoring unexpected %q message from %s before matchmaking.", msg.Type, player.Username)
+		}
+	}
+}
+
+// matchmakingPreferences returns the queue and game duration a MsgTypeMatchmakingRequest
+// payload asks for. A payload without a queue type, or one that cannot be read, means
+// ranked; a duration that is not one of the rules' DurationChoices means no preference (0).
+func matchmakingPreferences(player *models.PlayerAccount, payload json.RawMessage) (string, time.Duration) {
+	var request network.MatchmakingRequest
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &request); err != nil {
+			log.Printf("Malformed matchmaking request from %s (%v). Queueing for a ranked game.", player.Username, err)
+		}
+	}
+	queueType, ok := network.NormalizeQueueType(request.QueueType)
+	if !ok {
+		log.Printf("Player %s asked for unknown queue %q. Queueing for a ranked game.", player.Username, request.QueueType)
+	}
+	duration := time.Duration(request.PreferredDurationSeconds) * time.Second
+	if duration != 0 && !GlobalSessionManager.Rules().AllowsDuration(duration) {
+		log.Printf("Player %s asked for a %v game, which is not offered. Queueing with no duration preference.", player.Username, duration)
+		duration = 0
+	}
+	return queueType, duration
+}
+
+// updateAccountSettings applies a MsgTypeUpdateSettings payload to the player's stored
+// account and saves it, then copies the settings saved to player. Nothing is changed unless
+// the update is valid and saved.
+func updateAccountSettings(player *models.PlayerAccount, payload json.RawMessage) network.UpdateSettingsResponse {
+	reject := func(format string, args ...interface{}) network.UpdateSettingsResponse {
+		message := fmt.Sprintf(format, args...)
+		log.Printf("Rejected settings update from %s: %s", player.Username, message)
+		return network.UpdateSettingsResponse{Success: false, Message: message, Settings: player.Settings}
+	}
+	if len(payload) > network.MaxSettingsPayloadSize {
+		return reject("settings update is %d bytes; the limit is %d", len(payload), network.MaxSettingsPayloadSize)
+	}
+	var request network.UpdateSettingsRequest
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		return reject("malformed settings update: %v", err)
+	}
+
+	// Applied to the account as stored: player is the copy taken at login, and a game
+	// ended since may have saved EXP and a record it lacks
+	var invalid error
+	saved, err := persistence.UpdatePlayerAccount(player.Username, func(acc *models.PlayerAccount) error {
+		invalid = acc.ApplySettings(request.Settings)
+		return invalid
+	})
+	if invalid != nil {
+		return reject("%v", invalid)
+	}
+	if err != nil {
+		log.Printf("Error saving settings for %s: %v", player.Username, err)
+		return reject("could not save settings")
+	}
+	player.Settings = saved.Settings
+	log.Printf("Player %s updated settings: %v", player.Username, player.Settings)
+	return network.UpdateSettingsResponse{Success: true, Settings: player.Settings}
+}
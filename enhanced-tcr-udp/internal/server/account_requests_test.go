@@ -0,0 +1,59 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"enhanced-tcr-udp/internal/persistence"
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// A settings update is applied to the account as stored, not to the copy the connection
+// took at login: what a game saved since (EXP, level, record) survives it, and an invalid
+// update saves nothing.
+func TestSettingsUpdateKeepsStoredAccount(t *testing.T) {
+	const name = "settings-alice"
+	loggedIn := &models.PlayerAccount{Username: name, HashedPassword: "secret", Level: 1}
+	if err := persistence.SavePlayerAccount(loggedIn); err != nil {
+		t.Fatalf("saving %s: %v", name, err)
+	}
+	player := *loggedIn // The connection's copy
+	// A game ending after the login saves onto the stored account
+	if _, err := persistence.UpdatePlayerAccount(name, func(acc *models.PlayerAccount) error {
+		acc.EXP, acc.Level, acc.Wins = 30, 2, 1
+		return nil
+	}); err != nil {
+		t.Fatalf("updating %s: %v", name, err)
+	}
+
+	update := func(settings map[string]string) network.UpdateSettingsResponse {
+		t.Helper()
+		payload, err := json.Marshal(network.UpdateSettingsRequest{Settings: settings})
+		if err != nil {
+			t.Fatalf("encoding the update: %v", err)
+		}
+		return updateAccountSettings(&player, payload)
+	}
+
+	if resp := update(map[string]string{models.SettingPreferredDeck: "rush"}); !resp.Success {
+		t.Fatalf("update rejected: %s", resp.Message)
+	}
+	saved, err := persistence.LoadPlayerAccount(name)
+	if err != nil {
+		t.Fatalf("loading %s: %v", name, err)
+	}
+	if saved.EXP != 30 || saved.Level != 2 || saved.Wins != 1 {
+		t.Errorf("saved %d EXP at level %d with %d wins, want the game's 30 at level 2 with 1", saved.EXP, saved.Level, saved.Wins)
+	}
+	if saved.Settings[models.SettingPreferredDeck] != "rush" || player.Settings[models.SettingPreferredDeck] != "rush" {
+		t.Errorf("settings: saved %v, connection's copy %v; want the preferred deck set on both", saved.Settings, player.Settings)
+	}
+
+	if resp := update(map[string]string{"no_such_setting": "x"}); resp.Success {
+		t.Fatal("unknown setting accepted")
+	}
+	if again, err := persistence.LoadPlayerAccount(name); err != nil || len(again.Settings) != 1 {
+		t.Errorf("rejected update changed the stored settings to %v (%v)", again.Settings, err)
+	}
+}
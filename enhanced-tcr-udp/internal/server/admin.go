@@ -0,0 +1,354 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"enhanced-tcr-udp/internal/persistence"
+	"enhanced-tcr-udp/pkg/models"
+)
+
+// defaultLeaderboardSize is how many players the leaderboard command shows without a count.
+const defaultLeaderboardSize = 10
+
+// AdminConsole executes line-based operator commands against the running server.
+// It is normally fed from the server process's stdin.
+type AdminConsole struct {
+	sessions *GameSessionManager
+}
+
+// NewAdminConsole creates an admin console operating on the given session manager.
+func NewAdminConsole(sessions *GameSessionManager) *AdminConsole {
+	return &AdminConsole{sessions: sessions}
+}
+
+// Run reads commands from r line by line and writes each command's output to w
+// until r is exhausted.
+func (a *AdminConsole) Run(r io.Reader, w io.Writer) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fmt.Fprintln(w, a.Execute(line))
+	}
+}
+
+// Execute runs a single command line and returns the text to show the operator.
+func (a *AdminConsole) Execute(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	switch fields[0] {
+	case "help":
+		return "Commands: help, list-sessions, end-session <gameID> <draw|p1|p2|timeout-evaluate>, fast-forward <gameID> <seconds>, list-players, leaderboard [count], rebuild-index, analytics-summary, handicap [<username> <clear|starting-mana=N regen=X stats=X ...>], metrics"
+	case "list-sessions":
+		return a.listSessions()
+	case "end-session":
+		return a.endSession(fields[1:])
+	case "fast-forward":
+		return a.fastForward(fields[1:])
+	case "list-players":
+		return a.listPlayers()
+	case "leaderboard":
+		return a.leaderboard(fields[1:])
+	case "rebuild-index":
+		return a.rebuildIndex()
+	case "analytics-summary":
+		return a.analyticsSummary()
+	case "handicap":
+		return a.handicap(fields[1:])
+	case "metrics":
+		return a.metrics()
+	default:
+		return fmt.Sprintf("Unknown command %q. Type 'help' for a list of commands.", fields[0])
+	}
+}
+
+// metrics formats the server's counters, one "name value" line each, in the text format
+// metrics scrapers read.
+func (a *AdminConsole) metrics() string {
+	matchmaking := CurrentMatchmakingMetrics()
+	lines := []string{
+		fmt.Sprintf("tcr_sessions_active %d", len(a.sessions.ListSessions())),
+		fmt.Sprintf("tcr_matchmaking_dead_entries_evicted_total %d", matchmaking.DeadEntriesEvicted),
+	}
+	queues := make([]string, 0, len(matchmaking.Waiting))
+	for queueType := range matchmaking.Waiting {
+		queues = append(queues, queueType)
+	}
+	sort.Strings(queues)
+	for _, queueType := range queues {
+		lines = append(lines, fmt.Sprintf("tcr_matchmaking_waiting{queue=%q} %d", queueType, matchmaking.Waiting[queueType]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// listSessions formats one line per registered session.
+func (a *AdminConsole) listSessions() string {
+	snapshots := a.sessions.ListSessions()
+	if len(snapshots) == 0 {
+		return "No active sessions."
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].StartTime.Before(snapshots[j].StartTime)
+	})
+
+	var b strings.Builder
+	for _, snap := range snapshots {
+		state := fmt.Sprintf("%s %ds left", snap.State, int(snap.TimeRemaining/time.Second))
+		if snap.State.Finished() {
+			state = fmt.Sprintf("%s (%s)", snap.State, snap.EndReason)
+		}
+		for _, player := range []PlayerSnapshot{snap.Player1, snap.Player2} {
+			if player.Flagged {
+				state += fmt.Sprintf(" FLAGGED=%s(%d implausible)", player.Username, player.ImplausibleCommands)
+			}
+		}
+		if snap.PausedFor != "" {
+			state += " paused-for=" + snap.PausedFor
+		}
+		if snap.Spectators > 0 {
+			state += fmt.Sprintf(" watching=%d", snap.Spectators)
+		}
+		if snap.LogPath != "" {
+			state += " log=" + snap.LogPath
+		}
+		fmt.Fprintf(&b, "%s udp=%d %s(mana %d) vs %s(mana %d) troops=%d(peak %d) tick-p95=%v out=%d/%d dropped=%d rejected=%d malformed=%d busy=%d %s\n",
+			snap.SessionID, snap.UDPPort,
+			snap.Player1.Username, snap.Player1.CurrentMana,
+			snap.Player2.Username, snap.Player2.CurrentMana,
+			len(snap.ActiveTroops), snap.Perf.PeakTroops, snap.Perf.TickP95, snap.OutboundUDP.Sent, snap.OutboundUDP.Queued, snap.OutboundUDP.Dropped, snap.DroppedUDP.BadSession, snap.DroppedUDP.Total()-snap.DroppedUDP.BadSession, snap.BusyUDP, state)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// endSession force-ends a session with an operator-chosen outcome.
+func (a *AdminConsole) endSession(args []string) string {
+	if len(args) != 2 {
+		return "Usage: end-session <gameID> <draw|p1|p2|timeout-evaluate>"
+	}
+	session, ok := a.sessions.GetSession(args[0])
+	if !ok {
+		return fmt.Sprintf("No session %q.", args[0])
+	}
+	outcome, err := ParseForceOutcome(args[1])
+	if err != nil {
+		return err.Error()
+	}
+	if err := session.ForceEnd("ended by operator", outcome); err != nil {
+		return fmt.Sprintf("Could not end session %s: %v", args[0], err)
+	}
+	return fmt.Sprintf("Session %s ended: %s", args[0], session.Snapshot().Result)
+}
+
+// fastForward moves a session's end time closer, mainly for testing the timeout path.
+func (a *AdminConsole) fastForward(args []string) string {
+	if len(args) != 2 {
+		return "Usage: fast-forward <gameID> <seconds>"
+	}
+	session, ok := a.sessions.GetSession(args[0])
+	if !ok {
+		return fmt.Sprintf("No session %q.", args[0])
+	}
+	seconds, err := strconv.Atoi(args[1])
+	if err != nil || seconds <= 0 {
+		return fmt.Sprintf("Invalid number of seconds %q.", args[1])
+	}
+	remaining, err := session.FastForward(time.Duration(seconds) * time.Second)
+	if err != nil {
+		return fmt.Sprintf("Could not fast-forward session %s: %v", args[0], err)
+	}
+	return fmt.Sprintf("Session %s fast-forwarded by %ds; %ds left.", args[0], seconds, int(remaining/time.Second))
+}
+
+// handicapUsage is the handicap command's usage line.
+const handicapUsage = "Usage: handicap [<username> <clear|starting-mana=N regen=X stats=X ...>]"
+
+// handicap lists the players' handicaps, or sets or clears one player's. A handicap applies
+// to the player's casual games from the next one on, until it is cleared.
+func (a *AdminConsole) handicap(args []string) string {
+	if len(args) == 0 {
+		handicaps := a.sessions.Handicaps()
+		if len(handicaps) == 0 {
+			return "No handicaps set."
+		}
+		var b strings.Builder
+		for _, username := range sortedKeys(handicaps) {
+			fmt.Fprintf(&b, "%s %s\n", username, handicaps[username])
+		}
+		return strings.TrimRight(b.String(), "\n")
+	}
+	if len(args) < 2 {
+		return handicapUsage
+	}
+	username := args[0]
+	if len(args) == 2 && args[1] == "clear" {
+		if err := a.sessions.SetHandicap(username, models.PlayerOverrides{}); err != nil {
+			return fmt.Sprintf("Could not clear the handicap of %s: %v", username, err)
+		}
+		return fmt.Sprintf("Handicap of %s cleared.", username)
+	}
+
+	var overrides models.PlayerOverrides
+	for _, arg := range args[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return handicapUsage
+		}
+		switch key {
+		case "starting-mana":
+			mana, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Sprintf("Invalid starting mana %q.", value)
+			}
+			overrides.StartingMana = &mana
+		case "regen", "stats":
+			multiplier, err := strconv.ParseFloat(value, 64)
+			if err != nil || multiplier <= 0 {
+				return fmt.Sprintf("Invalid %s multiplier %q.", key, value)
+			}
+			if key == "regen" {
+				overrides.ManaRegenMultiplier = multiplier
+			} else {
+				overrides.StatMultiplier = multiplier
+			}
+		default:
+			return fmt.Sprintf("Unknown handicap %q. %s", key, handicapUsage)
+		}
+	}
+	if err := a.sessions.SetHandicap(username, overrides); err != nil {
+		return fmt.Sprintf("Invalid handicap for %s: %v", username, err)
+	}
+	return fmt.Sprintf("Handicap of %s set to %s for their casual games; ranked games ignore it.", username, overrides)
+}
+
+// listPlayers formats one line per account in the player index.
+func (a *AdminConsole) listPlayers() string {
+	players, err := persistence.ListPlayers()
+	if err != nil {
+		return fmt.Sprintf("Could not list players: %v", err)
+	}
+	if len(players) == 0 {
+		return "No player accounts."
+	}
+	var b strings.Builder
+	for _, player := range players {
+		fmt.Fprintf(&b, "%s level=%d exp=%d %dW/%dL last-login=%s\n",
+			player.Username, player.Level, player.EXP, player.Wins, player.Losses, formatLastLogin(player.LastLogin))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// leaderboard formats the top players, ranked by level, EXP and wins.
+func (a *AdminConsole) leaderboard(args []string) string {
+	if len(args) > 1 {
+		return "Usage: leaderboard [count]"
+	}
+	count := defaultLeaderboardSize
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return fmt.Sprintf("Invalid count %q.", args[0])
+		}
+		count = n
+	}
+	players, err := persistence.GetLeaderboard(count)
+	if err != nil {
+		return fmt.Sprintf("Could not build the leaderboard: %v", err)
+	}
+	if len(players) == 0 {
+		return "No player accounts."
+	}
+	var b strings.Builder
+	for i, player := range players {
+		fmt.Fprintf(&b, "%2d. %s level=%d exp=%d %dW/%dL\n", i+1, player.Username, player.Level, player.EXP, player.Wins, player.Losses)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// rebuildIndex rescans the account files, for when the player index has drifted from them.
+func (a *AdminConsole) rebuildIndex() string {
+	indexed, skipped, err := persistence.RebuildPlayerIndex()
+	if err != nil {
+		return fmt.Sprintf("Could not rebuild the player index: %v", err)
+	}
+	if skipped > 0 {
+		return fmt.Sprintf("Player index rebuilt: %d accounts, %d unreadable files skipped.", indexed, skipped)
+	}
+	return fmt.Sprintf("Player index rebuilt: %d accounts.", indexed)
+}
+
+// analyticsSummary aggregates the anonymized analytics records currently on disk.
+func (a *AdminConsole) analyticsSummary() string {
+	records, skipped, err := persistence.ReadAnalyticsRecords()
+	if err != nil {
+		return fmt.Sprintf("Could not read the analytics records: %v", err)
+	}
+	if len(records) == 0 {
+		return "No analytics records. Start the server with -analytics to collect them."
+	}
+	summary := persistence.SummarizeAnalytics(records)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Games: %d (%d draws), average length %.0fs", summary.Games, summary.Draws, summary.AverageDurationSeconds)
+	if skipped > 0 {
+		fmt.Fprintf(&b, ", %d unreadable lines skipped", skipped)
+	}
+	b.WriteString("\nEnd reasons:")
+	for _, reason := range sortedKeys(summary.EndReasons) {
+		fmt.Fprintf(&b, " %s=%d", reason, summary.EndReasons[reason])
+	}
+	gaps := make([]int, 0, len(summary.LevelGaps))
+	for gap := range summary.LevelGaps {
+		gaps = append(gaps, gap)
+	}
+	sort.Ints(gaps)
+	for _, gap := range gaps {
+		stats := summary.LevelGaps[gap]
+		fmt.Fprintf(&b, "\nLevel gap %d: higher level won %d/%d (%.0f%%)", gap, stats.HigherLevelWins, stats.Games, percent(stats.HigherLevelWins, stats.Games))
+	}
+	troops := sortedKeys(summary.Troops)
+	sort.SliceStable(troops, func(i, j int) bool {
+		return summary.Troops[troops[i]].Picks > summary.Troops[troops[j]].Picks
+	})
+	for _, troop := range troops {
+		stats := summary.Troops[troop]
+		fmt.Fprintf(&b, "\nTroop %s: picked %.0f%%, won %d/%d (%.0f%%)", troop, 100*stats.PickRate(summary.Games), stats.Wins, stats.Picks, percent(stats.Wins, stats.Picks))
+	}
+	return b.String()
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// percent returns n out of total as a percentage, 0 when total is 0.
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+// formatLastLogin shows a login time to the minute, or "never" if none was recorded.
+func formatLastLogin(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"log"
+	"sync"
+
+	"enhanced-tcr-udp/internal/persistence"
+)
+
+// analyticsQueueSize is how many finished games may wait for the analytics writer before
+// further records are dropped.
+const analyticsQueueSize = 64
+
+// pendingAnalytics is a finished game waiting for the analytics writer, with the usernames
+// it still has to anonymize, in the order of record.Players.
+type pendingAnalytics struct {
+	record    persistence.AnalyticsRecord
+	usernames [2]string
+}
+
+var (
+	analyticsQueue = make(chan pendingAnalytics, analyticsQueueSize)
+	analyticsOnce  sync.Once
+)
+
+// exportMatchAnalytics hands a finished game to the background analytics writer, starting
+// it on first use, so hashing and disk writes never hold up the end of a game.
+func exportMatchAnalytics(gameID string, record persistence.AnalyticsRecord, usernames [2]string) {
+	analyticsOnce.Do(func() { go runAnalyticsWriter() })
+	select {
+	case analyticsQueue <- pendingAnalytics{record: record, usernames: usernames}:
+	default:
+		log.Printf("[Analytics] Writer is %d games behind; dropped the record of game %s.", analyticsQueueSize, gameID)
+	}
+}
+
+// runAnalyticsWriter anonymizes and appends queued records, one at a time.
+func runAnalyticsWriter() {
+	for pending := range analyticsQueue {
+		if err := writeAnalytics(pending); err != nil {
+			log.Printf("[Analytics] Error writing match record: %v", err)
+		}
+	}
+}
+
+// writeAnalytics replaces the usernames of a queued record with their anonymized IDs and
+// appends it.
+func writeAnalytics(pending pendingAnalytics) error {
+	record := pending.record
+	for i, username := range pending.usernames {
+		id, err := persistence.AnonymizeUsername(username)
+		if err != nil {
+			return err
+		}
+		record.Players[i].ID = id
+	}
+	return persistence.AppendAnalyticsRecord(record)
+}
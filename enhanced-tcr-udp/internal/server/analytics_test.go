@@ -0,0 +1,57 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"enhanced-tcr-udp/internal/persistence"
+)
+
+// The analytics writer identifies players only by their salted IDs: a player has the same
+// ID in every game, and no analytics file holds their username.
+func TestAnalyticsRecordsHoldNoUsernames(t *testing.T) {
+	usernames := [2]string{"analytics-alice", "analytics-bob"}
+	for game := 0; game < 2; game++ {
+		record := persistence.AnalyticsRecord{EndedOn: "2026-10-17", EndReason: "timeout"}
+		record.Players[0] = persistence.AnalyticsPlayer{Level: 2, Outcome: "win", Deck: map[string]int{"pawn": 3}}
+		record.Players[1] = persistence.AnalyticsPlayer{Level: 1, Outcome: "loss", Deck: map[string]int{"knight": 1}}
+		if game == 1 { // The players swap sides in the second game
+			usernames[0], usernames[1] = usernames[1], usernames[0]
+		}
+		if err := writeAnalytics(pendingAnalytics{record: record, usernames: usernames}); err != nil {
+			t.Fatalf("writing game %d: %v", game, err)
+		}
+	}
+
+	records, skipped, err := persistence.ReadAnalyticsRecords()
+	if err != nil || skipped != 0 || len(records) < 2 {
+		t.Fatalf("read %d records, skipped %d (%v); want the 2 written", len(records), skipped, err)
+	}
+	first, second := records[len(records)-2], records[len(records)-1]
+	if first.Players[0].ID != second.Players[1].ID || first.Players[1].ID != second.Players[0].ID {
+		t.Errorf("IDs %s and %s in the first game, %s and %s in the second; want each player's the same in both",
+			first.Players[0].ID, first.Players[1].ID, second.Players[1].ID, second.Players[0].ID)
+	}
+	if first.Players[0].ID == first.Players[1].ID {
+		t.Errorf("both players have the ID %s", first.Players[0].ID)
+	}
+
+	dir := filepath.Join(persistence.DataRoot(), "analytics")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("listing %s: %v", dir, err)
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatalf("reading %s: %v", entry.Name(), err)
+		}
+		for _, username := range usernames {
+			if strings.Contains(string(data), username) {
+				t.Errorf("%s holds the username %s", entry.Name(), username)
+			}
+		}
+	}
+}
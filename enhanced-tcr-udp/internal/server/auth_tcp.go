@@ -5,9 +5,10 @@ import (
 	"log"
 	"os"
 	"sync"
+	"time"
 
-	"enhanced-tcr-udp/internal/models"
 	"enhanced-tcr-udp/internal/persistence"
+	"enhanced-tcr-udp/pkg/models"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -16,6 +17,9 @@ import (
 type AuthManager struct {
 	activeUsers map[string]string // Maps username to clientID (e.g., remote address)
 	mu          sync.RWMutex
+	// inGame reports whether a player is in a running game. Such a player may log in from
+	// another client, to rejoin the game after losing it; nil allows no such login.
+	inGame func(username string) bool
 }
 
 // NewAuthManager creates a new authentication manager.
@@ -32,29 +36,37 @@ func (am *AuthManager) Login(username, password, clientID string) (*models.Playe
 		return nil, errors.New("username and password cannot be empty")
 	}
 
-	acc, err := persistence.LoadPlayerAccount(username)
+	exists, err := persistence.PlayerExists(username)
 	if err != nil {
-		if os.IsNotExist(err) {
-			// Account does not exist, create a new one
-			log.Printf("No account found for user '%s'. Creating a new account.", username)
-			newAcc := &models.PlayerAccount{
-				Username:       username,
-				HashedPassword: password, // SavePlayerAccount will hash this
-				EXP:            0,
-				Level:          1,
-			}
-			if saveErr := persistence.SavePlayerAccount(newAcc); saveErr != nil {
-				log.Printf("Error saving new player account for %s: %v", username, saveErr)
-				return nil, errors.New("error creating user account")
-			}
+		log.Printf("Error looking up player account for %s: %v", username, err)
+		return nil, errors.New("error accessing player account")
+	}
+	var acc *models.PlayerAccount
+	if !exists {
+		// Account does not exist, create a new one
+		log.Printf("No account found for user '%s'. Creating a new account.", username)
+		newAcc := &models.PlayerAccount{
+			Username:       username,
+			HashedPassword: password, // CreatePlayerAccount will hash this
+			EXP:            0,
+			Level:          1,
+		}
+		if err := persistence.CreatePlayerAccount(newAcc); err == nil {
 			log.Printf("New account created successfully for user: %s", username)
 			acc = newAcc // Use the newly created account for subsequent login logic
+		} else if os.IsExist(err) {
+			// The index missed an account file; log in to that account as usual
+			log.Printf("Account file for '%s' exists but was not indexed. Loading it.", username)
 		} else {
-			// Other error loading account
+			log.Printf("Error saving new player account for %s: %v", username, err)
+			return nil, errors.New("error creating user account")
+		}
+	}
+	if acc == nil {
+		if acc, err = persistence.LoadPlayerAccount(username); err != nil {
 			log.Printf("Error loading player account for %s: %v", username, err)
 			return nil, errors.New("error accessing player account")
 		}
-	} else {
 		// Account exists, verify password
 		if err := bcrypt.CompareHashAndPassword([]byte(acc.HashedPassword), []byte(password)); err != nil {
 			log.Printf("Invalid password for user: %s", username)
@@ -66,18 +78,31 @@ func (am *AuthManager) Login(username, password, clientID string) (*models.Playe
 	am.mu.Lock()
 	defer am.mu.Unlock()
 
-	if existingClientID, isLoggedIn := am.activeUsers[username]; isLoggedIn {
-		if existingClientID != clientID {
-			log.Printf("User %s already logged in from another client (%s)", username, existingClientID)
-			return nil, errors.New("user already logged in from another client")
-		}
+	existingClientID, isLoggedIn := am.activeUsers[username]
+	switch {
+	case !isLoggedIn:
+		am.activeUsers[username] = clientID
+		log.Printf("User %s logged in successfully with client ID %s", username, clientID)
+	case existingClientID == clientID:
 		// Already logged in from the same client, proceed
 		log.Printf("User %s re-confirmed login from client %s", username, clientID)
-	} else {
+	case am.inGame != nil && am.inGame(username):
+		// The player lost their game's client; this one may rejoin it
 		am.activeUsers[username] = clientID
-		log.Printf("User %s logged in successfully with client ID %s", username, clientID)
+		log.Printf("User %s, in a running game on client %s, logged in again from client %s", username, existingClientID, clientID)
+	default:
+		log.Printf("User %s already logged in from another client (%s)", username, existingClientID)
+		return nil, errors.New("user already logged in from another client")
 	}
 
+	// Saved on the account as stored: a game the player is rejoining may have saved since
+	acc.LastLogin = time.Now()
+	if _, err := persistence.UpdatePlayerAccount(username, func(saved *models.PlayerAccount) error {
+		saved.LastLogin = acc.LastLogin
+		return nil
+	}); err != nil {
+		log.Printf("Error recording login time for %s: %v", username, err)
+	}
 	return acc, nil
 }
 
@@ -0,0 +1,58 @@
+package server
+
+import "time"
+
+// commandWatermark is the watermark a player's state updates echo in
+// LastProcessedClientSeq: the highest Seq such that every command up to it has been
+// handled. The client stops resending every deploy at or below it, so it must never pass
+// a command still in flight: if deploy 5 is lost and deploy 6 arrives, the watermark
+// stays at 4 until a resent copy of 5 is handled.
+//
+// A command that is never resent (a full state request, say) can be lost for good, which
+// would hold the watermark back for the rest of the game. So a gap is closed once a later
+// command has been waiting behind it for deployDedupeRetention: the client has stopped
+// sending copies of a command by then.
+type commandWatermark struct {
+	settled uint32               // Every Seq up to here was handled or can no longer arrive
+	ahead   map[uint32]time.Time // Seqs handled above settled -> when
+}
+
+// newCommandWatermark creates a watermark for a player who has sent nothing yet.
+func newCommandWatermark() *commandWatermark {
+	return &commandWatermark{ahead: make(map[uint32]time.Time)}
+}
+
+// handled records that the command seq was handled at now.
+func (w *commandWatermark) handled(seq uint32, now time.Time) {
+	if seq <= w.settled {
+		return
+	}
+	if _, ok := w.ahead[seq]; !ok {
+		w.ahead[seq] = now
+	}
+	w.advance(now)
+}
+
+// advance raises the watermark over the commands handled since, and over gaps that have
+// been open too long for the missing command to arrive.
+func (w *commandWatermark) advance(now time.Time) {
+	for len(w.ahead) > 0 {
+		if _, ok := w.ahead[w.settled+1]; ok {
+			delete(w.ahead, w.settled+1)
+			w.settled++
+			continue
+		}
+		// A command missing here was sent before any handled above it, so the oldest of
+		// those says how long it has been missing at least
+		oldest := now
+		for _, at := range w.ahead {
+			if at.Before(oldest) {
+				oldest = at
+			}
+		}
+		if now.Sub(oldest) <= deployDedupeRetention {
+			return
+		}
+		w.settled++
+	}
+}
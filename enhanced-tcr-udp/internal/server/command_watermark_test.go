@@ -0,0 +1,35 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCommandWatermark(t *testing.T) {
+	start := time.Now()
+	w := newCommandWatermark()
+	w.handled(1, start)
+	w.handled(3, start) // 2 is in flight
+	w.handled(4, start)
+	if w.settled != 1 {
+		t.Fatalf("watermark %d with 2 missing, want 1", w.settled)
+	}
+	w.handled(2, start.Add(time.Second))
+	if w.settled != 4 {
+		t.Fatalf("watermark %d once 2 arrived, want 4", w.settled)
+	}
+
+	w.handled(6, start.Add(2*time.Second)) // 5 is lost for good
+	w.advance(start.Add(2*time.Second + deployDedupeRetention))
+	if w.settled != 4 {
+		t.Errorf("watermark %d while 5 could still be resent, want 4", w.settled)
+	}
+	w.advance(start.Add(3*time.Second + deployDedupeRetention))
+	if w.settled != 6 {
+		t.Errorf("watermark %d once no copy of 5 can arrive, want 6", w.settled)
+	}
+	w.handled(5, start.Add(4*time.Second+deployDedupeRetention))
+	if w.settled != 6 || len(w.ahead) != 0 {
+		t.Errorf("a late 5 moved the watermark to %d with %v ahead, want 6 and nothing", w.settled, w.ahead)
+	}
+}
@@ -0,0 +1,36 @@
+//go:build unix
+
+package server
+
+import (
+	"net"
+	"syscall"
+)
+
+// connAlive reports whether the peer of a TCP connection may still be there. It peeks at
+// the socket without blocking or consuming anything: only an orderly close by the peer or
+// a socket error mean it is gone, while pending data or none at all count as alive (so a
+// peer that closed after sending something not yet read still does; notifyMatch catches it).
+// Connections without a socket, such as in-memory pipes, always count as alive.
+func connAlive(conn net.Conn) bool {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return true
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false // Already closed on our side
+	}
+	alive := true
+	buf := make([]byte, 1)
+	err = raw.Read(func(fd uintptr) bool {
+		n, _, err := syscall.Recvfrom(int(fd), buf, syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		switch {
+		case err == syscall.EAGAIN || err == syscall.EWOULDBLOCK || err == syscall.EINTR:
+		case err != nil || n == 0:
+			alive = false
+		}
+		return true // Never wait for the socket to become readable
+	})
+	return err == nil && alive
+}
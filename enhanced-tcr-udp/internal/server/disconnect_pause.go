@@ -0,0 +1,134 @@
+package server
+
+import (
+	"math"
+	"time"
+
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+)
+
+const (
+	// playerSilenceThreshold is how long a player whose client sends heartbeats may go
+	// without sending anything before the game pauses for them.
+	playerSilenceThreshold = 3 * network.HeartbeatInterval
+	// disconnectGrace is how long, over the whole game, the game waits paused for each
+	// player. Once a player has used it up the game goes on without them.
+	disconnectGrace = 30 * time.Second
+)
+
+// checkDisconnects pauses the game while a player whose client sends heartbeats has gone
+// silent, and resumes it once they are heard from again or their grace runs out. While the
+// game is paused its timers are pushed back every tick, so no game time passes and no mana
+// or attacks fall due. The opponent is told when the pause starts, each second it counts
+// down, and when it ends. It reports whether the game is paused. Must be called with gs.mu
+// held, once combat has started.
+func (gs *GameSession) checkDisconnects(now time.Time) bool {
+	if gs.pausedFor == nil {
+		gs.pausedFor = gs.silentPlayer(now)
+		if gs.pausedFor == nil {
+			return false
+		}
+		gs.pausedAt, gs.pausedThrough, gs.graceShown = now, now, 0
+		gs.pausedFrom = gs.State()
+		gs.transition(StatePaused)
+		username := gs.pausedFor.Account.Username
+		gs.logf("[GameSession %s] Nothing heard from %s for %v; pausing for up to %v.", gs.ID, username, now.Sub(gs.lastHeard[username]).Round(time.Millisecond), gs.graceLeft(username, now))
+	}
+
+	player, username := gs.pausedFor, gs.pausedFor.Account.Username
+	if gs.lastHeard[username].After(gs.pausedAt) {
+		gs.resume(now)
+		gs.logf("[GameSession %s] %s is back; resuming.", gs.ID, username)
+		gs.sendGameEventToOpponentOf(player, network.GameEventOpponentReconnected, network.OpponentConnectionEvent{Opponent: username})
+		return false
+	}
+	left := gs.graceLeft(username, now)
+	if left <= 0 {
+		gs.resume(now)
+		gs.logf("[GameSession %s] %s did not come back within %v; resuming without them.", gs.ID, username, disconnectGrace)
+		gs.sendGameEventToOpponentOf(player, network.GameEventOpponentDisconnected, network.OpponentConnectionEvent{Opponent: username})
+		return false
+	}
+
+	gs.pushBackTimers(now.Sub(gs.pausedThrough))
+	gs.pausedThrough = now
+	if shown := int(math.Ceil(left.Seconds())); shown != gs.graceShown {
+		gs.graceShown = shown
+		gs.sendGameEventToOpponentOf(player, network.GameEventOpponentDisconnected, network.OpponentConnectionEvent{Opponent: username, GraceSeconds: shown})
+	}
+	return true
+}
+
+// silentPlayer returns a player the game should pause for at now, or nil. Players whose
+// clients predate heartbeats are never paused for, as they send nothing while idle.
+func (gs *GameSession) silentPlayer(now time.Time) *models.PlayerInGame {
+	for _, player := range []*models.PlayerInGame{gs.Player1, gs.Player2} {
+		username := player.Account.Username
+		if gs.playerProtocols[player.SessionToken] < network.ProtocolVersionHeartbeat {
+			continue
+		}
+		last := gs.lastHeard[username]
+		if last.IsZero() || now.Sub(last) <= playerSilenceThreshold || gs.graceLeft(username, now) <= 0 {
+			continue
+		}
+		return player
+	}
+	return nil
+}
+
+// graceLeft returns how much longer the game may stay paused for a player at now.
+func (gs *GameSession) graceLeft(username string, now time.Time) time.Duration {
+	used := gs.graceUsed[username]
+	if gs.pausedFor != nil && gs.pausedFor.Account.Username == username {
+		used += now.Sub(gs.pausedAt)
+	}
+	return disconnectGrace - used
+}
+
+// resume ends the current pause, charging its length to the player it was for.
+func (gs *GameSession) resume(now time.Time) {
+	gs.pushBackTimers(now.Sub(gs.pausedThrough))
+	gs.graceUsed[gs.pausedFor.Account.Username] += now.Sub(gs.pausedAt)
+	gs.pausedFor = nil
+	gs.transition(gs.pausedFrom)
+}
+
+// pushBackTimers moves every game timer d later, so that the time paused does not count.
+// Troops still spawning at the last push spawn d later too, and every troop with a lifetime
+// expires d later.
+func (gs *GameSession) pushBackTimers(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	gs.gameEndTime = gs.gameEndTime.Add(d)
+	for username, last := range gs.lastManaRegen {
+		gs.lastManaRegen[username] = last.Add(d)
+	}
+	for id, last := range gs.lastTroopAttack {
+		gs.lastTroopAttack[id] = last.Add(d)
+	}
+	for id, last := range gs.lastTowerAttack {
+		gs.lastTowerAttack[id] = last.Add(d)
+	}
+	for _, troop := range gs.activeTroops {
+		if troop.ReadyAt.After(gs.pausedThrough) {
+			troop.ReadyAt = troop.ReadyAt.Add(d)
+		}
+		if !troop.ExpiresAt.IsZero() {
+			troop.ExpiresAt = troop.ExpiresAt.Add(d)
+		}
+	}
+	for token, expiry := range gs.tokenExpiry { // The game ends later, so its tokens must last longer
+		gs.tokenExpiry[token] = expiry.Add(d)
+	}
+}
+
+// sendGameEventToOpponentOf sends an event to the other player of the game.
+func (gs *GameSession) sendGameEventToOpponentOf(player *models.PlayerInGame, eventType string, details interface{}) {
+	opponent := gs.Player1
+	if player == gs.Player1 {
+		opponent = gs.Player2
+	}
+	gs.sendGameEventToPlayer(opponent.SessionToken, eventType, details)
+}
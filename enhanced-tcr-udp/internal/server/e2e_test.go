@@ -0,0 +1,365 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"enhanced-tcr-udp/internal/bot"
+	"enhanced-tcr-udp/internal/persistence"
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+	"enhanced-tcr-udp/pkg/tcrclient"
+)
+
+// startLoopbackServer runs a server on loopback ports playing by rules until the test
+// ends, and returns its TCP address.
+func startLoopbackServer(t *testing.T, rules models.GameRules) string {
+	t.Helper()
+	probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("finding a free UDP port: %v", err)
+	}
+	port := probe.LocalAddr().(*net.UDPAddr).Port
+	probe.Close()
+	if err := SetNetworkConfig(NetworkConfig{
+		TCPListen:       "127.0.0.1:0",
+		UDPListenHost:   "127.0.0.1",
+		UDPPortMin:      port,
+		UDPPortMax:      port,
+		TCPWriteTimeout: 5 * time.Second,
+	}); err != nil {
+		t.Fatalf("SetNetworkConfig: %v", err)
+	}
+	t.Cleanup(func() { SetNetworkConfig(DefaultNetworkConfig()) })
+
+	previous := GlobalSessionManager.Rules()
+	GlobalSessionManager.SetRules(rules)
+	t.Cleanup(func() { GlobalSessionManager.SetRules(previous) })
+
+	s := NewServer("127.0.0.1:0")
+	if err := s.Listen(); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go s.Serve()
+	t.Cleanup(s.Stop)
+	return s.Addr().String()
+}
+
+// botGame is what one scripted player saw of the loopback game.
+type botGame struct {
+	match   *tcrclient.Match
+	results network.GameOverResults
+	err     error
+
+	mu       sync.Mutex
+	deployed map[uint32]bool // Seqs of the deploys sent
+	acked    map[uint32]bool // Seqs of the deploys the server acknowledged
+}
+
+// ackedDeploys returns how many of the player's deploys the server acknowledged.
+func (g *botGame) ackedDeploys() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n := 0
+	for seq := range g.deployed {
+		if g.acked[seq] {
+			n++
+		}
+	}
+	return n
+}
+
+// playBot logs in as username and plays one game with the named strategy, the way the
+// client's bot subcommand does.
+func playBot(ctx context.Context, addr, username, strategyName string) *botGame {
+	g := &botGame{deployed: make(map[uint32]bool), acked: make(map[uint32]bool)}
+	c, err := tcrclient.Connect(ctx, addr)
+	if err != nil {
+		g.err = err
+		return g
+	}
+	defer c.Close()
+	if _, err := c.Login(ctx, username, "secret"); err != nil {
+		g.err = err
+		return g
+	}
+
+	var strategy bot.Strategy // Set once Queue returns the config; guarded by g.mu
+	c.OnStateUpdate(func(update network.GameStateUpdateUDP) {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if strategy == nil {
+			return
+		}
+		for _, action := range strategy.OnState(update) {
+			if seq, err := c.Deploy(ctx, action.Deploy, ""); err == nil {
+				g.deployed[seq] = true
+			}
+		}
+	})
+	c.OnAck(func(seq uint32) {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.acked[seq] = true
+	})
+
+	if g.match, g.err = c.Queue(ctx, tcrclient.QueueOptions{QueueType: network.QueueRanked}); g.err != nil {
+		return g
+	}
+	opts := bot.Options{Username: username, PlayerOne: g.match.IsPlayerOne, Seed: 1}
+	if g.match.Config != nil {
+		opts.Troops, opts.Towers = g.match.Config.Troops, g.match.Config.Towers
+	}
+	s, err := bot.New(strategyName, opts)
+	if err != nil {
+		g.err = err
+		return g
+	}
+	g.mu.Lock()
+	strategy = s
+	g.mu.Unlock()
+
+	g.results, g.err = c.Results(ctx)
+	return g
+}
+
+// Two bots play a whole game against a real server over loopback sockets: matchmaking
+// over TCP, deploys and their ACKs over the session's UDP port, and results with EXP that
+// was saved to the players' accounts.
+func TestLoopbackGame(t *testing.T) {
+	if testing.Short() {
+		t.Skip("plays a 10-second game")
+	}
+	rules := models.DefaultGameRules()
+	rules.GameDuration, rules.CountdownSeconds = 10*time.Second, 1
+	addr := startLoopbackServer(t, rules)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	names := []string{"e2e-alice", "e2e-bob"}
+	strategies := []string{"cheapest-spam", "value-based"}
+	games := make([]*botGame, len(names))
+	var wg sync.WaitGroup
+	for i := range names {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			games[i] = playBot(ctx, addr, names[i], strategies[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i, g := range games {
+		var cancelled *tcrclient.MatchCancelledError
+		if errors.As(g.err, &cancelled) {
+			t.Fatalf("%s: the match was called off: %s", names[i], cancelled.Reason)
+		}
+		if g.err != nil {
+			t.Fatalf("%s: %v", names[i], g.err)
+		}
+	}
+	alice, bob := games[0], games[1]
+
+	if alice.match.GameID == "" || alice.match.GameID != bob.match.GameID {
+		t.Errorf("matched into games %q and %q, want the same one", alice.match.GameID, bob.match.GameID)
+	}
+	if alice.match.IsPlayerOne == bob.match.IsPlayerOne {
+		t.Errorf("both players have IsPlayerOne = %v", alice.match.IsPlayerOne)
+	}
+	if alice.match.Config == nil || bob.match.Config == nil {
+		t.Error("a player got no game config with the match")
+	}
+	if alice.ackedDeploys()+bob.ackedDeploys() == 0 {
+		t.Error("no deploy was acknowledged during the game")
+	}
+
+	for i, g := range games {
+		if g.results.GameID != alice.match.GameID {
+			t.Errorf("%s: results for game %q, want %q", names[i], g.results.GameID, alice.match.GameID)
+		}
+		if g.results.Reason == "" {
+			t.Errorf("%s: results give no reason the game ended", names[i])
+		}
+		if g.results.TroopsDeployedByYou == 0 && g.results.TroopsDeployedByOpponent == 0 {
+			t.Errorf("%s: results count no troops deployed", names[i])
+		}
+		account, err := persistence.LoadPlayerAccount(names[i])
+		if err != nil {
+			t.Fatalf("%s: loading the saved account: %v", names[i], err)
+		}
+		if account.EXP != g.results.NewEXP || account.Level != g.results.NewLevel {
+			t.Errorf("%s: saved account has %d EXP at level %d, results say %d EXP at level %d",
+				names[i], account.EXP, account.Level, g.results.NewEXP, g.results.NewLevel)
+		}
+	}
+
+	// The two players' results tell the same story
+	if alice.results.WinnerID != bob.results.WinnerID {
+		t.Errorf("winners %q and %q, want the same", alice.results.WinnerID, bob.results.WinnerID)
+	}
+	if alice.results.TroopsDeployedByYou != bob.results.TroopsDeployedByOpponent || bob.results.TroopsDeployedByYou != alice.results.TroopsDeployedByOpponent {
+		t.Errorf("deploy counts disagree: alice %d/%d, bob %d/%d", alice.results.TroopsDeployedByYou, alice.results.TroopsDeployedByOpponent,
+			bob.results.TroopsDeployedByYou, bob.results.TroopsDeployedByOpponent)
+	}
+	for i, g := range games {
+		want := "loss"
+		switch g.results.WinnerID {
+		case "":
+			want = "draw"
+		case names[i]:
+			want = "win"
+		}
+		if g.results.Outcome != want {
+			t.Errorf("%s: outcome %q with winner %q, want %q", names[i], g.results.Outcome, g.results.WinnerID, want)
+		}
+	}
+}
+
+// A player whose client went away mid-game logs in again and rejoins it with Rejoin, under
+// a new session token, after changing a setting in the lobby. Surrendering over the
+// rejoined session then ends the game for both players. Nothing the server sends on the
+// way carries a password hash.
+func TestLoopbackRejoin(t *testing.T) {
+	rules := models.DefaultGameRules()
+	rules.GameDuration, rules.CountdownSeconds = time.Minute, 1
+	addr := startLoopbackServer(t, rules)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var receivedMu sync.Mutex
+	var received [][]byte // Every TCP message the server sent: login, match, rejoin, settings, results
+	login := func(username string) *tcrclient.Client {
+		t.Helper()
+		c, err := tcrclient.Connect(ctx, addr)
+		if err != nil {
+			t.Fatalf("%s: Connect: %v", username, err)
+		}
+		t.Cleanup(func() { c.Close() })
+		c.TraceTCP(func(direction string, data []byte) {
+			if direction == network.CaptureReceived {
+				receivedMu.Lock()
+				received = append(received, append([]byte(nil), data...))
+				receivedMu.Unlock()
+			}
+		})
+		if _, err := c.Login(ctx, username, "secret"); err != nil {
+			t.Fatalf("%s: Login: %v", username, err)
+		}
+		return c
+	}
+	alice, bob := login("rejoin-alice"), login("rejoin-bob")
+
+	settings, err := alice.UpdateSettings(ctx, map[string]string{models.SettingPreferredDeck: "rush"})
+	if err != nil {
+		t.Fatalf("UpdateSettings: %v", err)
+	}
+	if settings[models.SettingPreferredDeck] != "rush" || alice.Account().Settings[models.SettingPreferredDeck] != "rush" {
+		t.Errorf("settings after the update: returned %v, account %v", settings, alice.Account().Settings)
+	}
+
+	bobMatch := make(chan *tcrclient.Match, 1)
+	go func() {
+		m, err := bob.Queue(ctx, tcrclient.QueueOptions{})
+		if err != nil {
+			t.Errorf("bob: Queue: %v", err)
+		}
+		bobMatch <- m
+	}()
+	first, err := alice.Queue(ctx, tcrclient.QueueOptions{})
+	if err != nil {
+		t.Fatalf("alice: Queue: %v", err)
+	}
+	if <-bobMatch == nil {
+		t.FailNow()
+	}
+	alice.Close()
+
+	again := login("rejoin-alice")
+	if again.ActiveGameID() != first.GameID {
+		t.Fatalf("ActiveGameID() = %q after logging in again, want %q", again.ActiveGameID(), first.GameID)
+	}
+	match, err := again.Rejoin(ctx)
+	if err != nil {
+		t.Fatalf("Rejoin: %v", err)
+	}
+	if match.GameID != first.GameID {
+		t.Errorf("rejoined game %q, want %q", match.GameID, first.GameID)
+	}
+	if match.PlayerSessionToken == "" || match.PlayerSessionToken == first.PlayerSessionToken {
+		t.Errorf("rejoined with token %q, want a new one (was %q)", match.PlayerSessionToken, first.PlayerSessionToken)
+	}
+	if match.Config == nil {
+		t.Error("rejoined without the game config")
+	}
+	if again.ActiveGameID() != "" {
+		t.Errorf("ActiveGameID() = %q after rejoining, want none", again.ActiveGameID())
+	}
+
+	if _, err := again.Session().Send(network.UDPMsgTypeSurrender, network.SurrenderUDP{}); err != nil {
+		t.Fatalf("surrendering: %v", err)
+	}
+	results, err := again.Results(ctx)
+	if err != nil {
+		t.Fatalf("alice: Results: %v", err)
+	}
+	if results.GameID != first.GameID || results.Outcome != "loss" {
+		t.Errorf("alice: results for game %q with outcome %q, want a loss in %q", results.GameID, results.Outcome, first.GameID)
+	}
+	if results, err := bob.Results(ctx); err != nil || results.Outcome != "win" {
+		t.Errorf("bob: Results = %q, %v; want a win", results.Outcome, err)
+	}
+
+	// The responses carrying an account (login, match, rejoin) never carry its password hash
+	receivedMu.Lock()
+	defer receivedMu.Unlock()
+	for _, username := range []string{"rejoin-alice", "rejoin-bob"} {
+		account, err := persistence.LoadPlayerAccount(username)
+		if err != nil || account.HashedPassword == "" {
+			t.Fatalf("loading %s: %v (hash %q)", username, err, account.HashedPassword)
+		}
+		for _, data := range received {
+			if bytes.Contains(data, []byte(account.HashedPassword)) {
+				t.Errorf("the server sent %s's password hash: %s", username, data)
+			}
+		}
+	}
+	for _, data := range received {
+		var msg interface{}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("decoding %s: %v", data, err)
+		}
+		if key, ok := passwordKey(msg); ok {
+			t.Errorf("the server sent a %q field: %s", key, data)
+		}
+	}
+}
+
+// passwordKey looks through a decoded JSON value for a key naming a password, such as
+// "hashed_password", and returns the first found.
+func passwordKey(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if strings.Contains(strings.ToLower(key), "password") {
+				return key, true
+			}
+			if key, ok := passwordKey(value); ok {
+				return key, true
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if key, ok := passwordKey(value); ok {
+				return key, true
+			}
+		}
+	}
+	return "", false
+}
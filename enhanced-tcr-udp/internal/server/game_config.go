@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"enhanced-tcr-udp/internal/persistence"
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// loadGameConfig reads the tower and troop specs from the config files and checks them.
+// It reads the files on every call, so edits to them apply to the next game, and every
+// caller gets its own copy. Checks that depend on the session's rules are left to the caller.
+func loadGameConfig() (models.GameConfig, error) {
+	towerConf, err := persistence.LoadTowerConfig()
+	if err != nil {
+		return models.GameConfig{}, fmt.Errorf("loading tower config: %w", err)
+	}
+	troopConf, err := persistence.LoadTroopConfig()
+	if err != nil {
+		return models.GameConfig{}, fmt.Errorf("loading troop config: %w", err)
+	}
+
+	gameCfg := models.GameConfig{
+		Towers: towerConf,
+		Troops: troopConf,
+	}
+	if err := gameCfg.Normalize(); err != nil {
+		return models.GameConfig{}, fmt.Errorf("invalid display names/symbols in game config: %w", err)
+	}
+	if err := gameCfg.Validate(); err != nil {
+		return models.GameConfig{}, fmt.Errorf("invalid troop abilities in game config: %w", err)
+	}
+	return gameCfg, nil
+}
+
+// gameConfigResponse answers a MsgTypeGetGameConfig payload with the current game config,
+// or only its hash if the client already holds that config.
+func gameConfigResponse(player *models.PlayerAccount, payload json.RawMessage) network.GameConfigResponse {
+	var request network.GetGameConfigRequest
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &request); err != nil {
+			log.Printf("Malformed game config request from %s (%v). Sending the whole config.", player.Username, err)
+		}
+	}
+	gameCfg, err := loadGameConfig()
+	if err != nil {
+		log.Printf("Error loading game config for %s: %v", player.Username, err)
+		return network.GameConfigResponse{Success: false, Message: "the game config is unavailable"}
+	}
+	hash, err := gameCfg.Hash()
+	if err != nil {
+		log.Printf("Error hashing game config for %s: %v", player.Username, err)
+		return network.GameConfigResponse{Success: false, Message: "the game config is unavailable"}
+	}
+	if request.KnownHash == hash {
+		return network.GameConfigResponse{Success: true, Hash: hash, Unchanged: true}
+	}
+	return network.GameConfigResponse{Success: true, Hash: hash, Config: &gameCfg}
+}
@@ -1,29 +1,60 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
 	"enhanced-tcr-udp/internal/game" // Added for game logic
-	"enhanced-tcr-udp/internal/models"
-	"enhanced-tcr-udp/internal/network"
 	"enhanced-tcr-udp/internal/persistence"
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"maps"
 	"net"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	// "enhanced-tcr-udp/internal/game" // For actual game logic
 	// "enhanced-tcr-udp/internal/persistence" // For loading game config
 )
 
+const (
+	// playerActionQueueSize is the per-player buffer of UDP actions awaiting the game loop.
+	playerActionQueueSize = 10
+	// minStateUpdateGap rate-limits out-of-band state updates sent after player actions.
+	minStateUpdateGap = 100 * time.Millisecond
+	// stateKeepaliveInterval is the longest the session goes without a full state update,
+	// even when nothing has changed, so a client that missed one still converges.
+	stateKeepaliveInterval = 5 * time.Second
+	// udpReadTimeout is how long the UDP reader blocks before checking whether the session has stopped.
+	udpReadTimeout = 1 * time.Second
+	// actionEnqueueTimeout is how long the UDP reader waits for room in a full action queue
+	// before answering the sender with a server_busy error instead.
+	actionEnqueueTimeout = 50 * time.Millisecond
+	// sessionTokenGrace is how long a player's session token outlives the latest end the
+	// game can have, leaving room for the final messages.
+	sessionTokenGrace = 1 * time.Minute
+)
+
 // GameSession represents an active game between two players.
 type GameSession struct {
 	ID          string
 	Player1     *models.PlayerInGame // Extended struct with in-game state
 	Player2     *models.PlayerInGame
 	Config      models.GameConfig // Loaded game configuration (troops, towers)
+	Rules       models.GameRules  // Session rules (duration, mana)
+	udpHost     string
 	udpPort     int
-	udpConn     *net.UDPConn // Server-side UDP connection for this session
+	udpConn     *net.UDPConn  // Server-side UDP connection for this session
+	sender      *udpSender    // Owns all writes to udpConn
+	done        chan struct{} // Closed by Stop; the UDP reader then exits and closes udpConn
+	stopOnce    sync.Once
 	startTime   time.Time
 	gameEndTime time.Time
 	mu          sync.RWMutex
@@ -33,8 +64,12 @@ type GameSession struct {
 
 	playerClientAddresses map[string]*net.UDPAddr // Maps PlayerToken to their last known UDP address for targeted responses
 
-	playerActions chan network.UDPMessage // Channel to receive player actions
-	lastManaRegen time.Time               // For mana regeneration timing
+	// Each player gets a bounded action queue of their own so that one player flooding the
+	// session cannot crowd out the other's deploys or quit. The game loop selects on both.
+	player1Actions chan network.UDPMessage
+	player2Actions chan network.UDPMessage
+	droppedActions map[string]int       // PlayerToken -> actions discarded because that player's queue was full
+	lastManaRegen  map[string]time.Time // Username -> when the player last regenerated mana
 	// Add timers for troop and tower attacks
 	lastTroopAttack map[string]time.Time           // Key: Troop InstanceID
 	lastTowerAttack map[string]time.Time           // Key: Tower GameSpecificID
@@ -42,51 +77,191 @@ type GameSession struct {
 	towers          []*models.TowerInstance        // Centralized list of all towers
 	gameWinner      *models.PlayerInGame           // Stores the winner of the game
 	gameResult      string                         // e.g., "win", "loss", "draw"
-	isGameOver      bool                           // Flag to indicate if the game has concluded
-	resultsChan     chan<- network.GameResultInfo  // Channel to send game results back
+	endReason       string                         // Reason passed to determineWinnerAndStop, empty while running
+	forcedOutcome   ForceOutcome                   // Outcome imposed by ForceEnd, used with the "forced" reason
+	forcedReason    string                         // Operator's note passed to ForceEnd
+	surrenderedBy   *models.PlayerInGame           // Player who surrendered, used with the "surrender" reason
+
+	// Warm-up: the game clock, mana regen and deploys wait until combat starts (see advanceWarmup)
+	combatStarted  bool      // Combat has begun; stays set once the game is over, for the clock
+	warmupDeadline time.Time // The countdown starts by then even if a player is still silent
+	countdownEnd   time.Time // When combat starts; zero until the countdown begins
+	countdownShown int       // Last seconds-left value announced
+
+	// Pausing for a disconnected player (see checkDisconnects)
+	lastHeard     map[string]time.Time     // Username -> when that player's client last sent a valid datagram
+	pausedFor     *models.PlayerInGame     // The silent player the game is paused for; nil while it runs
+	pausedFrom    SessionState             // The state the current pause interrupted, resumed afterwards
+	pausedAt      time.Time                // When the current pause began
+	pausedThrough time.Time                // The game's timers have been pushed back for the pause up to here
+	graceShown    int                      // Last grace seconds announced for the current pause
+	graceUsed     map[string]time.Duration // Username -> time the game spent paused for that player in earlier pauses
+
+	// Per-session log file, when enabled with SessionOptions.SessionLog. logf writes each
+	// line there as well as to the server log; Stop closes it.
+	sessionLog  *log.Logger
+	logFile     *os.File
+	logPath     string
+	capture     *network.Capture              // Every UDP message sent or received, when SessionOptions.Capture is set
+	resultsChan chan<- network.GameResultInfo // Channel to send game results back
+	ending      atomic.Pointer[gameEnding]    // Set by determineWinnerAndStop until settleEnding takes it
 
 	processedDeployCommands map[string]map[uint32]time.Time // PlayerToken -> Seq -> ProcessTime
+	prunedDeploySeq         map[string]uint32               // PlayerToken -> highest Seq whose processedDeployCommands entry was pruned
+	lastProcessedSeq        map[string]uint32               // PlayerToken -> highest command Seq handled (applied or rejected)
+	commandWatermarks       map[string]*commandWatermark    // PlayerToken -> watermark echoed in state updates
+	commandChecks           map[string]*commandPlausibility // PlayerToken -> implausible commands seen (see checkCommandPlausibility)
+	spectators              *spectatorRegistry              // Who is watching; see AddSpectator
+	winConditions           game.WinConditionEvaluator      // Judges the end of the game; see determineWinnerAndStop
+	casual                  bool                            // Matched in the casual queue; see SessionOptions.QueueType
+	analytics               bool                            // See SessionOptions.Analytics
+
+	seqMu  sync.Mutex
+	outSeq map[string]uint32 // Stream -> last Seq sent on that stream
+
+	lastStateBroadcast time.Time                  // When the last GameStateUpdateUDP went out
+	lastStateDigest    string                     // The last GameStateUpdateUDP without its clock; see stateDigest
+	lastClock          network.GameTimerUpdateUDP // Clock fields of the last state or timer update sent
+	playerProtocols    map[string]int             // PlayerToken -> protocol version negotiated at login
+	tokenExpiry        map[string]time.Time       // PlayerToken -> when packets carrying it stop being accepted; see RotatePlayerToken
+	stateUpdateID      uint32                     // Increments per state update; shared by the parts of a split one
+	stateUpdatePending bool                       // A coalesced out-of-band state update is scheduled
+	lastFullState      map[string]time.Time       // PlayerToken -> when sendFullState last answered that player
+
+	// lastTickAt is the UnixNano time of the last completed tick. It is atomic so the
+	// manager's watchdog can read it even while a stalled loop is holding gs.mu.
+	lastTickAt atomic.Int64
+	state      atomic.Int32 // SessionState; changed only by transition, with gs.mu held
+
+	udpDrops       *network.UDPDrops // Inbound datagrams dropped before reaching an action queue, by reason
+	delayedActions atomic.Uint64     // Actions that found their queue full but got in within actionEnqueueTimeout
+	busyRejections atomic.Uint64     // Actions rejected with server_busy after actionEnqueueTimeout
+	lastInboundAt  atomic.Int64      // UnixNano time of the last datagram received from any player
+
+	perf tickStats // Tick durations, troop peak and action queue high-water mark; see finishTick
+}
+
+// Errors returned by NewGameSession, wrapping the underlying cause.
+var (
+	ErrSessionConfig = errors.New("game config unavailable")      // Tower or troop config could not be loaded; retrying will not help
+	ErrSessionUDP    = errors.New("session UDP setup failed")     // The session's UDP port could not be bound; another port may work
+	ErrSamePlayer    = errors.New("players are the same account") // Returned by CreateSession; a player cannot be matched against themselves
+	ErrPlayerInGame  = errors.New("player is already in a game")  // Returned by CreateSession; a player can only be in one running game
+)
+
+// SessionOptions describes a game session to create.
+type SessionOptions struct {
+	GameID       string
+	Player1      *models.PlayerAccount
+	Player2      *models.PlayerAccount
+	Player1Token string // Filled in by GameSessionManager.CreateSession when empty
+	Player2Token string // Filled in by GameSessionManager.CreateSession when empty
+	// Protocol versions the players negotiated at login; 0 means network.ProtocolVersion.
+	Player1Protocol, Player2Protocol int
+	UDPHost                          string                        // Host the session's UDP socket binds to; "" for all interfaces
+	UDPPort                          int                           // Port the session listens on
+	ResultsChan                      chan<- network.GameResultInfo // Receives the results once the game ends
+	Rules                            *models.GameRules             // nil means the manager's rules (or the defaults outside a manager)
+	SessionLog                       bool                          // Also write the session's log lines to its own file (persistence.SessionLogPath)
+	Capture                          bool                          // Record the session's UDP messages (persistence.SessionCapturePath)
+	Analytics                        bool                          // Export an anonymized record of the game when it ends (persistence.AnalyticsRecord)
+	// WinConditions decides the outcome when the game ends; nil means game.DefaultWinConditions.
+	WinConditions game.WinConditionEvaluator
+	// QueueType is the matchmaking queue the players were matched in; "" means ranked.
+	// Casual games scale EXP by Rules.CasualEXPMultiplier and leave the records alone.
+	QueueType string
 }
 
 // NewGameSession creates a new game session.
-func NewGameSession(id string, p1Acc, p2Acc *models.PlayerAccount, p1Token, p2Token string, udpPort int, resultsChan chan<- network.GameResultInfo) *GameSession {
-	towerConf, err := persistence.LoadTowerConfig()
-	if err != nil {
-		log.Printf("[GameSession %s] Error loading tower config: %v. Aborting session.", id, err)
-		return nil
+func NewGameSession(opts SessionOptions) (*GameSession, error) {
+	id := opts.GameID
+	p1Acc, p2Acc := opts.Player1, opts.Player2
+	p1Token, p2Token := opts.Player1Token, opts.Player2Token
+	udpPort, resultsChan := opts.UDPPort, opts.ResultsChan
+	if p1Acc == nil || p2Acc == nil {
+		return nil, fmt.Errorf("game session %s needs two players", id)
 	}
-	troopConf, err := persistence.LoadTroopConfig()
-	if err != nil {
-		log.Printf("[GameSession %s] Error loading troop config: %v. Aborting session.", id, err)
-		return nil
+	if p1Token == "" || p2Token == "" || p1Token == p2Token {
+		return nil, fmt.Errorf("game session %s needs two distinct session tokens", id)
+	}
+	rules := models.DefaultGameRules()
+	if opts.Rules != nil {
+		rules = *opts.Rules
+	}
+	if opts.QueueType != network.QueueCasual && len(rules.PerPlayerOverrides) > 0 {
+		log.Printf("[GameSession %s] Ignoring handicaps in a ranked game.", id)
+		rules.PerPlayerOverrides = nil
+	}
+	if err := rules.ValidateOverrides(); err != nil {
+		return nil, fmt.Errorf("game session %s: %w", id, err)
+	}
+	winConditions := opts.WinConditions
+	if winConditions == nil {
+		winConditions = game.DefaultWinConditions{}
 	}
 
-	gameCfg := models.GameConfig{
-		Towers: towerConf,
-		Troops: troopConf,
+	gameCfg, err := loadGameConfig()
+	if err != nil {
+		log.Printf("[GameSession %s] Error in game config: %v. Aborting session.", id, err)
+		return nil, fmt.Errorf("%w: %w", ErrSessionConfig, err)
+	}
+	if err := rules.ValidateDamageMatrix(&gameCfg); err != nil {
+		log.Printf("[GameSession %s] Invalid damage matrix: %v. Aborting session.", id, err)
+		return nil, fmt.Errorf("%w: %w", ErrSessionConfig, err)
+	}
+	if err := rules.ValidateSpawnDelays(&gameCfg); err != nil {
+		log.Printf("[GameSession %s] Invalid troop spawn delay: %v. Aborting session.", id, err)
+		return nil, fmt.Errorf("%w: %w", ErrSessionConfig, err)
 	}
 
 	startTime := time.Now()
 	gs := &GameSession{
 		ID:                      id,
-		Player1:                 &models.PlayerInGame{Account: *p1Acc, SessionToken: p1Token, CurrentMana: 5, DeployedTroops: make(map[string]*models.ActiveTroop), Towers: make([]*models.TowerInstance, 0)},
-		Player2:                 &models.PlayerInGame{Account: *p2Acc, SessionToken: p2Token, CurrentMana: 5, DeployedTroops: make(map[string]*models.ActiveTroop), Towers: make([]*models.TowerInstance, 0)},
+		Player1:                 &models.PlayerInGame{Account: *p1Acc, SessionToken: p1Token, CurrentMana: rules.StartingManaFor(p1Acc.Username), DeployedTroops: make(map[string]*models.ActiveTroop), Towers: make([]*models.TowerInstance, 0)},
+		Player2:                 &models.PlayerInGame{Account: *p2Acc, SessionToken: p2Token, CurrentMana: rules.StartingManaFor(p2Acc.Username), DeployedTroops: make(map[string]*models.ActiveTroop), Towers: make([]*models.TowerInstance, 0)},
 		Config:                  gameCfg,
+		Rules:                   rules,
+		udpHost:                 opts.UDPHost,
 		udpPort:                 udpPort,
+		done:                    make(chan struct{}),
 		startTime:               startTime,
-		gameEndTime:             startTime.Add(3 * time.Minute),
-		playerActions:           make(chan network.UDPMessage, 10),
+		gameEndTime:             startTime.Add(rules.GameDuration), // Provisional; startCombat sets the real end
+		warmupDeadline:          startTime.Add(rules.WarmupTimeout),
+		player1Actions:          make(chan network.UDPMessage, playerActionQueueSize),
+		player2Actions:          make(chan network.UDPMessage, playerActionQueueSize),
+		droppedActions:          make(map[string]int),
+		udpDrops:                network.NewUDPDrops(nil),
+		lastFullState:           make(map[string]time.Time),
+		lastHeard:               make(map[string]time.Time),
+		graceUsed:               make(map[string]time.Duration),
 		playerClientAddresses:   make(map[string]*net.UDPAddr),
-		lastManaRegen:           startTime,
+		lastManaRegen:           map[string]time.Time{p1Acc.Username: startTime, p2Acc.Username: startTime},
 		lastTroopAttack:         make(map[string]time.Time),
 		lastTowerAttack:         make(map[string]time.Time),
 		activeTroops:            make(map[string]*models.ActiveTroop), // Initialize centralized map
 		towers:                  make([]*models.TowerInstance, 0),     // Initialize centralized list
 		gameWinner:              nil,
 		gameResult:              "",
-		isGameOver:              false,
 		resultsChan:             resultsChan,
 		processedDeployCommands: make(map[string]map[uint32]time.Time),
+		prunedDeploySeq:         make(map[string]uint32),
+		lastProcessedSeq:        make(map[string]uint32),
+		commandWatermarks:       make(map[string]*commandWatermark),
+		commandChecks:           make(map[string]*commandPlausibility),
+		spectators:              newSpectatorRegistry(),
+		winConditions:           winConditions,
+		casual:                  opts.QueueType == network.QueueCasual,
+		analytics:               opts.Analytics,
+		outSeq:                  make(map[string]uint32),
+		playerProtocols:         map[string]int{p1Token: opts.Player1Protocol, p2Token: opts.Player2Protocol},
+	}
+	// Combat starts by the end of the warm-up and countdown, so no game outlives this
+	tokenExpiry := startTime.Add(rules.WarmupTimeout + time.Duration(rules.CountdownSeconds)*time.Second + rules.GameDuration + sessionTokenGrace)
+	gs.tokenExpiry = map[string]time.Time{p1Token: tokenExpiry, p2Token: tokenExpiry}
+	for token, version := range gs.playerProtocols {
+		if version == 0 {
+			gs.playerProtocols[token] = network.ProtocolVersion
+		}
 	}
 
 	// Initialize processedDeployCommands for each player
@@ -94,43 +269,68 @@ func NewGameSession(id string, p1Acc, p2Acc *models.PlayerAccount, p1Token, p2To
 	gs.processedDeployCommands[p2Token] = make(map[uint32]time.Time)
 
 	// Initialize towers for Player 1
-	initializePlayerTowers(gs.Player1, gs.Config.Towers, "player1", gs.Player1.Account.Level) // Pass player level
+	initializePlayerTowers(gs.Player1, gs.Config.Towers, "player1", statMultiplier(rules, &gs.Player1.Account), rules.TwoLanes)
 	// Initialize towers for Player 2
-	initializePlayerTowers(gs.Player2, gs.Config.Towers, "player2", gs.Player2.Account.Level) // Pass player level
+	initializePlayerTowers(gs.Player2, gs.Config.Towers, "player2", statMultiplier(rules, &gs.Player2.Account), rules.TwoLanes)
 
 	// Populate the centralized towers list
 	gs.towers = append(gs.towers, gs.Player1.Towers...)
 	gs.towers = append(gs.towers, gs.Player2.Towers...)
 
+	// The session counts as freshly ticked until its loop starts
+	gs.lastTickAt.Store(startTime.UnixNano())
+
 	// Initialize lastAttack times for towers
 	now := time.Now()
 	for _, tower := range gs.towers {
 		gs.lastTowerAttack[tower.GameSpecificID] = now
 	}
 
-	log.Printf("Initializing GameSession %s for %s and %s. Player1 Towers: %d, Player2 Towers: %d. Total towers: %d", id, p1Acc.Username, p2Acc.Username, len(gs.Player1.Towers), len(gs.Player2.Towers), len(gs.towers))
+	if opts.SessionLog {
+		// A missing session log is a nuisance, not a reason to refuse the game
+		if logFile, err := persistence.OpenSessionLog(id); err != nil {
+			log.Printf("[GameSession %s] Could not open session log: %v. Logging to the server log only.", id, err)
+		} else {
+			gs.logFile = logFile
+			gs.logPath = logFile.Name()
+			gs.sessionLog = log.New(logFile, "", log.LstdFlags|log.Lmicroseconds)
+		}
+	}
+
+	if opts.Capture {
+		if capture, err := persistence.OpenSessionCapture(id); err != nil {
+			log.Printf("[GameSession %s] Could not open message capture: %v. Continuing without it.", id, err)
+		} else {
+			gs.capture = capture
+		}
+	}
+
+	gs.logf("Initializing GameSession %s for %s and %s. Player1 Towers: %d, Player2 Towers: %d. Total towers: %d", id, p1Acc.Username, p2Acc.Username, len(gs.Player1.Towers), len(gs.Player2.Towers), len(gs.towers))
 
 	if err := gs.setupUDPConnectionAndListener(); err != nil {
-		log.Printf("[GameSession %s] Failed to setup UDP listener: %v. Aborting session.", gs.ID, err)
-		return nil // Session cannot function without UDP
+		gs.logf("[GameSession %s] Failed to setup UDP listener: %v. Aborting session.", gs.ID, err)
+		gs.closeSessionLog()
+		gs.capture.Close()
+		return nil, fmt.Errorf("%w: port %d: %w", ErrSessionUDP, udpPort, err) // Session cannot function without UDP
 	}
 
-	return gs
+	return gs, nil
 }
 
-// initializePlayerTowers creates tower instances for a player based on config.
-func initializePlayerTowers(player *models.PlayerInGame, towerSpecs map[string]models.TowerSpec, playerPrefix string, playerLevel int) {
-	// Calculate stat multiplier based on player level (10% cumulative per level)
-	// Level 1 = base stats (multiplier 1.0)
-	// Level 2 = base stats * 1.1
-	// Level N = base stats * (1.1)^(N-1)
-	levelMultiplier := 1.0
-	if playerLevel > 1 {
-		for i := 1; i < playerLevel; i++ {
-			levelMultiplier *= 1.1
-		}
+// statMultiplier returns the multiplier for a player's troop and tower stats: their handicap's
+// if they have one, otherwise their level's (cumulative per level, see game.LevelMultiplier).
+func statMultiplier(rules models.GameRules, account *models.PlayerAccount) float64 {
+	if multiplier, ok := rules.StatMultiplierFor(account.Username); ok {
+		return multiplier
 	}
+	return game.LevelMultiplier(account.Level, rules.LevelStatBonus)
+}
 
+// initializePlayerTowers creates tower instances for a player based on config, with stats
+// scaled by levelMultiplier (see statMultiplier). With twoLanes, each tower is placed in its
+// spec's lane, or once per lane if the spec names none; the King Tower covers both lanes.
+func initializePlayerTowers(player *models.PlayerInGame, towerSpecs map[string]models.TowerSpec, playerPrefix string, levelMultiplier float64, twoLanes bool) {
+	playerLevel := player.Account.Level
 	log.Printf("[GameSession] Initializing towers for %s (Level %d) with multiplier %.2f", player.Account.Username, playerLevel, levelMultiplier)
 	for specID, spec := range towerSpecs {
 		log.Printf("[GameSession] Processing tower specID: '%s', Name: '%s', BaseHP: %d", specID, spec.Name, spec.BaseHP)
@@ -141,233 +341,739 @@ func initializePlayerTowers(player *models.PlayerInGame, towerSpecs map[string]m
 			log.Printf("[GameSession] Warning: spec.Name is empty for specID '%s'. Using specID for GameSpecificID part: %s", specID, gameSpecificID)
 		}
 
-		instance := &models.TowerInstance{
-			SpecID:         specID,
-			OwnerID:        player.Account.Username, // Use Username as OwnerID
-			MaxHP:          int(float64(spec.BaseHP) * levelMultiplier),
-			CurrentHP:      int(float64(spec.BaseHP) * levelMultiplier),
-			CurrentATK:     int(float64(spec.BaseATK) * levelMultiplier),
-			CurrentDEF:     int(float64(spec.BaseDEF) * levelMultiplier),
-			IsDestroyed:    false,
-			GameSpecificID: gameSpecificID,
+		lanes := []string{""}
+		switch {
+		case !twoLanes || game.IsKingTowerSpec(spec):
+		case models.ValidLane(spec.Lane):
+			lanes = []string{spec.Lane}
+		default:
+			if spec.Lane != "" {
+				log.Printf("[GameSession] Warning: tower specID '%s' has unknown lane %q. Placing it in every lane.", specID, spec.Lane)
+			}
+			lanes = models.Lanes
 		}
-		if instance.MaxHP == 0 && spec.BaseHP != 0 { // Log if MaxHP ended up 0 but BaseHP was not
-			log.Printf("[GameSession] Warning: Tower %s (SpecID: %s) initialized with MaxHP 0 despite BaseHP %d and multiplier %.2f", instance.GameSpecificID, specID, spec.BaseHP, levelMultiplier)
+		for _, lane := range lanes {
+			instance := &models.TowerInstance{
+				SpecID:         specID,
+				OwnerID:        player.Account.Username, // Use Username as OwnerID
+				MaxHP:          game.ScaleStat(spec.BaseHP, levelMultiplier),
+				CurrentHP:      game.ScaleStat(spec.BaseHP, levelMultiplier),
+				CurrentATK:     game.ScaleStat(spec.BaseATK, levelMultiplier),
+				CurrentDEF:     game.ScaleStat(spec.BaseDEF, levelMultiplier),
+				IsDestroyed:    false,
+				GameSpecificID: gameSpecificID,
+				Lane:           lane,
+			}
+			if len(lanes) > 1 {
+				instance.GameSpecificID = fmt.Sprintf("%s_%s", gameSpecificID, lane) // e.g. "player1_guard_tower_left"
+			}
+			if instance.MaxHP == 0 && spec.BaseHP != 0 { // Log if MaxHP ended up 0 but BaseHP was not
+				log.Printf("[GameSession] Warning: Tower %s (SpecID: %s) initialized with MaxHP 0 despite BaseHP %d and multiplier %.2f", instance.GameSpecificID, specID, spec.BaseHP, levelMultiplier)
+			}
+			player.Towers = append(player.Towers, instance)
 		}
-		player.Towers = append(player.Towers, instance)
 	}
 	log.Printf("Initialized %d towers for player %s (Level %d) with multiplier %.2f", len(player.Towers), player.Account.Username, playerLevel, levelMultiplier)
 }
 
 // Start begins the game loop for the session.
 func (gs *GameSession) Start() {
-	log.Printf("Game session %s started. Game will end at %v. Player1: %s (Token: %s), Player2: %s (Token: %s)", gs.ID, gs.gameEndTime, gs.Player1.Account.Username, gs.Player1.SessionToken, gs.Player2.Account.Username, gs.Player2.SessionToken)
+	gs.logf("Game session %s started; warming up until both players connect (at most %v). Player1: %s (Token: %s), Player2: %s (Token: %s)", gs.ID, gs.Rules.WarmupTimeout, gs.Player1.Account.Username, gs.Player1.SessionToken, gs.Player2.Account.Username, gs.Player2.SessionToken)
+	gs.mu.Lock()
+	if !gs.State().Finished() { // Ended or aborted before the loop got going
+		gs.transition(StateWaitingForPlayers)
+	}
+	gs.mu.Unlock()
 
-	ticker := time.NewTicker(500 * time.Millisecond) // Tick more frequently for responsiveness
+	ticker := time.NewTicker(gs.tickInterval())
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			gs.mu.Lock()
-			if gs.isGameOver {
-				gs.mu.Unlock()
-				// gs.Stop() // Stop is handled by determineWinnerAndStop
+			// Capture the time once so every check in this tick agrees on "now".
+			if gs.tick(time.Now()) {
 				return
 			}
 
-			if time.Now().After(gs.gameEndTime) {
-				log.Printf("[GameSession %s] Timer ended.", gs.ID)
-				gs.determineWinnerAndStop("timeout")
-				gs.mu.Unlock()
-				return
-			}
+		// When both queues have work, select picks between them uniformly at random,
+		// so a flooded queue cannot starve the other player's actions.
+		case action := <-gs.player1Actions:
+			gs.processPlayerAction(action)
 
-			// Mana Regeneration
-			if time.Since(gs.lastManaRegen) >= 2*time.Second {
-				if gs.Player1.CurrentMana < 10 {
-					gs.Player1.CurrentMana++
-				}
-				if gs.Player2.CurrentMana < 10 {
-					gs.Player2.CurrentMana++
-				}
-				gs.lastManaRegen = time.Now()
+		case action := <-gs.player2Actions:
+			gs.processPlayerAction(action)
+		}
+	}
+}
+
+// tick runs one step of the game loop as of now: warmup, mana regeneration, attacks, the
+// timeout check and the state broadcast. It reports whether the game is over, after which
+// the loop stops.
+func (gs *GameSession) tick(now time.Time) bool {
+	defer gs.settleEnding() // Once gs.mu is released, if the game ended
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.State().Finished() {
+		// gs.Stop() // Stop is handled by determineWinnerAndStop
+		return true
+	}
+
+	// A tick that fires late (GC pause, slow disk) does not lose game time: every
+	// timer below runs from the time it was last due rather than from this tick, so
+	// the simulation catches up on whatever fell due since the last processed tick.
+	tickInterval := gs.tickInterval()
+	if late := now.Sub(gs.LastTickAt()) - tickInterval; late > tickInterval {
+		gs.logf("[GameSession %s] Tick ran %v late; catching up.", gs.ID, late)
+	}
+	// Nothing after the end of the game is simulated, but everything due before it is,
+	// so a stall across the end still plays out the final attacks before the timeout.
+	simNow := now
+	if simNow.After(gs.gameEndTime) {
+		simNow = gs.gameEndTime
+	}
+
+	gs.pruneProcessedCommands(now)
+
+	// Nothing is simulated until both players are in and the countdown has run
+	if !gs.State().InCombat() {
+		gs.advanceWarmup(now)
+		if !gs.State().InCombat() {
+			gs.broadcastGameState(now, false)
+			gs.finishTick(now)
+			return false
+		}
+	}
+
+	// While a player is disconnected the game stands still, waiting for them
+	if gs.checkDisconnects(now) {
+		gs.broadcastGameState(now, false)
+		gs.finishTick(now)
+		return false
+	}
+
+	// Mana Regeneration, one point per interval elapsed; a handicap may change a
+	// player's interval
+	for _, player := range []*models.PlayerInGame{gs.Player1, gs.Player2} {
+		username := player.Account.Username
+		interval := gs.Rules.ManaRegenIntervalFor(username)
+		for simNow.Sub(gs.lastManaRegen[username]) >= interval {
+			if player.CurrentMana < gs.Rules.MaxMana {
+				player.CurrentMana++
 			}
+			gs.lastManaRegen[username] = gs.lastManaRegen[username].Add(interval)
+		}
+	}
+
+	// Troops whose lifetime ran out leave before anyone attacks
+	gs.expireTroops(simNow)
 
-			// --- Continuous Attack Logic ---
-			// Troops attack towers (1 per 2 seconds, as per plan)
-			currentTime := time.Now()
-			for troopID, troop := range gs.activeTroops {
-				if troop.CurrentHP > 0 && currentTime.Sub(gs.lastTroopAttack[troopID]) >= 2*time.Second {
-					targetTower := game.FindLowestHPTower(troop.OwnerID, gs.toModelGameSession()) // Pass models.GameSession
-					if targetTower != nil && targetTower.CurrentHP > 0 {
-						// TroopSpec needed for ATK. Assuming troop.CurrentATK is already set based on level.
-						damage := game.CalculateDamage(troop.CurrentATK, targetTower.CurrentDEF, false, 0) // Troops have 0% CRIT
-						if damage > 0 {
-							originalHP := targetTower.CurrentHP
-							game.ApplyDamageToTower(targetTower, damage)
-							log.Printf("[GameSession %s] Troop %s (Owner: %s) attacked Tower %s (Owner: %s) for %d damage. HP %d -> %d",
-								gs.ID, troop.SpecID, troop.OwnerID, targetTower.GameSpecificID, targetTower.OwnerID, damage, originalHP, targetTower.CurrentHP)
-							gs.sendGameEventToAllPlayers(network.GameEventTowerDamaged, map[string]interface{}{
-								"attacker_id": troop.InstanceID, "attacker_spec": troop.SpecID, "defender_id": targetTower.GameSpecificID, "defender_spec": targetTower.SpecID, "damage": damage, "new_hp": targetTower.CurrentHP,
-							})
-							if targetTower.CurrentHP == 0 {
-								targetTower.IsDestroyed = true
-								log.Printf("[GameSession %s] Tower %s (Owner: %s) DESTROYED by Troop %s (Owner: %s)!",
-									gs.ID, targetTower.GameSpecificID, targetTower.OwnerID, troop.SpecID, troop.OwnerID)
-								gs.sendGameEventToAllPlayers(network.GameEventTowerDestroyed, map[string]interface{}{
-									"tower_id": targetTower.GameSpecificID, "tower_spec": targetTower.SpecID, "owner_id": targetTower.OwnerID, "destroyed_by_troop_id": troop.InstanceID,
-								})
-								// Check for King Tower destruction for instant win
-								if gs.isKingTower(targetTower) {
-									log.Printf("[GameSession %s] King Tower %s DESTROYED! Determining winner.", gs.ID, targetTower.GameSpecificID)
-									gs.determineWinnerAndStop("king_tower_destroyed")
-									gs.mu.Unlock() // ensure unlock before return
-									return
-								}
-							}
+	// --- Continuous Attack Logic ---
+	// Troops attack towers (1 per attackInterval, as per plan), catching up on
+	// attacks that fell due while the loop was stalled. Troops whose owner has no
+	// opponent tower left to attack are skipped, timers and all.
+	hasTargets := game.PlayersWithTargets(gs.toModelGameSession())
+	for troopID, troop := range gs.activeTroops {
+		if !hasTargets[troop.OwnerID] {
+			continue
+		}
+		if gs.State().Finished() { // Unreachable: the game ends as its King Tower falls
+			gs.logf("[GameSession %s] BUG: Troop %s (ID: %s) about to attack after the game ended. Skipping the remaining attacks.", gs.ID, troop.SpecID, troopID)
+			break
+		}
+		last := gs.lastTroopAttack[troopID]
+		due := attacksDue(&last, simNow)
+		gs.lastTroopAttack[troopID] = last
+		for ; due > 0 && troop.CurrentHP > 0 && hasTargets[troop.OwnerID]; due-- {
+			targetTower := game.FindTargetTower(troop, gs.toModelGameSession()) // Pass models.GameSession
+			if targetTower != nil && targetTower.CurrentHP > 0 {
+				troop.TargetID = targetTower.GameSpecificID // Shown on the client's battlefield panel
+				// TroopSpec needed for ATK. Assuming troop.CurrentATK is already set based on level.
+				attackATK := troop.CurrentATK
+				chargeMultiplier := 0.0
+				troopSpec := gs.Config.Troops[troop.SpecID]
+				if !troop.HasAttacked && troopSpec.Special == models.SpecialCharge {
+					chargeMultiplier = troopSpec.ChargeMultiplier
+					attackATK = game.ChargedATK(attackATK, chargeMultiplier)
+				}
+				troop.HasAttacked = true
+				effectiveness := game.Effectiveness(gs.Rules, troopSpec.DamageType, gs.Config.Towers[targetTower.SpecID].ArmorType)
+				damage, _ := game.CalculateDamage(attackATK, targetTower.CurrentDEF, false, 0, effectiveness) // Troops have 0% CRIT
+				if damage > 0 {
+					originalHP := targetTower.CurrentHP
+					hit := game.ApplyDamageToTower(targetTower, damage, troop.InstanceID)
+					gs.recordHit(troop.OwnerID, troop.SpecID, targetTower.OwnerID, hit.HPRemoved)
+					gs.logf("[GameSession %s] Troop %s (Owner: %s) attacked Tower %s (Owner: %s) for %d damage. HP %d -> %d",
+						gs.ID, troop.SpecID, troop.OwnerID, targetTower.GameSpecificID, targetTower.OwnerID, damage, originalHP, targetTower.CurrentHP)
+					eventData := network.TowerDamagedEvent{
+						AttackerID: troop.InstanceID, AttackerSpec: troop.SpecID, DefenderID: targetTower.GameSpecificID, DefenderSpec: targetTower.SpecID, Damage: damage, NewHP: targetTower.CurrentHP,
+					}
+					if effectiveness != 1.0 {
+						eventData.Effectiveness = effectiveness
+					}
+					if chargeMultiplier > 0 { // Celebrated like a crit
+						gs.sendGameEventToAllPlayers(network.GameEventChargeHit, network.ChargeHitEvent{TowerDamagedEvent: eventData, Multiplier: chargeMultiplier})
+					} else {
+						gs.sendGameEventToAllPlayers(network.GameEventTowerDamaged, eventData)
+					}
+					if hit.Destroyed {
+						gs.logf("[GameSession %s] Tower %s (Owner: %s) DESTROYED by Troop %s (Owner: %s)!",
+							gs.ID, targetTower.GameSpecificID, targetTower.OwnerID, troop.SpecID, troop.OwnerID)
+						gs.sendGameEventToAllPlayers(network.GameEventTowerDestroyed, network.TowerDestroyedEvent{
+							TowerID: targetTower.GameSpecificID, TowerSpec: targetTower.SpecID, OwnerID: targetTower.OwnerID, DestroyedByTroopID: troop.InstanceID, DestroyedByTroopSpec: troop.SpecID,
+						})
+						hasTargets = game.PlayersWithTargets(gs.toModelGameSession())
+						// Check for King Tower destruction for instant win
+						if gs.isKingTower(targetTower) {
+							gs.logf("[GameSession %s] King Tower %s DESTROYED! Determining winner.", gs.ID, targetTower.GameSpecificID)
+							gs.determineWinnerAndStop("king_tower_destroyed")
+							return true
 						}
 					}
-					gs.lastTroopAttack[troopID] = currentTime
 				}
 			}
+		}
+	}
+
+	// Towers attack troops (1 per attackInterval, as per plan), catching up the same way
+	for _, tower := range gs.towers {
+		last := gs.lastTowerAttack[tower.GameSpecificID]
+		due := attacksDue(&last, simNow)
+		gs.lastTowerAttack[tower.GameSpecificID] = last
+		for ; due > 0 && tower.CurrentHP > 0; due-- {
+			// TowerSpec needed for CRIT chance. Find it from gs.Config.Towers using tower.SpecID
+			towerSpec, specOk := gs.Config.Towers[tower.SpecID]
+			critChance := 0.0
+			if specOk {
+				critChance = towerSpec.CritChance // Assuming CritChance is float64 (0.0 to 1.0)
+			}
 
-			// Towers attack troops (1 per 2 seconds, as per plan)
-			for _, tower := range gs.towers {
-				if tower.CurrentHP > 0 && currentTime.Sub(gs.lastTowerAttack[tower.GameSpecificID]) >= 2*time.Second {
-					// TowerSpec needed for CRIT chance. Find it from gs.Config.Towers using tower.SpecID
-					towerSpec, specOk := gs.Config.Towers[tower.SpecID]
-					critChance := 0.0
-					if specOk {
-						critChance = towerSpec.CritChance // Assuming CritChance is float64 (0.0 to 1.0)
+			targetTroop := game.FindTroopToAttack(tower, gs.toModelGameSession()) // Pass models.GameSession
+			if targetTroop != nil && targetTroop.CurrentHP > 0 {
+				effectiveness := game.Effectiveness(gs.Rules, towerSpec.DamageType, gs.Config.Troops[targetTroop.SpecID].ArmorType)
+				damage, crit := game.CalculateDamage(tower.CurrentATK, targetTroop.CurrentDEF, true, critChance, effectiveness)
+				if damage > 0 {
+					originalHP := targetTroop.CurrentHP
+					hit := game.ApplyDamageToTroop(targetTroop, damage)
+					gs.recordHit(tower.OwnerID, "", targetTroop.OwnerID, hit.HPRemoved)
+					gs.logf("[GameSession %s] Tower %s (Owner: %s) attacked Troop %s (ID: %s, Owner: %s) for %d damage. HP %d -> %d",
+						gs.ID, tower.GameSpecificID, tower.OwnerID, targetTroop.SpecID, targetTroop.InstanceID, targetTroop.OwnerID, damage, originalHP, targetTroop.CurrentHP)
+					eventData := network.TroopDamagedEvent{
+						AttackerID: tower.GameSpecificID, AttackerSpec: tower.SpecID, DefenderID: targetTroop.InstanceID, DefenderSpec: targetTroop.SpecID, Damage: damage, NewHP: targetTroop.CurrentHP,
+					}
+					if hit.Absorbed > 0 {
+						eventData.ShieldAbsorbed = hit.Absorbed
+						eventData.ShieldLeft = targetTroop.ShieldHP
+					}
+					if effectiveness != 1.0 {
+						eventData.Effectiveness = effectiveness
+					}
+					if crit {
+						gs.sendGameEventToAllPlayers(network.GameEventCritHit, network.CritHitEvent{TroopDamagedEvent: eventData})
+					} else {
+						gs.sendGameEventToAllPlayers(network.GameEventTroopDamaged, eventData)
 					}
 
-					targetTroop := game.FindTroopToAttack(tower.OwnerID, gs.toModelGameSession()) // Pass models.GameSession
-					if targetTroop != nil && targetTroop.CurrentHP > 0 {
-						damage := game.CalculateDamage(tower.CurrentATK, targetTroop.CurrentDEF, true, critChance)
-						if damage > 0 {
-							originalHP := targetTroop.CurrentHP
-							game.ApplyDamageToTroop(targetTroop, damage)
-							log.Printf("[GameSession %s] Tower %s (Owner: %s) attacked Troop %s (ID: %s, Owner: %s) for %d damage. HP %d -> %d",
-								gs.ID, tower.GameSpecificID, tower.OwnerID, targetTroop.SpecID, targetTroop.InstanceID, targetTroop.OwnerID, damage, originalHP, targetTroop.CurrentHP)
-							eventData := map[string]interface{}{
-								"attacker_id": tower.GameSpecificID, "attacker_spec": tower.SpecID, "defender_id": targetTroop.InstanceID, "defender_spec": targetTroop.SpecID, "damage": damage, "new_hp": targetTroop.CurrentHP,
-							}
-							if damage > tower.CurrentATK-targetTroop.CurrentDEF { // Indicates a CRIT occurred
-								gs.sendGameEventToAllPlayers(network.GameEventCritHit, eventData)
-							} else {
-								gs.sendGameEventToAllPlayers(network.GameEventTroopDamaged, eventData)
-							}
-
-							if targetTroop.CurrentHP == 0 {
-								log.Printf("[GameSession %s] Troop %s (ID: %s, Owner: %s) DEFEATED by Tower %s (Owner: %s)!",
-									gs.ID, targetTroop.SpecID, targetTroop.InstanceID, targetTroop.OwnerID, tower.GameSpecificID, tower.OwnerID)
-								gs.sendGameEventToAllPlayers(network.GameEventTroopDefeated, map[string]interface{}{
-									"troop_id": targetTroop.InstanceID, "troop_spec": targetTroop.SpecID, "owner_id": targetTroop.OwnerID, "defeated_by_tower_id": tower.GameSpecificID,
-								})
-								// Remove defeated troop from activeTroops
-								delete(gs.activeTroops, targetTroop.InstanceID)
-								// Also remove from player's DeployedTroops map
-								if troopOwner := gs.getPlayerByUsername(targetTroop.OwnerID); troopOwner != nil {
-									delete(troopOwner.DeployedTroops, targetTroop.InstanceID)
-								}
-							}
+					if hit.Destroyed {
+						gs.logf("[GameSession %s] Troop %s (ID: %s, Owner: %s) DEFEATED by Tower %s (Owner: %s)!",
+							gs.ID, targetTroop.SpecID, targetTroop.InstanceID, targetTroop.OwnerID, tower.GameSpecificID, tower.OwnerID)
+						gs.sendGameEventToAllPlayers(network.GameEventTroopDefeated, network.TroopDefeatedEvent{
+							TroopID: targetTroop.InstanceID, TroopSpec: targetTroop.SpecID, OwnerID: targetTroop.OwnerID, DefeatedByTowerID: tower.GameSpecificID, DefeatedByTowerSpec: tower.SpecID,
+						})
+						// Remove defeated troop from activeTroops
+						delete(gs.activeTroops, targetTroop.InstanceID)
+						// Also remove from player's DeployedTroops map
+						if troopOwner := gs.getPlayerByUsername(targetTroop.OwnerID); troopOwner != nil {
+							delete(troopOwner.DeployedTroops, targetTroop.InstanceID)
 						}
 					}
-					gs.lastTowerAttack[tower.GameSpecificID] = currentTime
 				}
 			}
-			// --- End Continuous Attack Logic ---
+		}
+	}
+	// --- End Continuous Attack Logic ---
 
-			// Send game state update
-			timeRemaining := gs.gameEndTime.Sub(time.Now()).Seconds()
+	if !now.Before(gs.gameEndTime) {
+		gs.logf("[GameSession %s] Timer ended.", gs.ID)
+		gs.determineWinnerAndStop("timeout")
+		return true
+	}
 
-			// Collect all active troops for the game state update
-			activeTroopsForState := make(map[string]models.ActiveTroop)
-			for id, troop := range gs.activeTroops { // Use the centralized gs.activeTroops
-				activeTroopsForState[id] = *troop
-			}
+	// Send game state update
+	gs.broadcastGameState(now, false)
 
-			// Collect all tower instances for the game state update
-			towersForState := make([]models.TowerInstance, 0, len(gs.towers))
-			for _, tower := range gs.towers { // Use the centralized gs.towers
-				towersForState = append(towersForState, *tower)
-			}
+	gs.finishTick(now)
+	return false
+}
 
-			gameStateUpdatePayload := network.GameStateUpdateUDP{
-				GameTimeRemainingSeconds: int(timeRemaining),
-				Player1Mana:              gs.Player1.CurrentMana,
-				Player2Mana:              gs.Player2.CurrentMana,
-				Towers:                   towersForState,       // Use updated list
-				ActiveTroops:             activeTroopsForState, // Use updated map
-			}
+// attacksDue returns how many attacks a troop or tower that last attacked at *last is owed
+// by now, and moves *last forward by that many attackIntervals so the next one stays in
+// phase. At most maxCatchUpAttacks are owed at once; a unit further behind than that skips
+// the excess rather than unloading a burst of hits after a very long stall.
+func attacksDue(last *time.Time, now time.Time) int {
+	due := 0
+	for due < maxCatchUpAttacks && now.Sub(*last) >= attackInterval {
+		*last = last.Add(attackInterval)
+		due++
+	}
+	if behind := now.Sub(*last); behind >= attackInterval {
+		*last = now.Add(-(behind % attackInterval))
+	}
+	return due
+}
 
-			seq := uint32(time.Now().UnixNano())
+// expireTroops removes the troops whose lifetime has run out by now. They were not defeated,
+// so no tower is credited with them. The caller must hold gs.mu.
+func (gs *GameSession) expireTroops(now time.Time) {
+	for id, troop := range gs.activeTroops {
+		if troop.ExpiresAt.IsZero() || now.Before(troop.ExpiresAt) {
+			continue
+		}
+		gs.logf("[GameSession %s] Troop %s (ID: %s, Owner: %s) expired.", gs.ID, troop.SpecID, id, troop.OwnerID)
+		gs.sendGameEventToAllPlayers(network.GameEventTroopExpired, network.TroopExpiredEvent{
+			TroopID: id, TroopSpec: troop.SpecID, OwnerID: troop.OwnerID,
+		})
+		delete(gs.activeTroops, id)
+		delete(gs.lastTroopAttack, id)
+		if troopOwner := gs.getPlayerByUsername(troop.OwnerID); troopOwner != nil {
+			delete(troopOwner.DeployedTroops, id)
+		}
+	}
+}
 
-			playerTokens := []string{gs.Player1.SessionToken, gs.Player2.SessionToken}
+// tickInterval returns how often the game loop ticks.
+func (gs *GameSession) tickInterval() time.Duration {
+	if gs.Rules.TickInterval <= 0 {
+		return models.DefaultGameRules().TickInterval
+	}
+	return gs.Rules.TickInterval
+}
 
-			for _, token := range playerTokens {
-				if addr, ok := gs.playerClientAddresses[token]; ok {
-					msgForPlayer := network.UDPMessage{
-						Seq:         seq,
-						Timestamp:   time.Now(),
-						SessionID:   gs.ID,
-						PlayerToken: token,
-						Type:        network.UDPMsgTypeGameStateUpdate,
-						Payload:     gameStateUpdatePayload,
-					}
-					gs.sendUDPMessageToAddress(msgForPlayer, addr)
-				} else {
-					log.Printf("[GameSession %s] No UDP address found for player token %s during game state broadcast.", gs.ID, token)
-				}
-			}
+// finishTick records a completed tick that fired at now. The caller must hold gs.mu.
+func (gs *GameSession) finishTick(now time.Time) {
+	gs.perf.recordTick(time.Since(now), len(gs.activeTroops))
+	gs.lastTickAt.Store(now.UnixNano())
+}
 
-			gs.sendGameStateToAllPlayers()
-			gs.mu.Unlock()
+// LastTickAt returns when the game loop last completed a tick. It never blocks on gs.mu.
+func (gs *GameSession) LastTickAt() time.Time {
+	return time.Unix(0, gs.lastTickAt.Load())
+}
 
-		case action := <-gs.playerActions:
-			gs.mu.Lock()
-			if !gs.isGameOver { // Process actions only if game is not over
-				gs.handlePlayerAction(action)
+// IsOver reports whether the game has concluded. Like LastTickAt it never blocks on gs.mu.
+func (gs *GameSession) IsOver() bool {
+	return gs.State().Finished()
+}
+
+// processPlayerAction locks the session and handles one queued action if the game is still running.
+func (gs *GameSession) processPlayerAction(action network.UDPMessage) {
+	defer gs.settleEnding() // Once gs.mu is released, if the action ended the game
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if !gs.State().Finished() { // Process actions only if game is not over
+		gs.handlePlayerAction(action)
+	}
+	// After handling action, check if game ended due to it (e.g., Queen heal on a King Tower might be a win if it was the last action)
+	// This might be redundant if handlePlayerAction itself can trigger a game end check.
+	// However, for now, we rely on the main loop's tower destruction checks.
+}
+
+// actionQueueFor returns the action queue of the player owning the token, or nil for unknown
+// tokens, including ones replaced by RotatePlayerToken. The caller must hold gs.mu.
+func (gs *GameSession) actionQueueFor(playerToken string) chan network.UDPMessage {
+	switch playerToken {
+	case gs.Player1.SessionToken:
+		return gs.player1Actions
+	case gs.Player2.SessionToken:
+		return gs.player2Actions
+	}
+	return nil
+}
+
+// buildStateUpdate builds a GameStateUpdateUDP from the current session state. final marks
+// the last update of the game. The caller must hold gs.mu.
+func (gs *GameSession) buildStateUpdate(now time.Time, final bool) network.GameStateUpdateUDP {
+	timeRemaining := gs.gameEndTime.Sub(now)
+	if !gs.combatStarted {
+		timeRemaining = gs.Rules.GameDuration
+	}
+	return stateSource{
+		player1:          gs.Player1,
+		player2:          gs.Player2,
+		towers:           gs.towers,
+		troops:           gs.activeTroops,
+		lastProcessedSeq: gs.watermarks(),
+		timeRemaining:    timeRemaining,
+		warmup:           !gs.combatStarted,
+		startsIn:         gs.countdownSecondsLeft(now),
+		spectators:       gs.spectators.Count(),
+	}.update(now, final)
+}
+
+// stateSource is what a GameStateUpdateUDP is built from: the live session, read under
+// gs.mu, or a SessionSnapshot. Both go through update, so a full state built from a
+// snapshot says exactly what a broadcast at the same instant would.
+type stateSource struct {
+	player1, player2 *models.PlayerInGame // Only the username and mana are read
+	towers           []*models.TowerInstance
+	troops           map[string]*models.ActiveTroop
+	lastProcessedSeq map[string]uint32 // PlayerToken -> command watermark
+	timeRemaining    time.Duration
+	warmup           bool
+	startsIn         int
+	spectators       int
+}
+
+// update builds the state update as of now. final marks the last update of the game.
+func (src stateSource) update(now time.Time, final bool) network.GameStateUpdateUDP {
+	timeRemaining := src.timeRemaining.Seconds()
+	if timeRemaining < 0 {
+		timeRemaining = 0
+	}
+
+	// Collect all active troops for the game state update
+	activeTroopsForState := make(map[string]network.TroopState, len(src.troops))
+	for id, troop := range src.troops {
+		state := network.NewTroopState(troop)
+		if now.Before(troop.ReadyAt) {
+			state.Spawning = true
+			state.SpawnsInMs = int(troop.ReadyAt.Sub(now) / time.Millisecond)
+		}
+		if !troop.ExpiresAt.IsZero() && now.Before(troop.ExpiresAt) {
+			state.ExpiresInMs = int(troop.ExpiresAt.Sub(now) / time.Millisecond)
+		}
+		activeTroopsForState[id] = state
+	}
+
+	// Collect all tower instances for the game state update
+	towersForState := make([]network.TowerState, 0, len(src.towers))
+	for _, tower := range src.towers {
+		towersForState = append(towersForState, network.NewTowerState(tower))
+	}
+
+	// Towers destroyed so far by each player, the same count the timeout tiebreaker uses
+	player1, player2 := src.player1.Account.Username, src.player2.Account.Username
+	towersDestroyed := map[string]int{player1: 0, player2: 0}
+	for _, tower := range src.towers {
+		if !tower.IsDestroyed {
+			continue
+		}
+		if tower.OwnerID == player1 {
+			towersDestroyed[player2]++
+		} else if tower.OwnerID == player2 {
+			towersDestroyed[player1]++
+		}
+	}
+
+	// Echo each player's command watermark so clients can settle commands whose ACK was lost
+	lastProcessed := make(map[string]uint32, len(src.lastProcessedSeq))
+	for token, seq := range src.lastProcessedSeq {
+		lastProcessed[token] = seq
+	}
+
+	return network.GameStateUpdateUDP{
+		GameTimeRemainingSeconds: int(timeRemaining),
+		Player1Mana:              src.player1.CurrentMana,
+		Player2Mana:              src.player2.CurrentMana,
+		PlayerMana: map[string]int{
+			player1: src.player1.CurrentMana,
+			player2: src.player2.CurrentMana,
+		},
+		Towers:                 towersForState,
+		ActiveTroops:           activeTroopsForState,
+		PlayerScores:           towersDestroyed,
+		LastProcessedClientSeq: lastProcessed,
+		Final:                  final,
+		Warmup:                 src.warmup,
+		StartsIn:               src.startsIn,
+		SpectatorCount:         src.spectators,
+	}
+}
+
+// broadcastGameState sends a state update (see buildStateUpdate) to every player whose UDP
+// address is known. final marks the last update of the game, sent by
+// determineWinnerAndStop. The caller must hold gs.mu.
+//
+// A full update only goes out when something besides the clock has changed (mana, combat,
+// deploys, ...), or when stateKeepaliveInterval has passed since the last one. If only the
+// clock has ticked over, players get a GameTimerUpdateUDP instead, and nothing at all if
+// not even that changed.
+func (gs *GameSession) broadcastGameState(now time.Time, final bool) {
+	gameStateUpdatePayload := gs.buildStateUpdate(now, final)
+	gs.stateUpdatePending = false
+
+	clock := network.GameTimerUpdateUDP{GameTimeRemainingSeconds: gameStateUpdatePayload.GameTimeRemainingSeconds, StartsIn: gameStateUpdatePayload.StartsIn}
+	digest := stateDigest(gameStateUpdatePayload)
+	clockOnly := !final && digest == gs.lastStateDigest && now.Sub(gs.lastStateBroadcast) < stateKeepaliveInterval
+	if clockOnly && clock == gs.lastClock {
+		return // Nothing to tell the players
+	}
+	gs.lastClock = clock
+
+	var parts []network.GameStateUpdateUDP // Built once, when the first player needs them
+	playerTokens := []string{gs.Player1.SessionToken, gs.Player2.SessionToken}
+
+	for _, token := range playerTokens {
+		addr, ok := gs.playerClientAddresses[token]
+		if !ok {
+			gs.logf("[GameSession %s] No UDP address found for player token %s during game state broadcast.", gs.ID, token)
+			continue
+		}
+		if clockOnly && gs.playerProtocols[token] >= network.ProtocolVersionTimerUpdates {
+			gs.sendUDPMessageToAddress(network.UDPMessage{
+				Timestamp:   now,
+				SessionID:   gs.ID,
+				PlayerToken: token,
+				Type:        network.UDPMsgTypeGameTimer,
+				Payload:     clock,
+			}, addr)
+			continue
+		}
+		if parts == nil {
+			gs.stateUpdateID++
+			parts = gs.splitStateUpdate(gameStateUpdatePayload, gs.stateUpdateID)
+		}
+		for _, part := range parts {
+			msgForPlayer := network.UDPMessage{
+				Timestamp:   now,
+				SessionID:   gs.ID,
+				PlayerToken: token,
+				Type:        network.UDPMsgTypeGameStateUpdate,
+				Payload:     part,
 			}
-			// After handling action, check if game ended due to it (e.g., Queen heal on a King Tower might be a win if it was the last action)
-			// This might be redundant if handlePlayerAction itself can trigger a game end check.
-			// However, for now, we rely on the main loop's tower destruction checks.
-			gs.mu.Unlock()
+			gs.sendUDPMessageToAddress(msgForPlayer, addr)
+		}
+	}
 
-		case <-time.After(5 * time.Second): // Timeout for player actions if channel is empty
-			// This case helps prevent the select from blocking indefinitely if no actions or ticks occur.
-			// Potentially log this if it happens too often, might indicate an issue.
+	if !clockOnly {
+		gs.lastStateBroadcast = now
+		gs.lastStateDigest = digest
+	}
+}
+
+// sendFullState answers a UDPMsgTypeRequestFullState: the requesting player gets a full
+// state update marked as a snapshot right away, whatever was last broadcast. It is built
+// from a SessionSnapshot, like the state a rejoining player is sent. A player gets
+// at most one per network.FullStateRequestInterval; earlier requests are ignored. The
+// caller must hold gs.mu.
+func (gs *GameSession) sendFullState(playerToken string, now time.Time) {
+	addr, ok := gs.playerClientAddresses[playerToken]
+	if !ok {
+		return
+	}
+	if last, requested := gs.lastFullState[playerToken]; requested && now.Sub(last) < network.FullStateRequestInterval {
+		gs.logf("[GameSession %s] Ignoring full state request from %s; the last one was answered %v ago.", gs.ID, playerToken, now.Sub(last).Round(time.Millisecond))
+		return
+	}
+	gs.lastFullState[playerToken] = now
+
+	snapshot := gs.snapshotLocked(now).StateUpdate()
+	gs.stateUpdateID++
+	for _, part := range gs.splitStateUpdate(snapshot, gs.stateUpdateID) {
+		gs.sendUDPMessageToAddress(network.UDPMessage{
+			Timestamp:   now,
+			SessionID:   gs.ID,
+			PlayerToken: playerToken,
+			Type:        network.UDPMsgTypeGameStateUpdate,
+			Payload:     part,
+		}, addr)
+	}
+	gs.logf("[GameSession %s] Sent a full state snapshot to %s on request.", gs.ID, playerToken)
+}
+
+// stateDigest returns a state update serialized without its clock fields, so that two
+// updates differing only in the time left compare equal.
+func stateDigest(update network.GameStateUpdateUDP) string {
+	update.GameTimeRemainingSeconds, update.StartsIn = 0, 0
+	data, _ := json.Marshal(update)
+	return string(data)
+}
+
+// splitStateUpdate returns update unchanged if it serializes within
+// network.MaxStateUpdatePayloadSize. Otherwise the troops are spread over several parts:
+// the first keeps the header fields and towers, the rest carry only troops.
+func (gs *GameSession) splitStateUpdate(update network.GameStateUpdateUDP, updateID uint32) []network.GameStateUpdateUDP {
+	full, err := json.Marshal(update)
+	if err != nil || len(full) <= network.MaxStateUpdatePayloadSize {
+		return []network.GameStateUpdateUDP{update}
+	}
+
+	// Sort troop IDs so parts are filled deterministically
+	troopIDs := make([]string, 0, len(update.ActiveTroops))
+	for id := range update.ActiveTroops {
+		troopIDs = append(troopIDs, id)
+	}
+	sort.Strings(troopIDs)
+
+	// Measure the fixed part of each datagram with worst-case part numbers filled in
+	maxParts := len(troopIDs) + 1
+	header := update
+	header.ActiveTroops = make(map[string]network.TroopState)
+	header.UpdateID, header.Part, header.Parts = updateID, maxParts, maxParts
+	headerBytes, _ := json.Marshal(header)
+	troopsOnlyBytes, _ := json.Marshal(network.GameStateUpdateUDP{ActiveTroops: map[string]network.TroopState{}, UpdateID: updateID, Part: maxParts, Parts: maxParts})
+
+	parts := []network.GameStateUpdateUDP{header}
+	size := len(headerBytes)
+	for _, id := range troopIDs {
+		troopBytes, _ := json.Marshal(update.ActiveTroops[id])
+		entrySize := len(id) + len(troopBytes) + 4 // quotes, colon and comma
+		current := &parts[len(parts)-1]
+		if len(current.ActiveTroops) > 0 && size+entrySize > network.MaxStateUpdatePayloadSize {
+			parts = append(parts, network.GameStateUpdateUDP{ActiveTroops: make(map[string]network.TroopState)})
+			current = &parts[len(parts)-1]
+			size = len(troopsOnlyBytes)
 		}
+		current.ActiveTroops[id] = update.ActiveTroops[id]
+		size += entrySize
 	}
+
+	for i := range parts {
+		parts[i].UpdateID = updateID
+		parts[i].Part = i + 1
+		parts[i].Parts = len(parts)
+	}
+	gs.logf("[GameSession %s] State update %d is %d bytes; sending it in %d parts.", gs.ID, updateID, len(full), len(parts))
+	return parts
+}
+
+// requestImmediateStateUpdate pushes a state update right after an action changed the game,
+// instead of leaving it for the next tick. Requests within minStateUpdateGap of the last
+// broadcast are coalesced into a single deferred send. The caller must hold gs.mu.
+func (gs *GameSession) requestImmediateStateUpdate() {
+	now := time.Now()
+	sinceLast := now.Sub(gs.lastStateBroadcast)
+	if sinceLast >= minStateUpdateGap {
+		gs.broadcastGameState(now, false)
+		return
+	}
+	if gs.stateUpdatePending {
+		return // A deferred send is already scheduled and will include this change
+	}
+	gs.stateUpdatePending = true
+	time.AfterFunc(minStateUpdateGap-sinceLast, func() {
+		gs.mu.Lock()
+		defer gs.mu.Unlock()
+		if gs.stateUpdatePending && !gs.State().Finished() {
+			gs.broadcastGameState(time.Now(), false)
+		}
+	})
+}
+
+const (
+	// attackInterval is how often each troop and tower attacks (1 per 2 seconds, as per plan).
+	attackInterval = 2 * time.Second
+	// maxCatchUpAttacks caps the attacks one unit makes in a single tick after a stall.
+	maxCatchUpAttacks = 3
+)
+
+// deployDedupeRetention is how long a processed deploy's Seq is remembered. It must outlast
+// network.CommandResendHorizon, the longest a client keeps resending one command, or a late
+// copy could arrive after its record is gone; the slack covers delay in the network and in
+// the player's action queue.
+const deployDedupeRetention = network.CommandResendHorizon + 5*time.Second
+
+// pruneProcessedCommands forgets deploys processed more than deployDedupeRetention ago.
+// Each pruned Seq raises the player's pruned watermark, so a copy arriving later still
+// counts as a duplicate and is never applied (and charged mana) a second time. It also
+// closes the gaps in the echoed watermarks that have been open as long.
+func (gs *GameSession) pruneProcessedCommands(now time.Time) {
+	// gs.mu is already locked by the caller (the game loop)
+	for _, watermark := range gs.commandWatermarks {
+		watermark.advance(now)
+	}
+	for token, processed := range gs.processedDeployCommands {
+		for seq, processedAt := range processed {
+			if now.Sub(processedAt) <= deployDedupeRetention {
+				continue
+			}
+			delete(processed, seq)
+			if seq > gs.prunedDeploySeq[token] {
+				gs.prunedDeploySeq[token] = seq
+			}
+		}
+	}
+}
+
+// watermarks returns each player's echoed command watermark (see commandWatermark). The
+// caller must hold gs.mu.
+func (gs *GameSession) watermarks() map[string]uint32 {
+	settled := make(map[string]uint32, len(gs.commandWatermarks))
+	for token, watermark := range gs.commandWatermarks {
+		settled[token] = watermark.settled
+	}
+	return settled
 }
 
 // handlePlayerAction processes a UDP message received from a player.
 func (gs *GameSession) handlePlayerAction(msg network.UDPMessage) {
 	// gs.mu is already locked by the caller (the game loop)
-	log.Printf("[GameSession %s] Handling action: Type=%s, PlayerToken=%s, SessionID=%s", gs.ID, msg.Type, msg.PlayerToken, msg.SessionID)
+	gs.logf("[GameSession %s] Handling action: Type=%s, PlayerToken=%s, SessionID=%s", gs.ID, msg.Type, msg.PlayerToken, msg.SessionID)
 
 	if msg.SessionID != gs.ID {
-		log.Printf("[GameSession %s] Discarding message from token %s with incorrect SessionID %s (expected %s)", gs.ID, msg.PlayerToken, msg.SessionID, gs.ID)
+		gs.logf("[GameSession %s] Discarding message from token %s with incorrect SessionID %s (expected %s)", gs.ID, msg.PlayerToken, msg.SessionID, gs.ID)
+		return
+	}
+	if gs.actionQueueFor(msg.PlayerToken) == nil { // Queued before its player reconnected with a new token
+		gs.logf("[GameSession %s] Discarding %s message from token %s, which is no longer valid.", gs.ID, msg.Type, msg.PlayerToken)
 		return
 	}
 
+	if gs.checkCommandPlausibility(msg, time.Now()) {
+		gs.logf("[GameSession %s] Rejected %s command (Seq %d) from token %s as implausible.", gs.ID, msg.Type, msg.Seq, msg.PlayerToken)
+		return
+	}
+
+	// Every command handled below is settled by the time the next state update goes out,
+	// whether it was applied or rejected
+	if msg.Seq > gs.lastProcessedSeq[msg.PlayerToken] {
+		gs.lastProcessedSeq[msg.PlayerToken] = msg.Seq
+	}
+	watermark := gs.commandWatermarks[msg.PlayerToken]
+	if watermark == nil {
+		watermark = newCommandWatermark()
+		gs.commandWatermarks[msg.PlayerToken] = watermark
+	}
+	watermark.handled(msg.Seq, time.Now())
+
 	switch msg.Type {
 	case network.UDPMsgTypePlayerQuit:
 		if msg.PlayerToken == gs.Player1.SessionToken {
 			gs.player1Quit = true
-			log.Printf("Player %s (Token: %s) has quit session %s.", gs.Player1.Account.Username, gs.Player1.SessionToken, gs.ID)
+			gs.logf("Player %s (Token: %s) has quit session %s.", gs.Player1.Account.Username, gs.Player1.SessionToken, gs.ID)
 		} else if msg.PlayerToken == gs.Player2.SessionToken {
 			gs.player2Quit = true
-			log.Printf("Player %s (Token: %s) has quit session %s.", gs.Player2.Account.Username, gs.Player2.SessionToken, gs.ID)
+			gs.logf("Player %s (Token: %s) has quit session %s.", gs.Player2.Account.Username, gs.Player2.SessionToken, gs.ID)
 		} else {
-			log.Printf("[GameSession %s] Received quit message from unknown or mismatched token: %s", gs.ID, msg.PlayerToken)
+			gs.logf("[GameSession %s] Received quit message from unknown or mismatched token: %s", gs.ID, msg.PlayerToken)
 		}
 
+	case network.UDPMsgTypeRequestFullState:
+		gs.sendFullState(msg.PlayerToken, time.Now())
+
+	case network.UDPMsgTypeSurrender:
+		// Surrender ends the game at once; the opponent wins. Actions are no longer handled
+		// once the game is over, so a resent copy cannot surrender twice.
+		var player *models.PlayerInGame
+		if msg.PlayerToken == gs.Player1.SessionToken {
+			player = gs.Player1
+		} else if msg.PlayerToken == gs.Player2.SessionToken {
+			player = gs.Player2
+		} else {
+			gs.logf("[GameSession %s] Received surrender from unknown or mismatched token: %s", gs.ID, msg.PlayerToken)
+			return
+		}
+		gs.logf("[GameSession %s] Player %s surrendered.", gs.ID, player.Account.Username)
+		gs.surrenderedBy = player
+		gs.determineWinnerAndStop("surrender")
+
 	case network.UDPMsgTypeDeployTroop:
-		// Check if this command sequence from this player has already been processed.
-		if _, processed := gs.processedDeployCommands[msg.PlayerToken][msg.Seq]; processed {
-			log.Printf("[GameSession %s] Player %s: Duplicate DeployTroop command (Seq: %d) received. Ignoring and resending ACK.", gs.ID, msg.PlayerToken, msg.Seq)
+		// Check if this command sequence from this player has already been processed. A Seq at
+		// or below the pruned watermark is older than any copy the client can still be sending
+		// (see pruneProcessedCommands), so it is a late duplicate even with its record gone.
+		_, processed := gs.processedDeployCommands[msg.PlayerToken][msg.Seq]
+		if processed || msg.Seq <= gs.prunedDeploySeq[msg.PlayerToken] {
+			gs.logf("[GameSession %s] Player %s: Duplicate DeployTroop command (Seq: %d) received. Ignoring and resending ACK.", gs.ID, msg.PlayerToken, msg.Seq)
 			// Resend ACK just in case the first one was lost
 			ackPayload := network.CommandAckUDP{AckSeq: msg.Seq}
 			clientAddr, addrOk := gs.playerClientAddresses[msg.PlayerToken]
@@ -376,44 +1082,15 @@ func (gs *GameSession) handlePlayerAction(msg network.UDPMessage) {
 					Type:        network.UDPMsgTypeCommandAck,
 					SessionID:   gs.ID,           // Important for client to validate
 					PlayerToken: msg.PlayerToken, // Echo back player token
-					Seq:         0,               // ACKs themselves don't need sequence numbers for this simple ACK system
 					Timestamp:   time.Now(),
 					Payload:     ackPayload,
 				}, clientAddr)
 			} else {
-				log.Printf("[GameSession %s] Player %s: Could not resend ACK for Seq %d, client address unknown.", gs.ID, msg.PlayerToken, msg.Seq)
+				gs.logf("[GameSession %s] Player %s: Could not resend ACK for Seq %d, client address unknown.", gs.ID, msg.PlayerToken, msg.Seq)
 			}
 			return
 		}
 
-		var deployPayload network.DeployTroopCommandUDP
-		payloadMap, ok := msg.Payload.(map[string]interface{})
-		if !ok {
-			// Try to unmarshal directly if not a map (e.g., if client sends the struct directly)
-			payloadBytes, err := json.Marshal(msg.Payload)
-			if err != nil {
-				log.Printf("[GameSession %s] Error marshalling payload to bytes for DeployTroop: %v", gs.ID, err)
-				return
-			}
-			if err := json.Unmarshal(payloadBytes, &deployPayload); err != nil {
-				log.Printf("[GameSession %s] Error unmarshalling DeployTroopCommandUDP from payload bytes: %v", gs.ID, err)
-				log.Printf("[GameSession %s] Received payload: %s", gs.ID, string(payloadBytes))
-				return
-			}
-		} else { // Original logic for map[string]interface{}
-			troopIDInterface, idOk := payloadMap["troop_id"]
-			if !idOk {
-				log.Printf("[GameSession %s] 'troop_id' not found in DeployTroop payload: %+v", gs.ID, payloadMap)
-				return
-			}
-			troopID, troopIDStrOk := troopIDInterface.(string)
-			if !troopIDStrOk {
-				log.Printf("[GameSession %s] 'troop_id' is not a string in DeployTroop payload: %+v", gs.ID, payloadMap)
-				return
-			}
-			deployPayload.TroopID = troopID
-		}
-
 		// Determine which player is deploying
 		var deployingPlayer *models.PlayerInGame
 		var opponentPlayer *models.PlayerInGame // For context if needed later
@@ -425,56 +1102,95 @@ func (gs *GameSession) handlePlayerAction(msg network.UDPMessage) {
 			deployingPlayer = gs.Player2
 			opponentPlayer = gs.Player1
 		} else {
-			log.Printf("[GameSession %s] DeployTroop command from unknown or mismatched token: %s", gs.ID, msg.PlayerToken)
+			gs.logf("[GameSession %s] DeployTroop command from unknown or mismatched token: %s", gs.ID, msg.PlayerToken)
 			return
 		}
 
 		// Log a more specific message if player object is nil
 		if deployingPlayer == nil {
-			log.Printf("[GameSession %s] Deploying player could not be determined for token: %s", gs.ID, msg.PlayerToken)
+			gs.logf("[GameSession %s] Deploying player could not be determined for token: %s", gs.ID, msg.PlayerToken)
 			return
 		}
 		if opponentPlayer == nil { // Should not happen if deployingPlayer is set
-			log.Printf("[GameSession %s] Opponent player could not be determined for deploying player with token: %s", gs.ID, msg.PlayerToken)
+			gs.logf("[GameSession %s] Opponent player could not be determined for deploying player with token: %s", gs.ID, msg.PlayerToken)
 			// Potentially return or handle as a single player context if that's ever supported
 		}
 
-		// Get TroopSpec from config
+		switch gs.State() {
+		case StateCreated, StateWaitingForPlayers, StateCountdown:
+			gs.logf("[GameSession %s] Player %s tried to deploy during warm-up (Seq %d).", gs.ID, deployingPlayer.Account.Username, msg.Seq)
+			gs.rejectDeployWithCode(deployingPlayer, msg.Seq, network.GameErrorWarmup, "The battle has not started yet.")
+			return
+		case StatePaused:
+			gs.logf("[GameSession %s] Player %s tried to deploy while the game is paused (Seq %d).", gs.ID, deployingPlayer.Account.Username, msg.Seq)
+			gs.rejectDeployWithCode(deployingPlayer, msg.Seq, network.GameErrorPaused, "The game is paused until your opponent reconnects.")
+			return
+		}
+
+		rawPayload, _ := msg.Payload.(json.RawMessage) // Set by the UDP reader
+		deployPayload, err := decodeDeployPayload(rawPayload)
+		if err != nil {
+			gs.logf("[GameSession %s] Malformed DeployTroop command (Seq %d) from %s: %v", gs.ID, msg.Seq, deployingPlayer.Account.Username, err)
+			gs.rejectDeploy(deployingPlayer, msg.Seq, "Malformed deploy command.")
+			return
+		}
+
+		// Check the troop exists before anything else looks at the command
 		troopSpec, ok := gs.Config.Troops[deployPayload.TroopID]
 		if !ok {
-			log.Printf("[GameSession %s] Player %s tried to deploy unknown troop type: %s", gs.ID, deployingPlayer.Account.Username, deployPayload.TroopID)
-			gs.sendGameEventToPlayer(deployingPlayer.SessionToken, network.GameEventError, map[string]interface{}{"message": "Unknown troop type: " + deployPayload.TroopID})
+			gs.logf("[GameSession %s] Player %s tried to deploy unknown troop type: %q", gs.ID, deployingPlayer.Account.Username, deployPayload.TroopID)
+			gs.rejectDeploy(deployingPlayer, msg.Seq, "Unknown troop type.")
 			return
 		}
 
+		// In two-lane mode a troop goes into the lane asked for; older clients and bots send
+		// none and get the left lane. Classic games ignore the lane.
+		lane := ""
+		if gs.Rules.TwoLanes {
+			lane = deployPayload.Lane
+			if lane == "" {
+				lane = models.LaneLeft
+			}
+			if !models.ValidLane(lane) {
+				gs.logf("[GameSession %s] Player %s tried to deploy %s into unknown lane %q", gs.ID, deployingPlayer.Account.Username, troopSpec.Name, deployPayload.Lane)
+				gs.rejectDeploy(deployingPlayer, msg.Seq, "Unknown lane.")
+				return
+			}
+		}
+
 		// Check Mana Cost
 		if deployingPlayer.CurrentMana < troopSpec.ManaCost {
-			log.Printf("[GameSession %s] Player %s not enough mana to deploy %s (Cost: %d, Has: %d)", gs.ID, deployingPlayer.Account.Username, troopSpec.Name, troopSpec.ManaCost, deployingPlayer.CurrentMana)
-			gs.sendGameEventToPlayer(deployingPlayer.SessionToken, network.GameEventError, map[string]interface{}{"message": fmt.Sprintf("Not enough mana for %s. Need %d, have %d", troopSpec.Name, troopSpec.ManaCost, deployingPlayer.CurrentMana)})
+			gs.logf("[GameSession %s] Player %s not enough mana to deploy %s (Cost: %d, Has: %d)", gs.ID, deployingPlayer.Account.Username, troopSpec.Name, troopSpec.ManaCost, deployingPlayer.CurrentMana)
+			gs.rejectDeploy(deployingPlayer, msg.Seq, fmt.Sprintf("Not enough mana for %s. Need %d, have %d", troopSpec.Name, troopSpec.ManaCost, deployingPlayer.CurrentMana))
 			return
 		}
 
 		// Deduct Mana
 		deployingPlayer.CurrentMana -= troopSpec.ManaCost
+		deployingPlayer.Stats.AddDeploy(troopSpec.ID, 1)
 
 		// Handle Queen's special ability
 		if strings.ToLower(troopSpec.ID) == "queen" {
 			healAmount := 300 // As per plan
 			healMsg, healedTower, actualHeal, err := game.ApplyQueenHeal(deployingPlayer.Account.Username, gs.toModelGameSession(), healAmount)
 			if err != nil {
-				log.Printf("[GameSession %s] Error applying Queen heal for %s: %v", gs.ID, deployingPlayer.Account.Username, err)
-				gs.sendGameEventToPlayer(deployingPlayer.SessionToken, network.GameEventError, map[string]interface{}{"message": "Queen heal failed."})
+				gs.logf("[GameSession %s] Error applying Queen heal for %s: %v", gs.ID, deployingPlayer.Account.Username, err)
+				// Nothing was deployed, so refund the mana before rejecting
+				deployingPlayer.CurrentMana += troopSpec.ManaCost
+				deployingPlayer.Stats.AddDeploy(troopSpec.ID, -1)
+				gs.rejectDeploy(deployingPlayer, msg.Seq, "Queen heal failed.")
 			} else {
-				log.Printf("[GameSession %s] %s", gs.ID, healMsg)
-				eventDetails := map[string]interface{}{
-					"player_id": deployingPlayer.Account.Username,
-					"message":   healMsg,
+				gs.logf("[GameSession %s] %s", gs.ID, healMsg)
+				deployingPlayer.Stats.Heals += actualHeal
+				eventDetails := network.QueenHealEvent{
+					PlayerID: deployingPlayer.Account.Username,
+					Message:  healMsg,
 				}
 				if healedTower != nil {
-					eventDetails["tower_id"] = healedTower.GameSpecificID
-					eventDetails["tower_spec"] = healedTower.SpecID
-					eventDetails["healed_amount"] = actualHeal
-					eventDetails["new_hp"] = healedTower.CurrentHP
+					eventDetails.TowerID = healedTower.GameSpecificID
+					eventDetails.TowerSpec = healedTower.SpecID
+					eventDetails.HealedAmount = actualHeal
+					eventDetails.NewHP = healedTower.CurrentHP
 				}
 				gs.sendGameEventToAllPlayers(network.GameEventQueenHeal, eventDetails)
 
@@ -487,52 +1203,57 @@ func (gs *GameSession) handlePlayerAction(msg network.UDPMessage) {
 						Type:        network.UDPMsgTypeCommandAck,
 						SessionID:   gs.ID,
 						PlayerToken: msg.PlayerToken,
-						Seq:         0, // ACK specific seq
 						Timestamp:   time.Now(),
 						Payload:     ackPayload,
 					}, clientAddr)
-					log.Printf("[GameSession %s] Player %s: Sent ACK for Queen Deploy (Seq: %d)", gs.ID, msg.PlayerToken, msg.Seq)
+					gs.logf("[GameSession %s] Player %s: Sent ACK for Queen Deploy (Seq: %d)", gs.ID, msg.PlayerToken, msg.Seq)
 				} else {
-					log.Printf("[GameSession %s] Player %s: Could not send ACK for Queen Deploy (Seq: %d), client address unknown.", gs.ID, msg.PlayerToken, msg.Seq)
+					gs.logf("[GameSession %s] Player %s: Could not send ACK for Queen Deploy (Seq: %d), client address unknown.", gs.ID, msg.PlayerToken, msg.Seq)
 				}
 			}
 			// Queen does not persist on board, so we don't add to ActiveTroops
 		} else {
 			// Create and add the new troop
-			// Calculate stat multiplier based on player level
-			levelMultiplier := 1.0
-			if deployingPlayer.Account.Level > 1 {
-				for i := 1; i < deployingPlayer.Account.Level; i++ {
-					levelMultiplier *= 1.1
-				}
-			}
+			// Calculate stat multiplier based on player level, or the player's handicap
+			levelMultiplier := statMultiplier(gs.Rules, &deployingPlayer.Account)
 
-			newTroopInstanceID := fmt.Sprintf("%s_troop_%d", deployingPlayer.Account.Username, time.Now().UnixNano())
+			deployedAt := time.Now()
+			newTroopInstanceID := fmt.Sprintf("%s_troop_%d", deployingPlayer.Account.Username, deployedAt.UnixNano())
 			activeTroop := &models.ActiveTroop{
 				InstanceID: newTroopInstanceID,
 				SpecID:     troopSpec.ID,
 				OwnerID:    deployingPlayer.Account.Username,
-				CurrentHP:  int(float64(troopSpec.BaseHP) * levelMultiplier),
-				MaxHP:      int(float64(troopSpec.BaseHP) * levelMultiplier),
-				CurrentATK: int(float64(troopSpec.BaseATK) * levelMultiplier),
-				CurrentDEF: int(float64(troopSpec.BaseDEF) * levelMultiplier), // Though troops only attack towers
-				DeployedAt: time.Now(),
+				CurrentHP:  game.ScaleStat(troopSpec.BaseHP, levelMultiplier),
+				MaxHP:      game.ScaleStat(troopSpec.BaseHP, levelMultiplier),
+				CurrentATK: game.ScaleStat(troopSpec.BaseATK, levelMultiplier),
+				CurrentDEF: game.ScaleStat(troopSpec.BaseDEF, levelMultiplier), // Though troops only attack towers
+				DeployedAt: deployedAt,
+				ReadyAt:    deployedAt.Add(troopSpec.SpawnDelay()),
+				Lane:       lane,
 				// TargetID will be set by the attack logic
 			}
+			if lifetime := troopSpec.Lifetime(); lifetime > 0 {
+				activeTroop.ExpiresAt = deployedAt.Add(lifetime)
+			}
+			if troopSpec.Special == models.SpecialShield {
+				activeTroop.ShieldHP = game.ScaleStat(troopSpec.ShieldHP, levelMultiplier)
+			}
 			deployingPlayer.DeployedTroops[newTroopInstanceID] = activeTroop
-			gs.activeTroops[newTroopInstanceID] = activeTroop   // Add to centralized map
-			gs.lastTroopAttack[newTroopInstanceID] = time.Now() // Initialize attack timer
+			gs.activeTroops[newTroopInstanceID] = activeTroop // Add to centralized map
+			gs.perf.observeTroops(len(gs.activeTroops))
+			gs.lastTroopAttack[newTroopInstanceID] = activeTroop.ReadyAt // Initialize attack timer; no attack falls due while spawning
 
-			log.Printf("[GameSession %s] Player %s deployed %s (Instance: %s, HP: %d, ATK: %d)",
+			gs.logf("[GameSession %s] Player %s deployed %s (Instance: %s, HP: %d, ATK: %d)",
 				gs.ID, deployingPlayer.Account.Username, troopSpec.Name, newTroopInstanceID, activeTroop.CurrentHP, activeTroop.CurrentATK)
-			gs.sendGameEventToAllPlayers(network.GameEventTroopDeployed, map[string]interface{}{
-				"player_id":   deployingPlayer.Account.Username,
-				"troop_id":    newTroopInstanceID,
-				"troop_spec":  troopSpec.ID,
-				"owner_id":    deployingPlayer.Account.Username,
-				"current_hp":  activeTroop.CurrentHP,
-				"max_hp":      activeTroop.MaxHP,
-				"current_atk": activeTroop.CurrentATK,
+			gs.sendGameEventToAllPlayers(network.GameEventTroopDeployed, network.TroopDeployedEvent{
+				PlayerID:   deployingPlayer.Account.Username,
+				TroopID:    newTroopInstanceID,
+				TroopSpec:  troopSpec.ID,
+				OwnerID:    deployingPlayer.Account.Username,
+				CurrentHP:  activeTroop.CurrentHP,
+				MaxHP:      activeTroop.MaxHP,
+				CurrentATK: activeTroop.CurrentATK,
+				Lane:       lane,
 			})
 
 			// Record processed command and send ACK for normal troop deployment
@@ -544,107 +1265,297 @@ func (gs *GameSession) handlePlayerAction(msg network.UDPMessage) {
 					Type:        network.UDPMsgTypeCommandAck,
 					SessionID:   gs.ID,
 					PlayerToken: msg.PlayerToken,
-					Seq:         0, // ACK specific seq
 					Timestamp:   time.Now(),
 					Payload:     ackPayload,
 				}, clientAddr)
-				log.Printf("[GameSession %s] Player %s: Sent ACK for Troop Deploy %s (Seq: %d)", gs.ID, msg.PlayerToken, troopSpec.Name, msg.Seq)
+				gs.logf("[GameSession %s] Player %s: Sent ACK for Troop Deploy %s (Seq: %d)", gs.ID, msg.PlayerToken, troopSpec.Name, msg.Seq)
 			} else {
-				log.Printf("[GameSession %s] Player %s: Could not send ACK for Troop Deploy %s (Seq: %d), client address unknown.", gs.ID, msg.PlayerToken, troopSpec.Name, msg.Seq)
+				gs.logf("[GameSession %s] Player %s: Could not send ACK for Troop Deploy %s (Seq: %d), client address unknown.", gs.ID, msg.PlayerToken, troopSpec.Name, msg.Seq)
 			}
 		}
-		// After handling deployment, immediately send a game state update to reflect mana change and new troop/heal.
-		// This can be done by falling through, or explicitly calling a send state function if extracted.
-		// The main loop will send an update soon anyway with the ticker.
+		// After handling deployment, immediately send a game state update to reflect mana change and new troop/heal,
+		// rather than waiting up to a full tick for the main loop to do it.
+		gs.requestImmediateStateUpdate()
 
 	case "basic_ping": // Handling basic_ping to avoid unhandled message log
-		log.Printf("[GameSession %s] Received basic_ping from PlayerToken %s. Acknowledged.", gs.ID, msg.PlayerToken)
+		gs.logf("[GameSession %s] Received basic_ping from PlayerToken %s. Acknowledged.", gs.ID, msg.PlayerToken)
 		// Optionally, send a pong back or just ignore after logging.
 	default:
-		log.Printf("[GameSession %s] Received unhandled player action type: %s", gs.ID, msg.Type)
+		if unknown, sample := gs.udpDrops.Drop(network.DropUnknownType); sample {
+			gs.logf("[GameSession %s] Received unhandled player action type: %s (%d unknown so far).", gs.ID, msg.Type, unknown)
+		}
 	}
 }
 
-// Stop ends the game session, closes connections, and notifies the manager.
+// Stop ends the game session: it flushes outbound UDP and signals the reader to shut down.
+// The UDP reader owns the socket and closes it within udpReadTimeout of Stop returning.
 func (gs *GameSession) Stop() {
-	log.Printf("Game session %s stopped.", gs.ID)
-	if gs.udpConn != nil {
-		gs.udpConn.Close()
-	}
+	gs.stopOnce.Do(func() {
+		gs.logf("Game session %s stopped.", gs.ID)
+		// Let queued packets (final events, ACKs) go out before the socket closes
+		if gs.sender != nil && !gs.sender.closeAndFlush(outboundFlushTimeout) {
+			gs.logf("[GameSession %s] Outbound UDP queue did not drain within %v.", gs.ID, outboundFlushTimeout)
+		}
+		close(gs.done)
+		gs.closeSessionLog()
+		if err := gs.capture.Close(); err != nil {
+			log.Printf("[GameSession %s] Error closing message capture: %v", gs.ID, err)
+		}
+	})
 	// TODO: Persist player EXP/level changes, notify SessionManager to remove session.
 }
 
+// logf logs a line to the server log and, if enabled, to the session's own log file.
+func (gs *GameSession) logf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+	if gs.sessionLog != nil {
+		gs.sessionLog.Printf(format, args...)
+	}
+}
+
+// closeSessionLog closes the session's log file, if it has one. Lines logged afterwards,
+// e.g. by the UDP reader as it exits, only reach the server log.
+func (gs *GameSession) closeSessionLog() {
+	if gs.logFile == nil {
+		return
+	}
+	if err := gs.logFile.Close(); err != nil {
+		log.Printf("[GameSession %s] Error closing session log %s: %v", gs.ID, gs.logPath, err)
+	}
+}
+
+// LogPath returns the session's log file, or "" if it does not have one.
+func (gs *GameSession) LogPath() string {
+	return gs.logPath
+}
+
+// LastInboundAt returns when the session last received a UDP datagram, or the zero time if it never has.
+func (gs *GameSession) LastInboundAt() time.Time {
+	nanos := gs.lastInboundAt.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
 // setupUDPConnectionAndListener sets up the UDP listener for this game session.
 func (gs *GameSession) setupUDPConnectionAndListener() error {
-	if gs.udpConn != nil {
-		gs.udpConn.Close() // Close existing connection if any before setting up new
-	}
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", gs.udpPort))
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(gs.udpHost, strconv.Itoa(gs.udpPort)))
 	if err != nil {
-		log.Printf("[GameSession %s] Failed to resolve UDP address for port %d: %v", gs.ID, gs.udpPort, err)
+		gs.logf("[GameSession %s] Failed to resolve UDP address %s port %d: %v", gs.ID, gs.udpHost, gs.udpPort, err)
 		return err
 	}
 	conn, err := net.ListenUDP("udp", addr)
 	if err != nil {
-		log.Printf("[GameSession %s] Failed to listen on UDP port %d: %v", gs.ID, gs.udpPort, err)
+		gs.logf("[GameSession %s] Failed to listen on UDP port %d: %v", gs.ID, gs.udpPort, err)
 		return err
 	}
 	gs.udpConn = conn
-	log.Printf("[GameSession %s] Listening for UDP on port %d (%s)", gs.ID, gs.udpPort, gs.udpConn.LocalAddr().String())
+	gs.sender = newUDPSender(gs.ID, conn, gs.capture)
+	gs.logf("[GameSession %s] Listening for UDP on port %d (%s)", gs.ID, gs.udpPort, gs.udpConn.LocalAddr().String())
 
 	go gs.readUDPMessages() // Start the dedicated reader for this session
 	return nil
 }
 
 // readUDPMessages continuously reads messages from the session's UDP connection
-// and forwards them to the playerActions channel.
+// and forwards them to the sending player's action queue.
+// It is the only closer of udpConn: it returns, closing the socket, once gs.done is closed.
 func (gs *GameSession) readUDPMessages() {
 	defer func() {
-		if gs.udpConn != nil {
-			log.Printf("[GameSession %s] Closing UDP connection on port %d.", gs.ID, gs.udpPort)
-			gs.udpConn.Close() // Ensure connection is closed when goroutine exits
-		}
+		gs.logf("[GameSession %s] Closing UDP connection on port %d.", gs.ID, gs.udpPort)
+		gs.udpConn.Close()
 	}()
 
-	buffer := make([]byte, 2048) // Buffer for incoming UDP packets
+	buffer := make([]byte, network.MaxUDPDatagramSize) // Buffer for incoming UDP packets
 
 	for {
+		select {
+		case <-gs.done:
+			gs.logf("[GameSession %s] UDP listener on port %d stopped.", gs.ID, gs.udpPort)
+			return
+		default:
+		}
+
+		// Wake up periodically so a stopped session is noticed even when no player is sending
+		gs.udpConn.SetReadDeadline(time.Now().Add(udpReadTimeout))
 		n, remoteAddr, err := gs.udpConn.ReadFromUDP(buffer)
 		if err != nil {
-			// Check if the error is due to the connection being closed (e.g., by gs.Stop())
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				log.Printf("[GameSession %s] UDP read timeout on port %d. Continuing...", gs.ID, gs.udpPort)
 				continue
 			}
-			if strings.Contains(err.Error(), "use of closed network connection") {
-				log.Printf("[GameSession %s] UDP listener on port %d stopped (connection closed).", gs.ID, gs.udpPort)
-				return // Exit goroutine
-			}
-			log.Printf("[GameSession %s] Error reading from UDP on port %d: %v. Listener stopping.", gs.ID, gs.udpPort, err)
+			gs.logf("[GameSession %s] Error reading from UDP on port %d: %v. Listener stopping.", gs.ID, gs.udpPort, err)
 			return
 		}
+		gs.lastInboundAt.Store(time.Now().UnixNano())
+		gs.capture.Record(network.CaptureReceived, network.CaptureUDP, buffer[:n])
+
+		if n == len(buffer) { // The datagram may have been cut short; don't mistake it for corruption
+			if truncated, sample := gs.udpDrops.Drop(network.DropOversize); sample {
+				gs.logf("[GameSession %s] Discarding UDP datagram from %s that filled the %d-byte read buffer (%d truncated so far).", gs.ID, remoteAddr.String(), len(buffer), truncated)
+			}
+			continue
+		}
 
-		var udpMsg network.UDPMessage
+		// The payload is kept as raw JSON; each handler decodes it strictly into the type it expects
+		var rawPayload json.RawMessage
+		udpMsg := network.UDPMessage{Payload: &rawPayload}
 		if err := json.Unmarshal(buffer[:n], &udpMsg); err != nil {
-			log.Printf("[GameSession %s] Error unmarshalling UDP message from %s: %v. Raw: %s", gs.ID, remoteAddr.String(), err, string(buffer[:n]))
+			if malformed, sample := gs.udpDrops.Drop(network.DropParseError); sample {
+				gs.logf("[GameSession %s] Error unmarshalling UDP message from %s: %v (%d malformed so far). Raw: %s", gs.ID, remoteAddr.String(), err, malformed, logSnippet(buffer[:n]))
+			}
+			continue
+		}
+		udpMsg.Payload = rawPayload
+		if udpMsg.Stream == "" { // Version 1 client
+			udpMsg.Stream = network.StreamForType(udpMsg.Type)
+		}
+		if udpMsg.Stream != network.UDPStreamCommand {
+			if unexpected, sample := gs.udpDrops.Drop(network.DropUnknownType); sample {
+				gs.logf("[GameSession %s] Discarding message type %s from %s on unexpected stream %q (%d unknown so far).", gs.ID, udpMsg.Type, remoteAddr.String(), udpMsg.Stream, unexpected)
+			}
 			continue
 		}
 
-		// Store/update client address for potential direct responses
-		gs.mu.Lock() // Lock for writing to playerClientAddresses
-		gs.playerClientAddresses[udpMsg.PlayerToken] = remoteAddr
-		log.Printf("[GameSession %s] Stored/Updated remote UDP address for %s to %s", gs.ID, udpMsg.PlayerToken, remoteAddr.String())
+		// Only a packet carrying one of this session's current, unexpired tokens and its ID may
+		// set a player's address; anything else (a stray echo-server ping, a mistyped token, the
+		// token a reconnected player used before) is counted and dropped.
+		gs.mu.Lock()
+		queue := gs.actionQueueFor(udpMsg.PlayerToken)
+		valid := queue != nil && udpMsg.SessionID == gs.ID && time.Now().Before(gs.tokenExpiry[udpMsg.PlayerToken])
+		if valid {
+			// Store/update client address for potential direct responses
+			if previous, known := gs.playerClientAddresses[udpMsg.PlayerToken]; !known || previous.String() != remoteAddr.String() {
+				gs.logf("[GameSession %s] Stored/Updated remote UDP address for %s to %s", gs.ID, udpMsg.PlayerToken, remoteAddr.String())
+			}
+			gs.playerClientAddresses[udpMsg.PlayerToken] = remoteAddr
+			username := gs.Player1.Account.Username
+			if queue == gs.player2Actions {
+				username = gs.Player2.Account.Username
+			}
+			gs.lastHeard[username] = time.Now()
+		}
 		gs.mu.Unlock()
+		if !valid {
+			if rejected, sample := gs.udpDrops.Drop(network.DropBadSession); sample {
+				gs.logf("[GameSession %s] Discarding message type %s from %s with unknown or expired token or session ID (%d rejected so far).", gs.ID, udpMsg.Type, remoteAddr.String(), rejected)
+			}
+			continue
+		}
 
-		// Send to actions channel for processing by the game loop
-		// Add a non-blocking send with timeout or select to prevent deadlocks if channel is full
+		// Pings need no game state, so they are answered here rather than queued behind deploys
+		if udpMsg.Type == network.UDPMsgTypePing {
+			gs.sendUDPMessageToAddress(network.UDPMessage{
+				Type:        network.UDPMsgTypePong,
+				SessionID:   gs.ID,
+				PlayerToken: udpMsg.PlayerToken,
+				Timestamp:   time.Now(),
+				Payload:     network.PongUDP{PingSeq: udpMsg.Seq},
+			}, remoteAddr)
+			continue
+		}
+		// A full queue usually drains within a tick, so wait briefly rather than drop at once.
+		// If it is still full the sender is told the server is busy, so its client backs off
+		// instead of burning resends on what looks like packet loss.
 		select {
-		case gs.playerActions <- udpMsg:
-			// log.Printf("[GameSession %s] Forwarded UDP message from %s to playerActions channel.", gs.ID, udpMsg.PlayerToken)
+		case queue <- udpMsg:
+			// log.Printf("[GameSession %s] Forwarded UDP message from %s to action queue.", gs.ID, udpMsg.PlayerToken)
+			gs.perf.observeQueue(len(queue))
+			continue
 		default:
-			log.Printf("[GameSession %s] Warning: playerActions channel full for player %s. Discarding message type %s.", gs.ID, udpMsg.PlayerToken, udpMsg.Type)
 		}
+		timer := time.NewTimer(actionEnqueueTimeout)
+		select {
+		case queue <- udpMsg:
+			timer.Stop()
+			gs.delayedActions.Add(1)
+			gs.perf.observeQueue(cap(queue)) // It was full a moment ago
+		case <-timer.C:
+			gs.mu.Lock()
+			gs.droppedActions[udpMsg.PlayerToken]++
+			dropped := gs.droppedActions[udpMsg.PlayerToken]
+			gs.mu.Unlock()
+			gs.busyRejections.Add(1)
+			gs.logf("[GameSession %s] Warning: action queue full for player %s. Rejecting message type %s as server busy (%d dropped so far).", gs.ID, udpMsg.PlayerToken, udpMsg.Type, dropped)
+			gs.sendServerBusy(udpMsg, remoteAddr)
+		case <-gs.done:
+			timer.Stop()
+			gs.logf("[GameSession %s] UDP listener on port %d stopped.", gs.ID, gs.udpPort)
+			return
+		}
+	}
+}
+
+// rejectDeploy tells player their deploy command seq was refused and why. The event carries
+// the player's current mana so a client that deducted the cost in advance can reconcile at
+// once instead of waiting for the next state update. Must be called with gs.mu held.
+func (gs *GameSession) rejectDeploy(player *models.PlayerInGame, seq uint32, message string) {
+	gs.rejectDeployWithCode(player, seq, network.GameErrorDeployRejected, message)
+}
+
+// rejectDeployWithCode is rejectDeploy with a more specific error code, e.g. GameErrorWarmup.
+func (gs *GameSession) rejectDeployWithCode(player *models.PlayerInGame, seq uint32, code, message string) {
+	gs.sendGameEventToPlayer(player.SessionToken, network.GameEventError, network.GameErrorEvent{
+		Message: message,
+		Code:    code,
+		Seq:     seq,
+		Mana:    player.CurrentMana,
+	})
+}
+
+// decodeDeployPayload strictly decodes a deploy command payload: it must be at most
+// network.MaxDeployPayloadSize bytes, hold a single DeployTroopCommandUDP object with no
+// unknown fields, and name a troop. Whether the troop exists is left to the caller.
+func decodeDeployPayload(raw json.RawMessage) (network.DeployTroopCommandUDP, error) {
+	var cmd network.DeployTroopCommandUDP
+	if len(raw) > network.MaxDeployPayloadSize {
+		return cmd, fmt.Errorf("payload is %d bytes, limit is %d", len(raw), network.MaxDeployPayloadSize)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cmd); err != nil {
+		return network.DeployTroopCommandUDP{}, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return network.DeployTroopCommandUDP{}, errors.New("trailing data after payload")
+	}
+	if cmd.TroopID == "" {
+		return cmd, errors.New("missing troop_id")
+	}
+	return cmd, nil
+}
+
+// logSnippetMax is how much of a rejected datagram is logged.
+const logSnippetMax = 128
+
+// logSnippet returns data as text for a log line, cut to logSnippetMax bytes so a large
+// or hostile datagram can't flood the log.
+func logSnippet(data []byte) string {
+	if len(data) <= logSnippetMax {
+		return string(data)
 	}
+	return fmt.Sprintf("%s... (%d bytes)", data[:logSnippetMax], len(data))
+}
+
+// sendServerBusy tells the sender of msg that it was rejected because the session is
+// overloaded, and that it may retry. It only uses the address the message came from,
+// so the reader can call it without gs.mu.
+func (gs *GameSession) sendServerBusy(msg network.UDPMessage, addr *net.UDPAddr) {
+	gs.sendUDPMessageToAddress(network.UDPMessage{
+		Type:        network.UDPMsgTypeGameEvent,
+		SessionID:   gs.ID,
+		PlayerToken: msg.PlayerToken,
+		Timestamp:   time.Now(),
+		Payload: network.GameEventUDP{
+			EventType: network.GameEventError,
+			Details: network.GameErrorEvent{
+				Message: "Server busy; your command will be retried.",
+				Code:    network.GameErrorServerBusy,
+				Retry:   true,
+				Seq:     msg.Seq,
+			},
+		},
+	}, addr)
 }
 
 // This is a simplified listener. In a real server, you might have a central UDP listener
@@ -653,9 +1564,9 @@ func (gs *GameSession) readUDPMessages() {
 /* func (gs *GameSession) listenForUDPPackets() {
 	// This is a placeholder. The actual UDP listening is done by the global UDPServer
 	// in server.go. That server needs to route messages to the correct GameSession's
-	// playerActions channel.
+	// player action queues.
 	// For now, to test the quit logic, we won't implement full routing.
-	// We assume that if a message reaches this session's playerActions channel, it's for this session.
+	// We assume that if a message reaches this session's action queues, it's for this session.
 	log.Printf("[GameSession %s] Placeholder: listenForUDPPackets started. Real routing TBD.", gs.ID)
 }
 */
@@ -663,40 +1574,67 @@ func (gs *GameSession) readUDPMessages() {
 // TODO: Add methods for handling player actions received via UDP, updating game state, etc.
 // TODO: Implement broadcastUDPMessage to send GameStateUpdateUDP to both players using their stored UDP addresses.
 
-// sendUDPMessageToAddress sends a UDPMessage to a specific client UDP address.
+// sendUDPMessageToAddress queues a UDPMessage for a specific client UDP address.
 func (gs *GameSession) sendUDPMessageToAddress(msg network.UDPMessage, addr *net.UDPAddr) {
-	if gs.udpConn == nil {
-		log.Printf("[GameSession %s] Cannot send UDP message, udpConn is nil.", gs.ID)
+	if gs.udpConn == nil || gs.sender == nil {
+		gs.logf("[GameSession %s] Cannot send UDP message, udpConn is nil.", gs.ID)
 		return
 	}
 	if addr == nil {
-		log.Printf("[GameSession %s] Cannot send UDP message, target address is nil for PlayerToken %s.", gs.ID, msg.PlayerToken)
+		gs.logf("[GameSession %s] Cannot send UDP message, target address is nil for PlayerToken %s.", gs.ID, msg.PlayerToken)
 		return
 	}
 
+	// Stamp the message with its stream and the next sequence number on that stream.
+	msg.Stream = network.StreamForType(msg.Type)
+	msg.Seq = gs.nextOutboundSeq(msg.Stream)
+
 	bytes, err := json.Marshal(msg)
 	if err != nil {
-		log.Printf("[GameSession %s] Error marshalling UDP message for %s (Type: %s): %v", gs.ID, addr.String(), msg.Type, err)
+		gs.logf("[GameSession %s] Error marshalling UDP message for %s (Type: %s): %v", gs.ID, addr.String(), msg.Type, err)
 		return
 	}
 
-	_, err = gs.udpConn.WriteToUDP(bytes, addr)
-	if err != nil {
-		log.Printf("[GameSession %s] Error sending UDP message to %s (Type: %s): %v", gs.ID, addr.String(), msg.Type, err)
-	} else {
-		// log.Printf("[GameSession %s] Sent UDP message type %s to %s (PlayerToken: %s)", gs.ID, msg.Type, addr.String(), msg.PlayerToken)
+	// Hand off to the sender goroutine; the caller usually holds gs.mu and must not block on the socket
+	gs.sender.enqueue(outboundPacket{addr: addr, data: bytes, msgType: msg.Type, supersedable: isWholeStateUpdate(msg), lowPriority: isLowPriorityEvent(msg) || msg.Type == network.UDPMsgTypeGameTimer})
+	// log.Printf("[GameSession %s] Queued UDP message type %s to %s (PlayerToken: %s)", gs.ID, msg.Type, addr.String(), msg.PlayerToken)
+}
+
+// isLowPriorityEvent reports whether msg is a game event the sender may discard under load,
+// such as a spectator joining.
+func isLowPriorityEvent(msg network.UDPMessage) bool {
+	if msg.Type != network.UDPMsgTypeGameEvent {
+		return false
 	}
+	event, ok := msg.Payload.(network.GameEventUDP)
+	return ok && (event.EventType == network.GameEventSpectatorJoined || event.EventType == network.GameEventSpectatorLeft)
 }
 
-// sendGameEventToAllPlayers broadcasts a game event to both players in the session.
-func (gs *GameSession) sendGameEventToAllPlayers(eventType string, details map[string]interface{}) {
+// isWholeStateUpdate reports whether msg is a state update sent in a single datagram.
+func isWholeStateUpdate(msg network.UDPMessage) bool {
+	if msg.Type != network.UDPMsgTypeGameStateUpdate {
+		return false
+	}
+	update, ok := msg.Payload.(network.GameStateUpdateUDP)
+	return ok && update.Parts <= 1
+}
+
+// nextOutboundSeq returns the next sequence number for a server-to-client stream.
+func (gs *GameSession) nextOutboundSeq(stream string) uint32 {
+	gs.seqMu.Lock()
+	defer gs.seqMu.Unlock()
+	gs.outSeq[stream]++
+	return gs.outSeq[stream]
+}
+
+// sendGameEventToAllPlayers broadcasts a game event to both players in the session. details
+// is the event type's details struct from the network package, e.g. network.CountdownEvent.
+func (gs *GameSession) sendGameEventToAllPlayers(eventType string, details interface{}) {
 	eventPayload := network.GameEventUDP{
 		EventType: eventType,
 		Details:   details,
 	}
-	// TODO: Proper sequence numbers for server events
 	msg := network.UDPMessage{
-		Seq:       uint32(time.Now().UnixNano()),
 		Timestamp: time.Now(),
 		SessionID: gs.ID,
 		Type:      network.UDPMsgTypeGameEvent,
@@ -712,18 +1650,17 @@ func (gs *GameSession) sendGameEventToAllPlayers(eventType string, details map[s
 		msg.PlayerToken = gs.Player2.SessionToken
 		gs.sendUDPMessageToAddress(msg, addr2)
 	}
-	log.Printf("[GameSession %s] Broadcasted GameEvent: Type=%s, Details=%v", gs.ID, eventType, details)
+	gs.logf("[GameSession %s] Broadcasted GameEvent: Type=%s, Details=%+v", gs.ID, eventType, details)
 }
 
 // sendGameEventToPlayer sends a game event to a specific player.
-func (gs *GameSession) sendGameEventToPlayer(playerToken string, eventType string, details map[string]interface{}) {
+func (gs *GameSession) sendGameEventToPlayer(playerToken string, eventType string, details interface{}) {
 	if addr, ok := gs.playerClientAddresses[playerToken]; ok {
 		eventPayload := network.GameEventUDP{
 			EventType: eventType,
 			Details:   details,
 		}
 		msg := network.UDPMessage{
-			Seq:         uint32(time.Now().UnixNano()), // TODO: Proper sequence numbers
 			Timestamp:   time.Now(),
 			SessionID:   gs.ID,
 			PlayerToken: playerToken, // Target specific player
@@ -731,9 +1668,9 @@ func (gs *GameSession) sendGameEventToPlayer(playerToken string, eventType strin
 			Payload:     eventPayload,
 		}
 		gs.sendUDPMessageToAddress(msg, addr)
-		log.Printf("[GameSession %s] Sent GameEvent to %s: Type=%s, Details=%v", gs.ID, playerToken, eventType, details)
+		gs.logf("[GameSession %s] Sent GameEvent to %s: Type=%s, Details=%+v", gs.ID, playerToken, eventType, details)
 	} else {
-		log.Printf("[GameSession %s] Failed to send GameEvent to %s: address not found.", gs.ID, playerToken)
+		gs.logf("[GameSession %s] Failed to send GameEvent to %s: address not found.", gs.ID, playerToken)
 	}
 }
 
@@ -772,184 +1709,147 @@ func (gs *GameSession) getPlayerByUsername(username string) *models.PlayerInGame
 	return nil
 }
 
+// recordHit adds hp removed in one attack to the attacker's damage dealt, counted for
+// troopSpec when a troop made the attack ("" for a tower), and to the defender's damage
+// taken. Must be called with gs.mu held.
+func (gs *GameSession) recordHit(attackerID, troopSpec, defenderID string, hp int) {
+	if attacker := gs.getPlayerByUsername(attackerID); attacker != nil {
+		if troopSpec != "" {
+			attacker.Stats.AddTroopDamage(troopSpec, hp)
+		} else {
+			attacker.Stats.DamageDealt += hp
+		}
+	}
+	if defender := gs.getPlayerByUsername(defenderID); defender != nil {
+		defender.Stats.DamageTaken += hp
+	}
+}
+
+// gameClockElapsed returns how much of the game clock has run, counting any fast-forward.
+// Must be called with gs.mu held.
+func (gs *GameSession) gameClockElapsed() time.Duration {
+	if !gs.combatStarted {
+		return 0
+	}
+	elapsed := gs.Rules.GameDuration - time.Until(gs.gameEndTime)
+	if elapsed < 0 {
+		return 0
+	}
+	if elapsed > gs.Rules.GameDuration {
+		return gs.Rules.GameDuration
+	}
+	return elapsed
+}
+
 // isKingTower checks if a given tower is a King Tower.
 func (gs *GameSession) isKingTower(tower *models.TowerInstance) bool {
-	// Assuming King Tower can be identified by its SpecID or Name.
-	// Let's primarily use SpecID for robustness.
-	spec, ok := gs.Config.Towers[tower.SpecID]
-	if !ok {
-		log.Printf("[GameSession %s] Warning: Could not find tower spec for ID %s to check if King Tower.", gs.ID, tower.SpecID)
+	if _, ok := gs.Config.Towers[tower.SpecID]; !ok {
+		gs.logf("[GameSession %s] Warning: Could not find tower spec for ID %s to check if King Tower.", gs.ID, tower.SpecID)
 		return false // Or handle as an error
 	}
-	return spec.Name == "King Tower" // Or check spec.ID == "king_tower"
+	return game.IsKingTower(&gs.Config, tower)
 }
 
-// determineWinnerAndStop evaluates win conditions and stops the game.
-// reason: "timeout", "king_tower_destroyed", "player_quit"
-func (gs *GameSession) determineWinnerAndStop(reason string) {
-	if gs.isGameOver { // Prevent multiple calls
-		return
+// casualEXP scales EXP earned in a casual game by Rules.CasualEXPMultiplier, rounding down.
+func (gs *GameSession) casualEXP(exp int) int {
+	if gs.Rules.CasualEXPMultiplier <= 0 {
+		return 0
 	}
-	gs.isGameOver = true // Mark game as over immediately
-	log.Printf("[GameSession %s] Determining winner due to: %s", gs.ID, reason)
-
-	var winner *models.PlayerInGame
-	var resultPlayer1, resultPlayer2 string // "win", "loss", "draw"
-	var p1ExpEarned, p2ExpEarned int
-
-	switch reason {
-	case "king_tower_destroyed":
-		// The player whose King Tower is NOT destroyed is the winner.
-		// We need to find out which King Tower was destroyed.
-		// The call to this function happens right after a tower is destroyed.
-		// The 'defender' in that context lost their King Tower.
-		// Let's iterate through towers to be certain.
-		p1KingDestroyed := false
-		p2KingDestroyed := false
-		for _, tower := range gs.towers {
-			if gs.isKingTower(tower) && tower.IsDestroyed {
-				if tower.OwnerID == gs.Player1.Account.Username {
-					p1KingDestroyed = true
-				} else if tower.OwnerID == gs.Player2.Account.Username {
-					p2KingDestroyed = true
-				}
-			}
-		}
-
-		if p1KingDestroyed && !p2KingDestroyed {
-			winner = gs.Player2
-			gs.gameWinner = gs.Player2
-			gs.gameResult = fmt.Sprintf("%s won (King Tower)", gs.Player2.Account.Username)
-			resultPlayer1 = "loss"
-			resultPlayer2 = "win"
-		} else if p2KingDestroyed && !p1KingDestroyed {
-			winner = gs.Player1
-			gs.gameWinner = gs.Player1
-			gs.gameResult = fmt.Sprintf("%s won (King Tower)", gs.Player1.Account.Username)
-			resultPlayer1 = "win"
-			resultPlayer2 = "loss"
-		} else {
-			// This case (both or neither king tower destroyed by this specific event) should ideally not happen
-			// if called correctly. Or could be a simultaneous destruction? For now, treat as a draw.
-			log.Printf("[GameSession %s] Ambiguous King Tower destruction state (p1King: %v, p2King: %v). Declaring draw.", gs.ID, p1KingDestroyed, p2KingDestroyed)
-			gs.gameResult = "Draw (Simultaneous King Tower Destruction or Error)"
-			resultPlayer1 = "draw"
-			resultPlayer2 = "draw"
-		}
-
-	case "timeout":
-		p1TowersDestroyed := 0
-		p2TowersDestroyed := 0
-		for _, tower := range gs.towers {
-			if tower.IsDestroyed {
-				if tower.OwnerID == gs.Player1.Account.Username { // This tower belonged to P1, so P2 destroyed it.
-					p2TowersDestroyed++
-				} else if tower.OwnerID == gs.Player2.Account.Username { // This tower belonged to P2, so P1 destroyed it.
-					p1TowersDestroyed++
-				}
-			}
-		}
-		log.Printf("[GameSession %s] Timeout: Player 1 destroyed %d towers, Player 2 destroyed %d towers.", gs.ID, p1TowersDestroyed, p2TowersDestroyed)
-		if p1TowersDestroyed > p2TowersDestroyed {
-			winner = gs.Player1
-			gs.gameWinner = gs.Player1
-			gs.gameResult = fmt.Sprintf("%s won (Most Towers)", gs.Player1.Account.Username)
-			resultPlayer1 = "win"
-			resultPlayer2 = "loss"
-		} else if p2TowersDestroyed > p1TowersDestroyed {
-			winner = gs.Player2
-			gs.gameWinner = gs.Player2
-			gs.gameResult = fmt.Sprintf("%s won (Most Towers)", gs.Player2.Account.Username)
-			resultPlayer1 = "loss"
-			resultPlayer2 = "win"
-		} else {
-			gs.gameResult = "Draw (Equal Towers Destroyed)"
-			resultPlayer1 = "draw"
-			resultPlayer2 = "draw"
-		}
-	case "player_quit":
-		// Determine which player did not quit
-		if gs.player1Quit && !gs.player2Quit {
-			winner = gs.Player2
-			gs.gameWinner = gs.Player2
-			gs.gameResult = fmt.Sprintf("%s won (Opponent Quit)", gs.Player2.Account.Username)
-			resultPlayer1 = "loss" // The quitter loses
-			resultPlayer2 = "win"
-		} else if gs.player2Quit && !gs.player1Quit {
-			winner = gs.Player1
-			gs.gameWinner = gs.Player1
-			gs.gameResult = fmt.Sprintf("%s won (Opponent Quit)", gs.Player1.Account.Username)
-			resultPlayer1 = "win"
-			resultPlayer2 = "loss" // The quitter loses
-		} else {
-			// Both quit or some other state, declare as draw or handle as needed
-			gs.gameResult = "Draw (Both Players Quit or Undetermined)"
-			resultPlayer1 = "draw"
-			resultPlayer2 = "draw"
-			log.Printf("[GameSession %s] Both players quit or quit state unclear. Declaring draw.", gs.ID)
-		}
+	return int(float64(exp) * gs.Rules.CasualEXPMultiplier)
+}
 
-	default:
-		log.Printf("[GameSession %s] Unknown game end reason: %s. Declaring draw.", gs.ID, reason)
-		gs.gameResult = "Draw (Unknown Reason)"
-		resultPlayer1 = "draw"
-		resultPlayer2 = "draw"
+// expBreakdown itemizes exp, the EXP a player is paid for the game, from their outcome.
+// Whatever the outcome's breakdown leaves out becomes an "other" item, and the casual
+// reduction an item of its own, so the items always add up to exp.
+func expBreakdown(player game.PlayerOutcome, exp int) network.EXPBreakdown {
+	breakdown := append(network.EXPBreakdown(nil), player.Breakdown...)
+	if rest := player.EXP - breakdown.Total(); rest != 0 {
+		breakdown = append(breakdown, network.EXPItem{Source: network.EXPSourceOther, Label: "Other", EXP: rest})
 	}
-
-	// Calculate EXP from destroyed towers
-	for _, tower := range gs.towers {
-		if tower.IsDestroyed {
-			towerSpec, ok := gs.Config.Towers[tower.SpecID]
-			if !ok {
-				log.Printf("[GameSession %s] Warning: Could not find spec for destroyed tower %s (ID: %s) for EXP calculation.", gs.ID, tower.GameSpecificID, tower.SpecID)
-				continue
-			}
-			// If Player1's tower was destroyed, Player2 gets EXP
-			if tower.OwnerID == gs.Player1.Account.Username {
-				p2ExpEarned += towerSpec.EXPYield
-			} else if tower.OwnerID == gs.Player2.Account.Username {
-				p1ExpEarned += towerSpec.EXPYield
-			}
-		}
+	if cut := exp - player.EXP; cut != 0 {
+		breakdown = append(breakdown, network.EXPItem{Source: network.EXPSourceCasual, Label: "Casual game", EXP: cut})
 	}
+	return breakdown
+}
 
-	// Add win/draw bonus EXP
-	if resultPlayer1 == "win" {
-		p1ExpEarned += 30 // Win bonus from plan
-	} else if resultPlayer1 == "draw" {
-		p1ExpEarned += 10 // Draw bonus from plan
+// determineWinnerAndStop has gs.winConditions judge the game and builds the results, then
+// leaves saving and announcing them, and stopping the session, to settleEnding. The caller
+// must hold gs.mu, and must have deferred settleEnding before taking it.
+// reason: "timeout", "king_tower_destroyed", "player_quit", "surrender", "forced"
+func (gs *GameSession) determineWinnerAndStop(reason string) {
+	if gs.State().Finished() { // Prevent multiple calls
+		return
 	}
-
-	if resultPlayer2 == "win" {
-		p2ExpEarned += 30 // Win bonus
-	} else if resultPlayer2 == "draw" {
-		p2ExpEarned += 10 // Draw bonus
+	gs.transition(StateEnded) // Mark game as over immediately
+	gs.endReason = reason
+	gs.logf("[GameSession %s] Determining winner due to: %s", gs.ID, reason)
+
+	trigger := game.EndTrigger{
+		Reason:      reason,
+		Player1Quit: gs.player1Quit,
+		Player2Quit: gs.player2Quit,
+		ForcedNote:  gs.forcedReason,
+		Elapsed:     gs.gameClockElapsed(),
 	}
-
-	log.Printf("[GameSession %s] EXP Earned This Game: %s -> %d, %s -> %d", gs.ID, gs.Player1.Account.Username, p1ExpEarned, gs.Player2.Account.Username, p2ExpEarned)
-	// gs.Player1.Account.EXP += p1ExpEarned // This is now handled by UpdatePlayerAfterGame
-	// gs.Player2.Account.EXP += p2ExpEarned // This is now handled by UpdatePlayerAfterGame
-
-	p1LeveledUp, errP1 := persistence.UpdatePlayerAfterGame(&gs.Player1.Account, p1ExpEarned)
-	if errP1 != nil {
-		log.Printf("[GameSession %s] Error updating player %s data: %v", gs.ID, gs.Player1.Account.Username, errP1)
+	if gs.surrenderedBy != nil {
+		trigger.SurrenderedBy = gs.surrenderedBy.Account.Username
+	}
+	switch gs.forcedOutcome {
+	case ForceOutcomePlayer1:
+		trigger.ForcedWinner = gs.Player1.Account.Username
+	case ForceOutcomePlayer2:
+		trigger.ForcedWinner = gs.Player2.Account.Username
 	}
-	p2LeveledUp, errP2 := persistence.UpdatePlayerAfterGame(&gs.Player2.Account, p2ExpEarned)
-	if errP2 != nil {
-		log.Printf("[GameSession %s] Error updating player %s data: %v", gs.ID, gs.Player2.Account.Username, errP2)
+	outcome := gs.winConditions.Evaluate(gs.toModelGameSession(), trigger, gs.Rules)
+	if outcome.Detail != "" {
+		gs.logf("[GameSession %s] %s", gs.ID, outcome.Detail)
 	}
 
-	if p1LeveledUp {
-		log.Printf("[GameSession %s] Player %s leveled up to Level %d!", gs.ID, gs.Player1.Account.Username, gs.Player1.Account.Level)
+	winner := gs.getPlayerByUsername(outcome.Winner) // nil for a draw
+	gs.gameWinner = winner
+	gs.gameResult = outcome.Summary
+	resultPlayer1, resultPlayer2 := outcome.Player1.Result, outcome.Player2.Result
+	p1ExpEarned, p2ExpEarned := outcome.Player1.EXP, outcome.Player2.EXP
+	consolationEXP := outcome.ConsolationEXP
+	if gs.casual {
+		p1ExpEarned, p2ExpEarned = gs.casualEXP(p1ExpEarned), gs.casualEXP(p2ExpEarned)
+		consolationEXP = gs.casualEXP(consolationEXP)
 	}
-	if p2LeveledUp {
-		log.Printf("[GameSession %s] Player %s leveled up to Level %d!", gs.ID, gs.Player2.Account.Username, gs.Player2.Account.Level)
+	p1Breakdown, p2Breakdown := expBreakdown(outcome.Player1, p1ExpEarned), expBreakdown(outcome.Player2, p2ExpEarned)
+
+	// The first ranked win of the player's day, by when the game ended, earns a bonus. The
+	// day claimed is saved with the account below.
+	endedAt := time.Now()
+	firstWinBonus := 0
+	if winner != nil && !gs.casual && gs.Rules.FirstWinBonusEXP > 0 && winner.Account.ClaimFirstWinBonus(endedAt, gs.Rules.DayLocation) {
+		firstWinBonus = gs.Rules.FirstWinBonusEXP
+		item := network.EXPItem{Source: network.EXPSourceFirstWin, Label: "First win of the day", EXP: firstWinBonus}
+		if winner == gs.Player1 {
+			p1ExpEarned += firstWinBonus
+			p1Breakdown = append(p1Breakdown, item)
+		} else {
+			p2ExpEarned += firstWinBonus
+			p2Breakdown = append(p2Breakdown, item)
+		}
+		gs.logf("[GameSession %s] First win of the day for %s: +%d EXP.", gs.ID, winner.Account.Username, firstWinBonus)
 	}
 
+	gs.logf("[GameSession %s] EXP Earned This Game: %s -> %d, %s -> %d", gs.ID, gs.Player1.Account.Username, p1ExpEarned, gs.Player2.Account.Username, p2ExpEarned)
+	p1Level, p2Level := gs.Player1.Account.Level, gs.Player2.Account.Level // The levels the game was played at
+
+	// settleEnding saves the EXP and these changes to the accounts as saved, which may have
+	// changed since the match copied them. Until then, the results show what the game's
+	// copies predict.
+	p1Changes := gameAccountChanges(resultPlayer1, !gs.casual, p1ExpEarned, gs.Player1.Stats.DeploysBySpec, winner == gs.Player1 && firstWinBonus > 0, gs.Player1.Account.LastFirstWinBonusDate)
+	p2Changes := gameAccountChanges(resultPlayer2, !gs.casual, p2ExpEarned, gs.Player2.Stats.DeploysBySpec, winner == gs.Player2 && firstWinBonus > 0, gs.Player2.Account.LastFirstWinBonusDate)
+	p1Predicted, p2Predicted := gs.Player1.Account, gs.Player2.Account
+	p1LeveledUp := persistence.AddEXP(&p1Predicted, p1ExpEarned)
+	p2LeveledUp := persistence.AddEXP(&p2Predicted, p2ExpEarned)
+
 	if winner != nil {
-		log.Printf("[GameSession %s] Game ended. Winner: %s. Result: %s", gs.ID, winner.Account.Username, gs.gameResult)
+		gs.logf("[GameSession %s] Game ended. Winner: %s. Result: %s", gs.ID, winner.Account.Username, gs.gameResult)
 	} else {
-		log.Printf("[GameSession %s] Game ended. Result: %s", gs.ID, gs.gameResult)
+		gs.logf("[GameSession %s] Game ended. Result: %s", gs.ID, gs.gameResult)
 	}
 
 	// TODO: Sprint 5: Calculate EXP for Player1 -> DONE
@@ -968,70 +1868,204 @@ func (gs *GameSession) determineWinnerAndStop(reason string) {
 		resultInfo.OverallWinnerID = gs.gameWinner.Account.Username
 	}
 
+	p1Name, p2Name := gs.Player1.Account.Username, gs.Player2.Account.Username
+	durationSeconds := int(gs.gameClockElapsed().Seconds())
+	summaries := map[string]network.PlayerGameSummary{
+		p1Name: network.NewPlayerGameSummary(gs.Player1.Stats),
+		p2Name: network.NewPlayerGameSummary(gs.Player2.Stats),
+	}
+	for _, name := range []string{p1Name, p2Name} {
+		if s := summaries[name]; s.MVPTroop != "" {
+			gs.logf("[GameSession %s] MVP of %s: %s (%d damage)", gs.ID, name, s.MVPTroop, s.MVPDamage)
+		}
+	}
+
 	// Player 1 results
 	resultInfo.Player1Result = network.GameOverResults{
-		WinnerID:  resultInfo.OverallWinnerID,
-		Outcome:   resultPlayer1, // "win", "loss", "draw"
-		EXPChange: p1ExpEarned,
-		NewEXP:    gs.Player1.Account.EXP,
-		NewLevel:  gs.Player1.Account.Level,
-		LevelUp:   p1LeveledUp,
+		GameID:                   gs.ID,
+		Reason:                   reason,
+		WinnerID:                 resultInfo.OverallWinnerID,
+		Outcome:                  resultPlayer1, // "win", "loss", "draw"
+		EXPChange:                p1ExpEarned,
+		NewEXP:                   p1Predicted.EXP,
+		NewLevel:                 p1Predicted.Level,
+		LevelUp:                  p1LeveledUp,
+		DurationSeconds:          durationSeconds,
+		TroopsDeployedByYou:      gs.Player1.Stats.TroopsDeployed,
+		TroopsDeployedByOpponent: gs.Player2.Stats.TroopsDeployed,
+		TotalDamageDealt:         gs.Player1.Stats.DamageDealt,
+		Casual:                   gs.casual,
+		EXPBreakdown:             p1Breakdown,
+		Summaries:                summaries,
 		// DestroyedTowers: populated below
 	}
 
 	// Player 2 results
 	resultInfo.Player2Result = network.GameOverResults{
-		WinnerID:  resultInfo.OverallWinnerID,
-		Outcome:   resultPlayer2, // "win", "loss", "draw"
-		EXPChange: p2ExpEarned,
-		NewEXP:    gs.Player2.Account.EXP,
-		NewLevel:  gs.Player2.Account.Level,
-		LevelUp:   p2LeveledUp,
+		GameID:                   gs.ID,
+		Reason:                   reason,
+		WinnerID:                 resultInfo.OverallWinnerID,
+		Outcome:                  resultPlayer2, // "win", "loss", "draw"
+		EXPChange:                p2ExpEarned,
+		NewEXP:                   p2Predicted.EXP,
+		NewLevel:                 p2Predicted.Level,
+		LevelUp:                  p2LeveledUp,
+		DurationSeconds:          durationSeconds,
+		TroopsDeployedByYou:      gs.Player2.Stats.TroopsDeployed,
+		TroopsDeployedByOpponent: gs.Player1.Stats.TroopsDeployed,
+		TotalDamageDealt:         gs.Player2.Stats.DamageDealt,
+		Casual:                   gs.casual,
+		EXPBreakdown:             p2Breakdown,
+		Summaries:                summaries,
 		// DestroyedTowers: populated below
 	}
 
 	// Populate DestroyedTowers for each player
-	// p1DestroyedCount is towers P1 destroyed (owned by P2)
-	// p2DestroyedCount is towers P2 destroyed (owned by P1)
-	p1DestroyedCount := 0
-	p2DestroyedCount := 0
-	for _, tower := range gs.towers {
-		if tower.IsDestroyed {
-			if tower.OwnerID == gs.Player2.Account.Username { // P2's tower destroyed by P1
-				p1DestroyedCount++
-			} else if tower.OwnerID == gs.Player1.Account.Username { // P1's tower destroyed by P2
-				p2DestroyedCount++
-			}
-		}
-	}
+	p1DestroyedCount, p2DestroyedCount := outcome.Player1.TowersDestroyed, outcome.Player2.TowersDestroyed
 	resultInfo.Player1Result.DestroyedTowers = map[string]int{gs.Player2.Account.Username: p1DestroyedCount} // Towers P1 destroyed (belonging to P2)
 	resultInfo.Player2Result.DestroyedTowers = map[string]int{gs.Player1.Account.Username: p2DestroyedCount} // Towers P2 destroyed (belonging to P1)
 
+	if reason == "surrender" {
+		loserResult, winnerResult := &resultInfo.Player1Result, &resultInfo.Player2Result
+		if winner == gs.Player1 {
+			loserResult, winnerResult = winnerResult, loserResult
+		}
+		loserResult.Surrendered = true
+		winnerResult.OpponentSurrendered = true
+		winnerResult.ConsolationEXP = consolationEXP
+	}
+	if firstWinBonus > 0 {
+		winnerResult := &resultInfo.Player1Result
+		if winner == gs.Player2 {
+			winnerResult = &resultInfo.Player2Result
+		}
+		winnerResult.FirstWinBonus = firstWinBonus
+	}
+
+	perf := gs.perf.summary()
+	gs.logf("[GameSession %s] Perf: %d ticks, tick p50 %v p95 %v max %v, peak %d troops, peak action queue %d/%d.",
+		gs.ID, perf.Ticks, perf.TickP50, perf.TickP95, perf.TickMax, perf.PeakTroops, perf.PeakQueueDepth, playerActionQueueSize)
+	if perf.OverBudget(gs.tickInterval()) {
+		gs.logf("[GameSession %s] Warning: tick p95 %v exceeded the %v tick interval.", gs.ID, perf.TickP95, gs.tickInterval())
+	}
+
+	matchRecord := persistence.MatchRecord{
+		GameID:          gs.ID,
+		Player1:         p1Name,
+		Player2:         p2Name,
+		WinnerID:        resultInfo.OverallWinnerID,
+		EndReason:       reason,
+		EndedAt:         endedAt,
+		DurationSeconds: durationSeconds,
+		TroopsDeployed:  map[string]int{p1Name: gs.Player1.Stats.TroopsDeployed, p2Name: gs.Player2.Stats.TroopsDeployed},
+		DamageDealt:     map[string]int{p1Name: gs.Player1.Stats.DamageDealt, p2Name: gs.Player2.Stats.DamageDealt},
+		TowersDestroyed: map[string]int{p1Name: p1DestroyedCount, p2Name: p2DestroyedCount},
+		EXP:             map[string]network.EXPBreakdown{p1Name: p1Breakdown, p2Name: p2Breakdown},
+		Summaries:       summaries,
+		Casual:          gs.casual,
+		Perf:            &perf,
+	}
+	if reason == "surrender" {
+		matchRecord.SurrenderedBy = gs.surrenderedBy.Account.Username
+	}
+	ending := &gameEnding{
+		results:  resultInfo,
+		accounts: [2]func(*models.PlayerAccount){p1Changes, p2Changes},
+		record:   matchRecord,
+	}
+	if gs.analytics {
+		ending.analytics = &persistence.AnalyticsRecord{
+			EndedOn:         endedAt.UTC().Format("2006-01-02"),
+			DurationSeconds: durationSeconds,
+			EndReason:       reason,
+			Casual:          gs.casual,
+			Players: [2]persistence.AnalyticsPlayer{
+				{Level: p1Level, Outcome: resultPlayer1, Deck: maps.Clone(gs.Player1.Stats.DeploysBySpec)},
+				{Level: p2Level, Outcome: resultPlayer2, Deck: maps.Clone(gs.Player2.Stats.DeploysBySpec)},
+			},
+		}
+	}
+
+	// One last authoritative state update, flagged final, so clients can show the board as
+	// the game ended. settleEnding drains the outbound queue before the results go out over
+	// TCP, so the update (and every combat event queued before it) reaches the clients first.
+	// Nothing is sent over UDP after it; Stop's own flush then has nothing left to do.
+	gs.broadcastGameState(time.Now(), true)
+	gs.ending.Store(ending)
+}
+
+// gameEnding is the part of ending a game determineWinnerAndStop leaves to settleEnding,
+// because it writes to disk or may block.
+type gameEnding struct {
+	results   network.GameResultInfo
+	accounts  [2]func(acc *models.PlayerAccount) // Each player's account changes besides the EXP; see gameAccountChanges
+	record    persistence.MatchRecord
+	analytics *persistence.AnalyticsRecord // nil unless the session exports analytics
+}
+
+// gameAccountChanges returns what a game changes in a player's account besides the EXP:
+// the outcome of a ranked game, the games played and troops deployed, and the day of a
+// first-win bonus if the player claimed one (firstWinDay, as claimed on the game's copy).
+func gameAccountChanges(outcome string, ranked bool, exp int, deploys map[string]int, firstWin bool, firstWinDay string) func(*models.PlayerAccount) {
+	deploys = maps.Clone(deploys)
+	return func(acc *models.PlayerAccount) {
+		if ranked { // Casual games do not count
+			acc.RecordOutcome(outcome)
+		}
+		acc.RecordGamePlayed(exp, deploys)
+		if firstWin {
+			acc.LastFirstWinBonusDate = firstWinDay
+		}
+	}
+}
+
+// settleEnding finishes the game determineWinnerAndStop ended, without gs.mu: it saves the
+// players' accounts, each reread under the player's lock, and the match record, lets the
+// final UDP messages drain, hands the results over and stops the session. Everything that
+// may end the game defers it before taking gs.mu, so it runs once the lock is released.
+// It does nothing while the game runs, or once the ending has been settled.
+func (gs *GameSession) settleEnding() {
+	ending := gs.ending.Swap(nil)
+	if ending == nil {
+		return
+	}
+	resultInfo := ending.results
+	results := [2]*network.GameOverResults{&resultInfo.Player1Result, &resultInfo.Player2Result}
+	for i, username := range []string{resultInfo.Player1Username, resultInfo.Player2Username} {
+		acc, leveledUp, err := persistence.UpdatePlayerAfterGame(username, results[i].EXPChange, ending.accounts[i])
+		if err != nil {
+			gs.logf("[GameSession %s] Error updating player %s data: %v", gs.ID, username, err)
+			continue
+		}
+		results[i].NewEXP, results[i].NewLevel, results[i].LevelUp = acc.EXP, acc.Level, leveledUp
+		if leveledUp {
+			gs.logf("[GameSession %s] Player %s leveled up to Level %d!", gs.ID, username, acc.Level)
+		}
+	}
+	if err := persistence.AppendMatchRecord(ending.record); err != nil {
+		gs.logf("[GameSession %s] Error writing match history record: %v", gs.ID, err)
+	}
+	if ending.analytics != nil {
+		exportMatchAnalytics(gs.ID, *ending.analytics, [2]string{resultInfo.Player1Username, resultInfo.Player2Username})
+	}
+
+	if gs.sender != nil && !gs.sender.closeAndFlush(outboundFlushTimeout) {
+		gs.logf("[GameSession %s] Outbound UDP queue did not drain within %v before the results.", gs.ID, outboundFlushTimeout)
+	}
+
 	if gs.resultsChan != nil {
 		select {
 		case gs.resultsChan <- resultInfo:
-			log.Printf("[GameSession %s] Sent game results to results channel.", gs.ID)
+			gs.logf("[GameSession %s] Sent game results to results channel.", gs.ID)
 		case <-time.After(2 * time.Second): // Timeout to prevent blocking indefinitely
-			log.Printf("[GameSession %s] Timeout sending game results to results channel.", gs.ID)
+			gs.logf("[GameSession %s] Timeout sending game results to results channel.", gs.ID)
 		}
 		// close(gs.resultsChan) // The receiver should decide when to close if it's long-lived, or if it's one-shot, this is fine.
 		// For now, assume the receiver handles its lifecycle.
 	} else {
-		log.Printf("[GameSession %s] resultsChan is nil. Cannot send game results.", gs.ID)
+		gs.logf("[GameSession %s] resultsChan is nil. Cannot send game results.", gs.ID)
 	}
 
-	// Send final game state update, possibly indicating game over
-	gs.sendGameStateToAllPlayers() // Ensure clients get one last update
-
 	// Actual stopping of the session (closing UDP conn, removing from manager)
 	gs.Stop() // Call the original Stop method to clean up resources
 }
-
-// sendGameStateToAllPlayers sends a game state update to all players in the session.
-func (gs *GameSession) sendGameStateToAllPlayers() {
-	// This is a placeholder. The actual implementation should gather game state data
-	// and send it to all connected players.
-	// For now, we'll just log the call.
-
-	// log.Printf("[GameSession %s] Sending game state to all players.", gs.ID)
-}
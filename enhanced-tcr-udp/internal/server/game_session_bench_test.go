@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"enhanced-tcr-udp/pkg/models"
+)
+
+// combatSession returns a session in combat since start, with both players connected and
+// troops trading hits with the towers. Troops and towers are made too sturdy to fall, so
+// every tick does the same work and the game never ends.
+func combatSession(b *testing.B) (gs *GameSession, start time.Time) {
+	b.Helper()
+	rules := models.DefaultGameRules()
+	rules.GameDuration = 1000 * time.Hour
+	gs = newTestSession(b, SessionOptions{Rules: &rules})
+
+	sink := udpSink(b)
+	start = time.Now()
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.playerClientAddresses[gs.Player1.SessionToken] = sink
+	gs.playerClientAddresses[gs.Player2.SessionToken] = sink
+	startTestCombat(b, gs, start)
+	for _, tower := range gs.towers {
+		tower.CurrentHP, tower.MaxHP = math.MaxInt32, math.MaxInt32
+	}
+	for i, player := range []*models.PlayerInGame{gs.Player1, gs.Player2} {
+		for j, specID := range []string{"pawn", "knight", "prince"} {
+			spec := gs.Config.Troops[specID]
+			troop := &models.ActiveTroop{
+				InstanceID: fmt.Sprintf("troop_%d_%d", i, j),
+				SpecID:     specID,
+				OwnerID:    player.Account.Username,
+				CurrentHP:  math.MaxInt32,
+				MaxHP:      math.MaxInt32,
+				CurrentATK: spec.BaseATK,
+				CurrentDEF: spec.BaseDEF,
+				DeployedAt: start,
+				ReadyAt:    start,
+			}
+			player.DeployedTroops[troop.InstanceID] = troop
+			gs.activeTroops[troop.InstanceID] = troop
+			gs.lastTroopAttack[troop.InstanceID] = start
+		}
+	}
+	return gs, start
+}
+
+// BenchmarkTick measures one tick of a game in combat (see combatSession) and reports its
+// allocations.
+func BenchmarkTick(b *testing.B) {
+	gs, start := combatSession(b)
+	interval := gs.tickInterval()
+	now := start
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		now = now.Add(interval)
+		if gs.tick(now) {
+			b.Fatalf("game ended after %d ticks", i+1)
+		}
+	}
+}
+
+// BenchmarkTickUnderSnapshotLoad measures ticks while other goroutines take snapshots of the
+// session and build full states from them without pause, as the admin console and
+// rejoining players do. Snapshot only holds the read lock while copying, so ticks should
+// take about as long as in BenchmarkTick; max-ns/tick shows the worst wait.
+func BenchmarkTickUnderSnapshotLoad(b *testing.B) {
+	gs, start := combatSession(b)
+
+	const readers = 4
+	stop := make(chan struct{})
+	var snapshots atomic.Int64
+	var wg sync.WaitGroup
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				gs.Snapshot().StateUpdate()
+				snapshots.Add(1)
+			}
+		}()
+	}
+
+	interval := gs.tickInterval()
+	now := start
+	var slowest time.Duration
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		now = now.Add(interval)
+		began := time.Now()
+		if gs.tick(now) {
+			b.Fatalf("game ended after %d ticks", i+1)
+		}
+		slowest = max(slowest, time.Since(began))
+	}
+	b.StopTimer()
+	close(stop)
+	wg.Wait()
+	b.ReportMetric(float64(slowest.Nanoseconds()), "max-ns/tick")
+	b.ReportMetric(float64(snapshots.Load())/float64(b.N), "snapshots/tick")
+}
@@ -0,0 +1,798 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"enhanced-tcr-udp/internal/game"
+	"enhanced-tcr-udp/internal/persistence"
+	"enhanced-tcr-udp/internal/testutil"
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+	"enhanced-tcr-udp/pkg/tcrclient"
+)
+
+// dialTestSession opens a client session to gs at addr (the session's own port if nil) as
+// the player with token, receiving until the test ends.
+func dialTestSession(t *testing.T, gs *GameSession, addr *net.UDPAddr, token string, cfg tcrclient.SessionConfig) *tcrclient.Session {
+	t.Helper()
+	if addr == nil {
+		addr = gs.udpConn.LocalAddr().(*net.UDPAddr)
+	}
+	cfg.GameID, cfg.PlayerToken = gs.ID, token
+	session, err := tcrclient.DialSession(addr.IP.String(), addr.Port, cfg)
+	if err != nil {
+		t.Fatalf("DialSession: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		session.Listen(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		session.Close()
+		<-done
+	})
+	return session
+}
+
+// handleNextAction waits for the next command in queue and handles it as the game loop
+// would, returning it.
+func handleNextAction(t *testing.T, gs *GameSession, queue chan network.UDPMessage) network.UDPMessage {
+	t.Helper()
+	select {
+	case action := <-queue:
+		gs.processPlayerAction(action)
+		return action
+	case <-time.After(2 * time.Second):
+		t.Fatal("no command reached the session")
+	}
+	return network.UDPMessage{}
+}
+
+// A deploy the client resends because its ACK was lost is applied once and acknowledged
+// every time, so the client stops resending.
+func TestDuplicateDeployAppliedOnce(t *testing.T) {
+	gs := newTestSession(t, SessionOptions{}) // Listening, but not started: the test runs its loop
+	gs.mu.Lock()
+	startTestCombat(t, gs, time.Now())
+	gs.Player1.CurrentMana = 10
+	gs.mu.Unlock()
+
+	alice, err := testutil.NewFakePeer(testutil.FakePeerOptions{})
+	if err != nil {
+		t.Fatalf("NewFakePeer: %v", err)
+	}
+	defer alice.Close()
+	server := gs.udpConn.LocalAddr().(*net.UDPAddr)
+	deploy := network.UDPMessage{
+		Stream:      network.UDPStreamCommand,
+		Seq:         1,
+		SessionID:   gs.ID,
+		PlayerToken: gs.Player1.SessionToken,
+		Type:        network.UDPMsgTypeDeployTroop,
+		Payload:     network.DeployTroopCommandUDP{TroopID: "pawn"},
+	}
+	for copies := 1; copies <= 3; copies++ { // The original and two resends
+		if err := alice.Send(server, deploy); err != nil {
+			t.Fatalf("sending deploy copy %d: %v", copies, err)
+		}
+		select {
+		case action := <-gs.player1Actions:
+			gs.processPlayerAction(action)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("deploy copy %d never reached the session", copies)
+		}
+	}
+
+	gs.mu.Lock()
+	troops, mana := len(gs.Player1.DeployedTroops), gs.Player1.CurrentMana
+	processed := len(gs.processedDeployCommands[gs.Player1.SessionToken])
+	gs.mu.Unlock()
+	if troops != 1 {
+		t.Errorf("alice has %d troops after 3 copies of one deploy, want 1", troops)
+	}
+	if want := 10 - gs.Config.Troops["pawn"].ManaCost; mana != want {
+		t.Errorf("alice has %d mana, want %d: the pawn paid for once", mana, want)
+	}
+	if processed != 1 {
+		t.Errorf("%d deploys recorded as processed, want 1", processed)
+	}
+
+	acks := 0
+	deadline := time.Now().Add(2 * time.Second)
+	for acks < 3 && time.Now().Before(deadline) {
+		acks = 0
+		for _, msg := range alice.Messages() {
+			if msg.Type != network.UDPMsgTypeCommandAck {
+				continue
+			}
+			var ack network.CommandAckUDP
+			if err := json.Unmarshal(msg.Payload.(json.RawMessage), &ack); err != nil || ack.AckSeq != 1 {
+				t.Fatalf("malformed ACK %s (%v)", msg.Payload, err)
+			}
+			acks++
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if acks != 3 {
+		t.Errorf("alice received %d ACKs for Seq 1, want one per copy", acks)
+	}
+}
+
+// deployAs handles command seq, a deploy of troopID by the player with token, as the game
+// loop would.
+func deployAs(t *testing.T, gs *GameSession, token string, seq uint32, troopID string) {
+	t.Helper()
+	payload, err := json.Marshal(network.DeployTroopCommandUDP{TroopID: troopID})
+	if err != nil {
+		t.Fatalf("encoding the deploy: %v", err)
+	}
+	gs.processPlayerAction(network.UDPMessage{
+		Stream:      network.UDPStreamCommand,
+		Seq:         seq,
+		SessionID:   gs.ID,
+		PlayerToken: token,
+		Type:        network.UDPMsgTypeDeployTroop,
+		Payload:     json.RawMessage(payload),
+	})
+}
+
+// A copy of a deploy arriving after the deploy's record was pruned is still a duplicate:
+// the pruned watermark covers it, so the troop is not deployed or paid for again, while a
+// new deploy after it is.
+func TestLateDuplicateDeployAfterPrune(t *testing.T) {
+	gs := newTestSession(t, SessionOptions{}) // Not started: the test runs its loop
+	cost := gs.Config.Troops["pawn"].ManaCost
+	gs.mu.Lock()
+	startTestCombat(t, gs, time.Now())
+	gs.Player1.CurrentMana = 2 * cost
+	gs.mu.Unlock()
+
+	deploy := func(seq uint32) { deployAs(t, gs, gs.Player1.SessionToken, seq, "pawn") }
+	state := func() (troops, mana int) {
+		gs.mu.Lock()
+		defer gs.mu.Unlock()
+		return len(gs.Player1.DeployedTroops), gs.Player1.CurrentMana
+	}
+
+	deploy(1)
+	if troops, mana := state(); troops != 1 || mana != cost {
+		t.Fatalf("after deploy 1: %d troops and %d mana, want 1 and %d", troops, mana, cost)
+	}
+
+	gs.mu.Lock()
+	gs.pruneProcessedCommands(time.Now().Add(deployDedupeRetention + time.Second))
+	remembered, pruned := len(gs.processedDeployCommands[gs.Player1.SessionToken]), gs.prunedDeploySeq[gs.Player1.SessionToken]
+	gs.mu.Unlock()
+	if remembered != 0 || pruned != 1 {
+		t.Fatalf("after pruning: %d deploys remembered and watermark %d, want none and 1", remembered, pruned)
+	}
+
+	deploy(1) // The late copy
+	if troops, mana := state(); troops != 1 || mana != cost {
+		t.Errorf("after the late copy of deploy 1: %d troops and %d mana, want 1 and %d", troops, mana, cost)
+	}
+	deploy(2)
+	if troops, mana := state(); troops != 2 || mana != 0 {
+		t.Errorf("after deploy 2: %d troops and %d mana, want 2 and 0", troops, mana)
+	}
+}
+
+// lossyRelay forwards datagrams between clients and the server at to, dropping those from
+// a client for which drop returns true. It returns the address clients send to.
+func lossyRelay(t *testing.T, to *net.UDPAddr, drop func(msg network.UDPMessage) bool) *net.UDPAddr {
+	t.Helper()
+	front, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listening for clients: %v", err)
+	}
+	back, err := net.DialUDP("udp", nil, to)
+	if err != nil {
+		t.Fatalf("dialing the server: %v", err)
+	}
+	t.Cleanup(func() {
+		front.Close()
+		back.Close()
+	})
+	var client atomic.Pointer[net.UDPAddr]
+	go func() {
+		buf := make([]byte, network.MaxUDPDatagramSize)
+		for {
+			n, from, err := front.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			client.Store(from)
+			var msg network.UDPMessage
+			if json.Unmarshal(buf[:n], &msg) == nil && drop(msg) {
+				continue
+			}
+			back.Write(buf[:n])
+		}
+	}()
+	go func() {
+		buf := make([]byte, network.MaxUDPDatagramSize)
+		for {
+			n, err := back.Read(buf)
+			if err != nil {
+				return
+			}
+			if addr := client.Load(); addr != nil {
+				front.WriteToUDP(buf[:n], addr)
+			}
+		}
+	}()
+	return front.LocalAddr().(*net.UDPAddr)
+}
+
+// A deploy lost on the way is still resent and applied when a later one arrives first:
+// the watermark echoed in state updates stops below it.
+func TestLostDeployResentPastLaterOne(t *testing.T) {
+	gs := newTestSession(t, SessionOptions{})
+	gs.mu.Lock()
+	startTestCombat(t, gs, time.Now())
+	gs.Player1.CurrentMana = 10
+	gs.mu.Unlock()
+	token := gs.Player1.SessionToken
+
+	var dropped atomic.Bool
+	relay := lossyRelay(t, gs.udpConn.LocalAddr().(*net.UDPAddr), func(msg network.UDPMessage) bool {
+		return msg.Type == network.UDPMsgTypeDeployTroop && msg.Seq == 1 && dropped.CompareAndSwap(false, true)
+	})
+	clock := testutil.NewClock(time.Now())
+	watermarks := make(chan uint32, 10)
+	alice := dialTestSession(t, gs, relay, token, tcrclient.SessionConfig{
+		Resend: tcrclient.ResendConfig{TimeoutMillis: 400, MaxResends: 3},
+		Clock:  clock.Now,
+		OnState: func(update network.GameStateUpdateUDP) {
+			watermarks <- update.LastProcessedClientSeq[token]
+		},
+	})
+	broadcast := func() uint32 {
+		t.Helper()
+		gs.mu.Lock()
+		gs.broadcastGameState(time.Now(), false)
+		gs.mu.Unlock()
+		select {
+		case watermark := <-watermarks:
+			return watermark
+		case <-time.After(2 * time.Second):
+			t.Fatal("no state update reached alice")
+		}
+		return 0
+	}
+	waitForAck := func(seq uint32) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for alice.Pending(seq) {
+			if time.Now().After(deadline) {
+				t.Fatalf("deploy %d was never acknowledged", seq)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	for _, troop := range []string{"pawn", "bishop"} { // The pawn is lost
+		if _, err := alice.Deploy(troop, ""); err != nil {
+			t.Fatalf("Deploy: %v", err)
+		}
+	}
+	if action := handleNextAction(t, gs, gs.player1Actions); action.Seq != 2 {
+		t.Fatalf("the session got Seq %d first, want the bishop's 2", action.Seq)
+	}
+	waitForAck(2)
+	if watermark := broadcast(); watermark != 0 {
+		t.Errorf("watermark %d with deploy 1 missing, want 0", watermark)
+	}
+	if !alice.Pending(1) {
+		t.Fatal("the lost pawn was settled by the bishop's ACK or the watermark")
+	}
+
+	clock.Advance(time.Second)
+	if failed := alice.ProcessResends(); len(failed) != 0 {
+		t.Fatalf("gave up on %v after one timeout", failed)
+	}
+	if action := handleNextAction(t, gs, gs.player1Actions); action.Seq != 1 {
+		t.Fatalf("the session got Seq %d, want the resent pawn", action.Seq)
+	}
+	waitForAck(1)
+	if watermark := broadcast(); watermark != 2 {
+		t.Errorf("watermark %d with both deploys handled, want 2", watermark)
+	}
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	troops := make(map[string]int)
+	for _, troop := range gs.activeTroops {
+		troops[troop.SpecID]++
+	}
+	if troops["pawn"] != 1 || troops["bishop"] != 1 {
+		t.Errorf("alice's troops are %v, want the pawn and the bishop", troops)
+	}
+	if want := 10 - gs.Config.Troops["pawn"].ManaCost - gs.Config.Troops["bishop"].ManaCost; gs.Player1.CurrentMana != want {
+		t.Errorf("alice has %d mana, want %d", gs.Player1.CurrentMana, want)
+	}
+}
+
+// The end of a game saves its EXP and outcome onto each player's account as saved, not
+// onto the copy the match took: changes saved during the game survive, and the results
+// report the EXP and level the saved account ends up with.
+func TestGameEndUpdatesSavedAccounts(t *testing.T) {
+	names := [2]string{"end-alice", "end-bob"}
+	for _, name := range names {
+		if err := persistence.SavePlayerAccount(&models.PlayerAccount{Username: name, HashedPassword: "secret", Level: 1, EXP: 40, Wins: 2}); err != nil {
+			t.Fatalf("saving %s: %v", name, err)
+		}
+	}
+	results := make(chan network.GameResultInfo, 1)
+	gs := newTestSession(t, SessionOptions{
+		Player1:     &models.PlayerAccount{Username: names[0], Level: 1, EXP: 40, Wins: 2},
+		Player2:     &models.PlayerAccount{Username: names[1], Level: 1, EXP: 40, Wins: 2},
+		ResultsChan: results,
+	})
+	// Saved while the game runs, e.g. from a settings update and an operator's grant
+	if _, err := persistence.UpdatePlayerAccount(names[0], func(acc *models.PlayerAccount) error {
+		acc.Settings = map[string]string{models.SettingPreferredDeck: "rush"}
+		acc.EXP = 60
+		return nil
+	}); err != nil {
+		t.Fatalf("updating %s: %v", names[0], err)
+	}
+
+	gs.mu.Lock()
+	startTestCombat(t, gs, time.Now())
+	gs.mu.Unlock()
+	if err := gs.ForceEnd("test", ForceOutcomePlayer1); err != nil {
+		t.Fatalf("ForceEnd: %v", err)
+	}
+	info := <-results
+
+	alice, err := persistence.LoadPlayerAccount(names[0])
+	if err != nil {
+		t.Fatalf("loading %s: %v", names[0], err)
+	}
+	if alice.Settings[models.SettingPreferredDeck] != "rush" {
+		t.Errorf("settings saved during the game lost: %v", alice.Settings)
+	}
+	want := models.PlayerAccount{Level: 1, EXP: 60}
+	persistence.AddEXP(&want, info.Player1Result.EXPChange)
+	if alice.EXP != want.EXP || alice.Level != want.Level {
+		t.Errorf("saved %d EXP at level %d, want %d at level %d", alice.EXP, alice.Level, want.EXP, want.Level)
+	}
+	if info.Player1Result.NewEXP != alice.EXP || info.Player1Result.NewLevel != alice.Level {
+		t.Errorf("results say %d EXP at level %d, saved account has %d at level %d",
+			info.Player1Result.NewEXP, info.Player1Result.NewLevel, alice.EXP, alice.Level)
+	}
+	if alice.Wins != 3 || alice.GamesPlayed != 1 {
+		t.Errorf("saved %d wins in %d games, want 3 in 1", alice.Wins, alice.GamesPlayed)
+	}
+	if bob, err := persistence.LoadPlayerAccount(names[1]); err != nil || bob.Losses != 1 || bob.Wins != 2 {
+		t.Errorf("loser saved as %+v (%v), want 2 wins and 1 loss", bob, err)
+	}
+}
+
+// The results are handed over without gs.mu held, so a receiver slow to take them does
+// not stall the session's other users meanwhile.
+func TestGameEndReleasesLockBeforeResults(t *testing.T) {
+	results := make(chan network.GameResultInfo) // Unread until the lock is seen free
+	gs := newTestSession(t, SessionOptions{ResultsChan: results})
+	gs.mu.Lock()
+	startTestCombat(t, gs, time.Now())
+	gs.mu.Unlock()
+
+	ended := make(chan error, 1)
+	go func() { ended <- gs.ForceEnd("test", ForceOutcomeDraw) }()
+	deadline := time.Now().Add(time.Second)
+	for !gs.State().Finished() || !gs.mu.TryLock() {
+		if time.Now().After(deadline) {
+			t.Fatal("gs.mu still held while the results wait to be handed over")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	gs.mu.Unlock()
+
+	select {
+	case <-results:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no results handed over")
+	}
+	if err := <-ended; err != nil {
+		t.Fatalf("ForceEnd: %v", err)
+	}
+}
+
+// A flood of datagrams with made-up tokens (a port scan, a stray UDP check or echo-server
+// ping, another session's players) registers no address: the session only ever knows the
+// addresses of its two players, and every bogus datagram is counted as a bad session.
+func TestBogusTokensRegisterNoAddress(t *testing.T) {
+	gs := newTestSession(t, SessionOptions{})
+	conn, err := net.DialUDP("udp", nil, gs.udpConn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("dialing the session: %v", err)
+	}
+	defer conn.Close()
+	send := func(sessionID, token string) {
+		t.Helper()
+		data, err := json.Marshal(network.UDPMessage{Type: network.UDPMsgTypePing, SessionID: sessionID, PlayerToken: token, Payload: network.PingUDP{}})
+		if err != nil {
+			t.Fatalf("encoding a ping: %v", err)
+		}
+		if _, err := conn.Write(data); err != nil {
+			t.Fatalf("sending a ping: %v", err)
+		}
+	}
+
+	// Sent in batches the socket buffer can hold, each waited out, so none is lost unread
+	const randomTokens, otherSession, batch = 300, 50, 50
+	for sent := 0; sent < randomTokens+otherSession; {
+		for end := sent + batch; sent < end; sent++ {
+			if sent < randomTokens {
+				send(gs.ID, newSessionToken())
+			} else {
+				send("another-game", "token-alice")
+			}
+		}
+		for deadline := time.Now().Add(2 * time.Second); gs.udpDrops.Counts().BadSession < uint64(sent); time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%d of %d bogus datagrams dropped", gs.udpDrops.Counts().BadSession, sent)
+			}
+		}
+	}
+	conn.Write([]byte(network.UDPCheckPrefix + "probe")) // Not JSON: a parse error
+	send(gs.ID, "token-alice")
+	send(gs.ID, "token-bob")
+
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		gs.mu.Lock()
+		_, alice := gs.playerClientAddresses["token-alice"]
+		_, bob := gs.playerClientAddresses["token-bob"]
+		known := len(gs.playerClientAddresses)
+		gs.mu.Unlock()
+		if known > 2 {
+			t.Fatalf("session knows %d addresses, want at most 2", known)
+		}
+		if alice && bob {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the players' own pings registered no address")
+		}
+	}
+	counts := gs.udpDrops.Counts()
+	if counts.BadSession != randomTokens+otherSession || counts.ParseErrors != 1 {
+		t.Errorf("dropped %d bad sessions and %d parse errors, want %d and 1", counts.BadSession, counts.ParseErrors, randomTokens+otherSession)
+	}
+}
+
+// sturdyCombat starts combat in a new session and deploys troopID for alice, then makes the
+// troop and bob's towers too sturdy to fall, keeping the towers' order by HP so the troop
+// keeps attacking the same one for as long as a test runs the game loop. It returns the
+// session, the troop and when combat started.
+func sturdyCombat(t *testing.T, troopID string) (*GameSession, *models.ActiveTroop, time.Time) {
+	t.Helper()
+	gs := newTestSession(t, SessionOptions{})
+	start := time.Now()
+	gs.mu.Lock()
+	startTestCombat(t, gs, start)
+	gs.Player1.CurrentMana = gs.Rules.MaxMana
+	gs.mu.Unlock()
+	deployAs(t, gs, gs.Player1.SessionToken, 1, troopID)
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if len(gs.activeTroops) != 1 {
+		t.Fatalf("%d troops after deploying a %s, want 1", len(gs.activeTroops), troopID)
+	}
+	var troop *models.ActiveTroop
+	for _, troop = range gs.activeTroops {
+		troop.CurrentHP, troop.MaxHP = 1e9, 1e9
+	}
+	for _, tower := range gs.Player2.Towers {
+		tower.CurrentHP += 1e9
+		tower.MaxHP += 1e9
+	}
+	return gs, troop, start
+}
+
+// tickAt runs gs's game loop for a tick at now, with both players just heard from, and
+// returns the damage bob's towers took in it.
+func tickAt(t *testing.T, gs *GameSession, now time.Time) int {
+	t.Helper()
+	gs.mu.Lock()
+	gs.lastHeard[gs.Player1.Account.Username], gs.lastHeard[gs.Player2.Account.Username] = now, now
+	before := gs.Player2.Stats.DamageTaken
+	gs.mu.Unlock()
+	if gs.tick(now) {
+		t.Fatalf("game ended at %v", now)
+	}
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	return gs.Player2.Stats.DamageTaken - before
+}
+
+// A game loop whose ticks come late still gets each unit's attacks in on schedule: over the
+// same stretch of game time, ticks every 700ms or 1.9s, or a 5s stall, yield exactly the
+// attacks of ticks on time. A stall longer than maxCatchUpAttacks attacks skips the excess rather than
+// landing it in one burst.
+func TestSlowTicksKeepAttackSchedule(t *testing.T) {
+	const window = 20 * time.Second
+	// attacks runs combat from its start to window with a tick at each offset ticks returns,
+	// then one at window, and returns how many times alice's troop hit and the most hits
+	// that landed in one tick.
+	attacks := func(t *testing.T, ticks []time.Duration) (hits, burst int) {
+		t.Helper()
+		gs, _, start := sturdyCombat(t, "pawn")
+		perHit := 0
+		for _, offset := range append(ticks, window) {
+			dealt := tickAt(t, gs, start.Add(offset))
+			if dealt == 0 {
+				continue
+			}
+			if perHit == 0 {
+				perHit = dealt // Every hit lands on the same tower for the same damage
+			}
+			if dealt%perHit != 0 {
+				t.Fatalf("%d damage in the tick at %v is not a whole number of %d-damage hits", dealt, offset, perHit)
+			}
+			hits += dealt / perHit
+			if dealt/perHit > burst {
+				burst = dealt / perHit
+			}
+		}
+		return hits, burst
+	}
+	every := func(interval time.Duration, skip func(offset time.Duration) bool) []time.Duration {
+		var ticks []time.Duration
+		for offset := interval; offset < window; offset += interval {
+			if skip == nil || !skip(offset) {
+				ticks = append(ticks, offset)
+			}
+		}
+		return ticks
+	}
+	stalled := func(from, to time.Duration) func(time.Duration) bool {
+		return func(offset time.Duration) bool { return offset > from && offset < to }
+	}
+
+	onTime, _ := attacks(t, every(100*time.Millisecond, nil))
+	if want := int(window / attackInterval); onTime < want-1 || onTime > want {
+		t.Fatalf("%d attacks in %v with ticks on time, want about %d", onTime, window, want)
+	}
+	for _, tt := range []struct {
+		name  string
+		ticks []time.Duration
+	}{
+		{"700ms ticks", every(700*time.Millisecond, nil)},
+		{"1.9s ticks", every(1900*time.Millisecond, nil)},
+		{"5s stall", every(100*time.Millisecond, stalled(7*time.Second, 12*time.Second))},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if hits, _ := attacks(t, tt.ticks); hits != onTime {
+				t.Errorf("%d attacks, want %d as with ticks on time", hits, onTime)
+			}
+		})
+	}
+	t.Run("11s stall", func(t *testing.T) {
+		hits, burst := attacks(t, every(100*time.Millisecond, stalled(4*time.Second, 15*time.Second)))
+		if burst > maxCatchUpAttacks {
+			t.Errorf("%d attacks in one tick after the stall, want at most %d", burst, maxCatchUpAttacks)
+		}
+		// 11s owes 5 attacks, of which maxCatchUpAttacks are made up
+		if skipped := onTime - hits; skipped < 1 || skipped > 3 {
+			t.Errorf("%d attacks, want %d less the 2 or so the stall skipped", hits, onTime)
+		}
+	})
+}
+
+// A charge troop's first attack deals its charged damage and every later one its normal
+// damage.
+func TestChargeOnFirstHitOnly(t *testing.T) {
+	gs, troop, start := sturdyCombat(t, "prince")
+	spec := gs.Config.Troops["prince"]
+	if spec.Special != models.SpecialCharge {
+		t.Fatalf("the prince's special is %q, want %q", spec.Special, models.SpecialCharge)
+	}
+	gs.mu.Lock()
+	target := game.FindTargetTower(troop, gs.toModelGameSession())
+	effectiveness := game.Effectiveness(gs.Rules, spec.DamageType, gs.Config.Towers[target.SpecID].ArmorType)
+	charged, _ := game.CalculateDamage(game.ChargedATK(troop.CurrentATK, spec.ChargeMultiplier), target.CurrentDEF, false, 0, effectiveness)
+	normal, _ := game.CalculateDamage(troop.CurrentATK, target.CurrentDEF, false, 0, effectiveness)
+	readyAt := troop.ReadyAt
+	gs.mu.Unlock()
+	if charged <= normal {
+		t.Fatalf("charged damage %d is no more than normal damage %d", charged, normal)
+	}
+
+	var hits []int
+	for now := start; len(hits) < 3 && now.Before(readyAt.Add(4*attackInterval)); now = now.Add(100 * time.Millisecond) {
+		if dealt := tickAt(t, gs, now); dealt > 0 {
+			hits = append(hits, dealt)
+		}
+	}
+	if want := []int{charged, normal, normal}; !reflect.DeepEqual(hits, want) {
+		t.Errorf("the prince hit for %v, want %v", hits, want)
+	}
+}
+
+// A shield troop enters with its spec's shield, sent in state updates, and the tower hits
+// on it reach its HP only once the shield is gone: each hit's event tells the client how
+// much the shield absorbed and what is left of it.
+func TestShieldTakesTowerHitsFirst(t *testing.T) {
+	gs, troop, start := sturdyCombat(t, "rook")
+	shield := gs.Config.Troops["rook"].ShieldHP
+	gs.mu.Lock()
+	troopID, hp := troop.InstanceID, troop.CurrentHP
+	if troop.ShieldHP != shield || shield == 0 {
+		t.Errorf("the rook entered with a %d shield, want the spec's %d", troop.ShieldHP, shield)
+	}
+	gs.mu.Unlock()
+
+	states := make(chan network.GameStateUpdateUDP, 10)
+	hits := make(chan network.TroopDamagedEvent, 20)
+	alice := dialTestSession(t, gs, nil, gs.Player1.SessionToken, tcrclient.SessionConfig{
+		OnState: func(update network.GameStateUpdateUDP) { states <- update },
+		OnEvent: func(event network.GameEventUDP) {
+			switch details := event.Details.(type) {
+			case network.TroopDamagedEvent:
+				hits <- details
+			case network.CritHitEvent:
+				hits <- details.TroopDamagedEvent
+			}
+		},
+	})
+	if _, err := alice.Ping(2 * time.Second); err != nil { // Registers alice's address
+		t.Fatalf("Ping: %v", err)
+	}
+
+	gs.mu.Lock()
+	gs.broadcastGameState(time.Now(), true)
+	gs.mu.Unlock()
+	select {
+	case update := <-states:
+		if got := update.ActiveTroops[troopID].Shield; got != shield {
+			t.Errorf("state update shows a %d shield, want %d", got, shield)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no state update reached alice")
+	}
+
+	for volley := 1; volley <= 3; volley++ {
+		tickAt(t, gs, start.Add(time.Duration(volley)*attackInterval))
+	}
+	gs.mu.Lock()
+	damageTaken := hp - troop.CurrentHP + shield - troop.ShieldHP
+	gs.mu.Unlock()
+
+	shieldLeft, hpLeft, absorbedHits := shield, hp, 0
+	for accounted := 0; accounted < damageTaken; {
+		select {
+		case hit := <-hits:
+			if hit.DefenderID != troopID {
+				continue
+			}
+			absorbed := min(hit.Damage, shieldLeft)
+			shieldLeft -= absorbed
+			hpLeft -= hit.Damage - absorbed
+			if hit.ShieldAbsorbed != absorbed || hit.ShieldLeft != shieldLeft || hit.NewHP != hpLeft {
+				t.Errorf("a %d-damage hit reported %d absorbed, %d shield and %d HP left; want %d, %d and %d",
+					hit.Damage, hit.ShieldAbsorbed, hit.ShieldLeft, hit.NewHP, absorbed, shieldLeft, hpLeft)
+			}
+			if absorbed > 0 {
+				absorbedHits++
+			}
+			accounted += hit.Damage
+		case <-time.After(2 * time.Second):
+			t.Fatalf("events account for %d of the %d damage the rook took", accounted, damageTaken)
+		}
+	}
+	if shieldLeft != 0 || absorbedHits == 0 || hpLeft == hp {
+		t.Errorf("after three volleys: %d shield and %d HP lost over %d absorbing hits, want the shield broken and HP lost", shieldLeft, hp-hpLeft, absorbedHits)
+	}
+}
+
+// A troop with a lifetime stays on the field, counting down in state updates, until the
+// first tick at or after it runs out, which removes it with GameEventTroopExpired. It was
+// not defeated: no tower is credited with it and the opponent earns no EXP for it.
+func TestTroopExpiresAfterLifetime(t *testing.T) {
+	const lifetime = 5 * time.Second
+	results := make(chan network.GameResultInfo, 1)
+	gs := newTestSession(t, SessionOptions{ResultsChan: results})
+	spec := gs.Config.Troops["pawn"]
+	spec.LifetimeMs = int(lifetime / time.Millisecond)
+	gs.Config.Troops["pawn"] = spec
+	start := time.Now()
+	gs.mu.Lock()
+	startTestCombat(t, gs, start)
+	gs.Player1.CurrentMana = gs.Rules.MaxMana
+	gs.mu.Unlock()
+	deployAs(t, gs, gs.Player1.SessionToken, 1, "pawn")
+
+	gs.mu.Lock()
+	var troop *models.ActiveTroop
+	for _, troop = range gs.activeTroops {
+		troop.CurrentHP, troop.MaxHP = 1e9, 1e9 // Outlives the towers' attacks
+	}
+	for _, tower := range gs.Player2.Towers {
+		tower.CurrentHP += 1e9
+		tower.MaxHP += 1e9
+	}
+	troopID, expiresAt := troop.InstanceID, troop.ExpiresAt
+	if got := expiresAt.Sub(troop.DeployedAt); got != lifetime {
+		t.Errorf("troop expires %v after deployment, want %v", got, lifetime)
+	}
+	if left := gs.buildStateUpdate(troop.DeployedAt.Add(2*time.Second), false).ActiveTroops[troopID].ExpiresInMs; left != 3000 {
+		t.Errorf("state update 2s in shows %dms to live, want 3000", left)
+	}
+	gs.mu.Unlock()
+
+	expired := make(chan network.TroopExpiredEvent, 1)
+	defeated := make(chan network.TroopDefeatedEvent, 1)
+	bob := dialTestSession(t, gs, nil, gs.Player2.SessionToken, tcrclient.SessionConfig{
+		OnEvent: func(event network.GameEventUDP) {
+			switch details := event.Details.(type) {
+			case network.TroopExpiredEvent:
+				expired <- details
+			case network.TroopDefeatedEvent:
+				defeated <- details
+			}
+		},
+	})
+	if _, err := bob.Ping(2 * time.Second); err != nil { // Registers bob's address
+		t.Fatalf("Ping: %v", err)
+	}
+
+	onField := func() bool {
+		gs.mu.Lock()
+		defer gs.mu.Unlock()
+		_, active := gs.activeTroops[troopID]
+		_, deployed := gs.Player1.DeployedTroops[troopID]
+		_, timer := gs.lastTroopAttack[troopID]
+		if active != deployed || (timer && !active) {
+			t.Errorf("troop active %t, deployed %t, attack timer kept %t", active, deployed, timer)
+		}
+		return active
+	}
+	tickAt(t, gs, expiresAt.Add(-time.Millisecond))
+	if !onField() {
+		t.Fatal("troop left the field before its lifetime ran out")
+	}
+	tickAt(t, gs, expiresAt)
+	if onField() {
+		t.Fatal("troop still on the field once its lifetime ran out")
+	}
+	select {
+	case event := <-expired:
+		if event.TroopID != troopID || event.OwnerID != "alice" {
+			t.Errorf("expiry announced for %s owned by %s, want %s owned by alice", event.TroopID, event.OwnerID, troopID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no troop_expired event reached bob")
+	}
+	select {
+	case event := <-defeated:
+		t.Errorf("troop %s announced as defeated by %s", event.TroopID, event.DefeatedByTowerID)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := gs.ForceEnd("test over", ForceOutcomeDraw); err != nil {
+		t.Fatalf("ForceEnd: %v", err)
+	}
+	info := <-results
+	p1, p2 := info.Player1Result, info.Player2Result
+	if p2.EXPChange != p1.EXPChange {
+		t.Errorf("in a draw bob earned %d EXP and alice %d, want the same: the expiry is worth nothing", p2.EXPChange, p1.EXPChange)
+	}
+	for _, item := range p2.EXPBreakdown {
+		if item.Source != network.EXPSourceResult {
+			t.Errorf("bob was paid %d EXP for %q, want only the draw bonus", item.EXP, item.Label)
+		}
+	}
+}
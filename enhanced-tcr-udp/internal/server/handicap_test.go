@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"enhanced-tcr-udp/internal/game"
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// aliceHandicap is the handicap the tests give alice; bob plays without one.
+func aliceHandicap() models.PlayerOverrides {
+	mana := 8
+	return models.PlayerOverrides{StartingMana: &mana, ManaRegenMultiplier: 2, StatMultiplier: 2}
+}
+
+func handicapRules() *models.GameRules {
+	rules := models.DefaultGameRules()
+	rules.PerPlayerOverrides = map[string]models.PlayerOverrides{"alice": aliceHandicap()}
+	return &rules
+}
+
+func TestHandicapReachesTowersTroopsAndRegen(t *testing.T) {
+	gs := newTestSession(t, SessionOptions{Rules: handicapRules(), QueueType: network.QueueCasual})
+	rules := gs.Rules
+	levelMultiplier := game.LevelMultiplier(1, rules.LevelStatBonus)
+
+	if got := gs.Player1.CurrentMana; got != 8 {
+		t.Errorf("alice starts with %d mana, want 8", got)
+	}
+	if got := gs.Player2.CurrentMana; got != rules.StartingMana {
+		t.Errorf("bob starts with %d mana, want %d", got, rules.StartingMana)
+	}
+
+	for _, tc := range []struct {
+		player     *models.PlayerInGame
+		multiplier float64
+	}{
+		{gs.Player1, 2},
+		{gs.Player2, levelMultiplier},
+	} {
+		for _, tower := range tc.player.Towers {
+			want := game.ScaleStat(gs.Config.Towers[tower.SpecID].BaseHP, tc.multiplier)
+			if tower.MaxHP != want {
+				t.Errorf("%s's tower %s has %d HP, want %d", tc.player.Account.Username, tower.GameSpecificID, tower.MaxHP, want)
+			}
+		}
+	}
+
+	start := time.Now()
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	startTestCombat(t, gs, start)
+
+	// Deployed troops are scaled the same way
+	for seq, player := range []*models.PlayerInGame{gs.Player1, gs.Player2} {
+		gs.handlePlayerAction(network.UDPMessage{
+			Type:        network.UDPMsgTypeDeployTroop,
+			SessionID:   gs.ID,
+			PlayerToken: player.SessionToken,
+			Seq:         uint32(seq + 1),
+			Timestamp:   time.Now(),
+			Payload:     json.RawMessage(`{"troop_id":"pawn"}`),
+		})
+	}
+	pawnHP := gs.Config.Troops["pawn"].BaseHP
+	for _, tc := range []struct {
+		player     *models.PlayerInGame
+		multiplier float64
+	}{
+		{gs.Player1, 2},
+		{gs.Player2, levelMultiplier},
+	} {
+		if len(tc.player.DeployedTroops) != 1 {
+			t.Fatalf("%s has %d troops deployed, want 1", tc.player.Account.Username, len(tc.player.DeployedTroops))
+		}
+		for _, troop := range tc.player.DeployedTroops {
+			if want := game.ScaleStat(pawnHP, tc.multiplier); troop.MaxHP != want {
+				t.Errorf("%s's pawn has %d HP, want %d", tc.player.Account.Username, troop.MaxHP, want)
+			}
+		}
+	}
+
+	// Half a regen interval in, only alice, who regenerates twice as fast, has gained mana
+	gs.Player1.CurrentMana, gs.Player2.CurrentMana = 0, 0
+	gs.lastManaRegen["alice"], gs.lastManaRegen["bob"] = start, start
+	gs.mu.Unlock()
+	gs.tick(start.Add(rules.ManaRegenInterval / 2))
+	gs.mu.Lock()
+	if gs.Player1.CurrentMana != 1 || gs.Player2.CurrentMana != 0 {
+		t.Errorf("mana after half a regen interval: alice %d, bob %d; want 1 and 0", gs.Player1.CurrentMana, gs.Player2.CurrentMana)
+	}
+}
+
+func TestRankedGameDropsHandicaps(t *testing.T) {
+	gs := newTestSession(t, SessionOptions{Rules: handicapRules(), QueueType: network.QueueRanked})
+	if len(gs.Rules.PerPlayerOverrides) != 0 {
+		t.Errorf("ranked game kept handicaps %v", gs.Rules.PerPlayerOverrides)
+	}
+	if got, want := gs.Player1.CurrentMana, gs.Rules.StartingMana; got != want {
+		t.Errorf("alice starts a ranked game with %d mana, want %d", got, want)
+	}
+	want := game.ScaleStat(gs.Config.Towers[gs.Player1.Towers[0].SpecID].BaseHP, game.LevelMultiplier(1, gs.Rules.LevelStatBonus))
+	if got := gs.Player1.Towers[0].MaxHP; got != want {
+		t.Errorf("alice's tower has %d HP in a ranked game, want %d", got, want)
+	}
+}
+
+func TestAdminHandicap(t *testing.T) {
+	gsm := NewGameSessionManager()
+	admin := NewAdminConsole(gsm)
+
+	if out := admin.Execute("handicap alice starting-mana=8 regen=2 stats=2"); !strings.HasPrefix(out, "Handicap of alice set") {
+		t.Fatalf("setting a handicap: %q", out)
+	}
+	if got := gsm.Handicaps()["alice"]; got.String() != aliceHandicap().String() {
+		t.Errorf("alice's handicap = %s, want %s", got, aliceHandicap())
+	}
+	if out := admin.Execute("handicap alice stats=100"); !strings.HasPrefix(out, "Invalid handicap") {
+		t.Errorf("out-of-range handicap: %q", out)
+	}
+	if out := admin.Execute("handicap alice clear"); out != "Handicap of alice cleared." {
+		t.Errorf("clearing a handicap: %q", out)
+	}
+	if handicaps := gsm.Handicaps(); len(handicaps) != 0 {
+		t.Errorf("handicaps after clear = %v, want none", handicaps)
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"enhanced-tcr-udp/internal/game"
+	"enhanced-tcr-udp/internal/persistence"
+	"enhanced-tcr-udp/pkg/models"
+)
+
+// TestMain runs the package's tests from the module root, where the server finds its game
+// config, with player data in a temporary directory and a fixed seed for CRIT rolls.
+func TestMain(m *testing.M) {
+	if err := os.Chdir(filepath.Join("..", "..")); err != nil {
+		fmt.Fprintf(os.Stderr, "changing to the module root: %v\n", err)
+		os.Exit(1)
+	}
+	root, err := os.MkdirTemp("", "tcr-server-test-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "creating the data root: %v\n", err)
+		os.Exit(1)
+	}
+	persistence.SetDataRoot(root)
+	game.SeedRNG(1)
+	log.SetOutput(io.Discard) // Sessions log every attack
+	code := m.Run()
+	os.RemoveAll(root)
+	os.Exit(code)
+}
+
+// newTestSession creates a session between alice and bob, both level 1, listening on a free
+// loopback port. Fields of opts that are left empty get test defaults. The session is
+// stopped when the test ends; it is not started.
+func newTestSession(tb testing.TB, opts SessionOptions) *GameSession {
+	tb.Helper()
+	if opts.GameID == "" {
+		opts.GameID = "test-game"
+	}
+	if opts.Player1 == nil {
+		opts.Player1 = &models.PlayerAccount{Username: "alice", Level: 1}
+	}
+	if opts.Player2 == nil {
+		opts.Player2 = &models.PlayerAccount{Username: "bob", Level: 1}
+	}
+	if opts.Player1Token == "" {
+		opts.Player1Token = "token-" + opts.Player1.Username
+	}
+	if opts.Player2Token == "" {
+		opts.Player2Token = "token-" + opts.Player2.Username
+	}
+	if opts.UDPHost == "" {
+		opts.UDPHost = "127.0.0.1"
+	}
+	gs, err := NewGameSession(opts)
+	if err != nil {
+		tb.Fatalf("NewGameSession: %v", err)
+	}
+	tb.Cleanup(gs.Stop)
+	return gs
+}
+
+// udpSink returns the address of a loopback socket that reads and discards whatever is
+// sent to it until the test ends.
+func udpSink(tb testing.TB) *net.UDPAddr {
+	tb.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		tb.Fatalf("listening for UDP: %v", err)
+	}
+	tb.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			if _, _, err := conn.ReadFromUDP(buf); err != nil {
+				return
+			}
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+// startTestCombat skips the session's warm-up and countdown and starts combat at now, as if
+// both players had connected. The caller must hold gs.mu.
+func startTestCombat(tb testing.TB, gs *GameSession, now time.Time) {
+	tb.Helper()
+	if !gs.transition(StateWaitingForPlayers) || !gs.transition(StateCountdown) {
+		tb.Fatalf("session %s cannot start combat from state %s", gs.ID, gs.State())
+	}
+	gs.startCombat(now)
+}
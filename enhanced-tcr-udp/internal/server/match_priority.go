@@ -0,0 +1,52 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// matchPriorityTTL is how long a player keeps queue priority after a server-side failure
+// cost them a match. Priority also ends with their next successful match, so it cannot be
+// stockpiled.
+const matchPriorityTTL = 2 * time.Minute
+
+var (
+	// matchPriority maps usernames to the time their queue priority expires. It outlives the
+	// queue entry, so a player whose match failed after they were released from the queue
+	// still has priority when they search again.
+	matchPriority      = make(map[string]time.Time)
+	matchPriorityMutex = &sync.Mutex{}
+)
+
+// grantMatchPriority moves a player ahead of players without priority the next time they
+// wait for a match, for matchPriorityTTL. It returns the new expiry.
+func grantMatchPriority(username string) time.Time {
+	until := time.Now().Add(matchPriorityTTL)
+	matchPriorityMutex.Lock()
+	matchPriority[username] = until
+	matchPriorityMutex.Unlock()
+	return until
+}
+
+// matchPriorityUntil returns when a player's queue priority expires, or the zero time if
+// they have none.
+func matchPriorityUntil(username string) time.Time {
+	matchPriorityMutex.Lock()
+	defer matchPriorityMutex.Unlock()
+	until, ok := matchPriority[username]
+	if !ok {
+		return time.Time{}
+	}
+	if !time.Now().Before(until) {
+		delete(matchPriority, username)
+		return time.Time{}
+	}
+	return until
+}
+
+// clearMatchPriority ends a player's queue priority once they have been matched.
+func clearMatchPriority(username string) {
+	matchPriorityMutex.Lock()
+	delete(matchPriority, username)
+	matchPriorityMutex.Unlock()
+}
@@ -2,13 +2,17 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"enhanced-tcr-udp/internal/models"
-	"enhanced-tcr-udp/internal/network"
+	"enhanced-tcr-udp/internal/persistence"
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
 
 	// "enhanced-tcr-udp/internal/game" // For GameSession creation later
 	"github.com/google/uuid" // For generating unique Game IDs
@@ -18,171 +22,635 @@ import (
 type PlayerQueueEntry struct {
 	PlayerAccount     *models.PlayerAccount
 	Connection        net.Conn
+	Decoder           *json.Decoder // Reads the player's messages on Connection during the game; may be nil
+	ProtocolVersion   int           // Negotiated at login; decides how the game config is delivered
+	QueueType         string        // network.QueueRanked or network.QueueCasual; players are only matched within one queue
+	PreferredDuration time.Duration // Game duration the player asked for, one of the rules' DurationChoices; 0 for any
 	RequestTime       time.Time
+	PriorityUntil     time.Time     // Until then the player is matched ahead of others; zero if they have no priority
 	MatchedChan       chan struct{} // Closed when the player is matched and notified
 	GameConcludedChan chan struct{} // Closed when game results processing is done for this player connection
+
+	connMu sync.Mutex // Guards Connection once the game has started; see resultsConnection
+}
+
+// resultsConnection returns the connection the player's game results go to: the one they
+// queued on, or the one they rejoined the game on since.
+func (e *PlayerQueueEntry) resultsConnection() net.Conn {
+	e.connMu.Lock()
+	defer e.connMu.Unlock()
+	return e.Connection
+}
+
+// setResultsConnection sends the player's game results to conn instead, after they rejoined.
+func (e *PlayerQueueEntry) setResultsConnection(conn net.Conn) {
+	e.connMu.Lock()
+	e.Connection = conn
+	e.connMu.Unlock()
+}
+
+// hasPriority reports whether the player still has queue priority at now.
+func (e *PlayerQueueEntry) hasPriority(now time.Time) bool {
+	return now.Before(e.PriorityUntil)
 }
 
 var (
-	matchmakingQueue = make(chan *PlayerQueueEntry, 1) // Changed buffer size from 2 to 1
-	queueMutex       = &sync.Mutex{}
+	// waitingPlayers holds, per queue type, the players waiting for an opponent in the order
+	// they arrived. A player arriving while their queue is empty waits there; otherwise they
+	// are paired at once. A queue only holds more than one player after a failed match puts
+	// someone back.
+	waitingPlayers = make(map[string][]*PlayerQueueEntry)
+	queueMutex     = &sync.Mutex{} // Guards waitingPlayers
+	// deadEntriesEvicted counts the queue entries dropped since startup because their
+	// player's connection had died while they waited; see evictDeadWaitingPlayers.
+	deadEntriesEvicted atomic.Int64
 	// nextUDPPort can be managed by SessionManager or a global counter for simplicity in Sprint 1
-	currentUDPPort = 8081 // Starting UDP port, to be incremented
+	currentUDPPort = DefaultUDPPortMin // Next UDP port to hand out, within the configured range
 	portMutex      = &sync.Mutex{}
 	// Global instance of GameSessionManager
 	GlobalSessionManager = NewGameSessionManager()
 )
 
 // GetNextUDPPort provides a simple way to get unique UDP ports for game sessions.
+// Ports come from the configured range and wrap around at its end.
 func GetNextUDPPort() int {
+	cfg := CurrentNetworkConfig()
 	portMutex.Lock()
 	defer portMutex.Unlock()
+	if currentUDPPort < cfg.UDPPortMin || currentUDPPort > cfg.UDPPortMax {
+		currentUDPPort = cfg.UDPPortMin
+	}
 	port := currentUDPPort
 	currentUDPPort++
 	return port
 }
 
-// HandleMatchmakingRequest handles a client's request to find a match.
-func HandleMatchmakingRequest(conn net.Conn, player *models.PlayerAccount) {
-	log.Printf("Player %s entered matchmaking.", player.Username)
+// HandleMatchmakingRequest handles a client's request to find a match in the given queue
+// (network.QueueRanked or network.QueueCasual). protocolVersion is the version negotiated
+// with the client at login. A player with a preferredDuration is paired with players asking
+// for the same one (or for none) until they have waited the rules' DurationWidenAfter, and
+// with anyone in their queue after that.
+func HandleMatchmakingRequest(conn net.Conn, decoder *json.Decoder, player *models.PlayerAccount, protocolVersion int, queueType string, preferredDuration time.Duration) {
+	if session, ok := GlobalSessionManager.FindPlayerSession(player.Username); ok {
+		log.Printf("Player %s asked for matchmaking while still in game %s. Refusing.", player.Username, session.ID)
+		if err := notifyAlreadyInGame(conn, session.ID); err != nil {
+			log.Printf("Error sending MatchSetupFailed to %s: %v", player.Username, err)
+		}
+		return
+	}
+	if preferredDuration != 0 {
+		log.Printf("Player %s entered %s matchmaking, asking for a %v game.", player.Username, queueType, preferredDuration)
+	} else {
+		log.Printf("Player %s entered %s matchmaking.", player.Username, queueType)
+	}
+	// P2 goes back to searching, ahead of others, each time the waiting player they were
+	// paired with turns out to be unreachable
+	for searchForMatch(conn, decoder, player, protocolVersion, queueType, preferredDuration) {
+		log.Printf("Player %s is searching again.", player.Username)
+	}
+}
+
+// searchForMatch puts player in the queue, or pairs them with a player waiting there, and
+// returns once their game has concluded or their match has fallen through. It returns true
+// if the waiting player they were paired with could not be told about the match, in which
+// case the player was not told either and should search again.
+func searchForMatch(conn net.Conn, decoder *json.Decoder, player *models.PlayerAccount, protocolVersion int, queueType string, preferredDuration time.Duration) bool {
+	rules := GlobalSessionManager.Rules()
 
 	queueEntry := &PlayerQueueEntry{
 		PlayerAccount:     player,
 		Connection:        conn,
+		Decoder:           decoder,
+		ProtocolVersion:   protocolVersion,
+		QueueType:         queueType,
+		PreferredDuration: preferredDuration,
 		RequestTime:       time.Now(),
+		PriorityUntil:     matchPriorityUntil(player.Username),
 		MatchedChan:       make(chan struct{}), // Initialize the notification channel
 		GameConcludedChan: make(chan struct{}), // Initialize the game concluded channel
 	}
+	if queueEntry.hasPriority(queueEntry.RequestTime) {
+		log.Printf("Player %s has queue priority until %s after a failed match.", player.Username, queueEntry.PriorityUntil.Format(time.TimeOnly))
+	}
 
-	select {
-	case matchmakingQueue <- queueEntry: // This is the first player entering the queue
-		log.Printf("Player %s is waiting in queue. Connection will be held open.", player.Username)
-		// Wait for this player to be matched and notified.
-		<-queueEntry.MatchedChan
-		log.Printf("Player %s has been matched and notified. Now waiting for game to conclude before closing TCP.", player.Username)
-		<-queueEntry.GameConcludedChan // Wait for game results to be processed for this player
-		log.Printf("Player %s game has concluded. Completing HandleMatchmakingRequest.", player.Username)
-		return
-
-	default: // This is the second player; queue was full (P1 was waiting)
-		queueMutex.Lock()
-		select {
-		case waitingPlayer := <-matchmakingQueue: // Retrieve P1 (waitingPlayer)
-			queueMutex.Unlock()
-			log.Printf("Matching %s with %s", waitingPlayer.PlayerAccount.Username, player.Username)
-			gameID := uuid.New().String()
-			udpPort := GetNextUDPPort()
-
-			resultsChan := make(chan network.GameResultInfo, 1)
-
-			gameSession := GlobalSessionManager.CreateSession(gameID, waitingPlayer.PlayerAccount, player, udpPort, resultsChan)
-			if gameSession == nil {
-				log.Printf("Failed to create game session for %s and %s.", waitingPlayer.PlayerAccount.Username, player.Username)
-				matchmakingQueue <- waitingPlayer // Put P1 back
-				// For P2 (current player), their HandleMatchmakingRequest will simply return, and conn will be closed by server.go
-				// We should also signal P2 that their game setup failed more explicitly if possible.
-				close(queueEntry.GameConcludedChan) // Allow P2's handler to complete without error
-				return
-			}
+	queueMutex.Lock()
+	// The same account searching twice (a second login racing the first, or a takeover)
+	// must not be matched against itself. The earlier entry is the stale one: it is told
+	// why and released, and the newer one takes its place.
+	stale := removeWaitingPlayer(player.Username)
+	dead := evictDeadWaitingPlayers(queueType)
+	waitingPlayer := takeWaitingPlayer(queueEntry, time.Now(), rules.DurationWidenAfter)
+	if waitingPlayer == nil { // No one to pair with: wait for the next player
+		waitingPlayers[queueType] = append(waitingPlayers[queueType], queueEntry)
+	}
+	queueMutex.Unlock()
+	releaseDeadEntries(dead)
 
-			log.Printf("Match found: %s vs %s. GameID: %s, UDP Port: %d. Session created.", waitingPlayer.PlayerAccount.Username, player.Username, gameID, udpPort)
-			go handleGameResults(resultsChan, waitingPlayer, queueEntry, gameID) // Pass queueEntry for P2
+	for _, entry := range stale {
+		log.Printf("Player %s is already waiting in the queue. Replacing the earlier entry with the new request.", player.Username)
+		notifyMatchSetupFailed(entry.Connection, entry.PlayerAccount, ErrSamePlayer, false, false, false)
+		releaseQueueEntry(entry)
+	}
 
-			notifyMatch(waitingPlayer.Connection, waitingPlayer.PlayerAccount, player, gameID, udpPort, true, gameSession.Config)
-			notifyMatch(conn, player, waitingPlayer.PlayerAccount, gameID, udpPort, false, gameSession.Config)
+	if waitingPlayer == nil {
+		log.Printf("Player %s is waiting in the %s queue. Connection will be held open.", player.Username, queueType)
+		// Wait for this player to be matched and notified, unless they end up pairing
+		// with another waiting player themselves.
+		waitingPlayer = waitForMatch(queueEntry, rules.DurationWidenAfter)
+		if waitingPlayer == nil {
+			log.Printf("Player %s has been matched and notified. Now waiting for game to conclude before closing TCP.", player.Username)
+			<-queueEntry.GameConcludedChan // Wait for game results to be processed for this player
+			log.Printf("Player %s game has concluded. Completing HandleMatchmakingRequest.", player.Username)
+			return false
+		}
+		log.Printf("Player %s found no one wanting a %v game within %v. Widening the search.", player.Username, preferredDuration, rules.DurationWidenAfter)
+	}
 
-			log.Printf("Closing MatchedChan for waiting player %s to allow their handler to proceed with game conclusion wait.", waitingPlayer.PlayerAccount.Username)
-			close(waitingPlayer.MatchedChan)
+	// This is the second player: pair them with the one taken from the queue (P1)
+	rules.GameDuration = agreedDuration(waitingPlayer, queueEntry, rules)
+	log.Printf("Matching %s with %s (%s, %v)", waitingPlayer.PlayerAccount.Username, player.Username, queueType, rules.GameDuration)
+	gameID := uuid.New().String()
+	udpPort := GetNextUDPPort()
 
-			// P2's (current player, queueEntry) HandleMatchmakingRequest also waits for game conclusion.
-			log.Printf("Player %s (P2) is now waiting for game to conclude before closing TCP.", queueEntry.PlayerAccount.Username)
-			<-queueEntry.GameConcludedChan
-			log.Printf("Player %s (P2) game has concluded. Completing HandleMatchmakingRequest.", queueEntry.PlayerAccount.Username)
-			return
+	resultsChan := make(chan network.GameResultInfo, 1)
 
-		default: // Should ideally not be reached
-			queueMutex.Unlock()
-			log.Printf("Error in matchmaking: queue was full but no waiting player found. %s is being added to queue.", player.Username)
-			matchmakingQueue <- queueEntry
-			<-queueEntry.MatchedChan
-			log.Printf("Player %s (who was re-queued) has been matched. Waiting for game conclusion.", player.Username)
-			<-queueEntry.GameConcludedChan
-			log.Printf("Player %s (who was re-queued) game has concluded. Completing HandleMatchmakingRequest.", player.Username)
-			return
+	gameSession, err := GlobalSessionManager.CreateSession(SessionOptions{
+		GameID:          gameID,
+		Player1:         waitingPlayer.PlayerAccount,
+		Player2:         player,
+		Player1Protocol: waitingPlayer.ProtocolVersion,
+		Player2Protocol: protocolVersion,
+		UDPHost:         CurrentNetworkConfig().UDPListenHost,
+		UDPPort:         udpPort,
+		ResultsChan:     resultsChan,
+		Rules:           &rules,
+		QueueType:       queueType,
+	})
+	if err != nil {
+		log.Printf("Failed to create game session for %s and %s: %v", waitingPlayer.PlayerAccount.Username, player.Username, err)
+		// A bind failure may not recur on the next port, so P1 keeps its place in the queue.
+		// A config failure will fail every match, so both players are released.
+		// Neither player is at fault, so both are matched ahead of others next time.
+		retryable := errors.Is(err, ErrSessionUDP)
+		grantMatchPriority(player.Username)
+		notifyMatchSetupFailed(waitingPlayer.Connection, waitingPlayer.PlayerAccount, err, retryable, retryable, true)
+		notifyMatchSetupFailed(conn, player, err, retryable, false, true)
+		if retryable {
+			requeuePlayer(waitingPlayer)
+		} else {
+			grantMatchPriority(waitingPlayer.PlayerAccount.Username)
+			releaseQueueEntry(waitingPlayer)
 		}
+		// P2's (current player) HandleMatchmakingRequest simply returns, and conn will be closed by server.go
+		return false
+	}
+
+	log.Printf("Match found: %s vs %s. GameID: %s, UDP Port: %d. Session created.", waitingPlayer.PlayerAccount.Username, player.Username, gameID, udpPort)
+
+	// The waiting player is told first: their connection sat idle in the queue and is the likelier one to be dead.
+	if err := notifyMatch(waitingPlayer.Connection, waitingPlayer.PlayerAccount, player, gameID, udpPort, true, gameSession.Player1.SessionToken, gameSession.Config, waitingPlayer.ProtocolVersion, queueType, rules.GameDuration, gameSession.Rules.PerPlayerOverrides); err != nil {
+		log.Printf("Waiting player %s is unreachable (%v). Cancelling game %s and searching again for %s.", waitingPlayer.PlayerAccount.Username, err, gameID, player.Username)
+		abortMatch(gameSession, "player1 unreachable")
+		releaseQueueEntry(waitingPlayer)
+		// P2 was never told about this match, so it simply goes back to searching, ahead of others
+		grantMatchPriority(player.Username)
+		return true
+	}
+	if err := notifyMatch(conn, player, waitingPlayer.PlayerAccount, gameID, udpPort, false, gameSession.Player2.SessionToken, gameSession.Config, protocolVersion, queueType, rules.GameDuration, gameSession.Rules.PerPlayerOverrides); err != nil {
+		log.Printf("Player %s is unreachable (%v). Cancelling game %s already announced to %s.", player.Username, err, gameID, waitingPlayer.PlayerAccount.Username)
+		abortMatch(gameSession, "player2 unreachable")
+		grantMatchPriority(waitingPlayer.PlayerAccount.Username)
+		notifyMatchCancelled(waitingPlayer.Connection, waitingPlayer.PlayerAccount, gameID, "opponent disconnected before the game started", true)
+		releaseQueueEntry(waitingPlayer)
+		close(queueEntry.GameConcludedChan)
+		return false
 	}
+	clearMatchPriority(waitingPlayer.PlayerAccount.Username)
+	clearMatchPriority(player.Username)
+	registerInGame(gameSession, waitingPlayer, queueEntry)
+	cancelled := make(chan struct{}) // Closed if the match is called off once under way
+	go watchGameMessages(gameSession, waitingPlayer, queueEntry, cancelled)
+	go watchGameMessages(gameSession, queueEntry, waitingPlayer, cancelled)
+	go handleGameResults(resultsChan, cancelled, waitingPlayer, queueEntry, gameID) // Pass queueEntry for P2
+
+	log.Printf("Closing MatchedChan for waiting player %s to allow their handler to proceed with game conclusion wait.", waitingPlayer.PlayerAccount.Username)
+	close(waitingPlayer.MatchedChan)
+
+	// P2's (current player, queueEntry) HandleMatchmakingRequest also waits for game conclusion.
+	log.Printf("Player %s (P2) is now waiting for game to conclude before closing TCP.", queueEntry.PlayerAccount.Username)
+	<-queueEntry.GameConcludedChan
+	log.Printf("Player %s (P2) game has concluded. Completing HandleMatchmakingRequest.", queueEntry.PlayerAccount.Username)
+	return false
 }
 
 // handleGameResults waits for results from a game session and sends them to players via TCP.
-func handleGameResults(resultsChan <-chan network.GameResultInfo, p1Entry *PlayerQueueEntry, p2Entry *PlayerQueueEntry, gameID string) {
+// If cancelled is closed first, the match was called off and its players already told why.
+// Each player's results are delivered on their own (see deliverGameResults), so a player
+// whose connection is gone cannot hold up or stop the delivery to the other.
+func handleGameResults(resultsChan <-chan network.GameResultInfo, cancelled <-chan struct{}, p1Entry *PlayerQueueEntry, p2Entry *PlayerQueueEntry, gameID string) {
 	log.Printf("[GameID: %s] Goroutine started to handle game results for %s and %s.", gameID, p1Entry.PlayerAccount.Username, p2Entry.PlayerAccount.Username)
-	defer func() {
-		log.Printf("[GameID: %s] Closing GameConcludedChan for %s.", gameID, p1Entry.PlayerAccount.Username)
-		close(p1Entry.GameConcludedChan)
-		log.Printf("[GameID: %s] Closing GameConcludedChan for %s.", gameID, p2Entry.PlayerAccount.Username)
-		close(p2Entry.GameConcludedChan)
-		log.Printf("[GameID: %s] Goroutine for handling game results finished for %s and %s.", gameID, p1Entry.PlayerAccount.Username, p2Entry.PlayerAccount.Username)
-	}()
+	defer log.Printf("[GameID: %s] Goroutine for handling game results finished for %s and %s.", gameID, p1Entry.PlayerAccount.Username, p2Entry.PlayerAccount.Username)
 
+	var resultInfo network.GameResultInfo
+	ok := false
 	select {
-	case resultInfo, ok := <-resultsChan:
+	case resultInfo, ok = <-resultsChan:
 		if !ok {
 			log.Printf("[GameID: %s] Results channel closed prematurely for %s and %s.", gameID, p1Entry.PlayerAccount.Username, p2Entry.PlayerAccount.Username)
+		}
+	case <-cancelled:
+		log.Printf("[GameID: %s] Match was cancelled; there are no results for %s and %s.", gameID, p1Entry.PlayerAccount.Username, p2Entry.PlayerAccount.Username)
+	case <-time.After(10 * time.Minute): // Timeout if game session never sends results (e.g. crash)
+		log.Printf("[GameID: %s] Timeout waiting for game results from session for %s and %s.", gameID, p1Entry.PlayerAccount.Username, p2Entry.PlayerAccount.Username)
+	}
+	unregisterInGame(p1Entry, p2Entry)
+	if !ok {
+		concludeGame(p1Entry, gameID)
+		concludeGame(p2Entry, gameID)
+		return
+	}
+
+	log.Printf("[GameID: %s] Received game results: P1(%s): %s, P2(%s): %s, Winner: %s, Reason: %s",
+		gameID, resultInfo.Player1Username, resultInfo.Player1Result.Outcome,
+		resultInfo.Player2Username, resultInfo.Player2Result.Outcome,
+		resultInfo.OverallWinnerID, resultInfo.GameEndReason)
+
+	var wg sync.WaitGroup
+	statuses := make([]resultsDelivery, 2)
+	for i, delivery := range []struct {
+		entry   *PlayerQueueEntry
+		results network.GameOverResults
+	}{
+		{p1Entry, resultInfo.Player1Result},
+		{p2Entry, resultInfo.Player2Result},
+	} {
+		wg.Add(1)
+		go func(i int, entry *PlayerQueueEntry, results network.GameOverResults) {
+			defer wg.Done()
+			statuses[i] = deliverGameResults(entry, results, gameID)
+		}(i, delivery.entry, delivery.results)
+	}
+	wg.Wait()
+	log.Printf("[GameID: %s] Results for %s %s; for %s %s.", gameID, p1Entry.PlayerAccount.Username, statuses[0], p2Entry.PlayerAccount.Username, statuses[1])
+	// Note: The TCP connections (p1Entry.Connection, p2Entry.Connection) themselves are managed by their respective
+	// handleConnection goroutines in server.go. Closing a player's GameConcludedChan unblocks their
+	// HandleMatchmakingRequest, which returns so that the connection is closed.
+}
+
+// resultsDelivery is what became of one player's game results.
+type resultsDelivery int
+
+const (
+	resultsDelivered   resultsDelivery = iota // Sent on the player's connection
+	resultsPending                            // Not sent; stored for the player's next login
+	resultsUndelivered                        // Neither sent nor stored
+)
+
+// String describes the delivery for the log, e.g. "stored as pending".
+func (d resultsDelivery) String() string {
+	switch d {
+	case resultsDelivered:
+		return "delivered"
+	case resultsPending:
+		return "stored as pending"
+	}
+	return "lost"
+}
+
+// deliverGameResults sends a player their game results, storing them for the player's next
+// login if that fails, and then closes the player's GameConcludedChan whatever happened,
+// even if the attempt panicked.
+func deliverGameResults(entry *PlayerQueueEntry, results network.GameOverResults, gameID string) (status resultsDelivery) {
+	username := entry.PlayerAccount.Username
+	status = resultsUndelivered
+	defer concludeGame(entry, gameID)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[GameID: %s] Panic while sending GameOverResults to %s: %v. Storing them for the player's next login.", gameID, username, r)
+			status = storePendingResults(username, results, gameID)
+		}
+	}()
+
+	if err := writeTCPMessage(entry.resultsConnection(), network.TCPMessage{Type: network.MsgTypeGameOverResults, Payload: results}); err != nil {
+		log.Printf("[GameID: %s] Error sending GameOverResults to %s: %v. Storing them for the player's next login.", gameID, username, err)
+		return storePendingResults(username, results, gameID)
+	}
+	log.Printf("[GameID: %s] Sent GameOverResults to %s.", gameID, username)
+	return resultsDelivered
+}
+
+// storePendingResults keeps results that could not be sent for the player's next login.
+func storePendingResults(username string, results network.GameOverResults, gameID string) resultsDelivery {
+	if err := persistence.SavePendingResult(username, results); err != nil {
+		log.Printf("[GameID: %s] Error storing pending results for %s: %v", gameID, username, err)
+		return resultsUndelivered
+	}
+	return resultsPending
+}
+
+// concludeGame closes a player's GameConcludedChan, releasing their HandleMatchmakingRequest.
+func concludeGame(entry *PlayerQueueEntry, gameID string) {
+	log.Printf("[GameID: %s] Closing GameConcludedChan for %s.", gameID, entry.PlayerAccount.Username)
+	close(entry.GameConcludedChan)
+}
+
+// abortMatch tears down a session that cannot be played, e.g. because its players could not
+// both be told about it. It reports whether this call aborted it.
+func abortMatch(gameSession *GameSession, reason string) bool {
+	aborted := gameSession.Abort(reason)
+	GlobalSessionManager.RemoveSession(gameSession.ID)
+	return aborted
+}
+
+// watchGameMessages reads what a player sends over TCP while their game runs, until the
+// connection closes. A player who reports with MsgTypeUDPUnreachable that they cannot reach
+// the game's UDP port would leave the opponent in a game that never starts, so the match is
+// cancelled for both and cancelled closed. Players speaking a protocol version before
+// network.ProtocolVersionUDPUnreachable send nothing during the game and are not read.
+func watchGameMessages(gameSession *GameSession, entry, opponent *PlayerQueueEntry, cancelled chan<- struct{}) {
+	if entry.Decoder == nil || entry.ProtocolVersion < network.ProtocolVersionUDPUnreachable {
+		return
+	}
+	for {
+		var msg inboundTCPMessage
+		if err := entry.Decoder.Decode(&msg); err != nil {
+			return // The connection closed: the game is over, or the player left
+		}
+		switch msg.Type {
+		case network.MsgTypeUDPUnreachable:
+			var report network.UDPUnreachable
+			if err := json.Unmarshal(msg.Payload, &report); err != nil {
+				log.Printf("Malformed UDP unreachable report from %s (%v). Cancelling game %s anyway.", entry.PlayerAccount.Username, err, gameSession.ID)
+			}
+			log.Printf("Player %s got no answer from UDP port %d of game %s after %d pings. Cancelling the match.", entry.PlayerAccount.Username, gameSession.udpPort, gameSession.ID, report.Attempts)
+			if !abortMatch(gameSession, "udp unreachable for "+entry.PlayerAccount.Username) {
+				log.Printf("Game %s was already over when %s reported it unreachable.", gameSession.ID, entry.PlayerAccount.Username)
+				return
+			}
+			// The opponent is not at fault, so they are matched ahead of others next time
+			grantMatchPriority(opponent.PlayerAccount.Username)
+			notifyMatchCancelled(entry.resultsConnection(), entry.PlayerAccount, gameSession.ID, fmt.Sprintf("UDP port %d of the game server is unreachable from your network", gameSession.udpPort), false)
+			notifyMatchCancelled(opponent.resultsConnection(), opponent.PlayerAccount, gameSession.ID, "your opponent could not reach the game server", true)
+			close(cancelled)
 			return
+		default:
+			log.Printf("Ignoring unexpected %q message from %s during game %s.", msg.Type, entry.PlayerAccount.Username, gameSession.ID)
 		}
+	}
+}
 
-		log.Printf("[GameID: %s] Received game results: P1(%s): %s, P2(%s): %s, Winner: %s, Reason: %s",
-			gameID, resultInfo.Player1Username, resultInfo.Player1Result.Outcome,
-			resultInfo.Player2Username, resultInfo.Player2Result.Outcome,
-			resultInfo.OverallWinnerID, resultInfo.GameEndReason)
+// takeWaitingPlayer removes and returns the player in seeker's queue to pair with seeker,
+// among those whose duration preference is compatible with theirs (see durationsCompatible):
+// the first with queue priority at now, else the one waiting longest who asked for the same
+// duration, else the one waiting longest. Seeker itself, if queued, is skipped. It returns
+// nil if there is no one to pair with. queueMutex must be held.
+func takeWaitingPlayer(seeker *PlayerQueueEntry, now time.Time, widenAfter time.Duration) *PlayerQueueEntry {
+	queue := waitingPlayers[seeker.QueueType]
+	pick, sameDuration := -1, false
+	for i, entry := range queue {
+		if entry == seeker || !durationsCompatible(entry, seeker, now, widenAfter) {
+			continue
+		}
+		if entry.hasPriority(now) {
+			pick = i
+			break
+		}
+		if pick < 0 || (!sameDuration && entry.PreferredDuration == seeker.PreferredDuration) {
+			pick, sameDuration = i, entry.PreferredDuration == seeker.PreferredDuration
+		}
+	}
+	if pick < 0 {
+		return nil
+	}
+	entry := queue[pick]
+	waitingPlayers[seeker.QueueType] = append(queue[:pick], queue[pick+1:]...)
+	return entry
+}
 
-		// Send results to Player 1 (waitingPlayer)
-		msgP1 := network.TCPMessage{
-			Type:    network.MsgTypeGameOverResults,
-			Payload: resultInfo.Player1Result,
+// durationsCompatible reports whether two players may be paired given the game durations
+// they asked for: the same one, or none by either of them. Once one of them has waited
+// widenAfter since their request, any durations are.
+func durationsCompatible(a, b *PlayerQueueEntry, now time.Time, widenAfter time.Duration) bool {
+	if a.PreferredDuration == b.PreferredDuration || a.PreferredDuration == 0 || b.PreferredDuration == 0 {
+		return true
+	}
+	return now.Sub(a.RequestTime) >= widenAfter || now.Sub(b.RequestTime) >= widenAfter
+}
+
+// agreedDuration returns how long a game between two players lasts: the duration they both
+// asked for, or the one only one of them asked for. Players who asked for different ones
+// were paired after widening the search and get rules.GameDuration.
+func agreedDuration(a, b *PlayerQueueEntry, rules models.GameRules) time.Duration {
+	switch {
+	case a.PreferredDuration == b.PreferredDuration && a.PreferredDuration != 0:
+		return a.PreferredDuration
+	case a.PreferredDuration == 0 && b.PreferredDuration != 0:
+		return b.PreferredDuration
+	case b.PreferredDuration == 0 && a.PreferredDuration != 0:
+		return a.PreferredDuration
+	}
+	return rules.GameDuration
+}
+
+// waitForMatch blocks a queued player until a player arriving after them is paired with
+// them, and then returns nil. A player with a duration preference still queued after
+// widenAfter instead looks for anyone else waiting in their queue; one found is removed
+// from the queue along with them and returned, and the caller starts the match with them.
+func waitForMatch(entry *PlayerQueueEntry, widenAfter time.Duration) *PlayerQueueEntry {
+	if entry.PreferredDuration != 0 && widenAfter > 0 {
+		timer := time.NewTimer(widenAfter)
+		select {
+		case <-entry.MatchedChan:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		queueMutex.Lock()
+		var partner *PlayerQueueEntry
+		dead := evictDeadWaitingPlayers(entry.QueueType) // Possibly entry itself, which releases it
+		if isWaiting(entry) {
+			if partner = takeWaitingPlayer(entry, time.Now(), widenAfter); partner != nil {
+				removeWaitingPlayer(entry.PlayerAccount.Username)
+			}
 		}
-		if err := json.NewEncoder(p1Entry.Connection).Encode(msgP1); err != nil {
-			log.Printf("[GameID: %s] Error sending GameOverResults to %s: %v", gameID, p1Entry.PlayerAccount.Username, err)
+		queueMutex.Unlock()
+		releaseDeadEntries(dead)
+		if partner != nil {
+			return partner
+		}
+	}
+	<-entry.MatchedChan
+	return nil
+}
+
+// evictDeadWaitingPlayers removes and returns the players in a queue whose connection has
+// died while they waited, so that no one is paired with them. The caller releases them
+// with releaseDeadEntries once queueMutex is unlocked. queueMutex must be held.
+func evictDeadWaitingPlayers(queueType string) []*PlayerQueueEntry {
+	var dead []*PlayerQueueEntry
+	queue := waitingPlayers[queueType]
+	kept := queue[:0]
+	for _, entry := range queue {
+		if connAlive(entry.Connection) {
+			kept = append(kept, entry)
 		} else {
-			log.Printf("[GameID: %s] Sent GameOverResults to %s.", gameID, p1Entry.PlayerAccount.Username)
+			dead = append(dead, entry)
 		}
+	}
+	waitingPlayers[queueType] = kept
+	return dead
+}
 
-		// Send results to Player 2 (currentPlayer in HandleMatchmakingRequest context)
-		msgP2 := network.TCPMessage{
-			Type:    network.MsgTypeGameOverResults,
-			Payload: resultInfo.Player2Result,
+// releaseDeadEntries ends the matchmaking of players evicted by evictDeadWaitingPlayers,
+// so their handlers return and their connections are closed.
+func releaseDeadEntries(dead []*PlayerQueueEntry) {
+	for _, entry := range dead {
+		total := deadEntriesEvicted.Add(1)
+		log.Printf("Player %s disconnected while waiting in the %s queue (after %v). Removed from the queue (%d dead entries evicted since startup).",
+			entry.PlayerAccount.Username, entry.QueueType, time.Since(entry.RequestTime).Round(time.Second), total)
+		releaseQueueEntry(entry)
+	}
+}
+
+// MatchmakingMetrics are the matchmaking queues' counters, as the admin console's metrics
+// command reports them.
+type MatchmakingMetrics struct {
+	Waiting            map[string]int // Players waiting now, by queue type
+	DeadEntriesEvicted int64          // Entries dropped since startup because the player's connection died while waiting
+}
+
+// CurrentMatchmakingMetrics returns the matchmaking counters as they stand.
+func CurrentMatchmakingMetrics() MatchmakingMetrics {
+	metrics := MatchmakingMetrics{Waiting: make(map[string]int), DeadEntriesEvicted: deadEntriesEvicted.Load()}
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	for _, queueType := range []string{network.QueueRanked, network.QueueCasual} {
+		metrics.Waiting[queueType] = len(waitingPlayers[queueType])
+	}
+	return metrics
+}
+
+// isWaiting reports whether entry is still in its queue. queueMutex must be held.
+func isWaiting(entry *PlayerQueueEntry) bool {
+	for _, queued := range waitingPlayers[entry.QueueType] {
+		if queued == entry {
+			return true
 		}
-		if err := json.NewEncoder(p2Entry.Connection).Encode(msgP2); err != nil {
-			log.Printf("[GameID: %s] Error sending GameOverResults to %s: %v", gameID, p2Entry.PlayerAccount.Username, err)
-		} else {
-			log.Printf("[GameID: %s] Sent GameOverResults to %s.", gameID, p2Entry.PlayerAccount.Username)
+	}
+	return false
+}
+
+// removeWaitingPlayer removes and returns the given player's entries from every queue.
+// queueMutex must be held.
+func removeWaitingPlayer(username string) []*PlayerQueueEntry {
+	var removed []*PlayerQueueEntry
+	for queueType, queue := range waitingPlayers {
+		kept := queue[:0]
+		for _, entry := range queue {
+			if entry.PlayerAccount.Username == username {
+				removed = append(removed, entry)
+			} else {
+				kept = append(kept, entry)
+			}
 		}
+		waitingPlayers[queueType] = kept
+	}
+	return removed
+}
 
-	case <-time.After(10 * time.Minute): // Timeout if game session never sends results (e.g. crash)
-		log.Printf("[GameID: %s] Timeout waiting for game results from session for %s and %s.", gameID, p1Entry.PlayerAccount.Username, p2Entry.PlayerAccount.Username)
+// requeuePlayer puts a waiting player whose match fell through back in the queue, with
+// priority over the players without it. They are paired with the next player to arrive.
+func requeuePlayer(entry *PlayerQueueEntry) {
+	until := grantMatchPriority(entry.PlayerAccount.Username)
+	queueMutex.Lock()
+	entry.PriorityUntil = until
+	waitingPlayers[entry.QueueType] = append(waitingPlayers[entry.QueueType], entry)
+	queueMutex.Unlock()
+	log.Printf("Player %s is back in the queue with priority until %s.", entry.PlayerAccount.Username, until.Format(time.TimeOnly))
+}
+
+// releaseQueueEntry unblocks a queued player's HandleMatchmakingRequest when its match falls
+// through, so the handler returns and the connection is closed.
+func releaseQueueEntry(entry *PlayerQueueEntry) {
+	close(entry.MatchedChan)
+	close(entry.GameConcludedChan)
+}
+
+// notifyMatchSetupFailed tells a player that their match could not be set up.
+func notifyMatchSetupFailed(conn net.Conn, player *models.PlayerAccount, cause error, retryable, requeued, prioritized bool) {
+	msg := network.TCPMessage{
+		Type: network.MsgTypeMatchSetupFailed,
+		Payload: network.MatchSetupFailed{
+			Reason:      cause.Error(),
+			Retryable:   retryable,
+			Requeued:    requeued,
+			Prioritized: prioritized,
+		},
 	}
-	// Note: The TCP connections (p1Entry.Connection, p2Entry.Connection) themselves are managed by their respective
-	// handleConnection goroutines in server.go. This handleGameResults goroutine only sends one message
-	// and then its defer closes the GameConcludedChans, which unblocks the HandleMatchmakingRequest calls.
+	if err := writeTCPMessage(conn, msg); err != nil {
+		log.Printf("Error sending MatchSetupFailed to %s: %v", player.Username, err)
+	}
+}
+
+// notifyAlreadyInGame refuses matchmaking to a player who is still in a running game,
+// naming the game so that their client can rejoin it instead.
+func notifyAlreadyInGame(conn net.Conn, gameID string) error {
+	return writeTCPMessage(conn, network.TCPMessage{
+		Type: network.MsgTypeMatchSetupFailed,
+		Payload: network.MatchSetupFailed{
+			Reason:       "you are already in a running game",
+			ActiveGameID: gameID,
+		},
+	})
 }
 
-func notifyMatch(conn net.Conn, player *models.PlayerAccount, opponent *models.PlayerAccount, gameID string, udpPort int, isPlayerOne bool, gameConfig models.GameConfig) {
+// notifyMatchCancelled tells a player that the match they were sent will not start.
+func notifyMatchCancelled(conn net.Conn, player *models.PlayerAccount, gameID string, reason string, prioritized bool) {
+	msg := network.TCPMessage{
+		Type:    network.MsgTypeMatchCancelled,
+		Payload: network.MatchCancelled{GameID: gameID, Reason: reason, Prioritized: prioritized},
+	}
+	if err := writeTCPMessage(conn, msg); err != nil {
+		log.Printf("Error sending MatchCancelled to %s: %v", player.Username, err)
+	}
+}
+
+// notifyMatch sends MatchFoundResponse to a player and reports whether it could be delivered.
+// Clients speaking protocol version 4 or later get the game config in a GameConfigData
+// message right after it; older clients get it inside MatchFoundResponse. Both players are
+// told of any handicaps, those of the opponent included.
+func notifyMatch(conn net.Conn, player *models.PlayerAccount, opponent *models.PlayerAccount, gameID string, udpPort int, isPlayerOne bool, sessionToken string, gameConfig models.GameConfig, protocolVersion int, queueType string, gameDuration time.Duration, handicaps map[string]models.PlayerOverrides) error {
 	matchResponse := network.MatchFoundResponse{
-		GameID:             gameID,
-		Opponent:           *opponent,
-		UDPPort:            udpPort,
-		IsPlayerOne:        isPlayerOne,
-		PlayerSessionToken: player.Username,
-		GameConfig:         gameConfig,
+		GameID:              gameID,
+		Opponent:            network.NewPublicProfile(opponent),
+		UDPHost:             CurrentNetworkConfig().EffectiveAdvertiseHost(),
+		UDPPort:             udpPort,
+		IsPlayerOne:         isPlayerOne,
+		PlayerSessionToken:  sessionToken,
+		QueueType:           queueType,
+		GameDurationSeconds: int(gameDuration.Seconds()),
+		Handicaps:           handicaps,
+	}
+	separateConfig := protocolVersion >= 4
+	if !separateConfig {
+		matchResponse.GameConfig = &gameConfig
 	}
 
-	encoder := json.NewEncoder(conn)
-	if err := encoder.Encode(matchResponse); err != nil {
-		log.Printf("Error sending MatchFoundResponse to %s: %v. This might leave the other player hanging or cause issues.", player.Username, err)
-		// If sending fails, the MatchedChan for a waiting player might not be closed if this is the critical notification.
-		// Or, if it's for P2, P1 might have been unblocked but P2 didn't get message.
-		// More robust error handling needed here for production (e.g., attempt to remove session, notify other player of failure).
+	if err := writeTCPMessage(conn, matchResponse); err != nil {
+		log.Printf("Error sending MatchFoundResponse to %s: %v", player.Username, err)
+		return err
+	}
+	if separateConfig {
+		configMsg := network.TCPMessage{
+			Type:    network.MsgTypeGameConfigData,
+			Payload: network.GameConfigData{Config: gameConfig},
+		}
+		if err := writeTCPMessage(conn, configMsg); err != nil {
+			log.Printf("Error sending GameConfigData to %s: %v", player.Username, err)
+			return err
+		}
 	}
+	return nil
 }
 
 // This function would be called by the main server loop when a new connection is established
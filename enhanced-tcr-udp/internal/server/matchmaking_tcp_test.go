@@ -0,0 +1,606 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"enhanced-tcr-udp/internal/persistence"
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// loopbackConn returns the two ends of a loopback TCP connection: the server's, which is
+// queued, and the client's. Sockets are needed, not net.Pipe, because connAlive only sees a
+// closed peer on a real socket. Both ends are closed when the test ends.
+func loopbackConn(t *testing.T) (server, client net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	defer ln.Close()
+	client, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	if server, err = ln.Accept(); err != nil {
+		client.Close()
+		t.Fatalf("accepting: %v", err)
+	}
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return server, client
+}
+
+// queuePlayer runs HandleMatchmakingRequest for username on conn in the casual queue. The
+// returned channel is closed once the handler returns.
+func queuePlayer(conn net.Conn, username string) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		HandleMatchmakingRequest(conn, json.NewDecoder(conn), &models.PlayerAccount{Username: username, Level: 1}, network.ProtocolVersion, network.QueueCasual, 0)
+	}()
+	return done
+}
+
+// waitUntil polls cond until it holds, failing the test after a second.
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+	}
+}
+
+// casualWaiting returns the usernames waiting in the casual queue.
+func casualWaiting() []string {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	var names []string
+	for _, entry := range waitingPlayers[network.QueueCasual] {
+		names = append(names, entry.PlayerAccount.Username)
+	}
+	return names
+}
+
+// releaseWaiting takes username out of the queues and ends their matchmaking, so a test
+// leaves the queues as it found them.
+func releaseWaiting(username string) {
+	queueMutex.Lock()
+	removed := removeWaitingPlayer(username)
+	queueMutex.Unlock()
+	for _, entry := range removed {
+		releaseQueueEntry(entry)
+	}
+}
+
+// expectNoMessage fails the test if the server sends anything on the client end conn.
+func expectNoMessage(t *testing.T, conn net.Conn, who string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	defer conn.SetReadDeadline(time.Time{})
+	var msg network.TCPMessage
+	if err := json.NewDecoder(conn).Decode(&msg); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("%s was sent %q (%v), want nothing", who, msg.Type, err)
+	}
+}
+
+// A player whose client disconnects while they wait is dropped from the queue when the
+// next player arrives: the arrival waits for a live opponent instead, the dead entry's
+// handler returns, and the eviction shows on the admin console's metrics.
+func TestDeadQueueEntryIsNeverPaired(t *testing.T) {
+	before := CurrentMatchmakingMetrics().DeadEntriesEvicted
+
+	p1Server, p1Client := loopbackConn(t)
+	p1Done := queuePlayer(p1Server, "evicted-p1")
+	waitUntil(t, "evicted-p1 is queued", func() bool { return len(casualWaiting()) == 1 })
+	p1Client.Close()
+	waitUntil(t, "evicted-p1's connection reads as dead", func() bool { return !connAlive(p1Server) })
+
+	p2Server, p2Client := loopbackConn(t)
+	p2Done := queuePlayer(p2Server, "evicted-p2")
+	defer func() {
+		releaseWaiting("evicted-p2")
+		<-p2Done
+	}()
+
+	select {
+	case <-p1Done:
+	case <-time.After(time.Second):
+		t.Fatal("evicted-p1's handler is still waiting")
+	}
+	waitUntil(t, "evicted-p2 is queued", func() bool {
+		waiting := casualWaiting()
+		return len(waiting) == 1 && waiting[0] == "evicted-p2"
+	})
+	for _, name := range []string{"evicted-p1", "evicted-p2"} {
+		if session, ok := GlobalSessionManager.FindPlayerSession(name); ok {
+			t.Errorf("%s is in game %s, want no session", name, session.ID)
+		}
+	}
+	expectNoMessage(t, p2Client, "evicted-p2")
+
+	if got := CurrentMatchmakingMetrics().DeadEntriesEvicted - before; got != 1 {
+		t.Errorf("%d dead entries evicted, want 1", got)
+	}
+	metrics := NewAdminConsole(GlobalSessionManager).Execute("metrics")
+	for _, want := range []string{
+		fmt.Sprintf("tcr_matchmaking_dead_entries_evicted_total %d", before+1),
+		fmt.Sprintf("tcr_matchmaking_waiting{queue=%q} 1", network.QueueCasual),
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics output lacks %q:\n%s", want, metrics)
+		}
+	}
+}
+
+// useFreeUDPPorts points the network config at n consecutive free loopback UDP ports, so
+// the matches a test makes can bind them, until the test ends.
+func useFreeUDPPorts(t *testing.T, n int) {
+	t.Helper()
+	for attempt := 0; attempt < 10; attempt++ {
+		probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatalf("finding a free UDP port: %v", err)
+		}
+		first := probe.LocalAddr().(*net.UDPAddr).Port
+		probe.Close()
+		if first+n-1 > 65535 {
+			continue
+		}
+		free := true
+		for port := first; port < first+n && free; port++ {
+			conn, err := net.ListenPacket("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+			if free = err == nil; free {
+				conn.Close()
+			}
+		}
+		if !free {
+			continue
+		}
+		cfg := DefaultNetworkConfig()
+		cfg.UDPListenHost, cfg.UDPPortMin, cfg.UDPPortMax = "127.0.0.1", first, first+n-1
+		if err := SetNetworkConfig(cfg); err != nil {
+			t.Fatalf("SetNetworkConfig: %v", err)
+		}
+		t.Cleanup(func() { SetNetworkConfig(DefaultNetworkConfig()) })
+		return
+	}
+	t.Fatalf("found no %d consecutive free UDP ports", n)
+}
+
+// readMatchFound reads the MatchFoundResponse the server sends on the client end conn.
+func readMatchFound(t *testing.T, conn net.Conn, who string) network.MatchFoundResponse {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	var match network.MatchFoundResponse
+	if err := json.NewDecoder(conn).Decode(&match); err != nil {
+		t.Fatalf("reading %s's match: %v", who, err)
+	}
+	return match
+}
+
+// A waiting player whose client goes away just before an opponent arrives is not paired
+// with them; the opponent searches on and is matched with the next player to arrive. A
+// closed socket is caught by the liveness probe before pairing. A connection the probe
+// cannot see into (as on platforms without it) is caught when the match announcement to it
+// fails, and the opponent goes back to the queue without having been told of that match.
+func TestOpponentOfVanishedPlayerMatchesNextArrival(t *testing.T) {
+	tests := []struct {
+		name string
+		// queueGone queues P1 on a connection whose client has gone away by the time it returns
+		queueGone func(t *testing.T) <-chan struct{}
+	}{
+		{"closed socket", func(t *testing.T) <-chan struct{} {
+			server, client := loopbackConn(t)
+			done := queuePlayer(server, "vanished-p1")
+			waitUntil(t, "vanished-p1 is queued", func() bool { return len(casualWaiting()) == 1 })
+			client.Close()
+			waitUntil(t, "vanished-p1's connection reads as dead", func() bool { return !connAlive(server) })
+			return done
+		}},
+		{"unwritable connection", func(t *testing.T) <-chan struct{} {
+			server, client := net.Pipe() // Not a socket: connAlive cannot tell it is closed
+			t.Cleanup(func() { server.Close() })
+			done := queuePlayer(server, "vanished-p1")
+			waitUntil(t, "vanished-p1 is queued", func() bool { return len(casualWaiting()) == 1 })
+			client.Close()
+			return done
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFreeUDPPorts(t, 4)
+			p1Done := tt.queueGone(t)
+
+			p2Server, p2Client := loopbackConn(t)
+			p2Done := queuePlayer(p2Server, "vanished-p2")
+			select {
+			case <-p1Done:
+			case <-time.After(time.Second):
+				t.Fatal("vanished-p1's handler is still waiting")
+			}
+			waitUntil(t, "vanished-p2 is queued", func() bool {
+				waiting := casualWaiting()
+				return len(waiting) == 1 && waiting[0] == "vanished-p2"
+			})
+			expectNoMessage(t, p2Client, "vanished-p2")
+
+			p3Server, p3Client := loopbackConn(t)
+			p3Done := queuePlayer(p3Server, "vanished-p3")
+			p2Match := readMatchFound(t, p2Client, "vanished-p2")
+			p3Match := readMatchFound(t, p3Client, "vanished-p3")
+			if p2Match.Opponent.Username != "vanished-p3" || !p2Match.IsPlayerOne {
+				t.Errorf("vanished-p2 matched with %s (player one: %t), want vanished-p3 as player one", p2Match.Opponent.Username, p2Match.IsPlayerOne)
+			}
+			if p3Match.Opponent.Username != "vanished-p2" || p3Match.GameID != p2Match.GameID {
+				t.Errorf("vanished-p3 matched with %s in game %s, want vanished-p2 in %s", p3Match.Opponent.Username, p3Match.GameID, p2Match.GameID)
+			}
+			if session, ok := GlobalSessionManager.FindPlayerSession("vanished-p1"); ok {
+				t.Errorf("vanished-p1 is in game %s, want no session", session.ID)
+			}
+
+			session, ok := GlobalSessionManager.GetSession(p2Match.GameID)
+			if !ok {
+				t.Fatalf("game %s is not registered", p2Match.GameID)
+			}
+			if err := session.ForceEnd("test over", ForceOutcomeDraw); err != nil {
+				t.Fatalf("ForceEnd: %v", err)
+			}
+			for name, done := range map[string]<-chan struct{}{"vanished-p2": p2Done, "vanished-p3": p3Done} {
+				select {
+				case <-done:
+				case <-time.After(5 * time.Second):
+					t.Errorf("%s's handler did not return after the game ended", name)
+				}
+			}
+		})
+	}
+}
+
+// readSetupFailed reads the MatchSetupFailed the server sends on the client end conn.
+func readSetupFailed(t *testing.T, conn net.Conn, who string) network.MatchSetupFailed {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	var msg struct {
+		Type    string                   `json:"type"`
+		Payload network.MatchSetupFailed `json:"payload"`
+	}
+	if err := json.NewDecoder(conn).Decode(&msg); err != nil {
+		t.Fatalf("reading %s's message: %v", who, err)
+	}
+	if msg.Type != network.MsgTypeMatchSetupFailed {
+		t.Fatalf("%s was sent %q, want %q", who, msg.Type, network.MsgTypeMatchSetupFailed)
+	}
+	return msg.Payload
+}
+
+// When the session for a match cannot be created, both players are told with
+// match_setup_failed rather than left waiting. A UDP port that cannot be bound may be free
+// next time, so the waiting player keeps their place; a broken game config fails every
+// match, so both are released. Either way both are matched ahead of others next time.
+func TestMatchSetupFailureTellsBothPlayers(t *testing.T) {
+	tests := []struct {
+		name      string
+		players   string             // Prefix of the players' usernames
+		breakIt   func(t *testing.T) // Makes creating the session fail
+		retryable bool
+	}{
+		{"UDP port taken", "setup-udp", func(t *testing.T) {
+			taken, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				t.Fatalf("binding a UDP port: %v", err)
+			}
+			t.Cleanup(func() { taken.Close() })
+			port := taken.LocalAddr().(*net.UDPAddr).Port
+			cfg := DefaultNetworkConfig()
+			cfg.UDPListenHost, cfg.UDPPortMin, cfg.UDPPortMax = "127.0.0.1", port, port
+			if err := SetNetworkConfig(cfg); err != nil {
+				t.Fatalf("SetNetworkConfig: %v", err)
+			}
+			t.Cleanup(func() { SetNetworkConfig(DefaultNetworkConfig()) })
+		}, true},
+		{"towers.json missing", "setup-config", func(t *testing.T) {
+			useFreeUDPPorts(t, 1)
+			troops, err := os.ReadFile(filepath.Join("config_enhanced", "troops.json"))
+			if err != nil {
+				t.Fatalf("reading troops.json: %v", err)
+			}
+			dir := t.TempDir()
+			if err := os.Mkdir(filepath.Join(dir, "config_enhanced"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "config_enhanced", "troops.json"), troops, 0644); err != nil {
+				t.Fatal(err)
+			}
+			wd, err := os.Getwd()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chdir(dir); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { os.Chdir(wd) })
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.breakIt(t)
+			p1, p2 := tt.players+"-p1", tt.players+"-p2"
+
+			p1Server, p1Client := loopbackConn(t)
+			p1Done := queuePlayer(p1Server, p1)
+			defer func() {
+				releaseWaiting(p1)
+				<-p1Done
+			}()
+			waitUntil(t, p1+" is queued", func() bool { return len(casualWaiting()) == 1 })
+			p2Server, p2Client := loopbackConn(t)
+			p2Done := queuePlayer(p2Server, p2)
+
+			want := network.MatchSetupFailed{Retryable: tt.retryable, Requeued: tt.retryable, Prioritized: true}
+			got := readSetupFailed(t, p1Client, p1)
+			if got.Reason == "" || got.Retryable != want.Retryable || got.Requeued != want.Requeued || got.Prioritized != want.Prioritized {
+				t.Errorf("%s was told %+v, want a reason with %+v", p1, got, want)
+			}
+			want.Requeued = false // The arriving player is never kept in the queue
+			got = readSetupFailed(t, p2Client, p2)
+			if got.Reason == "" || got.Retryable != want.Retryable || got.Requeued != want.Requeued || got.Prioritized != want.Prioritized {
+				t.Errorf("%s was told %+v, want a reason with %+v", p2, got, want)
+			}
+
+			select {
+			case <-p2Done:
+			case <-time.After(time.Second):
+				t.Errorf("%s's handler is still waiting", p2)
+			}
+			if tt.retryable {
+				waitUntil(t, p1+" is back in the queue", func() bool {
+					waiting := casualWaiting()
+					return len(waiting) == 1 && waiting[0] == p1
+				})
+			} else {
+				select {
+				case <-p1Done:
+				case <-time.After(time.Second):
+					t.Errorf("%s's handler is still waiting", p1)
+				}
+			}
+			for _, name := range []string{p1, p2} {
+				if session, ok := GlobalSessionManager.FindPlayerSession(name); ok {
+					t.Errorf("%s is in game %s, want no session", name, session.ID)
+				}
+				if matchPriorityUntil(name).IsZero() {
+					t.Errorf("%s has no match priority after the failed match", name)
+				}
+			}
+		})
+	}
+}
+
+// useTCPWriteTimeout sets the TCP write timeout to d for the rest of the test.
+func useTCPWriteTimeout(t *testing.T, d time.Duration) {
+	t.Helper()
+	saved := CurrentNetworkConfig()
+	cfg := saved
+	cfg.TCPWriteTimeout = d
+	if err := SetNetworkConfig(cfg); err != nil {
+		t.Fatalf("setting the write timeout: %v", err)
+	}
+	t.Cleanup(func() { SetNetworkConfig(saved) })
+}
+
+// unreadConn returns the server end of a loopback connection whose client never reads,
+// with the socket buffers already full: a write to it fails at the write deadline, which
+// should be short. The buffers are made small, as the kernel would otherwise grow them,
+// or make room in them, while the test runs.
+func unreadConn(t *testing.T) net.Conn {
+	t.Helper()
+	server, client := loopbackConn(t)
+	if err := server.(*net.TCPConn).SetWriteBuffer(16 << 10); err != nil {
+		t.Fatalf("shrinking the send buffer: %v", err)
+	}
+	if err := client.(*net.TCPConn).SetReadBuffer(16 << 10); err != nil {
+		t.Fatalf("shrinking the receive buffer: %v", err)
+	}
+	timeout := CurrentNetworkConfig().TCPWriteTimeout
+	// writeUntilFull writes messages of size until one times out and returns how many did not
+	writeUntilFull := func(size int) int {
+		t.Helper()
+		filler := network.TCPMessage{Type: "filler", Payload: strings.Repeat("x", size)}
+		for i := 0; ; i++ {
+			start := time.Now()
+			err := writeTCPMessage(server, filler)
+			if took := time.Since(start); took > timeout+time.Second {
+				t.Fatalf("write %d took %v with a %v deadline", i, took, timeout)
+			}
+			if err != nil {
+				if !errors.Is(err, os.ErrDeadlineExceeded) {
+					t.Fatalf("write %d failed with %v, want the deadline exceeded", i, err)
+				}
+				return i
+			}
+			if i == 1<<16 {
+				t.Fatal("the socket buffers never filled")
+			}
+		}
+	}
+	// Large messages fill the buffers quickly; small ones then take up the space left,
+	// until not even one fits, so no message larger than them can fit either
+	writeUntilFull(1 << 10)
+	for writeUntilFull(0) > 0 {
+	}
+	return server
+}
+
+// A client that stops reading does not hold the server up: once its socket buffers are full
+// a write to it gives up at the write deadline, and its game results are stored for its next
+// login instead of being waited on.
+func TestUnreadClientTimesOut(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	useTCPWriteTimeout(t, timeout)
+	server := unreadConn(t)
+
+	const username = "unread-client"
+	entry := &PlayerQueueEntry{
+		PlayerAccount:     &models.PlayerAccount{Username: username},
+		Connection:        server,
+		GameConcludedChan: make(chan struct{}),
+	}
+	results := network.GameOverResults{GameID: "unread-game", Outcome: "Win"}
+	start := time.Now()
+	status := deliverGameResults(entry, results, "unread-game")
+	if took := time.Since(start); took > timeout+time.Second {
+		t.Errorf("delivering results took %v with a %v deadline", took, timeout)
+	}
+	if status != resultsPending {
+		t.Errorf("results %s, want stored as pending", status)
+	}
+	select {
+	case <-entry.GameConcludedChan:
+	default:
+		t.Error("GameConcludedChan is still open")
+	}
+	pending, err := persistence.LoadAndClearPendingResults(username)
+	if err != nil || len(pending) != 1 || pending[0].GameID != "unread-game" {
+		t.Errorf("pending results %+v (%v), want the unread game's", pending, err)
+	}
+}
+
+// An account that queues again while its first request still waits is never paired with
+// itself: the earlier entry is told why and released, the newer one waits alone, and no
+// session is created. CreateSession refuses such a pairing outright.
+func TestSameAccountQueuedTwiceFormsNoSession(t *testing.T) {
+	const username = "twice-queued"
+	firstServer, firstClient := loopbackConn(t)
+	firstDone := queuePlayer(firstServer, username)
+	waitUntil(t, "the first request is queued", func() bool { return len(casualWaiting()) == 1 })
+
+	secondServer, secondClient := loopbackConn(t)
+	secondDone := queuePlayer(secondServer, username)
+	t.Cleanup(func() {
+		releaseWaiting(username)
+		<-secondDone
+	})
+	failed := readSetupFailed(t, firstClient, "the first request")
+	if !strings.Contains(failed.Reason, ErrSamePlayer.Error()) || failed.Retryable || failed.Requeued {
+		t.Errorf("the first request was refused with %+v, want a final %q", failed, ErrSamePlayer)
+	}
+	select {
+	case <-firstDone:
+	case <-time.After(time.Second):
+		t.Fatal("the first request's handler is still waiting")
+	}
+	if waiting := casualWaiting(); len(waiting) != 1 || waiting[0] != username {
+		t.Errorf("waiting: %v, want only the second request", waiting)
+	}
+	expectNoMessage(t, secondClient, "the second request")
+	if session, ok := GlobalSessionManager.FindPlayerSession(username); ok {
+		t.Errorf("%s is in game %s, want no session", username, session.ID)
+	}
+
+	player := &models.PlayerAccount{Username: username, Level: 1}
+	if _, err := NewGameSessionManager().CreateSession(SessionOptions{GameID: "self-game", Player1: player, Player2: player}); !errors.Is(err, ErrSamePlayer) {
+		t.Errorf("CreateSession against itself: %v, want %v", err, ErrSamePlayer)
+	}
+}
+
+// When one player is gone by the end of the game, the other still gets their results at
+// once, without waiting on the first's connection, and the gone player's results are kept
+// for their next login. A closed connection fails at once; one whose client stopped reading
+// only at the write deadline, long after the healthy player has been served.
+func TestResultsReachHealthyPlayerWhenOpponentIsGone(t *testing.T) {
+	const timeout = time.Second
+	tests := []struct {
+		name string
+		gone func(t *testing.T) net.Conn // Returns P1's server end
+	}{
+		{"closed connection", func(t *testing.T) net.Conn {
+			server, client := loopbackConn(t)
+			client.Close()
+			server.Close()
+			return server
+		}},
+		{"unread connection", func(t *testing.T) net.Conn {
+			useTCPWriteTimeout(t, 200*time.Millisecond) // Fills quicker
+			return unreadConn(t)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p1Conn := tt.gone(t)
+			useTCPWriteTimeout(t, timeout)
+			p2Server, p2Client := loopbackConn(t)
+			entry := func(username string, conn net.Conn) *PlayerQueueEntry {
+				return &PlayerQueueEntry{
+					PlayerAccount:     &models.PlayerAccount{Username: username},
+					Connection:        conn,
+					GameConcludedChan: make(chan struct{}),
+				}
+			}
+			p1, p2 := entry("gone-p1", p1Conn), entry("healthy-p2", p2Server)
+
+			resultsChan := make(chan network.GameResultInfo, 1)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				handleGameResults(resultsChan, nil, p1, p2, "gone-game")
+			}()
+			start := time.Now()
+			resultsChan <- network.GameResultInfo{
+				Player1Username: "gone-p1",
+				Player2Username: "healthy-p2",
+				Player1Result:   network.GameOverResults{GameID: "gone-game", Outcome: "Loss"},
+				Player2Result:   network.GameOverResults{GameID: "gone-game", Outcome: "Win"},
+			}
+
+			p2Client.SetReadDeadline(start.Add(timeout / 2))
+			var msg struct {
+				Type    string                  `json:"type"`
+				Payload network.GameOverResults `json:"payload"`
+			}
+			if err := json.NewDecoder(p2Client).Decode(&msg); err != nil {
+				t.Fatalf("healthy-p2's results within %v: %v", timeout/2, err)
+			}
+			if msg.Type != network.MsgTypeGameOverResults || msg.Payload.Outcome != "Win" {
+				t.Errorf("healthy-p2 was sent %q %+v, want their win", msg.Type, msg.Payload)
+			}
+			select {
+			case <-p2.GameConcludedChan:
+			case <-time.After(timeout/2 - time.Since(start)):
+				t.Error("healthy-p2's GameConcludedChan is still open while gone-p1's delivery is tried")
+			}
+
+			select {
+			case <-done:
+			case <-time.After(timeout + 2*time.Second):
+				t.Fatal("handleGameResults did not return")
+			}
+			select {
+			case <-p1.GameConcludedChan:
+			default:
+				t.Error("gone-p1's GameConcludedChan is still open")
+			}
+			pending, err := persistence.LoadAndClearPendingResults("gone-p1")
+			if err != nil || len(pending) != 1 || pending[0].Outcome != "Loss" {
+				t.Errorf("gone-p1's pending results %+v (%v), want their loss", pending, err)
+			}
+			if pending, err := persistence.LoadAndClearPendingResults("healthy-p2"); err != nil || len(pending) != 0 {
+				t.Errorf("healthy-p2's pending results %+v (%v), want none", pending, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default UDP port range for game sessions.
+const (
+	DefaultUDPPortMin = 8081
+	DefaultUDPPortMax = 8999
+)
+
+// DefaultTCPWriteTimeout bounds each message the server writes to a client's TCP connection.
+const DefaultTCPWriteTimeout = 10 * time.Second
+
+// NetworkConfig says where the server listens and what address clients are given.
+type NetworkConfig struct {
+	TCPListen     string // host:port for the TCP control connection
+	UDPListenHost string // Host that session UDP sockets bind to; "" binds all interfaces
+	UDPPortMin    int    // First port handed to a game session
+	UDPPortMax    int    // Last port handed to a game session; allocation wraps back to UDPPortMin
+	// AdvertiseHost is the host clients send game UDP traffic to. Set it when clients reach
+	// the server through NAT or a proxy; when empty a host is derived from the listen addresses.
+	AdvertiseHost string
+	// TCPWriteTimeout is how long one message to a client may take to write. A client that
+	// stops reading fills its socket buffers; past this the write fails and the message is
+	// treated as undelivered instead of blocking matchmaking or results.
+	TCPWriteTimeout time.Duration
+}
+
+// DefaultNetworkConfig binds TCP and UDP to the same loopback host.
+func DefaultNetworkConfig() NetworkConfig {
+	return NetworkConfig{
+		TCPListen:     DefaultListenAddress,
+		UDPListenHost: "localhost",
+		UDPPortMin:    DefaultUDPPortMin,
+		UDPPortMax:    DefaultUDPPortMax,
+
+		TCPWriteTimeout: DefaultTCPWriteTimeout,
+	}
+}
+
+// ParsePortRange parses a range written as "8081-8999", or a single port.
+func ParsePortRange(s string) (min, max int, err error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	if !isRange {
+		hi = lo
+	}
+	if min, err = strconv.Atoi(strings.TrimSpace(lo)); err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+	if max, err = strconv.Atoi(strings.TrimSpace(hi)); err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+	return min, max, nil
+}
+
+// Validate reports addresses that cannot be parsed and port ranges that make no sense.
+func (c NetworkConfig) Validate() error {
+	host, port, err := net.SplitHostPort(c.TCPListen)
+	if err != nil {
+		return fmt.Errorf("tcp-listen %q: %w", c.TCPListen, err)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("tcp-listen %q: invalid port", c.TCPListen)
+	}
+	if err := validateHost(host); err != nil {
+		return fmt.Errorf("tcp-listen %q: %w", c.TCPListen, err)
+	}
+	if err := validateHost(c.UDPListenHost); err != nil {
+		return fmt.Errorf("udp-listen-host %q: %w", c.UDPListenHost, err)
+	}
+	if err := validateHost(c.AdvertiseHost); err != nil {
+		return fmt.Errorf("advertise-host %q: %w", c.AdvertiseHost, err)
+	}
+	if c.UDPPortMin < 1 || c.UDPPortMax > 65535 || c.UDPPortMin > c.UDPPortMax {
+		return fmt.Errorf("udp-port-range %d-%d: must be within 1-65535 with min <= max", c.UDPPortMin, c.UDPPortMax)
+	}
+	if c.TCPWriteTimeout <= 0 {
+		return fmt.Errorf("tcp-write-timeout %v: must be positive", c.TCPWriteTimeout)
+	}
+	return nil
+}
+
+// validateHost accepts "", an IP address or a plain host name, but not a host:port.
+func validateHost(host string) error {
+	if host == "" || net.ParseIP(host) != nil {
+		return nil
+	}
+	if strings.ContainsAny(host, ":/ \t[]") {
+		return fmt.Errorf("not a host name or IP address")
+	}
+	return nil
+}
+
+// EffectiveAdvertiseHost returns the host clients should send UDP to: AdvertiseHost if set,
+// otherwise a specific UDP or TCP bind host. It returns "" when the server binds every
+// interface and has no advertise host, leaving the client to use the host it reached over TCP.
+func (c NetworkConfig) EffectiveAdvertiseHost() string {
+	if c.AdvertiseHost != "" {
+		return c.AdvertiseHost
+	}
+	if isSpecificHost(c.UDPListenHost) {
+		return c.UDPListenHost
+	}
+	if host, _, err := net.SplitHostPort(c.TCPListen); err == nil && isSpecificHost(host) {
+		return host
+	}
+	return ""
+}
+
+// isSpecificHost reports whether binding to host selects one interface rather than all of them.
+func isSpecificHost(host string) bool {
+	if host == "" {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return !ip.IsUnspecified()
+	}
+	return true
+}
+
+var (
+	netConfigMu sync.RWMutex
+	netConfig   = DefaultNetworkConfig()
+)
+
+// SetNetworkConfig validates cfg and makes it the server's network configuration. It should
+// be called before the server starts handling connections; the UDP port counter restarts
+// at the beginning of the new range.
+func SetNetworkConfig(cfg NetworkConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	netConfigMu.Lock()
+	netConfig = cfg
+	netConfigMu.Unlock()
+
+	portMutex.Lock()
+	currentUDPPort = cfg.UDPPortMin
+	portMutex.Unlock()
+	return nil
+}
+
+// CurrentNetworkConfig returns the network configuration in effect.
+func CurrentNetworkConfig() NetworkConfig {
+	netConfigMu.RLock()
+	defer netConfigMu.RUnlock()
+	return netConfig
+}
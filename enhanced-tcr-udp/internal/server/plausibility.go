@@ -0,0 +1,128 @@
+package server
+
+import (
+	"time"
+
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// CommandViolation is a kind of implausible client command.
+type CommandViolation string
+
+const (
+	// ViolationClockSkew is a command whose timestamp is further from server time than
+	// GameRules.MaxClockSkew. A badly set client clock also trips it, so it is only counted.
+	ViolationClockSkew CommandViolation = "clock_skew"
+	// ViolationSeqRegression is a command whose Seq is more than GameRules.MaxSeqGap below
+	// the player's watermark. Resends of lost commands arrive a little below the watermark;
+	// nothing the real client sends lands this far back, so these commands are rejected.
+	ViolationSeqRegression CommandViolation = "seq_regression"
+	// ViolationSeqGap is a command whose Seq is more than GameRules.MaxSeqGap above the
+	// watermark, i.e. it skipped that many commands. It is counted, not rejected.
+	ViolationSeqGap CommandViolation = "seq_gap"
+)
+
+// commandPlausibility counts one player's implausible commands. The checks are there to make
+// a modified client visible to operators; apart from Seq regressions nothing is blocked
+// unless GameRules.ForfeitOnCheating is set.
+type commandPlausibility struct {
+	counts map[CommandViolation]int
+	warned bool // The session has already warned about this player
+}
+
+// newCommandPlausibility creates a tracker with no violations.
+func newCommandPlausibility() *commandPlausibility {
+	return &commandPlausibility{counts: make(map[CommandViolation]int)}
+}
+
+// check records the violations, if any, of a command with Seq seq sent at sentAt by a player
+// whose watermark is watermark, received at now. A zero sentAt is not checked.
+func (p *commandPlausibility) check(seq, watermark uint32, sentAt, now time.Time, rules models.GameRules) []CommandViolation {
+	var violations []CommandViolation
+	if !sentAt.IsZero() && rules.MaxClockSkew > 0 {
+		skew := sentAt.Sub(now)
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > rules.MaxClockSkew {
+			violations = append(violations, ViolationClockSkew)
+		}
+	}
+	if rules.MaxSeqGap > 0 && watermark > 0 {
+		if seq < watermark && watermark-seq > rules.MaxSeqGap {
+			violations = append(violations, ViolationSeqRegression)
+		} else if seq > watermark && seq-watermark > rules.MaxSeqGap {
+			violations = append(violations, ViolationSeqGap)
+		}
+	}
+	for _, v := range violations {
+		p.counts[v]++
+	}
+	return violations
+}
+
+// Total returns the number of violations recorded.
+func (p *commandPlausibility) Total() int {
+	total := 0
+	for _, n := range p.counts {
+		total += n
+	}
+	return total
+}
+
+// checkCommandPlausibility runs the plausibility checks on a command and reports whether it
+// must be rejected. Once a player's violations reach GameRules.CheatWarnThreshold the session
+// logs a warning, flags the player in its snapshot and, with ForfeitOnCheating, ends the game
+// in the opponent's favour. Must be called with gs.mu held, before the watermark advances.
+func (gs *GameSession) checkCommandPlausibility(msg network.UDPMessage, now time.Time) bool {
+	player, opponent := gs.playersForToken(msg.PlayerToken)
+	if player == nil {
+		return false
+	}
+	checks := gs.commandChecks[msg.PlayerToken]
+	if checks == nil {
+		checks = newCommandPlausibility()
+		gs.commandChecks[msg.PlayerToken] = checks
+	}
+
+	violations := checks.check(msg.Seq, gs.lastProcessedSeq[msg.PlayerToken], msg.Timestamp, now, gs.Rules)
+	reject := false
+	for _, v := range violations {
+		if n := checks.counts[v]; n == 1 || n%10 == 0 { // Rate-limited: a bad clock trips on every command
+			gs.logf("[GameSession %s] Player %s: implausible %s command (Seq %d, watermark %d, sent %s): %s (%d so far).",
+				gs.ID, player.Account.Username, msg.Type, msg.Seq, gs.lastProcessedSeq[msg.PlayerToken], msg.Timestamp.Format(time.RFC3339Nano), v, n)
+		}
+		if v == ViolationSeqRegression {
+			reject = true
+		}
+	}
+
+	if threshold := gs.Rules.CheatWarnThreshold; threshold > 0 && !checks.warned && checks.Total() >= threshold {
+		checks.warned = true
+		gs.logf("[GameSession %s] WARNING: Player %s has sent %d implausible commands (%v); possible modified client.",
+			gs.ID, player.Account.Username, checks.Total(), checks.counts)
+		if gs.Rules.ForfeitOnCheating && opponent != nil && !gs.State().Finished() {
+			gs.forcedOutcome = ForceOutcomePlayer1
+			if opponent == gs.Player2 {
+				gs.forcedOutcome = ForceOutcomePlayer2
+			}
+			gs.forcedReason = "implausible commands from " + player.Account.Username
+			gs.determineWinnerAndStop("forced")
+			return true
+		}
+	}
+	return reject
+}
+
+// playersForToken returns the player with the given session token and their opponent,
+// or nils if the token belongs to neither player.
+func (gs *GameSession) playersForToken(token string) (player, opponent *models.PlayerInGame) {
+	switch token {
+	case gs.Player1.SessionToken:
+		return gs.Player1, gs.Player2
+	case gs.Player2.SessionToken:
+		return gs.Player2, gs.Player1
+	}
+	return nil, nil
+}
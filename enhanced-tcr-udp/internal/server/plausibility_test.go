@@ -0,0 +1,48 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"enhanced-tcr-udp/pkg/tcrclient"
+)
+
+// A client idling for longer than MaxSeqGap heartbeats still has its next deploy taken as
+// the command after the last one: pings are numbered apart from commands.
+func TestHeartbeatsLeaveNoSeqGap(t *testing.T) {
+	gs := newTestSession(t, SessionOptions{})
+	gs.mu.Lock()
+	startTestCombat(t, gs, time.Now())
+	gs.Player1.CurrentMana = 10
+	gs.mu.Unlock()
+	token := gs.Player1.SessionToken
+	alice := dialTestSession(t, gs, nil, token, tcrclient.SessionConfig{})
+
+	deploy := func() {
+		t.Helper()
+		if _, err := alice.Deploy("pawn", ""); err != nil {
+			t.Fatalf("Deploy: %v", err)
+		}
+		handleNextAction(t, gs, gs.player1Actions)
+	}
+	deploy()
+	heartbeats := int(gs.Rules.MaxSeqGap) + 10 // Over 100s of idling, one per HeartbeatInterval
+	for i := 0; i < heartbeats; i++ {
+		if _, err := alice.Ping(time.Second); err != nil {
+			t.Fatalf("heartbeat %d: %v", i+1, err)
+		}
+	}
+	deploy()
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if checks := gs.commandChecks[token]; checks != nil && checks.Total() != 0 {
+		t.Errorf("alice was flagged for %v after idling", checks.counts)
+	}
+	if troops := len(gs.Player1.DeployedTroops); troops != 2 {
+		t.Errorf("alice has %d troops, want both deploys applied", troops)
+	}
+	if seq := gs.lastProcessedSeq[token]; seq != 2 {
+		t.Errorf("watermark %d after two deploys, want 2", seq)
+	}
+}
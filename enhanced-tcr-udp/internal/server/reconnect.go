@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"sync"
+
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// inGamePlayer is a player whose game is running, as reconnectPlayer needs to know them.
+type inGamePlayer struct {
+	entry    *PlayerQueueEntry // The player's queue entry; its results connection is swapped on reconnect
+	opponent *models.PlayerAccount
+	session  *GameSession
+}
+
+var (
+	// inGamePlayers maps the usernames of players whose game is running, from the moment
+	// both were told about the match until the results have been sent.
+	inGamePlayers = make(map[string]inGamePlayer)
+	inGameMutex   = &sync.Mutex{}
+)
+
+// registerInGame records the two players of a game that is about to start.
+func registerInGame(session *GameSession, p1Entry, p2Entry *PlayerQueueEntry) {
+	inGameMutex.Lock()
+	defer inGameMutex.Unlock()
+	inGamePlayers[p1Entry.PlayerAccount.Username] = inGamePlayer{entry: p1Entry, opponent: p2Entry.PlayerAccount, session: session}
+	inGamePlayers[p2Entry.PlayerAccount.Username] = inGamePlayer{entry: p2Entry, opponent: p1Entry.PlayerAccount, session: session}
+}
+
+// unregisterInGame forgets the players of a game whose results have been sent.
+func unregisterInGame(entries ...*PlayerQueueEntry) {
+	inGameMutex.Lock()
+	defer inGameMutex.Unlock()
+	for _, entry := range entries {
+		if inGamePlayers[entry.PlayerAccount.Username].entry == entry {
+			delete(inGamePlayers, entry.PlayerAccount.Username)
+		}
+	}
+}
+
+// reconnectPlayer handles a MsgTypeReconnectRequest from a player who logged in again while
+// their game is running. The session issues them a new token, which replaces the one their
+// lost client used, and the game's results are sent on conn instead of the old connection.
+// If the player rejoined, it returns once the results have been sent; otherwise it tells
+// them why and returns false, leaving conn free for other requests.
+func reconnectPlayer(conn net.Conn, player *models.PlayerAccount, protocolVersion int, payload json.RawMessage) bool {
+	reject := func(message string) bool {
+		log.Printf("Player %s could not rejoin their game: %s", player.Username, message)
+		reply := network.TCPMessage{
+			Type:    network.MsgTypeReconnectResponse,
+			Payload: network.ReconnectResponse{Success: false, Message: message},
+		}
+		if err := writeTCPMessage(conn, reply); err != nil {
+			log.Printf("Error answering reconnect request from %s: %v", player.Username, err)
+		}
+		return false
+	}
+
+	var request network.ReconnectRequest
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return reject("malformed reconnect request")
+	}
+	inGameMutex.Lock()
+	game, ok := inGamePlayers[player.Username]
+	inGameMutex.Unlock()
+	if !ok || game.session.State().Finished() {
+		return reject("you are not in a running game")
+	}
+	if request.GameID != "" && request.GameID != game.session.ID {
+		return reject("that game is no longer running")
+	}
+
+	token, isPlayerOne, err := game.session.RotatePlayerToken(player.Username, protocolVersion)
+	if err != nil {
+		return reject(err.Error())
+	}
+	queueType := network.QueueRanked
+	if game.session.casual {
+		queueType = network.QueueCasual
+	}
+	match := network.MatchFoundResponse{
+		GameID:              game.session.ID,
+		Opponent:            network.NewPublicProfile(game.opponent),
+		UDPHost:             CurrentNetworkConfig().EffectiveAdvertiseHost(),
+		UDPPort:             game.session.udpPort,
+		IsPlayerOne:         isPlayerOne,
+		PlayerSessionToken:  token,
+		QueueType:           queueType,
+		GameDurationSeconds: int(game.session.Rules.GameDuration.Seconds()),
+		Handicaps:           game.session.Rules.PerPlayerOverrides,
+	}
+	// A snapshot holds the session's lock only while copying, so the game loop goes on
+	state := game.session.Snapshot().StateUpdate()
+	reply := network.TCPMessage{
+		Type:    network.MsgTypeReconnectResponse,
+		Payload: network.ReconnectResponse{Success: true, Match: &match, State: &state},
+	}
+	if err := writeTCPMessage(conn, reply); err != nil {
+		// The new token stays in place; the player can reconnect once more for another one
+		log.Printf("Error sending reconnect response to %s: %v", player.Username, err)
+		return false
+	}
+	configMsg := network.TCPMessage{
+		Type:    network.MsgTypeGameConfigData,
+		Payload: network.GameConfigData{Config: game.session.Config},
+	}
+	if err := writeTCPMessage(conn, configMsg); err != nil {
+		log.Printf("Error sending GameConfigData to reconnected player %s: %v", player.Username, err)
+		return false
+	}
+
+	game.entry.setResultsConnection(conn)
+	log.Printf("Player %s rejoined game %s. Waiting for the game to conclude before closing TCP.", player.Username, game.session.ID)
+	<-game.entry.GameConcludedChan
+	log.Printf("Player %s (reconnected) game has concluded.", player.Username)
+	return true
+}
@@ -1,13 +1,18 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
-	"enhanced-tcr-udp/internal/models"
-	"enhanced-tcr-udp/internal/network"
+	"enhanced-tcr-udp/internal/persistence"
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
-	"os"
+	"sync"
+	"time"
 )
 
 const (
@@ -20,7 +25,28 @@ type Server struct {
 	listener       net.Listener
 	authManager    *AuthManager
 	sessionManager *GameSessionManager
+	auxiliaries    []AuxiliaryListener // Run beside the TCP listener; see AddAuxiliary
+	udpCheck       *UDPCheckResponder  // Named in health pongs; nil if there is none
 	// Add other global server components here, e.g., config loader
+
+	mu        sync.Mutex
+	cancelAux context.CancelFunc // Stops the auxiliary listeners started by Serve. Guarded by mu
+	auxErr    error              // First error an auxiliary listener failed with. Guarded by mu
+	auxWG     sync.WaitGroup     // The running auxiliary listeners
+}
+
+// AuxiliaryListener is a listener the Server runs beside its TCP control listener, such as
+// the UDP echo server. Listen binds it along with the TCP listener, so a bind failure is
+// returned from Server.Listen; Serve runs it until the Server stops.
+type AuxiliaryListener interface {
+	Name() string
+	// Listen binds the listener's socket.
+	Listen() error
+	// Serve runs until ctx is cancelled, then releases the socket and returns nil. Any other
+	// return stops the whole Server.
+	Serve(ctx context.Context) error
+	// Close releases the socket if Serve will not run, e.g. when a later bind failed.
+	Close() error
 }
 
 // NewServer creates and initializes a new game server.
@@ -28,26 +54,81 @@ func NewServer(listenAddr string) *Server {
 	if listenAddr == "" {
 		listenAddr = DefaultListenAddress
 	}
-	return &Server{
+	s := &Server{
 		listenAddress:  listenAddr,
 		authManager:    NewAuthManager(),     // From auth_tcp.go
 		sessionManager: GlobalSessionManager, // From matchmaking_tcp.go (or init here)
 	}
+	s.authManager.inGame = func(username string) bool {
+		_, ok := s.sessionManager.FindPlayerSession(username)
+		return ok
+	}
+	return s
+}
+
+// AddAuxiliary has the server run l beside its TCP listener. It must be called before Listen.
+func (s *Server) AddAuxiliary(l AuxiliaryListener) {
+	s.auxiliaries = append(s.auxiliaries, l)
+}
+
+// SetUDPCheckResponder has the server run r beside its TCP listener, like AddAuxiliary, and
+// name its port in the answers to health pings. It must be called before Listen.
+func (s *Server) SetUDPCheckResponder(r *UDPCheckResponder) {
+	s.udpCheck = r
+	s.AddAuxiliary(r)
 }
 
 // Start begins the server's operations, listening for incoming connections.
+// It is equivalent to calling Listen followed by Serve.
 func (s *Server) Start() error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	return s.Serve()
+}
+
+// Listen binds the TCP listener and the auxiliary listeners without accepting connections
+// yet. If any of them cannot bind, the ones already bound are released again.
+// Binding to port 0 picks an ephemeral port; use Addr to find out which one.
+func (s *Server) Listen() error {
 	listener, err := net.Listen("tcp", s.listenAddress)
 	if err != nil {
 		log.Printf("Error listening on %s: %v", s.listenAddress, err)
 		return err
 	}
+	for i, aux := range s.auxiliaries {
+		if err := aux.Listen(); err != nil {
+			log.Printf("Error starting %s: %v", aux.Name(), err)
+			for _, bound := range s.auxiliaries[:i] {
+				bound.Close()
+			}
+			listener.Close()
+			return fmt.Errorf("starting %s: %w", aux.Name(), err)
+		}
+	}
 	s.listener = listener
-	log.Printf("Server listening for TCP connections on %s", s.listenAddress)
+	log.Printf("Server listening for TCP connections on %s", listener.Addr().String())
+	return nil
+}
 
-	// For now, keep the simple global UDP echo server from main.go if needed for testing,
-	// or integrate a general purpose UDP port here if the design changes.
-	// Game-specific UDP will be handled by GameSession instances on their own ports.
+// Addr returns the address the TCP listener is bound to, or nil before Listen.
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Serve runs the auxiliary listeners and accepts connections on the listener bound by Listen
+// until it is closed. If an auxiliary listener fails, the server stops and Serve returns
+// its error; otherwise the error is the one that ended the accept loop. Either way the
+// auxiliary listeners have stopped by the time Serve returns.
+func (s *Server) Serve() error {
+	if s.listener == nil {
+		return errors.New("server is not listening; call Listen first")
+	}
+	// Game-specific UDP is handled by GameSession instances on their own ports.
+	s.startAuxiliaries()
 
 	// Accept connections in a loop
 	for {
@@ -57,6 +138,13 @@ func (s *Server) Start() error {
 			// Depending on the error, we might want to break or continue
 			if opErr, ok := err.(*net.OpError); ok && !opErr.Temporary() {
 				log.Println("Permanent error accepting connections. Shutting down listener.")
+				s.stopAuxiliaries()
+				s.mu.Lock()
+				auxErr := s.auxErr
+				s.mu.Unlock()
+				if auxErr != nil {
+					return auxErr
+				}
 				return err // Stop if listener is closed or has a permanent error
 			}
 			continue // Continue for temporary errors
@@ -66,15 +154,61 @@ func (s *Server) Start() error {
 	}
 }
 
-// Stop gracefully shuts down the server.
+// Stop gracefully shuts down the server. It returns once the auxiliary listeners have
+// released their sockets.
 func (s *Server) Stop() {
 	log.Println("Stopping server...")
 	if s.listener != nil {
 		s.listener.Close()
 	}
+	s.stopAuxiliaries()
 	// Add cleanup for other resources if necessary (e.g., active sessions)
 }
 
+// startAuxiliaries runs each auxiliary listener in a goroutine of its own. The first one to
+// fail records its error and closes the TCP listener, which ends Serve.
+func (s *Server) startAuxiliaries() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.cancelAux, s.auxErr = cancel, nil
+	s.mu.Unlock()
+	for _, aux := range s.auxiliaries {
+		s.auxWG.Add(1)
+		go func(aux AuxiliaryListener) {
+			defer s.auxWG.Done()
+			err := aux.Serve(ctx)
+			if err == nil || ctx.Err() != nil {
+				return
+			}
+			log.Printf("%s failed: %v. Stopping the server.", aux.Name(), err)
+			s.mu.Lock()
+			if s.auxErr == nil {
+				s.auxErr = fmt.Errorf("%s: %w", aux.Name(), err)
+			}
+			s.mu.Unlock()
+			cancel()
+			s.listener.Close()
+		}(aux)
+	}
+}
+
+// stopAuxiliaries stops the auxiliary listeners and waits for them to return. The ones
+// bound by a Listen that was never followed by Serve are closed instead.
+func (s *Server) stopAuxiliaries() {
+	s.mu.Lock()
+	cancel := s.cancelAux
+	s.cancelAux = nil
+	s.mu.Unlock()
+	if cancel == nil {
+		for _, aux := range s.auxiliaries {
+			aux.Close()
+		}
+		return
+	}
+	cancel()
+	s.auxWG.Wait()
+}
+
 // handleConnection manages an individual client connection.
 func (s *Server) handleConnection(conn net.Conn) {
 	defer func() {
@@ -89,14 +223,13 @@ func (s *Server) handleConnection(conn net.Conn) {
 	var playerAccount *models.PlayerAccount
 	var err error
 
-	// Expect LoginRequest
+	// Expect LoginRequest, or a health ping from a client checking it can reach us
 	// In a more robust system, we'd have a loop reading TCPMessage envelopes
 	// For Sprint 1, assume first message after connect is LoginRequest
 	decoder := json.NewDecoder(conn)
-	encoder := json.NewEncoder(conn) // For sending responses
 
-	var loginReq network.LoginRequest
-	if err = decoder.Decode(&loginReq); err != nil {
+	var first json.RawMessage
+	if err = decoder.Decode(&first); err != nil {
 		if err == io.EOF {
 			log.Printf("Client %s disconnected before login.", clientAddr)
 			return
@@ -105,31 +238,81 @@ func (s *Server) handleConnection(conn net.Conn) {
 		// Optionally send an error response if possible
 		return
 	}
+	if isHealthPing(first) {
+		s.answerHealthPing(conn)
+		return
+	}
+	var loginReq network.LoginRequest
+	if err = json.Unmarshal(first, &loginReq); err != nil {
+		log.Printf("Error decoding login request from %s: %v", clientAddr, err)
+		return
+	}
 
 	playerAccount, err = s.authManager.Login(loginReq.Username, loginReq.Password, clientAddr)
 	if err != nil {
 		log.Printf("Authentication failed for user '%s' from %s: %v", loginReq.Username, clientAddr, err)
 		response := network.LoginResponse{Success: false, Message: err.Error()}
-		if encErr := encoder.Encode(response); encErr != nil {
+		if encErr := writeTCPMessage(conn, response); encErr != nil {
 			log.Printf("Error sending login failure response to %s: %v", clientAddr, encErr)
 		}
 		return // Authentication failed, close connection.
 	}
 
 	log.Printf("User '%s' authenticated successfully from %s.", playerAccount.Username, clientAddr)
-	response := network.LoginResponse{Success: true, Message: "Login successful", Player: playerAccount}
-	if err := encoder.Encode(response); err != nil {
+	pendingResults, err := persistence.LoadAndClearPendingResults(playerAccount.Username)
+	if err != nil {
+		log.Printf("Error loading pending results for %s: %v", playerAccount.Username, err)
+	}
+	protocolVersion := network.NegotiateProtocolVersion(loginReq.ProtocolVersion)
+	response := network.LoginResponse{
+		Success:           true,
+		Message:           "Login successful",
+		Player:            network.NewOwnProfile(playerAccount),
+		ProtocolVersion:   protocolVersion,
+		HasPendingResults: len(pendingResults) > 0,
+	}
+	if protocolVersion >= network.ProtocolVersionReconnect {
+		if session, ok := s.sessionManager.FindPlayerSession(playerAccount.Username); ok {
+			response.ActiveGameID = session.ID
+		}
+	}
+	if err := writeTCPMessage(conn, response); err != nil {
 		log.Printf("Error sending login success response to %s: %v", clientAddr, err)
 		s.authManager.Logout(playerAccount.Username) // Rollback active user status
+		restorePendingResults(playerAccount.Username, pendingResults)
 		return
 	}
+	if len(pendingResults) > 0 {
+		pendingMsg := network.TCPMessage{Type: network.MsgTypePendingResults, Payload: pendingResults}
+		if err := writeTCPMessage(conn, pendingMsg); err != nil {
+			log.Printf("Error delivering %d pending results to %s: %v", len(pendingResults), playerAccount.Username, err)
+			restorePendingResults(playerAccount.Username, pendingResults)
+			s.authManager.Logout(playerAccount.Username)
+			return
+		}
+		log.Printf("Delivered %d pending results to %s.", len(pendingResults), playerAccount.Username)
+	}
 
 	// 2. Post-Authentication: Matchmaking or other actions
-	// For Sprint 1, directly proceed to matchmaking.
-	// A more advanced server would wait for a MatchmakingRequest PDU.
-	// The current HandleMatchmakingRequest is designed to be called directly.
-	log.Printf("User '%s' proceeding to matchmaking.", playerAccount.Username)
-	HandleMatchmakingRequest(conn, playerAccount) // This function will block until match or timeout
+	// Current clients send requests (e.g. settings updates) and then ask for matchmaking;
+	// older ones proceed to matchmaking directly.
+	// Older clients only know the ranked queue.
+	queueType := network.QueueRanked
+	var preferredDuration time.Duration // Older clients have no preference
+	if protocolVersion >= network.ProtocolVersionRequests {
+		end, requested, duration := serveAccountRequests(conn, decoder, playerAccount, protocolVersion)
+		switch end {
+		case requestsDisconnected:
+			s.authManager.Logout(playerAccount.Username) // Left before matchmaking
+			return
+		case requestsReconnected:
+			log.Printf("Client %s has completed its reconnected game.", clientAddr)
+			return
+		}
+		queueType, preferredDuration = requested, duration
+	}
+	log.Printf("User '%s' proceeding to %s matchmaking.", playerAccount.Username, queueType)
+	HandleMatchmakingRequest(conn, decoder, playerAccount, protocolVersion, queueType, preferredDuration) // This function will block until match or timeout
 
 	// After HandleMatchmakingRequest returns, the TCP connection's role for this client might be over,
 	// or it might be kept for game end results. The current Matchmaking logic sends MatchFoundResponse
@@ -142,36 +325,45 @@ func (s *Server) handleConnection(conn net.Conn) {
 	log.Printf("Client %s has completed its initial TCP interaction (auth + matchmaking).", clientAddr)
 }
 
-// Optional: Run a simple UDP echo server on a known port for basic UDP testing.
-// This is separate from game-specific UDP ports.
-func StartGlobalUDPEchoServer(address string) {
-	udpAddr, err := net.ResolveUDPAddr("udp", address)
-	if err != nil {
-		log.Printf("Error resolving global UDP address %s: %v", address, err)
-		os.Exit(1)
+// isHealthPing reports whether the first message on a connection is a MsgTypeHealthPing
+// rather than a LoginRequest.
+func isHealthPing(first json.RawMessage) bool {
+	var envelope struct {
+		Type string `json:"type"`
 	}
+	return json.Unmarshal(first, &envelope) == nil && envelope.Type == network.MsgTypeHealthPing
+}
 
-	conn, err := net.ListenUDP("udp", udpAddr)
-	if err != nil {
-		log.Printf("Error listening on global UDP %s: %v", address, err)
-		os.Exit(1)
+// answerHealthPing answers a health ping with the server's protocol version and where its
+// UDP check responder listens. The connection is closed afterwards.
+func (s *Server) answerHealthPing(conn net.Conn) {
+	pong := network.HealthPong{ProtocolVersion: network.ProtocolVersion}
+	if s.udpCheck != nil {
+		pong.UDPCheckHost = CurrentNetworkConfig().EffectiveAdvertiseHost()
+		pong.UDPCheckPort = s.udpCheck.Port()
 	}
-	defer conn.Close()
-	log.Printf("Global UDP echo server listening on %s", address)
+	if err := writeTCPMessage(conn, network.TCPMessage{Type: network.MsgTypeHealthPong, Payload: pong}); err != nil {
+		log.Printf("Error answering health ping from %s: %v", conn.RemoteAddr(), err)
+	}
+}
 
-	buf := make([]byte, 1024)
-	for {
-		n, remoteAddr, err := conn.ReadFromUDP(buf)
-		if err != nil {
-			log.Printf("Error reading from global UDP: %v", err)
-			continue
-		}
-		receivedMsg := string(buf[:n])
-		log.Printf("Global UDP: Received from %s: %s", remoteAddr, receivedMsg)
+// writeTCPMessage sends msg to a client as one JSON line. The write gives up after the
+// configured TCPWriteTimeout, so a client that has stopped reading fails the delivery instead
+// of blocking the caller. A timed-out write may have sent part of the line, so the caller
+// should treat the connection as unusable.
+func writeTCPMessage(conn net.Conn, msg interface{}) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(CurrentNetworkConfig().TCPWriteTimeout)); err != nil {
+		return err
+	}
+	defer conn.SetWriteDeadline(time.Time{})
+	return json.NewEncoder(conn).Encode(msg)
+}
 
-		_, err = conn.WriteToUDP([]byte("UDP Echo: "+receivedMsg), remoteAddr)
-		if err != nil {
-			log.Printf("Error writing to global UDP: %v", err)
+// restorePendingResults puts results back into the pending store after a failed delivery attempt.
+func restorePendingResults(username string, results []network.GameOverResults) {
+	for _, result := range results {
+		if err := persistence.SavePendingResult(username, result); err != nil {
+			log.Printf("Error restoring pending result for %s: %v", username, err)
 		}
 	}
 }
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// ForceOutcome is the result an operator imposes when ending a session early.
+type ForceOutcome string
+
+const (
+	ForceOutcomeDraw    ForceOutcome = "draw"             // Both players draw
+	ForceOutcomePlayer1 ForceOutcome = "p1"               // Player 1 wins
+	ForceOutcomePlayer2 ForceOutcome = "p2"               // Player 2 wins
+	ForceOutcomeTimeout ForceOutcome = "timeout-evaluate" // Apply the normal timeout tiebreaker now
+)
+
+// ErrGameAlreadyOver is returned when controlling a session that has already ended.
+var ErrGameAlreadyOver = errors.New("game is already over")
+
+// ErrNotInSession is returned by RotatePlayerToken for a player who is not in the session.
+var ErrNotInSession = errors.New("player is not in this session")
+
+// ErrGameNotStarted is returned when fast-forwarding a session still in its warm-up.
+var ErrGameNotStarted = errors.New("game has not started yet")
+
+// ParseForceOutcome converts an operator-supplied outcome name into a ForceOutcome.
+func ParseForceOutcome(s string) (ForceOutcome, error) {
+	switch outcome := ForceOutcome(s); outcome {
+	case ForceOutcomeDraw, ForceOutcomePlayer1, ForceOutcomePlayer2, ForceOutcomeTimeout:
+		return outcome, nil
+	}
+	return "", fmt.Errorf("unknown outcome %q (want draw, p1, p2 or timeout-evaluate)", s)
+}
+
+// ForceEnd ends the game immediately with the given outcome. It goes through
+// determineWinnerAndStop, so EXP, persistence and GameOverResults happen exactly as for
+// a game that ended on its own. reason is recorded in the result text and the logs.
+func (gs *GameSession) ForceEnd(reason string, outcome ForceOutcome) error {
+	if _, err := ParseForceOutcome(string(outcome)); err != nil {
+		return err
+	}
+
+	defer gs.settleEnding() // Once gs.mu is released
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.State().Finished() {
+		return ErrGameAlreadyOver
+	}
+
+	gs.logf("[GameSession %s] Force-ending game with outcome %s: %s", gs.ID, outcome, reason)
+	if outcome == ForceOutcomeTimeout {
+		gs.determineWinnerAndStop("timeout")
+		return nil
+	}
+	gs.forcedOutcome = outcome
+	gs.forcedReason = reason
+	gs.determineWinnerAndStop("forced")
+	return nil
+}
+
+// FastForward moves the game's end time d closer and returns the time left afterwards.
+// A game pushed past its end time finishes on the next tick through the normal timeout path.
+func (gs *GameSession) FastForward(d time.Duration) (time.Duration, error) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.State().Finished() {
+		return 0, ErrGameAlreadyOver
+	}
+	if !gs.State().InCombat() {
+		return 0, ErrGameNotStarted
+	}
+
+	gs.gameEndTime = gs.gameEndTime.Add(-d)
+	remaining := time.Until(gs.gameEndTime)
+	if remaining < 0 {
+		remaining = 0
+	}
+	gs.logf("[GameSession %s] Fast-forwarded by %v; %v remaining.", gs.ID, d, remaining)
+	return remaining, nil
+}
+
+// Abort tears the session down without producing results, for a game that never really
+// started (e.g. a player could not be told about the match, or cannot reach its UDP port).
+// The UDP port is released and the game loop exits on its next tick. It reports whether
+// this call aborted the session; false if it had already ended or been aborted.
+func (gs *GameSession) Abort(reason string) bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.State().Finished() {
+		return false
+	}
+	gs.transition(StateAborted)
+	gs.endReason = reason
+	gs.logf("[GameSession %s] Aborted: %s", gs.ID, reason)
+	gs.Stop()
+	return true
+}
+
+// RotatePlayerToken issues a new session token to a player who has reconnected, e.g. from a
+// restarted client or a new network. The old token stops working at once: packets still
+// carrying it, including ones already queued, are dropped, so a leaked token is only good
+// until the player reconnects. The player's UDP address is forgotten until a packet with the
+// new token arrives, and their command sequence starts over, as a new client's does.
+// protocolVersion is the version the reconnecting client negotiated. It returns the new
+// token and whether the player is Player 1.
+func (gs *GameSession) RotatePlayerToken(username string, protocolVersion int) (string, bool, error) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.State().Finished() {
+		return "", false, ErrGameAlreadyOver
+	}
+	var player *models.PlayerInGame
+	switch username {
+	case gs.Player1.Account.Username:
+		player = gs.Player1
+	case gs.Player2.Account.Username:
+		player = gs.Player2
+	default:
+		return "", false, ErrNotInSession
+	}
+
+	oldToken, newToken := player.SessionToken, newSessionToken()
+	player.SessionToken = newToken
+	if protocolVersion == 0 {
+		protocolVersion = network.ProtocolVersion
+	}
+	gs.playerProtocols[newToken] = protocolVersion
+	gs.tokenExpiry[newToken] = gs.tokenExpiry[oldToken]
+	gs.droppedActions[newToken] = gs.droppedActions[oldToken]
+	if checks := gs.commandChecks[oldToken]; checks != nil { // Flags follow the player, not the token
+		gs.commandChecks[newToken] = checks
+	}
+	gs.processedDeployCommands[newToken] = make(map[uint32]time.Time)
+	delete(gs.playerProtocols, oldToken)
+	delete(gs.tokenExpiry, oldToken)
+	delete(gs.droppedActions, oldToken)
+	delete(gs.commandChecks, oldToken)
+	delete(gs.processedDeployCommands, oldToken)
+	delete(gs.prunedDeploySeq, oldToken)
+	delete(gs.lastProcessedSeq, oldToken)
+	delete(gs.commandWatermarks, oldToken)
+	delete(gs.playerClientAddresses, oldToken)
+	delete(gs.lastFullState, oldToken)
+	gs.lastStateDigest = "" // The new client has no state yet: send it a full update next
+	gs.logf("[GameSession %s] Player %s reconnected; token %s replaced by %s.", gs.ID, username, oldToken, newToken)
+	return newToken, player == gs.Player1, nil
+}
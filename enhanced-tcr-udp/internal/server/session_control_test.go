@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// After a player reconnects and their token is rotated, the old token is dead: its packets
+// set no address and get no answer, and a command queued with it before the rotation is
+// dropped. The new token is answered and its commands are applied, sequence starting over.
+func TestRotatedTokenReplacesOldOne(t *testing.T) {
+	gs := newTestSession(t, SessionOptions{})
+	cost := gs.Config.Troops["pawn"].ManaCost
+	gs.mu.Lock()
+	startTestCombat(t, gs, time.Now())
+	gs.Player1.CurrentMana = 2 * cost
+	gs.mu.Unlock()
+
+	conn, err := net.DialUDP("udp", nil, gs.udpConn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("dialing the session: %v", err)
+	}
+	defer conn.Close()
+	// ping sends a ping with token and reports whether the session answered it
+	ping := func(token string) bool {
+		t.Helper()
+		data, err := json.Marshal(network.UDPMessage{Type: network.UDPMsgTypePing, SessionID: gs.ID, PlayerToken: token, Payload: network.PingUDP{}})
+		if err != nil {
+			t.Fatalf("encoding a ping: %v", err)
+		}
+		if _, err := conn.Write(data); err != nil {
+			t.Fatalf("sending a ping: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := conn.Read(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return false
+			}
+			if err != nil {
+				t.Fatalf("reading the answer: %v", err)
+			}
+			var msg network.UDPMessage
+			if json.Unmarshal(buf[:n], &msg) == nil && msg.Type == network.UDPMsgTypePong {
+				return true
+			}
+		}
+	}
+	hasAddress := func(token string) bool {
+		gs.mu.Lock()
+		defer gs.mu.Unlock()
+		_, ok := gs.playerClientAddresses[token]
+		return ok
+	}
+	troops := func() int {
+		gs.mu.Lock()
+		defer gs.mu.Unlock()
+		return len(gs.Player1.DeployedTroops)
+	}
+
+	const oldToken = "token-alice"
+	if !ping(oldToken) || !hasAddress(oldToken) {
+		t.Fatal("alice's ping before the rotation went unanswered")
+	}
+	deployAs(t, gs, oldToken, 1, "pawn")
+	if got := troops(); got != 1 {
+		t.Fatalf("%d troops after deploying with the current token, want 1", got)
+	}
+
+	newToken, isPlayer1, err := gs.RotatePlayerToken("alice", 0)
+	if err != nil || !isPlayer1 || newToken == oldToken || gs.Player1.SessionToken != newToken {
+		t.Fatalf("RotatePlayerToken: token %q, player 1 %t, %v; want a new player 1 token", newToken, isPlayer1, err)
+	}
+	if hasAddress(oldToken) || hasAddress(newToken) {
+		t.Error("an address is still known for alice after the rotation")
+	}
+
+	dropped := gs.udpDrops.Counts().BadSession
+	if ping(oldToken) || hasAddress(oldToken) {
+		t.Error("the old token was answered after the rotation")
+	}
+	if got := gs.udpDrops.Counts().BadSession; got != dropped+1 {
+		t.Errorf("%d packets dropped for a bad token, want the old token's ping among them (%d)", got, dropped+1)
+	}
+	deployAs(t, gs, oldToken, 2, "pawn") // Queued before the rotation
+	if got := troops(); got != 1 {
+		t.Errorf("%d troops after a deploy with the old token, want it dropped", got)
+	}
+
+	if !ping(newToken) || !hasAddress(newToken) {
+		t.Error("the new token was not answered")
+	}
+	deployAs(t, gs, newToken, 1, "pawn") // The reconnected client numbers its commands from 1
+	if got := troops(); got != 2 {
+		t.Errorf("%d troops after a deploy with the new token, want 2", got)
+	}
+
+	if _, _, err := gs.RotatePlayerToken("mallory", 0); !errors.Is(err, ErrNotInSession) {
+		t.Errorf("rotating the token of a stranger: %v, want %v", err, ErrNotInSession)
+	}
+}
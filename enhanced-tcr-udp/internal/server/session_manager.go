@@ -1,18 +1,46 @@
 package server
 
 import (
-	"enhanced-tcr-udp/internal/models"
-	"enhanced-tcr-udp/internal/network"
+	"enhanced-tcr-udp/internal/persistence"
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+	"fmt"
 	"log"
+	"maps"
 	"sync"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 // Manages game sessions
 
+const (
+	// watchdogInterval is how often the watchdog inspects running sessions.
+	watchdogInterval = 1 * time.Second
+	// sessionStallThreshold is how long a running session may go without completing a tick
+	// before the watchdog flags it as stalled.
+	sessionStallThreshold = 5 * time.Second
+	// sessionLogCleanupInterval is how often old session logs are looked for.
+	sessionLogCleanupInterval = time.Hour
+)
+
 // GameSessionManager manages all active game sessions.
 type GameSessionManager struct {
 	sessions map[string]*GameSession // gameID -> GameSession
+	players  map[string]string       // Username -> gameID of the latest session created for that player
 	mu       sync.RWMutex
+	rules    models.GameRules // Rules applied to every new session
+
+	sessionLogs      bool                              // Give new sessions their own log file
+	sessionLogMaxAge time.Duration                     // Session logs older than this are removed; 0 keeps them
+	captureSessions  bool                              // Record new sessions' UDP messages next to their logs
+	exportAnalytics  bool                              // New sessions write an anonymized record for balance analysis
+	handicaps        map[string]models.PlayerOverrides // Username -> handicap for new casual sessions; see SetHandicap
+	cleanupOnce      sync.Once
+
+	watchdogOnce sync.Once
+	stalled      map[string]bool // gameID -> already reported as stalled
 	// Config can be added here later, e.g., reference to game rules, troop/tower specs
 }
 
@@ -20,35 +48,193 @@ type GameSessionManager struct {
 func NewGameSessionManager() *GameSessionManager {
 	return &GameSessionManager{
 		sessions: make(map[string]*GameSession),
+		players:  make(map[string]string),
+		rules:    models.DefaultGameRules(),
+		stalled:  make(map[string]bool),
 	}
 }
 
-// CreateSession creates a new game session for two players.
-func (gsm *GameSessionManager) CreateSession(gameID string, player1, player2 *models.PlayerAccount, udpPort int, resultsChan chan<- network.GameResultInfo) *GameSession {
+// SetRules changes the rules used for sessions created from now on.
+// Sessions already in progress keep the rules they were created with.
+func (gsm *GameSessionManager) SetRules(rules models.GameRules) {
 	gsm.mu.Lock()
 	defer gsm.mu.Unlock()
+	gsm.rules = rules
+}
+
+// Rules returns the rules new sessions are created with.
+func (gsm *GameSessionManager) Rules() models.GameRules {
+	gsm.mu.RLock()
+	defer gsm.mu.RUnlock()
+	return gsm.rules
+}
 
-	if _, exists := gsm.sessions[gameID]; exists {
-		log.Printf("Error: Game session %s already exists.", gameID)
-		return nil // Or handle error appropriately
+// SetSessionLogging turns per-session log files on or off for sessions created from now
+// on. With a positive retention, a background job also removes session logs older than
+// that, checking once at startup and then every sessionLogCleanupInterval.
+func (gsm *GameSessionManager) SetSessionLogging(enabled bool, retention time.Duration) {
+	gsm.mu.Lock()
+	gsm.sessionLogs = enabled
+	gsm.sessionLogMaxAge = retention
+	gsm.mu.Unlock()
+	if retention > 0 {
+		gsm.cleanupOnce.Do(func() { go gsm.runSessionLogCleanup() })
 	}
+}
 
-	// TODO: Load full game config (troops, towers) here or pass it to NewGameSession
-	// For now, NewGameSession will be simple.
-	// Use player usernames as session tokens for now.
-	// In a more robust system, these tokens might be generated uniquely.
-	p1Token := player1.Username
-	p2Token := player2.Username
-	session := NewGameSession(gameID, player1, player2, p1Token, p2Token, udpPort, resultsChan)
-	if session == nil { // NewGameSession can return nil if config loading fails
-		log.Printf("Failed to create new game session %s due to initialization error.", gameID)
+// SetSessionCapture turns message capture on or off for sessions created from now on. Each
+// captured session writes its UDP traffic to persistence.SessionCapturePath, and captures
+// are removed with the session logs.
+func (gsm *GameSessionManager) SetSessionCapture(enabled bool) {
+	gsm.mu.Lock()
+	defer gsm.mu.Unlock()
+	gsm.captureSessions = enabled
+}
+
+// SetAnalyticsExport turns the anonymized analytics export on or off for sessions created
+// from now on. Each such session appends a persistence.AnalyticsRecord when it ends.
+func (gsm *GameSessionManager) SetAnalyticsExport(enabled bool) {
+	gsm.mu.Lock()
+	defer gsm.mu.Unlock()
+	gsm.exportAnalytics = enabled
+}
+
+// SetHandicap gives the player a handicap for their casual games from now on, replacing any
+// they had; an empty one removes it. Ranked games are never handicapped.
+func (gsm *GameSessionManager) SetHandicap(username string, overrides models.PlayerOverrides) error {
+	gsm.mu.Lock()
+	defer gsm.mu.Unlock()
+	if overrides.IsZero() {
+		delete(gsm.handicaps, username)
 		return nil
 	}
-	gsm.sessions[gameID] = session
+	if err := overrides.Validate(gsm.rules.MaxMana); err != nil {
+		return err
+	}
+	if gsm.handicaps == nil {
+		gsm.handicaps = make(map[string]models.PlayerOverrides)
+	}
+	gsm.handicaps[username] = overrides
+	return nil
+}
+
+// Handicaps returns a copy of the handicaps set with SetHandicap.
+func (gsm *GameSessionManager) Handicaps() map[string]models.PlayerOverrides {
+	gsm.mu.RLock()
+	defer gsm.mu.RUnlock()
+	return maps.Clone(gsm.handicaps)
+}
+
+// applyHandicaps returns rules with the handicaps of the session's players added to its
+// PerPlayerOverrides, or rules itself if neither player has one. The caller must hold gsm.mu.
+func (gsm *GameSessionManager) applyHandicaps(rules *models.GameRules, players ...*models.PlayerAccount) *models.GameRules {
+	var withHandicaps *models.GameRules
+	for _, player := range players {
+		overrides, ok := gsm.handicaps[player.Username]
+		if !ok {
+			continue
+		}
+		if withHandicaps == nil {
+			copied := *rules
+			copied.PerPlayerOverrides = maps.Clone(rules.PerPlayerOverrides)
+			if copied.PerPlayerOverrides == nil {
+				copied.PerPlayerOverrides = make(map[string]models.PlayerOverrides)
+			}
+			withHandicaps = &copied
+		}
+		withHandicaps.PerPlayerOverrides[player.Username] = overrides
+		log.Printf("Applying handicap to %s: %s", player.Username, overrides)
+	}
+	if withHandicaps == nil {
+		return rules
+	}
+	return withHandicaps
+}
+
+// runSessionLogCleanup removes expired session logs now and then periodically.
+func (gsm *GameSessionManager) runSessionLogCleanup() {
+	ticker := time.NewTicker(sessionLogCleanupInterval)
+	defer ticker.Stop()
+
+	for now := time.Now(); ; now = <-ticker.C {
+		gsm.mu.RLock()
+		retention := gsm.sessionLogMaxAge
+		gsm.mu.RUnlock()
+		if retention <= 0 {
+			continue
+		}
+		removed, err := persistence.CleanupSessionLogs(retention, now)
+		if err != nil {
+			log.Printf("[SessionLogs] Error removing old session logs: %v", err)
+		}
+		if removed > 0 {
+			log.Printf("[SessionLogs] Removed %d session logs older than %v.", removed, retention)
+		}
+	}
+}
+
+// CreateSession creates and starts a new game session for two players. The manager issues
+// the players' session tokens (unless opts already carries them) and applies its rules when
+// opts.Rules is nil. The tokens are available afterwards as session.PlayerN.SessionToken.
+func (gsm *GameSessionManager) CreateSession(opts SessionOptions) (*GameSession, error) {
+	gsm.mu.Lock()
+	defer gsm.mu.Unlock()
+
+	if _, exists := gsm.sessions[opts.GameID]; exists {
+		log.Printf("Error: Game session %s already exists.", opts.GameID)
+		return nil, fmt.Errorf("game session %s already exists", opts.GameID)
+	}
+	if opts.Player1 != nil && opts.Player2 != nil && opts.Player1.Username == opts.Player2.Username {
+		log.Printf("Error: Refusing to create game session %s with %s on both sides.", opts.GameID, opts.Player1.Username)
+		return nil, fmt.Errorf("%w: %s", ErrSamePlayer, opts.Player1.Username)
+	}
+	// Results are applied to a player's account per game, so one player in two running
+	// games would be rewarded twice
+	for _, player := range []*models.PlayerAccount{opts.Player1, opts.Player2} {
+		if player == nil {
+			continue
+		}
+		if current := gsm.runningSessionOf(player.Username); current != nil {
+			log.Printf("Error: Refusing to create game session %s: %s is still in game %s.", opts.GameID, player.Username, current.ID)
+			return nil, fmt.Errorf("%w: %s is in game %s", ErrPlayerInGame, player.Username, current.ID)
+		}
+	}
 
-	log.Printf("Game session %s created for %s and %s on UDP port %d", gameID, player1.Username, player2.Username, udpPort)
+	if opts.Player1Token == "" {
+		opts.Player1Token = newSessionToken()
+	}
+	if opts.Player2Token == "" {
+		opts.Player2Token = newSessionToken()
+	}
+	if opts.Rules == nil {
+		rules := gsm.rules
+		opts.Rules = &rules
+	}
+	if opts.QueueType == network.QueueCasual && opts.Player1 != nil && opts.Player2 != nil {
+		opts.Rules = gsm.applyHandicaps(opts.Rules, opts.Player1, opts.Player2)
+	}
+	opts.SessionLog = opts.SessionLog || gsm.sessionLogs
+	opts.Capture = opts.Capture || gsm.captureSessions
+	opts.Analytics = opts.Analytics || gsm.exportAnalytics
+
+	session, err := NewGameSession(opts)
+	if err != nil {
+		log.Printf("Failed to create new game session %s: %v", opts.GameID, err)
+		return nil, err
+	}
+	gsm.sessions[opts.GameID] = session
+	gsm.players[opts.Player1.Username] = opts.GameID
+	gsm.players[opts.Player2.Username] = opts.GameID
+	gsm.watchdogOnce.Do(func() { go gsm.runWatchdog() })
+
+	log.Printf("Game session %s created for %s and %s on UDP port %d", opts.GameID, opts.Player1.Username, opts.Player2.Username, opts.UDPPort)
 	go session.Start() // Start the game loop in a new goroutine
-	return session
+	return session, nil
+}
+
+// newSessionToken returns an unguessable token identifying one player within one session.
+func newSessionToken() string {
+	return uuid.New().String()
 }
 
 // GetSession retrieves an active game session by its ID.
@@ -59,10 +245,77 @@ func (gsm *GameSessionManager) GetSession(gameID string) (*GameSession, bool) {
 	return session, exists
 }
 
+// FindPlayerSession returns the running session the player is in, if any.
+func (gsm *GameSessionManager) FindPlayerSession(username string) (*GameSession, bool) {
+	gsm.mu.RLock()
+	defer gsm.mu.RUnlock()
+	session := gsm.runningSessionOf(username)
+	return session, session != nil
+}
+
+// runningSessionOf returns the session the player is in if it is still running, or nil.
+// The caller must hold gsm.mu.
+func (gsm *GameSessionManager) runningSessionOf(username string) *GameSession {
+	session := gsm.sessions[gsm.players[username]]
+	if session == nil || session.State().Finished() {
+		return nil
+	}
+	return session
+}
+
 // RemoveSession removes a game session, e.g., after it has ended.
 func (gsm *GameSessionManager) RemoveSession(gameID string) {
 	gsm.mu.Lock()
 	defer gsm.mu.Unlock()
 	delete(gsm.sessions, gameID)
+	delete(gsm.stalled, gameID)
+	for username, playerGameID := range gsm.players {
+		if playerGameID == gameID {
+			delete(gsm.players, username)
+		}
+	}
 	log.Printf("Game session %s removed.", gameID)
 }
+
+// ListSessions returns a snapshot of every session currently registered with the manager.
+// Each session is snapshotted outside the manager lock so a busy game loop cannot stall the manager.
+func (gsm *GameSessionManager) ListSessions() []SessionSnapshot {
+	gsm.mu.RLock()
+	sessions := make([]*GameSession, 0, len(gsm.sessions))
+	for _, session := range gsm.sessions {
+		sessions = append(sessions, session)
+	}
+	gsm.mu.RUnlock()
+
+	snapshots := make([]SessionSnapshot, 0, len(sessions))
+	for _, session := range sessions {
+		snapshots = append(snapshots, session.Snapshot())
+	}
+	return snapshots
+}
+
+// runWatchdog periodically flags running sessions whose game loop has not completed a tick
+// within sessionStallThreshold. A stalled session is reported once, and again if it recovers.
+func (gsm *GameSessionManager) runWatchdog() {
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		gsm.mu.Lock()
+		for gameID, session := range gsm.sessions {
+			sinceTick := now.Sub(session.LastTickAt())
+			stalled := sinceTick > sessionStallThreshold && !session.IsOver()
+			if stalled && !gsm.stalled[gameID] {
+				log.Printf("[Watchdog] Game session %s has not ticked for %v. It may be stalled.", gameID, sinceTick.Round(time.Millisecond))
+			} else if !stalled && gsm.stalled[gameID] {
+				log.Printf("[Watchdog] Game session %s is ticking again.", gameID)
+			}
+			if stalled {
+				gsm.stalled[gameID] = true
+			} else {
+				delete(gsm.stalled, gameID)
+			}
+		}
+		gsm.mu.Unlock()
+	}
+}
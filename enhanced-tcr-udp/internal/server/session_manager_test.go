@@ -0,0 +1,73 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"enhanced-tcr-udp/pkg/models"
+)
+
+func TestRemoveSessionForgetsStalledFlag(t *testing.T) {
+	gsm := NewGameSessionManager()
+	gs := newTestSession(t, SessionOptions{})
+	gsm.sessions[gs.ID] = gs
+	gsm.players[gs.Player1.Account.Username] = gs.ID
+	gsm.players[gs.Player2.Account.Username] = gs.ID
+	gsm.stalled[gs.ID] = true
+
+	gsm.RemoveSession(gs.ID)
+
+	if _, ok := gsm.stalled[gs.ID]; ok {
+		t.Errorf("stalled flag of removed session %s was kept", gs.ID)
+	}
+	if len(gsm.players) != 0 {
+		t.Errorf("players after RemoveSession = %v, want none", gsm.players)
+	}
+}
+
+// A player in a running game cannot be put in a second one, on either side; once their game
+// is removed they can be matched again.
+func TestCreateSessionRefusesPlayerInGame(t *testing.T) {
+	gsm := NewGameSessionManager()
+	create := func(gameID, player1, player2 string) (*GameSession, error) {
+		t.Helper()
+		gs, err := gsm.CreateSession(SessionOptions{
+			GameID:  gameID,
+			Player1: &models.PlayerAccount{Username: player1, Level: 1},
+			Player2: &models.PlayerAccount{Username: player2, Level: 1},
+			UDPHost: "127.0.0.1",
+		})
+		if gs != nil {
+			t.Cleanup(func() { gs.Abort("test over") })
+		}
+		return gs, err
+	}
+
+	first, err := create("first", "alice", "bob")
+	if err != nil {
+		t.Fatalf("creating the first game: %v", err)
+	}
+	for _, players := range [][2]string{{"alice", "carol"}, {"carol", "bob"}} {
+		if gs, err := create("second", players[0], players[1]); !errors.Is(err, ErrPlayerInGame) {
+			t.Errorf("second game for %s and %s: %v, want %v", players[0], players[1], err, ErrPlayerInGame)
+		} else if gs != nil {
+			t.Errorf("second game for %s and %s was created", players[0], players[1])
+		}
+	}
+	if gs, ok := gsm.FindPlayerSession("carol"); ok {
+		t.Errorf("carol is in game %s after the refusals, want none", gs.ID)
+	}
+	if _, ok := gsm.GetSession("second"); ok {
+		t.Error("the refused game is registered")
+	}
+
+	first.Abort("test over")
+	gsm.RemoveSession(first.ID)
+	second, err := create("second", "alice", "carol")
+	if err != nil {
+		t.Fatalf("creating a game for alice after hers was removed: %v", err)
+	}
+	if gs, ok := gsm.FindPlayerSession("alice"); !ok || gs != second {
+		t.Errorf("alice is in a session: %t, want the second game", ok)
+	}
+}
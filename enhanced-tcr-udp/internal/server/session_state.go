@@ -0,0 +1,92 @@
+package server
+
+import "fmt"
+
+// SessionState is where a GameSession is in its lifecycle. A session only moves along the
+// transitions in sessionTransitions; see GameSession.transition.
+type SessionState int32
+
+const (
+	StateCreated           SessionState = iota // Set up; the game loop has not started
+	StateWaitingForPlayers                     // Warm-up: waiting to hear from both players
+	StateCountdown                             // Counting down to combat
+	StateInProgress                            // Combat: the game clock runs
+	StatePaused                                // Combat stands still, waiting for a disconnected player
+	StateOvertime                              // Combat past the regular game duration; no rule enters it yet
+	StateEnded                                 // The game was decided and its results produced
+	StateAborted                               // Torn down without results
+)
+
+var sessionStateNames = [...]string{
+	StateCreated:           "created",
+	StateWaitingForPlayers: "waiting_for_players",
+	StateCountdown:         "countdown",
+	StateInProgress:        "in_progress",
+	StatePaused:            "paused",
+	StateOvertime:          "overtime",
+	StateEnded:             "ended",
+	StateAborted:           "aborted",
+}
+
+// sessionTransitions lists the states each state may move to. Any state but a final one
+// may end or be aborted; a pause returns to the state it interrupted.
+var sessionTransitions = map[SessionState][]SessionState{
+	StateCreated:           {StateWaitingForPlayers, StateEnded, StateAborted},
+	StateWaitingForPlayers: {StateCountdown, StateEnded, StateAborted},
+	StateCountdown:         {StateInProgress, StateEnded, StateAborted},
+	StateInProgress:        {StatePaused, StateOvertime, StateEnded, StateAborted},
+	StatePaused:            {StateInProgress, StateOvertime, StateEnded, StateAborted},
+	StateOvertime:          {StatePaused, StateEnded, StateAborted},
+}
+
+// String returns the state's name, e.g. "in_progress".
+func (s SessionState) String() string {
+	if s < 0 || int(s) >= len(sessionStateNames) {
+		return fmt.Sprintf("state(%d)", int32(s))
+	}
+	return sessionStateNames[s]
+}
+
+// MarshalText makes the state appear by name in JSON.
+func (s SessionState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Finished reports whether s is final: the session has ended or been aborted.
+func (s SessionState) Finished() bool {
+	return s == StateEnded || s == StateAborted
+}
+
+// InCombat reports whether combat has started and the game is not over in s.
+func (s SessionState) InCombat() bool {
+	return s == StateInProgress || s == StatePaused || s == StateOvertime
+}
+
+// CanTransition reports whether a session may move from state from to state to.
+func CanTransition(from, to SessionState) bool {
+	for _, next := range sessionTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// State returns where the session is in its lifecycle. It never blocks on gs.mu.
+func (gs *GameSession) State() SessionState {
+	return SessionState(gs.state.Load())
+}
+
+// transition moves the session to state to, logging the change. A transition that
+// CanTransition does not allow is logged and refused, leaving the state as it was. It
+// reports whether the session moved. Must be called with gs.mu held.
+func (gs *GameSession) transition(to SessionState) bool {
+	from := gs.State()
+	if !CanTransition(from, to) {
+		gs.logf("[GameSession %s] Refused state transition %s -> %s.", gs.ID, from, to)
+		return false
+	}
+	gs.state.Store(int32(to))
+	gs.logf("[GameSession %s] State %s -> %s.", gs.ID, from, to)
+	return true
+}
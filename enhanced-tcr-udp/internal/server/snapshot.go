@@ -0,0 +1,166 @@
+package server
+
+import (
+	"time"
+
+	"enhanced-tcr-udp/internal/persistence"
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// PlayerSnapshot is a copy of one player's in-game state.
+type PlayerSnapshot struct {
+	Username       string `json:"username"`
+	Level          int    `json:"level"`
+	SessionToken   string `json:"session_token"`
+	CurrentMana    int    `json:"current_mana"`
+	Quit           bool   `json:"quit"`
+	TroopCount     int    `json:"troop_count"`
+	DroppedActions int    `json:"dropped_actions"` // UDP actions discarded because this player's queue was full
+	// ImplausibleCommands counts this player's commands that failed a plausibility check;
+	// Flagged is set once they reach GameRules.CheatWarnThreshold.
+	ImplausibleCommands int  `json:"implausible_commands"`
+	Flagged             bool `json:"flagged,omitempty"`
+}
+
+// SessionSnapshot is a read-only view of a GameSession at a single instant.
+// Everything in it is copied, so callers may keep and inspect it without holding
+// the session lock and without racing the game loop.
+type SessionSnapshot struct {
+	SessionID     string                        `json:"session_id"`
+	UDPPort       int                           `json:"udp_port"`
+	Player1       PlayerSnapshot                `json:"player1"`
+	Player2       PlayerSnapshot                `json:"player2"`
+	Towers        []models.TowerInstance        `json:"towers"`
+	ActiveTroops  map[string]models.ActiveTroop `json:"active_troops"`
+	StartTime     time.Time                     `json:"start_time"`
+	LastTickAt    time.Time                     `json:"last_tick_at"`
+	LastInboundAt time.Time                     `json:"last_inbound_at"` // Last UDP datagram from either player; zero if none yet
+	TakenAt       time.Time                     `json:"taken_at"`
+	TimeRemaining time.Duration                 `json:"time_remaining"`
+	StartsIn      int                           `json:"starts_in,omitempty"` // Whole seconds of countdown left before combat
+	State         SessionState                  `json:"state"`
+	IsGameOver    bool                          `json:"is_game_over"` // The state is StateEnded or StateAborted
+	EndReason     string                        `json:"end_reason,omitempty"`
+	Result        string                        `json:"result,omitempty"`
+	OutboundUDP   OutboundUDPStats              `json:"outbound_udp"`
+	DroppedUDP    network.UDPDropCounts         `json:"dropped_udp"`        // Inbound datagrams discarded before reaching an action queue, by reason
+	DelayedUDP    uint64                        `json:"delayed_udp"`        // Actions that waited for room in a full queue
+	BusyUDP       uint64                        `json:"busy_udp"`           // Actions rejected with server_busy
+	LogPath       string                        `json:"log_path,omitempty"` // The session's own log file, if it has one
+	Spectators    int                           `json:"spectators"`
+	PausedFor     string                        `json:"paused_for,omitempty"` // Username of the disconnected player the game is paused for
+	Perf          persistence.MatchPerf         `json:"perf"`                 // Tick durations, troop peak and action queue high-water mark so far
+	// LastProcessedSeq is each player token's command watermark, as state updates echo it.
+	LastProcessedSeq map[string]uint32 `json:"last_processed_seq"`
+
+	combatStarted bool // Combat has begun, so the clock is running; see StateUpdate
+}
+
+// Snapshot returns a deep copy of the session's current state, taken under gs.mu. Only a
+// read lock is held, and only while copying, so the game loop waits no longer than that.
+func (gs *GameSession) Snapshot() SessionSnapshot {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.snapshotLocked(time.Now())
+}
+
+// snapshotLocked returns a deep copy of the session's state as of now. The caller must
+// hold gs.mu, for reading at least.
+func (gs *GameSession) snapshotLocked(now time.Time) SessionSnapshot {
+	snap := SessionSnapshot{
+		SessionID:     gs.ID,
+		UDPPort:       gs.udpPort,
+		Player1:       snapshotPlayer(gs.Player1, gs.player1Quit, gs.droppedActions, gs.commandChecks),
+		Player2:       snapshotPlayer(gs.Player2, gs.player2Quit, gs.droppedActions, gs.commandChecks),
+		Towers:        make([]models.TowerInstance, 0, len(gs.towers)),
+		ActiveTroops:  make(map[string]models.ActiveTroop, len(gs.activeTroops)),
+		StartTime:     gs.startTime,
+		LastTickAt:    gs.LastTickAt(),
+		LastInboundAt: gs.LastInboundAt(),
+		TakenAt:       now,
+		StartsIn:      gs.countdownSecondsLeft(now),
+		State:         gs.State(),
+		IsGameOver:    gs.State().Finished(),
+		EndReason:     gs.endReason,
+		Result:        gs.gameResult,
+		DroppedUDP:    gs.udpDrops.Counts(),
+		DelayedUDP:    gs.delayedActions.Load(),
+		BusyUDP:       gs.busyRejections.Load(),
+		LogPath:       gs.logPath,
+		Spectators:    gs.spectators.Count(),
+		Perf:          gs.perf.summary(),
+
+		LastProcessedSeq: gs.watermarks(),
+		combatStarted:    gs.combatStarted,
+	}
+	if gs.pausedFor != nil {
+		snap.PausedFor = gs.pausedFor.Account.Username
+	}
+	if gs.sender != nil {
+		snap.OutboundUDP = gs.sender.stats()
+	}
+	for _, tower := range gs.towers {
+		snap.Towers = append(snap.Towers, *tower)
+	}
+	for id, troop := range gs.activeTroops {
+		snap.ActiveTroops[id] = *troop
+	}
+	if state := gs.State(); !state.Finished() && !state.InCombat() {
+		snap.TimeRemaining = gs.Rules.GameDuration
+	} else if !state.Finished() {
+		snap.TimeRemaining = gs.gameEndTime.Sub(now)
+		if snap.TimeRemaining < 0 {
+			snap.TimeRemaining = 0
+		}
+	}
+	return snap
+}
+
+// StateUpdate returns the full game state as of the snapshot, marked as a snapshot: what
+// a player who has missed updates, or is rejoining the game, needs to redraw everything.
+func (snap SessionSnapshot) StateUpdate() network.GameStateUpdateUDP {
+	towers := make([]*models.TowerInstance, len(snap.Towers))
+	for i := range snap.Towers {
+		towers[i] = &snap.Towers[i]
+	}
+	troops := make(map[string]*models.ActiveTroop, len(snap.ActiveTroops))
+	for id, troop := range snap.ActiveTroops {
+		troop := troop
+		troops[id] = &troop
+	}
+	update := stateSource{
+		player1:          &models.PlayerInGame{Account: models.PlayerAccount{Username: snap.Player1.Username}, CurrentMana: snap.Player1.CurrentMana},
+		player2:          &models.PlayerInGame{Account: models.PlayerAccount{Username: snap.Player2.Username}, CurrentMana: snap.Player2.CurrentMana},
+		towers:           towers,
+		troops:           troops,
+		lastProcessedSeq: snap.LastProcessedSeq,
+		timeRemaining:    snap.TimeRemaining,
+		warmup:           !snap.combatStarted,
+		startsIn:         snap.StartsIn,
+		spectators:       snap.Spectators,
+	}.update(snap.TakenAt, false)
+	update.Snapshot = true
+	return update
+}
+
+// snapshotPlayer copies the scalar state of a player; the caller must hold gs.mu.
+func snapshotPlayer(p *models.PlayerInGame, quit bool, droppedActions map[string]int, commandChecks map[string]*commandPlausibility) PlayerSnapshot {
+	if p == nil {
+		return PlayerSnapshot{}
+	}
+	snap := PlayerSnapshot{
+		Username:       p.Account.Username,
+		Level:          p.Account.Level,
+		SessionToken:   p.SessionToken,
+		CurrentMana:    p.CurrentMana,
+		Quit:           quit,
+		TroopCount:     len(p.DeployedTroops),
+		DroppedActions: droppedActions[p.SessionToken],
+	}
+	if checks := commandChecks[p.SessionToken]; checks != nil {
+		snap.ImplausibleCommands = checks.Total()
+		snap.Flagged = checks.warned
+	}
+	return snap
+}
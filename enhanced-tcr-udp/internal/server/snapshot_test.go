@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// The full state a player gets on request or when rejoining is built from a snapshot; it
+// must say what a broadcast at the same instant says.
+func TestSnapshotStateUpdateMatchesBroadcast(t *testing.T) {
+	gs := newTestSession(t, SessionOptions{})
+	start := time.Now()
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	startTestCombat(t, gs, start)
+	gs.Player1.CurrentMana = 10
+	for seq, troop := range []string{"knight", "pawn"} {
+		gs.handlePlayerAction(network.UDPMessage{
+			Type:        network.UDPMsgTypeDeployTroop,
+			Seq:         uint32(seq + 1),
+			Timestamp:   time.Now(),
+			SessionID:   gs.ID,
+			PlayerToken: gs.Player1.SessionToken,
+			Payload:     json.RawMessage(`{"troop_id":"` + troop + `"}`),
+		})
+	}
+	gs.towers[0].CurrentHP -= 250
+	gs.towers[1].IsDestroyed, gs.towers[1].CurrentHP = true, 0
+	now := start.Add(1500 * time.Millisecond)
+
+	want := gs.buildStateUpdate(now, false)
+	want.Snapshot = true
+	got := gs.snapshotLocked(now).StateUpdate()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("StateUpdate() from a snapshot =\n%+v\nwant the broadcast at the same instant:\n%+v", got, want)
+	}
+	if len(got.ActiveTroops) != 2 || got.LastProcessedClientSeq[gs.Player1.SessionToken] != 2 || got.GameTimeRemainingSeconds == 0 {
+		t.Errorf("StateUpdate() left out the game in progress: %+v", got)
+	}
+
+	// A snapshot is a copy: later changes to the session do not reach it
+	snap := gs.snapshotLocked(now)
+	gs.towers[0].CurrentHP = 1
+	for _, troop := range gs.activeTroops {
+		troop.CurrentHP = 1
+	}
+	gs.commandWatermarks[gs.Player1.SessionToken].handled(3, now)
+	if after := snap.StateUpdate(); !reflect.DeepEqual(after, got) {
+		t.Errorf("a snapshot changed with the session:\n%+v\nwas\n%+v", after, got)
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// Errors returned by GameSession.AddSpectator.
+var (
+	ErrSpectatorIsPlayer = errors.New("players cannot spectate their own game")
+	ErrAlreadyWatching   = errors.New("already watching this game")
+)
+
+// spectatorRegistry tracks who is watching a session. It has its own lock so the count can
+// be read from the admin side without waiting on gs.mu.
+type spectatorRegistry struct {
+	mu       sync.Mutex
+	watching map[string]time.Time // Spectator username -> when they started watching
+}
+
+// newSpectatorRegistry creates an empty registry.
+func newSpectatorRegistry() *spectatorRegistry {
+	return &spectatorRegistry{watching: make(map[string]time.Time)}
+}
+
+// add registers a spectator and reports whether they were new.
+func (r *spectatorRegistry) add(username string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.watching[username]; ok {
+		return false
+	}
+	r.watching[username] = now
+	return true
+}
+
+// remove unregisters a spectator and reports whether they were watching.
+func (r *spectatorRegistry) remove(username string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.watching[username]; !ok {
+		return false
+	}
+	delete(r.watching, username)
+	return true
+}
+
+// Count returns how many spectators are watching.
+func (r *spectatorRegistry) Count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.watching)
+}
+
+// AddSpectator registers username as watching the game and tells both players. The count
+// goes out with every state update from then on.
+func (gs *GameSession) AddSpectator(username string) error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if username == gs.Player1.Account.Username || username == gs.Player2.Account.Username {
+		return ErrSpectatorIsPlayer
+	}
+	if !gs.spectators.add(username, time.Now()) {
+		return ErrAlreadyWatching
+	}
+	count := gs.spectators.Count()
+	gs.logf("[GameSession %s] Spectator %s joined (%d watching).", gs.ID, username, count)
+	gs.sendGameEventToAllPlayers(network.GameEventSpectatorJoined, network.SpectatorEvent{Spectator: username, Count: count})
+	return nil
+}
+
+// RemoveSpectator unregisters username, if they were watching, and tells both players.
+func (gs *GameSession) RemoveSpectator(username string) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if !gs.spectators.remove(username) {
+		return
+	}
+	count := gs.spectators.Count()
+	gs.logf("[GameSession %s] Spectator %s left (%d watching).", gs.ID, username, count)
+	gs.sendGameEventToAllPlayers(network.GameEventSpectatorLeft, network.SpectatorEvent{Spectator: username, Count: count})
+}
+
+// SpectatorCount returns how many spectators are watching. It never blocks on gs.mu.
+func (gs *GameSession) SpectatorCount() int {
+	return gs.spectators.Count()
+}
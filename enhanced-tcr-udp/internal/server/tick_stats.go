@@ -0,0 +1,69 @@
+package server
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"enhanced-tcr-udp/internal/persistence"
+)
+
+// tickStats collects how hard a game session worked, reported as a persistence.MatchPerf.
+// The game loop updates it under gs.mu; only the action queue high-water mark, raised by
+// the UDP reader, is atomic.
+type tickStats struct {
+	durations  []time.Duration // How long each completed tick took, from firing to unlock
+	peakTroops int             // Most active troops at once
+	peakQueue  atomic.Int64    // Most actions seen waiting in one player's queue
+}
+
+// recordTick adds a completed tick that took d and left troops active.
+func (t *tickStats) recordTick(d time.Duration, troops int) {
+	t.durations = append(t.durations, d)
+	t.observeTroops(troops)
+}
+
+// observeTroops raises the troop peak to troops if it is higher.
+func (t *tickStats) observeTroops(troops int) {
+	if troops > t.peakTroops {
+		t.peakTroops = troops
+	}
+}
+
+// observeQueue raises the action queue high-water mark to depth if it is higher. It may be
+// called without gs.mu.
+func (t *tickStats) observeQueue(depth int) {
+	for {
+		peak := t.peakQueue.Load()
+		if int64(depth) <= peak || t.peakQueue.CompareAndSwap(peak, int64(depth)) {
+			return
+		}
+	}
+}
+
+// summary returns the figures collected so far.
+func (t *tickStats) summary() persistence.MatchPerf {
+	perf := persistence.MatchPerf{
+		Ticks:          len(t.durations),
+		PeakTroops:     t.peakTroops,
+		PeakQueueDepth: int(t.peakQueue.Load()),
+	}
+	if len(t.durations) == 0 {
+		return perf
+	}
+	sorted := append([]time.Duration(nil), t.durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	perf.TickP50 = percentile(sorted, 50)
+	perf.TickP95 = percentile(sorted, 95)
+	perf.TickMax = sorted[len(sorted)-1]
+	return perf
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted, which must not be empty.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (len(sorted)*p + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
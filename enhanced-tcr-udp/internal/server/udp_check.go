@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// DefaultUDPCheckPort is the port the UDP check responder listens on unless told otherwise.
+const DefaultUDPCheckPort = 8007
+
+// UDPCheckResponder sends back every datagram that starts with network.UDPCheckPrefix, so a
+// client can check it reaches the server over UDP before it plays (see MsgTypeHealthPing).
+// Anything else is ignored, and replies are never larger than the request. Unlike the UDP
+// echo server it logs nothing per datagram. Register it with Server.SetUDPCheckResponder.
+type UDPCheckResponder struct {
+	address string
+
+	mu   sync.Mutex
+	conn *net.UDPConn // Bound by Listen; nil when not listening
+}
+
+// NewUDPCheckResponder creates a responder for address; port 0 picks an ephemeral port.
+func NewUDPCheckResponder(address string) *UDPCheckResponder {
+	return &UDPCheckResponder{address: address}
+}
+
+// Name implements AuxiliaryListener.
+func (r *UDPCheckResponder) Name() string {
+	return "UDP check responder"
+}
+
+// Listen binds the responder's socket.
+func (r *UDPCheckResponder) Listen() error {
+	udpAddr, err := net.ResolveUDPAddr("udp", r.address)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.conn = conn
+	r.mu.Unlock()
+	log.Printf("UDP check responder listening on %s", conn.LocalAddr().String())
+	return nil
+}
+
+// Port returns the port the responder is bound to, or 0 when it is not listening.
+func (r *UDPCheckResponder) Port() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		return 0
+	}
+	return r.conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// Close releases the socket bound by Listen. Closing twice does nothing.
+func (r *UDPCheckResponder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn = nil
+	return err
+}
+
+// Serve answers check datagrams until ctx is cancelled, then closes the socket and returns nil.
+func (r *UDPCheckResponder) Serve(ctx context.Context) error {
+	r.mu.Lock()
+	conn := r.conn
+	r.mu.Unlock()
+	if conn == nil {
+		return errors.New("UDP check responder is not listening; call Listen first")
+	}
+	stop := context.AfterFunc(ctx, func() { r.Close() }) // Unblocks the read below
+	defer stop()
+	defer r.Close()
+
+	prefix := []byte(network.UDPCheckPrefix)
+	buf := make([]byte, 512)
+	for {
+		n, remoteAddr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			continue
+		}
+		if !bytes.HasPrefix(buf[:n], prefix) {
+			continue
+		}
+		if _, err := conn.WriteToUDP(buf[:n], remoteAddr); err != nil {
+			log.Printf("Error answering UDP check from %s: %v", remoteAddr, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+)
+
+// DefaultUDPEchoAddress is where the UDP echo server listens unless told otherwise.
+const DefaultUDPEchoAddress = "localhost:8008"
+
+// UDPEchoServer answers every datagram with "UDP Echo: " and its contents, for basic UDP
+// tests. It is separate from the game sessions' UDP ports. Add it to a Server with
+// AddAuxiliary so it is bound and stopped with the server.
+type UDPEchoServer struct {
+	address string
+
+	mu   sync.Mutex
+	conn *net.UDPConn // Bound by Listen; nil when not listening
+}
+
+// NewUDPEchoServer creates an echo server for address; port 0 picks an ephemeral port.
+func NewUDPEchoServer(address string) *UDPEchoServer {
+	return &UDPEchoServer{address: address}
+}
+
+// Name implements AuxiliaryListener.
+func (e *UDPEchoServer) Name() string {
+	return "UDP echo server"
+}
+
+// Listen binds the echo server's socket.
+func (e *UDPEchoServer) Listen() error {
+	udpAddr, err := net.ResolveUDPAddr("udp", e.address)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.conn = conn
+	e.mu.Unlock()
+	log.Printf("Global UDP echo server listening on %s", conn.LocalAddr().String())
+	return nil
+}
+
+// Addr returns the address the echo server is bound to, or nil when it is not listening.
+func (e *UDPEchoServer) Addr() net.Addr {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn == nil {
+		return nil
+	}
+	return e.conn.LocalAddr()
+}
+
+// Close releases the socket bound by Listen. Closing twice does nothing.
+func (e *UDPEchoServer) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn == nil {
+		return nil
+	}
+	err := e.conn.Close()
+	e.conn = nil
+	return err
+}
+
+// Serve echoes datagrams until ctx is cancelled, then closes the socket and returns nil.
+func (e *UDPEchoServer) Serve(ctx context.Context) error {
+	e.mu.Lock()
+	conn := e.conn
+	e.mu.Unlock()
+	if conn == nil {
+		return errors.New("UDP echo server is not listening; call Listen first")
+	}
+	stop := context.AfterFunc(ctx, func() { e.Close() }) // Unblocks the read below
+	defer stop()
+	defer e.Close()
+
+	buf := make([]byte, 1024)
+	for {
+		n, remoteAddr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			log.Printf("Error reading from global UDP: %v", err)
+			continue
+		}
+		receivedMsg := string(buf[:n])
+		log.Printf("Global UDP: Received from %s: %s", remoteAddr, receivedMsg)
+
+		if _, err := conn.WriteToUDP([]byte("UDP Echo: "+receivedMsg), remoteAddr); err != nil {
+			log.Printf("Error writing to global UDP: %v", err)
+		}
+	}
+}
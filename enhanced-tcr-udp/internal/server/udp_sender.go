@@ -0,0 +1,176 @@
+package server
+
+import (
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"enhanced-tcr-udp/pkg/network"
+)
+
+const (
+	// outboundQueueSize is the queue depth above which superseded state updates are discarded.
+	// ACKs and game events other than low-priority ones are never discarded, so the queue may
+	// briefly grow past it.
+	outboundQueueSize = 32
+	// outboundFlushTimeout bounds how long Stop waits for queued packets (e.g. game over) to go out.
+	outboundFlushTimeout = time.Second
+)
+
+// outboundPacket is one marshalled datagram waiting to be written.
+type outboundPacket struct {
+	addr    *net.UDPAddr
+	data    []byte
+	msgType string
+	// supersedable marks a complete state update, made worthless by a newer one for the
+	// same address. ACKs, events and parts of a split update each carry information of their own.
+	supersedable bool
+	// lowPriority marks a packet nobody depends on, such as a spectator joining or a
+	// clock-only update; like a state update it may be discarded when the queue is full.
+	lowPriority bool
+}
+
+// OutboundUDPStats counts a session's outbound UDP traffic.
+type OutboundUDPStats struct {
+	Queued  uint64 `json:"queued"`  // Packets handed to the sender
+	Sent    uint64 `json:"sent"`    // Packets written to the socket
+	Dropped uint64 `json:"dropped"` // Stale state updates and low-priority events discarded before sending
+	Depth   int    `json:"depth"`   // Packets currently waiting
+}
+
+// udpSender owns all writes to a session's UDP socket. The game loop enqueues and moves
+// on, so a full socket buffer or a slow route never stalls a tick while gs.mu is held.
+type udpSender struct {
+	sessionID string
+	conn      *net.UDPConn
+	capture   *network.Capture // Records each packet written; nil unless the session captures
+
+	mu     sync.Mutex
+	queue  []outboundPacket
+	closed bool
+
+	wake chan struct{} // Signals run that the queue changed
+	done chan struct{} // Closed once run has drained the queue after close
+
+	queued  atomic.Uint64
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// newUDPSender creates a sender for conn and starts its goroutine.
+func newUDPSender(sessionID string, conn *net.UDPConn, capture *network.Capture) *udpSender {
+	s := &udpSender{
+		sessionID: sessionID,
+		conn:      conn,
+		capture:   capture,
+		queue:     make([]outboundPacket, 0, outboundQueueSize),
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// enqueue adds a packet to the queue without blocking. A new state update replaces any
+// older one still waiting for the same address, and once the queue is full the oldest
+// state update or low-priority event is discarded to make room.
+func (s *udpSender) enqueue(pkt outboundPacket) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		s.dropped.Add(1)
+		return
+	}
+	if pkt.supersedable {
+		kept := s.queue[:0]
+		for _, queued := range s.queue {
+			if queued.supersedable && queued.addr.String() == pkt.addr.String() {
+				s.dropped.Add(1)
+				continue
+			}
+			kept = append(kept, queued)
+		}
+		s.queue = kept
+	}
+	if len(s.queue) >= outboundQueueSize {
+		for i, queued := range s.queue {
+			if queued.supersedable || queued.lowPriority {
+				s.queue = append(s.queue[:i], s.queue[i+1:]...)
+				s.dropped.Add(1)
+				break
+			}
+		}
+	}
+	s.queue = append(s.queue, pkt)
+	s.queued.Add(1)
+	s.mu.Unlock()
+
+	s.signal()
+}
+
+// signal wakes run if it is waiting; a pending wake-up is enough, so it never blocks.
+func (s *udpSender) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run writes queued packets until the sender is closed and the queue is empty.
+func (s *udpSender) run() {
+	defer close(s.done)
+	for {
+		s.mu.Lock()
+		batch := s.queue
+		s.queue = make([]outboundPacket, 0, outboundQueueSize)
+		closed := s.closed
+		s.mu.Unlock()
+
+		for _, pkt := range batch {
+			if _, err := s.conn.WriteToUDP(pkt.data, pkt.addr); err != nil {
+				log.Printf("[GameSession %s] Error sending UDP message to %s (Type: %s): %v", s.sessionID, pkt.addr.String(), pkt.msgType, err)
+				continue
+			}
+			s.sent.Add(1)
+			s.capture.Record(network.CaptureSent, network.CaptureUDP, pkt.data)
+		}
+
+		if len(batch) == 0 {
+			if closed {
+				return
+			}
+			<-s.wake
+		}
+	}
+}
+
+// closeAndFlush stops accepting packets and waits up to timeout for the queue to drain.
+// It returns false if packets were still pending when the timeout expired.
+func (s *udpSender) closeAndFlush(timeout time.Duration) bool {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.signal()
+
+	select {
+	case <-s.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// stats returns the sender's counters and current queue depth.
+func (s *udpSender) stats() OutboundUDPStats {
+	s.mu.Lock()
+	depth := len(s.queue)
+	s.mu.Unlock()
+	return OutboundUDPStats{
+		Queued:  s.queued.Load(),
+		Sent:    s.sent.Load(),
+		Dropped: s.dropped.Load(),
+		Depth:   depth,
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"math"
+	"time"
+
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// advanceWarmup moves the pre-game phase along. The server only learns where to send a
+// player's updates from their first UDP packet, so the countdown starts once both players
+// have been heard from, or at warmupDeadline if one is still silent. Each second of the
+// countdown is announced, and combat starts when it runs out. Must be called with gs.mu held.
+func (gs *GameSession) advanceWarmup(now time.Time) {
+	if gs.countdownEnd.IsZero() {
+		_, p1Ready := gs.playerClientAddresses[gs.Player1.SessionToken]
+		_, p2Ready := gs.playerClientAddresses[gs.Player2.SessionToken]
+		if p1Ready && p2Ready {
+			gs.logf("[GameSession %s] Both players connected; starting the %ds countdown.", gs.ID, gs.Rules.CountdownSeconds)
+		} else if now.Before(gs.warmupDeadline) {
+			return
+		} else {
+			gs.logf("[GameSession %s] Warm-up timed out (player1 connected: %v, player2 connected: %v); starting the countdown anyway.", gs.ID, p1Ready, p2Ready)
+		}
+		gs.countdownEnd = now.Add(time.Duration(gs.Rules.CountdownSeconds) * time.Second)
+		gs.transition(StateCountdown)
+	}
+
+	if left := gs.countdownSecondsLeft(now); left > 0 {
+		if left != gs.countdownShown {
+			gs.countdownShown = left
+			gs.sendGameEventToAllPlayers(network.GameEventCountdown, network.CountdownEvent{SecondsLeft: left})
+		}
+		return
+	}
+	gs.startCombat(now)
+}
+
+// countdownSecondsLeft returns the whole seconds, rounded up, until combat starts, or 0 if
+// the countdown has not begun or is over.
+func (gs *GameSession) countdownSecondsLeft(now time.Time) int {
+	if gs.countdownEnd.IsZero() || !now.Before(gs.countdownEnd) {
+		return 0
+	}
+	return int(math.Ceil(gs.countdownEnd.Sub(now).Seconds()))
+}
+
+// startCombat ends the warm-up. The game clock runs from now, so time spent waiting for
+// players never comes out of GameDuration, and mana and tower attacks start from now too.
+func (gs *GameSession) startCombat(now time.Time) {
+	gs.transition(StateInProgress)
+	gs.combatStarted = true
+	gs.gameEndTime = now.Add(gs.Rules.GameDuration)
+	for username := range gs.lastManaRegen {
+		gs.lastManaRegen[username] = now
+	}
+	for _, tower := range gs.towers {
+		gs.lastTowerAttack[tower.GameSpecificID] = now
+	}
+	gs.logf("[GameSession %s] Combat started. Game will end at %v.", gs.ID, gs.gameEndTime)
+	gs.sendGameEventToAllPlayers(network.GameEventCombatStart, network.CombatStartEvent{
+		DurationSeconds: int(gs.Rules.GameDuration.Seconds()),
+	})
+}
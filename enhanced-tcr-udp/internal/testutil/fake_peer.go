@@ -0,0 +1,254 @@
+package testutil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// Received is a datagram a FakePeer read.
+type Received struct {
+	Msg     network.UDPMessage // Payload left as a json.RawMessage
+	From    *net.UDPAddr
+	At      time.Time // From FakePeerOptions.Now
+	Dropped bool      // Discarded by DropFirst: recorded, but never replied to
+}
+
+// ReplyFunc returns the messages a FakePeer answers msg with; nil sends nothing.
+type ReplyFunc func(msg network.UDPMessage) []network.UDPMessage
+
+// FakePeerOptions adjusts a FakePeer. The zero value uses the real clock.
+type FakePeerOptions struct {
+	Now   func() time.Time    // Timestamps Received.At; time.Now if nil
+	Sleep func(time.Duration) // Waits out Delay; time.Sleep if nil
+}
+
+// FakePeer is a scriptable UDP counterpart for exercising a client or server end of the
+// game protocol: it binds an ephemeral port on the loopback interface, records every
+// UDPMessage it receives and answers them as scripted. Replies go back to the sender.
+type FakePeer struct {
+	conn *net.UDPConn
+	opts FakePeerOptions
+
+	mu        sync.Mutex
+	received  []Received
+	arrived   chan struct{} // Closed and replaced whenever a datagram is recorded
+	replies   map[string]ReplyFunc
+	dropLeft  int
+	delay     time.Duration
+	duplicate bool
+	reorder   bool
+	held      []network.UDPMessage // A reply kept back by Reorder, sent after the next one
+	done      chan struct{}
+}
+
+// NewFakePeer binds a FakePeer to an ephemeral loopback port and starts reading. Close it
+// when done.
+func NewFakePeer(opts FakePeerOptions) (*FakePeer, error) {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Sleep == nil {
+		opts.Sleep = time.Sleep
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	p := &FakePeer{
+		conn:    conn,
+		opts:    opts,
+		arrived: make(chan struct{}),
+		replies: make(map[string]ReplyFunc),
+		done:    make(chan struct{}),
+	}
+	go p.read()
+	return p, nil
+}
+
+// Addr returns the address the peer is bound to.
+func (p *FakePeer) Addr() *net.UDPAddr {
+	return p.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Close stops the peer and releases its port.
+func (p *FakePeer) Close() error {
+	err := p.conn.Close()
+	<-p.done
+	return err
+}
+
+// OnType answers every message of type msgType with what reply returns. A later call for
+// the same type replaces the earlier one.
+func (p *FakePeer) OnType(msgType string, reply ReplyFunc) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.replies[msgType] = reply
+}
+
+// DropFirst discards the next n datagrams as if they were lost on the way.
+func (p *FakePeer) DropFirst(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dropLeft = n
+}
+
+// Delay holds every reply back for d before sending it.
+func (p *FakePeer) Delay(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.delay = d
+}
+
+// Duplicate sends every reply twice.
+func (p *FakePeer) Duplicate(on bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.duplicate = on
+}
+
+// Reorder swaps replies in pairs: each odd reply is held back and sent after the one that
+// follows it. Turning it off sends a held reply on its own with the next one.
+func (p *FakePeer) Reorder(on bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reorder = on
+}
+
+// Received returns what the peer has read so far, dropped datagrams included.
+func (p *FakePeer) Received() []Received {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Received(nil), p.received...)
+}
+
+// Messages returns the messages the peer has read and not dropped.
+func (p *FakePeer) Messages() []network.UDPMessage {
+	var msgs []network.UDPMessage
+	for _, r := range p.Received() {
+		if !r.Dropped {
+			msgs = append(msgs, r.Msg)
+		}
+	}
+	return msgs
+}
+
+// WaitFor waits until the peer has read at least n datagrams, dropped ones included, and
+// returns them. It fails if they have not arrived within timeout.
+func (p *FakePeer) WaitFor(n int, timeout time.Duration) ([]Received, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		p.mu.Lock()
+		got, arrived := len(p.received), p.arrived
+		p.mu.Unlock()
+		if got >= n {
+			return p.Received(), nil
+		}
+		select {
+		case <-arrived:
+		case <-p.done:
+			return p.Received(), fmt.Errorf("fake peer closed after %d of %d datagrams", got, n)
+		case <-deadline.C:
+			return p.Received(), fmt.Errorf("fake peer received %d of %d datagrams within %v", got, n, timeout)
+		}
+	}
+}
+
+// Send writes msg to addr, unaffected by the reply settings.
+func (p *FakePeer) Send(addr *net.UDPAddr, msg network.UDPMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = p.conn.WriteToUDP(data, addr)
+	return err
+}
+
+// read records incoming datagrams and answers them until the connection is closed.
+func (p *FakePeer) read() {
+	defer close(p.done)
+	buf := make([]byte, network.MaxUDPDatagramSize)
+	for {
+		n, from, err := p.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		var msg network.UDPMessage
+		var raw struct {
+			Payload json.RawMessage `json:"payload"`
+		}
+		if json.Unmarshal(buf[:n], &msg) != nil || json.Unmarshal(buf[:n], &raw) != nil {
+			continue // Not a protocol message; nothing to record
+		}
+		msg.Payload = raw.Payload
+		if replies := p.record(msg, from); len(replies) > 0 {
+			p.reply(from, replies)
+		}
+	}
+}
+
+// record stores msg and returns the replies to send for it, after DropFirst and Reorder.
+func (p *FakePeer) record(msg network.UDPMessage, from *net.UDPAddr) []network.UDPMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := Received{Msg: msg, From: from, At: p.opts.Now()}
+	if p.dropLeft > 0 {
+		p.dropLeft--
+		r.Dropped = true
+	}
+	p.received = append(p.received, r)
+	close(p.arrived)
+	p.arrived = make(chan struct{})
+	if r.Dropped {
+		return nil
+	}
+
+	var replies []network.UDPMessage
+	if reply, ok := p.replies[msg.Type]; ok {
+		replies = reply(msg)
+	}
+	var out []network.UDPMessage
+	for _, m := range replies {
+		if !p.reorder && len(p.held) == 0 {
+			out = append(out, m)
+			continue
+		}
+		if len(p.held) == 0 {
+			p.held = append(p.held, m)
+			continue
+		}
+		out = append(out, m)
+		out = append(out, p.held...)
+		p.held = nil
+	}
+	if p.duplicate {
+		doubled := make([]network.UDPMessage, 0, 2*len(out))
+		for _, m := range out {
+			doubled = append(doubled, m, m)
+		}
+		out = doubled
+	}
+	return out
+}
+
+// reply sends replies to addr after the configured delay.
+func (p *FakePeer) reply(addr *net.UDPAddr, replies []network.UDPMessage) {
+	p.mu.Lock()
+	delay := p.delay
+	p.mu.Unlock()
+	if delay > 0 {
+		p.opts.Sleep(delay)
+	}
+	for _, m := range replies {
+		p.Send(addr, m) // A failed send is a lost datagram, which tests must cope with anyway
+	}
+}
@@ -0,0 +1,175 @@
+package testutil
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"enhanced-tcr-udp/pkg/network"
+)
+
+const waitTimeout = 2 * time.Second
+
+var start = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// newPeer starts a FakePeer on clock that answers every ping with a pong carrying its Seq,
+// and returns it with a socket to talk to it from.
+func newPeer(t *testing.T, clock *Clock) (*FakePeer, *net.UDPConn) {
+	t.Helper()
+	p, err := NewFakePeer(FakePeerOptions{Now: clock.Now, Sleep: clock.Sleep})
+	if err != nil {
+		t.Fatalf("NewFakePeer: %v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+	p.OnType(network.UDPMsgTypePing, func(msg network.UDPMessage) []network.UDPMessage {
+		return []network.UDPMessage{{Type: network.UDPMsgTypePong, Seq: msg.Seq, Payload: network.PongUDP{PingSeq: msg.Seq}}}
+	})
+	conn, err := net.DialUDP("udp", nil, p.Addr())
+	if err != nil {
+		t.Fatalf("dialling the fake peer: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return p, conn
+}
+
+// ping sends the peer a ping with seq.
+func ping(t *testing.T, conn *net.UDPConn, seq uint32) {
+	t.Helper()
+	data, err := json.Marshal(network.UDPMessage{Type: network.UDPMsgTypePing, Seq: seq})
+	if err != nil {
+		t.Fatalf("encoding ping: %v", err)
+	}
+	if _, err := conn.Write(data); err != nil {
+		t.Fatalf("sending ping %d: %v", seq, err)
+	}
+}
+
+// pongs reads n replies from conn and returns their Seqs in the order they arrived.
+func pongs(t *testing.T, conn *net.UDPConn, n int) []uint32 {
+	t.Helper()
+	var seqs []uint32
+	buf := make([]byte, network.MaxUDPDatagramSize)
+	conn.SetReadDeadline(time.Now().Add(waitTimeout))
+	for len(seqs) < n {
+		size, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("reading reply %d of %d: %v (got %v)", len(seqs)+1, n, err, seqs)
+		}
+		var msg network.UDPMessage
+		if err := json.Unmarshal(buf[:size], &msg); err != nil {
+			t.Fatalf("decoding reply: %v", err)
+		}
+		seqs = append(seqs, msg.Seq)
+	}
+	return seqs
+}
+
+// assertSilent fails if conn receives anything within a short wait.
+func assertSilent(t *testing.T, conn *net.UDPConn) {
+	t.Helper()
+	buf := make([]byte, network.MaxUDPDatagramSize)
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, err := conn.Read(buf); err == nil {
+		t.Errorf("unexpected reply %s", buf[:n])
+	}
+}
+
+func TestFakePeerDropFirst(t *testing.T) {
+	clock := NewClock(start)
+	p, conn := newPeer(t, clock)
+	p.DropFirst(2)
+
+	for seq := uint32(1); seq <= 3; seq++ {
+		ping(t, conn, seq)
+		if _, err := p.WaitFor(int(seq), waitTimeout); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Second)
+	}
+
+	received := p.Received()
+	for i, r := range received {
+		if want := i < 2; r.Dropped != want {
+			t.Errorf("datagram %d: Dropped = %v, want %v", i+1, r.Dropped, want)
+		}
+		if want := start.Add(time.Duration(i) * time.Second); !r.At.Equal(want) {
+			t.Errorf("datagram %d: At = %v, want %v from the injected clock", i+1, r.At, want)
+		}
+	}
+	if got := p.Messages(); len(got) != 1 || got[0].Seq != 3 {
+		t.Errorf("Messages() = %+v, want only ping 3", got)
+	}
+	if got := pongs(t, conn, 1); !reflect.DeepEqual(got, []uint32{3}) {
+		t.Errorf("replies %v, want only the one to ping 3", got)
+	}
+	assertSilent(t, conn)
+}
+
+func TestFakePeerDelay(t *testing.T) {
+	clock := NewClock(start)
+	p, conn := newPeer(t, clock)
+	p.Delay(250 * time.Millisecond)
+
+	begin := time.Now()
+	ping(t, conn, 1)
+	if got := pongs(t, conn, 1); !reflect.DeepEqual(got, []uint32{1}) {
+		t.Errorf("replies %v, want [1]", got)
+	}
+	if got := clock.Sleeps(); !reflect.DeepEqual(got, []time.Duration{250 * time.Millisecond}) {
+		t.Errorf("slept %v, want [250ms] on the injected clock", got)
+	}
+	if got := clock.Now(); !got.Equal(start.Add(250 * time.Millisecond)) {
+		t.Errorf("clock reads %v after the delay, want %v", got, start.Add(250*time.Millisecond))
+	}
+	if elapsed := time.Since(begin); elapsed >= 250*time.Millisecond {
+		t.Errorf("the reply took %v of real time; the delay should only pass on the injected clock", elapsed)
+	}
+}
+
+func TestFakePeerReorder(t *testing.T) {
+	p, conn := newPeer(t, NewClock(start))
+	p.Reorder(true)
+
+	for seq := uint32(1); seq <= 4; seq++ {
+		ping(t, conn, seq)
+	}
+	if got := pongs(t, conn, 4); !reflect.DeepEqual(got, []uint32{2, 1, 4, 3}) {
+		t.Errorf("replies %v, want pairs swapped: [2 1 4 3]", got)
+	}
+
+	// A reply held back when reordering stops goes out with the next one
+	ping(t, conn, 5)
+	if _, err := p.WaitFor(5, waitTimeout); err != nil {
+		t.Fatal(err)
+	}
+	p.Reorder(false)
+	ping(t, conn, 6)
+	ping(t, conn, 7)
+	if got := pongs(t, conn, 3); !reflect.DeepEqual(got, []uint32{6, 5, 7}) {
+		t.Errorf("replies %v, want [6 5 7]", got)
+	}
+	assertSilent(t, conn)
+}
+
+func TestFakePeerDuplicate(t *testing.T) {
+	p, conn := newPeer(t, NewClock(start))
+	p.Duplicate(true)
+
+	ping(t, conn, 1)
+	ping(t, conn, 2)
+	if got := pongs(t, conn, 4); !reflect.DeepEqual(got, []uint32{1, 1, 2, 2}) {
+		t.Errorf("replies %v, want each twice: [1 1 2 2]", got)
+	}
+
+	p.Duplicate(false)
+	ping(t, conn, 3)
+	if got := pongs(t, conn, 1); !reflect.DeepEqual(got, []uint32{3}) {
+		t.Errorf("replies %v, want [3] once duplication is off", got)
+	}
+	assertSilent(t, conn)
+	if got := len(p.Received()); got != 3 {
+		t.Errorf("the peer recorded %d datagrams, want 3: duplication applies to replies only", got)
+	}
+}
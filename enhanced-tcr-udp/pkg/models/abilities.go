@@ -0,0 +1,128 @@
+package models
+
+import "fmt"
+
+// Troop special abilities (TroopSpec.Special). Any other value is treated as a plain
+// description with no effect on combat, which is what older configs contain.
+const (
+	// SpecialCharge makes the troop's first attack after deployment deal ChargeMultiplier
+	// times its ATK, after which it attacks normally (the classic Prince mechanic).
+	SpecialCharge = "charge"
+	// SpecialShield gives the troop ShieldHP extra points that absorb damage before its HP.
+	// A shield of zero is the same as no ability.
+	SpecialShield = "shield"
+)
+
+// Tower targeting modes (TowerSpec.TargetPriority).
+const (
+	// TargetPriorityRetaliate attacks the troop that last damaged the tower while it is
+	// still alive, and otherwise falls back to TargetPriorityOldest. It is the default.
+	TargetPriorityRetaliate = "retaliate"
+	// TargetPriorityOldest attacks the longest-deployed enemy troop.
+	TargetPriorityOldest = "oldest"
+)
+
+// Targeting returns the tower's target priority, applying the default.
+func (s TowerSpec) Targeting() string {
+	if s.TargetPriority == "" {
+		return TargetPriorityRetaliate
+	}
+	return s.TargetPriority
+}
+
+// Bounds for TroopSpec.ChargeMultiplier.
+const (
+	MinChargeMultiplier = 1.0
+	MaxChargeMultiplier = 5.0
+)
+
+// Validate checks the targeting mode of every tower spec and the ability settings of
+// every troop spec.
+func (c *GameConfig) Validate() error {
+	for _, id := range sortedKeys(c.Towers) {
+		switch mode := c.Towers[id].Targeting(); mode {
+		case TargetPriorityRetaliate, TargetPriorityOldest:
+		default:
+			return fmt.Errorf("tower %q: unknown target_priority %q (want %q or %q)", id, mode, TargetPriorityRetaliate, TargetPriorityOldest)
+		}
+	}
+	for _, id := range sortedKeys(c.Troops) {
+		spec := c.Troops[id]
+		if spec.Special == SpecialCharge {
+			if spec.ChargeMultiplier < MinChargeMultiplier || spec.ChargeMultiplier > MaxChargeMultiplier {
+				return fmt.Errorf("troop %q: charge_multiplier %.2f must be between %.1f and %.1f",
+					id, spec.ChargeMultiplier, MinChargeMultiplier, MaxChargeMultiplier)
+			}
+		}
+		if spec.ShieldHP < 0 {
+			return fmt.Errorf("troop %q: shield_hp %d must not be negative", id, spec.ShieldHP)
+		}
+		if spec.SpawnDelayMs < 0 {
+			return fmt.Errorf("troop %q: spawn_delay_ms %d must not be negative", id, spec.SpawnDelayMs)
+		}
+		if spec.LifetimeMs < 0 {
+			return fmt.Errorf("troop %q: lifetime_ms %d must not be negative", id, spec.LifetimeMs)
+		}
+		if spec.LifetimeMs > 0 && spec.LifetimeMs <= spec.SpawnDelayMs {
+			return fmt.Errorf("troop %q: lifetime_ms %d must be longer than its spawn_delay_ms %d", id, spec.LifetimeMs, spec.SpawnDelayMs)
+		}
+	}
+	return nil
+}
+
+// ValidateSpawnDelays checks that every troop in cfg finishes spawning well within a game:
+// a delay as long as GameDuration would make the troop useless.
+func (r GameRules) ValidateSpawnDelays(cfg *GameConfig) error {
+	for _, id := range sortedKeys(cfg.Troops) {
+		if delay := cfg.Troops[id].SpawnDelay(); delay >= r.GameDuration {
+			return fmt.Errorf("troop %q: spawn_delay_ms %d must be shorter than the game (%v)", id, cfg.Troops[id].SpawnDelayMs, r.GameDuration)
+		}
+	}
+	return nil
+}
+
+// Bounds for each GameRules.DamageMatrix multiplier.
+const (
+	MinDamageMultiplier = 0.1
+	MaxDamageMultiplier = 3.0
+)
+
+// ValidateDamageMatrix checks the matrix multipliers and that every damage and armor type
+// a spec in cfg uses appears in the matrix (as an attack row or an armor column).
+func (r GameRules) ValidateDamageMatrix(cfg *GameConfig) error {
+	armorTypes := make(map[string]bool)
+	for _, damageType := range sortedKeys(r.DamageMatrix) {
+		row := r.DamageMatrix[damageType]
+		for _, armorType := range sortedKeys(row) {
+			multiplier := row[armorType]
+			if multiplier < MinDamageMultiplier || multiplier > MaxDamageMultiplier {
+				return fmt.Errorf("damage matrix %s vs %s: multiplier %.2f must be between %.1f and %.1f",
+					damageType, armorType, multiplier, MinDamageMultiplier, MaxDamageMultiplier)
+			}
+			armorTypes[armorType] = true
+		}
+	}
+
+	checkTypes := func(category, id, damageType, armorType string) error {
+		if _, ok := r.DamageMatrix[damageType]; damageType != "" && !ok {
+			return fmt.Errorf("%s %q: damage_type %q is not in the damage matrix", category, id, damageType)
+		}
+		if armorType != "" && !armorTypes[armorType] {
+			return fmt.Errorf("%s %q: armor_type %q is not in the damage matrix", category, id, armorType)
+		}
+		return nil
+	}
+	for _, id := range sortedKeys(cfg.Towers) {
+		spec := cfg.Towers[id]
+		if err := checkTypes("tower", id, spec.DamageType, spec.ArmorType); err != nil {
+			return err
+		}
+	}
+	for _, id := range sortedKeys(cfg.Troops) {
+		spec := cfg.Troops[id]
+		if err := checkTypes("troop", id, spec.DamageType, spec.ArmorType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
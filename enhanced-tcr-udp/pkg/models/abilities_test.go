@@ -0,0 +1,48 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+// The damage matrix's multipliers must be within bounds, and every type a spec uses must
+// appear in it, damage types as rows and armor types as columns.
+func TestValidateDamageMatrix(t *testing.T) {
+	config := func(troopDamage, towerArmor string) *GameConfig {
+		return &GameConfig{
+			Troops: map[string]TroopSpec{"pawn": {ID: "pawn", DamageType: troopDamage}},
+			Towers: map[string]TowerSpec{"king_tower": {ID: "king_tower", ArmorType: towerArmor}},
+		}
+	}
+	withMultiplier := func(multiplier float64) GameRules {
+		rules := DefaultGameRules()
+		rules.DamageMatrix = map[string]map[string]float64{"piercing": {"light": multiplier}}
+		return rules
+	}
+	tests := []struct {
+		name    string
+		rules   GameRules
+		cfg     *GameConfig
+		wantErr string // Empty if the config is valid
+	}{
+		{"untyped specs", DefaultGameRules(), config("", ""), ""},
+		{"typed specs", DefaultGameRules(), config("siege", "fortified"), ""},
+		{"no matrix, no types", GameRules{}, config("", ""), ""},
+		{"lowest multiplier", withMultiplier(MinDamageMultiplier), config("piercing", "light"), ""},
+		{"highest multiplier", withMultiplier(MaxDamageMultiplier), config("piercing", "light"), ""},
+		{"multiplier too low", withMultiplier(0.05), config("", ""), "piercing vs light: multiplier 0.05"},
+		{"multiplier too high", withMultiplier(3.5), config("", ""), "piercing vs light: multiplier 3.50"},
+		{"unknown damage type", DefaultGameRules(), config("magic", ""), `troop "pawn": damage_type "magic"`},
+		{"unknown armor type", DefaultGameRules(), config("", "ethereal"), `tower "king_tower": armor_type "ethereal"`},
+		{"armor type used as a damage type", DefaultGameRules(), config("light", ""), `damage_type "light"`},
+	}
+	for _, tt := range tests {
+		err := tt.rules.ValidateDamageMatrix(tt.cfg)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: %v, want no error", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: %v, want an error mentioning %s", tt.name, err, tt.wantErr)
+		}
+	}
+}
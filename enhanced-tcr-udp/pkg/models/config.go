@@ -0,0 +1,189 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// TowerSpec defines the base specifications for a type of tower.
+type TowerSpec struct {
+	ID   string `json:"id"`   // e.g., "king_tower", "guard_tower_1"
+	Name string `json:"name"` // e.g., "King Tower", "Guard Tower"
+	// DisplayName is shown in the UI; Symbol is its one-character glyph for the compact
+	// battlefield view. Both are optional and derived from Name by GameConfig.Normalize.
+	DisplayName string  `json:"display_name,omitempty"`
+	Symbol      string  `json:"symbol,omitempty"`
+	BaseHP      int     `json:"base_hp"`     // Base Hit Points
+	BaseATK     int     `json:"base_atk"`    // Base Attack
+	BaseDEF     int     `json:"base_def"`    // Base Defense
+	CritChance  float64 `json:"crit_chance"` // Critical Hit Chance (0.0 to 1.0)
+	EXPYield    int     `json:"exp_yield"`   // EXP awarded when this tower is destroyed
+	// DamageType and ArmorType look up GameRules.DamageMatrix; empty means neutral.
+	DamageType string `json:"damage_type,omitempty"` // Type of this tower's attacks
+	ArmorType  string `json:"armor_type,omitempty"`  // Type of this tower's defence
+	// TargetPriority picks which troop the tower attacks (TargetPriorityRetaliate when empty).
+	TargetPriority string `json:"target_priority,omitempty"`
+	// Lane is the lane (LaneLeft or LaneRight) this tower guards when GameRules.TwoLanes is
+	// on. A King Tower covers both lanes; any other tower without a lane is placed once in
+	// each lane.
+	Lane string `json:"lane,omitempty"`
+}
+
+// TroopSpec defines the base specifications for a type of troop.
+type TroopSpec struct {
+	ID   string `json:"id"`   // e.g., "pawn", "queen"
+	Name string `json:"name"` // e.g., "Pawn", "Queen"
+	// DisplayName and Symbol work as for TowerSpec; symbols are unique among troops.
+	DisplayName string `json:"display_name,omitempty"`
+	Symbol      string `json:"symbol,omitempty"`
+	ManaCost    int    `json:"mana_cost"` // MANA required to deploy
+	BaseHP      int    `json:"base_hp"`   // Base Hit Points (if it were to fight, though troops only attack towers)
+	BaseATK     int    `json:"base_atk"`  // Base Attack
+	BaseDEF     int    `json:"base_def"`  // Base Defense (if it were to be attacked, though towers only attack troops)
+	Special     string `json:"special"`   // Ability, e.g. SpecialCharge; other values are descriptive only
+	// ChargeMultiplier scales the first attack of a SpecialCharge troop.
+	ChargeMultiplier float64 `json:"charge_multiplier,omitempty"`
+	// ShieldHP is the damage a SpecialShield troop absorbs before losing HP (scaled by level like HP).
+	ShieldHP int `json:"shield_hp,omitempty"`
+	// DamageType and ArmorType work as for TowerSpec.
+	DamageType string `json:"damage_type,omitempty"`
+	ArmorType  string `json:"armor_type,omitempty"`
+	// SpawnDelayMs is how long a deployed troop waits before it may attack, giving the
+	// opponent time to react. Zero (the default) keeps the old immediate behaviour.
+	SpawnDelayMs int `json:"spawn_delay_ms,omitempty"`
+	// LifetimeMs is how long a deployed troop stays on the field before it expires, counted
+	// from deployment. Zero (the default) means it stays until defeated.
+	LifetimeMs int `json:"lifetime_ms,omitempty"`
+	// Note: Troops have 0% base CRIT according to plan.
+}
+
+// SpawnDelay returns SpawnDelayMs as a duration.
+func (s TroopSpec) SpawnDelay() time.Duration {
+	return time.Duration(s.SpawnDelayMs) * time.Millisecond
+}
+
+// Lifetime returns LifetimeMs as a duration; 0 means the troop is permanent.
+func (s TroopSpec) Lifetime() time.Duration {
+	return time.Duration(s.LifetimeMs) * time.Millisecond
+}
+
+// GameConfig holds all configurable game parameters, typically loaded from JSON files.
+type GameConfig struct {
+	Towers map[string]TowerSpec `json:"towers"` // Keyed by Tower ID
+	Troops map[string]TroopSpec `json:"troops"` // Keyed by Troop ID
+	// Other global game settings can be added here
+	// e.g., MaxMana, ManaRegenRate, GameDurationSeconds
+}
+
+// Hash identifies the config's contents: two configs have the same hash exactly when they
+// encode to the same JSON. Clients use it to tell whether a config they hold is current.
+func (c *GameConfig) Hash() (string, error) {
+	data, err := json.Marshal(c) // Map keys are encoded sorted, so the encoding is stable
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// GameRules holds the session-level rules a GameSession runs with.
+// Unlike GameConfig (troop/tower specs), these describe how a match is played
+// and can be shortened for quick local runs without touching the JSON config.
+type GameRules struct {
+	GameDuration time.Duration `json:"game_duration"` // Length of a match before the timeout tiebreaker applies
+	// DurationChoices are the game durations a player may ask for when queueing. Players
+	// asking for the same one are paired with each other; a request for any other is
+	// treated as no preference, and a game without one lasts GameDuration.
+	DurationChoices []time.Duration `json:"duration_choices,omitempty"`
+	// DurationWidenAfter is how long a player waits for someone wanting the same duration
+	// before they are paired with anyone in their queue.
+	DurationWidenAfter time.Duration `json:"duration_widen_after,omitempty"`
+	StartingMana       int           `json:"starting_mana"`       // Mana each player has when the game starts
+	MaxMana            int           `json:"max_mana"`            // Mana cap
+	ManaRegenInterval  time.Duration `json:"mana_regen_interval"` // +1 mana every interval
+	LevelStatBonus     float64       `json:"level_stat_bonus"`    // Cumulative stat bonus per player level above 1 (0.10 = +10%)
+	TickInterval       time.Duration `json:"tick_interval"`       // How often the game loop advances the simulation
+	// DamageMatrix scales damage by attack type, then armor type, e.g.
+	// DamageMatrix["piercing"]["light"] = 1.25. Missing pairs are neutral (1.0).
+	DamageMatrix map[string]map[string]float64 `json:"damage_matrix,omitempty"`
+	// SurrenderEXPPerMinute is the consolation EXP the winner of a surrendered game earns per
+	// minute played, on top of the win bonus, for the tower EXP the early end cost them.
+	SurrenderEXPPerMinute float64 `json:"surrender_exp_per_minute"`
+	// CasualEXPMultiplier scales the EXP earned in a casual game; 0 awards none. Casual
+	// games never change wins, losses or streaks.
+	CasualEXPMultiplier float64 `json:"casual_exp_multiplier"`
+	// FirstWinBonusEXP is extra EXP for a player's first ranked win of each calendar day in
+	// DayLocation (the server's local time zone if nil), judged by when the game ended. 0
+	// turns the bonus off.
+	FirstWinBonusEXP int            `json:"first_win_bonus_exp"`
+	DayLocation      *time.Location `json:"-"`
+	// WarmupTimeout is how long a new session waits to hear from both players before it
+	// starts the countdown anyway; CountdownSeconds is the countdown before combat begins.
+	// The game clock, mana regen and deploys all wait for combat.
+	WarmupTimeout    time.Duration `json:"warmup_timeout"`
+	CountdownSeconds int           `json:"countdown_seconds"`
+	// TwoLanes splits the field into a left and a right lane. Each troop is deployed into a
+	// lane and attacks only the towers guarding it until they fall, then the King Tower.
+	// Off, every troop attacks the opponent's weakest tower.
+	TwoLanes bool `json:"two_lanes"`
+	// TimeoutHPTiebreak breaks a tie in towers destroyed at timeout by the share of tower HP
+	// each player has left; the player with more wins. Off, or with equal shares, the game
+	// is a draw.
+	TimeoutHPTiebreak bool `json:"timeout_hp_tiebreak"`
+	// PerPlayerOverrides handicaps players of a casual game, keyed by username; see
+	// PlayerOverrides. Ranked games are always played without them.
+	PerPlayerOverrides map[string]PlayerOverrides `json:"per_player_overrides,omitempty"`
+
+	// Plausibility checks on client commands. They flag signs of a modified client for
+	// operators rather than block play; a zero value turns the corresponding check off.
+	MaxClockSkew       time.Duration `json:"max_clock_skew"`       // Command timestamps further than this from server time are flagged
+	MaxSeqGap          uint32        `json:"max_seq_gap"`          // Command Seqs further than this from the player's watermark are flagged
+	CheatWarnThreshold int           `json:"cheat_warn_threshold"` // Flagged commands from one player before the session warns
+	ForfeitOnCheating  bool          `json:"forfeit_on_cheating"`  // A player reaching CheatWarnThreshold forfeits the game
+}
+
+// DefaultGameRules returns the rules described in the project plan:
+// 3-minute games (or 90-second ones for players who ask, who wait up to 20 seconds for
+// an opponent wanting the same), 5 starting mana, max 10, +1 mana every 2 seconds,
+// and +10% troop/tower stats per level, simulated in 500ms ticks. Beating a player who
+// surrenders earns 10 consolation EXP per minute played, and casual games award half the EXP. The first ranked win of
+// each day earns 50 bonus EXP. Combat starts after a 3-second
+// countdown, once both players are connected or 10 seconds have passed. Commands more than 30 seconds off
+// server time or 50 Seqs off the watermark are flagged, with a warning after 10, but no forfeit.
+func DefaultGameRules() GameRules {
+	return GameRules{
+		GameDuration:       3 * time.Minute,
+		DurationChoices:    []time.Duration{90 * time.Second, 3 * time.Minute},
+		DurationWidenAfter: 20 * time.Second,
+		StartingMana:       5,
+		MaxMana:            10,
+		ManaRegenInterval:  2 * time.Second,
+		LevelStatBonus:     0.10,
+		TickInterval:       500 * time.Millisecond,
+		DamageMatrix: map[string]map[string]float64{
+			"piercing": {"light": 1.25, "heavy": 1.0, "fortified": 0.8},
+			"blunt":    {"light": 1.0, "heavy": 1.25, "fortified": 0.8},
+			"siege":    {"light": 0.8, "heavy": 1.0, "fortified": 1.5},
+		},
+		SurrenderEXPPerMinute: 10,
+		CasualEXPMultiplier:   0.5,
+		FirstWinBonusEXP:      50,
+		WarmupTimeout:         10 * time.Second,
+		CountdownSeconds:      3,
+		MaxClockSkew:          30 * time.Second,
+		MaxSeqGap:             50,
+		CheatWarnThreshold:    10,
+	}
+}
+
+// AllowsDuration reports whether d is one of the DurationChoices players may ask for.
+func (r GameRules) AllowsDuration(d time.Duration) bool {
+	for _, choice := range r.DurationChoices {
+		if choice == d {
+			return true
+		}
+	}
+	return false
+}
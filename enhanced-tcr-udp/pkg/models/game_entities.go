@@ -0,0 +1,136 @@
+package models
+
+import (
+	"net"
+	"time"
+)
+
+// Lanes of the two-lane mode (GameRules.TwoLanes).
+const (
+	LaneLeft  = "left"
+	LaneRight = "right"
+)
+
+// Lanes lists the lanes of the two-lane mode, left to right.
+var Lanes = []string{LaneLeft, LaneRight}
+
+// ValidLane reports whether lane names a lane of the two-lane mode.
+func ValidLane(lane string) bool {
+	return lane == LaneLeft || lane == LaneRight
+}
+
+// TowerInstance represents a tower currently in a game session.
+type TowerInstance struct {
+	SpecID      string `json:"spec_id"`  // References TowerSpec.ID
+	OwnerID     string `json:"owner_id"` // Player ID who owns this tower
+	CurrentHP   int    `json:"current_hp"`
+	MaxHP       int    `json:"max_hp"`      // Max HP considering player level
+	CurrentATK  int    `json:"current_atk"` // ATK considering player level
+	CurrentDEF  int    `json:"current_def"` // DEF considering player level
+	IsDestroyed bool   `json:"is_destroyed"`
+	// Potentially add position/ID for targeting, e.g., guard_tower_1, guard_tower_2, king_tower
+	GameSpecificID string `json:"game_specific_id"` // e.g. "player1_king_tower"
+	// Lane is the lane the tower guards in two-lane mode; empty for a tower covering both
+	// lanes (the King Tower) and in classic games.
+	Lane string `json:"lane,omitempty"`
+	// LastAttackerInstanceID is the troop that last damaged this tower, for retaliation.
+	LastAttackerInstanceID string `json:"last_attacker_instance_id,omitempty"`
+}
+
+// ActiveTroop represents a troop deployed on the game field.
+// Queen is a special case as per plan: "Deployment is a one-time action... Does not persist on the board."
+// So, ActiveTroop will mainly represent other troops that do persist and attack.
+type ActiveTroop struct {
+	InstanceID  string    `json:"instance_id"` // Unique ID for this troop instance in the game
+	SpecID      string    `json:"spec_id"`     // References TroopSpec.ID
+	OwnerID     string    `json:"owner_id"`    // Player ID who owns this troop
+	CurrentHP   int       `json:"current_hp"`  // HP considering player level
+	MaxHP       int       `json:"max_hp"`
+	CurrentATK  int       `json:"current_atk"`  // ATK considering player level
+	CurrentDEF  int       `json:"current_def"`  // DEF considering player level (though it only attacks towers)
+	ShieldHP    int       `json:"shield_hp"`    // Shield left; damage depletes it before CurrentHP
+	TargetID    string    `json:"target_id"`    // ID of the TowerInstance it's targeting
+	HasAttacked bool      `json:"has_attacked"` // Set after the first attack; a charge troop's bonus is spent
+	DeployedAt  time.Time `json:"deployed_at"`
+	ReadyAt     time.Time `json:"ready_at"`       // DeployedAt plus the spec's spawn delay; the troop is "spawning" until then
+	ExpiresAt   time.Time `json:"expires_at"`     // DeployedAt plus the spec's lifetime; zero for a troop that stays until defeated
+	Lane        string    `json:"lane,omitempty"` // Lane deployed into in two-lane mode; empty in classic games
+	// Position might be needed later if we have a more complex board
+}
+
+// PlayerInGame represents a player's state within an active game session.
+type PlayerInGame struct {
+	Account        PlayerAccount           `json:"account"` // Copy of player account details, including current level
+	TCPConn        net.Conn                `json:"-"`       // TCP connection (for reliable messages, not for JSON marshal)
+	UDPAddr        *net.UDPAddr            `json:"-"`       // UDP address (not for JSON marshal)
+	CurrentMana    int                     `json:"current_mana"`
+	Towers         []*TowerInstance        `json:"towers"`           // Player's towers in the game
+	DeployedTroops map[string]*ActiveTroop `json:"deployed_troops"`  // Keyed by ActiveTroop.InstanceID
+	LastActionTime time.Time               `json:"last_action_time"` // For timeouts or other logic
+	SessionToken   string                  `json:"session_token"`    // Token to identify player in UDP messages
+	Stats          PlayerMatchStats        `json:"stats"`            // Running totals for the results screen
+}
+
+// PlayerMatchStats are the running totals of one player's game, kept up as it is played.
+type PlayerMatchStats struct {
+	DamageDealt    int `json:"damage_dealt"`    // HP removed by the player's troops and towers
+	DamageTaken    int `json:"damage_taken"`    // HP the player's troops and towers lost
+	TroopsDeployed int `json:"troops_deployed"` // Including Queens
+	Heals          int `json:"heals"`           // HP the player's Queens restored to their towers
+	// DamageBySpec is the HP the player's troops removed from towers, by TroopSpec ID.
+	DamageBySpec map[string]int `json:"damage_by_spec,omitempty"`
+	// DeploysBySpec counts the troops the player deployed, by TroopSpec ID.
+	DeploysBySpec map[string]int `json:"deploys_by_spec,omitempty"`
+}
+
+// AddDeploy counts a troop of spec specID deployed by the player; n is -1 to take back a
+// deploy that did not go through.
+func (s *PlayerMatchStats) AddDeploy(specID string, n int) {
+	if s.DeploysBySpec == nil {
+		s.DeploysBySpec = make(map[string]int)
+	}
+	s.DeploysBySpec[specID] += n
+	if s.DeploysBySpec[specID] <= 0 {
+		delete(s.DeploysBySpec, specID)
+	}
+	s.TroopsDeployed += n
+}
+
+// AddTroopDamage counts hp removed from a tower by one of the player's troops of spec specID.
+func (s *PlayerMatchStats) AddTroopDamage(specID string, hp int) {
+	if s.DamageBySpec == nil {
+		s.DamageBySpec = make(map[string]int)
+	}
+	s.DamageBySpec[specID] += hp
+	s.DamageDealt += hp
+}
+
+// MVP returns the troop spec that dealt the most damage and how much; ties go to the
+// spec ID that sorts first. It returns "" if no troop damaged a tower.
+func (s PlayerMatchStats) MVP() (specID string, damage int) {
+	for id, dealt := range s.DamageBySpec {
+		if dealt > damage || dealt == damage && dealt > 0 && id < specID {
+			specID, damage = id, dealt
+		}
+	}
+	return specID, damage
+}
+
+// GameSession represents an active game between two players.
+type GameSession struct {
+	SessionID      string        `json:"session_id"`
+	Player1        *PlayerInGame `json:"player1"`
+	Player2        *PlayerInGame `json:"player2"`
+	GameConfig     *GameConfig   `json:"game_config"` // Reference to the loaded game configuration
+	StartTime      time.Time     `json:"start_time"`
+	GameTimer      *time.Timer   `json:"-"` // Server-side timer for game duration
+	LastUpdateTime time.Time     `json:"last_update_time"`
+	GameState      string        `json:"game_state"` // e.g., "Initializing", "InProgress", "Finished"
+	// Potentially include a list of all active troops from both players for easier global access if needed
+	// AllActiveTroops map[string]*ActiveTroop `json:"all_active_troops"`
+
+	// UDP related fields
+	UDPPort        int          `json:"udp_port"` // Dedicated UDP port for this game session
+	ClientUDPAddr1 *net.UDPAddr `json:"-"`        // Caching client UDP address for player 1
+	ClientUDPAddr2 *net.UDPAddr `json:"-"`        // Caching client UDP address for player 2
+}
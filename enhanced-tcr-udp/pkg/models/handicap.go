@@ -0,0 +1,94 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Bounds for the multipliers of a PlayerOverrides.
+const (
+	MinHandicapMultiplier = 0.25
+	MaxHandicapMultiplier = 4.0
+)
+
+// PlayerOverrides is a handicap for one player of a game, evening out a match between very
+// different skill levels. Fields left at their zero value keep the session's rules.
+type PlayerOverrides struct {
+	StartingMana *int `json:"starting_mana,omitempty"` // Replaces GameRules.StartingMana
+	// ManaRegenMultiplier scales how fast the player regenerates mana: 2 halves their
+	// GameRules.ManaRegenInterval, 0.5 doubles it.
+	ManaRegenMultiplier float64 `json:"mana_regen_multiplier,omitempty"`
+	// StatMultiplier replaces the level-based multiplier of the player's troops and towers.
+	StatMultiplier float64 `json:"stat_multiplier,omitempty"`
+}
+
+// IsZero reports whether o changes nothing.
+func (o PlayerOverrides) IsZero() bool {
+	return o.StartingMana == nil && o.ManaRegenMultiplier == 0 && o.StatMultiplier == 0
+}
+
+// Validate checks o against the bounds a game with maxMana allows.
+func (o PlayerOverrides) Validate(maxMana int) error {
+	if o.StartingMana != nil && (*o.StartingMana < 0 || *o.StartingMana > maxMana) {
+		return fmt.Errorf("starting mana %d must be between 0 and %d", *o.StartingMana, maxMana)
+	}
+	if o.ManaRegenMultiplier != 0 && (o.ManaRegenMultiplier < MinHandicapMultiplier || o.ManaRegenMultiplier > MaxHandicapMultiplier) {
+		return fmt.Errorf("mana regen multiplier %.2f must be between %.2f and %.1f", o.ManaRegenMultiplier, MinHandicapMultiplier, MaxHandicapMultiplier)
+	}
+	if o.StatMultiplier != 0 && (o.StatMultiplier < MinHandicapMultiplier || o.StatMultiplier > MaxHandicapMultiplier) {
+		return fmt.Errorf("stat multiplier %.2f must be between %.2f and %.1f", o.StatMultiplier, MinHandicapMultiplier, MaxHandicapMultiplier)
+	}
+	return nil
+}
+
+// String describes o for logs and the admin console, e.g. "starting-mana=8 regen=x1.50".
+func (o PlayerOverrides) String() string {
+	var parts []string
+	if o.StartingMana != nil {
+		parts = append(parts, fmt.Sprintf("starting-mana=%d", *o.StartingMana))
+	}
+	if o.ManaRegenMultiplier != 0 {
+		parts = append(parts, fmt.Sprintf("regen=x%.2f", o.ManaRegenMultiplier))
+	}
+	if o.StatMultiplier != 0 {
+		parts = append(parts, fmt.Sprintf("stats=x%.2f", o.StatMultiplier))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, " ")
+}
+
+// ValidateOverrides checks every entry of PerPlayerOverrides.
+func (r GameRules) ValidateOverrides() error {
+	for _, username := range sortedKeys(r.PerPlayerOverrides) {
+		if err := r.PerPlayerOverrides[username].Validate(r.MaxMana); err != nil {
+			return fmt.Errorf("handicap for %s: %w", username, err)
+		}
+	}
+	return nil
+}
+
+// StartingManaFor returns the mana the player starts the game with.
+func (r GameRules) StartingManaFor(username string) int {
+	if o := r.PerPlayerOverrides[username]; o.StartingMana != nil {
+		return *o.StartingMana
+	}
+	return r.StartingMana
+}
+
+// ManaRegenIntervalFor returns how often the player regenerates a point of mana.
+func (r GameRules) ManaRegenIntervalFor(username string) time.Duration {
+	if o := r.PerPlayerOverrides[username]; o.ManaRegenMultiplier != 0 {
+		return time.Duration(float64(r.ManaRegenInterval) / o.ManaRegenMultiplier)
+	}
+	return r.ManaRegenInterval
+}
+
+// StatMultiplierFor returns the player's stat multiplier override, if they have one; without
+// one their troops and towers are scaled for their level.
+func (r GameRules) StatMultiplierFor(username string) (float64, bool) {
+	o := r.PerPlayerOverrides[username]
+	return o.StatMultiplier, o.StatMultiplier != 0
+}
@@ -0,0 +1,91 @@
+package models
+
+import "time"
+
+// PlayerAccount holds information about a player that persists between sessions.
+type PlayerAccount struct {
+	Username       string `json:"username"`
+	HashedPassword string `json:"-"` // bcrypted; never serialized, persistence stores it separately
+	EXP            int    `json:"exp"`
+	Level          int    `json:"level"`
+	GameID         string `json:"game_id,omitempty"` // Added to store current game ID if in a session
+	Wins           int    `json:"wins"`
+	Losses         int    `json:"losses"`
+	Streak         int    `json:"streak"` // Positive for consecutive wins, negative for consecutive losses
+	// LastLogin is when the player last logged in; zero if they never have since it was recorded.
+	LastLogin time.Time `json:"last_login"`
+	// Settings holds the player's preferences, keyed by the Setting* names; see ApplySettings.
+	Settings map[string]string `json:"settings,omitempty"`
+	// LastFirstWinBonusDate is the calendar day, as FirstWinBonusDateFormat, the player last
+	// earned the first-win bonus; empty if they never have.
+	LastFirstWinBonusDate string `json:"last_first_win_bonus_date,omitempty"`
+	// All-time totals, casual games included; zero for accounts from before they were kept.
+	GamesPlayed    int `json:"games_played"`
+	TotalEXPEarned int `json:"total_exp_earned"` // EXP earned in games, before level-ups spend it
+	// TroopDeploys counts the troops the player has deployed, by TroopSpec ID.
+	TroopDeploys map[string]int `json:"troop_deploys,omitempty"`
+}
+
+// RecordGamePlayed adds a finished game to the all-time totals: the EXP the player earned in
+// it and the troops they deployed, by TroopSpec ID.
+func (p *PlayerAccount) RecordGamePlayed(expEarned int, deploys map[string]int) {
+	p.GamesPlayed++
+	p.TotalEXPEarned += expEarned
+	for specID, n := range deploys {
+		if p.TroopDeploys == nil {
+			p.TroopDeploys = make(map[string]int)
+		}
+		p.TroopDeploys[specID] += n
+	}
+}
+
+// FavoriteTroop returns the TroopSpec ID the player has deployed most; ties go to the spec
+// ID that sorts first. It returns "" if they have deployed none.
+func (p *PlayerAccount) FavoriteTroop() string {
+	favorite, most := "", 0
+	for specID, n := range p.TroopDeploys {
+		if n > most || n == most && n > 0 && specID < favorite {
+			favorite, most = specID, n
+		}
+	}
+	return favorite
+}
+
+// FirstWinBonusDateFormat is the layout of PlayerAccount.LastFirstWinBonusDate.
+const FirstWinBonusDateFormat = "2006-01-02"
+
+// ClaimFirstWinBonus reports whether a win in a game that ended at ended is the player's
+// first of that calendar day in loc, and if so records the day so later wins that day do
+// not earn the bonus again.
+func (p *PlayerAccount) ClaimFirstWinBonus(ended time.Time, loc *time.Location) bool {
+	if loc == nil {
+		loc = time.Local
+	}
+	day := ended.In(loc).Format(FirstWinBonusDateFormat)
+	if p.LastFirstWinBonusDate == day {
+		return false
+	}
+	p.LastFirstWinBonusDate = day
+	return true
+}
+
+// RecordOutcome updates the win/loss record with a game result ("win", "loss" or "draw").
+// A draw keeps the record but breaks the streak.
+func (p *PlayerAccount) RecordOutcome(outcome string) {
+	switch outcome {
+	case "win":
+		p.Wins++
+		if p.Streak < 0 {
+			p.Streak = 0
+		}
+		p.Streak++
+	case "loss":
+		p.Losses++
+		if p.Streak > 0 {
+			p.Streak = 0
+		}
+		p.Streak--
+	default:
+		p.Streak = 0
+	}
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"fmt"
+	"unicode"
+)
+
+// Account settings (PlayerAccount.Settings). Only these keys are accepted, so the settings
+// cannot grow into a store for arbitrary client data.
+const (
+	SettingPreferredDeck        = "preferred_deck"         // Name of the deck to pre-select
+	SettingHideOpponentMana     = "hide_opponent_mana"     // "true" to opt in to hidden opponent mana in ranked games
+	SettingAcceptPrivateInvites = "accept_private_invites" // "false" to refuse private game invites
+)
+
+// MaxSettingValueLength bounds the length of a setting value in bytes.
+const MaxSettingValueLength = 64
+
+// settingValidators checks the value of each known setting.
+var settingValidators = map[string]func(string) error{
+	SettingPreferredDeck:        validateSettingName,
+	SettingHideOpponentMana:     validateSettingBool,
+	SettingAcceptPrivateInvites: validateSettingBool,
+}
+
+// ValidateSetting checks that key is a known setting and value is acceptable for it.
+// An empty value is always acceptable: it clears the setting.
+func ValidateSetting(key, value string) error {
+	validate, ok := settingValidators[key]
+	if !ok {
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	if value == "" {
+		return nil
+	}
+	if len(value) > MaxSettingValueLength {
+		return fmt.Errorf("setting %q: value is longer than %d bytes", key, MaxSettingValueLength)
+	}
+	if err := validate(value); err != nil {
+		return fmt.Errorf("setting %q: %w", key, err)
+	}
+	return nil
+}
+
+// ApplySettings validates every entry of update and, only if all pass, merges them into the
+// account's settings. An empty value removes the setting.
+func (p *PlayerAccount) ApplySettings(update map[string]string) error {
+	for _, key := range sortedKeys(update) {
+		if err := ValidateSetting(key, update[key]); err != nil {
+			return err
+		}
+	}
+	for key, value := range update {
+		if value == "" {
+			delete(p.Settings, key)
+			continue
+		}
+		if p.Settings == nil {
+			p.Settings = make(map[string]string)
+		}
+		p.Settings[key] = value
+	}
+	return nil
+}
+
+// SettingEnabled reports whether a boolean setting is "true".
+func (p *PlayerAccount) SettingEnabled(key string) bool {
+	return p.Settings[key] == "true"
+}
+
+// validateSettingName accepts printable text without control characters.
+func validateSettingName(value string) error {
+	for _, r := range value {
+		if !unicode.IsPrint(r) {
+			return fmt.Errorf("value contains a non-printable character")
+		}
+	}
+	return nil
+}
+
+// validateSettingBool accepts "true" or "false".
+func validateSettingBool(value string) error {
+	if value != "true" && value != "false" {
+		return fmt.Errorf("value %q must be \"true\" or \"false\"", value)
+	}
+	return nil
+}
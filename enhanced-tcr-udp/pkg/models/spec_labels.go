@@ -0,0 +1,147 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"unicode"
+	"unicode/utf8"
+)
+
+// specLabel points at the display fields of one tower or troop spec while they are filled in.
+type specLabel struct {
+	id          string
+	name        string
+	displayName *string
+	symbol      *string
+}
+
+// Normalize fills in missing DisplayName and Symbol values and validates them, so configs
+// written before those fields existed keep working. DisplayName defaults to Name (or the ID
+// when Name is empty). Symbol defaults to the first letter of the display name not already
+// used in its category, trying uppercase before lowercase. Explicit symbols must be a single
+// printable, non-space rune and unique among the towers or among the troops.
+func (c *GameConfig) Normalize() error {
+	towerIDs := sortedKeys(c.Towers)
+	towers := make([]TowerSpec, len(towerIDs))
+	towerLabels := make([]specLabel, len(towerIDs))
+	for i, id := range towerIDs {
+		towers[i] = c.Towers[id]
+		towerLabels[i] = specLabel{id: id, name: towers[i].Name, displayName: &towers[i].DisplayName, symbol: &towers[i].Symbol}
+	}
+	if err := normalizeLabels("tower", towerLabels); err != nil {
+		return err
+	}
+	for i, id := range towerIDs {
+		c.Towers[id] = towers[i]
+	}
+
+	troopIDs := sortedKeys(c.Troops)
+	troops := make([]TroopSpec, len(troopIDs))
+	troopLabels := make([]specLabel, len(troopIDs))
+	for i, id := range troopIDs {
+		troops[i] = c.Troops[id]
+		troopLabels[i] = specLabel{id: id, name: troops[i].Name, displayName: &troops[i].DisplayName, symbol: &troops[i].Symbol}
+	}
+	if err := normalizeLabels("troop", troopLabels); err != nil {
+		return err
+	}
+	for i, id := range troopIDs {
+		c.Troops[id] = troops[i]
+	}
+	return nil
+}
+
+// TowerDisplayName returns the display name of a tower spec, or the spec ID if it is unknown.
+func (c *GameConfig) TowerDisplayName(specID string) string {
+	if c != nil {
+		if spec, ok := c.Towers[specID]; ok && spec.DisplayName != "" {
+			return spec.DisplayName
+		}
+	}
+	return specID
+}
+
+// TroopDisplayName returns the display name of a troop spec, or the spec ID if it is unknown.
+func (c *GameConfig) TroopDisplayName(specID string) string {
+	if c != nil {
+		if spec, ok := c.Troops[specID]; ok && spec.DisplayName != "" {
+			return spec.DisplayName
+		}
+	}
+	return specID
+}
+
+// normalizeLabels applies the Normalize rules to one category. Explicit symbols are claimed
+// first so a derived symbol never steals one the config asked for.
+func normalizeLabels(category string, labels []specLabel) error {
+	used := make(map[rune]string, len(labels))
+	for _, l := range labels {
+		if *l.displayName == "" {
+			*l.displayName = l.name
+		}
+		if *l.displayName == "" {
+			*l.displayName = l.id
+		}
+		if *l.symbol == "" {
+			continue
+		}
+		r, err := parseSymbol(*l.symbol)
+		if err != nil {
+			return fmt.Errorf("%s %q: %w", category, l.id, err)
+		}
+		if other, taken := used[r]; taken {
+			return fmt.Errorf("%s %q: symbol %q is already used by %s %q", category, l.id, *l.symbol, category, other)
+		}
+		used[r] = l.id
+	}
+	for _, l := range labels {
+		if *l.symbol != "" {
+			continue
+		}
+		r, ok := deriveSymbol(*l.displayName, used)
+		if !ok {
+			return fmt.Errorf("%s %q: no free symbol can be derived from %q; set one explicitly", category, l.id, *l.displayName)
+		}
+		used[r] = l.id
+		*l.symbol = string(r)
+	}
+	return nil
+}
+
+// parseSymbol checks that s is exactly one printable, non-space rune.
+func parseSymbol(s string) (rune, error) {
+	if utf8.RuneCountInString(s) != 1 {
+		return 0, fmt.Errorf("symbol %q must be a single character", s)
+	}
+	r, _ := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError || !unicode.IsPrint(r) || unicode.IsSpace(r) {
+		return 0, fmt.Errorf("symbol %q must be a printable, non-space character", s)
+	}
+	return r, nil
+}
+
+// deriveSymbol picks the first letter or digit of name not in used, uppercase first.
+func deriveSymbol(name string, used map[rune]string) (rune, bool) {
+	for _, transform := range []func(rune) rune{unicode.ToUpper, unicode.ToLower} {
+		for _, r := range name {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				continue
+			}
+			candidate := transform(r)
+			if _, taken := used[candidate]; !taken {
+				return candidate, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// sortedKeys returns a map's keys in order, so derived symbols don't depend on map iteration.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,207 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Capture record directions and transports.
+const (
+	CaptureSent     = "sent"
+	CaptureReceived = "received"
+	CaptureTCP      = "tcp"
+	CaptureUDP      = "udp"
+)
+
+// captureRedacted replaces the value of every field named in captureSecretFields.
+const captureRedacted = "[redacted]"
+
+// captureRecentRecords is how many of the latest records a Capture keeps in memory; see Recent.
+const captureRecentRecords = 20
+
+// captureSecretFields are the JSON field names whose values never reach a capture file.
+var captureSecretFields = map[string]bool{"password": true}
+
+// CaptureRecord is one line of a capture file: a single message as it crossed the wire.
+type CaptureRecord struct {
+	Time      time.Time       `json:"time"`
+	Direction string          `json:"direction"` // CaptureSent or CaptureReceived
+	Transport string          `json:"transport"` // CaptureTCP or CaptureUDP
+	Payload   json.RawMessage `json:"payload"`   // The message with secrets redacted; data that is not JSON is kept as a string
+}
+
+// Capture appends every message it is given to a JSON-lines stream, for debugging the
+// protocol. Writes are buffered; Flush or Close pushes them out. A nil *Capture records
+// nothing, so callers need not check whether capturing is on. It is safe for concurrent use.
+type Capture struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer         // Closed by Close; nil when the caller owns the writer
+	recent []json.RawMessage // The latest records, oldest first; at most captureRecentRecords
+}
+
+// NewCapture returns a Capture writing to w. Closing it flushes but does not close w.
+func NewCapture(w io.Writer) *Capture {
+	return &Capture{w: bufio.NewWriter(w)}
+}
+
+// OpenCapture returns a Capture appending to the file at path, creating it if needed.
+func OpenCapture(path string) (*Capture, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	c := NewCapture(f)
+	c.closer = f
+	return c, nil
+}
+
+// Record appends one message. data is the message as sent or received, normally one JSON
+// document; fields holding secrets (see captureSecretFields) are redacted first.
+func (c *Capture) Record(direction, transport string, data []byte) {
+	c.RecordAt(time.Time{}, direction, transport, data)
+}
+
+// RecordAt is Record for a message that crossed the wire at t, for callers that write
+// records some time after the fact. A zero t means now.
+func (c *Capture) RecordAt(t time.Time, direction, transport string, data []byte) {
+	if c == nil {
+		return
+	}
+	record := CaptureRecord{Time: t, Direction: direction, Transport: transport, Payload: redactCapturePayload(data)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	c.w.Write(append(line, '\n'))
+	if len(c.recent) >= captureRecentRecords {
+		c.recent = c.recent[1:]
+	}
+	c.recent = append(c.recent, line)
+}
+
+// Recent returns the latest records, oldest first, for a crash report. They stay available
+// after Close.
+func (c *Capture) Recent() []json.RawMessage {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]json.RawMessage(nil), c.recent...)
+}
+
+// RecordValue marshals v and records it; it is for messages written through an encoder.
+func (c *Capture) RecordValue(direction, transport string, v interface{}) {
+	if c == nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	c.Record(direction, transport, data)
+}
+
+// Flush writes out buffered records.
+func (c *Capture) Flush() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.w.Flush()
+}
+
+// Close flushes the capture and closes its file, if it opened one.
+func (c *Capture) Close() error {
+	if c == nil {
+		return nil
+	}
+	err := c.Flush()
+	if c.closer != nil {
+		if closeErr := c.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// ReadCapture reads back the records of a capture file, in the order they were written.
+func ReadCapture(r io.Reader) ([]CaptureRecord, error) {
+	var records []CaptureRecord
+	decoder := json.NewDecoder(r)
+	for {
+		var record CaptureRecord
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, fmt.Errorf("capture record %d: %w", len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+}
+
+// redactCapturePayload returns data as a JSON value for a capture record, with secret fields
+// replaced at any depth. Data that is not valid JSON is returned as a JSON string.
+func redactCapturePayload(data []byte) json.RawMessage {
+	data = bytes.TrimSpace(data)
+	if !json.Valid(data) {
+		quoted, _ := json.Marshal(string(data))
+		return quoted
+	}
+	needsRedaction := false
+	for field := range captureSecretFields {
+		if bytes.Contains(data, []byte(`"`+field+`"`)) {
+			needsRedaction = true
+			break
+		}
+	}
+	if !needsRedaction {
+		return append(json.RawMessage(nil), data...)
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		quoted, _ := json.Marshal(captureRedacted)
+		return quoted
+	}
+	redacted, err := json.Marshal(redactSecrets(v))
+	if err != nil {
+		quoted, _ := json.Marshal(captureRedacted)
+		return quoted
+	}
+	return redacted
+}
+
+// redactSecrets replaces the values of secret fields in a decoded JSON value.
+func redactSecrets(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if captureSecretFields[key] {
+				value[key] = captureRedacted
+			} else {
+				value[key] = redactSecrets(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactSecrets(item)
+		}
+	}
+	return v
+}
@@ -0,0 +1,30 @@
+package network
+
+import "encoding/json"
+
+// EncodeJSON marshals an interface{} into a JSON byte slice.
+func EncodeJSON(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// DecodeJSON unmarshals a JSON byte slice into an interface{}.
+// The second argument should be a pointer to the struct you want to decode into.
+func DecodeJSON(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// DecodePayload converts a message payload into a T. A payload decoded as part of its
+// envelope arrives as a map[string]interface{} (or a json.RawMessage, if the envelope asked
+// for one); either is turned back into JSON and decoded again.
+func DecodePayload[T any](payload interface{}) (T, error) {
+	var v T
+	data, ok := payload.(json.RawMessage)
+	if !ok {
+		var err error
+		if data, err = json.Marshal(payload); err != nil {
+			return v, err
+		}
+	}
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
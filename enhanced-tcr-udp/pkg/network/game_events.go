@@ -0,0 +1,200 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Details of each game event type (GameEventUDP.Details). The server sends these structs;
+// DecodeGameEvent turns the details of a received event back into the one registered for
+// its type. The JSON field names are the keys the details maps of earlier servers used, so
+// details from those servers decode the same way.
+
+// TowerDamagedEvent details GameEventTowerDamaged: a troop hit a tower.
+type TowerDamagedEvent struct {
+	AttackerID   string `json:"attacker_id"`   // Troop instance ID
+	AttackerSpec string `json:"attacker_spec"` // TroopSpec.ID
+	DefenderID   string `json:"defender_id"`   // Tower GameSpecificID
+	DefenderSpec string `json:"defender_spec"` // TowerSpec.ID
+	Damage       int    `json:"damage"`
+	NewHP        int    `json:"new_hp"`
+	// Effectiveness is the damage type's multiplier against the defender's armor; 0 (absent)
+	// means neutral.
+	Effectiveness float64 `json:"effectiveness,omitempty"`
+}
+
+// ChargeHitEvent details GameEventChargeHit: a charge troop's boosted first hit on a tower.
+type ChargeHitEvent struct {
+	TowerDamagedEvent
+	Multiplier float64 `json:"multiplier"` // The charge bonus applied to the hit
+}
+
+// TroopDamagedEvent details GameEventTroopDamaged: a tower hit a troop.
+type TroopDamagedEvent struct {
+	AttackerID    string  `json:"attacker_id"`   // Tower GameSpecificID
+	AttackerSpec  string  `json:"attacker_spec"` // TowerSpec.ID
+	DefenderID    string  `json:"defender_id"`   // Troop instance ID
+	DefenderSpec  string  `json:"defender_spec"` // TroopSpec.ID
+	Damage        int     `json:"damage"`
+	NewHP         int     `json:"new_hp"`
+	Effectiveness float64 `json:"effectiveness,omitempty"` // As in TowerDamagedEvent
+
+	ShieldAbsorbed int `json:"shield_absorbed,omitempty"` // Damage the troop's shield took instead of its HP
+	ShieldLeft     int `json:"shield_left,omitempty"`     // Shield remaining after the hit; 0 once it broke
+}
+
+// CritHitEvent details GameEventCritHit: a tower's critical hit on a troop.
+type CritHitEvent struct {
+	TroopDamagedEvent
+}
+
+// TowerDestroyedEvent details GameEventTowerDestroyed.
+type TowerDestroyedEvent struct {
+	TowerID            string `json:"tower_id"`
+	TowerSpec          string `json:"tower_spec"`
+	OwnerID            string `json:"owner_id"`              // Username of the player who lost the tower
+	DestroyedByTroopID string `json:"destroyed_by_troop_id"` // Instance ID of the troop that destroyed it
+	// DestroyedByTroopSpec is the TroopSpec.ID of that troop, to name it by; earlier servers
+	// leave it out.
+	DestroyedByTroopSpec string `json:"destroyed_by_troop_spec,omitempty"`
+}
+
+// TroopDefeatedEvent details GameEventTroopDefeated.
+type TroopDefeatedEvent struct {
+	TroopID           string `json:"troop_id"`
+	TroopSpec         string `json:"troop_spec"`
+	OwnerID           string `json:"owner_id"`             // Username of the player who lost the troop
+	DefeatedByTowerID string `json:"defeated_by_tower_id"` // GameSpecificID of the tower that defeated it
+	// DefeatedByTowerSpec is the TowerSpec.ID of that tower, to name it by; earlier servers
+	// leave it out.
+	DefeatedByTowerSpec string `json:"defeated_by_tower_spec,omitempty"`
+}
+
+// TroopExpiredEvent details GameEventTroopExpired: a troop with a lifetime left the field
+// without being defeated.
+type TroopExpiredEvent struct {
+	TroopID   string `json:"troop_id"`
+	TroopSpec string `json:"troop_spec"`
+	OwnerID   string `json:"owner_id"` // Username of the player who deployed the troop
+}
+
+// QueenHealEvent details GameEventQueenHeal. The tower fields are empty when there was no
+// damaged tower to heal.
+type QueenHealEvent struct {
+	PlayerID     string `json:"player_id"` // Username of the player who deployed the Queen
+	Message      string `json:"message"`   // The heal described for the event log
+	TowerID      string `json:"tower_id,omitempty"`
+	TowerSpec    string `json:"tower_spec,omitempty"`
+	HealedAmount int    `json:"healed_amount,omitempty"`
+	NewHP        int    `json:"new_hp,omitempty"`
+}
+
+// TroopDeployedEvent details GameEventTroopDeployed.
+type TroopDeployedEvent struct {
+	PlayerID   string `json:"player_id"` // Username of the player who deployed the troop
+	TroopID    string `json:"troop_id"`  // Instance ID of the new troop
+	TroopSpec  string `json:"troop_spec"`
+	OwnerID    string `json:"owner_id"`
+	CurrentHP  int    `json:"current_hp"`
+	MaxHP      int    `json:"max_hp"`
+	CurrentATK int    `json:"current_atk"`
+	Lane       string `json:"lane,omitempty"` // Lane deployed into in two-lane games
+}
+
+// GameErrorEvent details GameEventError, sent to one player.
+type GameErrorEvent struct {
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"`  // One of the GameError* constants
+	Seq     uint32 `json:"seq,omitempty"`   // Command-stream Seq of the command the error is about
+	Mana    int    `json:"mana,omitempty"`  // The player's mana after a rejected deploy
+	Retry   bool   `json:"retry,omitempty"` // The client may resend the command
+}
+
+// CountdownEvent details GameEventCountdown.
+type CountdownEvent struct {
+	SecondsLeft int `json:"seconds_left"` // Until combat starts
+}
+
+// CombatStartEvent details GameEventCombatStart.
+type CombatStartEvent struct {
+	DurationSeconds int `json:"duration_seconds"` // Length of the game from now
+}
+
+// SpectatorEvent details GameEventSpectatorJoined and GameEventSpectatorLeft.
+type SpectatorEvent struct {
+	Spectator string `json:"spectator"` // Username of the spectator who joined or left
+	Count     int    `json:"count"`     // Spectators watching now
+}
+
+// OpponentConnectionEvent details GameEventOpponentDisconnected and
+// GameEventOpponentReconnected, sent to the player who is still connected.
+type OpponentConnectionEvent struct {
+	Opponent string `json:"opponent"` // Username of the player who went silent or came back
+	// GraceSeconds is how much longer the paused game waits for the opponent. It is resent
+	// as it counts down; 0 means the wait is over and the game goes on without them.
+	// Always 0 in GameEventOpponentReconnected.
+	GraceSeconds int `json:"grace_seconds,omitempty"`
+}
+
+// GameEventDeployFailed is an event type early servers sent instead of GameEventError.
+const GameEventDeployFailed = "DeployFailed"
+
+// DeployFailedEvent details GameEventDeployFailed.
+type DeployFailedEvent struct {
+	Reason string `json:"reason"`
+}
+
+// gameEventDetails maps each event type to the decoder for its details.
+var gameEventDetails = map[string]func(json.RawMessage) (interface{}, error){
+	GameEventTowerDamaged:    decodeEventDetails[TowerDamagedEvent],
+	GameEventChargeHit:       decodeEventDetails[ChargeHitEvent],
+	GameEventTroopDamaged:    decodeEventDetails[TroopDamagedEvent],
+	GameEventCritHit:         decodeEventDetails[CritHitEvent],
+	GameEventTowerDestroyed:  decodeEventDetails[TowerDestroyedEvent],
+	GameEventTroopDefeated:   decodeEventDetails[TroopDefeatedEvent],
+	GameEventTroopExpired:    decodeEventDetails[TroopExpiredEvent],
+	GameEventQueenHeal:       decodeEventDetails[QueenHealEvent],
+	GameEventTroopDeployed:   decodeEventDetails[TroopDeployedEvent],
+	GameEventError:           decodeEventDetails[GameErrorEvent],
+	GameEventCountdown:       decodeEventDetails[CountdownEvent],
+	GameEventCombatStart:     decodeEventDetails[CombatStartEvent],
+	GameEventSpectatorJoined: decodeEventDetails[SpectatorEvent],
+	GameEventSpectatorLeft:   decodeEventDetails[SpectatorEvent],
+
+	GameEventOpponentDisconnected: decodeEventDetails[OpponentConnectionEvent],
+	GameEventOpponentReconnected:  decodeEventDetails[OpponentConnectionEvent],
+	GameEventDeployFailed:         decodeEventDetails[DeployFailedEvent],
+}
+
+// decodeEventDetails decodes details into a T. Missing or null details give a zero T.
+func decodeEventDetails[T any](raw json.RawMessage) (interface{}, error) {
+	var details T
+	if len(raw) == 0 {
+		return details, nil
+	}
+	err := json.Unmarshal(raw, &details)
+	return details, err
+}
+
+// DecodeGameEvent decodes the payload of a UDPMsgTypeGameEvent message, as received in
+// UDPMessage.Payload. The event's Details become the struct registered for its type, e.g.
+// a TowerDamagedEvent for GameEventTowerDamaged, held by value. Details of an event type
+// with no struct are left as a map[string]interface{}.
+func DecodeGameEvent(payload interface{}) (GameEventUDP, error) {
+	raw, err := DecodePayload[struct {
+		EventType string          `json:"event_type"`
+		Details   json.RawMessage `json:"details"`
+	}](payload)
+	if err != nil {
+		return GameEventUDP{}, err
+	}
+	event := GameEventUDP{EventType: raw.EventType}
+	decode, ok := gameEventDetails[raw.EventType]
+	if !ok {
+		decode = decodeEventDetails[map[string]interface{}]
+	}
+	if event.Details, err = decode(raw.Details); err != nil {
+		return event, fmt.Errorf("%s details: %w", raw.EventType, err)
+	}
+	return event, nil
+}
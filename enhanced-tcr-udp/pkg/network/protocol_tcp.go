@@ -0,0 +1,469 @@
+package network
+
+import (
+	"maps"
+
+	"enhanced-tcr-udp/pkg/models"
+)
+
+// ProtocolVersion is the protocol version spoken by this build.
+// Version 2 introduced UDPMessage.Stream with per-stream sequence numbers.
+// Version 3 sends towers and troops in state updates as TowerState and TroopState
+// instead of the full server models.
+// Version 4 sends the game config in a MsgTypeGameConfigData message right after
+// MatchFoundResponse instead of inside it.
+// Version 5 waits for requests after login: the client may send MsgTypeUpdateSettings
+// any number of times, then MsgTypeMatchmakingRequest to enter matchmaking. Earlier
+// clients are put into matchmaking straight after login.
+// Version 6 sends a GameTimerUpdateUDP instead of a full state update when only the game
+// clock has changed. Earlier clients get full state updates throughout.
+// Version 7 lets a client rejoin its running game after logging in again, with
+// MsgTypeReconnectRequest; the server then issues it a new session token.
+// Version 8 adds GameStateUpdateUDP.PlayerMana, keyed by username. Player1Mana and
+// Player2Mana are still sent for earlier clients.
+// Version 9 adds UDPMsgTypeRequestFullState, with which a client asks for a full state
+// update at once.
+// Version 10 clients ping their game session every HeartbeatInterval. The server pauses the
+// game while such a client is silent, for a limited time, and tells the opponent with
+// GameEventOpponentDisconnected and GameEventOpponentReconnected.
+// Version 11 clients that get no pong from the game's UDP port send MsgTypeUDPUnreachable;
+// the server then cancels the match for both players with MsgTypeMatchCancelled.
+// Version 12 answers MsgTypeGetGameConfig before matchmaking, so that clients can show the
+// troops and towers outside a game.
+// Clients that do not send a version are treated as version 1.
+const ProtocolVersion = 12
+
+// ProtocolVersionRequests is the first version that sends requests after login.
+const ProtocolVersionRequests = 5
+
+// ProtocolVersionTimerUpdates is the first version that understands UDPMsgTypeGameTimer.
+const ProtocolVersionTimerUpdates = 6
+
+// ProtocolVersionReconnect is the first version that can rejoin a running game.
+const ProtocolVersionReconnect = 7
+
+// ProtocolVersionManaByPlayer is the first version whose state updates carry PlayerMana.
+const ProtocolVersionManaByPlayer = 8
+
+// ProtocolVersionFullState is the first version that answers UDPMsgTypeRequestFullState.
+const ProtocolVersionFullState = 9
+
+// ProtocolVersionHeartbeat is the first version whose clients send heartbeats, and so the
+// first the server pauses the game for when they go silent.
+const ProtocolVersionHeartbeat = 10
+
+// ProtocolVersionUDPUnreachable is the first version whose server listens for
+// MsgTypeUDPUnreachable during the game.
+const ProtocolVersionUDPUnreachable = 11
+
+// ProtocolVersionGameConfigRequest is the first version that answers MsgTypeGetGameConfig.
+const ProtocolVersionGameConfigRequest = 12
+
+// MaxSettingsPayloadSize is the largest MsgTypeUpdateSettings payload the server accepts.
+const MaxSettingsPayloadSize = 1024
+
+// Standard envelope for all TCP messages to define message type
+const (
+	MsgTypeLoginRequest       = "login_request"
+	MsgTypeLoginResponse      = "login_response"
+	MsgTypeMatchmakingRequest = "matchmaking_request"
+	MsgTypeMatchFoundResponse = "match_found_response"
+	MsgTypeGameConfigData     = "game_config_data"
+	MsgTypeGameOverResults    = "game_over_results"
+	MsgTypePendingResults     = "pending_results"    // Results of earlier games that could not be delivered when they ended
+	MsgTypeMatchCancelled     = "match_cancelled"    // A match was announced but could not start
+	MsgTypeMatchSetupFailed   = "match_setup_failed" // The server could not create the game session for a match
+	MsgTypeUpdateSettings     = "update_settings"    // Client changes its account settings (UpdateSettingsRequest)
+	MsgTypeSettingsUpdated    = "settings_updated"   // Server's answer to MsgTypeUpdateSettings (UpdateSettingsResponse)
+	MsgTypeReconnectRequest   = "reconnect_request"  // Client rejoins its running game (ReconnectRequest)
+	MsgTypeReconnectResponse  = "reconnect_response" // Server's answer to MsgTypeReconnectRequest (ReconnectResponse)
+	MsgTypeUDPUnreachable     = "udp_unreachable"    // Client gets no answer from its game's UDP port (UDPUnreachable)
+	MsgTypeGetGameConfig      = "get_game_config"    // Client asks for the game config before matchmaking (GetGameConfigRequest)
+	MsgTypeGameConfigResponse = "game_config"        // Server's answer to MsgTypeGetGameConfig (GameConfigResponse)
+	// MsgTypeHealthPing may be sent instead of a LoginRequest as the first message on a
+	// connection; the server answers with MsgTypeHealthPong (HealthPong) and closes it.
+	MsgTypeHealthPing = "health_ping"
+	MsgTypeHealthPong = "health_pong"
+	// Add other TCP message types here as needed
+)
+
+type TCPMessage struct {
+	Type    string      `json:"type"`    // e.g., MsgTypeLoginRequest
+	Payload interface{} `json:"payload"` // The actual data structure for the message type
+}
+
+// --- Client to Server (C2S) TCP Messages ---
+
+// LoginRequest is the structure for a client's login attempt.
+type LoginRequest struct {
+	Username        string `json:"username"`
+	Password        string `json:"password"`
+	ProtocolVersion int    `json:"protocol_version,omitempty"` // Highest protocol version the client speaks
+}
+
+// Matchmaking queues, as sent in MatchmakingRequest.QueueType. Ranked games count towards
+// the players' records; casual games award less EXP and leave wins, losses and streaks alone.
+const (
+	QueueRanked = "ranked"
+	QueueCasual = "casual"
+)
+
+// MatchmakingRequest is sent by the client to find a game.
+type MatchmakingRequest struct {
+	PlayerID  string `json:"player_id"`            // Username or a session token
+	QueueType string `json:"queue_type,omitempty"` // QueueRanked or QueueCasual; empty means ranked
+	// PreferredDurationSeconds is the game length the player would like, one of the server's
+	// duration choices; 0 means any. Older servers ignore it.
+	PreferredDurationSeconds int `json:"preferred_duration_seconds,omitempty"`
+}
+
+// NormalizeQueueType returns the queue a MatchmakingRequest.QueueType asks for, and whether
+// it was recognized. Anything but QueueCasual means ranked, the only queue older clients know.
+func NormalizeQueueType(queueType string) (string, bool) {
+	switch queueType {
+	case QueueCasual:
+		return QueueCasual, true
+	case QueueRanked, "":
+		return QueueRanked, true
+	}
+	return QueueRanked, false
+}
+
+// MatchmakingResponse is sent by the server when a match is found or status update.
+type MatchmakingResponse struct {
+	Status          string `json:"status"` // e.g., "searching", "match_found", "error"
+	Message         string `json:"message"`
+	OpponentName    string `json:"opponent_name,omitempty"`
+	GameID          string `json:"game_id,omitempty"`           // Unique ID for the game session
+	AssignedUDPPort int    `json:"assigned_udp_port,omitempty"` // UDP port for this game
+}
+
+// UpdateSettingsRequest changes account settings. Only the keys given are touched; an
+// empty value clears a setting. Unknown keys or bad values reject the whole request.
+type UpdateSettingsRequest struct {
+	Settings map[string]string `json:"settings"`
+}
+
+// GetGameConfigRequest asks for the troops and towers games are currently played with.
+type GetGameConfigRequest struct {
+	// KnownHash is the GameConfigResponse.Hash of a config the client already holds, if any.
+	// The server leaves the config out of its answer when it has not changed since.
+	KnownHash string `json:"known_hash,omitempty"`
+}
+
+// ReconnectRequest asks to rejoin the game the player is still in, after the client lost
+// it (a crash, a network change). The login authenticates the request.
+type ReconnectRequest struct {
+	GameID string `json:"game_id"` // The game to rejoin, from LoginResponse.ActiveGameID
+}
+
+// --- Server to Client (S2C) TCP Messages ---
+
+// UpdateSettingsResponse answers an UpdateSettingsRequest with the settings now in effect.
+type UpdateSettingsResponse struct {
+	Success  bool              `json:"success"`
+	Message  string            `json:"message,omitempty"` // Why the update was rejected
+	Settings map[string]string `json:"settings,omitempty"`
+}
+
+// GameConfigResponse answers a GetGameConfigRequest. The config is the one the next game
+// will be set up with; a match still gets its own in GameConfigData, since the server's
+// config files may change in between.
+type GameConfigResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"` // Why there is no config
+	Hash    string `json:"hash,omitempty"`    // models.GameConfig.Hash of the config
+	// Unchanged means Hash equals the request's KnownHash, and Config is left out.
+	Unchanged bool               `json:"unchanged,omitempty"`
+	Config    *models.GameConfig `json:"config,omitempty"`
+}
+
+// LoginResponse is the structure for the server's response to a login attempt.
+type LoginResponse struct {
+	Success bool        `json:"success"`
+	Message string      `json:"message"`
+	Player  *OwnProfile `json:"player,omitempty"` // Sent on successful login
+	// ProtocolVersion is the version both sides use for this connection:
+	// the lower of the client's and the server's.
+	ProtocolVersion int `json:"protocol_version,omitempty"`
+	// HasPendingResults tells the client a MsgTypePendingResults message follows immediately.
+	HasPendingResults bool `json:"has_pending_results,omitempty"`
+	// ActiveGameID is the running game the player is in, which a client speaking
+	// ProtocolVersionReconnect can rejoin with a ReconnectRequest.
+	ActiveGameID string `json:"active_game_id,omitempty"`
+}
+
+// ReconnectResponse answers a ReconnectRequest. On success Match describes the game as
+// MatchFoundResponse does, with a newly issued session token; the old token no longer
+// works. The game config follows in a MsgTypeGameConfigData message, and the game's
+// results arrive on this connection.
+type ReconnectResponse struct {
+	Success bool                `json:"success"`
+	Message string              `json:"message,omitempty"` // Why the player could not rejoin
+	Match   *MatchFoundResponse `json:"match,omitempty"`
+	// State is the whole game state when the player rejoined, marked as a snapshot, so the
+	// client can draw the game before any UDP update reaches it. Older servers send none.
+	State *GameStateUpdateUDP `json:"state,omitempty"`
+}
+
+// PublicProfile is what any player may be shown about another, e.g. their opponent. It is
+// built field by field from the PlayerAccount so EXP, the password hash and anything added
+// to the account later stay on the server. Network payloads never embed PlayerAccount itself.
+type PublicProfile struct {
+	Username string `json:"username"`
+	Level    int    `json:"level"`
+	Wins     int    `json:"wins"`
+	Losses   int    `json:"losses"`
+	Streak   int    `json:"streak"` // Positive for consecutive wins, negative for consecutive losses
+	// FavoriteTroop is the TroopSpec ID the player has deployed most; empty if none, and
+	// from older servers.
+	FavoriteTroop string `json:"favorite_troop,omitempty"`
+}
+
+// NewPublicProfile copies the public fields of an account.
+func NewPublicProfile(acc *models.PlayerAccount) PublicProfile {
+	return PublicProfile{
+		Username: acc.Username,
+		Level:    acc.Level,
+		Wins:     acc.Wins,
+		Losses:   acc.Losses,
+		Streak:   acc.Streak,
+
+		FavoriteTroop: acc.FavoriteTroop(),
+	}
+}
+
+// OwnProfile is a player's own account as sent to them at login: the public profile plus
+// the progress only they get to see.
+type OwnProfile struct {
+	PublicProfile
+	EXP      int               `json:"exp"`
+	Settings map[string]string `json:"settings,omitempty"` // The account's settings, for the client to apply
+	// All-time totals, as in PlayerAccount; zero from older servers.
+	GamesPlayed    int            `json:"games_played,omitempty"`
+	TotalEXPEarned int            `json:"total_exp_earned,omitempty"`
+	TroopDeploys   map[string]int `json:"troop_deploys,omitempty"`
+}
+
+// NewOwnProfile copies the fields of an account its owner may see.
+func NewOwnProfile(acc *models.PlayerAccount) *OwnProfile {
+	return &OwnProfile{
+		PublicProfile:  NewPublicProfile(acc),
+		EXP:            acc.EXP,
+		Settings:       copySettings(acc.Settings),
+		GamesPlayed:    acc.GamesPlayed,
+		TotalEXPEarned: acc.TotalEXPEarned,
+		TroopDeploys:   maps.Clone(acc.TroopDeploys),
+	}
+}
+
+// copySettings returns a copy of settings, or nil if there are none.
+func copySettings(settings map[string]string) map[string]string {
+	if len(settings) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(settings))
+	for key, value := range settings {
+		copied[key] = value
+	}
+	return copied
+}
+
+// Account returns the profile as a PlayerAccount for client-side use. It has no password hash.
+func (p *OwnProfile) Account() *models.PlayerAccount {
+	return &models.PlayerAccount{
+		Username: p.Username,
+		Level:    p.Level,
+		EXP:      p.EXP,
+		Wins:     p.Wins,
+		Losses:   p.Losses,
+		Streak:   p.Streak,
+		Settings: copySettings(p.Settings),
+
+		GamesPlayed:    p.GamesPlayed,
+		TotalEXPEarned: p.TotalEXPEarned,
+		TroopDeploys:   maps.Clone(p.TroopDeploys),
+	}
+}
+
+// MatchFoundResponse is sent when a match is made.
+type MatchFoundResponse struct {
+	GameID              string             `json:"game_id"`
+	Opponent            PublicProfile      `json:"opponent"`                        // Public info about the opponent
+	UDPHost             string             `json:"udp_host,omitempty"`              // Host to send game UDP to; empty means the TCP server's host
+	UDPPort             int                `json:"udp_port"`                        // UDP port for this game session
+	IsPlayerOne         bool               `json:"is_player_one"`                   // To help client identify its role initially
+	PlayerSessionToken  string             `json:"player_session_token"`            // Token for this player in this session
+	GameConfig          *models.GameConfig `json:"game_config,omitempty"`           // Full game config (troops, towers); only for clients older than version 4
+	QueueType           string             `json:"queue_type,omitempty"`            // The queue the match was made in; empty from older servers, meaning ranked
+	GameDurationSeconds int                `json:"game_duration_seconds,omitempty"` // How long the game lasts; 0 from older servers
+	// Handicaps are the handicaps either player plays the game with, keyed by username, so
+	// both sides know them. Only casual games have any.
+	Handicaps map[string]models.PlayerOverrides `json:"handicaps,omitempty"`
+	// May include initial turn info or other specific game start details
+}
+
+// MatchCancelled tells a player that the match they were just told about will not happen,
+// typically because the opponent disconnected before it started.
+type MatchCancelled struct {
+	GameID      string `json:"game_id"`
+	Reason      string `json:"reason"`
+	Prioritized bool   `json:"prioritized,omitempty"` // The player will be matched ahead of others on their next search
+}
+
+// UDPUnreachable tells the server that the client pinged its game's UDP port and never got
+// a pong, typically because a firewall blocks the port. The server cancels the match.
+type UDPUnreachable struct {
+	GameID   string `json:"game_id"`
+	Port     int    `json:"port"`
+	Attempts int    `json:"attempts"` // Pings sent before giving up
+}
+
+// HealthPong answers a MsgTypeHealthPing. It names the UDP port the server answers
+// connectivity checks on: a datagram starting with UDPCheckPrefix sent there comes back
+// unchanged.
+type HealthPong struct {
+	ProtocolVersion int    `json:"protocol_version"`         // The server's ProtocolVersion
+	UDPCheckHost    string `json:"udp_check_host,omitempty"` // Empty means the host the client reached over TCP
+	UDPCheckPort    int    `json:"udp_check_port,omitempty"` // 0 if the server has no UDP check responder
+}
+
+// UDPCheckPrefix starts every datagram of a UDP connectivity check.
+const UDPCheckPrefix = "tcr-udp-check "
+
+// MatchSetupFailed is sent instead of MatchFoundResponse when the server paired the player
+// but could not create the game session.
+type MatchSetupFailed struct {
+	Reason    string `json:"reason"`
+	Retryable bool   `json:"retryable"` // The failure is transient; trying again may succeed
+	Requeued  bool   `json:"requeued"`  // The server kept the player in the queue; keep waiting for a match
+	// Prioritized means the player is matched ahead of players who did not lose a match to
+	// the failure, while they are requeued or on their next search.
+	Prioritized bool `json:"prioritized,omitempty"`
+	// ActiveGameID is set when the player was refused because they are still in this
+	// running game. A client speaking ProtocolVersionReconnect can rejoin it instead, with
+	// a ReconnectRequest on the same connection.
+	ActiveGameID string `json:"active_game_id,omitempty"`
+}
+
+// GameConfigData contains the initial game configuration. From protocol version 4 it is
+// sent in a TCPMessage of type MsgTypeGameConfigData right after MatchFoundResponse.
+type GameConfigData struct {
+	Config models.GameConfig `json:"config"`
+}
+
+// GameOverResults contains the results of the game.
+type GameOverResults struct {
+	GameID          string         `json:"game_id,omitempty"`   // Session the results are for; empty from older servers
+	Reason          string         `json:"reason,omitempty"`    // Why the game ended, one of the GameEnd* constants
+	WinnerID        string         `json:"winner_id,omitempty"` // Empty if draw
+	Outcome         string         `json:"outcome"`             // e.g., "Win", "Loss", "Draw"
+	EXPChange       int            `json:"exp_change"`
+	NewEXP          int            `json:"new_exp"`
+	NewLevel        int            `json:"new_level"`
+	LevelUp         bool           `json:"level_up"`
+	DestroyedTowers map[string]int `json:"destroyed_towers"` // map[playerID]count
+
+	DurationSeconds          int `json:"duration_seconds"`            // Game clock time played
+	TroopsDeployedByYou      int `json:"troops_deployed_by_you"`      // Including Queens
+	TroopsDeployedByOpponent int `json:"troops_deployed_by_opponent"` // Including Queens
+	TotalDamageDealt         int `json:"total_damage_dealt"`          // HP removed by your troops and towers
+
+	Surrendered         bool `json:"surrendered,omitempty"`          // You surrendered this game
+	OpponentSurrendered bool `json:"opponent_surrendered,omitempty"` // Your opponent surrendered
+	ConsolationEXP      int  `json:"consolation_exp,omitempty"`      // Part of EXPChange awarded for the opponent's surrender
+	Casual              bool `json:"casual,omitempty"`               // A casual game: reduced EXP and the win/loss record unchanged
+
+	// EXPBreakdown itemizes EXPChange; its items add up to it. Older servers leave it out.
+	EXPBreakdown EXPBreakdown `json:"exp_breakdown,omitempty"`
+	// FirstWinBonus is the part of EXPChange paid for the player's first ranked win of the day.
+	FirstWinBonus int `json:"first_win_bonus,omitempty"`
+	// Summaries sums up both players' games, keyed by username. Older servers leave it out.
+	Summaries map[string]PlayerGameSummary `json:"summaries,omitempty"`
+}
+
+// PlayerGameSummary sums up how one player's game went.
+type PlayerGameSummary struct {
+	DamageDealt    int `json:"damage_dealt"`    // HP removed by the player's troops and towers
+	DamageTaken    int `json:"damage_taken"`    // HP the player's troops and towers lost
+	TroopsDeployed int `json:"troops_deployed"` // Including Queens
+	Heals          int `json:"heals"`           // HP the player's Queens restored
+	// MVPTroop is the TroopSpec ID of the player's troops that dealt the most damage to
+	// towers, MVPDamage how much; empty if none did.
+	MVPTroop  string `json:"mvp_troop,omitempty"`
+	MVPDamage int    `json:"mvp_damage,omitempty"`
+}
+
+// NewPlayerGameSummary sums up a player's game from its running totals.
+func NewPlayerGameSummary(stats models.PlayerMatchStats) PlayerGameSummary {
+	summary := PlayerGameSummary{
+		DamageDealt:    stats.DamageDealt,
+		DamageTaken:    stats.DamageTaken,
+		TroopsDeployed: stats.TroopsDeployed,
+		Heals:          stats.Heals,
+	}
+	summary.MVPTroop, summary.MVPDamage = stats.MVP()
+	return summary
+}
+
+// Sources of the EXP in an EXPItem.
+const (
+	EXPSourceTowers    = "towers"    // Opponent towers destroyed
+	EXPSourceResult    = "result"    // The win or draw bonus
+	EXPSourceSurrender = "surrender" // Paid to the winner for time played when the opponent surrendered
+	EXPSourceFirstWin  = "first_win" // The first ranked win of the day
+	EXPSourceCasual    = "casual"    // The reduction for a casual game; negative
+	EXPSourceOther     = "other"     // EXP the win conditions did not itemize
+)
+
+// EXPItem is one part of the EXP a player earned in a game.
+type EXPItem struct {
+	Source string `json:"source"` // One of the EXPSource* constants
+	Label  string `json:"label"`  // Describes the item for display, e.g. "Win bonus"
+	EXP    int    `json:"exp"`
+}
+
+// EXPBreakdown lists where a player's EXP for a game came from.
+type EXPBreakdown []EXPItem
+
+// Total returns the sum of the items' EXP.
+func (b EXPBreakdown) Total() int {
+	total := 0
+	for _, item := range b {
+		total += item.EXP
+	}
+	return total
+}
+
+// Reasons a game ends, as sent in GameOverResults.Reason and GameResultInfo.GameEndReason.
+const (
+	GameEndKingTowerDestroyed = "king_tower_destroyed"
+	GameEndTimeout            = "timeout"
+	GameEndPlayerQuit         = "player_quit"
+	GameEndSurrender          = "surrender"
+	GameEndForced             = "forced" // Ended by an operator
+)
+
+// GameResultInfo is used to pass comprehensive game results internally,
+// typically from a GameSession back to a managing component that handles TCP responses.
+type GameResultInfo struct {
+	SessionID       string          `json:"session_id"`
+	Player1Username string          `json:"player1_username"`
+	Player2Username string          `json:"player2_username"`
+	Player1Result   GameOverResults `json:"player1_result"`              // Individual result for player 1
+	Player2Result   GameOverResults `json:"player2_result"`              // Individual result for player 2
+	OverallWinnerID string          `json:"overall_winner_id,omitempty"` // Username of the winner, empty if draw
+	GameEndReason   string          `json:"game_end_reason"`             // e.g., "timeout", "king_tower_destroyed"
+}
+
+// NegotiateProtocolVersion returns the protocol version to use with a peer that announced
+// peerVersion (0 meaning the peer predates version negotiation).
+func NegotiateProtocolVersion(peerVersion int) int {
+	if peerVersion <= 0 {
+		return 1
+	}
+	if peerVersion < ProtocolVersion {
+		return peerVersion
+	}
+	return ProtocolVersion
+}
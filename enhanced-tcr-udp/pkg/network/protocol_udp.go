@@ -0,0 +1,244 @@
+package network
+
+import (
+	"time"
+)
+
+// General structure for UDP messages for identification and ordering.
+//
+// Every message belongs to one stream (see UDPStream* below). Each sender keeps an
+// independent, monotonically increasing Seq per stream, starting at 1:
+//   - the client numbers its commands (deploy, quit, ping) on UDPStreamCommand; the
+//     server's duplicate detection and the client's resend bookkeeping key off this Seq;
+//   - the server numbers ACKs, state updates and events on their own streams, so a
+//     receiver can order messages within a stream without comparing across streams.
+//
+// Peers speaking protocol version 1 leave Stream empty; StreamForType infers it from Type.
+type UDPMessage struct {
+	Stream      string      `json:"stream,omitempty"` // One of the UDPStream* constants
+	Seq         uint32      `json:"seq"`              // Sequence number within Stream
+	Timestamp   time.Time   `json:"timestamp"`        // Client or Server timestamp
+	SessionID   string      `json:"session_id"`       // Game Session ID
+	PlayerToken string      `json:"player_token"`     // Player identifier within the session (e.g., from PlayerInGame.SessionToken)
+	Type        string      `json:"type"`             // e.g., UDPMsgTypeDeployTroop
+	Payload     interface{} `json:"payload"`          // Actual data for the message type; the server reads it in as json.RawMessage
+}
+
+// UDP datagram sizes
+const (
+	// MaxUDPDatagramSize is the read buffer size on both ends. It holds any UDP payload,
+	// so a read that fills it completely can only mean a truncated datagram.
+	MaxUDPDatagramSize = 64 * 1024
+	// MaxStateUpdatePayloadSize is the largest serialized game state update sent as a single
+	// datagram. Larger updates are split into parts (see GameStateUpdateUDP.Parts).
+	MaxStateUpdatePayloadSize = 4096
+	// MaxDeployPayloadSize is the largest deploy command payload the server will decode.
+	// A real one is a few dozen bytes; anything bigger is rejected unread.
+	MaxDeployPayloadSize = 256
+)
+
+// Client command resend policy. It lives here rather than in the client so the server can
+// size its duplicate detection from the same numbers. The client may configure its timeout
+// and resend count, or adapt the timeout to the measured round trip, but never beyond
+// CommandResendTimeoutCeiling and CommandMaxResendsLimit.
+const (
+	CommandResendTimeout        = 1 * time.Second        // Default: resend a command not ACKed within this long
+	CommandResendTimeoutFloor   = 200 * time.Millisecond // Default lower bound on an adaptive resend timeout
+	CommandResendTimeoutCeiling = 5 * time.Second        // Hard upper bound on any resend timeout
+	CommandResendCheckInterval  = 500 * time.Millisecond // How often the client looks for commands to resend
+	CommandMaxResends           = 3                      // Default resends before the client gives up on a command
+	CommandMaxResendsLimit      = 8                      // Hard upper bound on the configured resends
+	CommandBusyBackoff          = 1 * time.Second        // Extra wait before resending after server_busy
+	CommandMaxBusyDeferrals     = 5                      // server_busy back-offs allowed per command
+
+	// CommandResendHorizon is the longest a client can keep sending copies of one command:
+	// the first send and every resend, each waiting out the longest timeout plus a check
+	// interval, plus every server_busy back-off. No copy of a command is sent after it.
+	CommandResendHorizon = (CommandMaxResendsLimit+1)*(CommandResendTimeoutCeiling+CommandResendCheckInterval) +
+		CommandMaxBusyDeferrals*(CommandBusyBackoff+CommandResendTimeoutCeiling+CommandResendCheckInterval)
+)
+
+// FullStateRequestInterval is the shortest gap the server allows between two full state
+// updates it sends one player in answer to UDPMsgTypeRequestFullState. Requests arriving
+// sooner are ignored; the client holds back its own requests for as long.
+const FullStateRequestInterval = 3 * time.Second
+
+// HeartbeatInterval is how often a client speaking ProtocolVersionHeartbeat pings its game
+// session, so the server can tell a quiet player from a disconnected one.
+const HeartbeatInterval = 2 * time.Second
+
+// UDP streams (UDPMessage.Stream)
+const (
+	UDPStreamCommand = "command" // Client -> server commands
+	UDPStreamAck     = "ack"     // Server -> client command acknowledgements
+	UDPStreamState   = "state"   // Server -> client game state updates
+	UDPStreamEvent   = "event"   // Server -> client game events
+)
+
+// UDP Message Types
+const (
+	UDPMsgTypeDeployTroop      = "deploy_troop_command_udp"
+	UDPMsgTypePlayerInput      = "player_input_udp" // Generic placeholder
+	UDPMsgTypeGameStateUpdate  = "game_state_update_udp"
+	UDPMsgTypeGameTimer        = "game_timer_udp" // Clock-only state update (GameTimerUpdateUDP)
+	UDPMsgTypeGameEvent        = "game_event_udp"
+	UDPMsgTypePlayerQuit       = "player_quit_udp"        // New: Client signals quit
+	UDPMsgTypeSurrender        = "surrender_udp"          // Client concedes the game; the opponent wins at once
+	UDPMsgTypeCommandAck       = "command_ack_udp"        // New: Server acknowledges a critical client command
+	UDPMsgTypePing             = "ping_udp"               // Client checks its game socket reaches the session
+	UDPMsgTypePong             = "pong_udp"               // Server's reply to a ping, on the ack stream
+	UDPMsgTypeRequestFullState = "request_full_state_udp" // Client asks for a full state update at once (RequestFullStateUDP)
+	// Add other UDP message types here
+
+	// Game Event Types (for GameEventUDP.EventType and server-side gs.sendGameEventToAllPlayers).
+	// The details of each are the struct named beside it; see game_events.go.
+	GameEventTowerDamaged    = "event_tower_damaged"    // TowerDamagedEvent
+	GameEventTroopDamaged    = "event_troop_damaged"    // TroopDamagedEvent
+	GameEventTowerDestroyed  = "event_tower_destroyed"  // TowerDestroyedEvent
+	GameEventTroopDefeated   = "event_troop_defeated"   // TroopDefeatedEvent
+	GameEventTroopExpired    = "event_troop_expired"    // A troop's lifetime ran out; TroopExpiredEvent
+	GameEventCritHit         = "event_crit_hit"         // CritHitEvent
+	GameEventQueenHeal       = "event_queen_heal"       // QueenHealEvent
+	GameEventTroopDeployed   = "event_troop_deployed"   // TroopDeployedEvent
+	GameEventChargeHit       = "event_charge_hit"       // A charge troop's boosted first attack; ChargeHitEvent
+	GameEventError           = "event_error"            // For sending errors to a specific player; GameErrorEvent
+	GameEventCountdown       = "event_countdown"        // Warm-up countdown; CountdownEvent
+	GameEventCombatStart     = "event_combat_start"     // Combat has begun; CombatStartEvent
+	GameEventSpectatorJoined = "event_spectator_joined" // Low priority; SpectatorEvent
+	GameEventSpectatorLeft   = "event_spectator_left"   // Low priority; SpectatorEvent
+	// The game paused because the opponent's client went silent, or the wait for it is over;
+	// OpponentConnectionEvent
+	GameEventOpponentDisconnected = "event_opponent_disconnected"
+	GameEventOpponentReconnected  = "event_opponent_reconnected" // The opponent is back and the game resumed; OpponentConnectionEvent
+
+	// GameErrorServerBusy is the GameErrorEvent.Code sent when the session could not queue a
+	// command in time. Its Seq names the command; Retry is true.
+	GameErrorServerBusy = "server_busy"
+	// GameErrorDeployRejected is the GameErrorEvent.Code sent when a deploy is refused
+	// (unknown troop, not enough mana, ...). Its Seq names the command and Mana is the
+	// player's authoritative mana after the rejection, so a client that deducted the cost in
+	// advance can give it back. Rejected commands are not retried.
+	GameErrorDeployRejected = "deploy_rejected"
+	// GameErrorWarmup is the GameErrorEvent.Code sent for a deploy made before combat starts.
+	// The rest of the event is as for GameErrorDeployRejected.
+	GameErrorWarmup = "warmup"
+	// GameErrorPaused is the GameErrorEvent.Code sent for a deploy made while the game is
+	// paused for a disconnected player. The rest of the event is as for GameErrorDeployRejected.
+	GameErrorPaused = "paused"
+)
+
+// StreamForType returns the stream a message type travels on. It is used to fill in
+// Stream for messages from version 1 peers, which do not set it.
+func StreamForType(msgType string) string {
+	switch msgType {
+	case UDPMsgTypeCommandAck, UDPMsgTypePong:
+		return UDPStreamAck
+	case UDPMsgTypeGameStateUpdate, UDPMsgTypeGameTimer:
+		return UDPStreamState
+	case UDPMsgTypeGameEvent:
+		return UDPStreamEvent
+	default:
+		return UDPStreamCommand
+	}
+}
+
+// IsKnownStream reports whether stream is one of the UDPStream* constants.
+func IsKnownStream(stream string) bool {
+	switch stream {
+	case UDPStreamCommand, UDPStreamAck, UDPStreamState, UDPStreamEvent:
+		return true
+	}
+	return false
+}
+
+// --- Client to Server (C2S) UDP Messages ---
+
+// DeployTroopCommandUDP is sent by a client to deploy a troop.
+type DeployTroopCommandUDP struct {
+	TroopID string `json:"troop_id"` // TroopSpec.ID of the troop to deploy
+	// Lane is the lane to deploy into in two-lane games, models.LaneLeft or
+	// models.LaneRight; empty means the left lane. Classic games ignore it.
+	Lane string `json:"lane,omitempty"`
+}
+
+// PlayerInputUDP is a generic structure for other player inputs.
+// This can be expanded or replaced with more specific input types.
+type PlayerInputUDP struct {
+	InputType string      `json:"input_type"` // e.g., "use_special_ability"
+	Details   interface{} `json:"details"`    // Command-specific details
+}
+
+// PlayerQuitUDP is sent by a client to signal they are quitting the game session.
+// It currently has no additional payload beyond what's in UDPMessage.
+type PlayerQuitUDP struct {
+	// No specific fields needed for now, PlayerToken in UDPMessage is enough
+}
+
+// SurrenderUDP is sent by a client conceding the game. Like PlayerQuitUDP it carries no
+// fields; the PlayerToken in UDPMessage says who surrendered.
+type SurrenderUDP struct{}
+
+// RequestFullStateUDP is sent by a client that suspects its view of the game is wrong, e.g.
+// after losing packets or rejoining the game. The server answers with a GameStateUpdateUDP
+// marked Snapshot, at most once per FullStateRequestInterval.
+type RequestFullStateUDP struct{}
+
+// PingUDP is sent by a client over its game socket to check that the session hears it.
+// The session also learns the socket's address from it before any command is sent.
+// Pings are numbered apart from the player's commands: the session answers them without
+// handling them as commands, so sharing the command Seqs would leave gaps that look like
+// skipped commands (see models.GameRules.MaxSeqGap).
+type PingUDP struct{}
+
+// --- Server to Client (S2C) UDP Messages ---
+
+// PongUDP answers a PingUDP.
+type PongUDP struct {
+	PingSeq uint32 `json:"ping_seq"` // Seq of the ping being answered, among the client's pings
+}
+
+// CommandAckUDP is sent by the server to acknowledge a critical command from the client.
+type CommandAckUDP struct {
+	AckSeq uint32 `json:"ack_seq"` // Sequence number of the client's command being acknowledged
+}
+
+// GameStateUpdateUDP contains the current state of the game.
+// This can be a full snapshot or a delta.
+// For simplicity, starting with a fuller snapshot.
+type GameStateUpdateUDP struct {
+	GameTimeRemainingSeconds int                   `json:"game_time_remaining_seconds"`
+	Player1Mana              int                   `json:"player1_mana"`                        // Deprecated: use PlayerMana; kept for clients older than ProtocolVersionManaByPlayer
+	Player2Mana              int                   `json:"player2_mana"`                        // Deprecated: as Player1Mana
+	PlayerMana               map[string]int        `json:"player_mana,omitempty"`               // map[Username]current mana
+	Towers                   []TowerState          `json:"towers"`                              // All towers from both players
+	ActiveTroops             map[string]TroopState `json:"active_troops"`                       // All active troops from both players, keyed by InstanceID
+	PlayerScores             map[string]int        `json:"player_scores,omitempty"`             // map[Username]towers that player has destroyed so far
+	LastProcessedClientSeq   map[string]uint32     `json:"last_processed_client_seq,omitempty"` // map[PlayerToken]Seq up to which the server has handled every command; commands at or below it need no further resends
+	Final                    bool                  `json:"final,omitempty"`                     // Last update of the game, sent just before the results
+	Warmup                   bool                  `json:"warmup,omitempty"`                    // Combat has not started; deploys are refused
+	StartsIn                 int                   `json:"starts_in,omitempty"`                 // Seconds of countdown left during warm-up; 0 while still waiting for a player
+	SpectatorCount           int                   `json:"spectator_count,omitempty"`           // Spectators watching the game
+	Snapshot                 bool                  `json:"snapshot,omitempty"`                  // Sent to one player in answer to their UDPMsgTypeRequestFullState
+
+	// An update too large for one datagram is split into Parts datagrams sharing an UpdateID.
+	// Part 1 carries everything except troops that did not fit; later parts carry only troops.
+	// Parts is 0 for an update sent whole.
+	UpdateID uint32 `json:"update_id,omitempty"`
+	Part     int    `json:"part,omitempty"`  // 1-based
+	Parts    int    `json:"parts,omitempty"` // Total number of parts
+}
+
+// GameTimerUpdateUDP replaces a GameStateUpdateUDP when nothing but the clock has changed
+// since the last one, e.g. while both players save up mana. Everything else in the last
+// full update still holds.
+type GameTimerUpdateUDP struct {
+	GameTimeRemainingSeconds int `json:"game_time_remaining_seconds"`
+	StartsIn                 int `json:"starts_in,omitempty"` // As in GameStateUpdateUDP
+}
+
+// GameEventUDP is for broadcasting significant one-off events. Receivers decode it with
+// DecodeGameEvent.
+type GameEventUDP struct {
+	EventType string      `json:"event_type"` // One of the GameEvent* constants
+	Details   interface{} `json:"details"`    // The event type's details struct, e.g. TowerDamagedEvent
+}
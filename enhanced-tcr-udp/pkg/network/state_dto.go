@@ -0,0 +1,63 @@
+package network
+
+import "enhanced-tcr-udp/pkg/models"
+
+// TowerState is a tower as sent in GameStateUpdateUDP: only what the client renders.
+// The server keeps the full models.TowerInstance; the wire format does not follow it around.
+type TowerState struct {
+	ID        string `json:"id"`   // Game-specific ID, e.g. "player1_king_tower"
+	Spec      string `json:"spec"` // TowerSpec ID, e.g. "king_tower"
+	Owner     string `json:"owner"`
+	HP        int    `json:"hp"`
+	MaxHP     int    `json:"max_hp"`
+	Destroyed bool   `json:"destroyed,omitempty"`
+	Lane      string `json:"lane,omitempty"` // Lane guarded in two-lane games; empty for the King Tower and in classic games
+}
+
+// TroopState is an active troop as sent in GameStateUpdateUDP, keyed by its instance ID.
+type TroopState struct {
+	Spec  string `json:"spec"` // TroopSpec ID, e.g. "knight"
+	Owner string `json:"owner"`
+	HP    int    `json:"hp"`
+	MaxHP int    `json:"max_hp"`
+	ATK   int    `json:"atk"`
+	// Target is the game-specific ID of the tower the troop last attacked; empty while it
+	// is still advancing. Clients that predate it ignore the field.
+	Target string `json:"target,omitempty"`
+	Shield int    `json:"shield,omitempty"` // Shield points left, if the troop has one
+	// Spawning is set while the troop is still within its spawn delay and cannot attack;
+	// SpawnsInMs is how long it has left.
+	Spawning   bool `json:"spawning,omitempty"`
+	SpawnsInMs int  `json:"spawns_in_ms,omitempty"`
+	// ExpiresInMs is how long a troop with a lifetime has left on the field; 0 for a troop
+	// that stays until defeated.
+	ExpiresInMs int    `json:"expires_in_ms,omitempty"`
+	Lane        string `json:"lane,omitempty"` // Lane deployed into in two-lane games
+}
+
+// NewTowerState converts a server-side tower to its wire form.
+func NewTowerState(t *models.TowerInstance) TowerState {
+	return TowerState{
+		ID:        t.GameSpecificID,
+		Spec:      t.SpecID,
+		Owner:     t.OwnerID,
+		HP:        t.CurrentHP,
+		MaxHP:     t.MaxHP,
+		Destroyed: t.IsDestroyed,
+		Lane:      t.Lane,
+	}
+}
+
+// NewTroopState converts a server-side troop to its wire form.
+func NewTroopState(t *models.ActiveTroop) TroopState {
+	return TroopState{
+		Spec:   t.SpecID,
+		Owner:  t.OwnerID,
+		HP:     t.CurrentHP,
+		MaxHP:  t.MaxHP,
+		ATK:    t.CurrentATK,
+		Target: t.TargetID,
+		Shield: t.ShieldHP,
+		Lane:   t.Lane,
+	}
+}
@@ -0,0 +1,84 @@
+package network
+
+import (
+	"sync"
+	"time"
+)
+
+// Reasons an inbound UDP datagram is dropped before it is handled, as counted by UDPDrops.
+const (
+	DropParseError  = "parse_errors" // Not a UDPMessage
+	DropOversize    = "oversize"     // Filled the read buffer, so possibly truncated
+	DropUnknownType = "unknown_type" // A message type or stream the receiver does not take
+	DropBadSession  = "bad_session"  // Another session's ID, or an unknown or expired token
+)
+
+// DropSampleInterval is how often UDPDrops lets another drop of the same reason be logged
+// after the first.
+const DropSampleInterval = time.Minute
+
+// UDPDropCounts are the inbound datagrams dropped so far, by reason.
+type UDPDropCounts struct {
+	ParseErrors uint64 `json:"parse_errors"`
+	Oversize    uint64 `json:"oversize"`
+	UnknownType uint64 `json:"unknown_type"`
+	BadSession  uint64 `json:"bad_session"`
+}
+
+// Total returns the number of dropped datagrams.
+func (c UDPDropCounts) Total() uint64 {
+	return c.ParseErrors + c.Oversize + c.UnknownType + c.BadSession
+}
+
+// UDPDrops counts the datagrams a UDP reader drops and samples them for its log: the first
+// drop of each reason is logged, then at most one per DropSampleInterval, so a flood of
+// junk cannot flood the log too. It is safe for concurrent use.
+type UDPDrops struct {
+	now func() time.Time // Clock, replaceable for deterministic use
+
+	mu         sync.Mutex
+	counts     UDPDropCounts
+	lastLogged map[string]time.Time
+}
+
+// NewUDPDrops creates a drop counter; now is the clock to sample by, time.Now if nil.
+func NewUDPDrops(now func() time.Time) *UDPDrops {
+	if now == nil {
+		now = time.Now
+	}
+	return &UDPDrops{now: now, lastLogged: make(map[string]time.Time)}
+}
+
+// Drop counts one datagram dropped for reason, one of the Drop* constants. It returns how
+// many have been dropped for that reason so far and whether this drop should be logged.
+func (d *UDPDrops) Drop(reason string) (count uint64, sample bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch reason {
+	case DropParseError:
+		d.counts.ParseErrors++
+		count = d.counts.ParseErrors
+	case DropOversize:
+		d.counts.Oversize++
+		count = d.counts.Oversize
+	case DropUnknownType:
+		d.counts.UnknownType++
+		count = d.counts.UnknownType
+	default: // DropBadSession
+		d.counts.BadSession++
+		count = d.counts.BadSession
+	}
+	now := d.now()
+	if last, logged := d.lastLogged[reason]; logged && now.Sub(last) < DropSampleInterval {
+		return count, false
+	}
+	d.lastLogged[reason] = now
+	return count, true
+}
+
+// Counts returns the drops so far.
+func (d *UDPDrops) Counts() UDPDropCounts {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.counts
+}
@@ -0,0 +1,701 @@
+// Package tcrclient is a client library for the game server, for programs that play or
+// watch the game without the terminal UI: bots, dashboards, tournament tools. It logs in
+// over TCP, queues for a match and then plays it over the match's UDP session, handing
+// every state update and game event to callbacks. The protocol types it speaks are those
+// of the enhanced-tcr-udp/pkg/network package.
+//
+// A bot deploying its cheapest troop whenever it can looks like this:
+//
+//	c, err := tcrclient.Connect(ctx, "localhost:8080")
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//	if _, err := c.Login(ctx, "bot1", "secret"); err != nil {
+//		return err
+//	}
+//	c.OnStateUpdate(func(update network.GameStateUpdateUDP) {
+//		if !update.Warmup && update.PlayerMana["bot1"] >= 3 {
+//			c.Deploy(ctx, "pawn", "")
+//		}
+//	})
+//	if _, err := c.Queue(ctx, tcrclient.QueueOptions{}); err != nil {
+//		return err
+//	}
+//	results, err := c.Results(ctx)
+//
+// A Client plays one match at a time; once Results has returned, Queue may be called again
+// for the next. Its methods are safe for concurrent use.
+//
+// The UDP side of a match is a Session: it numbers and sends commands, resends deploys
+// until they are acknowledged and reassembles split state updates. A program speaking TCP
+// to the server itself, like the terminal client, can play a match through DialSession.
+package tcrclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// Errors returned by the Client.
+var (
+	ErrNotLoggedIn = errors.New("tcrclient: not logged in")
+	ErrInGame      = errors.New("tcrclient: already in a match")
+	ErrNotInGame   = errors.New("tcrclient: not in a match")
+	ErrClosed      = errors.New("tcrclient: client closed")
+	// ErrUnsupported is returned for a request the server's protocol version lacks.
+	ErrUnsupported = errors.New("tcrclient: not supported by the server")
+	// ErrNoActiveGame is returned by Rejoin when the server reported no running game.
+	ErrNoActiveGame = errors.New("tcrclient: no running game to rejoin")
+)
+
+// LoginError is returned by Login when the server refuses the credentials.
+type LoginError struct {
+	Message string // The server's reason
+}
+
+func (e *LoginError) Error() string { return "tcrclient: login refused: " + e.Message }
+
+// MatchSetupError is returned by Queue when the server could not set up a match and did
+// not keep searching.
+type MatchSetupError struct {
+	network.MatchSetupFailed
+}
+
+func (e *MatchSetupError) Error() string { return "tcrclient: match setup failed: " + e.Reason }
+
+// RejoinError is returned by Rejoin when the server refuses to let the player back into
+// the game.
+type RejoinError struct {
+	Message string // The server's reason
+}
+
+func (e *RejoinError) Error() string { return "tcrclient: rejoin refused: " + e.Message }
+
+// SettingsError is returned by UpdateSettings when the server rejects the settings.
+type SettingsError struct {
+	Message string // The server's reason
+}
+
+func (e *SettingsError) Error() string { return "tcrclient: settings rejected: " + e.Message }
+
+// MatchCancelledError is returned by Results when the match was called off before it
+// started, e.g. because the opponent disconnected.
+type MatchCancelledError struct {
+	network.MatchCancelled
+}
+
+func (e *MatchCancelledError) Error() string { return "tcrclient: match cancelled: " + e.Reason }
+
+// QueueOptions picks the match Queue searches for.
+type QueueOptions struct {
+	QueueType string // network.QueueRanked or network.QueueCasual; "" means ranked
+	// PreferredDuration is the game length to ask for, one the server offers; 0 means any.
+	PreferredDuration time.Duration
+	// OnRequeued, if set, is called each time the server fails to set up a match and keeps
+	// searching, e.g. because the opponent it found had gone.
+	OnRequeued func(failure network.MatchSetupFailed)
+}
+
+// Match describes the match Queue found or Rejoin returned to.
+type Match struct {
+	network.MatchFoundResponse
+	// Config is the troops and towers of the game; nil if it did not arrive within the
+	// client's config wait (see SetConfigWait), in which case OnGameConfig receives it.
+	Config *models.GameConfig
+	// State is the whole game as it stood when Rejoin returned to it; nil from Queue and
+	// from servers that send none.
+	State *network.GameStateUpdateUDP
+}
+
+// Client is a connection to the game server. Create one with Connect.
+type Client struct {
+	tcp net.Conn
+	dec *json.Decoder // The only reader of tcp; a match's TCP reader owns it while it runs
+
+	mu         sync.Mutex
+	account    *models.PlayerAccount
+	protocol   int
+	activeGame string // Running game the player is in, from login or a refused Queue
+	pending    []network.GameOverResults
+	onState    func(network.GameStateUpdateUDP)
+	onEvent    func(network.GameEventUDP)
+	onAck      func(uint32)
+	onMatch    func(network.MatchFoundResponse)
+	onConfig   func(*models.GameConfig)
+	trace      func(direction string, data []byte)
+	sessionCfg SessionConfig // What each match's Session starts from; see SetSessionConfig
+	configWait time.Duration // See SetConfigWait
+	game       *game         // The current match, from Queue until its results are in
+	closed     bool
+
+	config     *models.GameConfig // Last fetched by GameConfig, kept for the connection
+	configHash string
+}
+
+// Connect opens a connection to the server's TCP address.
+func Connect(ctx context.Context, addr string) (*Client, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{tcp: conn, dec: json.NewDecoder(conn)}, nil
+}
+
+// Login authenticates the connection and returns the player's account as the server holds
+// it. Results of earlier games the server kept for the player are available from
+// PendingResults afterwards.
+func (c *Client) Login(ctx context.Context, username, password string) (*models.PlayerAccount, error) {
+	stop := c.watch(ctx)
+	resp, pending, err := c.login(username, password)
+	if err = stop(err); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.account = resp.Player.Account()
+	c.protocol = resp.ProtocolVersion
+	c.activeGame = resp.ActiveGameID
+	c.pending = pending
+	return c.account, nil
+}
+
+// login exchanges the login messages.
+func (c *Client) login(username, password string) (network.LoginResponse, []network.GameOverResults, error) {
+	var resp network.LoginResponse
+	request := network.LoginRequest{Username: username, Password: password, ProtocolVersion: network.ProtocolVersion}
+	if err := c.send(request); err != nil {
+		return resp, nil, err
+	}
+	if err := c.decode(&resp); err != nil {
+		return resp, nil, err
+	}
+	if !resp.Success {
+		return resp, nil, &LoginError{Message: resp.Message}
+	}
+	if resp.Player == nil {
+		return resp, nil, errors.New("tcrclient: server accepted the login but sent no profile")
+	}
+	if !resp.HasPendingResults {
+		return resp, nil, nil
+	}
+	var msg struct {
+		Type    string                    `json:"type"`
+		Payload []network.GameOverResults `json:"payload"`
+	}
+	if err := c.decode(&msg); err != nil {
+		return resp, nil, fmt.Errorf("tcrclient: reading pending results: %w", err)
+	}
+	return resp, msg.Payload, nil
+}
+
+// Account returns the logged-in player's account, with the EXP and level of the last
+// game's results applied; nil before Login.
+func (c *Client) Account() *models.PlayerAccount {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.account
+}
+
+// PendingResults returns the results of earlier games the server delivered at login.
+func (c *Client) PendingResults() []network.GameOverResults {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pending
+}
+
+// Protocol returns the protocol version the server speaks, from Login.
+func (c *Client) Protocol() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.protocol
+}
+
+// ActiveGameID returns the running game the player is still in, which Rejoin returns to:
+// the one reported at login, or the one a Queue was refused for. It is "" when there is
+// none.
+func (c *Client) ActiveGameID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.activeGame
+}
+
+// TraceTCP sets the function that sees every TCP message sent to or received from the
+// server, as network.Capture records them. Set it before Login.
+func (c *Client) TraceTCP(fn func(direction string, data []byte)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.trace = fn
+}
+
+// SetSessionConfig sets what the Session of each later match starts from: its resend
+// settings, drop counter, logging and tracing, and the handlers the Client has no callback
+// for (OnMessage, OnTimer, OnGiveUp). The match fills in GameID, PlayerToken and Protocol,
+// and OnState, OnEvent and OnAck are the Client's own callbacks.
+func (c *Client) SetSessionConfig(cfg SessionConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessionCfg = cfg
+}
+
+// SetConfigWait bounds how long Queue and Rejoin wait for the game config that follows a
+// match; without it they return a Match with a nil Config and OnGameConfig receives the
+// config when it comes. 0, the default, waits until it arrives or the match ends.
+func (c *Client) SetConfigWait(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.configWait = d
+}
+
+// UpdateSettings asks the server to change the account settings given (an empty value
+// clears one) and returns the settings now in effect, which Account also reflects. If the
+// server rejects the change it returns a *SettingsError along with the unchanged
+// settings. It may not be called during a match.
+func (c *Client) UpdateSettings(ctx context.Context, settings map[string]string) (map[string]string, error) {
+	if err := c.lobby(network.ProtocolVersionRequests); err != nil {
+		return nil, err
+	}
+	stop := c.watch(ctx)
+	resp, err := c.updateSettings(settings)
+	if err = stop(err); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.account.Settings = resp.Settings
+	c.mu.Unlock()
+	if !resp.Success {
+		return resp.Settings, &SettingsError{Message: resp.Message}
+	}
+	return resp.Settings, nil
+}
+
+// updateSettings exchanges the settings update and its answer.
+func (c *Client) updateSettings(settings map[string]string) (network.UpdateSettingsResponse, error) {
+	request := network.TCPMessage{Type: network.MsgTypeUpdateSettings, Payload: network.UpdateSettingsRequest{Settings: settings}}
+	if err := c.send(request); err != nil {
+		return network.UpdateSettingsResponse{}, err
+	}
+	var reply struct {
+		Type    string                         `json:"type"`
+		Payload network.UpdateSettingsResponse `json:"payload"`
+	}
+	if err := c.decode(&reply); err != nil {
+		return network.UpdateSettingsResponse{}, err
+	}
+	if reply.Type != network.MsgTypeSettingsUpdated {
+		return network.UpdateSettingsResponse{}, fmt.Errorf("tcrclient: unexpected %q reply to a settings update", reply.Type)
+	}
+	return reply.Payload, nil
+}
+
+// lobby checks that the client is logged in, not in a match and talking to a server of
+// at least protocol version minProtocol.
+func (c *Client) lobby(minProtocol int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.closed:
+		return ErrClosed
+	case c.account == nil:
+		return ErrNotLoggedIn
+	case c.game != nil:
+		return ErrInGame
+	case c.protocol < minProtocol:
+		return ErrUnsupported
+	}
+	return nil
+}
+
+// GameConfig returns the troops and towers the server's games are currently played with,
+// for use before queueing; a match's own config is in Match. The config is kept for the
+// connection and only downloaded again when it has changed on the server. It may not be
+// called during a match.
+func (c *Client) GameConfig(ctx context.Context) (*models.GameConfig, error) {
+	if err := c.lobby(network.ProtocolVersionGameConfigRequest); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	known := c.configHash
+	c.mu.Unlock()
+
+	stop := c.watch(ctx)
+	resp, err := c.fetchGameConfig(known)
+	if err = stop(err); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if resp.Unchanged && c.config != nil && resp.Hash == c.configHash {
+		return c.config, nil
+	}
+	if resp.Config == nil {
+		return nil, errors.New("tcrclient: server sent no game config")
+	}
+	_ = resp.Config.Normalize()
+	c.config, c.configHash = resp.Config, resp.Hash
+	return c.config, nil
+}
+
+// fetchGameConfig exchanges the game config request and its answer.
+func (c *Client) fetchGameConfig(knownHash string) (network.GameConfigResponse, error) {
+	request := network.TCPMessage{Type: network.MsgTypeGetGameConfig, Payload: network.GetGameConfigRequest{KnownHash: knownHash}}
+	if err := c.send(request); err != nil {
+		return network.GameConfigResponse{}, err
+	}
+	var reply struct {
+		Type    string                     `json:"type"`
+		Payload network.GameConfigResponse `json:"payload"`
+	}
+	if err := c.decode(&reply); err != nil {
+		return network.GameConfigResponse{}, err
+	}
+	if reply.Type != network.MsgTypeGameConfigResponse {
+		return network.GameConfigResponse{}, fmt.Errorf("tcrclient: unexpected %q reply to a game config request", reply.Type)
+	}
+	if !reply.Payload.Success {
+		return network.GameConfigResponse{}, fmt.Errorf("tcrclient: server sent no game config: %s", reply.Payload.Message)
+	}
+	return reply.Payload, nil
+}
+
+// OnStateUpdate sets the function called with every complete game state update of the
+// current match. Callbacks run one at a time on the client's receiving goroutine, so they
+// should return quickly; they may call Deploy and Quit.
+func (c *Client) OnStateUpdate(fn func(update network.GameStateUpdateUDP)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onState = fn
+}
+
+// OnEvent sets the function called with every game event of the current match, its
+// Details decoded as by network.DecodeGameEvent. It runs as OnStateUpdate's does.
+func (c *Client) OnEvent(fn func(event network.GameEventUDP)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onEvent = fn
+}
+
+// OnAck sets the function called with the Seq of every command the server acknowledges,
+// including repeated ACKs for a command it received more than once. It runs as
+// OnStateUpdate's does.
+func (c *Client) OnAck(fn func(seq uint32)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onAck = fn
+}
+
+// OnMatch sets the function called with each match Queue finds or Rejoin returns to,
+// before its UDP session starts, so a program can set up for the match before its first
+// update arrives.
+func (c *Client) OnMatch(fn func(match network.MatchFoundResponse)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onMatch = fn
+}
+
+// OnGameConfig sets the function called with the game config of the current match when it
+// arrives from the server, including one arriving after Queue or Rejoin gave up waiting.
+// It runs on the client's TCP reading goroutine.
+func (c *Client) OnGameConfig(fn func(cfg *models.GameConfig)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onConfig = fn
+}
+
+// Queue asks the server for a match and waits until one is found and its UDP session is
+// set up. If the server fails to set up a match but keeps searching, Queue keeps waiting.
+// A refusal because the player is still in a running game returns a *MatchSetupError
+// with ActiveGameID set, which Rejoin then returns to. Cancelling ctx while Queue waits
+// leaves the connection unusable; close it.
+func (c *Client) Queue(ctx context.Context, opts QueueOptions) (*Match, error) {
+	if err := c.lobby(0); err != nil {
+		return nil, err
+	}
+	account, protocol := c.Account(), c.Protocol()
+
+	stop := c.watch(ctx)
+	found, err := c.awaitMatch(account.Username, protocol, opts)
+	if err = stop(err); err != nil {
+		var setup *MatchSetupError
+		if errors.As(err, &setup) && setup.ActiveGameID != "" {
+			c.mu.Lock()
+			c.activeGame = setup.ActiveGameID
+			c.mu.Unlock()
+		}
+		return nil, err
+	}
+	return c.begin(ctx, found, protocol)
+}
+
+// Rejoin returns to the running game named by ActiveGameID, e.g. one a lost connection
+// left behind, on a server speaking network.ProtocolVersionReconnect. The server issues a
+// new session token for it; the one the lost client used no longer works. If the server
+// refuses, Rejoin returns a *RejoinError. Either way ActiveGameID is cleared.
+func (c *Client) Rejoin(ctx context.Context) (*Match, error) {
+	if err := c.lobby(network.ProtocolVersionReconnect); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	gameID, protocol := c.activeGame, c.protocol
+	c.activeGame = ""
+	c.mu.Unlock()
+	if gameID == "" {
+		return nil, ErrNoActiveGame
+	}
+
+	stop := c.watch(ctx)
+	resp, err := c.reconnect(gameID)
+	if err = stop(err); err != nil {
+		return nil, err
+	}
+	match, err := c.begin(ctx, resp.Match, protocol)
+	if err != nil {
+		return nil, err
+	}
+	match.State = resp.State
+	return match, nil
+}
+
+// reconnect exchanges the reconnect request for gameID and its answer.
+func (c *Client) reconnect(gameID string) (network.ReconnectResponse, error) {
+	request := network.TCPMessage{Type: network.MsgTypeReconnectRequest, Payload: network.ReconnectRequest{GameID: gameID}}
+	if err := c.send(request); err != nil {
+		return network.ReconnectResponse{}, err
+	}
+	var reply struct {
+		Type    string                    `json:"type"`
+		Payload network.ReconnectResponse `json:"payload"`
+	}
+	if err := c.decode(&reply); err != nil {
+		return network.ReconnectResponse{}, err
+	}
+	if reply.Type != network.MsgTypeReconnectResponse {
+		return network.ReconnectResponse{}, fmt.Errorf("tcrclient: unexpected %q reply to a reconnect request", reply.Type)
+	}
+	if !reply.Payload.Success || reply.Payload.Match == nil {
+		return network.ReconnectResponse{}, &RejoinError{Message: reply.Payload.Message}
+	}
+	return reply.Payload, nil
+}
+
+// begin starts the match found and waits for its game config, as long as the config wait
+// allows.
+func (c *Client) begin(ctx context.Context, found *network.MatchFoundResponse, protocol int) (*Match, error) {
+	c.mu.Lock()
+	onMatch, wait := c.onMatch, c.configWait
+	c.mu.Unlock()
+	if onMatch != nil {
+		onMatch(*found)
+	}
+	g, err := c.startGame(found, protocol)
+	if err != nil {
+		return nil, err
+	}
+	var timeout <-chan time.Time
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-g.configReady:
+	case <-g.done:
+	case <-timeout:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	match := &Match{MatchFoundResponse: *found}
+	match.Config, _ = g.gameConfig()
+	return match, nil
+}
+
+// awaitMatch sends the matchmaking request and reads until a match is found.
+func (c *Client) awaitMatch(username string, protocol int, opts QueueOptions) (*network.MatchFoundResponse, error) {
+	// A server before ProtocolVersionRequests queues the player straight after login
+	if protocol >= network.ProtocolVersionRequests {
+		request := network.TCPMessage{
+			Type: network.MsgTypeMatchmakingRequest,
+			Payload: network.MatchmakingRequest{
+				PlayerID:                 username,
+				QueueType:                opts.QueueType,
+				PreferredDurationSeconds: int(opts.PreferredDuration.Seconds()),
+			},
+		}
+		if err := c.send(request); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		var raw json.RawMessage
+		if err := c.decode(&raw); err != nil {
+			return nil, err
+		}
+		// MatchFoundResponse is sent bare; a setup failure comes wrapped in a TCPMessage
+		var envelope struct {
+			Type    string          `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if json.Unmarshal(raw, &envelope) == nil && envelope.Type == network.MsgTypeMatchSetupFailed {
+			failure, err := network.DecodePayload[network.MatchSetupFailed](envelope.Payload)
+			if err != nil {
+				return nil, err
+			}
+			if failure.Requeued {
+				if opts.OnRequeued != nil {
+					opts.OnRequeued(failure)
+				}
+				continue
+			}
+			return nil, &MatchSetupError{MatchSetupFailed: failure}
+		}
+		var found network.MatchFoundResponse
+		if err := json.Unmarshal(raw, &found); err != nil {
+			return nil, err
+		}
+		return &found, nil
+	}
+}
+
+// Results waits for the current match to end and returns its results, applying the EXP
+// and level to Account. A match called off before it started returns a
+// *MatchCancelledError. Either way the client is then ready to Queue again.
+func (c *Client) Results(ctx context.Context) (network.GameOverResults, error) {
+	c.mu.Lock()
+	g := c.game
+	c.mu.Unlock()
+	if g == nil {
+		return network.GameOverResults{}, ErrNotInGame
+	}
+	select {
+	case <-g.done:
+	case <-ctx.Done():
+		return network.GameOverResults{}, ctx.Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.game == g {
+		c.game = nil
+	}
+	if g.err != nil {
+		return network.GameOverResults{}, g.err
+	}
+	if c.account != nil && (g.results.GameID == "" || g.results.GameID == g.match.GameID) {
+		c.account.EXP = g.results.NewEXP
+		c.account.Level = g.results.NewLevel
+	}
+	return g.results, nil
+}
+
+// Session returns the UDP session of the current match, or nil outside a match. Commands
+// sent through it directly, like a surrender, are numbered with the Client's own.
+func (c *Client) Session() *Session {
+	g, err := c.currentGame()
+	if err != nil {
+		return nil
+	}
+	return g.session
+}
+
+// ReportUDPUnreachable tells the server that the current match's UDP port never answered
+// the attempts pings sent to port, so it calls the match off for both players; Results
+// then returns a *MatchCancelledError. It reports whether the server was told: one
+// speaking a protocol version before network.ProtocolVersionUDPUnreachable does not
+// listen, and the match will not start.
+func (c *Client) ReportUDPUnreachable(ctx context.Context, port, attempts int) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	g, err := c.currentGame()
+	if err != nil {
+		return false, err
+	}
+	if c.Protocol() < network.ProtocolVersionUDPUnreachable {
+		return false, nil
+	}
+	report := network.TCPMessage{
+		Type:    network.MsgTypeUDPUnreachable,
+		Payload: network.UDPUnreachable{GameID: g.match.GameID, Port: port, Attempts: attempts},
+	}
+	if err := c.send(report); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Close closes the connection to the server, ending any match in progress on this side.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	g := c.game
+	c.closed = true
+	c.mu.Unlock()
+	if g != nil {
+		g.stop(ErrClosed) // Before the TCP reader sees the connection close
+	}
+	return c.tcp.Close()
+}
+
+// send writes v to the server as one JSON line.
+func (c *Client) send(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if trace := c.tracer(); trace != nil {
+		trace(network.CaptureSent, data)
+	}
+	_, err = c.tcp.Write(append(data, '\n'))
+	return err
+}
+
+// decode reads the server's next message into v.
+func (c *Client) decode(v interface{}) error {
+	var raw json.RawMessage
+	if err := c.dec.Decode(&raw); err != nil {
+		return err
+	}
+	if trace := c.tracer(); trace != nil {
+		trace(network.CaptureReceived, raw)
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// tracer returns the TraceTCP function.
+func (c *Client) tracer() func(string, []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.trace
+}
+
+// watch makes reads and writes on the TCP connection fail once ctx is done, until the
+// returned function is called. That function takes the error of the guarded operation and
+// returns ctx's error in its place if ctx ended it.
+func (c *Client) watch(ctx context.Context) func(error) error {
+	fired := make(chan struct{})
+	stopAfter := context.AfterFunc(ctx, func() {
+		c.tcp.SetDeadline(time.Unix(1, 0)) // Unblocks any read or write in progress
+		close(fired)
+	})
+	return func(err error) error {
+		if !stopAfter() {
+			<-fired
+			c.tcp.SetDeadline(time.Time{})
+			if err != nil {
+				return ctx.Err()
+			}
+		}
+		return err
+	}
+}
@@ -0,0 +1,171 @@
+package tcrclient_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"enhanced-tcr-udp/pkg/network"
+	"enhanced-tcr-udp/pkg/tcrclient"
+)
+
+// A bot that deploys a pawn whenever it can afford one, for one game.
+func Example() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	c, err := tcrclient.Connect(ctx, "localhost:8080")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Login(ctx, "bot1", "secret"); err != nil {
+		log.Fatal(err)
+	}
+
+	c.OnStateUpdate(func(update network.GameStateUpdateUDP) {
+		if !update.Warmup && update.PlayerMana["bot1"] >= 3 {
+			c.Deploy(ctx, "pawn", "")
+		}
+	})
+	if _, err := c.Queue(ctx, tcrclient.QueueOptions{}); err != nil {
+		log.Fatal(err)
+	}
+	results, err := c.Results(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s, %+d EXP\n", results.Outcome, results.EXPChange)
+}
+
+// Queue waits for an opponent; the Match it returns says who they are and which troops
+// the game is played with.
+func ExampleClient_Queue() {
+	ctx := context.Background()
+	c, err := tcrclient.Connect(ctx, "localhost:8080")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Login(ctx, "bot1", "secret"); err != nil {
+		log.Fatal(err)
+	}
+
+	match, err := c.Queue(ctx, tcrclient.QueueOptions{
+		QueueType:         network.QueueCasual,
+		PreferredDuration: 90 * time.Second,
+	})
+	var setup *tcrclient.MatchSetupError
+	if errors.As(err, &setup) {
+		log.Fatalf("no match: %s", setup.Reason)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Playing %s (level %d) in game %s\n", match.Opponent.Username, match.Opponent.Level, match.GameID)
+	for id, troop := range match.Config.Troops {
+		fmt.Printf("%s costs %d mana\n", id, troop.ManaCost)
+	}
+}
+
+// OnEvent sees every game event with its details decoded; a type switch picks out the
+// ones of interest.
+func ExampleClient_OnEvent() {
+	ctx := context.Background()
+	c, err := tcrclient.Connect(ctx, "localhost:8080")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Login(ctx, "watcher", "secret"); err != nil {
+		log.Fatal(err)
+	}
+
+	c.OnEvent(func(event network.GameEventUDP) {
+		switch details := event.Details.(type) {
+		case network.TowerDestroyedEvent:
+			fmt.Printf("%s lost their %s\n", details.OwnerID, details.TowerSpec)
+		case network.GameErrorEvent:
+			fmt.Printf("command %d failed: %s\n", details.Seq, details.Message)
+		}
+	})
+	if _, err := c.Queue(ctx, tcrclient.QueueOptions{}); err != nil {
+		log.Fatal(err)
+	}
+	c.Results(ctx)
+}
+
+// A match called off before it starts, e.g. because the opponent never connected, ends
+// with a MatchCancelledError instead of results; the client may queue again.
+func ExampleClient_Results() {
+	ctx := context.Background()
+	c, err := tcrclient.Connect(ctx, "localhost:8080")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Login(ctx, "bot1", "secret"); err != nil {
+		log.Fatal(err)
+	}
+
+	for {
+		if _, err := c.Queue(ctx, tcrclient.QueueOptions{}); err != nil {
+			log.Fatal(err)
+		}
+		results, err := c.Results(ctx)
+		var cancelled *tcrclient.MatchCancelledError
+		if errors.As(err, &cancelled) {
+			fmt.Printf("called off (%s); queueing again\n", cancelled.Reason)
+			continue
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s; now level %d with %d EXP\n", results.Outcome, c.Account().Level, c.Account().EXP)
+		return
+	}
+}
+
+// A program that speaks TCP to the server itself can still play the match through a
+// Session, opened from the MatchFoundResponse it received.
+func ExampleDialSession() {
+	var found network.MatchFoundResponse // As received over TCP
+	session, err := tcrclient.DialSession(found.UDPHost, found.UDPPort, tcrclient.SessionConfig{
+		GameID:      found.GameID,
+		PlayerToken: found.PlayerSessionToken,
+		Protocol:    network.ProtocolVersion,
+		OnState: func(update network.GameStateUpdateUDP) {
+			fmt.Printf("%ds left\n", update.GameTimeRemainingSeconds)
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer session.Close()
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go session.Resend(ctx)
+	go session.Listen(ctx)
+	if rtt, err := session.Ping(time.Second); err == nil {
+		fmt.Printf("round trip %v\n", rtt)
+	}
+	session.Deploy("knight", "")
+}
+
+// An adaptive strategy resends after twice the smoothed round trip, but never sooner than
+// the floor.
+func ExampleResendStrategy() {
+	s := tcrclient.NewResendStrategy(tcrclient.ResendConfig{TimeoutMillis: 1000, FloorMillis: 100, Adaptive: true}, nil)
+	fmt.Println("before any round trip:", s.Timeout())
+	for _, rtt := range []time.Duration{80 * time.Millisecond, 160 * time.Millisecond, 20 * time.Millisecond} {
+		s.ObserveRTT(rtt)
+		fmt.Printf("after %v: resend after %v\n", rtt, s.Timeout())
+	}
+	// Output:
+	// before any round trip: 1s
+	// after 80ms: resend after 160ms
+	// after 160ms: resend after 180ms
+	// after 20ms: resend after 162.5ms
+}
@@ -0,0 +1,231 @@
+package tcrclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+
+	"enhanced-tcr-udp/pkg/models"
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// game is one match's UDP session and the goroutines serving it.
+type game struct {
+	client  *Client
+	match   network.MatchFoundResponse
+	session *Session
+	ctx     context.Context // Done once the match is over
+	cancel  context.CancelFunc
+
+	mu          sync.Mutex
+	config      *models.GameConfig
+	configReady chan struct{} // Closed when config is set
+
+	done    chan struct{} // Closed by stop when the match is over
+	once    sync.Once
+	results network.GameOverResults // Set before done is closed
+	err     error                   // Why the match ended without results; set before done is closed
+}
+
+// startGame dials the match's UDP session and starts the goroutines serving it: the TCP
+// reader waiting for the config and the results, the session's listener, and its resender.
+func (c *Client) startGame(found *network.MatchFoundResponse, protocol int) (*game, error) {
+	host := found.UDPHost
+	if host == "" { // The server's host as reached over TCP
+		host, _, _ = net.SplitHostPort(c.tcp.RemoteAddr().String())
+	}
+	c.mu.Lock()
+	cfg := c.sessionCfg
+	c.mu.Unlock()
+	cfg.GameID = found.GameID
+	cfg.PlayerToken = found.PlayerSessionToken
+	cfg.Protocol = protocol
+	cfg.OnState = func(update network.GameStateUpdateUDP) {
+		if fn := c.stateCallback(); fn != nil {
+			fn(update)
+		}
+	}
+	cfg.OnEvent = func(event network.GameEventUDP) {
+		if fn := c.eventCallback(); fn != nil {
+			fn(event)
+		}
+	}
+	cfg.OnAck = func(seq uint32) {
+		if fn := c.ackCallback(); fn != nil {
+			fn(seq)
+		}
+	}
+	session, err := DialSession(host, found.UDPPort, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &game{
+		client:      c,
+		match:       *found,
+		session:     session,
+		configReady: make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	g.ctx, g.cancel = context.WithCancel(context.Background())
+	g.setConfig(found.GameConfig) // Only older servers embed it
+	c.mu.Lock()
+	c.game = g
+	c.mu.Unlock()
+
+	go g.readTCP()
+	go func() {
+		// An error ends only the updates; the results still come over TCP
+		if err := session.Listen(g.ctx); err != nil {
+			session.logf("tcrclient: UDP listener for game %s stopped: %v", found.GameID, err)
+		}
+	}()
+	go session.Resend(g.ctx)
+	session.Send(network.UDPMsgTypePing, network.PingUDP{}) // Tells the session where we are
+	return g, nil
+}
+
+// stop ends the match with err (nil when its results arrived) and closes its UDP session.
+func (g *game) stop(err error) {
+	g.once.Do(func() {
+		g.err = err
+		close(g.done)
+		g.cancel()
+		g.session.Close()
+	})
+}
+
+// setConfig stores the game config, wakes Queue and hands it to OnGameConfig; a nil
+// config is ignored.
+func (g *game) setConfig(cfg *models.GameConfig) {
+	if cfg == nil {
+		return
+	}
+	_ = cfg.Normalize() // An older server's config may lack display names
+	g.mu.Lock()
+	if g.config == nil {
+		close(g.configReady)
+	}
+	g.config = cfg
+	g.mu.Unlock()
+	if fn := g.client.configCallback(); fn != nil {
+		fn(cfg)
+	}
+}
+
+// gameConfig returns the match's game config, if it has arrived.
+func (g *game) gameConfig() (*models.GameConfig, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.config, g.config != nil
+}
+
+// readTCP reads the server's TCP messages during the match until the results or a
+// cancellation arrive, or the connection fails.
+func (g *game) readTCP() {
+	for {
+		var msg struct {
+			Type    string          `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := g.client.decode(&msg); err != nil {
+			g.stop(fmt.Errorf("tcrclient: connection lost during the match: %w", err))
+			return
+		}
+		switch msg.Type {
+		case network.MsgTypeGameConfigData:
+			if data, err := network.DecodePayload[network.GameConfigData](msg.Payload); err == nil {
+				g.setConfig(&data.Config)
+			}
+		case network.MsgTypeGameOverResults:
+			results, err := network.DecodePayload[network.GameOverResults](msg.Payload)
+			if err != nil {
+				g.stop(fmt.Errorf("tcrclient: reading the results: %w", err))
+				return
+			}
+			g.results = results
+			g.stop(nil)
+			return
+		case network.MsgTypeMatchCancelled:
+			cancelled, err := network.DecodePayload[network.MatchCancelled](msg.Payload)
+			if err != nil {
+				cancelled.Reason = err.Error()
+			}
+			g.stop(&MatchCancelledError{MatchCancelled: cancelled})
+			return
+		}
+	}
+}
+
+// currentGame returns the match in progress, or ErrNotInGame.
+func (c *Client) currentGame() (*game, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.game == nil {
+		return nil, ErrNotInGame
+	}
+	select {
+	case <-c.game.done:
+		return nil, ErrNotInGame
+	default:
+	}
+	return c.game, nil
+}
+
+// stateCallback returns the OnStateUpdate callback.
+func (c *Client) stateCallback() func(network.GameStateUpdateUDP) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.onState
+}
+
+// eventCallback returns the OnEvent callback.
+func (c *Client) eventCallback() func(network.GameEventUDP) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.onEvent
+}
+
+// configCallback returns the OnGameConfig callback.
+func (c *Client) configCallback() func(*models.GameConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.onConfig
+}
+
+// ackCallback returns the OnAck callback.
+func (c *Client) ackCallback() func(uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.onAck
+}
+
+// Deploy sends a command deploying troopID, into lane in a two-lane game ("" otherwise),
+// and returns its Seq. Once sent, the command is resent until the server acknowledges it;
+// if the server rejects it, OnEvent receives a network.GameErrorEvent carrying that Seq.
+func (c *Client) Deploy(ctx context.Context, troopID, lane string) (uint32, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	g, err := c.currentGame()
+	if err != nil {
+		return 0, err
+	}
+	return g.session.Deploy(troopID, lane)
+}
+
+// Quit leaves the current match, which the opponent then wins. The results still arrive
+// through Results.
+func (c *Client) Quit(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	g, err := c.currentGame()
+	if err != nil {
+		return err
+	}
+	_, err = g.session.Send(network.UDPMsgTypePlayerQuit, network.PlayerQuitUDP{})
+	return err
+}
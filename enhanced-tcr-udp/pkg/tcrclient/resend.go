@@ -0,0 +1,129 @@
+package tcrclient
+
+import (
+	"time"
+
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// ResendConfig controls how long a Session waits for a command's ACK before resending it
+// and how often it resends before giving up. Zero fields keep their defaults.
+type ResendConfig struct {
+	TimeoutMillis int  `json:"timeout_ms"` // Resend timeout before any round trip is measured (and always, if not adaptive)
+	FloorMillis   int  `json:"floor_ms"`   // Adaptive timeouts never go below this
+	MaxResends    int  `json:"max_resends"`
+	Adaptive      bool `json:"adaptive"` // Derive the timeout from measured round-trip times
+}
+
+// DefaultResendConfig returns the resend settings of a Session configured without any:
+// the protocol's default timeout, adapted to the measured round trip.
+func DefaultResendConfig() ResendConfig {
+	return ResendConfig{
+		TimeoutMillis: int(network.CommandResendTimeout / time.Millisecond),
+		FloorMillis:   int(network.CommandResendTimeoutFloor / time.Millisecond),
+		MaxResends:    network.CommandMaxResends,
+		Adaptive:      true,
+	}
+}
+
+// ResendStrategy decides when an unacknowledged command is due for a resend and when to give
+// up on it. When adaptive, the timeout follows the classic scheme: a smoothed round-trip time
+// SRTT = 7/8 SRTT + 1/8 sample, and a timeout of max(2 x SRTT, floor). Every timeout is capped
+// at network.CommandResendTimeoutCeiling, which the server sizes its duplicate detection from.
+type ResendStrategy struct {
+	now func() time.Time // Clock, replaceable for deterministic use
+
+	initial    time.Duration // Timeout until the first round trip is measured
+	floor      time.Duration
+	maxResends int
+	adaptive   bool
+
+	srtt time.Duration // Smoothed round-trip time; 0 until the first sample
+}
+
+// NewResendStrategy creates a resend strategy from cfg, with out-of-range values clamped.
+// A nil clock means time.Now.
+func NewResendStrategy(cfg ResendConfig, now func() time.Time) *ResendStrategy {
+	if now == nil {
+		now = time.Now
+	}
+	defaults := DefaultResendConfig()
+	if cfg.TimeoutMillis <= 0 {
+		cfg.TimeoutMillis = defaults.TimeoutMillis
+	}
+	if cfg.FloorMillis <= 0 {
+		cfg.FloorMillis = defaults.FloorMillis
+	}
+	if cfg.MaxResends <= 0 {
+		cfg.MaxResends = defaults.MaxResends
+	}
+	if cfg.MaxResends > network.CommandMaxResendsLimit {
+		cfg.MaxResends = network.CommandMaxResendsLimit
+	}
+	return &ResendStrategy{
+		now:        now,
+		initial:    clampResendTimeout(time.Duration(cfg.TimeoutMillis) * time.Millisecond),
+		floor:      clampResendTimeout(time.Duration(cfg.FloorMillis) * time.Millisecond),
+		maxResends: cfg.MaxResends,
+		adaptive:   cfg.Adaptive,
+	}
+}
+
+// clampResendTimeout caps d at network.CommandResendTimeoutCeiling.
+func clampResendTimeout(d time.Duration) time.Duration {
+	if d > network.CommandResendTimeoutCeiling {
+		return network.CommandResendTimeoutCeiling
+	}
+	return d
+}
+
+// Now returns the strategy's current time.
+func (s *ResendStrategy) Now() time.Time {
+	return s.now()
+}
+
+// Timeout returns how long to wait for an ACK before resending.
+func (s *ResendStrategy) Timeout() time.Duration {
+	if !s.adaptive || s.srtt == 0 {
+		return s.initial
+	}
+	timeout := 2 * s.srtt
+	if timeout < s.floor {
+		timeout = s.floor
+	}
+	return clampResendTimeout(timeout)
+}
+
+// MaxResends returns how many times a command is resent before it is given up on.
+func (s *ResendStrategy) MaxResends() int {
+	return s.maxResends
+}
+
+// ObserveRTT feeds a measured round-trip time into the adaptive timeout. Only samples from
+// commands that were never resent should be given, since an ACK to a resent command cannot
+// be matched to the copy it answers.
+func (s *ResendStrategy) ObserveRTT(rtt time.Duration) {
+	if rtt <= 0 {
+		return
+	}
+	if s.srtt == 0 {
+		s.srtt = rtt
+		return
+	}
+	s.srtt = (7*s.srtt + rtt) / 8
+}
+
+// SmoothedRTT returns the smoothed round-trip time, or 0 if none has been measured.
+func (s *ResendStrategy) SmoothedRTT() time.Duration {
+	return s.srtt
+}
+
+// Due reports whether a command last sent at sentAt should be resent (or given up on) now.
+func (s *ResendStrategy) Due(sentAt time.Time) bool {
+	return s.now().Sub(sentAt) > s.Timeout()
+}
+
+// GiveUp reports whether a command already resent retries times has used up its resends.
+func (s *ResendStrategy) GiveUp(retries int) bool {
+	return retries >= s.maxResends
+}
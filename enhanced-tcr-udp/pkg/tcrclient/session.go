@@ -0,0 +1,529 @@
+package tcrclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"enhanced-tcr-udp/pkg/network"
+)
+
+// udpReadPollInterval bounds how long Listen blocks before checking whether its context
+// is done.
+const udpReadPollInterval = 500 * time.Millisecond
+
+// SessionConfig describes the UDP session of a match for DialSession. The handlers are
+// called one at a time on the goroutine running Listen, except OnGiveUp, which
+// ProcessResends calls; any of them may be nil.
+type SessionConfig struct {
+	GameID      string // The match's session, from network.MatchFoundResponse
+	PlayerToken string // This player's token in it, from network.MatchFoundResponse
+	// Protocol is the server's protocol version from login; the session pings the server
+	// every network.HeartbeatInterval from network.ProtocolVersionHeartbeat on.
+	Protocol int
+	Resend   ResendConfig
+	Clock    func() time.Time // Times resends and the round trips ACKs measure; nil means time.Now
+	// Drops counts the datagrams dropped before handling; nil gives the session a counter
+	// of its own. Logf, if set, reports them, one line per reason and sample interval.
+	Drops *network.UDPDrops
+	Logf  func(format string, args ...interface{})
+	// Trace sees every datagram sent or received, before a received one is checked.
+	Trace func(direction string, data []byte)
+
+	OnMessage func(msg network.UDPMessage, data []byte) // Every datagram of the match for this player, before it is handled
+	OnState   func(update network.GameStateUpdateUDP)   // Complete state updates; a split one once all its parts are in
+	OnTimer   func(timer network.GameTimerUpdateUDP)
+	OnEvent   func(event network.GameEventUDP) // Details decoded as by network.DecodeGameEvent
+	OnAck     func(seq uint32)                 // Every ACK, including repeats for a command the server received twice
+	OnGiveUp  func(seq uint32)                 // A deploy that used up its resends
+}
+
+// Session is the UDP side of one match: it numbers and sends this player's commands,
+// resends deploys until they are acknowledged, and hands the server's datagrams for the
+// match to the handlers of its SessionConfig. Client plays matches with one; a program
+// managing its own TCP connection can use one directly. Its methods are safe for
+// concurrent use.
+type Session struct {
+	cfg   SessionConfig
+	conn  *net.UDPConn
+	drops *network.UDPDrops
+
+	mu               sync.Mutex
+	nextSeq          uint32
+	nextPing         uint32              // Pings are numbered apart from commands; see network.PingUDP
+	unacked          map[uint32]*command // Command-stream Seq -> deploy awaiting its ACK
+	resend           *ResendStrategy
+	pings            map[uint32]chan struct{} // Ping Seq -> closed when its pong arrives
+	lastStateRequest time.Time                // When RequestFullState last asked the server
+	partsID          uint32                   // Update the parts in stateParts belong to
+	stateParts       map[int]network.GameStateUpdateUDP
+}
+
+// command is a deploy awaiting its ACK.
+type command struct {
+	data          []byte
+	sentAt        time.Time
+	resends       int
+	busyDeferrals int // Times the server answered "busy" for it
+}
+
+// DialSession opens the match's UDP socket to the session at host and port. Nothing is
+// received until Listen runs, and nothing resent until Resend runs.
+func DialSession(host string, port int, cfg SessionConfig) (*Session, error) {
+	raddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, fmt.Sprint(port)))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	drops := cfg.Drops
+	if drops == nil {
+		drops = network.NewUDPDrops(nil)
+	}
+	return &Session{
+		cfg:      cfg,
+		conn:     conn,
+		drops:    drops,
+		nextSeq:  1,
+		nextPing: 1,
+		unacked:  make(map[uint32]*command),
+		resend:   NewResendStrategy(cfg.Resend, cfg.Clock),
+		pings:    make(map[uint32]chan struct{}),
+	}, nil
+}
+
+// LocalAddr returns the address of the session's socket.
+func (s *Session) LocalAddr() *net.UDPAddr {
+	return s.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// RemoteAddr returns the address of the server's session.
+func (s *Session) RemoteAddr() *net.UDPAddr {
+	return s.conn.RemoteAddr().(*net.UDPAddr)
+}
+
+// Close closes the session's socket, ending Listen.
+func (s *Session) Close() error {
+	return s.conn.Close()
+}
+
+// Listen receives the match's datagrams and hands them to the handlers until ctx is done
+// or the session is closed, when it returns nil. It returns the error if the socket fails.
+func (s *Session) Listen(ctx context.Context) error {
+	buffer := make([]byte, network.MaxUDPDatagramSize)
+	for ctx.Err() == nil {
+		// Wake up periodically to notice ctx ending even when the server is silent
+		s.conn.SetReadDeadline(time.Now().Add(udpReadPollInterval))
+		n, err := s.conn.Read(buffer)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.receive(buffer[:n], n == len(buffer))
+	}
+	return nil
+}
+
+// receive checks one datagram and hands it on. A full buffer may hold a truncated
+// datagram; a partial JSON document would only look like corruption.
+func (s *Session) receive(data []byte, truncated bool) {
+	if s.cfg.Trace != nil {
+		s.cfg.Trace(network.CaptureReceived, data)
+	}
+	if truncated {
+		if n, sample := s.drops.Drop(network.DropOversize); sample {
+			s.logf("Discarding UDP datagram that filled the %d-byte read buffer (%d truncated so far)", len(data), n)
+		}
+		return
+	}
+	var msg network.UDPMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		if n, sample := s.drops.Drop(network.DropParseError); sample {
+			s.logf("Error unmarshalling UDP message: %v (%d malformed so far). Raw: %s", err, n, data)
+		}
+		return
+	}
+	if !s.accept(msg) {
+		return
+	}
+	if s.cfg.OnMessage != nil {
+		s.cfg.OnMessage(msg, data)
+	}
+	if msg.Stream == "" { // Server speaking protocol version 1
+		msg.Stream = network.StreamForType(msg.Type)
+	}
+	if !network.IsKnownStream(msg.Stream) || msg.Stream == network.UDPStreamCommand {
+		if n, sample := s.drops.Drop(network.DropUnknownType); sample {
+			s.logf("Dropping UDP message type %s on unexpected stream %q (%d unknown so far)", msg.Type, msg.Stream, n)
+		}
+		return
+	}
+	s.handle(msg)
+}
+
+// accept reports whether a datagram belongs to this match and is addressed to this player.
+// Datagrams for another session, e.g. stray ones from a previous game, and those targeted at
+// another player's token are dropped and counted; an empty token means a broadcast.
+func (s *Session) accept(msg network.UDPMessage) bool {
+	if msg.SessionID != s.cfg.GameID {
+		if n, sample := s.drops.Drop(network.DropBadSession); sample {
+			s.logf("Dropping UDP %s message for session %s (current game %s). Dropped so far: %d", msg.Type, msg.SessionID, s.cfg.GameID, n)
+		}
+		return false
+	}
+	if msg.PlayerToken != "" && msg.PlayerToken != s.cfg.PlayerToken {
+		if n, sample := s.drops.Drop(network.DropBadSession); sample {
+			s.logf("Dropping UDP %s message for another player's token in game %s. Dropped so far: %d", msg.Type, msg.SessionID, n)
+		}
+		return false
+	}
+	return true
+}
+
+// handle acts on one datagram of the match.
+func (s *Session) handle(msg network.UDPMessage) {
+	switch msg.Type {
+	case network.UDPMsgTypeGameStateUpdate:
+		update, err := network.DecodePayload[network.GameStateUpdateUDP](msg.Payload)
+		if err != nil {
+			return
+		}
+		if update.Parts > 1 { // Handed on only once all of its parts have arrived
+			var complete bool
+			if update, complete = s.assemble(update); !complete {
+				return
+			}
+		}
+		// The watermark settles any deploy whose own ACK was lost
+		s.settleUpTo(update.LastProcessedClientSeq[s.cfg.PlayerToken])
+		if s.cfg.OnState != nil {
+			s.cfg.OnState(update)
+		}
+	case network.UDPMsgTypeGameTimer:
+		if timer, err := network.DecodePayload[network.GameTimerUpdateUDP](msg.Payload); err == nil && s.cfg.OnTimer != nil {
+			s.cfg.OnTimer(timer)
+		}
+	case network.UDPMsgTypeCommandAck:
+		ack, err := network.DecodePayload[network.CommandAckUDP](msg.Payload)
+		if err != nil {
+			return
+		}
+		s.acknowledge(ack.AckSeq)
+		if s.cfg.OnAck != nil {
+			s.cfg.OnAck(ack.AckSeq)
+		}
+	case network.UDPMsgTypePong:
+		if pong, err := network.DecodePayload[network.PongUDP](msg.Payload); err == nil {
+			s.resolvePing(pong.PingSeq)
+		}
+	case network.UDPMsgTypeGameEvent:
+		event, err := network.DecodeGameEvent(msg.Payload)
+		if err != nil {
+			return
+		}
+		if failure, ok := event.Details.(network.GameErrorEvent); ok && failure.Seq != 0 {
+			switch {
+			case failure.Code == network.GameErrorServerBusy:
+				s.deferForBusy(failure.Seq)
+			case !failure.Retry:
+				s.settle(failure.Seq) // Rejected; resending would not help
+			}
+		}
+		if s.cfg.OnEvent != nil {
+			s.cfg.OnEvent(event)
+		}
+	default:
+		if n, sample := s.drops.Drop(network.DropUnknownType); sample {
+			s.logf("Received unknown UDP message type: %s (%d unknown so far)", msg.Type, n)
+		}
+	}
+}
+
+// assemble collects the parts of a split state update. It returns the merged update and
+// true once every part has arrived. A part of a different update discards whatever was
+// collected so far; the next update supersedes an incomplete one anyway.
+func (s *Session) assemble(part network.GameStateUpdateUDP) (network.GameStateUpdateUDP, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if part.UpdateID != s.partsID || s.stateParts == nil {
+		s.partsID = part.UpdateID
+		s.stateParts = make(map[int]network.GameStateUpdateUDP, part.Parts)
+	}
+	s.stateParts[part.Part] = part
+	merged, ok := s.stateParts[1]
+	if len(s.stateParts) < part.Parts || !ok {
+		return network.GameStateUpdateUDP{}, false
+	}
+	troops := make(map[string]network.TroopState)
+	for _, p := range s.stateParts {
+		for id, troop := range p.ActiveTroops {
+			troops[id] = troop
+		}
+	}
+	merged.ActiveTroops = troops
+	s.stateParts = nil
+	return merged, true
+}
+
+// acknowledge stops resending the deploy seq, which the server ACKed. Only an ACK to a
+// deploy sent once measures the round trip: one to a resent deploy cannot be matched to
+// the copy it answers (Karn's rule).
+func (s *Session) acknowledge(seq uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cmd, ok := s.unacked[seq]
+	if !ok {
+		return
+	}
+	delete(s.unacked, seq)
+	if cmd.resends == 0 && cmd.busyDeferrals == 0 {
+		s.resend.ObserveRTT(s.resend.Now().Sub(cmd.sentAt))
+	}
+}
+
+// settle stops resending the deploy seq.
+func (s *Session) settle(seq uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.unacked, seq)
+}
+
+// settleUpTo stops resending every deploy up to the server's watermark. The server has
+// handled every command up to it, so a deploy lost below a later one that arrived is
+// still resent.
+func (s *Session) settleUpTo(watermark uint32) {
+	if watermark == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for seq := range s.unacked {
+		if seq <= watermark {
+			delete(s.unacked, seq)
+		}
+	}
+}
+
+// deferForBusy handles a server_busy answer to deploy seq: overload is not loss, so the
+// next resend waits an extra network.CommandBusyBackoff and does not count against the
+// resends, up to network.CommandMaxBusyDeferrals times per deploy.
+func (s *Session) deferForBusy(seq uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cmd, ok := s.unacked[seq]
+	if !ok || cmd.busyDeferrals >= network.CommandMaxBusyDeferrals {
+		return
+	}
+	cmd.busyDeferrals++
+	if cmd.resends > 0 {
+		cmd.resends--
+	}
+	cmd.sentAt = s.resend.Now().Add(network.CommandBusyBackoff)
+}
+
+// Pending reports whether the deploy seq is still awaiting its ACK, and so will be resent.
+func (s *Session) Pending(seq uint32) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.unacked[seq]
+	return ok
+}
+
+// Resend resends deploys that are due until ctx is done, checking every
+// network.CommandResendCheckInterval, and sends the heartbeats the server's protocol
+// version asks for.
+func (s *Session) Resend(ctx context.Context) {
+	ticker := time.NewTicker(network.CommandResendCheckInterval)
+	defer ticker.Stop()
+	var lastHeartbeat time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.ProcessResends()
+			if s.cfg.Protocol >= network.ProtocolVersionHeartbeat && now.Sub(lastHeartbeat) >= network.HeartbeatInterval {
+				s.Send(network.UDPMsgTypePing, network.PingUDP{})
+				lastHeartbeat = now
+			}
+		}
+	}
+}
+
+// ProcessResends resends each deploy whose ACK is overdue and gives up on those that have
+// used up their resends, calling OnGiveUp for each. It returns the Seqs given up on.
+// Resend calls it periodically; it is exported for programs driving their own timing.
+func (s *Session) ProcessResends() []uint32 {
+	var failed []uint32
+	s.mu.Lock()
+	for seq, cmd := range s.unacked {
+		if !s.resend.Due(cmd.sentAt) {
+			continue
+		}
+		if s.resend.GiveUp(cmd.resends) {
+			delete(s.unacked, seq)
+			failed = append(failed, seq)
+			continue
+		}
+		if err := s.write(cmd.data); err != nil {
+			continue // Not counted; tried again on the next pass
+		}
+		cmd.sentAt, cmd.resends = s.resend.Now(), cmd.resends+1
+	}
+	s.mu.Unlock()
+
+	if s.cfg.OnGiveUp != nil {
+		for _, seq := range failed {
+			s.cfg.OnGiveUp(seq)
+		}
+	}
+	return failed
+}
+
+// Deploy sends a command deploying troopID, into lane in a two-lane game ("" otherwise),
+// and returns its Seq. Once sent, the command is resent until the server acknowledges it
+// or rejects it; a rejection arrives as a network.GameErrorEvent carrying the Seq.
+func (s *Session) Deploy(troopID, lane string) (uint32, error) {
+	seq, data, err := s.encode(network.UDPMsgTypeDeployTroop, network.DeployTroopCommandUDP{TroopID: troopID, Lane: lane})
+	if err != nil {
+		return 0, err
+	}
+	// Tracked before sending, so an ACK that beats the write's return finds it
+	s.mu.Lock()
+	s.unacked[seq] = &command{data: data, sentAt: s.resend.Now()}
+	s.mu.Unlock()
+	if err := s.write(data); err != nil {
+		s.settle(seq)
+		return 0, err
+	}
+	return seq, nil
+}
+
+// Send sends a command of msgType that is not acknowledged, such as a quit or a
+// surrender, and returns its Seq.
+func (s *Session) Send(msgType string, payload interface{}) (uint32, error) {
+	seq, data, err := s.encode(msgType, payload)
+	if err != nil {
+		return 0, err
+	}
+	return seq, s.write(data)
+}
+
+// RequestFullState asks the server for a full state update at once, for when this
+// player's view of the game may be wrong: after lost datagrams, or on rejoining a game.
+// The server answers at most once per network.FullStateRequestInterval, so a request within
+// that time of the last one is not sent and RequestFullState returns false.
+func (s *Session) RequestFullState() (bool, error) {
+	if s.cfg.Protocol < network.ProtocolVersionFullState {
+		return false, ErrUnsupported
+	}
+	s.mu.Lock()
+	now := time.Now()
+	tooSoon := now.Sub(s.lastStateRequest) < network.FullStateRequestInterval
+	if !tooSoon {
+		s.lastStateRequest = now
+	}
+	s.mu.Unlock()
+	if tooSoon {
+		return false, nil
+	}
+	if _, err := s.Send(network.UDPMsgTypeRequestFullState, network.RequestFullStateUDP{}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Ping pings the session and waits up to timeout for the pong, returning the round-trip
+// time, which also feeds the adaptive resend timeout. Listen must be running to receive
+// the pong.
+func (s *Session) Ping(timeout time.Duration) (time.Duration, error) {
+	seq, data, err := s.encode(network.UDPMsgTypePing, network.PingUDP{})
+	if err != nil {
+		return 0, err
+	}
+	pong := make(chan struct{})
+	s.mu.Lock()
+	s.pings[seq] = pong
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pings, seq)
+		s.mu.Unlock()
+	}()
+
+	sentAt := time.Now()
+	if err := s.write(data); err != nil {
+		return 0, fmt.Errorf("failed to send ping: %w", err)
+	}
+	select {
+	case <-pong:
+		rtt := time.Since(sentAt)
+		s.mu.Lock()
+		s.resend.ObserveRTT(rtt)
+		s.mu.Unlock()
+		return rtt, nil
+	case <-time.After(timeout):
+		return 0, fmt.Errorf("no pong from %s within %v", s.conn.RemoteAddr(), timeout)
+	}
+}
+
+// resolvePing wakes the Ping waiting for the pong to ping seq, if any.
+func (s *Session) resolvePing(seq uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pong, ok := s.pings[seq]; ok {
+		close(pong)
+		delete(s.pings, seq)
+	}
+}
+
+// encode numbers a command of msgType on the command stream and returns its Seq and
+// encoding. Pings are numbered separately from the other commands.
+func (s *Session) encode(msgType string, payload interface{}) (uint32, []byte, error) {
+	s.mu.Lock()
+	next := &s.nextSeq
+	if msgType == network.UDPMsgTypePing {
+		next = &s.nextPing
+	}
+	seq := *next
+	*next++
+	s.mu.Unlock()
+	data, err := json.Marshal(network.UDPMessage{
+		Stream:      network.UDPStreamCommand,
+		Seq:         seq,
+		Timestamp:   time.Now(),
+		SessionID:   s.cfg.GameID,
+		PlayerToken: s.cfg.PlayerToken,
+		Type:        msgType,
+		Payload:     payload,
+	})
+	return seq, data, err
+}
+
+// write sends one encoded datagram to the server.
+func (s *Session) write(data []byte) error {
+	if s.cfg.Trace != nil {
+		s.cfg.Trace(network.CaptureSent, data)
+	}
+	_, err := s.conn.Write(data)
+	return err
+}
+
+// logf reports through the configured Logf, if any.
+func (s *Session) logf(format string, args ...interface{}) {
+	if s.cfg.Logf != nil {
+		s.cfg.Logf(format, args...)
+	}
+}
@@ -0,0 +1,275 @@
+package tcrclient
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"enhanced-tcr-udp/internal/testutil"
+	"enhanced-tcr-udp/pkg/network"
+)
+
+const (
+	testGameID = "game-1"
+	testToken  = "token-alice"
+)
+
+// resendGame is alice's session of game testGameID against a FakePeer that acknowledges
+// every deploy it does not drop. The session's resend timing runs on clock.
+type resendGame struct {
+	session *Session
+	peer    *testutil.FakePeer
+	clock   *testutil.Clock
+
+	mu      sync.Mutex
+	givenUp []uint32 // Seqs passed to OnGiveUp
+}
+
+func newResendGame(t *testing.T, cfg ResendConfig) *resendGame {
+	t.Helper()
+	g := &resendGame{clock: testutil.NewClock(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))}
+	peer, err := testutil.NewFakePeer(testutil.FakePeerOptions{Now: g.clock.Now, Sleep: g.clock.Sleep})
+	if err != nil {
+		t.Fatalf("NewFakePeer: %v", err)
+	}
+	t.Cleanup(func() { peer.Close() })
+	peer.OnType(network.UDPMsgTypeDeployTroop, func(msg network.UDPMessage) []network.UDPMessage {
+		return []network.UDPMessage{{
+			Type:        network.UDPMsgTypeCommandAck,
+			SessionID:   msg.SessionID,
+			PlayerToken: msg.PlayerToken,
+			Payload:     network.CommandAckUDP{AckSeq: msg.Seq},
+		}}
+	})
+	g.peer = peer
+
+	g.session, err = DialSession("127.0.0.1", peer.Addr().Port, SessionConfig{
+		GameID:      testGameID,
+		PlayerToken: testToken,
+		Resend:      cfg,
+		Clock:       g.clock.Now,
+		OnGiveUp: func(seq uint32) {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			g.givenUp = append(g.givenUp, seq)
+		},
+	})
+	if err != nil {
+		t.Fatalf("DialSession: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.session.Listen(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		g.session.Close() // Ends Listen without waiting out its read deadline
+		<-done
+	})
+	return g
+}
+
+// unacked returns how often deploy seq has been resent and whether it is still
+// unacknowledged.
+func (g *resendGame) unacked(seq uint32) (resends int, pending bool) {
+	g.session.mu.Lock()
+	defer g.session.mu.Unlock()
+	cmd, ok := g.session.unacked[seq]
+	if !ok {
+		return 0, false
+	}
+	return cmd.resends, true
+}
+
+// waitForAck waits until the session has taken deploy seq off its unacknowledged list.
+func (g *resendGame) waitForAck(t *testing.T, seq uint32) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for g.session.Pending(seq) {
+		if time.Now().After(deadline) {
+			t.Fatalf("deploy %d was never acknowledged", seq)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDeployResentUntilAcked(t *testing.T) {
+	g := newResendGame(t, ResendConfig{TimeoutMillis: 400, MaxResends: 3})
+	g.peer.DropFirst(1) // The first copy is lost
+
+	if _, err := g.session.Deploy("pawn", ""); err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if _, err := g.peer.WaitFor(1, 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Not due until the timeout has passed
+	g.clock.Advance(400 * time.Millisecond)
+	g.session.ProcessResends()
+	if resends, ok := g.unacked(1); !ok || resends != 0 {
+		t.Fatalf("deploy 1 after exactly the timeout: pending %v, resends %d; want pending, not resent", ok, resends)
+	}
+
+	g.clock.Advance(time.Millisecond)
+	if failed := g.session.ProcessResends(); len(failed) != 0 {
+		t.Fatalf("ProcessResends gave up on %v after one timeout", failed)
+	}
+	received, err := g.peer.WaitFor(2, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, resent := received[0], received[1]
+	if !first.Dropped || resent.Dropped {
+		t.Fatalf("the peer dropped %v and %v, want only the first copy", first.Dropped, resent.Dropped)
+	}
+	if resent.Msg.Seq != 1 || resent.Msg.Type != network.UDPMsgTypeDeployTroop {
+		t.Errorf("resent %s Seq %d, want the deploy with Seq 1", resent.Msg.Type, resent.Msg.Seq)
+	}
+	if want := first.At.Add(401 * time.Millisecond); !resent.At.Equal(want) {
+		t.Errorf("resent at %v, want %v", resent.At, want)
+	}
+
+	g.waitForAck(t, 1)
+	g.session.mu.Lock()
+	defer g.session.mu.Unlock()
+	if srtt := g.session.resend.SmoothedRTT(); srtt != 0 {
+		t.Errorf("SmoothedRTT() = %v after an ACK to a resent deploy, want no sample", srtt)
+	}
+}
+
+func TestDeployGivenUpAfterMaxResends(t *testing.T) {
+	g := newResendGame(t, ResendConfig{TimeoutMillis: 400, MaxResends: 2})
+	g.peer.DropFirst(10) // The server is unreachable
+
+	if _, err := g.session.Deploy("pawn", ""); err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	for resend := 1; resend <= 2; resend++ {
+		g.clock.Advance(time.Second)
+		if failed := g.session.ProcessResends(); len(failed) != 0 {
+			t.Fatalf("gave up on %v at resend %d of 2", failed, resend)
+		}
+	}
+	if _, err := g.peer.WaitFor(3, 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	g.clock.Advance(time.Second)
+	failed := g.session.ProcessResends()
+	if len(failed) != 1 || failed[0] != 1 {
+		t.Errorf("ProcessResends() = %v once the resends ran out, want [1]", failed)
+	}
+	if g.session.Pending(1) {
+		t.Error("deploy 1 is still pending after the session gave up on it")
+	}
+	g.mu.Lock()
+	if !reflect.DeepEqual(g.givenUp, []uint32{1}) {
+		t.Errorf("OnGiveUp called with %v, want [1]", g.givenUp)
+	}
+	g.mu.Unlock()
+	if got := len(g.peer.Received()); got != 3 {
+		t.Errorf("the peer received %d copies, want the original and 2 resends", got)
+	}
+}
+
+func TestAckMeasuresRoundTrip(t *testing.T) {
+	g := newResendGame(t, ResendConfig{TimeoutMillis: 1000, FloorMillis: 50, Adaptive: true})
+	g.peer.Delay(80 * time.Millisecond) // Passes on the shared clock, so the round trip is exact
+
+	if _, err := g.session.Deploy("pawn", ""); err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	g.waitForAck(t, 1)
+	g.session.mu.Lock()
+	defer g.session.mu.Unlock()
+	if srtt := g.session.resend.SmoothedRTT(); srtt != 80*time.Millisecond {
+		t.Errorf("SmoothedRTT() = %v, want the 80ms the ACK took", srtt)
+	}
+	if timeout := g.session.resend.Timeout(); timeout != 160*time.Millisecond {
+		t.Errorf("Timeout() = %v, want twice the round trip", timeout)
+	}
+}
+
+// offlineSession is alice's session of game testGameID without a socket, for feeding
+// datagrams to handle directly.
+func offlineSession(cfg SessionConfig) *Session {
+	cfg.GameID, cfg.PlayerToken = testGameID, testToken
+	return &Session{
+		cfg:     cfg,
+		drops:   network.NewUDPDrops(nil),
+		unacked: make(map[uint32]*command),
+		resend:  NewResendStrategy(cfg.Resend, nil),
+	}
+}
+
+func TestSessionAccept(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  network.UDPMessage
+		want bool
+	}{
+		{"other session", network.UDPMessage{SessionID: "stale-game"}, false},
+		{"other token", network.UDPMessage{SessionID: testGameID, PlayerToken: "token-mallory"}, false},
+		{"own token", network.UDPMessage{SessionID: testGameID, PlayerToken: testToken}, true},
+		{"broadcast", network.UDPMessage{SessionID: testGameID}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := offlineSession(SessionConfig{})
+			if got := s.accept(tt.msg); got != tt.want {
+				t.Errorf("accept() = %v, want %v", got, tt.want)
+			}
+			wantDrops := uint64(1)
+			if tt.want {
+				wantDrops = 0
+			}
+			if drops := s.drops.Counts(); drops.BadSession != wantDrops {
+				t.Errorf("BadSession drops = %d, want %d", drops.BadSession, wantDrops)
+			}
+		})
+	}
+}
+
+// A split state update reaches OnState once, whole, when its last part arrives; a part
+// of an update that never completes is dropped, and the watermark settles deploys.
+func TestSessionAssemblesSplitState(t *testing.T) {
+	var updates []network.GameStateUpdateUDP
+	s := offlineSession(SessionConfig{OnState: func(update network.GameStateUpdateUDP) {
+		updates = append(updates, update)
+	}})
+	s.unacked[1] = &command{}
+	s.unacked[2] = &command{}
+	part := func(updateID uint32, part int, troops ...string) network.UDPMessage {
+		update := network.GameStateUpdateUDP{UpdateID: updateID, Part: part, Parts: 2, ActiveTroops: map[string]network.TroopState{}}
+		if part == 1 {
+			update.GameTimeRemainingSeconds = 42
+			update.LastProcessedClientSeq = map[string]uint32{testToken: 1}
+		}
+		for _, id := range troops {
+			update.ActiveTroops[id] = network.TroopState{Spec: "pawn"}
+		}
+		return network.UDPMessage{Type: network.UDPMsgTypeGameStateUpdate, SessionID: testGameID, Payload: update}
+	}
+
+	s.handle(part(6, 1, "t1")) // Superseded before its second part arrives
+	s.handle(part(7, 2, "t3"))
+	if len(updates) != 0 {
+		t.Fatalf("OnState called with %d updates before any was complete", len(updates))
+	}
+	s.handle(part(7, 1, "t1", "t2"))
+
+	if len(updates) != 1 {
+		t.Fatalf("OnState called %d times, want once for update 7", len(updates))
+	}
+	got := updates[0]
+	if got.GameTimeRemainingSeconds != 42 || len(got.ActiveTroops) != 3 {
+		t.Errorf("merged update has %ds left and troops %v, want part 1's fields and t1, t2, t3", got.GameTimeRemainingSeconds, got.ActiveTroops)
+	}
+	if s.Pending(1) || !s.Pending(2) {
+		t.Errorf("pending after watermark 1: deploy 1 %v, deploy 2 %v; want only deploy 2", s.Pending(1), s.Pending(2))
+	}
+}